@@ -0,0 +1,136 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"tmsu/common/log"
+	"tmsu/storage"
+)
+
+var ApplyCommand = Command{
+	Name:     "apply",
+	Synopsis: "Apply the tags listed in a manifest",
+	Usages:   []string{"tmsu apply MANIFEST"},
+	Description: `Reconciles the tags of the files listed in MANIFEST so that each file is tagged with exactly the tags listed against it, applying and removing tags as necessary.
+
+MANIFEST is a tab-separated file where each line is a path followed by a tab and a comma-separated list of TAG or TAG=VALUE. Blank lines and lines starting with '#' are ignored. Relative paths are resolved against the working directory. A MANIFEST of '-' reads the manifest from standard input.
+
+Implied tags need not be listed: a tag that is implied by other tags on the same line is not explicitly applied.`,
+	Examples: []string{"$ cat tags.tsv\nmountain.jpg\tphoto,landscape,country=france\nriver.jpg\tphoto\n$ tmsu apply tags.tsv",
+		"$ printf 'river.jpg\tphoto,water\n' | tmsu apply -"},
+	Exec: applyExec,
+}
+
+func applyExec(store *storage.Storage, options Options, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("a single manifest must be specified")
+	}
+
+	if args[0] == "-" {
+		return applyManifest(store, os.Stdin)
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("could not open manifest: %v", err)
+	}
+	defer file.Close()
+
+	return applyManifest(store, file)
+}
+
+// unexported
+
+func applyManifest(store *storage.Storage, reader io.Reader) error {
+	wereErrors := false
+
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || line[0] == '#' {
+			continue
+		}
+
+		path, tagNames := parseManifestLine(line)
+
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not get absolute path: %v", path, err)
+		}
+
+		if _, err := os.Lstat(absPath); err != nil {
+			log.Warnf("line %v: %v: could not stat file: %v", lineNumber, path, err)
+			wereErrors = true
+			continue
+		}
+
+		currentTagNames, _, err := editableTagNames(store, absPath)
+		if err != nil {
+			return err
+		}
+
+		if err := reconcileTags(store, absPath, currentTagNames, tagNames); err != nil {
+			if err != errBlank {
+				return err
+			}
+
+			wereErrors = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("could not read manifest: %v", err)
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+func parseManifestLine(line string) (string, []string) {
+	parts := strings.SplitN(line, "\t", 2)
+	path := parts[0]
+
+	tagNames := make([]string, 0, 10)
+	if len(parts) > 1 {
+		fields := strings.FieldsFunc(parts[1], func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+
+		for _, tagName := range fields {
+			if !containsTag(tagNames, tagName) {
+				tagNames = append(tagNames, tagName)
+			}
+		}
+	}
+
+	sort.Strings(tagNames)
+
+	return path, tagNames
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestApplyReconcilesTags(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/a", "a"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	if err := createFile("/tmp/tmsu/b", "b"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/b")
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "apple", "banana"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/b", "apple"}); err != nil {
+		test.Fatal(err)
+	}
+
+	manifest := "# comment\n/tmp/tmsu/a\tbanana,year=2015\n/tmp/tmsu/b\t\n"
+
+	// test
+
+	if err := applyManifest(store, strings.NewReader(manifest)); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	files, err := store.Files()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Path() != "/tmp/tmsu/a" {
+		test.Fatalf("Expected only '/tmp/tmsu/a' to remain tagged.")
+	}
+
+	tagNames, err := tagNamesForFile(store, files[0].Id, true, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(tagNames) != 2 || tagNames[0] != "banana" || tagNames[1] != "year=2015" {
+		test.Fatalf("Unexpected tags: %v", tagNames)
+	}
+}
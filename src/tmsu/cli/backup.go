@@ -0,0 +1,122 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"tmsu/common/log"
+	"tmsu/storage"
+)
+
+const defaultBackupCount = 5
+
+var BackupCommand = Command{
+	Name:     "backup",
+	Synopsis: "Take a snapshot of the database",
+	Usages:   []string{"tmsu backup [OPTION]... [PATH]"},
+	Description: `Writes a snapshot of the database using the SQLite online backup API, which is safe to use whilst the database is in use.
+
+If PATH is specified the snapshot is written there. Otherwise the snapshot is written alongside the database as DB.bak.1, with the existing backups shifted to DB.bak.2, DB.bak.3 and so on. Only the newest COUNT backups are kept (default 5).
+
+Use the 'restore' subcommand to roll the database back to a snapshot.`,
+	Examples: []string{"$ tmsu backup",
+		"$ tmsu backup --keep 10",
+		"$ tmsu backup /mnt/backups/tmsu.db"},
+	Options: Options{{"--keep", "-k", "the number of rotated backups to keep", true, ""}},
+	Exec:    backupExec,
+}
+
+func backupExec(store *storage.Storage, options Options, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	if len(args) == 1 {
+		if err := store.Backup(args[0]); err != nil {
+			return fmt.Errorf("could not back up database to '%v': %v", args[0], err)
+		}
+
+		return nil
+	}
+
+	keep := defaultBackupCount
+	if options.HasOption("--keep") {
+		var err error
+		keep, err = strconv.Atoi(options.Get("--keep").Argument)
+		if err != nil || keep < 1 {
+			return fmt.Errorf("invalid number of backups to keep '%v'", options.Get("--keep").Argument)
+		}
+	}
+
+	if err := rotateBackups(store.Db.Path, keep); err != nil {
+		return fmt.Errorf("could not rotate backups: %v", err)
+	}
+
+	path := backupPath(store.Db.Path, 1)
+	if err := store.Backup(path); err != nil {
+		return fmt.Errorf("could not back up database to '%v': %v", path, err)
+	}
+
+	return nil
+}
+
+// unexported
+
+func backupPath(databasePath string, number int) string {
+	return fmt.Sprintf("%v.bak.%v", databasePath, number)
+}
+
+// Shifts the existing backups along by one to make way for a new backup,
+// discarding any that would then exceed the number to keep.
+func rotateBackups(databasePath string, keep int) error {
+	for number := keep; ; number++ {
+		path := backupPath(databasePath, number)
+
+		if _, err := os.Stat(path); err != nil {
+			if os.IsNotExist(err) {
+				break
+			}
+			return err
+		}
+
+		log.Infof(2, "removing old backup '%v'", path)
+
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	for number := keep - 1; number >= 1; number-- {
+		path := backupPath(databasePath, number)
+
+		if _, err := os.Stat(path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+
+		if err := os.Rename(path, backupPath(databasePath, number+1)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"os"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestBackupAndRestore(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+	defer os.Remove(backupPath(databasePath, 1))
+	defer os.Remove(backupPath(databasePath, 2))
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/a", "a"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "apple"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := store.Commit(); err != nil {
+		test.Fatal(err)
+	}
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := BackupCommand.Exec(store, Options{}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+	if err := BackupCommand.Exec(store, Options{}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "banana"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := store.Commit(); err != nil {
+		test.Fatal(err)
+	}
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := RestoreCommand.Exec(store, Options{}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	if _, err := os.Stat(backupPath(databasePath, 2)); err != nil {
+		test.Fatalf("Backup was not rotated: %v", err)
+	}
+
+	file, err := store.FileByPath("/tmp/tmsu/a")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagNames, err := tagNamesForFile(store, file.Id, true, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if len(tagNames) != 1 || tagNames[0] != "apple" {
+		test.Fatalf("Unexpected tags: %v", tagNames)
+	}
+}
+
+func TestFailedRestoreKeepsEarlierChanges(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+
+	// tagging would commit the transaction to run the hooks
+	if _, err := store.AddTag("apple"); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := RestoreCommand.Exec(store, Options{}, []string{"/tmp/tmsu/missing.db"}); err == nil {
+		test.Fatal("Expected restore from a missing snapshot to fail.")
+	}
+
+	// validate
+
+	if err := store.Rollback(); err != nil {
+		test.Fatal(err)
+	}
+
+	tag, err := store.TagByName("apple")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if tag == nil {
+		test.Fatal("The earlier change was discarded.")
+	}
+}
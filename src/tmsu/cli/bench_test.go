@@ -0,0 +1,272 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"tmsu/storage"
+	"tmsu/vfs"
+)
+
+// The sizes, in files, of the synthetic databases benchmarked against. The
+// largest is skipped when benchmarking with -short.
+var benchmarkSizes = []struct {
+	name      string
+	fileCount int
+}{
+	{"10k", 10000},
+	{"100k", 100000},
+	{"1M", 1000000},
+}
+
+// The number of tags and values in each synthetic database. File n is given
+// tag 't<k>' if n is a multiple of k+1 and, for even k, the value
+// 'v<n mod syntheticValueCount>'.
+const syntheticTagCount = 20
+const syntheticValueCount = 50
+
+// The paths of the synthetic databases generated so far, by file count. They
+// are generated once per run and removed by TestMain.
+var syntheticDatabases = make(map[int]string)
+
+func TestMain(tests *testing.M) {
+	code := tests.Run()
+
+	for _, path := range syntheticDatabases {
+		os.Remove(path)
+	}
+
+	os.Exit(code)
+}
+
+func BenchmarkTagging(benchmark *testing.B) {
+	eachBenchmarkSize(benchmark, func(benchmark *testing.B, store *storage.Storage) {
+		dir := filepath.Join(os.TempDir(), "tmsu_bench")
+		defer os.RemoveAll(dir)
+
+		paths := make([]string, 100)
+		for index := range paths {
+			paths[index] = filepath.Join(dir, fmt.Sprintf("file%v", index))
+			if err := createFile(paths[index], paths[index]); err != nil {
+				benchmark.Fatal(err)
+			}
+		}
+
+		if err := redirectStreams(); err != nil {
+			benchmark.Fatal(err)
+		}
+		defer restoreStreams()
+
+		if err := store.Begin(); err != nil {
+			benchmark.Fatal(err)
+		}
+		defer store.Rollback()
+
+		benchmark.ResetTimer()
+
+		for iteration := 0; iteration < benchmark.N; iteration++ {
+			args := []string{paths[iteration%len(paths)], fmt.Sprintf("bench=%v", iteration)}
+			if err := TagCommand.Exec(store, Options{}, args); err != nil {
+				benchmark.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkQuery(benchmark *testing.B) {
+	eachBenchmarkSize(benchmark, func(benchmark *testing.B, store *storage.Storage) {
+		if err := redirectStreams(); err != nil {
+			benchmark.Fatal(err)
+		}
+		defer restoreStreams()
+
+		benchmark.ResetTimer()
+
+		for iteration := 0; iteration < benchmark.N; iteration++ {
+			if err := FilesCommand.Exec(store, Options{}, []string{"t3 and t5 and not t7"}); err != nil {
+				benchmark.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkQueryValue(benchmark *testing.B) {
+	eachBenchmarkSize(benchmark, func(benchmark *testing.B, store *storage.Storage) {
+		if err := redirectStreams(); err != nil {
+			benchmark.Fatal(err)
+		}
+		defer restoreStreams()
+
+		benchmark.ResetTimer()
+
+		for iteration := 0; iteration < benchmark.N; iteration++ {
+			if err := FilesCommand.Exec(store, Options{}, []string{"t2 < v10 or t4 = v20"}); err != nil {
+				benchmark.Fatal(err)
+			}
+		}
+	})
+}
+
+// Compares the retrieval of the files beneath a directory with the LIKE
+// comparison of file directories that it replaced.
+func BenchmarkFilesByDirectory(benchmark *testing.B) {
+	eachBenchmarkSize(benchmark, func(benchmark *testing.B, store *storage.Storage) {
+		benchmark.Run("range", func(benchmark *testing.B) {
+			for iteration := 0; iteration < benchmark.N; iteration++ {
+				if _, err := store.FilesByDirectory("/bench/d5"); err != nil {
+					benchmark.Fatal(err)
+				}
+			}
+		})
+
+		benchmark.Run("like", func(benchmark *testing.B) {
+			sql := `SELECT f.id, d.path, f.name, f.fingerprint, f.mod_time, f.size, f.is_dir
+			        FROM file f
+			        INNER JOIN directory d ON d.id = f.directory_id
+			        WHERE d.path = ? OR d.path LIKE ?
+			        ORDER BY d.path || '/' || f.name`
+
+			for iteration := 0; iteration < benchmark.N; iteration++ {
+				rows, err := store.Db.ExecQuery(sql, "/bench/d5", "/bench/d5/%")
+				if err != nil {
+					benchmark.Fatal(err)
+				}
+				for rows.Next() {
+				}
+				rows.Close()
+			}
+		})
+	})
+}
+
+func BenchmarkVfsReaddir(benchmark *testing.B) {
+	eachBenchmarkSize(benchmark, func(benchmark *testing.B, store *storage.Storage) {
+		tree := vfs.NewTree(store)
+
+		benchmark.ResetTimer()
+
+		for iteration := 0; iteration < benchmark.N; iteration++ {
+			if _, err := tree.List([]string{"tags", "t3", "t5"}); err != nil {
+				benchmark.Fatal(err)
+			}
+		}
+	})
+}
+
+// unexported
+
+// Runs the benchmark as a sub-benchmark against a synthetic database of each
+// size.
+func eachBenchmarkSize(benchmark *testing.B, run func(*testing.B, *storage.Storage)) {
+	for _, size := range benchmarkSizes {
+		benchmark.Run(size.name, func(benchmark *testing.B) {
+			if testing.Short() && size.fileCount > 100000 {
+				benchmark.Skip("skipping large database in short mode")
+			}
+
+			databasePath, err := syntheticDatabase(size.fileCount)
+			if err != nil {
+				benchmark.Fatal(err)
+			}
+
+			store, err := storage.OpenAt(databasePath)
+			if err != nil {
+				benchmark.Fatal(err)
+			}
+			defer store.Close()
+
+			run(benchmark, store)
+		})
+	}
+}
+
+// Retrieves the path of a synthetic database of the specified number of files,
+// generating it if it has not yet been. The rows are generated in SQL as
+// adding a million files through the storage layer would dwarf the benchmarks
+// themselves.
+func syntheticDatabase(fileCount int) (string, error) {
+	if path, ok := syntheticDatabases[fileCount]; ok {
+		return path, nil
+	}
+
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("tmsu_bench_%v.db", fileCount))
+	os.Remove(path)
+
+	store, err := storage.OpenAt(path)
+	if err != nil {
+		return "", err
+	}
+	defer store.Close()
+
+	syntheticDatabases[fileCount] = path
+
+	if err := store.Begin(); err != nil {
+		return "", err
+	}
+
+	for index := 0; index < syntheticTagCount; index++ {
+		if _, err := store.AddTag(fmt.Sprintf("t%v", index)); err != nil {
+			return "", err
+		}
+	}
+
+	for index := 0; index < syntheticValueCount; index++ {
+		if _, err := store.AddValue(fmt.Sprintf("v%v", index)); err != nil {
+			return "", err
+		}
+	}
+
+	sql := `INSERT INTO directory (path)
+            WITH RECURSIVE seq(n) AS (SELECT 0 UNION ALL SELECT n + 1 FROM seq WHERE n < 999)
+            SELECT '/bench/d' || n
+            FROM seq`
+
+	if _, err := store.Db.Exec(sql); err != nil {
+		return "", err
+	}
+
+	sql = `INSERT INTO file (directory_id, name, fingerprint, mod_time, size, is_dir)
+           WITH RECURSIVE seq(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM seq WHERE n < ?)
+           SELECT (SELECT id FROM directory WHERE path = '/bench/d' || (n % 1000)), 'f' || n, 'fp' || n, datetime('now'), n, 0
+           FROM seq`
+
+	if _, err := store.Db.Exec(sql, fileCount); err != nil {
+		return "", err
+	}
+
+	sql = `INSERT INTO file_tag (file_id, tag_id, value_id)
+           SELECT f.id, t.id, CASE WHEN substr(t.name, 2) % 2 = 0
+                                   THEN (SELECT v.id FROM value v WHERE v.name = 'v' || (f.id % ?))
+                                   ELSE 0
+                              END
+           FROM file f, tag t
+           WHERE f.id % (substr(t.name, 2) + 1) = 0`
+
+	if _, err := store.Db.Exec(sql, syntheticValueCount); err != nil {
+		return "", err
+	}
+
+	if err := store.Commit(); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
@@ -0,0 +1,330 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"tmsu/common/filesystem"
+	"tmsu/common/log"
+	"tmsu/common/text"
+	"tmsu/query"
+	"tmsu/storage"
+)
+
+var BrowseCommand = Command{
+	Name:     "browse",
+	Synopsis: "Interactively browse and tag the files in a directory",
+	Usages:   []string{"tmsu browse [DIRECTORY]"},
+	Description: `Lists the contents of DIRECTORY (or the working directory) alongside their tags and then reads commands from standard input so that files can be tagged and untagged interactively.
+
+The following commands are recognised, where ITEMS is a list of item numbers from the listing such as '3', '1,4', '2-6' or '*' for all listed items:
+
+  ls                      List the items again
+  cd DIRECTORY            Browse another directory
+  filter [QUERY]          List only items matching QUERY (or clear the filter)
+  tag ITEMS TAG[=VALUE]...    Apply tags to the items
+  untag ITEMS TAG[=VALUE]...  Remove tags from the items
+  help                    Show the commands
+  quit                    Finish browsing
+
+Changes are committed to the database as they are made.`,
+	Examples: []string{"$ tmsu browse ~/photos\n  1  beach.jpg    holiday\n  2  mountain.jpg\n  3  sunset.jpg   holiday\nbrowse> tag 1-3 year=2015\nbrowse> filter not holiday\n  2  mountain.jpg  year=2015\nbrowse> quit"},
+	Exec:     browseExec,
+}
+
+func browseExec(store *storage.Storage, options Options, args []string) error {
+	dir := "."
+	switch len(args) {
+	case 0:
+	case 1:
+		dir = args[0]
+	default:
+		return fmt.Errorf("only one directory may be specified")
+	}
+
+	return browse(store, dir, os.Stdin)
+}
+
+// unexported
+
+type browseItem struct {
+	path  string
+	name  string
+	isDir bool
+}
+
+type browser struct {
+	store      *storage.Storage
+	dir        string
+	filter     query.Expression
+	filterText string
+	items      []browseItem
+}
+
+func browse(store *storage.Storage, dir string, input io.Reader) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("%v: could not get absolute path: %v", dir, err)
+	}
+
+	b := &browser{store: store, dir: absDir}
+	if err := b.list(); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(input)
+	for {
+		fmt.Print("browse> ")
+
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line == "" && err == io.EOF {
+			fmt.Println()
+			return nil
+		}
+
+		words := text.Tokenize(strings.TrimSpace(line))
+		if len(words) == 0 {
+			continue
+		}
+
+		quit, cmdErr := b.command(words[0], words[1:])
+		if cmdErr != nil && cmdErr != errBlank {
+			log.Warn(cmdErr.Error())
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+func (b *browser) command(name string, args []string) (bool, error) {
+	switch name {
+	case "ls", "l":
+		return false, b.list()
+	case "cd":
+		if len(args) != 1 {
+			return false, fmt.Errorf("directory must be specified")
+		}
+
+		dir := args[0]
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(b.dir, dir)
+		}
+
+		stat, err := os.Stat(dir)
+		if err != nil {
+			return false, fmt.Errorf("%v: could not stat: %v", dir, err)
+		}
+		if !stat.IsDir() {
+			return false, fmt.Errorf("%v: not a directory", dir)
+		}
+
+		b.dir = filepath.Clean(dir)
+		return false, b.list()
+	case "filter", "f":
+		if len(args) == 0 {
+			b.filter = nil
+			b.filterText = ""
+		} else {
+			queryText := strings.Join(args, " ")
+
+			expression, err := b.store.ParseQuery(queryText)
+			if err != nil {
+				return false, querySyntaxError{err}
+			}
+
+			b.filter = expression
+			b.filterText = queryText
+		}
+
+		return false, b.list()
+	case "tag", "t", "untag", "u":
+		if len(args) < 2 {
+			return false, fmt.Errorf("items and tags must be specified")
+		}
+
+		paths, err := b.selection(args[0])
+		if err != nil {
+			return false, err
+		}
+
+		if name == "tag" || name == "t" {
+			var policy filesystem.SymlinkPolicy
+			policy, err = symlinkPolicy(b.store, nil)
+			if err == nil {
+				err = tagPaths(b.store, args[1:], paths, false, false, false, policy)
+			}
+		} else {
+			err = untagPaths(b.store, paths, args[1:], false, false, false)
+		}
+
+		if commitErr := b.checkpoint(); commitErr != nil {
+			return true, commitErr
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		return false, b.list()
+	case "help", "?":
+		fmt.Println("ls | cd DIRECTORY | filter [QUERY] | tag ITEMS TAG[=VALUE]... | untag ITEMS TAG[=VALUE]... | quit")
+		return false, nil
+	case "quit", "q", "exit":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown command '%v': try 'help'", name)
+	}
+}
+
+// Lists the items in the current directory that match the filter.
+func (b *browser) list() error {
+	entries, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		return fmt.Errorf("%v: could not read directory: %v", b.dir, err)
+	}
+
+	var matches map[string]bool
+	if b.filter != nil {
+		files, err := b.store.QueryFiles(b.filter, b.dir, false)
+		if err != nil {
+			return fmt.Errorf("could not query files: %v", err)
+		}
+
+		matches = make(map[string]bool, len(files))
+		for _, file := range files {
+			matches[file.Path()] = true
+		}
+	}
+
+	b.items = make([]browseItem, 0, len(entries))
+	for _, entry := range entries {
+		path := filepath.Join(b.dir, entry.Name())
+
+		if matches != nil && !matches[path] {
+			continue
+		}
+
+		name := entry.Name()
+		if entry.IsDir() {
+			name += string(filepath.Separator)
+		}
+
+		b.items = append(b.items, browseItem{path, name, entry.IsDir()})
+	}
+
+	header := b.dir
+	if b.filterText != "" {
+		header += " [" + b.filterText + "]"
+	}
+	fmt.Println(header)
+
+	width := 0
+	for _, item := range b.items {
+		if len(item.name) > width {
+			width = len(item.name)
+		}
+	}
+
+	for index, item := range b.items {
+		tagNames, err := b.tagNames(item.path)
+		if err != nil {
+			return err
+		}
+
+		line := fmt.Sprintf("%3v  %-*v  %v", index+1, width, item.name, strings.Join(tagNames, " "))
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+
+	return nil
+}
+
+func (b *browser) tagNames(path string) ([]string, error) {
+	file, err := b.store.FileByPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("%v: could not retrieve file: %v", path, err)
+	}
+	if file == nil {
+		return []string{}, nil
+	}
+
+	return tagNamesForFile(b.store, file.Id, false, false)
+}
+
+// Resolves an item selection such as '1,3-5' or '*' to the item paths.
+func (b *browser) selection(text string) ([]string, error) {
+	if text == "*" {
+		paths := make([]string, len(b.items))
+		for index, item := range b.items {
+			paths[index] = item.path
+		}
+
+		return paths, nil
+	}
+
+	paths := make([]string, 0, 10)
+	for _, part := range strings.Split(text, ",") {
+		from, to := part, part
+		if index := strings.Index(part, "-"); index > 0 {
+			from, to = part[:index], part[index+1:]
+		}
+
+		first, err := strconv.Atoi(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid item selection '%v'", text)
+		}
+
+		last, err := strconv.Atoi(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid item selection '%v'", text)
+		}
+
+		if first < 1 || last > len(b.items) || first > last {
+			return nil, fmt.Errorf("item selection '%v' is out of range", text)
+		}
+
+		for number := first; number <= last; number++ {
+			paths = append(paths, b.items[number-1].path)
+		}
+	}
+
+	return paths, nil
+}
+
+// Commits the changes made so far so that they survive the session being interrupted.
+func (b *browser) checkpoint() error {
+	if err := b.store.Commit(); err != nil {
+		return fmt.Errorf("could not commit changes: %v", err)
+	}
+
+	if err := b.store.Begin(); err != nil {
+		return fmt.Errorf("could not begin transaction: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestBrowseTagAndFilter(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/browse/a", "a"); err != nil {
+		test.Fatal(err)
+	}
+	if err := createFile("/tmp/tmsu/browse/b", "b"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll("/tmp/tmsu/browse")
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	input := strings.NewReader("tag 1,2 apple\nuntag 2 apple\nfilter apple\nquit\n")
+	if err := browse(store, "/tmp/tmsu/browse", input); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := store.Commit(); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, `/tmp/tmsu/browse
+  1  a
+  2  b
+browse> /tmp/tmsu/browse
+  1  a  apple
+  2  b  apple
+browse> /tmp/tmsu/browse
+  1  a  apple
+  2  b
+browse> /tmp/tmsu/browse [apple]
+  1  a  apple
+browse> `, string(bytes))
+
+	files, err := store.Files()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Path() != "/tmp/tmsu/browse/a" {
+		test.Fatalf("Expected only '/tmp/tmsu/browse/a' to be tagged.")
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"tmsu/common/log"
+	_path "tmsu/common/path"
+	"tmsu/entities"
+	"tmsu/storage"
+)
+
+// Where a database is nested within the directory tree of another, the
+// 'cascade' setting of the nearest database determines whether the ancestor
+// databases are also consulted:
+//
+//   none      only the nearest database is used (default)
+//   fallback  the ancestor databases are used, nearest first, where the
+//             nearer databases have no results
+//   union     the results from all of the databases are combined
+const (
+	cascadeNone     = "none"
+	cascadeFallback = "fallback"
+	cascadeUnion    = "union"
+)
+
+func cascadeMode(store *storage.Storage) (string, error) {
+	mode, err := store.SettingAsString("cascade")
+	if err != nil {
+		return "", fmt.Errorf("could not retrieve setting 'cascade': %v", err)
+	}
+
+	switch mode {
+	case cascadeNone, cascadeFallback, cascadeUnion:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf("setting 'cascade' has an invalid value '%v': expected 'none', 'fallback' or 'union'", mode)
+}
+
+// Finds the databases in the ancestors of the root directory of the store,
+// nearest first.
+func ancestorDatabases(store *storage.Storage) []string {
+	paths := make([]string, 0, 2)
+
+	path := store.RootPath
+	for !_path.IsRoot(path) {
+		path = filepath.Dir(path)
+
+		dbPath := filepath.Join(path, ".tmsu", "db")
+		if _, err := os.Stat(dbPath); err == nil {
+			paths = append(paths, dbPath)
+		}
+	}
+
+	return paths
+}
+
+// Runs the retrieval against the store and, depending upon the store's
+// 'cascade' setting, against the databases in its ancestor directories.
+// Files are combined by path.
+func cascadeFiles(store *storage.Storage, retrieve func(*storage.Storage) (entities.Files, error)) (entities.Files, error) {
+	files, err := retrieve(store)
+	if err != nil {
+		return nil, err
+	}
+
+	mode, err := cascadeMode(store)
+	if err != nil {
+		return nil, err
+	}
+	if mode == cascadeNone {
+		return files, nil
+	}
+
+	for _, dbPath := range ancestorDatabases(store) {
+		if mode == cascadeFallback && len(files) > 0 {
+			break
+		}
+
+		log.Infof(2, "cascading to database '%v'", dbPath)
+
+		ancestorFiles, err := retrieveFrom(dbPath, retrieve)
+		if err != nil {
+			return nil, err
+		}
+
+		files = mergeFiles(files, ancestorFiles)
+	}
+
+	return files, nil
+}
+
+func retrieveFrom(dbPath string, retrieve func(*storage.Storage) (entities.Files, error)) (entities.Files, error) {
+	ancestor, err := storage.OpenAt(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not open database '%v': %v", dbPath, err)
+	}
+	defer ancestor.Close()
+
+	files, err := retrieve(ancestor)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", dbPath, err)
+	}
+
+	return files, nil
+}
+
+func mergeFiles(files, additional entities.Files) entities.Files {
+	paths := make(map[string]bool, len(files))
+	for _, file := range files {
+		paths[file.Path()] = true
+	}
+
+	for _, file := range additional {
+		if !paths[file.Path()] {
+			files = append(files, file)
+			paths[file.Path()] = true
+		}
+	}
+
+	return files
+}
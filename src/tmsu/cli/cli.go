@@ -19,12 +19,19 @@ package cli
 
 import (
     "bufio"
+    "context"
+    "errors"
     "fmt"
     "io"
 	"os"
+	"os/signal"
 	"os/user"
 	"path/filepath"
+	"syscall"
+	"time"
+	"tmsu/common/config"
 	"tmsu/common/log"
+	_path "tmsu/common/path"
 	"tmsu/common/text"
 	"tmsu/storage"
 )
@@ -32,10 +39,19 @@ import (
 func Run() {
 	helpCommands = commands
 
+	for _, arg := range os.Args[1:] {
+		if arg == "--" {
+			break
+		}
+		if arg == "--porcelain" {
+			log.Porcelain = true
+		}
+	}
+
 	parser := NewOptionParser(globalOptions, commands)
 	commandName, options, arguments, err := parser.Parse(os.Args[1:]...)
 	if err != nil {
-		log.Fatal(err)
+		exit(usageError{err})
 	}
 
 	switch {
@@ -47,6 +63,23 @@ func Run() {
 
 	log.Verbosity = options.Count("--verbose") + 1
 
+	configuration, err = config.Load()
+	if err != nil {
+		log.Fatalf("could not read configuration: %v", err)
+	}
+
+	if !options.HasOption("--color") && configuration["color"] != "" {
+		options = append(options, Option{"--color", "", "", true, configuration["color"]})
+	}
+
+	host, request, err := remoteRequestFor(commandName, options, arguments)
+	if err != nil {
+		exit(usageError{err})
+	}
+	if host != nil {
+		exit(runRemote(host, request))
+	}
+
     var databasePath string
     switch {
     case options.HasOption("--database"):
@@ -56,17 +89,26 @@ func Run() {
 	default:
         databasePath, err = findDatabase()
         if err != nil {
-            log.Fatalf("could not find database: %v", err)
+            exit(noDatabaseError{fmt.Errorf("could not find database: %v", err)})
         }
     }
 
     store, err := storage.OpenAt(databasePath)
     if err != nil {
-        log.Fatalf("could not open storage: %v", err)
+        exit(noDatabaseError{fmt.Errorf("could not open storage: %v", err)})
     }
+    store.Config = configuration
+
+    ctx := interruptContext()
+    store = store.WithContext(ctx)
 
-    if err := store.Begin(); err != nil {
-        log.Fatalf("could not begin transaction: %v", err)
+    command := findCommand(commands, commandName)
+    transacted := command == nil || !command.NoTransaction
+
+    if transacted {
+        if err := store.Begin(); err != nil {
+            log.Fatalf("could not begin transaction: %v", err)
+        }
     }
 
 	if commandName == "-" {
@@ -75,28 +117,70 @@ func Run() {
         err = processCommand(store, commandName, options, arguments)
     }
 
-    if err := store.Commit(); err != nil {
-        log.Fatalf("could not commit transaction: %v", err)
+    if ctx.Err() != nil {
+        if transacted {
+            store.Rollback()
+        }
+        exit(errInterrupted)
     }
 
-    store.Close()
-
-    if err != nil {
-        if err != errBlank {
-            log.Warn(err.Error())
+    if transacted {
+        if err := store.Commit(); err != nil {
+            log.Fatalf("could not commit transaction: %v", err)
         }
+    }
 
-        os.Exit(1)
+    if commandName != "vacuum" {
+        if err := autoVacuum(store); err != nil {
+            log.Warn(err)
+        }
     }
+
+    store.Close()
+
+    exit(err)
 }
 
 // unexported
 
+// The settings from the user's configuration file.
+var configuration = config.Settings{}
+
+var errInterrupted = errors.New("interrupted: the changes made since the last checkpoint were discarded")
+
+// How long a command has, once interrupted, to abandon its work before the
+// process exits regardless.
+const interruptGracePeriod = 2 * time.Second
+
+// Creates the context to which the storage is bound. It is cancelled when the
+// process is interrupted or terminated, abandoning the current statement so
+// that the open transaction can be rolled back. Commands that wait on something other
+// than the database, such as standard input, are given a short time to finish
+// before the process exits.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+		log.Info(2, "interrupted")
+		cancel()
+
+		time.Sleep(interruptGracePeriod)
+		exit(errInterrupted)
+	}()
+
+	return ctx
+}
+
 var globalOptions = Options{Option{"--verbose", "-v", "show verbose messages", false, ""},
 	Option{"--help", "-h", "show help and exit", false, ""},
 	Option{"--version", "-V", "show version information and exit", false, ""},
 	Option{"--database", "-D", "use the specified database", true, ""},
 	Option{"--color", "", "colorize the output (auto/always/never)", true, ""},
+	Option{"--porcelain", "", "report warnings and errors in a form intended for scripts", false, ""},
 }
 
 func findDatabase() (string, error) {
@@ -108,6 +192,10 @@ func findDatabase() (string, error) {
         return databasePath, nil
     }
 
+    if databasePath := configuration["database"]; databasePath != "" {
+        return databasePath, nil
+    }
+
     u, err := user.Current()
     if err != nil {
         panic(fmt.Sprintf("could not identify current user: %v", err))
@@ -132,7 +220,7 @@ func findDatabaseInPath() (string, error) {
 
         switch {
         case os.IsNotExist(err):
-            if path == "/" {
+            if _path.IsRoot(path) {
                 return "", nil
             }
 
@@ -147,6 +235,9 @@ func findDatabaseInPath() (string, error) {
 }
 
 func readCommandsFromStdin(store *storage.Storage) error {
+    // the same queries are often repeated
+    store.EnableQueryCache()
+
     reader := bufio.NewReader(os.Stdin)
 
     wereErrors := false
@@ -168,7 +259,7 @@ func readCommandsFromStdin(store *storage.Storage) error {
         words := text.Tokenize(string(line))
         commandName, options, arguments, err := parser.Parse(words...)
         if err != nil {
-            log.Fatal(err)
+            return usageError{err}
         }
 
         if err := processCommand(store, commandName, options, arguments); err != nil {
@@ -186,9 +277,16 @@ func readCommandsFromStdin(store *storage.Storage) error {
 func processCommand(store *storage.Storage, commandName string, options Options, arguments []string) error {
 	command := findCommand(commands, commandName)
 	if command == nil {
-		log.Fatalf("invalid command '%v'.", commandName)
+		return usageError{fmt.Errorf("invalid command '%v'", commandName)}
 	}
 
+	options, err := applyDefaultOptions(store, command, options)
+	if err != nil {
+		return err
+	}
+
+	store.Command = command.Name
+
     if err := command.Exec(store, options, arguments); err != nil {
         return err
 	}
@@ -0,0 +1,78 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"context"
+	"os"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestProcessCommandInterrupted(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	store = store.WithContext(ctx)
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddTag("apple"); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	cancel()
+	err = processCommand(store, "tags", Options{}, []string{})
+
+	// validate
+
+	if err == nil {
+		test.Fatal("Expected the interrupted command to fail.")
+	}
+
+	if err := store.Rollback(); err != nil {
+		test.Fatal(err)
+	}
+
+	tags, err := store.WithContext(context.Background()).Tags()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(tags) != 0 {
+		test.Fatalf("Expected the interrupted changes to be discarded but there were tags %v.", tags)
+	}
+}
@@ -31,26 +31,61 @@ type Command struct {
 	Options     Options
 	Exec        func(*storage.Storage, Options, []string) error
 	Hidden      bool
+
+	// Whether the command runs outside of a transaction, e.g. because it
+	// serves until interrupted and so begins any transactions it needs.
+	NoTransaction bool
 }
 
 var commands = map[string]*Command{
-	"copy":     &CopyCommand,
-	"delete":   &DeleteCommand,
-	"dupes":    &DupesCommand,
-	"files":    &FilesCommand,
-	"help":     &HelpCommand,
-	"imply":    &ImplyCommand,
-	"merge":    &MergeCommand,
-    "mount":    &MountCommand,
-	"rename":   &RenameCommand,
-	"repair":   &RepairCommand,
-	"stats":    &StatsCommand,
-	"status":   &StatusCommand,
-	"tag":      &TagCommand,
-	"tags":     &TagsCommand,
-    "unmount":  &UnmountCommand,
-	"untag":    &UntagCommand,
-	"untagged": &UntaggedCommand,
-	"values":   &ValuesCommand,
-	"version":  &VersionCommand,
-    "vfs":      &VfsCommand}
+	"apply":       &ApplyCommand,
+	"backup":      &BackupCommand,
+	"browse":      &BrowseCommand,
+	"complete":    &CompleteCommand,
+	"config":      &ConfigCommand,
+	"copy":        &CopyCommand,
+	"copy-file":   &CopyFileCommand,
+	"delete":      &DeleteCommand,
+	"dupes":       &DupesCommand,
+	"edit":        &EditCommand,
+	"emblems":     &EmblemsCommand,
+	"events":      &EventsCommand,
+	"export":      &ExportCommand,
+	"files":       &FilesCommand,
+	"fingerprint": &FingerprintCommand,
+	"fsck":        &FsckCommand,
+	"gc":          &GcCommand,
+	"git-sync":    &GitSyncCommand,
+	"help":        &HelpCommand,
+	"imply":       &ImplyCommand,
+	"import":      &ImportCommand,
+	"info":        &InfoCommand,
+	"link":        &LinkCommand,
+	"log":         &LogCommand,
+	"merge":       &MergeCommand,
+	"move-file":   &MoveFileCommand,
+	"open":        &OpenCommand,
+	"playlist":    &PlaylistCommand,
+	"remove-file": &RemoveFileCommand,
+	"rename":      &RenameCommand,
+	"repair":      &RepairCommand,
+	"restore":     &RestoreCommand,
+	"search":      &SearchCommand,
+	"serve":       &ServeCommand,
+	"set":         &SetCommand,
+	"similar":     &SimilarCommand,
+	"split":       &SplitCommand,
+	"sql":         &SqlCommand,
+	"stats":       &StatsCommand,
+	"status":      &StatusCommand,
+	"suggest":     &SuggestCommand,
+	"tag":         &TagCommand,
+	"tags":        &TagsCommand,
+	"untag":       &UntagCommand,
+	"untagged":    &UntaggedCommand,
+	"vacuum":      &VacuumCommand,
+	"values":      &ValuesCommand,
+	"verify":      &VerifyCommand,
+	"version":     &VersionCommand,
+    "vfs":      &VfsCommand,
+	"xmp":         &XmpCommand}
@@ -17,8 +17,7 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
 package cli
 
+// Finder tags are stored in an extended attribute only macOS understands
 func init() {
-    delete(commands, "mount")
-    delete(commands, "unmount")
-    delete(commands, "vfs")
+	commands["finder-sync"] = &FinderSyncCommand
 }
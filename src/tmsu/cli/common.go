@@ -18,13 +18,110 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package cli
 
 import (
+	"bufio"
 	"errors"
-	"tmsu/entities"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"tmsu/common/filesystem"
+	"tmsu/common/fingerprint"
+	"tmsu/common/terminal"
+	"tmsu/storage"
 )
 
 var errBlank = errors.New("")
 
-type TagValuePair struct {
-	TagId   entities.TagId
-	ValueId entities.ValueId
+// Determines whether output is to be coloured from the --color option: 'auto'
+// (the default) colours output to a terminal, 'always' and 'never' override.
+func useColour(options Options) (bool, error) {
+	when := "auto"
+	if options.HasOption("--color") {
+		when = options.Get("--color").Argument
+	}
+
+	switch when {
+	case "auto":
+		return terminal.Colour() && terminal.Width() > 0, nil
+	case "always":
+		return true, nil
+	case "", "never":
+		return false, nil
+	}
+
+	return false, fmt.Errorf("invalid argument '%v' for '--color'", when)
+}
+
+// Determines how symbolic links are treated from the --symlinks option or,
+// where it is not specified, the 'symlinkPolicy' setting.
+func symlinkPolicy(store *storage.Storage, options Options) (filesystem.SymlinkPolicy, error) {
+	if options.HasOption("--symlinks") {
+		policy, err := filesystem.ParseSymlinkPolicy(options.Get("--symlinks").Argument)
+		if err != nil {
+			return policy, usageError{err}
+		}
+
+		return policy, nil
+	}
+
+	name, err := store.SettingAsString("symlinkPolicy")
+	if err != nil {
+		return filesystem.FollowSymlinks, err
+	}
+
+	return filesystem.ParseSymlinkPolicy(name)
+}
+
+// Creates the fingerprint for the file at path using the specified algorithm
+// unless it is a symbolic link the policy stores as a link, in which case the
+// link's target is used.
+func createFingerprint(path, algorithm string, policy filesystem.SymlinkPolicy) (fingerprint.Fingerprint, error) {
+	if policy.StoresAsLink(path) {
+		return fingerprint.CreateForLink(path)
+	}
+
+	return fingerprint.Create(path, algorithm)
+}
+
+// Parses a duration such as '90m', '36h', '7d' or '2w'.
+func parseDuration(text string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+
+	for suffix, unit := range units {
+		if strings.HasSuffix(text, suffix) {
+			count, err := strconv.ParseFloat(strings.TrimSuffix(text, suffix), 64)
+			if err != nil || count < 0 {
+				return 0, fmt.Errorf("invalid duration '%v'", text)
+			}
+
+			return time.Duration(count * float64(unit)), nil
+		}
+	}
+
+	duration, err := time.ParseDuration(text)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid duration '%v'", text)
+	}
+
+	return duration, nil
+}
+
+// Asks the user the specified yes/no question, returning whether they agreed.
+// Anything other than 'y' or 'yes', including end of input, is taken as no.
+func confirm(question string) (bool, error) {
+	fmt.Fprintf(os.Stderr, "tmsu: %v [y/N] ", question)
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("could not read answer: %v", err)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+
+	return false, nil
 }
@@ -0,0 +1,188 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"tmsu/storage"
+)
+
+var CompleteCommand = Command{
+	Name:     "complete",
+	Synopsis: "List completions for shell completion scripts",
+	Usages: []string{"tmsu complete --commands [PREFIX]",
+		"tmsu complete --tags [PREFIX]",
+		"tmsu complete --values TAG [PREFIX]",
+		"tmsu complete --query [PREFIX]"},
+	Description: `Lists, one per line, the completions for PREFIX for use by shell completion scripts.
+
+With --tags, PREFIX is completed against the tag names unless it contains an equals sign in which case the part after the equals sign is completed against the tag's values, e.g. 'year=20' completes to 'year=2014' and 'year=2015'.
+
+With --query, PREFIX is completed against the tag names and the query language operators.`,
+	Examples: []string{"$ tmsu complete --tags mu\nmusic\nmusical",
+		"$ tmsu complete --tags year=201\nyear=2014\nyear=2015",
+		"$ tmsu complete --values year 201\n2014\n2015",
+		"$ tmsu complete --query an\nand\nanimal"},
+	Options: Options{{"--commands", "", "complete subcommand names", false, ""},
+		{"--tags", "", "complete tag names and tag=value pairs", false, ""},
+		{"--values", "", "complete the values of TAG", false, ""},
+		{"--query", "", "complete tag names and query operators", false, ""}},
+	Exec:   completeExec,
+	Hidden: true,
+}
+
+func completeExec(store *storage.Storage, options Options, args []string) error {
+	var completions []string
+	var err error
+
+	switch {
+	case options.HasOption("--commands"):
+		completions = completeCommands(prefixArgument(args, 0))
+	case options.HasOption("--tags"):
+		completions, err = completeTags(store, prefixArgument(args, 0))
+	case options.HasOption("--values"):
+		if len(args) < 1 {
+			return fmt.Errorf("tag must be specified")
+		}
+
+		completions, err = completeValues(store, args[0], prefixArgument(args, 1))
+	case options.HasOption("--query"):
+		completions, err = completeQuery(store, prefixArgument(args, 0))
+	default:
+		return fmt.Errorf("one of --commands, --tags, --values or --query must be specified")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, completion := range completions {
+		fmt.Println(completion)
+	}
+
+	return nil
+}
+
+// unexported
+
+var queryKeywords = []string{"and", "or", "not", "eq", "ne", "lt", "gt", "le", "ge", "==", "!=", "<", ">", "<=", ">="}
+
+func prefixArgument(args []string, index int) string {
+	if len(args) > index {
+		return args[index]
+	}
+
+	return ""
+}
+
+func completeCommands(prefix string) []string {
+	completions := make([]string, 0, len(helpCommands))
+
+	for name, command := range helpCommands {
+		if command.Hidden {
+			continue
+		}
+
+		if strings.HasPrefix(name, prefix) {
+			completions = append(completions, name)
+		}
+	}
+
+	sort.Strings(completions)
+
+	return completions
+}
+
+func completeTags(store *storage.Storage, prefix string) ([]string, error) {
+	if index := strings.Index(prefix, "="); index != -1 {
+		tagName := prefix[:index]
+
+		valueNames, err := completeValues(store, tagName, prefix[index+1:])
+		if err != nil {
+			return nil, err
+		}
+
+		completions := make([]string, len(valueNames))
+		for index, valueName := range valueNames {
+			completions[index] = tagName + "=" + valueName
+		}
+
+		return completions, nil
+	}
+
+	tags, err := store.Tags()
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve tags: %v", err)
+	}
+
+	completions := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if strings.HasPrefix(tag.Name, prefix) {
+			completions = append(completions, tag.Name)
+		}
+	}
+
+	return completions, nil
+}
+
+func completeValues(store *storage.Storage, tagName, prefix string) ([]string, error) {
+	tag, err := store.TagByName(tagName)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve tag '%v': %v", tagName, err)
+	}
+	if tag == nil {
+		return []string{}, nil
+	}
+
+	values, err := store.ValuesByTag(tag.Id)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve values for tag '%v': %v", tagName, err)
+	}
+
+	completions := make([]string, 0, len(values))
+	for _, value := range values {
+		if strings.HasPrefix(value.Name, prefix) {
+			completions = append(completions, value.Name)
+		}
+	}
+
+	return completions, nil
+}
+
+func completeQuery(store *storage.Storage, prefix string) ([]string, error) {
+	completions, err := completeTags(store, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.Contains(prefix, "=") {
+		return completions, nil
+	}
+
+	for _, keyword := range queryKeywords {
+		if strings.HasPrefix(keyword, prefix) {
+			completions = append(completions, keyword)
+		}
+	}
+
+	sort.Strings(completions)
+
+	return completions, nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+	"tmsu/common/fingerprint"
+	"tmsu/storage"
+)
+
+func TestCompleteTags(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, tagName := range []string{"music", "musical", "art"} {
+		if _, err := store.AddTag(tagName); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// test
+
+	if err := CompleteCommand.Exec(store, Options{Option{"--tags", "", "", false, ""}}, []string{"mu"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "music\nmusical\n", string(bytes))
+}
+
+func TestCompleteTagValuePairs(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	file, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tag, err := store.AddTag("year")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	for _, valueName := range []string{"2014", "2015", "1999"} {
+		value, err := store.AddValue(valueName)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		if _, err := store.AddFileTag(file.Id, tag.Id, value.Id); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// test
+
+	if err := CompleteCommand.Exec(store, Options{Option{"--tags", "", "", false, ""}}, []string{"year=20"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "year=2014\nyear=2015\n", string(bytes))
+}
+
+func TestCompleteQuery(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, tagName := range []string{"animal", "north"} {
+		if _, err := store.AddTag(tagName); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// test
+
+	if err := CompleteCommand.Exec(store, Options{Option{"--query", "", "", false, ""}}, []string{"n"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "ne\nnorth\nnot\n", string(bytes))
+}
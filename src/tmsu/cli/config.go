@@ -0,0 +1,291 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"tmsu/common/log"
+	"tmsu/query"
+	"tmsu/storage"
+)
+
+var ConfigCommand = Command{
+	Name:     "config",
+	Synopsis: "View or amend database settings",
+	Usages: []string{"tmsu config",
+		"tmsu config NAME...",
+		"tmsu config NAME=VALUE...",
+		"tmsu config --unset NAME..."},
+	Description: `Without arguments, lists the settings in effect. Where NAMEs are specified, shows the values of those settings. Where NAME=VALUE pairs are specified, stores the settings in the database. With --unset, removes the settings from the database so that the value from the configuration file, or otherwise the default, applies again.
+
+Settings of the form COMMAND.OPTION supply default options for a command, where OPTION is the long option name without the leading dashes. For an option that takes an argument the setting's value is the argument; otherwise 'yes' (or 'true') passes the option and 'no' (or 'false') does not.
+
+Settings of the form macro.NAME define query macros: the term '$NAME' within any query is replaced by the setting's value, itself a query.
+
+The 'modTimeTags' setting maintains tags derived from the files' modification times, such as 'mtime-year=2015', for browsing by date. They are applied as files are tagged, updated by 'repair' as files change and added to, or removed from, all of the files when the setting is changed. They do not count towards the 'fileTagLimit' and a file with no other tags is removed from the database.
+
+The following database settings are recognised:
+
+  autoCreateTags        create tags that do not yet exist (yes/no)
+  autoCreateValues      create values that do not yet exist (yes/no)
+  autoVacuumThreshold   the percentage of the database file that may be free
+                        space before it is vacuumed (0 never vacuums)
+  cascade               whether ancestor databases are consulted (none/fallback/union)
+  deleteConfirmThreshold
+                        the number of files a tag may be applied to before
+                        'delete' asks for confirmation (0 never asks)
+  emblemTags            the emblems 'emblems' shows for tags, e.g.
+                        'favourite:emblem-favorite'
+  extraFingerprintAlgorithms
+                        further algorithms under which to fingerprint files
+  fileTagLimit          the number of tags a file may have (0 for no limit)
+  finderTagPrefix       the prefix of the tags 'finder-sync' synchronizes
+                        with macOS Finder tags
+  fingerprintAlgorithm  the algorithm used to identify file contents
+  inheritDirectoryTags  items within a tagged directory match its tags in
+                        queries (yes/no)
+  lockedTags            the tags that may not be removed without --force
+  modTimeTags           the parts of each file's modification time kept as
+                        tags, e.g. 'year,month' for 'mtime-year=2015' and
+                        'mtime-month=06' (year/month/day)
+  recordTagOwner        record which user applied each tag (yes/no)
+  retainDeletedFiles    keep a record of removed files (yes/no)
+  sidecarGroups         groups of files tagged as one, e.g. '*.cr2,*.jpg,*.xmp'
+  symlinkPolicy         how symbolic links are treated (follow/target/link)
+  tagFileLimit          the number of files a tag may be applied to (0 for
+                        no limit)
+  tagHardLinks          also tag a file's other hard links (yes/no)
+  tagVisibility         whether other users' taggings match (all/mine)
+  untagConfirmThreshold the number of files 'untag --recursive' may affect
+                        before asking for confirmation (0 never asks)
+  unusedRetention       how long unused tags and values are kept before
+                        'gc' removes them, e.g. 30d
+  xmpValueSeparator     separates a tag from its value in XMP keywords`,
+	Examples: []string{"$ tmsu config",
+		"$ tmsu config autoCreateTags\nyes",
+		"$ tmsu config autoCreateValues=no",
+		"$ tmsu config files.directory=yes tags.count=yes",
+		"$ tmsu config macro.unsorted='not (genre or year)'",
+		"$ tmsu config --unset files.directory"},
+	Options: Options{{"--unset", "-u", "remove the settings from the database", false, ""}},
+	Exec:    configExec,
+}
+
+func configExec(store *storage.Storage, options Options, args []string) error {
+	if options.HasOption("--unset") {
+		if len(args) == 0 {
+			return fmt.Errorf("settings to unset must be specified")
+		}
+
+		return unsetSettings(store, args)
+	}
+
+	if len(args) == 0 {
+		return listSettings(store)
+	}
+
+	wereErrors := false
+	for _, arg := range args {
+		var err error
+		if index := strings.Index(arg, "="); index != -1 {
+			err = updateSetting(store, arg[:index], arg[index+1:])
+		} else {
+			err = printSetting(store, arg)
+		}
+
+		if err != nil {
+			if err != errBlank {
+				return err
+			}
+
+			wereErrors = true
+		}
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+// unexported
+
+func listSettings(store *storage.Storage) error {
+	settings, err := store.Settings()
+	if err != nil {
+		return fmt.Errorf("could not retrieve settings: %v", err)
+	}
+
+	for _, setting := range settings {
+		fmt.Printf("%v=%v\n", setting.Name, setting.Value)
+	}
+
+	return nil
+}
+
+func printSetting(store *storage.Storage, name string) error {
+	setting, err := store.Setting(name)
+	if err != nil {
+		return fmt.Errorf("could not retrieve setting '%v': %v", name, err)
+	}
+	if setting == nil {
+		log.Warnf("no such setting '%v'.", name)
+		return errBlank
+	}
+
+	fmt.Println(setting.Value)
+
+	return nil
+}
+
+func updateSetting(store *storage.Storage, name, value string) error {
+	if err := validateSettingName(name); err != nil {
+		log.Warn(err.Error())
+		return errBlank
+	}
+
+	if name == "modTimeTags" {
+		if err := storage.ValidateModTimeTags(value); err != nil {
+			log.Warnf("%v: %v", name, err)
+			return errBlank
+		}
+	}
+
+	if strings.HasPrefix(name, storage.MacroSettingPrefix) {
+		if _, err := query.Parse(value); err != nil {
+			log.Warnf("%v: invalid query: %v", name, err)
+			return errBlank
+		}
+	}
+
+	log.Infof(2, "setting '%v' to '%v'.", name, value)
+
+	if _, err := store.UpdateSetting(name, value); err != nil {
+		return fmt.Errorf("could not update setting '%v': %v", name, err)
+	}
+
+	if name == "modTimeTags" {
+		return updateModTimeTags(store)
+	}
+
+	return nil
+}
+
+func unsetSettings(store *storage.Storage, names []string) error {
+	for _, name := range names {
+		log.Infof(2, "removing setting '%v'.", name)
+
+		if err := store.DeleteSetting(name); err != nil {
+			return fmt.Errorf("could not remove setting '%v': %v", name, err)
+		}
+
+		if name == "modTimeTags" {
+			if err := updateModTimeTags(store); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Adds or removes the modification time tags of the files in the database
+// as the 'modTimeTags' setting now requires.
+func updateModTimeTags(store *storage.Storage) error {
+	log.Info(2, "updating modification time tags.")
+
+	if err := store.UpdateAllModTimeTags(); err != nil {
+		return fmt.Errorf("could not update modification time tags: %v", err)
+	}
+
+	return nil
+}
+
+// Checks that a setting of the form COMMAND.OPTION names an option of the
+// command and that a setting of the form macro.NAME names a valid macro.
+func validateSettingName(name string) error {
+	if name == "" {
+		return fmt.Errorf("setting name must be specified.")
+	}
+
+	index := strings.Index(name, ".")
+	if index == -1 {
+		return nil
+	}
+
+	commandName, optionName := name[:index], name[index+1:]
+
+	if name[:index+1] == storage.MacroSettingPrefix {
+		if optionName == "" || strings.ContainsAny(optionName, " \t()=!<>") {
+			return fmt.Errorf("%v: invalid macro name '%v'.", name, optionName)
+		}
+
+		return nil
+	}
+
+	command := findCommand(helpCommands, commandName)
+	if command == nil || command.Name != commandName {
+		return fmt.Errorf("%v: no such command '%v'.", name, commandName)
+	}
+	if !command.Options.HasOption("--" + optionName) {
+		return fmt.Errorf("%v: command '%v' has no option '--%v'.", name, commandName, optionName)
+	}
+
+	return nil
+}
+
+// Adds the options the COMMAND.OPTION settings supply for the command, unless
+// already specified.
+func applyDefaultOptions(store *storage.Storage, command *Command, options Options) (Options, error) {
+	for _, option := range command.Options {
+		if options.HasOption(option.LongName) {
+			continue
+		}
+
+		name := command.Name + "." + strings.TrimPrefix(option.LongName, "--")
+
+		setting, err := store.Setting(name)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve setting '%v': %v", name, err)
+		}
+		if setting == nil {
+			continue
+		}
+
+		if option.HasArgument {
+			if setting.Value != "" {
+				option.Argument = setting.Value
+				options = append(options, option)
+			}
+
+			continue
+		}
+
+		switch setting.Value {
+		case "yes", "true":
+			options = append(options, option)
+		case "no", "false":
+		default:
+			return nil, fmt.Errorf("setting '%v' has an invalid value '%v': expected 'yes' or 'no'.", name, setting.Value)
+		}
+	}
+
+	return options, nil
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestConfigSetGetAndUnset(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	helpCommands = commands
+
+	// test
+
+	if err := ConfigCommand.Exec(store, Options{}, []string{"autoCreateTags=no", "files.count=yes"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := ConfigCommand.Exec(store, Options{}, []string{"autoCreateTags", "files.count"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := ConfigCommand.Exec(store, Options{Option{"--unset", "-u", "", false, ""}}, []string{"autoCreateTags"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := ConfigCommand.Exec(store, Options{}, []string{"autoCreateTags"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "no\nyes\nyes\n", string(bytes))
+
+	if err := ConfigCommand.Exec(store, Options{}, []string{"files.bogus=yes"}); err != errBlank {
+		test.Fatalf("Expected setting for non-existent option to be refused but got %v", err)
+	}
+}
+
+func TestConfigDefaultOptions(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.UpdateSetting("files.count", "yes"); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.UpdateSetting("files.path", "/tmp/tmsu"); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.UpdateSetting("files.directory", "no"); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	options, err := applyDefaultOptions(store, &FilesCommand, Options{Option{"--path", "-p", "", true, "/tmp/other"}})
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	if !options.HasOption("--count") {
+		test.Fatal("Expected '--count' option to be applied.")
+	}
+	if options.HasOption("--directory") {
+		test.Fatal("Expected '--directory' option not to be applied.")
+	}
+	if options.Count("--path") != 1 || options.Get("--path").Argument != "/tmp/other" {
+		test.Fatal("Expected '--path' option given to take precedence.")
+	}
+}
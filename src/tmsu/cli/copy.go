@@ -20,22 +20,32 @@ package cli
 import (
 	"fmt"
 	"tmsu/common/log"
+	"tmsu/entities"
 	"tmsu/storage"
 )
 
 var CopyCommand = Command{
-	Name:        "copy",
-	Aliases:     []string{"cp"},
-	Synopsis:    "Create a copy of a tag",
-	Usages:      []string{"tmsu copy TAG NEW..."},
-	Description: `Creates a new tag NEW applied to the same set of files as TAG.`,
+	Name:     "copy",
+	Aliases:  []string{"cp"},
+	Synopsis: "Create a copy of a tag",
+	Usages:   []string{"tmsu copy [OPTION]... TAG NEW..."},
+	Description: `Creates a new tag NEW applied to the same set of files as TAG. Where several NEW tags are specified, each is created as a copy of TAG.
+
+With --value only the applications of TAG with VALUE are copied. The option may be repeated to copy the applications with any of the VALUEs.
+
+Each NEW tag is created in full or, should an error occur, not at all.`,
 	Examples: []string{"$ tmsu copy cheese wine",
-		"$ tmsu copy report document text"},
-	Options: Options{},
+		"$ tmsu copy report document text",
+		"$ tmsu copy year year-backup --value 2014"},
+	Options: Options{{"--value", "", "copy only the applications with VALUE (may be repeated)", true, ""}},
 	Exec:    copyExec,
 }
 
 func copyExec(store *storage.Storage, options Options, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("too few arguments")
+	}
+
 	sourceTagName := args[0]
 	destTagNames := args[1:]
 
@@ -44,11 +54,29 @@ func copyExec(store *storage.Storage, options Options, args []string) error {
 		return fmt.Errorf("could not retrieve tag '%v': %v", sourceTagName, err)
 	}
 	if sourceTag == nil {
-		return fmt.Errorf("no such tag '%v'", sourceTagName)
+		return noSuchTagError{sourceTagName}
+	}
+
+	valueIds := make(entities.ValueIds, 0, len(options))
+	for _, option := range options.GetAll("--value") {
+		value, err := getValue(store, option.Argument)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			return fmt.Errorf("no such value '%v'", option.Argument)
+		}
+
+		valueIds = append(valueIds, value.Id)
+	}
+
+	fileTagCount, err := store.FileTagCountByTagId(sourceTag.Id, true)
+	if err != nil {
+		return fmt.Errorf("could not retrieve file tag count for tag '%v': %v", sourceTagName, err)
 	}
 
 	wereErrors := false
-	for _, destTagName := range destTagNames {
+	for index, destTagName := range destTagNames {
 		destTag, err := store.Db.TagByName(destTagName)
 		if err != nil {
 			return fmt.Errorf("could not retrieve tag '%v': %v", destTagName, err)
@@ -59,9 +87,13 @@ func copyExec(store *storage.Storage, options Options, args []string) error {
 			continue
 		}
 
-		log.Infof(2, "copying tag '%v' to '%v'.", sourceTagName, destTagName)
+		if fileTagCount >= copyProgressThreshold {
+			log.Infof(1, "copying tag '%v' to '%v' (%v of %v).", sourceTagName, destTagName, index+1, len(destTagNames))
+		} else {
+			log.Infof(2, "copying tag '%v' to '%v'.", sourceTagName, destTagName)
+		}
 
-		if _, err = store.CopyTag(sourceTag.Id, destTagName); err != nil {
+		if _, err = store.CopyTag(sourceTag.Id, destTagName, valueIds); err != nil {
 			return fmt.Errorf("could not copy tag '%v' to '%v': %v", sourceTagName, destTagName, err)
 		}
 	}
@@ -72,3 +104,9 @@ func copyExec(store *storage.Storage, options Options, args []string) error {
 
 	return nil
 }
+
+// unexported
+
+// The number of applications of a tag from which progress is reported as each
+// copy is made.
+const copyProgressThreshold = 10000
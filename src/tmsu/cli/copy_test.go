@@ -18,6 +18,7 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package cli
 
 import (
+	"fmt"
 	"os"
 	"testing"
 	"time"
@@ -158,3 +159,109 @@ func TestCopyDestTagAlreadyExists(test *testing.T) {
 		test.Fatal("Existing dest tag not identified.")
 	}
 }
+
+func TestCopyValuesToMultipleTags(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	yearTag, err := store.AddTag("year")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	for index, year := range []string{"2013", "2014", "2015"} {
+		file, err := store.AddFile(fmt.Sprintf("/tmp/%v", index), fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		value, err := store.AddValue(year)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		if _, err := store.AddFileTag(file.Id, yearTag.Id, value.Id); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// test
+
+	options := Options{Option{"--value", "", "", true, "2013"}, Option{"--value", "", "", true, "2014"}}
+	if err := CopyCommand.Exec(store, options, []string{"year", "year-backup", "archived"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	for _, tagName := range []string{"year-backup", "archived"} {
+		tag, err := store.TagByName(tagName)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if tag == nil {
+			test.Fatalf("Tag '%v' does not exist.", tagName)
+		}
+
+		fileTags, err := store.FileTagsByTagId(tag.Id, true)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if len(fileTags) != 2 {
+			test.Fatalf("Expected tag '%v' to be applied to 2 files but it is applied to %v.", tagName, len(fileTags))
+		}
+
+		for _, fileTag := range fileTags {
+			value, err := store.Value(fileTag.ValueId)
+			if err != nil {
+				test.Fatal(err)
+			}
+			if value == nil || (value.Name != "2013" && value.Name != "2014") {
+				test.Fatalf("Unexpected value %v copied to tag '%v'.", value, tagName)
+			}
+		}
+	}
+}
+
+func TestCopyNonExistentValue(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.AddTag("source"); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	err = CopyCommand.Exec(store, Options{Option{"--value", "", "", true, "missing"}}, []string{"source", "dest"})
+
+	// validate
+
+	if err == nil {
+		test.Fatal("Non-existent value was not identified.")
+	}
+
+	destTag, err := store.TagByName("dest")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if destTag != nil {
+		test.Fatal("Destination tag was created.")
+	}
+}
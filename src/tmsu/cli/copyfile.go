@@ -0,0 +1,171 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"tmsu/common/filesystem"
+	"tmsu/common/log"
+	"tmsu/entities"
+	"tmsu/storage"
+)
+
+var CopyFileCommand = Command{
+	Name:     "copy-file",
+	Synopsis: "Copy files along with their tags",
+	Usages: []string{"tmsu copy-file [OPTION]... SOURCE DEST",
+		"tmsu copy-file [OPTION]... SOURCE... DIRECTORY"},
+	Description: `Copies SOURCE to DEST, or each SOURCE into DIRECTORY, and applies the tags of the source files to the copies. Permissions and modification times are preserved.
+
+Existing files are not overwritten.
+
+(To copy a tag use the 'copy' subcommand, or its alias 'cp'.)`,
+	Examples: []string{"$ tmsu copy-file mountain.jpg mountain-edit.jpg",
+		"$ tmsu copy-file --recursive ~/photos /media/backup"},
+	Options: Options{{"--recursive", "-r", "copy directories and their contents", false, ""}},
+	Exec:    copyFileExec,
+}
+
+func copyFileExec(store *storage.Storage, options Options, args []string) error {
+	recursive := options.HasOption("--recursive")
+
+	if len(args) < 2 {
+		return fmt.Errorf("source and destination must be specified")
+	}
+
+	sources, dest, destIsDir, err := sourcesAndDestination(args)
+	if err != nil {
+		return err
+	}
+
+	wereErrors := false
+	for _, source := range sources {
+		target := destinationPath(source, dest, destIsDir)
+
+		if err := copyPath(store, source, target, recursive); err != nil {
+			log.Warnf("%v: could not copy to '%v': %v", source, target, err)
+			wereErrors = true
+		}
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+// unexported
+
+func copyPath(store *storage.Storage, source, dest string, recursive bool) error {
+	absSource, err := filepath.Abs(source)
+	if err != nil {
+		return err
+	}
+
+	absDest, err := filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+
+	stat, err := os.Stat(absSource)
+	if err != nil {
+		return err
+	}
+	if stat.IsDir() && !recursive {
+		return fmt.Errorf("is a directory (use --recursive)")
+	}
+
+	if _, err := os.Lstat(absDest); err == nil {
+		return fmt.Errorf("destination already exists")
+	}
+
+	log.Infof(2, "%v: copying to '%v'", source, dest)
+
+	if err := filesystem.Copy(absSource, absDest); err != nil {
+		return err
+	}
+
+	file, err := store.FileByPath(absSource)
+	if err != nil {
+		return fmt.Errorf("could not retrieve file: %v", err)
+	}
+	if file != nil {
+		if err := copyFileEntry(store, file, absDest); err != nil {
+			return err
+		}
+	}
+
+	childFiles, err := store.FilesByDirectory(absSource)
+	if err != nil {
+		return fmt.Errorf("could not retrieve files for directory: %v", err)
+	}
+
+	for _, childFile := range childFiles {
+		childDest := absDest + childFile.Path()[len(absSource):]
+
+		if err := copyFileEntry(store, childFile, childDest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyFileEntry(store *storage.Storage, file *entities.File, destPath string) error {
+	stat, err := os.Lstat(destPath)
+	if err != nil {
+		// the database entry may be stale so there may be nothing to tag
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	destFile, err := store.FileByPath(destPath)
+	if err != nil {
+		return fmt.Errorf("%v: could not retrieve file: %v", destPath, err)
+	}
+	if destFile == nil {
+		destFile, err = store.AddFile(destPath, file.Fingerprint, stat.ModTime(), stat.Size(), stat.IsDir())
+	} else {
+		destFile, err = store.UpdateFile(destFile.Id, destPath, file.Fingerprint, stat.ModTime(), stat.Size(), stat.IsDir())
+	}
+	if err != nil {
+		return fmt.Errorf("%v: could not add file: %v", destPath, err)
+	}
+
+	fingerprints, err := store.FileFingerprints(file.Id)
+	if err != nil {
+		return fmt.Errorf("%v: could not retrieve fingerprints: %v", file.Path(), err)
+	}
+	for algorithm, fingerprint := range fingerprints {
+		if err := store.UpdateFileFingerprint(destFile.Id, algorithm, fingerprint); err != nil {
+			return fmt.Errorf("%v: could not copy fingerprints: %v", destPath, err)
+		}
+	}
+
+	if err := store.CopyFileTagsByFileId(file.Id, destFile.Id); err != nil {
+		return fmt.Errorf("%v: could not copy tags: %v", destPath, err)
+	}
+
+	return nil
+}
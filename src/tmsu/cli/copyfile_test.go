@@ -0,0 +1,144 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestCopyFileCopiesFileAndTags(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/cp/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll("/tmp/tmsu/cp")
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/cp/a", "apple", "year=2015"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := CopyFileCommand.Exec(store, Options{}, []string{"/tmp/tmsu/cp/a", "/tmp/tmsu/cp/b"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	bytes, err := ioutil.ReadFile("/tmp/tmsu/cp/b")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if string(bytes) != "hello" {
+		test.Fatalf("Copy has unexpected contents '%v'.", string(bytes))
+	}
+
+	source, err := store.FileByPath("/tmp/tmsu/cp/a")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	copy, err := store.FileByPath("/tmp/tmsu/cp/b")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if copy == nil {
+		test.Fatalf("Copy was not added to the database.")
+	}
+	if copy.Fingerprint != source.Fingerprint {
+		test.Fatalf("Copy has fingerprint '%v' but expected '%v'.", copy.Fingerprint, source.Fingerprint)
+	}
+
+	tagNames, err := tagNamesForFile(store, copy.Id, true, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(tagNames) != 2 || tagNames[0] != "apple" || tagNames[1] != "year=2015" {
+		test.Fatalf("Unexpected tags: %v", tagNames)
+	}
+}
+
+func TestCopyFileDoesNotOverwrite(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/cp/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	if err := createFile("/tmp/tmsu/cp/b", "world"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll("/tmp/tmsu/cp")
+
+	// test
+
+	err = CopyFileCommand.Exec(store, Options{}, []string{"/tmp/tmsu/cp/a", "/tmp/tmsu/cp/b"})
+
+	// validate
+
+	if err != errBlank {
+		test.Fatalf("Expected copy to fail.")
+	}
+
+	bytes, err := ioutil.ReadFile("/tmp/tmsu/cp/b")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if string(bytes) != "world" {
+		test.Fatalf("Destination was overwritten.")
+	}
+}
+
+func TestCpIsAliasOfCopy(test *testing.T) {
+	// test
+
+	command := findCommand(commands, "cp")
+
+	// validate
+
+	if command != &CopyCommand {
+		test.Fatalf("Expected 'cp' to be the 'copy' subcommand but was '%v'.", command.Name)
+	}
+}
@@ -20,19 +20,28 @@ package cli
 import (
 	"fmt"
 	"tmsu/common/log"
+	"tmsu/entities"
 	"tmsu/storage"
 )
 
 var DeleteCommand = Command{
-	Name:        "delete",
-	Aliases:     []string{"del", "rm"},
-	Synopsis:    "Delete one or more tags",
-	Usages:      []string{"tmsu delete TAG..."},
-	Description: `Permanently deletes the TAGs specified.`,
+	Name:     "delete",
+	Aliases:  []string{"del", "rm"},
+	Synopsis: "Delete one or more tags",
+	Usages:   []string{"tmsu delete [OPTION]... TAG..."},
+	Description: `Permanently deletes the TAGs specified.
+
+When a TAG is applied to more files than the 'deleteConfirmThreshold' setting (default 1000) permits, the number of files is shown and confirmation is requested first unless --force is specified. A threshold of 0 never asks.
+
+File entries that are left without any tags are removed from the database. With --and-files, every other file entry in the database that has no tags is removed too.
+
+Tags named by the 'lockedTags' setting cannot be deleted unless --force is specified.`,
 	Examples: []string{"$ tmsu delete pineapple",
-		"$ tmsu delete red green blue"},
-	Options: Options{},
-	Exec:    deleteExec,
+		"$ tmsu delete red green blue",
+		"$ tmsu delete --force --and-files imported"},
+	Options: Options{{"--force", "-f", "delete locked tags and do not ask for confirmation", false, ""},
+		{"--and-files", "", "also remove the file entries that have no tags", false, ""}},
+	Exec: deleteExec,
 }
 
 func deleteExec(store *storage.Storage, options Options, args []string) error {
@@ -40,6 +49,8 @@ func deleteExec(store *storage.Storage, options Options, args []string) error {
 		return fmt.Errorf("no tags to delete specified")
 	}
 
+	store.Force = options.HasOption("--force")
+
 	wereErrors := false
 	for _, tagName := range args {
 		tag, err := store.TagByName(tagName)
@@ -52,15 +63,77 @@ func deleteExec(store *storage.Storage, options Options, args []string) error {
 			continue
 		}
 
+		if !store.Force {
+			confirmed, err := confirmDelete(store, tag)
+			if err != nil {
+				return err
+			}
+			if !confirmed {
+				log.Warnf("tag '%v' was not deleted: use --force to delete it without confirmation.", tagName)
+				wereErrors = true
+				continue
+			}
+		}
+
 		err = store.DeleteTag(tag.Id)
+		if _, ok := err.(storage.TagLockedError); ok {
+			log.Warnf("tag '%v' is locked: use --force to delete it.", tagName)
+			wereErrors = true
+			continue
+		}
 		if err != nil {
 			return fmt.Errorf("could not delete tag '%v': %v", tagName, err)
 		}
 	}
 
+	if options.HasOption("--and-files") {
+		if err := deleteAllUntaggedFiles(store); err != nil {
+			return err
+		}
+	}
+
 	if wereErrors {
 		return errBlank
 	}
 
 	return nil
 }
+
+// unexported
+
+// Asks for confirmation when the tag is applied to more files than the
+// 'deleteConfirmThreshold' setting allows.
+func confirmDelete(store *storage.Storage, tag *entities.Tag) (bool, error) {
+	threshold, err := store.SettingAsUint("deleteConfirmThreshold")
+	if err != nil {
+		return false, err
+	}
+	if threshold == 0 {
+		return true, nil
+	}
+
+	fileCount, err := store.FileCountByTagId(tag.Id)
+	if err != nil {
+		return false, fmt.Errorf("could not retrieve file count for tag '%v': %v", tag.Name, err)
+	}
+	if fileCount <= threshold {
+		return true, nil
+	}
+
+	return confirm(fmt.Sprintf("tag '%v' is applied to %v files: delete it?", tag.Name, fileCount))
+}
+
+func deleteAllUntaggedFiles(store *storage.Storage) error {
+	files, err := store.UntaggedFiles()
+	if err != nil {
+		return fmt.Errorf("could not retrieve untagged files: %v", err)
+	}
+
+	if err := deleteUntaggedFiles(store, files); err != nil {
+		return fmt.Errorf("could not remove untagged files: %v", err)
+	}
+
+	log.Infof(1, "removed %v untagged file entries.", len(files))
+
+	return nil
+}
@@ -18,7 +18,9 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package cli
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 	"tmsu/common/fingerprint"
@@ -180,3 +182,171 @@ func TestDeleteNonExistentTag(test *testing.T) {
 		test.Fatal("Non-existent from tag was not identified.")
 	}
 }
+
+func TestDeleteLockedTag(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.Db.Exec("INSERT INTO setting (name, value) VALUES ('lockedTags', 'legal-hold')"); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddTag("legal-hold"); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := DeleteCommand.Exec(store, Options{}, []string{"legal-hold"}); err != errBlank {
+		test.Fatalf("Expected locked tag to be reported but got %v", err)
+	}
+
+	// validate
+
+	tag, err := store.TagByName("legal-hold")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if tag == nil {
+		test.Fatal("Locked tag was deleted.")
+	}
+}
+
+func TestDeleteConfirmation(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.UpdateSetting("deleteConfirmThreshold", "1"); err != nil {
+		test.Fatal(err)
+	}
+
+	appleTag, err := store.AddTag("apple")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	for _, path := range []string{"/tmp/tmsu/a", "/tmp/tmsu/b"} {
+		file, err := store.AddFile(path, fingerprint.Fingerprint("abc123"), time.Now(), 0, false)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		if _, err := store.AddFileTag(file.Id, appleTag.Id, 0); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+
+	answer := func(text string) {
+		path := filepath.Join(os.TempDir(), "tmsu_test.in")
+		if err := ioutil.WriteFile(path, []byte(text), 0600); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+
+		file, err := os.Open(path)
+		if err != nil {
+			test.Fatal(err)
+		}
+		os.Stdin = file
+	}
+
+	// test
+
+	answer("n\n")
+	err = DeleteCommand.Exec(store, Options{}, []string{"apple"})
+
+	// validate
+
+	if err != errBlank {
+		test.Fatalf("Expected declined deletion to be reported but got: %v", err)
+	}
+
+	tag, err := store.TagByName("apple")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if tag == nil {
+		test.Fatal("Tag was deleted despite confirmation being declined.")
+	}
+
+	answer("y\n")
+	if err := DeleteCommand.Exec(store, Options{}, []string{"apple"}); err != nil {
+		test.Fatal(err)
+	}
+
+	tag, err = store.TagByName("apple")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if tag != nil {
+		test.Fatal("Tag was not deleted despite confirmation.")
+	}
+}
+
+func TestDeleteAndFiles(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.AddTag("apple"); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFile("/tmp/tmsu/a", fingerprint.Fingerprint("abc123"), time.Now(), 0, false); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := DeleteCommand.Exec(store, Options{Option{"--and-files", "", "", false, ""}}, []string{"apple"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	fileCount, err := store.FileCount()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if fileCount != 0 {
+		test.Fatalf("Expected the untagged file entry to be removed but there are %v files.", fileCount)
+	}
+}
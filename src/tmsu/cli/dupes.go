@@ -30,41 +30,66 @@ import (
 )
 
 var DupesCommand = Command{
-	Name:        "dupes",
-	Synopsis:    "Identify duplicate files",
-	Usages:      []string{"tmsu dupes [FILE]..."},
-	Description: `Identifies all files in the database that are exact duplicates of FILE. If no FILE is specified then identifies duplicates between files in the database.`,
+	Name:     "dupes",
+	Synopsis: "Identify duplicate files",
+	Usages:   []string{"tmsu dupes [FILE]..."},
+	Description: `Identifies all files in the database that are exact duplicates of FILE. If no FILE is specified then identifies duplicates between files in the database.
+
+Files are compared by their fingerprints under the 'fingerprintAlgorithm' setting unless --algorithm specifies one of the algorithms named by the 'extraFingerprintAlgorithms' setting.
+
+Hard links to the same file are reported as duplicates unless --exclude-hardlinks is specified, in which case only one of them is listed. Hard links are identified from the device and inode numbers recorded when files are tagged or repaired.`,
 	Examples: []string{"$ tmsu dupes\nSet of 2 duplicates:\n  /tmp/song.mp3\n  /tmp/copy of song.mp3a",
-		"$ tmsu dupes /tmp/song.mp3\n/tmp/copy of song.mp3"},
-	Options: Options{Option{"--recursive", "-r", "recursively check directory contents", false, ""}},
-	Exec:    dupesExec,
+		"$ tmsu dupes /tmp/song.mp3\n/tmp/copy of song.mp3",
+		"$ tmsu dupes --algorithm=CRC64"},
+	Options: Options{Option{"--recursive", "-r", "recursively check directory contents", false, ""},
+		Option{"--algorithm", "-a", "compare fingerprints under ALGORITHM", true, ""},
+		Option{"--exclude-hardlinks", "-x", "do not report hard links to the same file as duplicates", false, ""}},
+	Exec: dupesExec,
 }
 
 func dupesExec(store *storage.Storage, options Options, args []string) error {
 	recursive := options.HasOption("--recursive")
+	excludeHardLinks := options.HasOption("--exclude-hardlinks")
+
+	algorithm := ""
+	if options.HasOption("--algorithm") {
+		algorithm = options.Get("--algorithm").Argument
+	}
 
 	switch len(args) {
 	case 0:
-		findDuplicatesInDb(store)
+		return findDuplicatesInDb(store, algorithm, excludeHardLinks)
 	default:
-		return findDuplicatesOf(store, args, recursive)
+		return findDuplicatesOf(store, args, recursive, algorithm, excludeHardLinks)
 	}
-
-	return nil
 }
 
-func findDuplicatesInDb(store *storage.Storage) error {
+func findDuplicatesInDb(store *storage.Storage, algorithm string, excludeHardLinks bool) error {
 	log.Info(2, "identifying duplicate files.")
 
-	fileSets, err := store.DuplicateFiles()
+	fileSets, err := store.DuplicateFilesUsing(algorithm)
 	if err != nil {
 		return fmt.Errorf("could not identify duplicate files: %v", err)
 	}
 
 	log.Infof(2, "found %v sets of duplicate files.", len(fileSets))
 
-	for index, fileSet := range fileSets {
-		if index > 0 {
+	first := true
+	for _, fileSet := range fileSets {
+		if excludeHardLinks {
+			fileSet, err = withoutHardLinks(store, fileSet, nil)
+			if err != nil {
+				return err
+			}
+
+			if len(fileSet) < 2 {
+				continue
+			}
+		}
+
+		if first {
+			first = false
+		} else {
 			fmt.Println()
 		}
 
@@ -79,15 +104,24 @@ func findDuplicatesInDb(store *storage.Storage) error {
 	return nil
 }
 
-func findDuplicatesOf(store *storage.Storage, paths []string, recursive bool) error {
-	fingerprintAlgorithm, err := store.SettingAsString("fingerprintAlgorithm")
+func findDuplicatesOf(store *storage.Storage, paths []string, recursive bool, algorithm string, excludeHardLinks bool) error {
+	fingerprintAlgorithm := algorithm
+	if fingerprintAlgorithm == "" {
+		var err error
+		fingerprintAlgorithm, err = store.SettingAsString("fingerprintAlgorithm")
+		if err != nil {
+			return err
+		}
+	}
+
+	policy, err := symlinkPolicy(store, nil)
 	if err != nil {
 		return err
 	}
 
 	wereErrors := false
 	for _, path := range paths {
-		_, err := os.Stat(path)
+		_, err := policy.Stat(path)
 		if err != nil {
 			switch {
 			case os.IsNotExist(err):
@@ -109,7 +143,7 @@ func findDuplicatesOf(store *storage.Storage, paths []string, recursive bool) er
 	}
 
 	if recursive {
-		p, err := filesystem.Enumerate(paths...)
+		p, err := filesystem.EnumerateUsing(policy, paths...)
 		if err != nil {
 			return fmt.Errorf("could not enumerate paths: %v", err)
 		}
@@ -124,7 +158,7 @@ func findDuplicatesOf(store *storage.Storage, paths []string, recursive bool) er
 	for _, path := range paths {
 		log.Infof(2, "%v: identifying duplicate files.", path)
 
-		fp, err := fingerprint.Create(path, fingerprintAlgorithm)
+		fp, err := createFingerprint(path, fingerprintAlgorithm, policy)
 		if err != nil {
 			return fmt.Errorf("%v: could not create fingerprint: %v", path, err)
 		}
@@ -133,7 +167,7 @@ func findDuplicatesOf(store *storage.Storage, paths []string, recursive bool) er
 			continue
 		}
 
-		files, err := store.FilesByFingerprint(fp)
+		files, err := store.FilesByFingerprintUsing(algorithm, fp)
 		if err != nil {
 			return fmt.Errorf("%v: could not retrieve files matching fingerprint '%v': %v", path, fp, err)
 		}
@@ -146,6 +180,20 @@ func findDuplicatesOf(store *storage.Storage, paths []string, recursive bool) er
 		// filter out the file we're searching on
 		dupes := files.Where(func(file *entities.File) bool { return file.Path() != absPath })
 
+		if excludeHardLinks {
+			seen := make(map[inodeKey]bool)
+			if stat, err := policy.Stat(path); err == nil {
+				if dev, inode, ok := filesystem.Inode(stat); ok {
+					seen[inodeKey{dev, inode}] = true
+				}
+			}
+
+			dupes, err = withoutHardLinks(store, dupes, seen)
+			if err != nil {
+				return err
+			}
+		}
+
 		if len(paths) > 1 && len(dupes) > 0 {
 			if first {
 				first = false
@@ -169,3 +217,38 @@ func findDuplicatesOf(store *storage.Storage, paths []string, recursive bool) er
 
 	return nil
 }
+
+type inodeKey struct {
+	dev   uint64
+	inode uint64
+}
+
+// Removes all but the first of the files that are hard links to the same file,
+// as well as any that are hard links to the files already seen. Files whose
+// device and inode numbers are not known are retained.
+func withoutHardLinks(store *storage.Storage, files entities.Files, seen map[inodeKey]bool) (entities.Files, error) {
+	if seen == nil {
+		seen = make(map[inodeKey]bool, len(files))
+	}
+
+	result := make(entities.Files, 0, len(files))
+	for _, file := range files {
+		dev, inode, err := store.FileInode(file.Id)
+		if err != nil {
+			return nil, fmt.Errorf("%v: could not retrieve inode: %v", file.Path(), err)
+		}
+
+		if dev != 0 || inode != 0 {
+			key := inodeKey{dev, inode}
+			if seen[key] {
+				log.Infof(2, "%v: excluding hard link.", file.Path())
+				continue
+			}
+			seen[key] = true
+		}
+
+		result = append(result, file)
+	}
+
+	return result, nil
+}
@@ -350,3 +350,127 @@ func TestDupesNoneUntaggedFile(test *testing.T) {
 	bytes, err := ioutil.ReadAll(outFile)
 	compareOutput(test, "", string(bytes))
 }
+
+func TestDupesByAlgorithm(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.UpdateSetting("extraFingerprintAlgorithms", "CRC64"); err != nil {
+		test.Fatal(err)
+	}
+
+	for path, contents := range map[string]string{"/tmp/tmsu/a": "hello", "/tmp/tmsu/b": "hello", "/tmp/tmsu/c": "other"} {
+		if err := createFile(path, contents); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+
+		if err := TagCommand.Exec(store, Options{}, []string{path, "a"}); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// test
+
+	if err := DupesCommand.Exec(store, Options{Option{"--algorithm", "-a", "", true, "CRC64"}}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := DupesCommand.Exec(store, Options{Option{"--algorithm", "-a", "", true, "MD5"}}, []string{}); err == nil {
+		test.Fatal("Expected error for algorithm that is not recorded")
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "Set of 2 duplicates:\n  /tmp/tmsu/a\n  /tmp/tmsu/b\n", string(bytes))
+
+	// a change to the primary fingerprint discards the others
+	file, err := store.FileByPath("/tmp/tmsu/a")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.UpdateFile(file.Id, file.Path(), fingerprint.Fingerprint("abc"), file.ModTime, file.Size, file.IsDir); err != nil {
+		test.Fatal(err)
+	}
+
+	fingerprints, err := store.FileFingerprints(file.Id)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(fingerprints) != 0 {
+		test.Fatalf("Expected no additional fingerprints but are %v", len(fingerprints))
+	}
+}
+
+func TestDupesExcludeHardLinks(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	if err := os.Link("/tmp/tmsu/a", "/tmp/tmsu/b"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/b")
+
+	if err := createFile("/tmp/tmsu/c", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/c")
+
+	if err := TagCommand.Exec(store, Options{Option{"--tags", "-t", "", true, "a"}}, []string{"/tmp/tmsu/a", "/tmp/tmsu/b", "/tmp/tmsu/c"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := DupesCommand.Exec(store, Options{Option{"--exclude-hardlinks", "-x", "", false, ""}}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := DupesCommand.Exec(store, Options{Option{"--exclude-hardlinks", "-x", "", false, ""}}, []string{"/tmp/tmsu/a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "Set of 2 duplicates:\n  /tmp/tmsu/a\n  /tmp/tmsu/c\n/tmp/tmsu/c\n", string(bytes))
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"tmsu/common/log"
+	"tmsu/storage"
+)
+
+var EditCommand = Command{
+	Name:     "edit",
+	Synopsis: "Edit a file's tags in a text editor",
+	Usages:   []string{"tmsu edit FILE"},
+	Description: `Opens a text editor listing the tags applied to FILE, one per line. Tags added to the list are applied to FILE and tags removed from the list are removed from FILE when the editor exits.
+
+The editor is taken from the VISUAL or EDITOR environment variables, falling back to 'vi'.`,
+	Examples: []string{"$ tmsu edit mountain.jpg",
+		"$ EDITOR=nano tmsu edit mountain.jpg"},
+	Exec: editExec,
+}
+
+func editExec(store *storage.Storage, options Options, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("a single file to edit must be specified")
+	}
+
+	return editTags(store, args[0], runEditor)
+}
+
+// unexported
+
+func editTags(store *storage.Storage, path string, edit func(string) error) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("%v: could not get absolute path: %v", path, err)
+	}
+
+	if _, err := os.Lstat(absPath); err != nil {
+		return fmt.Errorf("%v: could not stat file: %v", path, err)
+	}
+
+	tagNames, impliedTagNames, err := editableTagNames(store, absPath)
+	if err != nil {
+		return err
+	}
+
+	tempFile, err := ioutil.TempFile("", "tmsu-edit-")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file: %v", err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	fmt.Fprintf(tempFile, "# Tags for %v\n", absPath)
+	fmt.Fprintln(tempFile, "# One TAG or TAG=VALUE per line. Lines starting with '#' are ignored.")
+	if len(impliedTagNames) > 0 {
+		fmt.Fprintf(tempFile, "# Implied: %v\n", strings.Join(impliedTagNames, " "))
+	}
+	for _, tagName := range tagNames {
+		fmt.Fprintln(tempFile, tagName)
+	}
+	tempFile.Close()
+
+	if err := edit(tempFile.Name()); err != nil {
+		return fmt.Errorf("could not edit tags: %v", err)
+	}
+
+	editedTagNames, err := readEditedTagNames(tempFile.Name())
+	if err != nil {
+		return err
+	}
+
+	return reconcileTags(store, absPath, tagNames, editedTagNames)
+}
+
+// Applies and removes tags so that the file's explicit tags change from
+// currentTagNames to wantedTagNames.
+func reconcileTags(store *storage.Storage, absPath string, currentTagNames, wantedTagNames []string) error {
+	added := difference(wantedTagNames, currentTagNames)
+	removed := difference(currentTagNames, wantedTagNames)
+
+	// add before removing so that the file is not dropped from the database
+	// when all of its original tags are replaced
+	if len(added) > 0 {
+		log.Infof(2, "%v: applying tags %v", absPath, strings.Join(added, " "))
+
+		policy, err := symlinkPolicy(store, nil)
+		if err != nil {
+			return err
+		}
+
+		if err := tagPaths(store, added, []string{absPath}, false, false, false, policy); err != nil {
+			return err
+		}
+	}
+
+	if len(removed) > 0 {
+		log.Infof(2, "%v: removing tags %v", absPath, strings.Join(removed, " "))
+
+		if err := untagPaths(store, []string{absPath}, removed, false, false, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Retrieves the explicitly applied tags, which can be edited, and the
+// implied tags, which cannot.
+func editableTagNames(store *storage.Storage, absPath string) ([]string, []string, error) {
+	file, err := store.FileByPath(absPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%v: could not retrieve file: %v", absPath, err)
+	}
+	if file == nil {
+		return []string{}, []string{}, nil
+	}
+
+	tagNames, err := tagNamesForFile(store, file.Id, true, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	allTagNames, err := tagNamesForFile(store, file.Id, false, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tagNames, difference(allTagNames, tagNames), nil
+}
+
+func readEditedTagNames(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read edited tags: %v", err)
+	}
+	defer file.Close()
+
+	tagNames := make([]string, 0, 10)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		for _, tagName := range strings.Fields(line) {
+			if !containsTag(tagNames, tagName) {
+				tagNames = append(tagNames, tagName)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read edited tags: %v", err)
+	}
+
+	sort.Strings(tagNames)
+
+	return tagNames, nil
+}
+
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	words := strings.Fields(editor)
+	command := exec.Command(words[0], append(words[1:], path)...)
+	command.Stdin = os.Stdin
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+
+	return command.Run()
+}
+
+// The items in a that are not in b.
+func difference(a, b []string) []string {
+	result := make([]string, 0, len(a))
+	for _, item := range a {
+		if !containsTag(b, item) {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestEditAppliesChanges(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "apple", "banana"}); err != nil {
+		test.Fatal(err)
+	}
+
+	var original string
+	edit := func(path string) error {
+		bytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		original = string(bytes)
+
+		return ioutil.WriteFile(path, []byte("# comment\nbanana\ncherry year=2015\n"), 0600)
+	}
+
+	// test
+
+	if err := editTags(store, "/tmp/tmsu/a", edit); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	expected := "# Tags for /tmp/tmsu/a\n# One TAG or TAG=VALUE per line. Lines starting with '#' are ignored.\napple\nbanana\n"
+	if original != expected {
+		test.Fatalf("Unexpected edit buffer:\n%v", original)
+	}
+
+	file, err := store.FileByPath("/tmp/tmsu/a")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagNames, err := tagNamesForFile(store, file.Id, true, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if len(tagNames) != 3 || tagNames[0] != "banana" || tagNames[1] != "cherry" || tagNames[2] != "year=2015" {
+		test.Fatalf("Unexpected tags: %v", tagNames)
+	}
+}
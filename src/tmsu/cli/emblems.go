@@ -0,0 +1,172 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"tmsu/common/filesystem"
+	"tmsu/common/log"
+	"tmsu/storage"
+)
+
+var EmblemsCommand = Command{
+	Name:     "emblems",
+	Synopsis: "Show tags as file manager emblems",
+	Usages:   []string{"tmsu emblems [OPTION]... [FILE]..."},
+	Description: `Writes the emblems for the tags of each FILE to the metadata store of the desktop's file manager, so that tagged files are badged in GNOME Files (Nautilus) or listed with their tags in KDE Dolphin. If no FILE is specified then every file in the database is updated.
+
+The emblems are chosen by the 'emblemTags' setting, which holds whitespace-separated rules of the form TAG[=VALUE]:EMBLEM. A rule without a value applies whatever the tag's value. Implied tags are included. A file with no matching tags has its emblems removed.
+
+The FORMAT determines where the emblems are written:
+
+  gio   the 'metadata::emblems' GVfs attribute, as used by Nautilus, Nemo
+        and Caja, where EMBLEM names an icon, e.g. 'emblem-favorite'
+  xdg   the 'user.xdg.tags' extended attribute, as used by Dolphin, where
+        EMBLEM is the name of the tag shown
+
+The 'gio' format requires the 'gio' program; the 'xdg' format requires a filesystem that supports extended attributes.`,
+	Examples: []string{"$ tmsu config emblemTags='favourite:emblem-favorite status=todo:emblem-important'\n$ tmsu emblems",
+		"$ tmsu emblems --format=xdg ~/Documents/report.pdf"},
+	Options: Options{{"--format", "-f", "where to write the emblems: gio (default) or xdg", true, ""}},
+	Exec:    emblemsExec,
+}
+
+func emblemsExec(store *storage.Storage, options Options, args []string) error {
+	var writer func(path string, emblems []string) error
+	format := "gio"
+	if options.HasOption("--format") {
+		format = options.Get("--format").Argument
+	}
+	switch format {
+	case "gio":
+		writer = writeGioEmblems
+	case "xdg":
+		writer = writeXdgEmblems
+	default:
+		return usageError{fmt.Errorf("invalid format '%v': must be 'gio' or 'xdg'", format)}
+	}
+
+	rules, err := store.EmblemRules()
+	if err != nil {
+		return fmt.Errorf("could not retrieve setting 'emblemTags': %v", err)
+	}
+	if len(rules) == 0 {
+		return fmt.Errorf("no emblems are configured: see the 'emblemTags' setting")
+	}
+
+	paths := args
+	if len(paths) == 0 {
+		files, err := store.Files()
+		if err != nil {
+			return fmt.Errorf("could not retrieve files: %v", err)
+		}
+		files = localFiles(files)
+
+		paths = make([]string, len(files))
+		for index, file := range files {
+			paths[index] = file.Path()
+		}
+	}
+
+	wereErrors := false
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not get absolute path: %v", path, err)
+		}
+
+		file, err := store.FileByPath(absPath)
+		if err != nil {
+			return fmt.Errorf("%v: could not retrieve file: %v", path, err)
+		}
+
+		var tagNames []string
+		if file != nil {
+			tagNames, err = tagNamesForFile(store, file.Id, false, false)
+			if err != nil {
+				return err
+			}
+		}
+
+		emblems := emblemsForTags(rules, tagNames)
+
+		log.Infof(2, "%v: writing emblems %v", path, strings.Join(emblems, ", "))
+
+		if err := writer(absPath, emblems); err != nil {
+			log.Warnf("%v: could not write emblems: %v", path, err)
+			wereErrors = true
+		}
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+// unexported
+
+// Determines the emblems for the tags, in the order of the rules.
+func emblemsForTags(rules []storage.EmblemRule, tagNames []string) []string {
+	emblems := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		if containsTag(emblems, rule.Emblem) {
+			continue
+		}
+
+		for _, tagName := range tagNames {
+			if rule.Matches(tagName) {
+				emblems = append(emblems, rule.Emblem)
+				break
+			}
+		}
+	}
+
+	return emblems
+}
+
+func writeGioEmblems(path string, emblems []string) error {
+	var command *exec.Cmd
+	if len(emblems) == 0 {
+		command = exec.Command("gio", "set", "-t", "unset", path, "metadata::emblems")
+	} else {
+		command = exec.Command("gio", append([]string{"set", "-t", "stringv", path, "metadata::emblems"}, emblems...)...)
+	}
+
+	if output, err := command.CombinedOutput(); err != nil {
+		if len(output) > 0 {
+			return fmt.Errorf("%v", strings.TrimSpace(string(output)))
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func writeXdgEmblems(path string, emblems []string) error {
+	if len(emblems) == 0 {
+		return filesystem.RemoveXattr(path, "user.xdg.tags")
+	}
+
+	return filesystem.SetXattr(path, "user.xdg.tags", []byte(strings.Join(emblems, ",")))
+}
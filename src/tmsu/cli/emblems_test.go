@@ -0,0 +1,57 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestEmblemsForTags(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.UpdateSetting("emblemTags", "favourite:emblem-favorite status=todo:emblem-important year:emblem-documents"); err != nil {
+		test.Fatal(err)
+	}
+
+	rules, err := store.EmblemRules()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	emblems := emblemsForTags(rules, []string{"favourite", "status=done", "year=2014"})
+
+	// validate
+
+	if strings.Join(emblems, " ") != "emblem-favorite emblem-documents" {
+		test.Fatalf("unexpected emblems: %v", emblems)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"tmsu/common/log"
+)
+
+// The exit codes, which scripts may rely upon.
+const (
+	exitSuccess     = 0 // the command succeeded
+	exitFailure     = 1 // the command failed for some other reason
+	exitPartial     = 2 // the command failed for some of the items it was given
+	exitNoDatabase  = 3 // the database could not be found or opened
+	exitNoSuchTag   = 4 // a tag that must exist does not
+	exitQuerySyntax = 5 // the query could not be parsed
+	exitUsage       = 6 // the command or its options are invalid
+)
+
+type noDatabaseError struct {
+	Reason error
+}
+
+func (err noDatabaseError) Error() string {
+	return err.Reason.Error()
+}
+
+type noSuchTagError struct {
+	Name string
+}
+
+func (err noSuchTagError) Error() string {
+	return fmt.Sprintf("no such tag '%v'", err.Name)
+}
+
+type querySyntaxError struct {
+	Reason error
+}
+
+func (err querySyntaxError) Error() string {
+	return fmt.Sprintf("could not parse query: %v", err.Reason)
+}
+
+type usageError struct {
+	Reason error
+}
+
+func (err usageError) Error() string {
+	return err.Reason.Error()
+}
+
+// Determines the exit code for the error and the name by which the
+// --porcelain format identifies it.
+func exitCodeFor(err error) (int, string) {
+	switch err.(type) {
+	case nil:
+		return exitSuccess, ""
+	case noDatabaseError:
+		return exitNoDatabase, "no-database"
+	case noSuchTagError:
+		return exitNoSuchTag, "no-such-tag"
+	case querySyntaxError:
+		return exitQuerySyntax, "query-syntax"
+	case usageError:
+		return exitUsage, "usage"
+	}
+
+	if err == errBlank {
+		return exitPartial, "partial"
+	}
+
+	return exitFailure, "failure"
+}
+
+// Reports the error, if any, and exits with the corresponding exit code.
+func exit(err error) {
+	code, kind := exitCodeFor(err)
+
+	switch {
+	case err == nil:
+	case log.Porcelain:
+		log.Error(code, kind, err.Error())
+	case err != errBlank:
+		log.Warn(err.Error())
+	}
+
+	os.Exit(code)
+}
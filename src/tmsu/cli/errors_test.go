@@ -0,0 +1,66 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestExitCodes(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	// test
+
+	noSuchTagErr := FilesCommand.Exec(store, Options{}, []string{"banana"})
+	querySyntaxErr := FilesCommand.Exec(store, Options{}, []string{"banana and"})
+
+	// validate
+
+	expectations := []struct {
+		err  error
+		code int
+		kind string
+	}{
+		{nil, exitSuccess, ""},
+		{errBlank, exitPartial, "partial"},
+		{errors.New("oops"), exitFailure, "failure"},
+		{noSuchTagErr, exitNoSuchTag, "no-such-tag"},
+		{querySyntaxErr, exitQuerySyntax, "query-syntax"},
+		{usageError{errors.New("invalid command 'x'")}, exitUsage, "usage"},
+		{noDatabaseError{errors.New("could not find database")}, exitNoDatabase, "no-database"},
+	}
+
+	for _, expectation := range expectations {
+		code, kind := exitCodeFor(expectation.err)
+		if code != expectation.code || kind != expectation.kind {
+			test.Fatalf("Expected exit code %v (%v) for '%v' but got %v (%v).", expectation.code, expectation.kind, expectation.err, code, kind)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+	"tmsu/common/log"
+	"tmsu/entities"
+	"tmsu/storage"
+)
+
+var EventsCommand = Command{
+	Name:     "events",
+	Synopsis: "List changes made to the database",
+	Usages:   []string{"tmsu events [OPTION]..."},
+	Description: `Lists the changes made to the database: files being tagged, untagged, added, moved or removed, and tags being created, renamed or deleted.
+
+Each event is shown with its sequence number, which an external indexer can record and later pass to --since to retrieve only the events that have occurred since.
+
+With --follow the command does not exit but continues to list changes as they are made.
+
+Events are retained until explicitly pruned with --prune.`,
+	Examples: []string{"$ tmsu events",
+		"$ tmsu events --since 1500",
+		"$ tmsu events --follow",
+		"$ tmsu events --prune 1500  # forget events up to 1500"},
+	Options: Options{{"--since", "-s", "list only events after sequence number SEQ", true, ""},
+		{"--follow", "-f", "continue to list events as they occur", false, ""},
+		{"--prune", "", "delete the events up to and including sequence number SEQ", true, ""}},
+	Exec: eventsExec,
+}
+
+func eventsExec(store *storage.Storage, options Options, args []string) error {
+	follow := options.HasOption("--follow")
+
+	if options.HasOption("--prune") {
+		seq, err := parseEventSeq(options.Get("--prune").Argument)
+		if err != nil {
+			return err
+		}
+
+		if err := store.DeleteEventsUpTo(seq); err != nil {
+			return fmt.Errorf("could not delete events: %v", err)
+		}
+
+		return nil
+	}
+
+	var seq uint
+	if options.HasOption("--since") {
+		var err error
+		seq, err = parseEventSeq(options.Get("--since").Argument)
+		if err != nil {
+			return err
+		}
+	}
+
+	seq, err := listEvents(store, seq)
+	if err != nil {
+		return err
+	}
+
+	if follow {
+		return followEvents(store, seq)
+	}
+
+	return nil
+}
+
+// unexported
+
+const eventPollInterval = time.Second
+
+func parseEventSeq(text string) (uint, error) {
+	seq, err := strconv.ParseUint(text, 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sequence number '%v'", text)
+	}
+
+	return uint(seq), nil
+}
+
+// Lists the events after the specified sequence number, returning the
+// sequence number of the last event listed.
+func listEvents(store *storage.Storage, seq uint) (uint, error) {
+	events, err := store.EventsSince(seq)
+	if err != nil {
+		return 0, fmt.Errorf("could not retrieve events: %v", err)
+	}
+
+	for _, event := range events {
+		fmt.Printf("%v %v %v %v\n", event.Seq, event.Time.Local().Format("2006-01-02T15:04:05"), event.Type, describeEvent(event))
+		seq = event.Seq
+	}
+
+	return seq, nil
+}
+
+// Polls for new events. This does not return unless there is an error.
+func followEvents(store *storage.Storage, seq uint) error {
+	// the transaction must be closed so as to see changes made by others: the
+	// changes made so far, e.g. by earlier commands read from standard input,
+	// are kept
+	if err := store.Commit(); err != nil {
+		return err
+	}
+
+	pollErr := pollEvents(store, seq)
+
+	if err := store.Begin(); err != nil {
+		return err
+	}
+
+	return pollErr
+}
+
+func pollEvents(store *storage.Storage, seq uint) error {
+	for {
+		time.Sleep(eventPollInterval)
+
+		log.Info(3, "polling for events")
+
+		var err error
+		seq, err = listEvents(store, seq)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func describeEvent(event *entities.Event) string {
+	switch event.Type {
+	case entities.EventTag, entities.EventUntag:
+		if event.ValueName == "" {
+			return fmt.Sprintf("%v %v", event.Path, event.TagName)
+		}
+		return fmt.Sprintf("%v %v=%v", event.Path, event.TagName, event.ValueName)
+	case entities.EventFileMove:
+		return fmt.Sprintf("%v -> %v", event.Previous, event.Path)
+	case entities.EventTagRename:
+		return fmt.Sprintf("%v -> %v", event.Previous, event.TagName)
+	case entities.EventTagAdd, entities.EventTagRemove:
+		return event.TagName
+	default:
+		return event.Path
+	}
+}
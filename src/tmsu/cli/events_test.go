@@ -0,0 +1,146 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestEventsSince(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/a", "a"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "apple"}); err != nil {
+		test.Fatal(err)
+	}
+
+	seq, err := store.LatestEventSeq()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "year=2015"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := RenameCommand.Exec(store, Options{}, []string{"apple", "pear"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := UntagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "pear", "year=2015"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	since := Option{"--since", "-s", "", true, strconv.FormatUint(uint64(seq), 10)}
+	if err := EventsCommand.Exec(store, Options{since}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// drop the sequence numbers and times
+	lines := strings.Split(strings.TrimSpace(string(bytes)), "\n")
+	for index, line := range lines {
+		lines[index] = strings.SplitN(line, " ", 3)[2]
+	}
+
+	compareOutput(test, `tag-add year
+tag /tmp/tmsu/a year=2015
+tag-rename apple -> pear
+untag /tmp/tmsu/a pear
+untag /tmp/tmsu/a year=2015
+file-remove /tmp/tmsu/a`, strings.Join(lines, "\n"))
+}
+
+func TestFollowEventsKeepsEarlierChanges(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+
+	// tagging would commit the transaction to run the hooks
+	if _, err := store.AddTag("apple"); err != nil {
+		test.Fatal(err)
+	}
+
+	// a cancelled handle stops following at the first poll
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// test
+
+	if err := followEvents(store.WithContext(ctx), 0); err == nil {
+		test.Fatal("Expected following with a cancelled context to fail.")
+	}
+
+	// validate
+
+	if err := store.Rollback(); err != nil {
+		test.Fatal(err)
+	}
+
+	tag, err := store.TagByName("apple")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if tag == nil {
+		test.Fatal("The earlier change was discarded.")
+	}
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"tmsu/common/checksum"
+	"tmsu/common/fingerprint"
+	"tmsu/common/log"
+	"tmsu/common/path"
+	"tmsu/query"
+	"tmsu/storage"
+)
+
+var ExportCommand = Command{
+	Name:     "export",
+	Synopsis: "Export the tags of files as a matrix",
+	Usages: []string{"tmsu export [OPTION]... --csv [QUERY]",
+		"tmsu export --implications",
+		"tmsu export --checksums [QUERY]"},
+	Description: `Writes the files matching QUERY, or all files if no QUERY is specified, and their tags as a CSV matrix for analysis in a spreadsheet or similar.
+
+The first column holds the path of each file and the remaining columns one tag each. A cell is empty where the file does not have the tag, 'yes' where it has the tag without a value and otherwise the tag's values separated by spaces. (A value that is itself 'yes' is written '=yes'.)
+
+The matrix can be edited and read back with 'import --csv'.
+
+With --implications the tag implications are instead written as rules, one line per implying tag, of the form 'TAG[=VALUE] -> IMPL, IMPL...'. The rules can be read back, into this or another database, with 'import --implications'.
+
+With --checksums a SHA-256 checksum manifest is instead written for the files matching QUERY, in the format of 'sha256sum', for verification by 'sha256sum --check' or other tools. The checksums are taken from the fingerprints recorded in the database where the 'fingerprintAlgorithm' or 'extraFingerprintAlgorithms' setting provides them, and otherwise calculated. Directories are omitted. The manifest can be read back with 'import --checksums'.`,
+	Examples: []string{"$ tmsu export --csv music\npath,genre,music,year\n./a.mp3,rock,yes,2015\n./b.mp3,,yes,\n",
+		"$ tmsu export --csv --explicit > tags.csv",
+		"$ tmsu export --implications\nmp3 -> music\nmusic -> audio, media",
+		"$ tmsu export --checksums iso > SHA256SUMS\n$ sha256sum --check SHA256SUMS"},
+	Options: Options{{"--csv", "", "write a CSV matrix", false, ""},
+		{"--implications", "", "write the tag implications as rules", false, ""},
+		{"--checksums", "", "write a SHA-256 checksum manifest", false, ""},
+		{"--explicit", "-e", "do not include implied tags", false, ""}},
+	Exec: exportExec,
+}
+
+func exportExec(store *storage.Storage, options Options, args []string) error {
+	if options.HasOption("--implications") {
+		if len(args) > 0 {
+			return fmt.Errorf("a query cannot be used with --implications")
+		}
+
+		return exportImplications(store)
+	}
+	if !options.HasOption("--csv") && !options.HasOption("--checksums") {
+		return usageError{fmt.Errorf("an export format must be specified: --csv, --implications or --checksums")}
+	}
+
+	explicitOnly := options.HasOption("--explicit")
+
+	expression, err := store.ParseQuery(strings.Join(args, " "))
+	if err != nil {
+		return querySyntaxError{err}
+	}
+
+	if options.HasOption("--checksums") {
+		return exportChecksums(store, expression, explicitOnly)
+	}
+
+	log.Info(2, "querying database")
+
+	files, err := store.QueryFiles(expression, "", explicitOnly)
+	if err != nil {
+		return fmt.Errorf("could not query files: %v", err)
+	}
+
+	rows := make([]map[string][]string, len(files))
+	columns := make(map[string]bool)
+	for index, file := range files {
+		tagNames, err := tagNamesForFile(store, file.Id, explicitOnly, false)
+		if err != nil {
+			return err
+		}
+
+		cells := make(map[string][]string, len(tagNames))
+		for _, tagName := range tagNames {
+			name, cellEntry := matrixEntry(tagName)
+			cells[name] = append(cells[name], cellEntry)
+			columns[name] = true
+		}
+
+		rows[index] = cells
+	}
+
+	tagNames := make([]string, 0, len(columns))
+	for tagName := range columns {
+		tagNames = append(tagNames, tagName)
+	}
+	sort.Strings(tagNames)
+
+	writer := csv.NewWriter(os.Stdout)
+
+	if err := writer.Write(append([]string{"path"}, tagNames...)); err != nil {
+		return fmt.Errorf("could not write matrix: %v", err)
+	}
+
+	for index, file := range files {
+		record := make([]string, len(tagNames)+1)
+		record[0] = path.Rel(file.Path())
+		for column, tagName := range tagNames {
+			record[column+1] = strings.Join(rows[index][tagName], " ")
+		}
+
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("could not write matrix: %v", err)
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("could not write matrix: %v", err)
+	}
+
+	return nil
+}
+
+// unexported
+
+// Writes the tag implications as rules of the form 'TAG[=VALUE] -> IMPL, IMPL...'.
+func exportImplications(store *storage.Storage) error {
+	log.Info(2, "retrieving tag implications")
+
+	implications, err := store.Implications()
+	if err != nil {
+		return fmt.Errorf("could not retrieve implications: %v", err)
+	}
+
+	tagNames := make([]string, 0, len(implications))
+	impliedByTag := make(map[string][]string, len(implications))
+	for _, implication := range implications {
+		name := implication.ImplyingName()
+		if _, ok := impliedByTag[name]; !ok {
+			tagNames = append(tagNames, name)
+		}
+
+		impliedByTag[name] = append(impliedByTag[name], implication.ImpliedTag.Name)
+	}
+
+	for _, tagName := range tagNames {
+		fmt.Printf("%v -> %v\n", tagName, strings.Join(impliedByTag[tagName], ", "))
+	}
+
+	return nil
+}
+
+// Writes a SHA-256 checksum manifest for the files matching the query.
+func exportChecksums(store *storage.Storage, expression query.Expression, explicitOnly bool) error {
+	log.Info(2, "querying database")
+
+	files, err := store.QueryFiles(expression, "", explicitOnly)
+	if err != nil {
+		return fmt.Errorf("could not query files: %v", err)
+	}
+
+	algorithm, err := store.SettingAsString("fingerprintAlgorithm")
+	if err != nil {
+		return err
+	}
+
+	wereErrors := false
+	for _, file := range files {
+		if file.IsDir {
+			continue
+		}
+
+		sum := file.Fingerprint
+		if !fingerprint.IsSha256Sum(algorithm, file.Size) {
+			fingerprints, err := store.FileFingerprints(file.Id)
+			if err != nil {
+				return fmt.Errorf("%v: could not retrieve fingerprints: %v", file.Path(), err)
+			}
+
+			sum = fingerprints["SHA256"]
+		}
+
+		// the algorithm may have changed since the file was fingerprinted
+		if len(sum) != sha256.Size*2 {
+			if path.IsUrl(file.Path()) {
+				log.Warnf("%v: no checksum recorded", file.Path())
+				wereErrors = true
+				continue
+			}
+
+			log.Infof(2, "%v: calculating checksum", file.Path())
+
+			sum, err = fingerprint.Create(file.Path(), "SHA256")
+			if err != nil {
+				return fmt.Errorf("%v: could not calculate checksum: %v", file.Path(), err)
+			}
+			if sum == fingerprint.EMPTY {
+				log.Warnf("%v: no such file", file.Path())
+				wereErrors = true
+				continue
+			}
+		}
+
+		if err := checksum.WriteEntry(os.Stdout, checksum.Entry{Checksum: string(sum), Path: path.Rel(file.Path())}); err != nil {
+			return fmt.Errorf("could not write checksums: %v", err)
+		}
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+// Splits TAG or TAG=VALUE into the tag name and the entry for the matrix cell.
+func matrixEntry(tagName string) (string, string) {
+	index := strings.Index(tagName, "=")
+	if index == -1 {
+		return tagName, "yes"
+	}
+
+	name, value := tagName[:index], tagName[index+1:]
+	if value == "yes" {
+		return name, "=yes"
+	}
+
+	return name, value
+}
+
+// Converts an entry from the matrix cell for the tag to TAG or TAG=VALUE.
+func matrixTagName(tagName, cellEntry string) string {
+	switch {
+	case cellEntry == "yes":
+		return tagName
+	case strings.HasPrefix(cellEntry, "="):
+		return tagName + cellEntry
+	default:
+		return tagName + "=" + cellEntry
+	}
+}
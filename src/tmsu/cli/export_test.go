@@ -0,0 +1,176 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestExportCsv(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/a", "a"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	if err := createFile("/tmp/tmsu/b", "b"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/b")
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "music", "year=2015", "year=2016", "live=yes"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/b", "music"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := ExportCommand.Exec(store, Options{Option{"--csv", "", "", false, ""}}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "path,live,music,year\n/tmp/tmsu/a,=yes,yes,2015 2016\n/tmp/tmsu/b,,yes,\n", string(bytes))
+}
+
+func TestExportImplications(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, name := range []string{"mp3", "music", "audio", "media"} {
+		if _, err := store.AddTag(name); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	if err := ImplyCommand.Exec(store, Options{}, []string{"mp3", "music", "audio"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := ImplyCommand.Exec(store, Options{}, []string{"music", "media"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := ExportCommand.Exec(store, Options{Option{"--implications", "", "", false, ""}}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "mp3 -> audio, music\nmusic -> media\n", string(bytes))
+}
+
+func TestExportChecksums(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	for path, content := range map[string]string{"/tmp/tmsu/a": "a", "/tmp/tmsu/b": "b"} {
+		if err := createFile(path, content); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "music"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// a fingerprint recorded under another algorithm is not a checksum
+	if _, err := store.UpdateSetting("fingerprintAlgorithm", "MD5"); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/b", "music"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.UpdateSetting("fingerprintAlgorithm", "dynamic:SHA256"); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := ExportCommand.Exec(store, Options{Option{"--checksums", "", "", false, ""}}, []string{"music"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb  /tmp/tmsu/a\n3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d  /tmp/tmsu/b\n", string(bytes))
+}
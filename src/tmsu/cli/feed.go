@@ -0,0 +1,199 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"tmsu/common/log"
+	"tmsu/entities"
+	"tmsu/query"
+	"tmsu/storage"
+)
+
+const feedPath = "/feed"
+const defaultFeedPeriod = "7d"
+const feedEntryLimit = 100
+
+// An Atom feed of the files most recently tagged.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	Id      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	Id         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Link       atomLink       `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Summary    string         `xml:"summary"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// Serves an Atom feed of the files tagged within a recent period, optionally
+// restricted to those matching a query. The query is taken from the 'query'
+// parameter and the period, e.g. '2d' or '12h', from the 'since' parameter.
+func newFeedHandler(store *storage.Storage) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		queryText := request.URL.Query().Get("query")
+
+		since := request.URL.Query().Get("since")
+		if since == "" {
+			since = defaultFeedPeriod
+		}
+
+		period, err := parseDuration(since)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var feed *atomFeed
+		err = store.Exclusively(func() error {
+			feed, err = recentlyTaggedFeed(store, queryText, time.Now().Add(-period))
+			return err
+		})
+
+		if err != nil {
+			switch err.(type) {
+			case querySyntaxError, noSuchTagError:
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+			default:
+				log.Warnf("could not build feed: %v", err)
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+			}
+
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		writer.Write([]byte(xml.Header))
+
+		encoder := xml.NewEncoder(writer)
+		encoder.Indent("", "  ")
+		if err := encoder.Encode(feed); err != nil {
+			log.Warnf("could not write feed: %v", err)
+		}
+	})
+}
+
+// unexported
+
+func recentlyTaggedFeed(store *storage.Storage, queryText string, since time.Time) (*atomFeed, error) {
+	expression, err := store.ParseQuery(queryText)
+	if err != nil {
+		return nil, querySyntaxError{err}
+	}
+
+	tagNames := query.TagNames(expression)
+	tags, err := store.TagsByNames(tagNames)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve tags: %v", err)
+	}
+	for _, tagName := range tagNames {
+		if !tags.ContainsName(tagName) {
+			return nil, noSuchTagError{tagName}
+		}
+	}
+
+	files, err := store.QueryFilteredFiles(expression, nil, false, entities.FileFilter{TaggedSince: since})
+	if err != nil {
+		return nil, fmt.Errorf("could not query files: %v", err)
+	}
+
+	entries := make([]atomEntry, 0, len(files))
+	for _, file := range files {
+		entry, err := feedEntry(store, file)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	// the timestamps are all UTC so sort chronologically as text
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Updated > entries[j].Updated })
+	if len(entries) > feedEntryLimit {
+		entries = entries[:feedEntryLimit]
+	}
+
+	title := "Recently tagged files"
+	if queryText != "" {
+		title += ": " + queryText
+	}
+
+	feed := atomFeed{Title: title,
+		Id:      "tmsu:feed:" + url.QueryEscape(queryText),
+		Updated: since.UTC().Format(time.RFC3339),
+		Entries: entries}
+	if len(entries) > 0 {
+		feed.Updated = entries[0].Updated
+	}
+
+	return &feed, nil
+}
+
+// Builds the feed entry for a file, dated when the file was last tagged.
+func feedEntry(store *storage.Storage, file *entities.File) (atomEntry, error) {
+	applications, err := store.FileTagApplications(file.Id)
+	if err != nil {
+		return atomEntry{}, fmt.Errorf("could not retrieve tag applications for '%v': %v", file.Path(), err)
+	}
+
+	var updated time.Time
+	for _, application := range applications {
+		if application.Applied.After(updated) {
+			updated = application.Applied
+		}
+	}
+
+	tagNames, err := tagNamesForFile(store, file.Id, false, false)
+	if err != nil {
+		return atomEntry{}, err
+	}
+
+	categories := make([]atomCategory, len(tagNames))
+	for index, tagName := range tagNames {
+		categories[index] = atomCategory{tagName}
+	}
+
+	location := url.URL{Scheme: "file", Path: file.Path()}
+
+	return atomEntry{Title: file.Name,
+		Id:         location.String(),
+		Updated:    updated.UTC().Format(time.RFC3339),
+		Link:       atomLink{location.String()},
+		Categories: categories,
+		Summary:    file.Path() + ": " + strings.Join(tagNames, " ")}, nil
+}
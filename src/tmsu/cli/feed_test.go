@@ -0,0 +1,84 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestFeedListsRecentlyTaggedFiles(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, path := range []string{"/tmp/tmsu/a", "/tmp/tmsu/b"} {
+		if err := createFile(path, path); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "film"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/b", "book"}); err != nil {
+		test.Fatal(err)
+	}
+
+	handler := newFeedHandler(store)
+
+	// test
+
+	request := httptest.NewRequest("GET", "/feed?query=film&since=1d", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	// validate
+
+	if recorder.Code != 200 {
+		test.Fatalf("Expected status 200 but was %v.", recorder.Code)
+	}
+
+	body := recorder.Body.String()
+	if !strings.Contains(body, "<id>file:///tmp/tmsu/a</id>") || !strings.Contains(body, `<category term="film"></category>`) {
+		test.Fatalf("Feed does not list the tagged file: %v", body)
+	}
+	if strings.Contains(body, "/tmp/tmsu/b") {
+		test.Fatalf("Feed lists a file not matching the query: %v", body)
+	}
+
+	request = httptest.NewRequest("GET", "/feed?query=missing", nil)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Code != 400 {
+		test.Fatalf("Expected status 400 for a missing tag but was %v.", recorder.Code)
+	}
+}
@@ -19,9 +19,13 @@ package cli
 
 import (
 	"fmt"
-	"path/filepath"
+	"math/rand"
+	"os"
 	"sort"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 	"tmsu/common/log"
 	"tmsu/common/path"
 	"tmsu/entities"
@@ -38,8 +42,34 @@ var FilesCommand = Command{
 
 QUERY may contain tag names to match, operators and parentheses. Operators are: and or not == != < > <= >=.
 
+Where several users share a database and the 'recordTagOwner' setting is enabled, a tag name may be prefixed with 'mine:' to match only your own taggings or 'user:NAME:' to match only those of user NAME. The 'tagVisibility' setting determines whether the other tag names match 'all' of the taggings or just those that are 'mine' or have no owner. (The TMSU_USER environment variable overrides the user name.)
+
+Within a query, a backslash escapes the character that follows it, which is then read as part of the tag name: 'mine\:cheese', for example, matches the tag named 'mine:cheese' rather than your taggings of 'cheese'. (A backslash within a tag name must itself be escaped as '\\'.)
+
+A tag name may also be prefixed with 'explicit:' to match only the files to which the tag is applied explicitly or 'implied:' to match only those that have the tag solely by implication. These precede any 'mine:' or 'user:NAME:' prefix. Escape a character of the prefix, e.g. 'explicit\:cheese', to match a tag whose name begins with it.
+
+The term 'under:PATH' matches the items at or beneath PATH and may be combined with tags like any other term. Spaces, parentheses and operator characters within PATH must be escaped, e.g. 'under:/mnt/my\ photos'.
+
+The term '$NAME' is replaced by the query of the macro NAME, as defined by the 'macro.NAME' setting (see 'config'). Macros may refer to other macros but not, directly or indirectly, to themselves. Macros can be used wherever a query is accepted. (Escape the prefix, as in '\$NAME', to match a tag whose name begins with '$'.)
+
+Where the 'inheritDirectoryTags' setting is enabled, the items within a tagged directory also match the directory's tags (and values) without the tags being applied to them. Such tags count as implied rather than explicit.
+
+The --tagged-since option restricts the results to files that have had a tag applied within DURATION, such as '36h', '7d' or '2w'. The times at which tags were applied are only recorded from TMSU v0.5.0 onward.
+
+The --sample option lists just COUNT of the matching files, chosen at random, which is handy for spot-checking the tagging of a large set of files. The sample is drawn by the database so the full set of matches need not be loaded, except where the results of several databases are combined (see 'cascade' under 'config'), when it is drawn from the combined matches.
+
+The --path option restricts the results to items under PATH. It may be repeated to list the items under any of several paths.
+
 Queries are run against the database so the results may not reflect the current state of the filesystem. Only tagged files are matched: to identify untagged files use the 'untagged' subcommand.
 
+When run with --missing the QUERY is instead matched against the records of files that have been removed from the database by 'repair --remove' or 'remove-file'. These records are only kept when the 'retainDeletedFiles' setting is enabled.
+
+Where the database is nested within the directory tree of other databases (in '.tmsu' directories of ancestor directories), the 'cascade' setting determines whether those databases are also queried: 'none' (the default) queries only the nearest database, 'fallback' queries the ancestor databases, nearest first, until there are results and 'union' combines the results from all of them.
+
+The --format option prints each file using a Go template (see https://golang.org/pkg/text/template/) instead of just its path. The template may refer to the fields .Path, .AbsPath, .Id, .Size, .ModTime, .Fingerprint, .IsDir and .Tags (as TAG or TAG=VALUE) and use the 'join' function to combine a list. '\t' and '\n' in the template stand for a tab and a newline. Each file's output is followed by a newline (or a NUL character with --print0).
+
+The --explain option shows how the query is run instead of listing the files: the query once the tag implications are applied, the SQL that is generated with its parameters, SQLite's plan for running it and the number of files each tag is applied to and that match. This is useful when reporting a slow query.
+
 Note: Your shell may use some punctuation (e.g. < and >) for its own purposes. Either enclose the query in quotation marks, escape the problematic characters or use the equivalent text operators: == eq, != ne, < lt, > gt, <= le, >= ge.`,
 	Examples: []string{"$ tmsu files music mp3  # files with both 'music' and 'mp3'",
 		"$ tmsu files music and mp3  # same query but with explicit 'and'",
@@ -49,120 +79,360 @@ Note: Your shell may use some punctuation (e.g. < and >) for its own purposes. E
 		`$ tmsu files "year < 2015" # tagged 'year' with values under '2015'`,
 		`$ tmsu files year lt 2015  # same query but using textual operator`,
 		`$ tmsu files year  # tagged 'year' (any or no value)`,
+		`$ tmsu config macro.loved="rating >= 4 or favourite"\n$ tmsu files '$loved and jazz'`,
 		`$ tmsu files --top music  # don't list individual files if directory is tagged`,
-		`$ tmsu files --path=/home/bob music  # tagged 'music' under /home/bob`},
+		`$ tmsu files --path=/home/bob music  # tagged 'music' under /home/bob`,
+		`$ tmsu files -p /home/bob -p /home/alice music  # under either directory`,
+		`$ tmsu files --missing music  # deleted files that were tagged 'music'`,
+		`$ tmsu files --tagged-since=1w  # files tagged this past week`,
+		`$ tmsu files --sample=20 photo  # twenty random photos`,
+		`$ tmsu files mine:favourite  # files you have tagged 'favourite'`,
+		`$ tmsu files user:alice:music  # files alice has tagged 'music'`,
+		`$ tmsu files implied:music  # files that are 'music' only by implication`,
+		`$ tmsu files beach and under:/mnt/photos/2015`,
+		`$ tmsu files --format '{{.Path}}\t{{.Size}}\t{{join .Tags ","}}' music`,
+		`$ tmsu files --explain music and not mp3`},
 	Options: Options{{"--directory", "-d", "list only items that are directories", false, ""},
 		{"--file", "-f", "list only items that are files", false, ""},
 		{"--top", "-t", "list only the top-most matching items (exclude files under matching directories)", false, ""},
 		{"--leaf", "-l", "list only the leaf items (files and directories without tagged contents)", false, ""},
 		{"--print0", "-0", "delimit files with a NUL character rather than newline.", false, ""},
 		{"--count", "-c", "lists the number of files rather than their names", false, ""},
-		{"--path", "-p", "list only items under PATH", true, ""},
-		{"--explicit", "-e", "list only explicitly tagged files", false, ""}},
+		{"--path", "-p", "list only items under PATH (may be repeated)", true, ""},
+		{"--explicit", "-e", "list only explicitly tagged files", false, ""},
+		{"--missing", "-m", "list deleted files retained in the database", false, ""},
+		{"--format", "-F", "print each file using the Go template FORMAT", true, ""},
+		{"--tagged-since", "", "list only files tagged within DURATION", true, ""},
+		{"--sample", "", "list only COUNT matching files chosen at random", true, ""},
+		{"--explain", "", "show how the query is run instead of listing the files", false, ""}},
 	Exec: filesExec,
 }
 
 func filesExec(store *storage.Storage, options Options, args []string) error {
-	dirOnly := options.HasOption("--directory")
-	fileOnly := options.HasOption("--file")
-	topOnly := options.HasOption("--top")
-	leafOnly := options.HasOption("--leaf")
+	filter := entities.FileFilter{DirectoriesOnly: options.HasOption("--directory"),
+		FilesOnly:  options.HasOption("--file"),
+		TopOnly:    options.HasOption("--top"),
+		LeavesOnly: options.HasOption("--leaf")}
 	print0 := options.HasOption("--print0")
 	showCount := options.HasOption("--count")
-	hasPath := options.HasOption("--path")
 	explicitOnly := options.HasOption("--explicit")
+	missing := options.HasOption("--missing")
+
+	if options.HasOption("--tagged-since") {
+		if missing {
+			return usageError{fmt.Errorf("--tagged-since cannot be used with --missing")}
+		}
+
+		duration, err := parseDuration(options.Get("--tagged-since").Argument)
+		if err != nil {
+			return usageError{err}
+		}
+
+		filter.TaggedSince = time.Now().Add(-duration)
+	}
+
+	if options.HasOption("--sample") {
+		if missing {
+			return usageError{fmt.Errorf("--sample cannot be used with --missing")}
+		}
+
+		sample, err := strconv.ParseUint(options.Get("--sample").Argument, 10, 0)
+		if err != nil || sample == 0 {
+			return usageError{fmt.Errorf("invalid sample size '%v'", options.Get("--sample").Argument)}
+		}
 
-	absPath := ""
-	if hasPath {
-		relPath := options.Get("--path").Argument
+		filter.Sample = uint(sample)
+	}
+
+	var format *template.Template
+	if options.HasOption("--format") {
+		if missing {
+			return usageError{fmt.Errorf("--format cannot be used with --missing")}
+		}
 
 		var err error
-		absPath, err = filepath.Abs(relPath)
+		format, err = parseFormat(options.Get("--format").Argument)
 		if err != nil {
-			fmt.Println("could not get absolute path of '%v': %v'", relPath, err)
+			return usageError{fmt.Errorf("invalid format: %v", err)}
 		}
 	}
 
+	pathOptions := options.GetAll("--path")
+	absPaths := make([]string, 0, len(pathOptions))
+	for _, option := range pathOptions {
+		absPath, err := path.Abs(option.Argument)
+		if err != nil {
+			return fmt.Errorf("could not get absolute path of '%v': %v", option.Argument, err)
+		}
+
+		absPaths = append(absPaths, absPath)
+	}
+
 	queryText := strings.Join(args, " ")
-	return listFilesForQuery(store, queryText, absPath, dirOnly, fileOnly, topOnly, leafOnly, print0, showCount, explicitOnly)
+
+	if options.HasOption("--explain") {
+		if missing {
+			return usageError{fmt.Errorf("--explain cannot be used with --missing")}
+		}
+
+		return explainQuery(store, queryText, absPaths, filter, explicitOnly)
+	}
+
+	if missing {
+		return listDeletedFilesForQuery(store, queryText, absPaths, print0, showCount)
+	}
+
+	return listFilesForQuery(store, queryText, absPaths, filter, print0, showCount, explicitOnly, format)
 }
 
 // unexported
 
-func listFilesForQuery(store *storage.Storage, queryText, path string, dirOnly, fileOnly, topOnly, leafOnly, print0, showCount, explicitOnly bool) error {
+func listFilesForQuery(store *storage.Storage, queryText string, paths []string, filter entities.FileFilter, print0, showCount, explicitOnly bool, format *template.Template) error {
 	log.Info(2, "parsing query")
 
-	expression, err := query.Parse(queryText)
+	expression, err := store.ParseQuery(queryText)
 	if err != nil {
-		return fmt.Errorf("could not parse query: %v", err)
+		return querySyntaxError{err}
 	}
 
 	log.Info(2, "checking tag names")
 
-	wereErrors := false
+	mode, err := cascadeMode(store)
+	if err != nil {
+		return err
+	}
 
 	tagNames := query.TagNames(expression)
 	tags, err := store.TagsByNames(tagNames)
+	missingTagNames := make([]string, 0)
 	for _, tagName := range tagNames {
-		if !tags.ContainsName(tagName) {
-			log.Warnf("no such tag '%v'.", tagName)
-			wereErrors = true
-			continue
+		if !tags.ContainsName(tagName) && mode == cascadeNone {
+			missingTagNames = append(missingTagNames, tagName)
 		}
 	}
 
-	if wereErrors {
-		return errBlank
+	if count := len(missingTagNames); count > 0 {
+		for _, tagName := range missingTagNames[:count-1] {
+			log.Warnf("no such tag '%v'.", tagName)
+		}
+
+		return noSuchTagError{missingTagNames[count-1]}
 	}
 
 	log.Info(2, "querying database")
 
-	files, err := store.QueryFiles(expression, path, explicitOnly)
+	if mode == cascadeNone {
+		return printQueriedFiles(store, expression, paths, filter, print0, showCount, explicitOnly, format)
+	}
+
+	// the sample is drawn from the matches of the databases combined, so that
+	// each database is represented in proportion to its matches
+	cascadeFilter := filter
+	cascadeFilter.Sample = 0
+
+	files, err := cascadeFiles(store, func(store *storage.Storage) (entities.Files, error) {
+		return store.QueryFilteredFiles(expression, paths, explicitOnly, cascadeFilter)
+	})
 	if err != nil {
-	    if strings.Index(err.Error(), "parser stack overflow") > -1 {
-            return fmt.Errorf("the query is too complex (see the troubleshooting wiki for how to increase the stack size)")
-        } else {
-            return fmt.Errorf("could not query files: %v", err)
-        }
+		return queryFilesError(err)
+	}
+
+	if filter.Sample != 0 && uint(len(files)) > filter.Sample {
+		random := rand.New(rand.NewSource(time.Now().UnixNano()))
+		random.Shuffle(len(files), func(i, j int) { files[i], files[j] = files[j], files[i] })
+		files = files[:filter.Sample]
+
+		sort.Slice(files, func(i, j int) bool { return files[i].Path() < files[j].Path() })
 	}
 
-	if err = listFiles(files, dirOnly, fileOnly, topOnly, leafOnly, print0, showCount); err != nil {
+	if format != nil && !showCount {
+		return formatFiles(store, files, print0, explicitOnly, format)
+	}
+
+	if err = listFiles(files, print0, showCount); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func listFiles(files entities.Files, dirOnly, fileOnly, topOnly, leafOnly, print0, showCount bool) error {
-	tree := path.NewTree()
-	for _, file := range files {
-		tree.Add(file.Path(), file.IsDir)
+// Prints the files matching the query as they are read from the database, so
+// that the whole result need not be held in memory.
+func printQueriedFiles(store *storage.Storage, expression query.Expression, paths []string, filter entities.FileFilter, print0, showCount, explicitOnly bool, format *template.Template) error {
+	terminator := "\n"
+	if print0 {
+		terminator = "\000"
+	}
+
+	var count uint
+	var printErr error
+	err := store.EachQueryFilteredFile(expression, paths, explicitOnly, filter, func(file *entities.File) error {
+		count++
+
+		switch {
+		case showCount:
+		case format != nil:
+			printErr = formatFile(store, file, explicitOnly, format, terminator)
+		default:
+			fmt.Print(path.Rel(file.Path()) + terminator)
+		}
+
+		return printErr
+	})
+	if printErr != nil {
+		return printErr
+	}
+	if err != nil {
+		return queryFilesError(err)
+	}
+
+	if showCount {
+		fmt.Println(count)
+	}
+
+	return nil
+}
+
+func queryFilesError(err error) error {
+	if strings.Index(err.Error(), "parser stack overflow") > -1 {
+		return fmt.Errorf("the query is too complex (see the troubleshooting wiki for how to increase the stack size)")
+	}
+
+	return fmt.Errorf("could not query files: %v", err)
+}
+
+func explainQuery(store *storage.Storage, queryText string, paths []string, filter entities.FileFilter, explicitOnly bool) error {
+	log.Info(2, "parsing query")
+
+	expression, err := store.ParseQuery(queryText)
+	if err != nil {
+		return querySyntaxError{err}
+	}
+
+	log.Info(2, "checking tag names")
+
+	tagNames := query.TagNames(expression)
+	tags, err := store.TagsByNames(tagNames)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tags: %v", err)
+	}
+	for _, tagName := range tagNames {
+		if !tags.ContainsName(tagName) {
+			return noSuchTagError{tagName}
+		}
+	}
+
+	log.Info(2, "planning query")
+
+	expandedExpression, plan, err := store.ExplainQuery(expression, paths, explicitOnly, filter)
+	if err != nil {
+		return fmt.Errorf("could not plan query: %v", err)
+	}
+
+	fmt.Println("Query:")
+	fmt.Printf("    %v\n", query.Format(expression))
+	fmt.Println("Expanded:")
+	fmt.Printf("    %v\n", query.Format(expandedExpression))
+
+	fmt.Println("SQL:")
+	for _, line := range strings.Split(strings.TrimSpace(plan.Sql), "\n") {
+		if line = strings.TrimRight(line, " "); line != "" {
+			fmt.Printf("    %v\n", line)
+		}
 	}
 
-	if topOnly {
-		tree = tree.TopLevel()
+	if len(plan.Params) > 0 {
+		fmt.Println("Parameters:")
+		for index, param := range plan.Params {
+			fmt.Printf("    ?%v = %v\n", index+1, param)
+		}
 	}
 
-	if leafOnly {
-		tree = tree.Leaves()
+	fmt.Println("Plan:")
+	for _, step := range plan.Steps {
+		fmt.Printf("    %v\n", step)
 	}
 
-	if fileOnly {
-		tree = tree.Files()
+	log.Info(2, "estimating rows")
+
+	expandedTagNames := query.TagNames(expandedExpression)
+	expandedTags, err := store.TagsByNames(expandedTagNames)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tags: %v", err)
 	}
+	sort.Sort(expandedTags)
 
-	if dirOnly {
-		tree = tree.Directories()
+	width := len("(matched)")
+	for _, tag := range expandedTags {
+		if len(tag.Name) > width {
+			width = len(tag.Name)
+		}
 	}
 
-	absPaths := tree.Paths()
+	fmt.Println("Rows:")
+	for _, tag := range expandedTags {
+		count, err := store.FileTagCountByTagId(tag.Id, true)
+		if err != nil {
+			return fmt.Errorf("could not count files tagged '%v': %v", tag.Name, err)
+		}
+
+		fmt.Printf("    %-*v %v\n", width, tag.Name, count)
+	}
+
+	var matched uint
+	err = store.EachQueryFilteredFile(expression, paths, explicitOnly, filter, func(*entities.File) error {
+		matched++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not query files: %v", err)
+	}
+
+	fmt.Printf("    %-*v %v\n", width, "(matched)", matched)
+
+	return nil
+}
+
+func listDeletedFilesForQuery(store *storage.Storage, queryText string, absPaths []string, print0, showCount bool) error {
+	log.Info(2, "parsing query")
+
+	expression, err := store.ParseQuery(queryText)
+	if err != nil {
+		return querySyntaxError{err}
+	}
+
+	log.Info(2, "querying deleted files")
+
+	files, err := store.DeletedFiles(expression, absPaths)
+	if err != nil {
+		return fmt.Errorf("could not query deleted files: %v", err)
+	}
 
 	if showCount {
-		fmt.Println(len(absPaths))
+		fmt.Println(len(files))
+		return nil
+	}
+
+	for _, file := range files {
+		relPath := path.Rel(file.Path())
+
+		if print0 {
+			fmt.Printf("%v\000", relPath)
+		} else {
+			fmt.Printf("%v (deleted %v)\n", relPath, file.Deleted.Local().Format("2006-01-02 15:04:05"))
+		}
+	}
+
+	return nil
+}
+
+func listFiles(files entities.Files, print0, showCount bool) error {
+	if showCount {
+		fmt.Println(len(files))
 	} else {
-		relPaths := make([]string, len(absPaths))
-		for index, absPath := range absPaths {
-			relPaths[index] = path.Rel(absPath)
+		relPaths := make([]string, len(files))
+		for index, file := range files {
+			relPaths[index] = path.Rel(file.Path())
 		}
 		sort.Strings(relPaths)
 
@@ -178,6 +448,40 @@ func listFiles(files entities.Files, dirOnly, fileOnly, topOnly, leafOnly, print
 	return nil
 }
 
+func formatFiles(store *storage.Storage, files entities.Files, print0, explicitOnly bool, format *template.Template) error {
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path() < files[j].Path()
+	})
+
+	terminator := "\n"
+	if print0 {
+		terminator = "\000"
+	}
+
+	for _, file := range files {
+		if err := formatFile(store, file, explicitOnly, format, terminator); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func formatFile(store *storage.Storage, file *entities.File, explicitOnly bool, format *template.Template, terminator string) error {
+	report, err := newFileReport(store, file, explicitOnly)
+	if err != nil {
+		return fmt.Errorf("%v: could not retrieve file details: %v", file.Path(), err)
+	}
+
+	if err := format.Execute(os.Stdout, report); err != nil {
+		return fmt.Errorf("%v: could not format file: %v", file.Path(), err)
+	}
+
+	fmt.Print(terminator)
+
+	return nil
+}
+
 func containsTag(tags []string, tag string) bool {
 	for _, iteratedTag := range tags {
 		if iteratedTag == tag {
@@ -18,11 +18,17 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package cli
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 	"tmsu/common/fingerprint"
+	"tmsu/entities"
+	"tmsu/query"
 	"tmsu/storage"
 )
 
@@ -958,4 +964,1199 @@ func TestFilesTagGreaterThanOrEqualToValue(test *testing.T) {
 	compareOutput(test, "/tmp/a\n/tmp/b\n/tmp/a\n/tmp/b\n/tmp/a\n/tmp/b\n", string(bytes))
 }
 
-//TODO tests for 'file' and 'directory' options.
+func TestFilesDirectory(test *testing.T) {
+	testFilesModifiers(test, Options{Option{"--directory", "-d", "", false, ""}}, "/tmp/b\n/tmp/b/c\n/tmp/f\n")
+}
+
+func TestFilesFile(test *testing.T) {
+	testFilesModifiers(test, Options{Option{"--file", "-f", "", false, ""}}, "/tmp/b/a\n/tmp/b/c/e\n/tmp/bb\n/tmp/d\n")
+}
+
+func TestFilesTop(test *testing.T) {
+	testFilesModifiers(test, Options{Option{"--top", "-t", "", false, ""}}, "/tmp/b\n/tmp/bb\n/tmp/d\n/tmp/f\n")
+}
+
+func TestFilesLeaf(test *testing.T) {
+	testFilesModifiers(test, Options{Option{"--leaf", "-l", "", false, ""}}, "/tmp/b/a\n/tmp/b/c/e\n/tmp/bb\n/tmp/d\n/tmp/f\n")
+}
+
+func TestFilesTopDirectory(test *testing.T) {
+	testFilesModifiers(test, Options{Option{"--top", "-t", "", false, ""}, Option{"--directory", "-d", "", false, ""}}, "/tmp/b\n/tmp/f\n")
+}
+
+func TestFilesLeafDirectory(test *testing.T) {
+	testFilesModifiers(test, Options{Option{"--leaf", "-l", "", false, ""}, Option{"--directory", "-d", "", false, ""}}, "/tmp/f\n")
+}
+
+func TestFilesLeafCount(test *testing.T) {
+	testFilesModifiers(test, Options{Option{"--leaf", "-l", "", false, ""}, Option{"--count", "-c", "", false, ""}}, "5\n")
+}
+
+func TestFilesPath(test *testing.T) {
+	testFilesModifiers(test, Options{Option{"--path", "-p", "", true, "/tmp/b"}}, "/tmp/b\n/tmp/b/a\n/tmp/b/c\n/tmp/b/c/e\n")
+}
+
+func TestFilesPaths(test *testing.T) {
+	testFilesModifiers(test, Options{Option{"--path", "-p", "", true, "/tmp/b/c"}, Option{"--path", "-p", "", true, "/tmp/d"}}, "/tmp/b/c\n/tmp/b/c/e\n/tmp/d\n")
+}
+
+func TestFilesPathTop(test *testing.T) {
+	testFilesModifiers(test, Options{Option{"--path", "-p", "", true, "/tmp/b/c"}, Option{"--top", "-t", "", false, ""}}, "/tmp/b/c\n")
+}
+
+func TestFilesPathUrl(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := redirectStreams(); err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	for _, url := range []string{"s3://bucket/photos/a.jpg", "s3://bucket/photos/b.jpg", "s3://bucket/docs/c.txt", "s3://bucket2/d.jpg"} {
+		if err := TagCommand.Exec(store, Options{}, []string{url, "cloud"}); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	expectations := []struct {
+		options  Options
+		args     []string
+		expected string
+	}{
+		{Options{Option{"--path", "-p", "", true, "s3://bucket/photos"}}, []string{"cloud"}, "s3://bucket/photos/a.jpg\ns3://bucket/photos/b.jpg\n"},
+		{Options{Option{"--path", "-p", "", true, "s3://bucket/"}}, []string{}, "s3://bucket/docs/c.txt\ns3://bucket/photos/a.jpg\ns3://bucket/photos/b.jpg\n"},
+		{Options{Option{"--path", "-p", "", true, "s3://bucket/docs/c.txt"}}, []string{}, "s3://bucket/docs/c.txt\n"},
+		{Options{}, []string{"cloud", "and", "under:s3://bucket2"}, "s3://bucket2/d.jpg\n"},
+	}
+
+	for _, expectation := range expectations {
+		// test
+
+		outFile.Seek(0, 0)
+		outFile.Truncate(0)
+
+		if err := FilesCommand.Exec(store, expectation.options, expectation.args); err != nil {
+			test.Fatal(err)
+		}
+
+		// validate
+
+		outFile.Seek(0, 0)
+
+		bytes, err := ioutil.ReadAll(outFile)
+		if err != nil {
+			test.Fatal(err)
+		}
+		compareOutput(test, expectation.expected, string(bytes))
+	}
+}
+
+func TestFilesTopSampleCount(test *testing.T) {
+	testFilesModifiers(test, Options{Option{"--top", "-t", "", false, ""}, Option{"--sample", "", "", true, "2"}, Option{"--count", "-c", "", false, ""}}, "2\n")
+}
+
+func TestFilesMissing(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.Db.Exec("INSERT INTO setting (name, value) VALUES ('retainDeletedFiles', 'yes')"); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := createFile("/tmp/tmsu/missing/a", "a"); err != nil {
+		test.Fatal(err)
+	}
+	if err := createFile("/tmp/tmsu/missing/b", "b"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll("/tmp/tmsu/missing")
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/missing/a", "apple", "year=2015"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/missing/b", "banana", "year=2017"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := RemoveFileCommand.Exec(store, Options{}, []string{"/tmp/tmsu/missing/a", "/tmp/tmsu/missing/b"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	options := Options{Option{"--missing", "-m", "", false, ""}, Option{"--print0", "-0", "", false, ""}}
+	if err := FilesCommand.Exec(store, options, []string{"year", "<", "2016", "or", "banana"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := FilesCommand.Exec(store, options, []string{"not", "apple"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := FilesCommand.Exec(store, Options{}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/tmsu/missing/a\000/tmp/tmsu/missing/b\000/tmp/tmsu/missing/b\000", string(bytes))
+}
+
+func TestFilesCascadeUnion(test *testing.T) {
+	// set-up
+
+	outerPath := "/tmp/tmsu/outer/.tmsu/db"
+	innerPath := "/tmp/tmsu/outer/inner/.tmsu/db"
+	defer os.RemoveAll("/tmp/tmsu/outer")
+
+	for _, dbPath := range []string{outerPath, innerPath} {
+		if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	if err := createFile("/tmp/tmsu/outer/a", "a"); err != nil {
+		test.Fatal(err)
+	}
+	if err := createFile("/tmp/tmsu/outer/inner/b", "b"); err != nil {
+		test.Fatal(err)
+	}
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	outer, err := storage.OpenAt(outerPath)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(outer, Options{}, []string{"/tmp/tmsu/outer/a", "beach"}); err != nil {
+		test.Fatal(err)
+	}
+
+	outer.Close()
+
+	store, err := storage.OpenAt(innerPath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/outer/inner/b", "beach"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.Db.Exec("INSERT INTO setting (name, value) VALUES ('cascade', 'union')"); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := FilesCommand.Exec(store, Options{}, []string{"beach"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/tmsu/outer/a\n/tmp/tmsu/outer/inner/b\n", string(bytes))
+}
+
+func TestFilesCascadeUnionSample(test *testing.T) {
+	// set-up
+
+	outerPath := "/tmp/tmsu/outer/.tmsu/db"
+	innerPath := "/tmp/tmsu/outer/inner/.tmsu/db"
+	defer os.RemoveAll("/tmp/tmsu/outer")
+
+	for _, dbPath := range []string{outerPath, innerPath} {
+		if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	if err := createFile("/tmp/tmsu/outer/a", "a"); err != nil {
+		test.Fatal(err)
+	}
+
+	innerFilePaths := make([]string, 40)
+	for index := range innerFilePaths {
+		innerFilePaths[index] = fmt.Sprintf("/tmp/tmsu/outer/inner/%02d", index)
+		if err := createFile(innerFilePaths[index], innerFilePaths[index]); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	outer, err := storage.OpenAt(outerPath)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(outer, Options{}, []string{"/tmp/tmsu/outer/a", "beach"}); err != nil {
+		test.Fatal(err)
+	}
+
+	outer.Close()
+
+	store, err := storage.OpenAt(innerPath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := TagCommand.Exec(store, Options{Option{"--tags", "-t", "", true, "beach"}}, innerFilePaths); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.Db.Exec("INSERT INTO setting (name, value) VALUES ('cascade', 'union')"); err != nil {
+		test.Fatal(err)
+	}
+
+	options := Options{Option{"--sample", "", "", true, "2"}}
+
+	// test
+
+	// the one file of the outer database should be sampled about one time in
+	// twenty, not as often as if each database contributed a sample of its own
+	outerSamples := 0
+	for run := 0; run < 20; run++ {
+		outFile.Truncate(0)
+		outFile.Seek(0, 0)
+
+		if err := FilesCommand.Exec(store, options, []string{"beach"}); err != nil {
+			test.Fatal(err)
+		}
+
+		// validate
+
+		outFile.Seek(0, 0)
+
+		bytes, err := ioutil.ReadAll(outFile)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		sample := strings.Split(strings.TrimSuffix(string(bytes), "\n"), "\n")
+		if len(sample) != 2 {
+			test.Fatalf("Expected a sample of 2 files but was '%v'.", string(bytes))
+		}
+		if !sort.StringsAreSorted(sample) {
+			test.Fatalf("Expected the sample to be in path order but was '%v'.", string(bytes))
+		}
+		if sample[0] == "/tmp/tmsu/outer/a" {
+			outerSamples++
+		}
+	}
+
+	if outerSamples > 6 {
+		test.Fatalf("Expected the outer database's file to be sampled in proportion but it was in %v of 20 samples.", outerSamples)
+	}
+}
+
+func TestFilesOwnerModifiers(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.Db.Exec("INSERT INTO setting (name, value) VALUES ('recordTagOwner', 'yes')"); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := createFile("/tmp/tmsu/a", "a"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	if err := createFile("/tmp/tmsu/b", "b"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/b")
+
+	defer os.Unsetenv("TMSU_USER")
+
+	os.Setenv("TMSU_USER", "alice")
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "fav"}); err != nil {
+		test.Fatal(err)
+	}
+
+	os.Setenv("TMSU_USER", "bob")
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/b", "fav"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := FilesCommand.Exec(store, Options{}, []string{"mine:fav"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := FilesCommand.Exec(store, Options{}, []string{"user:alice:fav"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.Db.Exec("INSERT INTO setting (name, value) VALUES ('tagVisibility', 'mine')"); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := FilesCommand.Exec(store, Options{}, []string{"fav"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/tmsu/b\n/tmp/tmsu/a\n/tmp/tmsu/b\n", string(bytes))
+}
+
+func TestFilesFormat(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileB, err := store.AddFile("/tmp/b", fingerprint.Fingerprint("def"), time.Now(), 456, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	musicTag, err := store.AddTag("music")
+	if err != nil {
+		test.Fatal(err)
+	}
+	yearTag, err := store.AddTag("year")
+	if err != nil {
+		test.Fatal(err)
+	}
+	value, err := store.AddValue("2015")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileA.Id, musicTag.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileA.Id, yearTag.Id, value.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, musicTag.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	options := Options{Option{"--format", "-F", "", true, `{{.Path}}\t{{.Size}}\t{{join .Tags ","}}`}}
+	if err := FilesCommand.Exec(store, options, []string{"music"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/a\t123\tmusic,year=2015\n/tmp/b\t456\tmusic\n", string(bytes))
+}
+
+func TestFilesTaggedSince(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileB, err := store.AddFile("/tmp/b", fingerprint.Fingerprint("def"), time.Now(), 456, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	musicTag, err := store.AddTag("music")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileA.Id, musicTag.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, musicTag.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+
+	// b was tagged a fortnight ago
+	if _, err := store.Db.Exec("UPDATE file_tag SET applied = ? WHERE file_id = ?", time.Now().UTC().Add(-14*24*time.Hour), fileB.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := FilesCommand.Exec(store, Options{Option{"--tagged-since", "", "", true, "1w"}}, []string{"music"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := FilesCommand.Exec(store, Options{Option{"--tagged-since", "", "", true, "3w"}}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/a\n/tmp/a\n/tmp/b\n", string(bytes))
+}
+
+func TestFilesProvenanceModifiers(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileB, err := store.AddFile("/tmp/b", fingerprint.Fingerprint("def"), time.Now(), 456, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	mp3Tag, err := store.AddTag("mp3")
+	if err != nil {
+		test.Fatal(err)
+	}
+	musicTag, err := store.AddTag("music")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if err := store.AddImplication(mp3Tag.Id, 0, musicTag.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileA.Id, mp3Tag.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileA.Id, musicTag.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, mp3Tag.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	for _, query := range []string{"music", "explicit:music", "implied:music", "not implied:music"} {
+		if err := FilesCommand.Exec(store, Options{}, []string{query}); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/a\n/tmp/b\n/tmp/a\n/tmp/b\n/tmp/a\n", string(bytes))
+}
+
+func TestFilesInheritDirectoryTags(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.UpdateSetting("inheritDirectoryTags", "yes"); err != nil {
+		test.Fatal(err)
+	}
+
+	dirB, err := store.AddFile("/tmp/b", fingerprint.Fingerprint(""), time.Now(), 0, true)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFile("/tmp/b/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFile("/tmp/b/c/d", fingerprint.Fingerprint("def"), time.Now(), 456, false); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFile("/tmp/bc", fingerprint.Fingerprint("ghi"), time.Now(), 789, false); err != nil {
+		test.Fatal(err)
+	}
+
+	musicTag, err := store.AddTag("music")
+	if err != nil {
+		test.Fatal(err)
+	}
+	yearTag, err := store.AddTag("year")
+	if err != nil {
+		test.Fatal(err)
+	}
+	value2015, err := store.AddValue("2015")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(dirB.Id, musicTag.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(dirB.Id, yearTag.Id, value2015.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	for _, query := range []string{"music", "year < 2016", "explicit:music", "implied:music"} {
+		if err := FilesCommand.Exec(store, Options{}, []string{query}); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/b\n/tmp/b/a\n/tmp/b/c/d\n/tmp/b\n/tmp/b/a\n/tmp/b/c/d\n/tmp/b\n/tmp/b/a\n/tmp/b/c/d\n", string(bytes))
+}
+
+func TestFilesUnder(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	beachTag, err := store.AddTag("beach")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	for _, path := range []string{"/tmp/photos/2015/a", "/tmp/photos/2015/b/c", "/tmp/photos/2016/d", "/tmp/photos/20150"} {
+		file, err := store.AddFile(path, fingerprint.Fingerprint(path), time.Now(), 123, false)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if path == "/tmp/photos/2015/a" {
+			continue
+		}
+
+		if _, err := store.AddFileTag(file.Id, beachTag.Id, 0); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// test
+
+	for _, query := range []string{"beach and under:/tmp/photos/2015", "under:/tmp/photos/2015", "beach and not under:/tmp/photos/2015"} {
+		if err := FilesCommand.Exec(store, Options{}, []string{query}); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/photos/2015/b/c\n/tmp/photos/2015/a\n/tmp/photos/2015/b/c\n/tmp/photos/20150\n/tmp/photos/2016/d\n", string(bytes))
+}
+
+func TestFilesValueImplication(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileB, err := store.AddFile("/tmp/b", fingerprint.Fingerprint("def"), time.Now(), 456, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	countryTag, err := store.AddTag("country")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddTag("france"); err != nil {
+		test.Fatal(err)
+	}
+	franceValue, err := store.AddValue("france")
+	if err != nil {
+		test.Fatal(err)
+	}
+	germanyValue, err := store.AddValue("germany")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileA.Id, countryTag.Id, franceValue.Id); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, countryTag.Id, germanyValue.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := ImplyCommand.Exec(store, Options{}, []string{"country=france", "france"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	for _, query := range []string{"france", "implied:france", "not france"} {
+		if err := FilesCommand.Exec(store, Options{}, []string{query}); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/a\n/tmp/a\n/tmp/b\n", string(bytes))
+}
+
+func TestFilesExplain(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileB, err := store.AddFile("/tmp/b", fingerprint.Fingerprint("def"), time.Now(), 456, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	mp3Tag, err := store.AddTag("mp3")
+	if err != nil {
+		test.Fatal(err)
+	}
+	musicTag, err := store.AddTag("music")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddTag("loud"); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := store.AddImplication(mp3Tag.Id, 0, musicTag.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileA.Id, mp3Tag.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileB.Id, musicTag.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := FilesCommand.Exec(store, Options{Option{"--explain", "", "", false, ""}}, []string{"music", "not", "loud"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	output := string(bytes)
+
+	for _, expected := range []string{
+		"Query:\n    music and not loud\n",
+		"Expanded:\n    (music or mp3) and not loud\n",
+		"SQL:\n    SELECT id, directory, name",
+		"?1 = music\n",
+		"Plan:\n",
+		"    loud      0\n    mp3       1\n    music     1\n    (matched) 2\n"} {
+		if !strings.Contains(output, expected) {
+			test.Fatalf("Expected output to contain '%v' but was:\n%v", expected, output)
+		}
+	}
+}
+
+func TestFilesQueryCache(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	store.EnableQueryCache()
+
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagB, err := store.AddTag("b")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(fileA.Id, tagB.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := FilesCommand.Exec(store, Options{}, []string{"b"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	// a change made by another connection
+	otherStore, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer otherStore.Close()
+
+	fileC, err := otherStore.AddFile("/tmp/c", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if _, err := otherStore.AddFileTag(fileC.Id, tagB.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := FilesCommand.Exec(store, Options{}, []string{"b"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// a change made within a transaction
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+	if err := store.DeleteFileTag(fileA.Id, tagB.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := FilesCommand.Exec(store, Options{}, []string{"b"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := store.Rollback(); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := FilesCommand.Exec(store, Options{}, []string{"b"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/a\n/tmp/a\n/tmp/c\n/tmp/c\n/tmp/a\n/tmp/c\n", string(bytes))
+}
+
+func BenchmarkFilesTagIntersection(benchmark *testing.B) {
+	benchmarkQuery(benchmark, "tag3 and tag5 and not tag7")
+}
+
+func BenchmarkFilesValueComparison(benchmark *testing.B) {
+	benchmarkQuery(benchmark, "tag3 = value2 or tag5 = value4")
+}
+
+// unexported
+
+func testFilesModifiers(test *testing.T, options Options, expectedOutput string) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	tag, err := store.AddTag("music")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	paths := []struct {
+		path  string
+		isDir bool
+	}{
+		{"/tmp/b", true},
+		{"/tmp/b/a", false},
+		{"/tmp/b/c", true},
+		{"/tmp/b/c/e", false},
+		{"/tmp/bb", false},
+		{"/tmp/d", false},
+		{"/tmp/f", true},
+	}
+
+	for _, item := range paths {
+		file, err := store.AddFile(item.path, fingerprint.Fingerprint("abc"), time.Now(), 123, item.isDir)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		if _, err := store.AddFileTag(file.Id, tag.Id, 0); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// test
+
+	if err := FilesCommand.Exec(store, options, []string{"music"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, expectedOutput, string(bytes))
+}
+
+// Times a query against a database of several thousand files, each tagged
+// with a selection of tags some of which have values.
+func benchmarkQuery(benchmark *testing.B, queryText string) {
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		benchmark.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := store.Begin(); err != nil {
+		benchmark.Fatal(err)
+	}
+
+	tags := make(entities.Tags, 10)
+	for index := range tags {
+		if tags[index], err = store.AddTag(fmt.Sprintf("tag%v", index)); err != nil {
+			benchmark.Fatal(err)
+		}
+	}
+
+	values := make(entities.Values, 5)
+	for index := range values {
+		if values[index], err = store.AddValue(fmt.Sprintf("value%v", index)); err != nil {
+			benchmark.Fatal(err)
+		}
+	}
+
+	for fileIndex := 0; fileIndex < 5000; fileIndex++ {
+		path := fmt.Sprintf("/tmp/%v/%v", fileIndex%100, fileIndex)
+		file, err := store.AddFile(path, fingerprint.Fingerprint(path), time.Now(), 0, false)
+		if err != nil {
+			benchmark.Fatal(err)
+		}
+
+		for tagIndex, tag := range tags {
+			if fileIndex%(tagIndex+2) != 0 {
+				continue
+			}
+
+			var valueId entities.ValueId
+			if tagIndex%2 == 1 {
+				valueId = values[fileIndex%len(values)].Id
+			}
+
+			if _, err := store.AddFileTag(file.Id, tag.Id, valueId); err != nil {
+				benchmark.Fatal(err)
+			}
+		}
+	}
+
+	if err := store.Commit(); err != nil {
+		benchmark.Fatal(err)
+	}
+
+	expression, err := query.Parse(queryText)
+	if err != nil {
+		benchmark.Fatal(err)
+	}
+
+	benchmark.ResetTimer()
+
+	for iteration := 0; iteration < benchmark.N; iteration++ {
+		if _, err := store.QueryFiles(expression, "", false); err != nil {
+			benchmark.Fatal(err)
+		}
+	}
+}
+
+func TestFilesExpandsQueryMacros(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, path := range []string{"/tmp/tmsu/a", "/tmp/tmsu/b", "/tmp/tmsu/c"} {
+		if err := createFile(path, path); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "jazz", "rating=5"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/b", "jazz", "rating=2"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/c", "rock", "rating=5"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := ConfigCommand.Exec(store, Options{}, []string{"macro.loved=rating >= 4", "macro.lovedjazz=$loved and jazz"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := redirectStreams(); err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	// test
+
+	if err := FilesCommand.Exec(store, Options{}, []string{"$lovedjazz"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/tmsu/a\n", string(bytes))
+}
+
+func TestFilesSample(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	paths := []string{"/tmp/tmsu/a", "/tmp/tmsu/b", "/tmp/tmsu/c", "/tmp/tmsu/d", "/tmp/tmsu/e"}
+	for _, path := range paths {
+		if err := createFile(path, path); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+
+		if err := TagCommand.Exec(store, Options{}, []string{path, "jazz"}); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "rock"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := redirectStreams(); err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	// test
+
+	options := Options{Option{"--sample", "", "", true, "3"}}
+	if err := FilesCommand.Exec(store, options, []string{"jazz", "and", "not", "rock"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	sample := strings.Split(strings.TrimSuffix(string(bytes), "\n"), "\n")
+	if len(sample) != 3 {
+		test.Fatalf("Expected a sample of 3 files but was '%v'.", string(bytes))
+	}
+	if !sort.StringsAreSorted(sample) {
+		test.Fatalf("Expected the sample to be in path order but was '%v'.", string(bytes))
+	}
+	candidates := map[string]bool{"/tmp/tmsu/b": true, "/tmp/tmsu/c": true, "/tmp/tmsu/d": true, "/tmp/tmsu/e": true}
+	for index, path := range sample {
+		if !candidates[path] || (index > 0 && path == sample[index-1]) {
+			test.Fatalf("Unexpected file '%v' in sample '%v'.", path, string(bytes))
+		}
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"tmsu/common/finder"
+	"tmsu/common/log"
+	"tmsu/storage"
+)
+
+var FinderSyncCommand = Command{
+	Name:     "finder-sync",
+	Synopsis: "Synchronize tags with macOS Finder tags",
+	Usages:   []string{"tmsu finder-sync [OPTION]... [FILE]..."},
+	Description: `Synchronizes the tags of each FILE with its Finder tags so that they are visible in Finder and Spotlight. If no FILE is specified then every file in the database is synchronized.
+
+By default the tags are merged: tags missing from Finder are added to it and Finder tags missing from the database are applied. With --to-finder the Finder tags are replaced by the file's tags; with --from-finder the file's tags are replaced by its Finder tags.
+
+A tag with a value appears in Finder as TAG=VALUE. Finder tags whose names are not valid tag names, for example because they contain spaces, are reported and skipped.
+
+Only the tags starting with the prefix held in the 'finderTagPrefix' setting, which is empty by default, are synchronized. The prefix is removed from the names shown in Finder and added to the names of the tags applied from Finder.`,
+	Examples: []string{"$ tmsu finder-sync ~/Documents/report.pdf",
+		"$ tmsu finder-sync --to-finder",
+		"$ tmsu config finderTagPrefix=finder.\n$ tmsu finder-sync --from-finder *.jpg"},
+	Options: Options{{"--to-finder", "-t", "replace the Finder tags with the file's tags", false, ""},
+		{"--from-finder", "-f", "replace the file's tags with its Finder tags", false, ""}},
+	Exec: finderSyncExec,
+}
+
+func finderSyncExec(store *storage.Storage, options Options, args []string) error {
+	toFinder := options.HasOption("--to-finder")
+	fromFinder := options.HasOption("--from-finder")
+	if toFinder && fromFinder {
+		return usageError{fmt.Errorf("--to-finder and --from-finder cannot be combined")}
+	}
+
+	prefix, err := store.SettingAsString("finderTagPrefix")
+	if err != nil {
+		return fmt.Errorf("could not retrieve setting 'finderTagPrefix': %v", err)
+	}
+
+	paths := args
+	if len(paths) == 0 {
+		files, err := store.Files()
+		if err != nil {
+			return fmt.Errorf("could not retrieve files: %v", err)
+		}
+		files = localFiles(files)
+
+		paths = make([]string, len(files))
+		for index, file := range files {
+			paths[index] = file.Path()
+		}
+	}
+
+	wereErrors := false
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not get absolute path: %v", path, err)
+		}
+
+		if err := finderSync(store, absPath, prefix, toFinder, fromFinder); err != nil {
+			if err != errBlank {
+				log.Warnf("%v: %v", path, err)
+			}
+
+			wereErrors = true
+		}
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+// unexported
+
+func finderSync(store *storage.Storage, absPath, prefix string, toFinder, fromFinder bool) error {
+	entries, err := finder.ReadTags(absPath)
+	if err != nil {
+		return fmt.Errorf("could not read Finder tags: %v", err)
+	}
+
+	currentTagNames, _, err := editableTagNames(store, absPath)
+	if err != nil {
+		return err
+	}
+
+	wereErrors := false
+
+	if !toFinder {
+		wantedTagNames := currentTagNames
+		if fromFinder {
+			wantedTagNames = make([]string, 0, len(currentTagNames))
+			for _, tagName := range currentTagNames {
+				if !strings.HasPrefix(tagName, prefix) {
+					wantedTagNames = append(wantedTagNames, tagName)
+				}
+			}
+		}
+
+		for _, entry := range entries {
+			tagArg, err := finderTagArg(finder.TagName(entry), prefix)
+			if err != nil {
+				log.Warnf("%v: skipping Finder tag '%v': %v", absPath, finder.TagName(entry), err)
+				wereErrors = true
+				continue
+			}
+
+			if !containsTag(wantedTagNames, tagArg) {
+				wantedTagNames = append(wantedTagNames, tagArg)
+			}
+		}
+
+		if err := reconcileTags(store, absPath, currentTagNames, wantedTagNames); err != nil {
+			return err
+		}
+	}
+
+	if !fromFinder {
+		wantedEntries := finderEntries(entries, finderTagNames(currentTagNames, prefix), toFinder)
+		if !equalEntries(entries, wantedEntries) {
+			log.Infof(2, "%v: writing Finder tags %v", absPath, strings.Join(wantedEntries, ", "))
+
+			if err := finder.WriteTags(absPath, wantedEntries); err != nil {
+				return fmt.Errorf("could not write Finder tags: %v", err)
+			}
+		}
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+// The names, as shown in Finder, of the tags with the prefix.
+func finderTagNames(tagNames []string, prefix string) []string {
+	names := make([]string, 0, len(tagNames))
+	for _, tagName := range tagNames {
+		if strings.HasPrefix(tagName, prefix) {
+			names = append(names, tagName[len(prefix):])
+		}
+	}
+
+	return names
+}
+
+// Determines the Finder tag entries a file should have. The existing entries
+// for the wanted tags are kept so that their colours are retained; where
+// replacing, the others are dropped.
+func finderEntries(entries, wantedNames []string, replace bool) []string {
+	result := make([]string, 0, len(entries)+len(wantedNames))
+	existingNames := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := finder.TagName(entry)
+		existingNames = append(existingNames, name)
+
+		if !replace || containsTag(wantedNames, name) {
+			result = append(result, entry)
+		}
+	}
+
+	for _, name := range wantedNames {
+		if !containsTag(existingNames, name) {
+			result = append(result, name)
+		}
+	}
+
+	return result
+}
+
+func equalEntries(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for index := range a {
+		if a[index] != b[index] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Converts the name of a Finder tag to a tag argument of the form TAG or
+// TAG=VALUE.
+func finderTagArg(name, prefix string) (string, error) {
+	if index := strings.Index(name, "="); index > 0 {
+		return importedTagArg(prefix+name[:index], name[index+1:])
+	}
+
+	return importedTagArg(prefix+name, "")
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFinderEntriesMerge(test *testing.T) {
+	entries := finderEntries([]string{"Red\n6", "work"}, []string{"work", "year=2014"}, false)
+
+	if strings.Join(entries, "|") != "Red\n6|work|year=2014" {
+		test.Fatalf("unexpected entries: %q", entries)
+	}
+}
+
+func TestFinderEntriesReplace(test *testing.T) {
+	entries := finderEntries([]string{"Red\n6", "work\n0"}, []string{"work", "year=2014"}, true)
+
+	if strings.Join(entries, "|") != "work\n0|year=2014" {
+		test.Fatalf("unexpected entries: %q", entries)
+	}
+}
+
+func TestFinderTagArg(test *testing.T) {
+	tagArg, err := finderTagArg("year=2014", "finder.")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if tagArg != "finder.year=2014" {
+		test.Fatalf("expected 'finder.year=2014' but was '%v'", tagArg)
+	}
+
+	if _, err := finderTagArg("Important Stuff", ""); err == nil {
+		test.Fatal("Finder tag with a space was accepted")
+	}
+
+	names := finderTagNames([]string{"finder.work", "private"}, "finder.")
+	if len(names) != 1 || names[0] != "work" {
+		test.Fatalf("unexpected Finder tag names: %v", names)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"tmsu/common/filesystem"
+	"tmsu/common/log"
+	"tmsu/entities"
+	"tmsu/storage"
+)
+
+var FingerprintCommand = Command{
+	Name:     "fingerprint",
+	Synopsis: "Calculate file fingerprints",
+	Usages: []string{"tmsu fingerprint [OPTION]... PATH...",
+		"tmsu fingerprint [OPTION]... --pending [PATH]..."},
+	Description: `Calculates and records the fingerprints of the tagged files at and under the PATHs using the algorithm given by the 'fingerprintAlgorithm' setting.
+
+With --pending the files that were tagged with 'tag --no-fingerprint', and so await a fingerprint, are fingerprinted instead: all of them or those at and under the PATHs. Progress is saved as it goes so an interrupted run can simply be repeated.
+
+Files that no longer exist are reported and, if pending, remain so.`,
+	Examples: []string{"$ tmsu fingerprint --pending",
+		"$ tmsu fingerprint --pending /media/videos",
+		"$ tmsu fingerprint photo.jpg"},
+	Options: Options{{"--pending", "-p", "fingerprint the files awaiting a fingerprint", false, ""}},
+	Exec:    fingerprintExec,
+}
+
+func fingerprintExec(store *storage.Storage, options Options, args []string) error {
+	pending := options.HasOption("--pending")
+
+	var files entities.Files
+	var err error
+	if pending {
+		files, err = pendingFingerprintFiles(store, args)
+	} else {
+		if len(args) == 0 {
+			return fmt.Errorf("paths to fingerprint must be specified")
+		}
+
+		files, err = verifyFiles(store, args)
+	}
+	if err != nil {
+		return err
+	}
+
+	fingerprintAlgorithm, err := store.SettingAsString("fingerprintAlgorithm")
+	if err != nil {
+		return err
+	}
+
+	policy, err := symlinkPolicy(store, nil)
+	if err != nil {
+		return err
+	}
+
+	wereErrors := false
+	for index, file := range files {
+		if err := fingerprintFile(store, file, fingerprintAlgorithm, policy); err != nil {
+			if !os.IsNotExist(err) {
+				return err
+			}
+
+			log.Warnf("%v: missing", file.Path())
+			wereErrors = true
+		}
+
+		if (index+1)%fingerprintBatchSize == 0 {
+			if err := store.Checkpoint(); err != nil {
+				return fmt.Errorf("could not save changes: %v", err)
+			}
+		}
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+// unexported
+
+// The number of files fingerprinted between saving the changes.
+const fingerprintBatchSize = 100
+
+// Retrieves the files awaiting a fingerprint: all of them or those at and
+// under the specified paths.
+func pendingFingerprintFiles(store *storage.Storage, paths []string) (entities.Files, error) {
+	files, err := store.PendingFingerprintFiles()
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve files awaiting a fingerprint: %v", err)
+	}
+
+	if len(paths) == 0 {
+		return files, nil
+	}
+
+	absPaths := make([]string, len(paths))
+	for index, path := range paths {
+		if absPaths[index], err = filepath.Abs(path); err != nil {
+			return nil, fmt.Errorf("%v: could not get absolute path: %v", path, err)
+		}
+	}
+
+	return files.Where(func(file *entities.File) bool {
+		for _, absPath := range absPaths {
+			if file.Path() == absPath || strings.HasPrefix(file.Path(), absPath+string(filepath.Separator)) {
+				return true
+			}
+		}
+
+		return false
+	}), nil
+}
+
+// Calculates and records the fingerprints of the file, removing it from the
+// files awaiting a fingerprint.
+func fingerprintFile(store *storage.Storage, file *entities.File, fingerprintAlgorithm string, policy filesystem.SymlinkPolicy) error {
+	log.Infof(2, "%v: creating fingerprint", file.Path())
+
+	stat, err := policy.Stat(file.Path())
+	if err != nil {
+		return err
+	}
+
+	fp, err := createFingerprint(file.Path(), fingerprintAlgorithm, policy)
+	if err != nil {
+		return fmt.Errorf("%v: could not create fingerprint: %v", file.Path(), err)
+	}
+
+	updatedFile, err := store.UpdateFile(file.Id, file.Path(), fp, stat.ModTime(), stat.Size(), stat.IsDir())
+	if err != nil {
+		return fmt.Errorf("%v: could not update file in database: %v", file.Path(), err)
+	}
+
+	if err := store.UpdateExtraFingerprints(updatedFile); err != nil {
+		return err
+	}
+
+	return store.DeletePendingFingerprint(file.Id)
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"os"
+	"testing"
+	"tmsu/common/fingerprint"
+	"tmsu/storage"
+)
+
+func TestFingerprintPending(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/pending/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	if err := createFile("/tmp/tmsu/pending/b", "world"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll("/tmp/tmsu/pending")
+
+	options := Options{Option{"--recursive", "-r", "", false, ""}, Option{"--no-fingerprint", "", "", false, ""}}
+	if err := TagCommand.Exec(store, options, []string{"/tmp/tmsu/pending", "apple"}); err != nil {
+		test.Fatal(err)
+	}
+
+	file, err := store.FileByPath("/tmp/tmsu/pending/a")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if file.Fingerprint != fingerprint.EMPTY {
+		test.Fatalf("File was fingerprinted: %v", file.Fingerprint)
+	}
+
+	count, err := store.PendingFingerprintCount()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if count != 2 {
+		test.Fatalf("Expected two files awaiting a fingerprint but were %v.", count)
+	}
+
+	// test
+
+	if err := FingerprintCommand.Exec(store, Options{Option{"--pending", "-p", "", false, ""}}, []string{"/tmp/tmsu/pending/a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	file, err = store.FileByPath("/tmp/tmsu/pending/a")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if file.Fingerprint == fingerprint.EMPTY {
+		test.Fatal("File was not fingerprinted.")
+	}
+
+	files, err := store.PendingFingerprintFiles()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Path() != "/tmp/tmsu/pending/b" {
+		test.Fatalf("Expected only '/tmp/tmsu/pending/b' to await a fingerprint.")
+	}
+}
@@ -0,0 +1,264 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"tmsu/common/fingerprint"
+	"tmsu/common/log"
+	"tmsu/entities"
+	"tmsu/storage"
+)
+
+var FsckCommand = Command{
+	Name:     "fsck",
+	Synopsis: "Check the database for consistency",
+	Usages:   []string{"tmsu fsck [OPTION]..."},
+	Description: `Checks the database for internal inconsistencies:
+
+  * file tags referring to files, tags or values that do not exist
+  * values that are not used by any file tag
+  * implications referring to tags that do not exist
+  * file entries that resolve to the same path
+  * files that have no fingerprint
+
+Problems are reported but not fixed unless --fix is specified, in which case the fixes are made together: if any fix fails then none are applied.
+
+Duplicate file entries are fixed by merging their tags into the oldest entry. Missing fingerprints are recalculated if the file exists.
+
+To check the database against the filesystem use the 'repair' subcommand.`,
+	Examples: []string{"$ tmsu fsck",
+		"$ tmsu fsck --fix"},
+	Options: Options{{"--fix", "-f", "fix the problems found", false, ""}},
+	Exec:    fsckExec,
+}
+
+func fsckExec(store *storage.Storage, options Options, args []string) error {
+	fix := options.HasOption("--fix")
+
+	var unfixed uint
+	var err error
+	if fix {
+		// only the fixes are undone should one fail, not the changes made by
+		// earlier commands read from standard input
+		err = store.Atomically("fsck_fix", func() error {
+			var err error
+			unfixed, err = fsck(store, true)
+			return err
+		})
+	} else {
+		unfixed, err = fsck(store, false)
+	}
+	if err != nil {
+		return err
+	}
+
+	if unfixed > 0 {
+		return errBlank
+	}
+
+	return nil
+}
+
+// unexported
+
+// Checks the database, reporting and optionally fixing the problems found.
+// Returns the number of problems that remain.
+func fsck(store *storage.Storage, fix bool) (uint, error) {
+	unfixed := uint(0)
+
+	report := func(fixed bool, format string, args ...interface{}) {
+		problem := fmt.Sprintf(format, args...)
+
+		if fixed {
+			fmt.Printf("%v: fixed\n", problem)
+		} else {
+			fmt.Println(problem)
+			unfixed++
+		}
+	}
+
+	log.Info(2, "checking for orphaned file tags")
+
+	fileTags, err := store.OrphanedFileTags()
+	if err != nil {
+		return 0, fmt.Errorf("could not retrieve orphaned file tags: %v", err)
+	}
+
+	if fix && len(fileTags) > 0 {
+		if err := store.DeleteOrphanedFileTags(); err != nil {
+			return 0, fmt.Errorf("could not delete orphaned file tags: %v", err)
+		}
+	}
+
+	for _, fileTag := range fileTags {
+		report(fix, "orphaned file tag (file #%v, tag #%v, value #%v)", fileTag.FileId, fileTag.TagId, fileTag.ValueId)
+	}
+
+	log.Info(2, "checking for dangling implications")
+
+	implications, err := store.DanglingImplications()
+	if err != nil {
+		return 0, fmt.Errorf("could not retrieve dangling implications: %v", err)
+	}
+
+	if fix && len(implications) > 0 {
+		if err := store.DeleteDanglingImplications(); err != nil {
+			return 0, fmt.Errorf("could not delete dangling implications: %v", err)
+		}
+	}
+
+	for _, implication := range implications {
+		if implication.ImplyingValue.Id != 0 {
+			report(fix, "dangling implication (tag #%v, value #%v -> tag #%v)", implication.ImplyingTag.Id, implication.ImplyingValue.Id, implication.ImpliedTag.Id)
+		} else {
+			report(fix, "dangling implication (tag #%v -> tag #%v)", implication.ImplyingTag.Id, implication.ImpliedTag.Id)
+		}
+	}
+
+	log.Info(2, "checking for duplicate file paths")
+
+	duplicates, err := store.FilesWithDuplicatePaths()
+	if err != nil {
+		return 0, fmt.Errorf("could not retrieve duplicate files: %v", err)
+	}
+
+	for _, files := range duplicates {
+		if fix {
+			if err := mergeDuplicateFiles(store, files); err != nil {
+				return 0, fmt.Errorf("%v: could not merge duplicate entries: %v", files[0].Path(), err)
+			}
+		}
+
+		report(fix, "%v: duplicate entries (%v)", files[0].Path(), len(files))
+	}
+
+	log.Info(2, "checking for unused values")
+
+	values, err := store.UnusedValues()
+	if err != nil {
+		return 0, fmt.Errorf("could not retrieve unused values: %v", err)
+	}
+
+	if fix && len(values) > 0 {
+		valueIds := make(entities.ValueIds, len(values))
+		for index, value := range values {
+			valueIds[index] = value.Id
+		}
+
+		if err := store.DeleteUnusedValues(valueIds); err != nil {
+			return 0, fmt.Errorf("could not delete unused values: %v", err)
+		}
+	}
+
+	for _, value := range values {
+		report(fix, "unused value '%v'", value.Name)
+	}
+
+	log.Info(2, "checking for missing fingerprints")
+
+	files, err := store.FilesWithoutFingerprint()
+	if err != nil {
+		return 0, fmt.Errorf("could not retrieve files: %v", err)
+	}
+
+	var fingerprintAlgorithm string
+	if fix && len(files) > 0 {
+		fingerprintAlgorithm, err = store.SettingAsString("fingerprintAlgorithm")
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for _, file := range files {
+		fixed := false
+		if fix {
+			fixed, err = recalculateFingerprint(store, file, fingerprintAlgorithm)
+			if err != nil {
+				return 0, fmt.Errorf("%v: could not recalculate fingerprint: %v", file.Path(), err)
+			}
+		}
+
+		report(fixed, "%v: no fingerprint", file.Path())
+	}
+
+	return unfixed, nil
+}
+
+// Merges the tags of the duplicate files into the oldest entry and deletes
+// the others.
+func mergeDuplicateFiles(store *storage.Storage, files entities.Files) error {
+	keeper := files[0]
+	for _, file := range files[1:] {
+		if file.Id < keeper.Id {
+			keeper = file
+		}
+	}
+
+	for _, file := range files {
+		if file == keeper {
+			continue
+		}
+
+		if err := store.CopyFileTagsByFileId(file.Id, keeper.Id); err != nil {
+			return err
+		}
+
+		if err := store.Db.DeleteFileTagsByFileId(file.Id); err != nil {
+			return err
+		}
+
+		if err := store.DeleteFile(file.Id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Recalculates the fingerprint of a file. Returns false if the file does not
+// exist or still has no fingerprint.
+func recalculateFingerprint(store *storage.Storage, file *entities.File, fingerprintAlgorithm string) (bool, error) {
+	stat, err := os.Stat(file.Path())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	fp, err := fingerprint.Create(file.Path(), fingerprintAlgorithm)
+	if err != nil {
+		return false, err
+	}
+	if fp == fingerprint.EMPTY {
+		return false, nil
+	}
+
+	updatedFile, err := store.UpdateFile(file.Id, file.Path(), fp, stat.ModTime(), stat.Size(), stat.IsDir())
+	if err != nil {
+		return false, err
+	}
+
+	if err := store.UpdateExtraFingerprints(updatedFile); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
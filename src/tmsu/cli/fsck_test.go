@@ -0,0 +1,175 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestFsckFix(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/a", "a"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "apple"}); err != nil {
+		test.Fatal(err)
+	}
+
+	statements := []string{"INSERT INTO file_tag (file_id, tag_id, value_id) VALUES (99, 1, 0)",
+		"INSERT INTO implication (tag_id, implied_tag_id) VALUES (1, 99)",
+		"INSERT INTO value (name) VALUES ('unused')",
+		"INSERT INTO tag (name) VALUES ('banana')",
+		"INSERT INTO directory (path) VALUES ('tmp/tmsu')",
+		"INSERT INTO file (directory_id, name, fingerprint, mod_time, size, is_dir) VALUES ((SELECT id FROM directory WHERE path = 'tmp/tmsu'), 'a', '', '2015-01-01', 1, 0)",
+		"INSERT INTO file_tag (file_id, tag_id, value_id) VALUES (2, 2, 0)"}
+	for _, statement := range statements {
+		if _, err := store.Db.Exec(statement); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// test
+
+	if err := FsckCommand.Exec(store, Options{Option{"--fix", "-f", "", false, ""}}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+	if err := FsckCommand.Exec(store, Options{}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, `orphaned file tag (file #99, tag #1, value #0): fixed
+dangling implication (tag #1 -> tag #99): fixed
+/tmp/tmsu/a: duplicate entries (2): fixed
+unused value 'unused': fixed
+`, string(bytes))
+
+	file, err := store.FileByPath("/tmp/tmsu/a")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagNames, err := tagNamesForFile(store, file.Id, true, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if len(tagNames) != 2 || tagNames[0] != "apple" || tagNames[1] != "banana" {
+		test.Fatalf("Unexpected tags: %v", tagNames)
+	}
+}
+
+func TestFsckFailedFixKeepsEarlierChanges(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/a", "a"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddTag("apple"); err != nil {
+		test.Fatal(err)
+	}
+
+	// the missing fingerprint cannot be recalculated with an unknown algorithm
+	statements := []string{"INSERT INTO value (name) VALUES ('unused')",
+		"INSERT INTO setting (name, value) VALUES ('fingerprintAlgorithm', 'bogus')",
+		"INSERT INTO directory (path) VALUES ('tmp/tmsu')",
+		"INSERT INTO file (directory_id, name, fingerprint, mod_time, size, is_dir) VALUES ((SELECT id FROM directory WHERE path = 'tmp/tmsu'), 'a', '', '2015-01-01', 1, 0)"}
+	for _, statement := range statements {
+		if _, err := store.Db.Exec(statement); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// test
+
+	if err := FsckCommand.Exec(store, Options{Option{"--fix", "-f", "", false, ""}}, []string{}); err == nil {
+		test.Fatal("Expected the fix to fail.")
+	}
+
+	// validate
+
+	tag, err := store.TagByName("apple")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if tag == nil {
+		test.Fatal("The earlier change was discarded.")
+	}
+
+	value, err := store.ValueByName("unused")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if value == nil {
+		test.Fatal("The fixes made before the failure were kept.")
+	}
+
+	if err := store.Commit(); err != nil {
+		test.Fatal(err)
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"tmsu/common/log"
+	"tmsu/storage"
+)
+
+var GcCommand = Command{
+	Name:     "gc",
+	Synopsis: "Remove unused tags and values",
+	Usages:   []string{"tmsu gc [OPTION]..."},
+	Description: `Removes the tags and values that have not been applied to any file for longer than the retention period.
+
+The retention period is taken from the 'unusedRetention' setting unless --retention is specified. Durations are given as a number followed by a unit, e.g. 12h, 30d or 2w. A retention period of zero removes every unused tag and value.
+
+Tags that take part in an implication, tags named by the 'lockedTags' setting and the TAGs given to --keep-tags are never removed.
+
+Tags and values that were already unused when the database was upgraded are treated as having become unused at the time of the upgrade.`,
+	Examples: []string{"$ tmsu gc",
+		"$ tmsu gc --retention 7d",
+		"$ tmsu gc --pretend --retention 0",
+		"$ tmsu gc --keep-tags \"todo draft\""},
+	Options: Options{{"--retention", "-r", "remove tags and values unused for longer than DURATION", true, ""},
+		{"--keep-tags", "-k", "never remove the space-separated TAGS", true, ""},
+		{"--pretend", "-P", "list the tags and values that would be removed", false, ""}},
+	Exec: gcExec,
+}
+
+func gcExec(store *storage.Storage, options Options, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	var retention time.Duration
+	if options.HasOption("--retention") {
+		var err error
+		retention, err = parseDuration(options.Get("--retention").Argument)
+		if err != nil {
+			return usageError{err}
+		}
+	} else {
+		retentionText, err := store.SettingAsString("unusedRetention")
+		if err != nil {
+			return fmt.Errorf("could not retrieve setting 'unusedRetention': %v", err)
+		}
+
+		retention, err = parseDuration(retentionText)
+		if err != nil {
+			return fmt.Errorf("invalid 'unusedRetention' setting: %v", err)
+		}
+	}
+
+	keepTagNames := []string{}
+	if options.HasOption("--keep-tags") {
+		keepTagNames = strings.Fields(options.Get("--keep-tags").Argument)
+	}
+
+	lockedTagNames, err := store.LockedTagNames()
+	if err != nil {
+		return fmt.Errorf("could not retrieve locked tags: %v", err)
+	}
+	keepTagNames = append(keepTagNames, lockedTagNames...)
+
+	pretend := options.HasOption("--pretend")
+	cutoff := time.Now().Add(-retention)
+
+	return collectGarbage(store, cutoff, keepTagNames, pretend)
+}
+
+// unexported
+
+func collectGarbage(store *storage.Storage, cutoff time.Time, keepTagNames []string, pretend bool) error {
+	log.Infof(2, "retrieving tags unused since %v", cutoff.Format("2006-01-02 15:04:05"))
+
+	tags, err := store.UnusedTagsSince(cutoff)
+	if err != nil {
+		return fmt.Errorf("could not retrieve unused tags: %v", err)
+	}
+
+	keep := make(map[string]bool, len(keepTagNames))
+	for _, name := range keepTagNames {
+		keep[name] = true
+	}
+
+	for _, tag := range tags {
+		if keep[tag.Name] {
+			log.Infof(2, "keeping tag '%v'", tag.Name)
+			continue
+		}
+
+		if pretend {
+			fmt.Printf("tag '%v': unused\n", tag.Name)
+			continue
+		}
+
+		if err := store.DeleteTag(tag.Id); err != nil {
+			return fmt.Errorf("could not delete tag '%v': %v", tag.Name, err)
+		}
+
+		fmt.Printf("tag '%v': removed\n", tag.Name)
+	}
+
+	log.Infof(2, "retrieving values unused since %v", cutoff.Format("2006-01-02 15:04:05"))
+
+	values, err := store.UnusedValuesSince(cutoff)
+	if err != nil {
+		return fmt.Errorf("could not retrieve unused values: %v", err)
+	}
+
+	for _, value := range values {
+		if pretend {
+			fmt.Printf("value '%v': unused\n", value.Name)
+			continue
+		}
+
+		if err := store.DeleteValue(value.Id); err != nil {
+			return fmt.Errorf("could not delete value '%v': %v", value.Name, err)
+		}
+
+		fmt.Printf("value '%v': removed\n", value.Name)
+	}
+
+	return nil
+}
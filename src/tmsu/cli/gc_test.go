@@ -0,0 +1,106 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"os"
+	"testing"
+	"time"
+	"tmsu/common/fingerprint"
+	"tmsu/storage"
+)
+
+func TestGcRemovesTagsUnusedBeyondRetention(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	file, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	for _, name := range []string{"apple", "banana", "cherry", "date"} {
+		if _, err := store.AddTag(name); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	tagApple, err := store.TagByName("apple")
+	if err != nil {
+		test.Fatal(err)
+	}
+	tagBanana, err := store.TagByName("banana")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(file.Id, tagApple.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.AddFileTag(file.Id, tagBanana.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+	if err := store.DeleteFileTag(file.Id, tagBanana.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddValue("stale"); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.Db.Exec("UPDATE tag SET unused_since = datetime('now', '-10 days') WHERE name IN ('banana', 'date')"); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.Db.Exec("UPDATE value SET unused_since = datetime('now', '-10 days')"); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	options := Options{Option{"--retention", "-r", "", true, "7d"},
+		Option{"--keep-tags", "-k", "", true, "date"}}
+	if err := GcCommand.Exec(store, options, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	tags, err := store.Tags()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(tags) != 3 || tags[0].Name != "apple" || tags[1].Name != "cherry" || tags[2].Name != "date" {
+		test.Fatalf("Unexpected tags remain: %v.", tags)
+	}
+
+	values, err := store.Values()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(values) != 0 {
+		test.Fatalf("Unused value was not removed: %v.", values)
+	}
+}
@@ -0,0 +1,328 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"tmsu/common/fingerprint"
+	"tmsu/common/log"
+	"tmsu/entities"
+	"tmsu/storage"
+)
+
+var GitSyncCommand = Command{
+	Name:     "git-sync",
+	Synopsis: "Reconcile tagged files with a git work tree",
+	Usages:   []string{"tmsu git-sync [OPTION]... [PATH]..."},
+	Description: `Updates the database so that the tags of the files within the git work trees containing each PATH follow the files' contents across checkouts, branch switches and renames. If no PATH is specified then the work tree containing the working directory is synchronized.
+
+Each tagged file whose contents have changed, or which no longer exists, is moved to a tracked file with the contents it had when it was last seen. Where there is no such file, a changed file keeps its tags and has its fingerprint updated whilst a missing file is reported.
+
+Only files tracked by git are considered. Tracked files can also be excluded by unsetting the 'tmsu' attribute for them in '.gitattributes', e.g. '*.o -tmsu'.
+
+The files are matched by fingerprint, so the 'fingerprintAlgorithm' setting should be 'gitBlob' for a database that tags a git work tree. With this algorithm each file's fingerprint is the hash git gives its contents.`,
+	Examples: []string{"$ git checkout feature && tmsu git-sync",
+		"$ tmsu git-sync --pretend ~/src/project"},
+	Options: Options{{"--pretend", "-p", "do not make any changes", false, ""}},
+	Exec:    gitSyncExec,
+}
+
+func gitSyncExec(store *storage.Storage, options Options, args []string) error {
+	pretend := options.HasOption("--pretend")
+
+	paths := args
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	fingerprintAlgorithm, err := store.SettingAsString("fingerprintAlgorithm")
+	if err != nil {
+		return fmt.Errorf("could not retrieve setting 'fingerprintAlgorithm': %v", err)
+	}
+
+	wereErrors := false
+	for _, path := range paths {
+		workTree, err := gitWorkTree(path)
+		if err != nil {
+			log.Warnf("%v: %v", path, err)
+			wereErrors = true
+			continue
+		}
+
+		if err := gitSync(store, workTree, fingerprintAlgorithm, pretend); err != nil {
+			return err
+		}
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+// unexported
+
+func gitSync(store *storage.Storage, workTree, fingerprintAlgorithm string, pretend bool) error {
+	log.Infof(2, "%v: synchronizing git work tree", workTree)
+
+	trackedPaths, err := gitTrackedFiles(workTree)
+	if err != nil {
+		return err
+	}
+
+	fingerprintByPath := make(map[string]fingerprint.Fingerprint, len(trackedPaths))
+	pathsByFingerprint := make(map[fingerprint.Fingerprint][]string, len(trackedPaths))
+	for _, path := range trackedPaths {
+		fp, err := fingerprint.Create(path, fingerprintAlgorithm)
+		if err != nil {
+			return fmt.Errorf("%v: could not create fingerprint: %v", path, err)
+		}
+
+		fingerprintByPath[path] = fp
+		pathsByFingerprint[fp] = append(pathsByFingerprint[fp], path)
+	}
+
+	dbFiles, err := store.FilesByDirectory(workTree)
+	if err != nil {
+		return fmt.Errorf("%v: could not retrieve files from database: %v", workTree, err)
+	}
+
+	fileByPath := make(map[string]*entities.File, len(dbFiles))
+	stale := make(entities.Files, 0, 10)
+	for _, dbFile := range dbFiles {
+		if dbFile.IsDir {
+			continue
+		}
+
+		path := dbFile.Path()
+		fileByPath[path] = dbFile
+
+		fp, tracked := fingerprintByPath[path]
+		switch {
+		case tracked && fp == dbFile.Fingerprint:
+			continue
+		case !tracked:
+			if _, err := os.Lstat(path); err == nil {
+				// untracked or excluded files are not ours to reconcile
+				continue
+			}
+		}
+
+		stale = append(stale, dbFile)
+	}
+
+	targets := planGitMoves(stale, fileByPath, fingerprintByPath, pathsByFingerprint)
+
+	if !pretend {
+		// move via a temporary path so that files can swap places
+		for _, dbFile := range stale {
+			if _, ok := targets[dbFile.Id]; !ok {
+				continue
+			}
+
+			tempPath := filepath.Join(workTree, fmt.Sprintf(".tmsu-git-sync-%v", dbFile.Id))
+			if _, err := store.UpdateFile(dbFile.Id, tempPath, dbFile.Fingerprint, dbFile.ModTime, dbFile.Size, dbFile.IsDir); err != nil {
+				return fmt.Errorf("%v: could not update file in database: %v", dbFile.Path(), err)
+			}
+		}
+	}
+
+	for _, dbFile := range stale {
+		target, moved := targets[dbFile.Id]
+
+		switch {
+		case moved:
+			if !pretend {
+				stat, err := os.Stat(target)
+				if err != nil {
+					return fmt.Errorf("%v: could not stat file: %v", target, err)
+				}
+
+				if _, err := store.UpdateFile(dbFile.Id, target, dbFile.Fingerprint, stat.ModTime(), stat.Size(), false); err != nil {
+					return fmt.Errorf("%v: could not update file in database: %v", dbFile.Path(), err)
+				}
+			}
+
+			fmt.Printf("%v: updated path to %v\n", dbFile.Path(), target)
+		case fingerprintByPath[dbFile.Path()] != "":
+			if !pretend {
+				stat, err := os.Stat(dbFile.Path())
+				if err != nil {
+					return fmt.Errorf("%v: could not stat file: %v", dbFile.Path(), err)
+				}
+
+				file, err := store.UpdateFile(dbFile.Id, dbFile.Path(), fingerprintByPath[dbFile.Path()], stat.ModTime(), stat.Size(), false)
+				if err != nil {
+					return fmt.Errorf("%v: could not update file in database: %v", dbFile.Path(), err)
+				}
+
+				if err := store.UpdateExtraFingerprints(file); err != nil {
+					return err
+				}
+			}
+
+			fmt.Printf("%v: updated fingerprint\n", dbFile.Path())
+		default:
+			fmt.Printf("%v: missing\n", dbFile.Path())
+		}
+	}
+
+	return nil
+}
+
+// Determines the path each stale file should move to. A file may not move
+// onto a path that is held by a file that is staying put, so such moves are
+// abandoned until no more can be.
+func planGitMoves(stale entities.Files, fileByPath map[string]*entities.File, fingerprintByPath map[string]fingerprint.Fingerprint, pathsByFingerprint map[fingerprint.Fingerprint][]string) map[entities.FileId]string {
+	isStale := make(map[entities.FileId]bool, len(stale))
+	for _, dbFile := range stale {
+		isStale[dbFile.Id] = true
+	}
+
+	targets := make(map[entities.FileId]string, len(stale))
+	claimed := make(map[string]bool, len(stale))
+	for _, dbFile := range stale {
+		for _, path := range pathsByFingerprint[dbFile.Fingerprint] {
+			if claimed[path] {
+				continue
+			}
+			if holder := fileByPath[path]; holder != nil && !isStale[holder.Id] {
+				continue
+			}
+
+			targets[dbFile.Id] = path
+			claimed[path] = true
+			break
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+
+		for id, path := range targets {
+			holder := fileByPath[path]
+			if holder == nil || holder.Id == id {
+				continue
+			}
+			if _, moving := targets[holder.Id]; moving {
+				continue
+			}
+
+			delete(targets, id)
+			changed = true
+		}
+	}
+
+	return targets
+}
+
+func gitWorkTree(path string) (string, error) {
+	output, err := runGit(path, nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", fmt.Errorf("could not identify git work tree: %v", err)
+	}
+
+	return filepath.Clean(strings.TrimSpace(string(output))), nil
+}
+
+// Lists the files tracked by git within the work tree, excluding those for
+// which the 'tmsu' attribute is unset and those absent from the work tree.
+func gitTrackedFiles(workTree string) ([]string, error) {
+	output, err := runGit(workTree, nil, "ls-files", "-z")
+	if err != nil {
+		return nil, fmt.Errorf("%v: could not list tracked files: %v", workTree, err)
+	}
+
+	relPaths := splitNul(output)
+	if len(relPaths) == 0 {
+		return nil, nil
+	}
+
+	output, err = runGit(workTree, output, "check-attr", "-z", "--stdin", "tmsu")
+	if err != nil {
+		return nil, fmt.Errorf("%v: could not check attributes: %v", workTree, err)
+	}
+
+	excluded := make(map[string]bool)
+	fields := splitNul(output)
+	for index := 0; index+2 < len(fields); index += 3 {
+		if fields[index+2] == "unset" {
+			excluded[fields[index]] = true
+		}
+	}
+
+	paths := make([]string, 0, len(relPaths))
+	for _, relPath := range relPaths {
+		if excluded[relPath] {
+			log.Infof(2, "%v: excluded by .gitattributes", relPath)
+			continue
+		}
+
+		path := filepath.Join(workTree, filepath.FromSlash(relPath))
+
+		stat, err := os.Lstat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, fmt.Errorf("%v: could not stat file: %v", path, err)
+		}
+		if !stat.Mode().IsRegular() {
+			continue
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+func runGit(dir string, input []byte, args ...string) ([]byte, error) {
+	command := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	command.Stdin = bytes.NewReader(input)
+
+	var stderr bytes.Buffer
+	command.Stderr = &stderr
+
+	output, err := command.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%v", message)
+		}
+
+		return nil, err
+	}
+
+	return output, nil
+}
+
+func splitNul(data []byte) []string {
+	text := strings.TrimRight(string(data), "\x00")
+	if text == "" {
+		return nil
+	}
+
+	return strings.Split(text, "\x00")
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestGitSyncFollowsRename(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.Db.Exec("INSERT INTO setting (name, value) VALUES ('fingerprintAlgorithm', 'gitBlob')"); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := createFile("/tmp/tmsu/git/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll("/tmp/tmsu/git")
+
+	if err := createFile("/tmp/tmsu/git/b", "world"); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := createFile("/tmp/tmsu/git/.gitattributes", "b -tmsu\n"); err != nil {
+		test.Fatal(err)
+	}
+
+	for _, args := range [][]string{{"init", "-q"}, {"add", "a", "b"}} {
+		if err := exec.Command("git", append([]string{"-C", "/tmp/tmsu/git"}, args...)...).Run(); err != nil {
+			test.Skipf("git is unavailable: %v", err)
+		}
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/git/a", "apple"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := exec.Command("git", "-C", "/tmp/tmsu/git", "mv", "a", "c").Run(); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := GitSyncCommand.Exec(store, Options{}, []string{"/tmp/tmsu/git"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	files, err := store.Files()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(files) != 1 {
+		test.Fatalf("Expected one file but are %v", len(files))
+	}
+	if files[0].Path() != "/tmp/tmsu/git/c" {
+		test.Fatalf("Expected file to have moved to '/tmp/tmsu/git/c' but is at '%v'.", files[0].Path())
+	}
+	if files[0].Fingerprint != "b6fc4c620b67d95f953a5c1c1230aaab5db5a1b0" {
+		test.Fatalf("Expected git blob fingerprint but is '%v'.", files[0].Fingerprint)
+	}
+}
@@ -40,22 +40,9 @@ var HelpCommand = Command{
 var helpCommands map[string]*Command
 
 func helpExec(store *storage.Storage, options Options, args []string) error {
-	var colour bool
-	if options.HasOption("--color") {
-		when := options.Get("--color").Argument
-		switch when {
-		case "auto":
-			colour = terminal.Colour() && terminal.Width() > 0
-		case "":
-		case "always":
-			colour = true
-		case "never":
-			colour = false
-		default:
-			return fmt.Errorf("invalid argument '%v' for '--color'", when)
-		}
-	} else {
-		colour = terminal.Colour() && terminal.Width() > 0
+	colour, err := useColour(options)
+	if err != nil {
+		return err
 	}
 
 	if options.HasOption("--list") {
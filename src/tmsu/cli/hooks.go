@@ -0,0 +1,215 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"tmsu/common/log"
+	"tmsu/entities"
+	"tmsu/storage"
+)
+
+// Hooks are executables within the 'hooks' directory alongside the database
+// (e.g. '.tmsu/hooks') that are run before and after the tag, untag and repair
+// operations. They are named after the operation, e.g. 'pre-tag' and
+// 'post-tag'.
+//
+// A pre-hook that exits with a non-zero status aborts the operation, leaving
+// the database unchanged.
+//
+// Once the operation has been attempted its changes are committed and then the
+// post-hook is run, so that the post-hook sees the updated database and may
+// itself run TMSU. The post-hook is run even if the operation failed for some
+// of the files, as the changes made to the others are kept; only if the
+// command is interrupted, which discards the changes, is it skipped. A
+// post-hook that fails is reported, and the command exits with an error, but
+// the changes it followed are not undone.
+//
+// The context of the operation is supplied in the environment:
+//
+//	TMSU_HOOK       the name of the hook
+//	TMSU_DB         the path of the database
+//	TMSU_FILE_LIST  the path of a file listing the files being operated upon
+//	TMSU_TAG_LIST   the path of a file listing the tags being applied or
+//	                removed (empty where all of the files' tags are removed, or
+//	                for 'repair')
+//	TMSU_STATUS     for post-hooks, the outcome of the operation: 'success', or
+//	                'failure' if it failed for some or all of the files
+//
+// The lists are terminated by NUL characters, e.g. for 'xargs -0', as paths and
+// tag names may contain any other character. They are supplied in files rather
+// than the environment as a large operation would exceed the size limit of an
+// environment variable.
+func hooksPath(store *storage.Storage) string {
+	return filepath.Join(filepath.Dir(store.Db.Path), "hooks")
+}
+
+// Runs the operation between its pre- and post-hooks, committing the changes
+// of the operation before the post-hook is run.
+func withHooks(store *storage.Storage, operation string, paths, tagArgs []string, fn func() error) error {
+	if err := runHook(store, "pre-"+operation, paths, tagArgs, ""); err != nil {
+		return err
+	}
+
+	opErr := fn()
+
+	if store.Context().Err() != nil {
+		return opErr
+	}
+
+	if err := store.Checkpoint(); err != nil {
+		return fmt.Errorf("could not commit changes: %v", err)
+	}
+
+	status := "success"
+	if opErr != nil {
+		status = "failure"
+	}
+
+	if err := runHook(store, "post-"+operation, paths, tagArgs, status); err != nil {
+		if opErr != nil {
+			log.Warn(err)
+			return opErr
+		}
+
+		return err
+	}
+
+	return opErr
+}
+
+// Formats the tag/value pairs as they would be given on the command line.
+func hookTagArgs(store *storage.Storage, tagValuePairs []entities.TagValuePair) ([]string, error) {
+	tagArgs := make([]string, len(tagValuePairs))
+	for index, pair := range tagValuePairs {
+		tag, err := store.Tag(pair.TagId)
+		if err != nil {
+			return nil, fmt.Errorf("could not lookup tag: %v", err)
+		}
+		if tag == nil {
+			return nil, fmt.Errorf("tag '%v' does not exist", pair.TagId)
+		}
+
+		tagArgs[index] = tag.Name
+
+		if pair.ValueId != 0 {
+			value, err := store.Value(pair.ValueId)
+			if err != nil {
+				return nil, fmt.Errorf("could not lookup value: %v", err)
+			}
+			if value == nil {
+				return nil, fmt.Errorf("value '%v' does not exist", pair.ValueId)
+			}
+
+			tagArgs[index] += "=" + value.Name
+		}
+	}
+
+	return tagArgs, nil
+}
+
+func runHook(store *storage.Storage, name string, paths, tagArgs []string, status string) error {
+	hookPath := filepath.Join(hooksPath(store), name)
+
+	info, err := os.Stat(hookPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return fmt.Errorf("could not stat hook '%v': %v", hookPath, err)
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		log.Infof(2, "skipping hook '%v' as it is not executable", hookPath)
+		return nil
+	}
+
+	absPaths := make([]string, len(paths))
+	for index, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not get absolute path: %v", path, err)
+		}
+
+		absPaths[index] = absPath
+	}
+
+	fileListPath, err := writeHookList("files", absPaths)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fileListPath)
+
+	tagListPath, err := writeHookList("tags", tagArgs)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tagListPath)
+
+	log.Infof(2, "running hook '%v'", hookPath)
+
+	command := exec.Command(hookPath)
+	command.Stdin = os.Stdin
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	command.Env = append(os.Environ(),
+		"TMSU_HOOK="+name,
+		"TMSU_DB="+store.Db.Path,
+		"TMSU_FILE_LIST="+fileListPath,
+		"TMSU_TAG_LIST="+tagListPath)
+	if status != "" {
+		command.Env = append(command.Env, "TMSU_STATUS="+status)
+	}
+
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("hook '%v' failed: %v", name, err)
+	}
+
+	return nil
+}
+
+// Writes the items, each terminated by a NUL character, to a temporary file for
+// a hook to read, returning its path.
+func writeHookList(name string, items []string) (string, error) {
+	file, err := ioutil.TempFile("", "tmsu-hook-"+name+"-")
+	if err != nil {
+		return "", fmt.Errorf("could not create hook %v list: %v", name, err)
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, item := range items {
+		writer.WriteString(item)
+		writer.WriteByte(0)
+	}
+
+	err = writer.Flush()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("could not write hook %v list: %v", name, err)
+	}
+
+	return file.Name(), nil
+}
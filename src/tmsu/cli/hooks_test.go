@@ -0,0 +1,330 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestTagRunsHooks(test *testing.T) {
+	// set-up
+
+	databasePath := "/tmp/tmsu/hooks/.tmsu/db"
+	if err := os.MkdirAll(filepath.Dir(databasePath), 0777); err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll("/tmp/tmsu/hooks")
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/hooks/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+
+	hook := "#!/bin/sh\n(echo \"$TMSU_HOOK\"; cat \"$TMSU_FILE_LIST\" \"$TMSU_TAG_LIST\") | tr '\\000' '|' >>/tmp/tmsu/hooks/log\n"
+	if err := createFile("/tmp/tmsu/hooks/.tmsu/hooks/post-tag", hook); err != nil {
+		test.Fatal(err)
+	}
+	if err := os.Chmod("/tmp/tmsu/hooks/.tmsu/hooks/post-tag", 0755); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/hooks/a", "apple", "banana=yellow"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	bytes, err := ioutil.ReadFile("/tmp/tmsu/hooks/log")
+	if err != nil {
+		test.Fatal(err)
+	}
+	compareOutput(test, "post-tag\n/tmp/tmsu/hooks/a|apple|banana=yellow|", string(bytes))
+}
+
+func TestTagAbortedByFailingPreHook(test *testing.T) {
+	// set-up
+
+	databasePath := "/tmp/tmsu/hooks/.tmsu/db"
+	if err := os.MkdirAll(filepath.Dir(databasePath), 0777); err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll("/tmp/tmsu/hooks")
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/hooks/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := createFile("/tmp/tmsu/hooks/.tmsu/hooks/pre-tag", "#!/bin/sh\nexit 1\n"); err != nil {
+		test.Fatal(err)
+	}
+	if err := os.Chmod("/tmp/tmsu/hooks/.tmsu/hooks/pre-tag", 0755); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/hooks/a", "apple"}); err == nil {
+		test.Fatal("Expected the failing pre-tag hook to abort tagging.")
+	}
+
+	// validate
+
+	files, err := store.Files()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(files) != 0 {
+		test.Fatalf("Expected no files but are %v", len(files))
+	}
+}
+
+func TestPostHookSeesCommittedChanges(test *testing.T) {
+	// set-up
+
+	store := openHooksStorage(test)
+	defer os.RemoveAll("/tmp/tmsu/hooks")
+	defer store.Close()
+
+	installHook(test, "post-tag", "#!/bin/sh\ncp \"$TMSU_DB\" /tmp/tmsu/hooks/.tmsu/snapshot\n")
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/hooks/a", "apple"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	snapshot, err := storage.OpenAt("/tmp/tmsu/hooks/.tmsu/snapshot")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer snapshot.Close()
+
+	expectHookTagged(test, snapshot, "/tmp/tmsu/hooks/a", "apple")
+
+	if err := store.Commit(); err != nil {
+		test.Fatal(err)
+	}
+}
+
+func TestPostHookRunsAfterPartialFailure(test *testing.T) {
+	// set-up
+
+	store := openHooksStorage(test)
+	defer os.RemoveAll("/tmp/tmsu/hooks")
+	defer store.Close()
+
+	installHook(test, "post-tag", "#!/bin/sh\necho \"$TMSU_HOOK $TMSU_STATUS\" >>/tmp/tmsu/hooks/log\n")
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	err := TagCommand.Exec(store, Options{Option{"--tags", "-t", "", true, "apple"}}, []string{"/tmp/tmsu/hooks/a", "/tmp/tmsu/hooks/missing"})
+
+	// validate
+
+	if err == nil {
+		test.Fatal("Expected tagging of the missing file to fail.")
+	}
+
+	bytes, err := ioutil.ReadFile("/tmp/tmsu/hooks/log")
+	if err != nil {
+		test.Fatal(err)
+	}
+	compareOutput(test, "post-tag failure\n", string(bytes))
+
+	expectHookTagged(test, store, "/tmp/tmsu/hooks/a", "apple")
+
+	if err := store.Commit(); err != nil {
+		test.Fatal(err)
+	}
+}
+
+func TestFailingPostHookKeepsChanges(test *testing.T) {
+	// set-up
+
+	store := openHooksStorage(test)
+	defer os.RemoveAll("/tmp/tmsu/hooks")
+	defer store.Close()
+
+	installHook(test, "post-tag", "#!/bin/sh\nexit 1\n")
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/hooks/a", "apple"})
+
+	// validate
+
+	if err == nil {
+		test.Fatal("Expected the failing post-tag hook to be reported.")
+	}
+
+	// the changes were committed before the hook ran
+	if err := store.Rollback(); err != nil {
+		test.Fatal(err)
+	}
+
+	expectHookTagged(test, store, "/tmp/tmsu/hooks/a", "apple")
+}
+
+func TestTagFromRunsHooksWithTags(test *testing.T) {
+	// set-up
+
+	store := openHooksStorage(test)
+	defer os.RemoveAll("/tmp/tmsu/hooks")
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/hooks/b", "world"); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/hooks/a", "apple", "banana=yellow"}); err != nil {
+		test.Fatal(err)
+	}
+
+	installHook(test, "post-tag", "#!/bin/sh\ncat \"$TMSU_FILE_LIST\" \"$TMSU_TAG_LIST\" | tr '\\000' '|' >>/tmp/tmsu/hooks/log\n")
+
+	// test
+
+	if err := TagCommand.Exec(store, Options{Option{"--from", "-f", "", true, "/tmp/tmsu/hooks/a"}}, []string{"/tmp/tmsu/hooks/b"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	bytes, err := ioutil.ReadFile("/tmp/tmsu/hooks/log")
+	if err != nil {
+		test.Fatal(err)
+	}
+	compareOutput(test, "/tmp/tmsu/hooks/b|apple|banana=yellow|", string(bytes))
+}
+
+func TestHookListsExceedingEnvironmentLimit(test *testing.T) {
+	// set-up
+
+	store := openHooksStorage(test)
+	defer os.RemoveAll("/tmp/tmsu/hooks")
+	defer store.Close()
+
+	installHook(test, "pre-tag", "#!/bin/sh\ntr -cd '\\000' <\"$TMSU_FILE_LIST\" | wc -c >/tmp/tmsu/hooks/log\ntr '\\000' '|' <\"$TMSU_TAG_LIST\" >>/tmp/tmsu/hooks/log\n")
+
+	// more than the 128KiB permitted of an environment variable
+	paths := make([]string, 2000)
+	for index := range paths {
+		paths[index] = fmt.Sprintf("/tmp/tmsu/hooks/%v/%v", strings.Repeat("x", 100), index)
+	}
+
+	// test
+
+	if err := runHook(store, "pre-tag", paths, []string{"big apple", "colour=light blue"}, ""); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	bytes, err := ioutil.ReadFile("/tmp/tmsu/hooks/log")
+	if err != nil {
+		test.Fatal(err)
+	}
+	lines := strings.SplitN(string(bytes), "\n", 2)
+	if strings.TrimSpace(lines[0]) != "2000" {
+		test.Fatalf("Expected 2000 files but the hook read %v.", strings.TrimSpace(lines[0]))
+	}
+	compareOutput(test, "big apple|colour=light blue|", lines[1])
+}
+
+// unexported
+
+func openHooksStorage(test *testing.T) *storage.Storage {
+	databasePath := "/tmp/tmsu/hooks/.tmsu/db"
+	if err := os.MkdirAll(filepath.Dir(databasePath), 0777); err != nil {
+		test.Fatal(err)
+	}
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if err := createFile("/tmp/tmsu/hooks/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+
+	return store
+}
+
+func installHook(test *testing.T, name, script string) {
+	hookPath := filepath.Join("/tmp/tmsu/hooks/.tmsu/hooks", name)
+	if err := createFile(hookPath, script); err != nil {
+		test.Fatal(err)
+	}
+	if err := os.Chmod(hookPath, 0755); err != nil {
+		test.Fatal(err)
+	}
+}
+
+func expectHookTagged(test *testing.T, store *storage.Storage, path, tagName string) {
+	file, err := store.FileByPath(path)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if file == nil {
+		test.Fatalf("File '%v' was not added.", path)
+	}
+
+	tag, err := store.TagByName(tagName)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if tag == nil {
+		test.Fatalf("Tag '%v' was not added.", tagName)
+	}
+
+	expectTags(test, store, file, tag)
+}
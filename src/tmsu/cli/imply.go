@@ -18,26 +18,37 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"sort"
+	"strings"
 	"tmsu/common/log"
+	"tmsu/entities"
 	"tmsu/storage"
 )
 
 var ImplyCommand = Command{
 	Name:     "imply",
 	Synopsis: "Creates a tag implication",
-	Usages: []string{"tmsu imply [OPTION] TAG IMPL...",
-		"tmsu imply --list"},
-	Description: `Creates a tag implication such that whenever TAG is applied, IMPL are automatically applied.
+	Usages: []string{"tmsu imply [OPTION] TAG[=VALUE] IMPL...",
+		"tmsu imply --list",
+		"tmsu imply --graph FORMAT"},
+	Description: `Creates a tag implication such that whenever TAG is applied, IMPL are automatically applied. Where a VALUE is given the implication holds only when TAG is applied with that value.
 
 It is possible that a file may end up with the same tag applied explicitly and by way of a tag implication, making the explicit tag redundant. The decision on whether to keep or remove the redundant explicit tag is with you, but understand that the implied tags are more flexible in that the rules of which tags implies which others can be changed at any time.
 
-The 'tags' subcommand can be used to identify which tags applied to a file are implied.`,
+The 'tags' subcommand can be used to identify which tags applied to a file are implied.
+
+The --graph option prints the implications as a graph, in FORMAT 'dot' for Graphviz or 'json'. As well as the implications themselves the graph includes the transitive implications that follow from them, which are drawn dashed or marked 'transitive' respectively.`,
 	Examples: []string{`$ tmsu imply mp3 music`,
+		`$ tmsu imply country=france france`,
 		`$ tmsu imply --list\nmp3 => music`,
-		`$ tmsu imply --delete mp3 music`},
+		`$ tmsu imply --delete mp3 music`,
+		`$ tmsu imply --graph dot | dot -Tsvg >implications.svg`},
 	Options: Options{Option{"--delete", "-d", "deletes the tag implication", false, ""},
-		Option{"--list", "-l", "lists the tag implications", false, ""}},
+		Option{"--list", "-l", "lists the tag implications", false, ""},
+		Option{"--graph", "-g", "prints the tag implications as a graph in FORMAT: dot or json", true, ""}},
 	Exec: implyExec,
 }
 
@@ -45,6 +56,8 @@ func implyExec(store *storage.Storage, options Options, args []string) error {
 	switch {
 	case options.HasOption("--list"):
 		return listImplications(store)
+	case options.HasOption("--graph"):
+		return graphImplications(store, options.Get("--graph").Argument)
 	case options.HasOption("--delete"):
 		if len(args) < 2 {
 			return fmt.Errorf("implying and implied tag must be specified")
@@ -72,23 +85,24 @@ func listImplications(store *storage.Storage) error {
 
 	width := 0
 	for _, implication := range implications {
-		length := len(implication.ImplyingTag.Name)
+		length := len(implication.ImplyingName())
 		if length > width {
 			width = length
 		}
 	}
 
 	if len(implications) > 0 {
-		previousImplyingTagName := ""
+		previousImplyingName := ""
 		for _, implication := range implications {
-			if implication.ImplyingTag.Name != previousImplyingTagName {
-				if previousImplyingTagName != "" {
+			implyingName := implication.ImplyingName()
+			if implyingName != previousImplyingName {
+				if previousImplyingName != "" {
 					fmt.Println()
 				}
 
-				previousImplyingTagName = implication.ImplyingTag.Name
+				previousImplyingName = implyingName
 
-				fmt.Printf("%*v => %v", width, implication.ImplyingTag.Name, implication.ImpliedTag.Name)
+				fmt.Printf("%*v => %v", width, implyingName, implication.ImpliedTag.Name)
 			} else {
 				fmt.Printf(" %v", implication.ImpliedTag.Name)
 			}
@@ -100,15 +114,131 @@ func listImplications(store *storage.Storage) error {
 	return nil
 }
 
-func addImplications(store *storage.Storage, tagName string, impliedTagNames []string) error {
-	log.Infof(2, "looking up tag '%v'.", tagName)
+// An edge of the implication graph, as printed by --graph.
+type implicationEdge struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Transitive bool   `json:"transitive"`
+}
 
-	tag, err := store.TagByName(tagName)
+// The implication graph, as printed by --graph json.
+type implicationGraph struct {
+	Tags  []string          `json:"tags"`
+	Edges []implicationEdge `json:"edges"`
+}
+
+func graphImplications(store *storage.Storage, format string) error {
+	if format != "dot" && format != "json" {
+		return usageError{fmt.Errorf("invalid graph format '%v': expected 'dot' or 'json'", format)}
+	}
+
+	log.Infof(2, "retrieving tag implications.")
+
+	implications, err := store.Implications()
 	if err != nil {
-		return fmt.Errorf("could not retrieve tag '%v': %v", tagName, err)
+		return fmt.Errorf("could not retrieve implications: %v", err)
 	}
-	if tag == nil {
-		return fmt.Errorf("no such tag '%v'", tagName)
+
+	graph := buildImplicationGraph(implications)
+
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		if err := encoder.Encode(graph); err != nil {
+			return fmt.Errorf("could not write graph: %v", err)
+		}
+
+		return nil
+	}
+
+	fmt.Println("digraph implications {")
+	for _, tagName := range graph.Tags {
+		fmt.Printf("    %v;\n", dotId(tagName))
+	}
+	for _, edge := range graph.Edges {
+		if edge.Transitive {
+			fmt.Printf("    %v -> %v [style=dashed];\n", dotId(edge.From), dotId(edge.To))
+		} else {
+			fmt.Printf("    %v -> %v;\n", dotId(edge.From), dotId(edge.To))
+		}
+	}
+	fmt.Println("}")
+
+	return nil
+}
+
+// Builds the graph of the implications together with the transitive
+// implications that follow from them.
+func buildImplicationGraph(implications entities.Implications) implicationGraph {
+	impliedByTag := make(map[string][]string)
+	tagNames := make([]string, 0, len(implications))
+	seen := make(map[string]bool)
+
+	addTag := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			tagNames = append(tagNames, name)
+		}
+	}
+
+	edges := make([]implicationEdge, 0, len(implications))
+	for _, implication := range implications {
+		from, to := implication.ImplyingName(), implication.ImpliedTag.Name
+
+		addTag(from)
+		addTag(to)
+
+		impliedByTag[from] = append(impliedByTag[from], to)
+		edges = append(edges, implicationEdge{from, to, false})
+	}
+
+	sort.Strings(tagNames)
+
+	for _, from := range tagNames {
+		direct := make(map[string]bool, len(impliedByTag[from]))
+		for _, to := range impliedByTag[from] {
+			direct[to] = true
+		}
+
+		reached := map[string]bool{from: true}
+		pending := append([]string{}, impliedByTag[from]...)
+		transitive := []string{}
+
+		for len(pending) > 0 {
+			to := pending[0]
+			pending = pending[1:]
+
+			if reached[to] {
+				continue
+			}
+			reached[to] = true
+
+			if !direct[to] {
+				transitive = append(transitive, to)
+			}
+
+			pending = append(pending, impliedByTag[to]...)
+		}
+
+		sort.Strings(transitive)
+		for _, to := range transitive {
+			edges = append(edges, implicationEdge{from, to, true})
+		}
+	}
+
+	return implicationGraph{tagNames, edges}
+}
+
+// Quotes the name as a Graphviz identifier.
+func dotId(name string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+}
+
+func addImplications(store *storage.Storage, tagArg string, impliedTagNames []string) error {
+	tag, value, err := lookupImplyingTag(store, tagArg, true)
+	if err != nil {
+		return err
 	}
 
 	for _, impliedTagName := range impliedTagNames {
@@ -119,28 +249,23 @@ func addImplications(store *storage.Storage, tagName string, impliedTagNames []s
 			return fmt.Errorf("could not retrieve tag '%v': %v", impliedTagName, err)
 		}
 		if impliedTag == nil {
-			return fmt.Errorf("no such tag '%v'", impliedTagName)
+			return noSuchTagError{impliedTagName}
 		}
 
-		log.Infof(2, "adding tag implication of '%v' to '%v'", tagName, impliedTagName)
+		log.Infof(2, "adding tag implication of '%v' to '%v'", tagArg, impliedTagName)
 
-		if err = store.AddImplication(tag.Id, impliedTag.Id); err != nil {
-			return fmt.Errorf("could not add tag implication of '%v' to '%v': %v", tagName, impliedTagName, err)
+		if err = store.AddImplication(tag.Id, value.Id, impliedTag.Id); err != nil {
+			return fmt.Errorf("could not add tag implication of '%v' to '%v': %v", tagArg, impliedTagName, err)
 		}
 	}
 
 	return nil
 }
 
-func deleteImplications(store *storage.Storage, tagName string, impliedTagNames []string) error {
-	log.Infof(2, "looking up tag '%v'.", tagName)
-
-	tag, err := store.TagByName(tagName)
+func deleteImplications(store *storage.Storage, tagArg string, impliedTagNames []string) error {
+	tag, value, err := lookupImplyingTag(store, tagArg, false)
 	if err != nil {
-		return fmt.Errorf("could not retrieve tag '%v': %v", tagName, err)
-	}
-	if tag == nil {
-		return fmt.Errorf("no such tag '%v'", tagName)
+		return err
 	}
 
 	for _, impliedTagName := range impliedTagNames {
@@ -151,15 +276,55 @@ func deleteImplications(store *storage.Storage, tagName string, impliedTagNames
 			return fmt.Errorf("could not retrieve tag '%v': %v", impliedTagName, err)
 		}
 		if impliedTag == nil {
-			return fmt.Errorf("no such tag '%v'", impliedTagName)
+			return noSuchTagError{impliedTagName}
 		}
 
-		log.Infof(2, "removing tag implication of '%v' to '%v'.", tagName, impliedTagName)
+		log.Infof(2, "removing tag implication of '%v' to '%v'.", tagArg, impliedTagName)
 
-		if err = store.RemoveImplication(tag.Id, impliedTag.Id); err != nil {
-			return fmt.Errorf("could not delete tag implication of '%v' to '%v': %v", tagName, impliedTagName, err)
+		if err = store.RemoveImplication(tag.Id, value.Id, impliedTag.Id); err != nil {
+			return fmt.Errorf("could not delete tag implication of '%v' to '%v': %v", tagArg, impliedTagName, err)
 		}
 	}
 
 	return nil
 }
+
+// Looks up the implying tag and, if given as TAG=VALUE, its value. The value is
+// created if it does not exist, create is specified and the 'autoCreateValues'
+// setting is enabled.
+func lookupImplyingTag(store *storage.Storage, tagArg string, create bool) (*entities.Tag, *entities.Value, error) {
+	tagName, valueName := tagArg, ""
+	if index := strings.Index(tagArg, "="); index > 0 {
+		tagName, valueName = tagArg[:index], tagArg[index+1:]
+	}
+
+	log.Infof(2, "looking up tag '%v'.", tagName)
+
+	tag, err := store.TagByName(tagName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not retrieve tag '%v': %v", tagName, err)
+	}
+	if tag == nil {
+		return nil, nil, noSuchTagError{tagName}
+	}
+
+	value, err := getValue(store, valueName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if value == nil {
+		autoCreateValues, err := store.SettingAsBool("autoCreateValues")
+		if err != nil {
+			return nil, nil, err
+		}
+		if !create || !autoCreateValues {
+			return nil, nil, fmt.Errorf("no such value '%v'", valueName)
+		}
+
+		if value, err = createValue(store, valueName); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return tag, value, nil
+}
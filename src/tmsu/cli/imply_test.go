@@ -0,0 +1,126 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestImplyGraphDot(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, name := range []string{"mp3", "music", "audio"} {
+		if _, err := store.AddTag(name); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	if err := ImplyCommand.Exec(store, Options{}, []string{"mp3", "music"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := ImplyCommand.Exec(store, Options{}, []string{"music", "audio"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := ImplyCommand.Exec(store, Options{Option{"--graph", "-g", "", true, "dot"}}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, `digraph implications {
+    "audio";
+    "mp3";
+    "music";
+    "mp3" -> "music";
+    "music" -> "audio";
+    "mp3" -> "audio" [style=dashed];
+}
+`, string(bytes))
+}
+
+func TestImplyValueList(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, name := range []string{"country", "france", "europe"} {
+		if _, err := store.AddTag(name); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	if err := ImplyCommand.Exec(store, Options{}, []string{"country=france", "france", "europe"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := ImplyCommand.Exec(store, Options{}, []string{"country", "europe"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := ImplyCommand.Exec(store, Options{Option{"--delete", "-d", "", false, ""}}, []string{"country=france", "europe"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := ImplyCommand.Exec(store, Options{Option{"--list", "-l", "", false, ""}}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "       country => europe\ncountry=france => france\n", string(bytes))
+}
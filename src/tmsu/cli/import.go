@@ -0,0 +1,811 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"tmsu/common/checksum"
+	"tmsu/common/fingerprint"
+	"tmsu/common/log"
+	_path "tmsu/common/path"
+	"tmsu/entities"
+	"tmsu/storage"
+	"tmsu/storage/database"
+)
+
+var ImportCommand = Command{
+	Name:     "import",
+	Synopsis: "Reconcile the tags of files with a matrix",
+	Usages: []string{"tmsu import --csv FILE",
+		"tmsu import --implications FILE",
+		"tmsu import --from-tagspaces DIR",
+		"tmsu import --from-digikam FILE",
+		"tmsu import --checksums FILE [TAG[=VALUE]...]"},
+	Description: `Reconciles the tags of the files listed in the CSV matrix FILE, as written by 'export --csv', so that each file has exactly the tags and values given for it, applying and removing tags as necessary.
+
+The first column holds the path of each file and the first row the name of the tag of each remaining column. Only the tags with a column are affected: the files' other tags are retained. A cell of 'yes' applies the tag without a value, otherwise each space-separated entry in the cell is applied as a value. An empty cell removes the tag.
+
+Implied tags are not explicitly applied. Relative paths are resolved against the working directory. A FILE of '-' reads the matrix from standard input.
+
+With --implications the tag implications are instead added from the rules in FILE, as written by 'export --implications'. Each line holds a rule of the form 'TAG[=VALUE] -> IMPL, IMPL...'. Blank lines and lines starting with '#' are ignored. Tags that do not exist are created if the 'autoCreateTags' setting is enabled. The existing implications are retained.
+
+The rules are checked before any is added: should a rule be malformed, name a tag that does not exist, have a tag imply itself or, together with the other rules and the existing implications, form a cycle then none are added.
+
+With --from-tagspaces the tags TagSpaces has applied to the files within DIR are imported, both those in the file names, e.g. 'beach[holiday 2014].jpg', and those in the sidecar files of its '.ts' directories. The files are not renamed.
+
+With --from-digikam the tags are imported from the digiKam database FILE, typically 'digikam4.db'. A nested tag is applied as a value of its parent tag, e.g. 'Places/Paris' as 'Places=Paris'.
+
+With --checksums the SHA-256 checksums in the manifest FILE, as written by 'sha256sum' or 'export --checksums', are recorded as the fingerprints of the files listed so that they need not be calculated again. Where TAGs are specified they are applied to each file listed, adding those files not yet in the database; otherwise only the files already in the database are updated. A checksum is recorded as a file's fingerprint where the 'fingerprintAlgorithm' setting is 'SHA256', or 'dynamic:SHA256' for a file of up to 5MB, and as its 'SHA256' fingerprint where that is one of the 'extraFingerprintAlgorithms'. Relative paths are resolved against the working directory. The manifest may also list URLs, such as 's3://bucket/key', supplying the fingerprints of files that cannot be fetched.
+
+Tags whose names are not valid in TMSU, for example because they contain spaces, are reported and skipped.`,
+	Examples: []string{"$ tmsu export --csv > tags.csv\n$ libreoffice tags.csv\n$ tmsu import --csv tags.csv",
+		"$ tmsu --database=a.db export --implications > rules.txt\n$ tmsu --database=b.db import --implications rules.txt",
+		"$ tmsu import --from-tagspaces ~/Documents",
+		"$ tmsu import --from-digikam ~/Pictures/digikam4.db",
+		"$ sha256sum *.iso > SHA256SUMS\n$ tmsu import --checksums SHA256SUMS iso"},
+	Options: Options{{"--csv", "", "read a CSV matrix", false, ""},
+		{"--implications", "", "read tag implication rules", false, ""},
+		{"--from-tagspaces", "", "import the tags applied by TagSpaces", false, ""},
+		{"--from-digikam", "", "import the tags from a digiKam database", false, ""},
+		{"--checksums", "", "read a SHA-256 checksum manifest", false, ""}},
+	Exec: importExec,
+}
+
+func importExec(store *storage.Storage, options Options, args []string) error {
+	switch {
+	case options.HasOption("--from-tagspaces"):
+		if len(args) != 1 {
+			return fmt.Errorf("a single directory to import must be specified")
+		}
+
+		return importTagSpaces(store, args[0])
+	case options.HasOption("--from-digikam"):
+		if len(args) != 1 {
+			return fmt.Errorf("a single digiKam database must be specified")
+		}
+
+		return importDigikam(store, args[0])
+	case options.HasOption("--checksums"):
+		if len(args) == 0 {
+			return fmt.Errorf("a checksum manifest must be specified")
+		}
+
+		return importChecksums(store, args[0], args[1:])
+	}
+
+	var importer func(*storage.Storage, io.Reader) error
+	switch {
+	case options.HasOption("--csv"):
+		importer = importMatrix
+	case options.HasOption("--implications"):
+		importer = importImplications
+	default:
+		return usageError{fmt.Errorf("an import format must be specified: --csv, --implications, --checksums, --from-tagspaces or --from-digikam")}
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("a single file to import must be specified")
+	}
+
+	if args[0] == "-" {
+		return importer(store, os.Stdin)
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("could not open '%v': %v", args[0], err)
+	}
+	defer file.Close()
+
+	return importer(store, file)
+}
+
+// unexported
+
+func importMatrix(store *storage.Storage, reader io.Reader) error {
+	csvReader := csv.NewReader(reader)
+
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read matrix: %v", err)
+	}
+
+	columnTagNames := header[1:]
+	for _, tagName := range columnTagNames {
+		if tagName == "" {
+			return fmt.Errorf("could not read matrix: a column has no tag name")
+		}
+	}
+
+	wereErrors := false
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("could not read matrix: %v", err)
+		}
+
+		path := record[0]
+
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not get absolute path: %v", path, err)
+		}
+
+		if _, err := os.Lstat(absPath); err != nil {
+			log.Warnf("%v: could not stat file: %v", path, err)
+			wereErrors = true
+			continue
+		}
+
+		currentTagNames, impliedTagNames, err := editableTagNames(store, absPath)
+		if err != nil {
+			return err
+		}
+
+		wantedTagNames := wantedMatrixTagNames(currentTagNames, impliedTagNames, columnTagNames, record[1:])
+
+		if err := reconcileTags(store, absPath, currentTagNames, wantedTagNames); err != nil {
+			if err != errBlank {
+				return err
+			}
+
+			wereErrors = true
+		}
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+// Determines the tags the file should have: those of its current tags that
+// are not in the matrix and those in its row of the matrix.
+func wantedMatrixTagNames(currentTagNames, impliedTagNames, columnTagNames, cells []string) []string {
+	inMatrix := make(map[string]bool, len(columnTagNames))
+	for _, tagName := range columnTagNames {
+		inMatrix[tagName] = true
+	}
+
+	wantedTagNames := make([]string, 0, len(currentTagNames))
+	for _, tagName := range currentTagNames {
+		name, _ := matrixEntry(tagName)
+		if !inMatrix[name] {
+			wantedTagNames = append(wantedTagNames, tagName)
+		}
+	}
+
+	for index, cell := range cells {
+		if index >= len(columnTagNames) {
+			break
+		}
+
+		for _, cellEntry := range strings.Fields(cell) {
+			tagName := matrixTagName(columnTagNames[index], cellEntry)
+			if !containsTag(wantedTagNames, tagName) && !containsTag(impliedTagNames, tagName) {
+				wantedTagNames = append(wantedTagNames, tagName)
+			}
+		}
+	}
+
+	sort.Strings(wantedTagNames)
+
+	return wantedTagNames
+}
+
+// A tag implication read from a rules file.
+type implicationRule struct {
+	tagName        string
+	valueName      string
+	impliedTagName string
+	line           int
+}
+
+// The implying tag and, if there is one, value as TAG or TAG=VALUE.
+func (rule implicationRule) implyingName() string {
+	if rule.valueName == "" {
+		return rule.tagName
+	}
+
+	return rule.tagName + "=" + rule.valueName
+}
+
+// Adds the tag implications from rules of the form 'TAG[=VALUE] -> IMPL, IMPL...',
+// validating all of them before adding any.
+func importImplications(store *storage.Storage, reader io.Reader) error {
+	rules, err := readImplicationRules(reader)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		if rule.tagName == rule.impliedTagName {
+			return fmt.Errorf("line %v: tag '%v' cannot imply itself", rule.line, rule.tagName)
+		}
+	}
+
+	implications, err := store.Implications()
+	if err != nil {
+		return fmt.Errorf("could not retrieve implications: %v", err)
+	}
+
+	impliedByTag := make(map[string][]string, len(implications)+len(rules))
+	for _, implication := range implications {
+		implyingName := implication.ImplyingName()
+		impliedByTag[implyingName] = append(impliedByTag[implyingName], implication.ImpliedTag.Name)
+	}
+	for _, rule := range rules {
+		implyingName := rule.implyingName()
+		impliedByTag[implyingName] = append(impliedByTag[implyingName], rule.impliedTagName)
+	}
+
+	if cycle := findImplicationCycle(impliedByTag); cycle != nil {
+		return fmt.Errorf("the implications would form a cycle: %v", strings.Join(cycle, " -> "))
+	}
+
+	autoCreateTags, err := store.SettingAsBool("autoCreateTags")
+	if err != nil {
+		return err
+	}
+
+	tagNames := make([]string, 0, 2*len(rules))
+	for _, rule := range rules {
+		tagNames = append(tagNames, rule.tagName, rule.impliedTagName)
+	}
+
+	tags, err := store.TagsByNames(tagNames)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tags: %v", err)
+	}
+
+	tagsByName := make(map[string]*entities.Tag, len(tagNames))
+	for _, tag := range tags {
+		tagsByName[tag.Name] = tag
+	}
+
+	if !autoCreateTags {
+		for _, tagName := range tagNames {
+			if tagsByName[tagName] == nil {
+				return noSuchTagError{tagName}
+			}
+		}
+	}
+
+	for _, tagName := range tagNames {
+		if tagsByName[tagName] != nil {
+			continue
+		}
+
+		tag, err := createTag(store, tagName)
+		if err != nil {
+			return err
+		}
+
+		tagsByName[tagName] = tag
+	}
+
+	autoCreateValues, err := store.SettingAsBool("autoCreateValues")
+	if err != nil {
+		return err
+	}
+
+	valuesByName := make(map[string]*entities.Value, len(rules))
+	for _, rule := range rules {
+		if _, ok := valuesByName[rule.valueName]; ok {
+			continue
+		}
+
+		value, err := getValue(store, rule.valueName)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			if !autoCreateValues {
+				return fmt.Errorf("no such value '%v'", rule.valueName)
+			}
+
+			if value, err = createValue(store, rule.valueName); err != nil {
+				return err
+			}
+		}
+
+		valuesByName[rule.valueName] = value
+	}
+
+	for _, rule := range rules {
+		tag := tagsByName[rule.tagName]
+		value := valuesByName[rule.valueName]
+		impliedTag := tagsByName[rule.impliedTagName]
+
+		log.Infof(2, "adding tag implication of '%v' to '%v'", rule.implyingName(), rule.impliedTagName)
+
+		if err := store.AddImplication(tag.Id, value.Id, impliedTag.Id); err != nil {
+			return fmt.Errorf("could not add tag implication of '%v' to '%v': %v", rule.implyingName(), rule.impliedTagName, err)
+		}
+	}
+
+	return nil
+}
+
+func readImplicationRules(reader io.Reader) ([]implicationRule, error) {
+	rules := make([]implicationRule, 0, 10)
+
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(text, "->", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %v: expected a rule of the form 'TAG -> IMPL, IMPL...'", line)
+		}
+
+		tagName, valueName := strings.TrimSpace(parts[0]), ""
+		if index := strings.Index(tagName, "="); index > 0 {
+			tagName, valueName = tagName[:index], tagName[index+1:]
+		}
+		if tagName == "" {
+			return nil, fmt.Errorf("line %v: no implying tag", line)
+		}
+
+		for _, impliedTagName := range strings.Split(parts[1], ",") {
+			impliedTagName = strings.TrimSpace(impliedTagName)
+			if impliedTagName == "" {
+				return nil, fmt.Errorf("line %v: missing implied tag", line)
+			}
+
+			rules = append(rules, implicationRule{tagName, valueName, impliedTagName, line})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read implications: %v", err)
+	}
+
+	return rules, nil
+}
+
+// Finds a cycle in the implication graph, returning the tags around it with
+// the first repeated at the end, or nil if there is none.
+func findImplicationCycle(impliedByTag map[string][]string) []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[string]int, len(impliedByTag))
+	trail := make([]string, 0, 10)
+
+	var visit func(tagName string) []string
+	visit = func(tagName string) []string {
+		switch state[tagName] {
+		case visited:
+			return nil
+		case visiting:
+			for index, name := range trail {
+				if name == tagName {
+					return append(append([]string{}, trail[index:]...), tagName)
+				}
+			}
+		}
+
+		state[tagName] = visiting
+		trail = append(trail, tagName)
+
+		for _, impliedTagName := range impliedByTag[tagName] {
+			if cycle := visit(impliedTagName); cycle != nil {
+				return cycle
+			}
+		}
+
+		trail = trail[:len(trail)-1]
+		state[tagName] = visited
+
+		return nil
+	}
+
+	tagNames := make([]string, 0, len(impliedByTag))
+	for tagName := range impliedByTag {
+		tagNames = append(tagNames, tagName)
+	}
+	sort.Strings(tagNames)
+
+	for _, tagName := range tagNames {
+		if cycle := visit(tagName); cycle != nil {
+			return cycle
+		}
+	}
+
+	return nil
+}
+
+// The metadata TagSpaces keeps for a file, or directory, in a '.ts' directory.
+type tagSpacesMetadata struct {
+	Tags []struct {
+		Title string `json:"title"`
+	} `json:"tags"`
+}
+
+// Records the checksums of the manifest as the files' fingerprints, applying
+// the tags, if any, to each of the files.
+func importChecksums(store *storage.Storage, manifestPath string, tagArgs []string) error {
+	reader := io.Reader(os.Stdin)
+	if manifestPath != "-" {
+		file, err := os.Open(manifestPath)
+		if err != nil {
+			return fmt.Errorf("could not open '%v': %v", manifestPath, err)
+		}
+		defer file.Close()
+
+		reader = file
+	}
+
+	entries, err := checksum.ReadManifest(reader, sha256.Size)
+	if err != nil {
+		return fmt.Errorf("could not read checksums: %v", err)
+	}
+
+	algorithm, err := store.SettingAsString("fingerprintAlgorithm")
+	if err != nil {
+		return err
+	}
+
+	extraAlgorithms, err := store.ExtraFingerprintAlgorithms()
+	if err != nil {
+		return err
+	}
+
+	wereErrors := false
+	paths := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		// a URL cannot be examined, so its size and modification time are
+		// those already recorded, if any
+		isUrl := _path.IsUrl(entry.Path)
+		absPath := entry.Path
+		var modTime time.Time
+		var size int64
+
+		if !isUrl {
+			absPath, err = filepath.Abs(entry.Path)
+			if err != nil {
+				return fmt.Errorf("%v: could not get absolute path: %v", entry.Path, err)
+			}
+
+			stat, err := os.Lstat(absPath)
+			if err != nil {
+				if os.IsNotExist(err) {
+					log.Warnf("%v: no such file", entry.Path)
+					wereErrors = true
+					continue
+				}
+
+				return fmt.Errorf("%v: could not stat: %v", entry.Path, err)
+			}
+			if !stat.Mode().IsRegular() {
+				log.Warnf("%v: not a regular file", entry.Path)
+				wereErrors = true
+				continue
+			}
+
+			modTime, size = stat.ModTime(), stat.Size()
+		}
+
+		file, err := store.FileByPath(absPath)
+		if err != nil {
+			return fmt.Errorf("%v: could not retrieve file: %v", entry.Path, err)
+		}
+		if isUrl && file != nil {
+			modTime, size = file.ModTime, file.Size
+		}
+
+		sum := fingerprint.Fingerprint(entry.Checksum)
+		usable := fingerprint.IsSha256Sum(algorithm, size)
+		added := false
+
+		switch {
+		case file == nil && len(tagArgs) == 0:
+			log.Infof(2, "%v: not in the database: skipping", entry.Path)
+			continue
+		case file == nil:
+			fp := sum
+			switch {
+			case !usable && isUrl:
+				fp = fingerprint.EMPTY
+			case !usable:
+				log.Infof(2, "%v: creating fingerprint", entry.Path)
+
+				fp, err = fingerprint.Create(absPath, algorithm)
+				if err != nil {
+					return fmt.Errorf("%v: could not create fingerprint: %v", entry.Path, err)
+				}
+			}
+
+			log.Infof(2, "%v: adding file", entry.Path)
+
+			file, err = store.AddFile(absPath, fp, modTime, size, false)
+			if err != nil {
+				return fmt.Errorf("%v: could not add file to database: %v", entry.Path, err)
+			}
+
+			added = true
+		case usable && file.Fingerprint != sum:
+			log.Infof(2, "%v: updating fingerprint", entry.Path)
+
+			file, err = store.UpdateFile(file.Id, absPath, sum, modTime, size, false)
+			if err != nil {
+				return fmt.Errorf("%v: could not update file in database: %v", entry.Path, err)
+			}
+		}
+
+		for _, extraAlgorithm := range extraAlgorithms {
+			fp := sum
+			if extraAlgorithm != "SHA256" {
+				if !added || isUrl {
+					continue
+				}
+
+				fp, err = fingerprint.Create(absPath, extraAlgorithm)
+				if err != nil {
+					return fmt.Errorf("%v: could not create '%v' fingerprint: %v", entry.Path, extraAlgorithm, err)
+				}
+			}
+
+			if err := store.UpdateFileFingerprint(file.Id, extraAlgorithm, fp); err != nil {
+				return fmt.Errorf("%v: could not record '%v' fingerprint: %v", entry.Path, extraAlgorithm, err)
+			}
+		}
+
+		paths = append(paths, absPath)
+	}
+
+	if len(tagArgs) > 0 && len(paths) > 0 {
+		policy, err := symlinkPolicy(store, nil)
+		if err != nil {
+			return err
+		}
+
+		if err := tagPaths(store, tagArgs, paths, false, false, false, policy); err != nil {
+			if err != errBlank {
+				return err
+			}
+
+			wereErrors = true
+		}
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+// Imports the tags TagSpaces has applied to the files within the directory.
+func importTagSpaces(store *storage.Storage, dirPath string) error {
+	paths := make([]string, 0, 10)
+	tagArgsByPath := make(map[string][]string, 10)
+	wereErrors := false
+
+	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Warnf("%v: %v", path, err)
+			wereErrors = true
+			return nil
+		}
+
+		var tagNames []string
+		if info.IsDir() {
+			if info.Name() == ".ts" {
+				return filepath.SkipDir
+			}
+
+			tagNames, err = tagSpacesSidecarTags(filepath.Join(path, ".ts", "tsm.json"))
+		} else {
+			tagNames, err = tagSpacesSidecarTags(filepath.Join(filepath.Dir(path), ".ts", info.Name()+".json"))
+			tagNames = append(tagSpacesFileNameTags(info.Name()), tagNames...)
+		}
+		if err != nil {
+			log.Warnf("%v: %v", path, err)
+			wereErrors = true
+		}
+
+		for _, tagName := range tagNames {
+			tagArg, err := importedTagArg(tagName, "")
+			if err != nil {
+				log.Warnf("%v: skipping tag '%v': %v", path, tagName, err)
+				wereErrors = true
+				continue
+			}
+
+			if _, ok := tagArgsByPath[path]; !ok {
+				paths = append(paths, path)
+			}
+			tagArgsByPath[path] = append(tagArgsByPath[path], tagArg)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := applyImportedTags(store, paths, tagArgsByPath); err != nil {
+		if err != errBlank {
+			return err
+		}
+
+		wereErrors = true
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+// Reads the tags in a file name of the form 'NAME[TAG TAG...].EXT'.
+func tagSpacesFileNameTags(name string) []string {
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+	if !strings.HasSuffix(stem, "]") {
+		return nil
+	}
+
+	start := strings.LastIndex(stem, "[")
+	if start == -1 {
+		return nil
+	}
+
+	return strings.Fields(stem[start+1 : len(stem)-1])
+}
+
+func tagSpacesSidecarTags(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("could not read TagSpaces metadata: %v", err)
+	}
+
+	var metadata tagSpacesMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("could not parse TagSpaces metadata '%v': %v", path, err)
+	}
+
+	tagNames := make([]string, 0, len(metadata.Tags))
+	for _, tag := range metadata.Tags {
+		tagNames = append(tagNames, tag.Title)
+	}
+
+	return tagNames, nil
+}
+
+// Imports the tags from the digiKam database at the specified path.
+func importDigikam(store *storage.Storage, databasePath string) error {
+	if _, err := os.Stat(databasePath); err != nil {
+		return fmt.Errorf("could not open '%v': %v", databasePath, err)
+	}
+
+	taggings, err := database.DigikamTaggings(databasePath)
+	if err != nil {
+		return fmt.Errorf("could not read digiKam database: %v", err)
+	}
+
+	paths := make([]string, 0, len(taggings))
+	tagArgsByPath := make(map[string][]string, len(taggings))
+	wereErrors := false
+	for _, tagging := range taggings {
+		var tagArg string
+		var err error
+		if tagging.ParentName == "" {
+			tagArg, err = importedTagArg(tagging.TagName, "")
+		} else {
+			tagArg, err = importedTagArg(tagging.ParentName, tagging.TagName)
+		}
+		if err != nil {
+			log.Warnf("%v: skipping tag '%v': %v", tagging.Path, tagging.TagName, err)
+			wereErrors = true
+			continue
+		}
+
+		if _, ok := tagArgsByPath[tagging.Path]; !ok {
+			paths = append(paths, tagging.Path)
+		}
+		tagArgsByPath[tagging.Path] = append(tagArgsByPath[tagging.Path], tagArg)
+	}
+
+	if err := applyImportedTags(store, paths, tagArgsByPath); err != nil {
+		if err != errBlank {
+			return err
+		}
+
+		wereErrors = true
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+// Applies the tags imported from another tagger to each file in turn.
+func applyImportedTags(store *storage.Storage, paths []string, tagArgsByPath map[string][]string) error {
+	policy, err := symlinkPolicy(store, nil)
+	if err != nil {
+		return err
+	}
+
+	wereErrors := false
+	for _, path := range paths {
+		log.Infof(2, "%v: importing tags %v", path, strings.Join(tagArgsByPath[path], ", "))
+
+		if err := tagPaths(store, tagArgsByPath[path], []string{path}, false, false, false, policy); err != nil {
+			if err != errBlank {
+				return err
+			}
+
+			wereErrors = true
+		}
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+// Converts a tag, and optionally value, name from another tagger to a tag
+// argument of the form TAG or TAG=VALUE, checking that they are valid.
+func importedTagArg(tagName, valueName string) (string, error) {
+	if err := storage.ValidateTagName(tagName); err != nil {
+		return "", err
+	}
+
+	if valueName == "" {
+		return tagName, nil
+	}
+
+	if err := storage.ValidateValueName(valueName); err != nil {
+		return "", err
+	}
+
+	return tagName + "=" + valueName, nil
+}
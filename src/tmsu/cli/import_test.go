@@ -0,0 +1,418 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestImportCsvReconcilesTags(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/a", "a"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	if err := createFile("/tmp/tmsu/b", "b"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/b")
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "music", "rock", "year=2015"}); err != nil {
+		test.Fatal(err)
+	}
+
+	matrix := "path,music,year,live\n/tmp/tmsu/a,,2016,=yes\n/tmp/tmsu/b,yes,,\n"
+
+	// test
+
+	if err := importMatrix(store, strings.NewReader(matrix)); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	fileA, err := store.FileByPath("/tmp/tmsu/a")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagNames, err := tagNamesForFile(store, fileA.Id, true, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if strings.Join(tagNames, " ") != "live=yes rock year=2016" {
+		test.Fatalf("Unexpected tags for '/tmp/tmsu/a': %v", tagNames)
+	}
+
+	fileB, err := store.FileByPath("/tmp/tmsu/b")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if fileB == nil {
+		test.Fatal("Expected '/tmp/tmsu/b' to be tagged.")
+	}
+
+	tagNames, err = tagNamesForFile(store, fileB.Id, true, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if strings.Join(tagNames, " ") != "music" {
+		test.Fatalf("Unexpected tags for '/tmp/tmsu/b': %v", tagNames)
+	}
+}
+
+func TestImportImplications(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.AddTag("mp3"); err != nil {
+		test.Fatal(err)
+	}
+
+	rules := "# audio formats\nmp3 -> music, audio\n\nmusic -> media\n"
+
+	// test
+
+	if err := importImplications(store, strings.NewReader(rules)); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	implications, err := store.Implications()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	actual := make([]string, len(implications))
+	for index, implication := range implications {
+		actual[index] = implication.ImplyingTag.Name + " -> " + implication.ImpliedTag.Name
+	}
+
+	expected := "mp3 -> audio, mp3 -> music, music -> media"
+	if strings.Join(actual, ", ") != expected {
+		test.Fatalf("Expected implications '%v' but were '%v'.", expected, strings.Join(actual, ", "))
+	}
+}
+
+func TestImportImplicationsRejectsCycle(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := importImplications(store, strings.NewReader("mp3 -> music\n")); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	err = importImplications(store, strings.NewReader("flac -> music\nmusic -> mp3\n"))
+
+	// validate
+
+	if err == nil || !strings.Contains(err.Error(), "music -> mp3 -> music") {
+		test.Fatalf("Expected the cycle to be reported but error was: %v", err)
+	}
+
+	implications, err := store.Implications()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(implications) != 1 {
+		test.Fatalf("Expected only the original implication but there were %v.", len(implications))
+	}
+}
+
+func TestImportTagSpaces(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/tagspaces/beach[holiday 2014].jpg", "a"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll("/tmp/tmsu/tagspaces")
+
+	if err := createFile("/tmp/tmsu/tagspaces/notes.txt", "b"); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := createFile("/tmp/tmsu/tagspaces/.ts/notes.txt.json", `{"tags":[{"title":"work","type":"sidecar"},{"title":"to do","type":"sidecar"}]}`); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	err = ImportCommand.Exec(store, Options{Option{"--from-tagspaces", "", "", false, ""}}, []string{"/tmp/tmsu/tagspaces"})
+	if err != errBlank {
+		test.Fatalf("expected the invalid tag to be reported but was: %v", err)
+	}
+
+	// validate
+
+	for path, expected := range map[string]string{"/tmp/tmsu/tagspaces/beach[holiday 2014].jpg": "2014 holiday", "/tmp/tmsu/tagspaces/notes.txt": "work"} {
+		file, err := store.FileByPath(path)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if file == nil {
+			test.Fatalf("%v: file was not tagged", path)
+		}
+
+		tagNames, err := tagNamesForFile(store, file.Id, true, false)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		if strings.Join(tagNames, " ") != expected {
+			test.Fatalf("%v: expected tags '%v' but were '%v'", path, expected, strings.Join(tagNames, " "))
+		}
+	}
+}
+
+func TestImportDigikam(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/digikam/album/IMG_0001.jpg", "a"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll("/tmp/tmsu/digikam")
+
+	digikam, err := sql.Open("sqlite3", "/tmp/tmsu/digikam/digikam4.db")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	for _, statement := range []string{
+		"CREATE TABLE AlbumRoots (id INTEGER PRIMARY KEY, specificPath TEXT)",
+		"CREATE TABLE Albums (id INTEGER PRIMARY KEY, albumRoot INTEGER, relativePath TEXT)",
+		"CREATE TABLE Images (id INTEGER PRIMARY KEY, album INTEGER, name TEXT)",
+		"CREATE TABLE Tags (id INTEGER PRIMARY KEY, pid INTEGER, name TEXT)",
+		"CREATE TABLE ImageTags (imageid INTEGER, tagid INTEGER)",
+		"INSERT INTO AlbumRoots VALUES (1, '/tmp/tmsu/digikam')",
+		"INSERT INTO Albums VALUES (1, 1, '/album')",
+		"INSERT INTO Images VALUES (1, 1, 'IMG_0001.jpg')",
+		"INSERT INTO Tags VALUES (0, -1, '_Digikam_root_tag_'), (1, 0, 'Places'), (2, 1, 'Paris'), (3, 0, 'mountain'), (4, 0, '_Digikam_Internal_Tags_'), (5, 4, 'Color Label None')",
+		"INSERT INTO ImageTags VALUES (1, 2), (1, 3), (1, 5)"} {
+		if _, err := digikam.Exec(statement); err != nil {
+			test.Fatal(err)
+		}
+	}
+	digikam.Close()
+
+	// test
+
+	if err := ImportCommand.Exec(store, Options{Option{"--from-digikam", "", "", false, ""}}, []string{"/tmp/tmsu/digikam/digikam4.db"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	file, err := store.FileByPath("/tmp/tmsu/digikam/album/IMG_0001.jpg")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if file == nil {
+		test.Fatal("file was not tagged")
+	}
+
+	tagNames, err := tagNamesForFile(store, file.Id, true, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if strings.Join(tagNames, " ") != "Places=Paris mountain" {
+		test.Fatalf("unexpected tags: %v", tagNames)
+	}
+}
+
+func TestImportChecksums(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.UpdateSetting("extraFingerprintAlgorithms", "SHA256"); err != nil {
+		test.Fatal(err)
+	}
+
+	for _, path := range []string{"/tmp/tmsu/a", "/tmp/tmsu/b"} {
+		if err := createFile(path, path); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "old"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// the checksums are not those of the files' contents so as to show that
+	// they are taken from the manifest
+	sumA, sumB := strings.Repeat("a", 64), strings.Repeat("B", 64)
+	manifest := sumA + "  /tmp/tmsu/a\n" + sumB + " */tmp/tmsu/b\n" + sumA + "  /tmp/tmsu/missing\n"
+	if err := createFile("/tmp/tmsu/SHA256SUMS", manifest); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/SHA256SUMS")
+
+	// test
+
+	err = ImportCommand.Exec(store, Options{Option{"--checksums", "", "", false, ""}}, []string{"/tmp/tmsu/SHA256SUMS", "new"})
+	if err != errBlank {
+		test.Fatalf("expected the missing file to be reported but was: %v", err)
+	}
+
+	// validate
+
+	for path, expected := range map[string]string{"/tmp/tmsu/a": strings.ToLower(sumA), "/tmp/tmsu/b": strings.ToLower(sumB)} {
+		file, err := store.FileByPath(path)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if file == nil {
+			test.Fatalf("%v: file was not added", path)
+		}
+
+		if string(file.Fingerprint) != expected {
+			test.Fatalf("%v: expected fingerprint '%v' but was '%v'", path, expected, file.Fingerprint)
+		}
+
+		fingerprints, err := store.FileFingerprints(file.Id)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if string(fingerprints["SHA256"]) != expected {
+			test.Fatalf("%v: expected SHA256 fingerprint '%v' but was '%v'", path, expected, fingerprints["SHA256"])
+		}
+
+		tagNames, err := tagNamesForFile(store, file.Id, true, false)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if !containsTag(tagNames, "new") {
+			test.Fatalf("%v: expected tag 'new' but tags were '%v'", path, strings.Join(tagNames, " "))
+		}
+	}
+}
+
+func TestImportChecksumsForUrls(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := TagCommand.Exec(store, Options{}, []string{"s3://bucket/a.jpg", "photo"}); err != nil {
+		test.Fatal(err)
+	}
+
+	sumA, sumB := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	manifest := sumA + "  s3://bucket/a.jpg\n" + sumB + "  s3://bucket/b.jpg\n"
+	if err := createFile("/tmp/tmsu/SHA256SUMS", manifest); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/SHA256SUMS")
+
+	// test
+
+	if err := ImportCommand.Exec(store, Options{Option{"--checksums", "", "", false, ""}}, []string{"/tmp/tmsu/SHA256SUMS", "imported"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	for path, expected := range map[string]string{"s3://bucket/a.jpg": sumA, "s3://bucket/b.jpg": sumB} {
+		file, err := store.FileByPath(path)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if file == nil {
+			test.Fatalf("%v: file was not added", path)
+		}
+
+		if string(file.Fingerprint) != expected {
+			test.Fatalf("%v: expected fingerprint '%v' but was '%v'", path, expected, file.Fingerprint)
+		}
+
+		tagNames, err := tagNamesForFile(store, file.Id, true, false)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if !containsTag(tagNames, "imported") {
+			test.Fatalf("%v: expected tag 'imported' but tags were '%v'", path, strings.Join(tagNames, " "))
+		}
+	}
+}
@@ -0,0 +1,429 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"tmsu/common/fingerprint"
+	"tmsu/common/log"
+	_path "tmsu/common/path"
+	"tmsu/entities"
+	"tmsu/storage"
+	"tmsu/storage/database"
+)
+
+var InfoCommand = Command{
+	Name:     "info",
+	Synopsis: "Show database information",
+	Usages: []string{"tmsu info [OPTION]...",
+		"tmsu info FILE...",
+		"tmsu info --details [--json] FILE..."},
+	Description: `Shows information about the database: its location, the root path that files are stored relative to and the schema version.
+
+The schema is upgraded automatically when the database is opened by a newer version of TMSU. With --schema the schema migrations that have been applied are listed.
+
+With --usage the number of unused tags and values, untagged files and files that are missing from the file-system are shown along with the size of the database file and how much of it is free space. Unused tags and values are removed by 'gc', missing files by 'repair' and free space by 'vacuum'.
+
+Where FILEs are specified, instead shows the tagging history of each: when each of its tags was applied and by which subcommand, oldest first. A '-' is shown for tags applied before this was recorded.
+
+With --details, the details of each FILE are shown instead: its fingerprint, size and modification time as recorded in the database, its tags (marking those that are implied by other tags) and the other files with the same fingerprint. This is useful for understanding why a file does, or does not, match a query. With --json the details are written as a JSON document.`,
+	Examples: []string{"$ tmsu info",
+		"$ tmsu info --schema",
+		"$ tmsu info --usage",
+		"$ tmsu info song.mp3\nsong.mp3:\n  2015-06-01 09:12:44  tag     music\n  2015-06-03 18:40:02  tag     year=2015",
+		"$ tmsu info --details song.mp3",
+		"$ tmsu info --details --json song.mp3"},
+	Options: Options{{"--schema", "-s", "list the schema migrations applied", false, ""},
+		{"--usage", "-u", "show the unused tags and values, untagged and missing files and free space", false, ""},
+		{"--details", "-d", "show the details of each FILE rather than its tagging history", false, ""},
+		{"--json", "-j", "show the details of each FILE as JSON", false, ""}},
+	Exec: infoExec,
+}
+
+// The details of a file, as shown by --details.
+type fileDetails struct {
+	Path        string           `json:"path"`
+	Fingerprint string           `json:"fingerprint"`
+	Size        int64            `json:"size"`
+	ModTime     time.Time        `json:"modTime"`
+	IsDir       bool             `json:"isDir"`
+	Tags        []fileDetailsTag `json:"tags"`
+	Duplicates  []string         `json:"duplicates"`
+}
+
+// A tag applied to a file, explicitly, by implication or both.
+type fileDetailsTag struct {
+	Name     string `json:"name"`
+	Value    string `json:"value,omitempty"`
+	Explicit bool   `json:"explicit"`
+	Implied  bool   `json:"implied"`
+}
+
+func infoExec(store *storage.Storage, options Options, args []string) error {
+	asJson := options.HasOption("--json")
+	details := options.HasOption("--details") || asJson
+
+	if details && len(args) == 0 {
+		return fmt.Errorf("files must be specified")
+	}
+
+	if details {
+		return showFileDetails(store, args, asJson)
+	}
+
+	if len(args) > 0 {
+		return showTagHistories(store, args)
+	}
+
+	showSchema := options.HasOption("--schema")
+	showUsage := options.HasOption("--usage")
+
+	schemaVersion, err := store.SchemaVersion()
+	if err != nil {
+		return fmt.Errorf("could not retrieve schema version: %v", err)
+	}
+
+	fmt.Printf("Database:       %v\n", store.Db.Path)
+	fmt.Printf("Root path:      %v\n", store.RootPath)
+	fmt.Printf("Schema version: %v (latest %v)\n", schemaVersion, database.LatestSchemaVersion())
+
+	if showSchema {
+		migrations, err := store.Migrations()
+		if err != nil {
+			return fmt.Errorf("could not retrieve schema migrations: %v", err)
+		}
+
+		fmt.Println()
+		for _, migration := range migrations {
+			fmt.Printf("  %3v  %v  %v\n", migration.Version, migration.Applied.Local().Format("2006-01-02 15:04:05"), migration.Description)
+		}
+	}
+
+	if showUsage {
+		fmt.Println()
+		if err := showDatabaseUsage(store); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unexported
+
+func showTagHistories(store *storage.Storage, paths []string) error {
+	wereErrors := false
+	for index, path := range paths {
+		absPath, err := _path.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not get absolute path: %v", path, err)
+		}
+
+		file, err := store.FileByPath(absPath)
+		if err != nil {
+			return fmt.Errorf("%v: could not retrieve file: %v", path, err)
+		}
+		if file == nil {
+			log.Warnf("%v: file is not tagged", path)
+			wereErrors = true
+			continue
+		}
+
+		applications, err := store.FileTagApplications(file.Id)
+		if err != nil {
+			return fmt.Errorf("%v: could not retrieve tag history: %v", path, err)
+		}
+
+		if index > 0 {
+			fmt.Println()
+		}
+
+		fmt.Printf("%v:\n", path)
+		for _, application := range applications {
+			printTagApplication(application)
+		}
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+func showDatabaseUsage(store *storage.Storage) error {
+	unusedTagCount, err := store.UnusedTagCount()
+	if err != nil {
+		return fmt.Errorf("could not retrieve unused tag count: %v", err)
+	}
+
+	unusedValueCount, err := store.UnusedValueCount()
+	if err != nil {
+		return fmt.Errorf("could not retrieve unused value count: %v", err)
+	}
+
+	untaggedFiles, err := store.UntaggedFiles()
+	if err != nil {
+		return fmt.Errorf("could not retrieve untagged files: %v", err)
+	}
+
+	missingFileCount, err := missingFileCount(store)
+	if err != nil {
+		return err
+	}
+
+	pageUsage, err := store.PageUsage()
+	if err != nil {
+		return fmt.Errorf("could not retrieve database size: %v", err)
+	}
+
+	size := pageUsage.Pages * pageUsage.PageSize
+	freeSize := pageUsage.FreePages * pageUsage.PageSize
+
+	var freePercentage float32
+	if pageUsage.Pages > 0 {
+		freePercentage = float32(pageUsage.FreePages) / float32(pageUsage.Pages) * 100
+	}
+
+	fmt.Printf("Unused tags:    %v\n", unusedTagCount)
+	fmt.Printf("Unused values:  %v\n", unusedValueCount)
+	fmt.Printf("Untagged files: %v\n", len(untaggedFiles))
+	fmt.Printf("Missing files:  %v\n", missingFileCount)
+	fmt.Printf("Database size:  %v bytes (%v bytes free, %.1f%%)\n", size, freeSize, freePercentage)
+
+	return nil
+}
+
+// Counts the files in the database that no longer exist in the file-system.
+func missingFileCount(store *storage.Storage) (uint, error) {
+	policy, err := symlinkPolicy(store, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	files, err := store.Files()
+	if err != nil {
+		return 0, fmt.Errorf("could not retrieve files: %v", err)
+	}
+
+	var count uint
+	for _, file := range localFiles(files) {
+		if _, err := policy.Stat(file.Path()); err != nil {
+			switch {
+			case os.IsNotExist(err), strings.Contains(err.Error(), "not a directory"):
+				count++
+			case os.IsPermission(err):
+				log.Warnf("%v: permission denied", file.Path())
+			default:
+				return 0, fmt.Errorf("%v: could not stat: %v", file.Path(), err)
+			}
+		}
+	}
+
+	return count, nil
+}
+
+func showFileDetails(store *storage.Storage, paths []string, asJson bool) error {
+	wereErrors := false
+	report := make([]fileDetails, 0, len(paths))
+	for _, path := range paths {
+		absPath, err := _path.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not get absolute path: %v", path, err)
+		}
+
+		file, err := store.FileByPath(absPath)
+		if err != nil {
+			return fmt.Errorf("%v: could not retrieve file: %v", path, err)
+		}
+		if file == nil {
+			log.Warnf("%v: file is not tagged", path)
+			wereErrors = true
+			continue
+		}
+
+		details, err := retrieveFileDetails(store, file)
+		if err != nil {
+			return fmt.Errorf("%v: %v", path, err)
+		}
+
+		if !asJson {
+			if len(report) > 0 {
+				fmt.Println()
+			}
+
+			printFileDetails(path, details)
+		}
+
+		report = append(report, *details)
+	}
+
+	if asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		if err := encoder.Encode(report); err != nil {
+			return fmt.Errorf("could not write details: %v", err)
+		}
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+func retrieveFileDetails(store *storage.Storage, file *entities.File) (*fileDetails, error) {
+	details := fileDetails{Path: file.Path(),
+		Fingerprint: string(file.Fingerprint),
+		Size:        file.Size,
+		ModTime:     file.ModTime,
+		IsDir:       file.IsDir,
+		Tags:        []fileDetailsTag{},
+		Duplicates:  []string{}}
+
+	fileTags, err := store.FileTagsByFileId(file.Id, false)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve file-tags: %v", err)
+	}
+
+	for _, fileTag := range fileTags {
+		tag, err := store.Tag(fileTag.TagId)
+		if err != nil {
+			return nil, fmt.Errorf("could not look up tag: %v", err)
+		}
+		if tag == nil {
+			return nil, fmt.Errorf("tag '%v' does not exist", fileTag.TagId)
+		}
+
+		detailsTag := fileDetailsTag{Name: tag.Name, Explicit: fileTag.Explicit, Implied: fileTag.Implicit}
+
+		if fileTag.ValueId != 0 {
+			value, err := store.Value(fileTag.ValueId)
+			if err != nil {
+				return nil, fmt.Errorf("could not look up value: %v", err)
+			}
+			if value == nil {
+				return nil, fmt.Errorf("value '%v' does not exist", fileTag.ValueId)
+			}
+
+			detailsTag.Value = value.Name
+		}
+
+		details.Tags = append(details.Tags, detailsTag)
+	}
+
+	sort.Slice(details.Tags, func(i, j int) bool {
+		if details.Tags[i].Name != details.Tags[j].Name {
+			return details.Tags[i].Name < details.Tags[j].Name
+		}
+
+		return details.Tags[i].Value < details.Tags[j].Value
+	})
+
+	if !file.IsDir && file.Fingerprint != fingerprint.EMPTY {
+		duplicates, err := store.FilesByFingerprint(file.Fingerprint)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve duplicates: %v", err)
+		}
+
+		for _, duplicate := range duplicates {
+			if duplicate.Id != file.Id {
+				details.Duplicates = append(details.Duplicates, duplicate.Path())
+			}
+		}
+	}
+
+	return &details, nil
+}
+
+func printFileDetails(path string, details *fileDetails) {
+	fingerprint := details.Fingerprint
+	if fingerprint == "" {
+		fingerprint = "-"
+	}
+
+	fmt.Printf("%v:\n", path)
+	fmt.Printf("  Fingerprint: %v\n", fingerprint)
+	fmt.Printf("  Size:        %v\n", details.Size)
+	fmt.Printf("  Modified:    %v\n", details.ModTime.Local().Format("2006-01-02 15:04:05"))
+
+	if details.IsDir {
+		fmt.Println("  Type:        directory")
+	} else {
+		fmt.Println("  Type:        file")
+	}
+
+	fmt.Printf("  Tags:")
+	if len(details.Tags) == 0 {
+		fmt.Printf("        -")
+	}
+	fmt.Println()
+	for _, tag := range details.Tags {
+		text := tag.Name
+		if tag.Value != "" {
+			text += "=" + tag.Value
+		}
+
+		switch {
+		case tag.Explicit && tag.Implied:
+			text += " (explicit and implied)"
+		case tag.Implied:
+			text += " (implied)"
+		}
+
+		fmt.Printf("    %v\n", text)
+	}
+
+	fmt.Printf("  Duplicates:")
+	if len(details.Duplicates) == 0 {
+		fmt.Printf("  -")
+	}
+	fmt.Println()
+	for _, duplicate := range details.Duplicates {
+		fmt.Printf("    %v\n", duplicate)
+	}
+}
+
+func printTagApplication(application *entities.FileTagApplication) {
+	applied := "-"
+	if !application.Applied.IsZero() {
+		applied = application.Applied.Local().Format("2006-01-02 15:04:05")
+	}
+
+	command := application.Command
+	if command == "" {
+		command = "-"
+	}
+
+	tag := application.TagName
+	if application.ValueName != "" {
+		tag += "=" + application.ValueName
+	}
+	if application.Owner != "" {
+		tag += " (" + application.Owner + ")"
+	}
+
+	fmt.Printf("  %-19v  %-7v %v\n", applied, command, tag)
+}
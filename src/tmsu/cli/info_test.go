@@ -0,0 +1,278 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"tmsu/common/fingerprint"
+	"tmsu/storage"
+	"tmsu/storage/database"
+)
+
+func TestInfoReportsUpgradedSchema(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+	defer os.Remove(databasePath + ".schema-1.bak")
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.Db.Exec("DELETE FROM migration WHERE version > 1"); err != nil {
+		test.Fatal(err)
+	}
+
+	store.Close()
+
+	// test
+
+	store, err = storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := InfoCommand.Exec(store, Options{}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	if _, err := os.Stat(databasePath + ".schema-1.bak"); err != nil {
+		test.Fatalf("Database was not backed up before upgrade: %v", err)
+	}
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	expected := fmt.Sprintf("Schema version: %v (latest %v)\n", database.LatestSchemaVersion(), database.LatestSchemaVersion())
+	if !strings.HasSuffix(string(bytes), expected) {
+		test.Fatalf("Unexpected output:\n%v", string(bytes))
+	}
+}
+
+func TestInfoTagHistory(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	store.Command = "tag"
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "banana", "apple"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// simulate a tag applied before the times were recorded
+	if _, err := store.Db.Exec("UPDATE file_tag SET applied = NULL, command = '' WHERE tag_id = (SELECT id FROM tag WHERE name = 'banana')"); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := InfoCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	lines := strings.Split(string(bytes), "\n")
+	if len(lines) != 4 || lines[0] != "/tmp/tmsu/a:" || lines[1] != "  -                    -       banana" || len(lines[2]) != 36 || !strings.HasSuffix(lines[2], "  tag     apple") {
+		test.Fatalf("Unexpected output:\n%v", string(bytes))
+	}
+}
+
+func TestInfoDetails(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	if err := createFile("/tmp/tmsu/b", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/b")
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "mp3", "year=2015"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/b", "mp3", "music"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := ImplyCommand.Exec(store, Options{}, []string{"mp3", "music"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := InfoCommand.Exec(store, Options{Option{"--json", "-j", "", false, ""}}, []string{"/tmp/tmsu/a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	var report []fileDetails
+	if err := json.NewDecoder(outFile).Decode(&report); err != nil {
+		test.Fatal(err)
+	}
+
+	if len(report) != 1 {
+		test.Fatalf("Expected one file but got %v.", len(report))
+	}
+
+	details := report[0]
+	if details.Path != "/tmp/tmsu/a" || details.Size != 5 || details.IsDir || details.Fingerprint == "" {
+		test.Fatalf("Unexpected details: %+v", details)
+	}
+
+	expectedTags := []fileDetailsTag{{"mp3", "", true, false}, {"music", "", false, true}, {"year", "2015", true, false}}
+	if !reflect.DeepEqual(details.Tags, expectedTags) {
+		test.Fatalf("Expected tags %+v but got %+v.", expectedTags, details.Tags)
+	}
+
+	if len(details.Duplicates) != 1 || details.Duplicates[0] != "/tmp/tmsu/b" {
+		test.Fatalf("Expected duplicate '/tmp/tmsu/b' but got %v.", details.Duplicates)
+	}
+}
+
+func TestInfoUsage(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	if err := createFile("/tmp/tmsu/b", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/b")
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "apple=red"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/b", "banana"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddTag("cherry"); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddValue("green"); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFile("/tmp/tmsu/c", fingerprint.Fingerprint(""), time.Now(), 0, false); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := os.Remove("/tmp/tmsu/b"); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := InfoCommand.Exec(store, Options{Option{"--usage", "-u", "", false, ""}}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	output := string(bytes)
+	for _, expected := range []string{"\nUnused tags:    1\n", "\nUnused values:  1\n", "\nUntagged files: 1\n", "\nMissing files:  2\n", "\nDatabase size:  "} {
+		if !strings.Contains(output, expected) {
+			test.Fatalf("Expected '%v' in output:\n%v", strings.TrimSpace(expected), output)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"tmsu/common/log"
+	"tmsu/entities"
+	"tmsu/storage"
+)
+
+const linkManifestName = ".tmsu-link"
+
+var LinkCommand = Command{
+	Name:     "link",
+	Synopsis: "Materialise a query as a directory of links",
+	Usages: []string{"tmsu link [OPTION]... QUERY DIRECTORY",
+		"tmsu link --refresh DIRECTORY..."},
+	Description: `Creates DIRECTORY containing a link to each file matching QUERY. Unlike the virtual filesystem, the directory is a real directory on disk, so can be shared over Samba or NFS or used where FUSE is unavailable.
+
+Where two files share a name, the file identifier is added to the name of the second.
+
+The query is recorded within the directory (in '` + linkManifestName + `') so that the links can later be brought up to date with --refresh, which adds links for newly matching files and removes the links it created for files that no longer match. Other files within the directory are left alone.
+
+See the 'files' subcommand for the query syntax.`,
+	Examples: []string{`$ tmsu link "beach and 2014" ~/views/beach-2014`,
+		"$ tmsu link --hard music ~/views/music",
+		"$ tmsu link --refresh ~/views/beach-2014"},
+	Options: Options{{"--hard", "-H", "create hard links rather than symbolic links", false, ""},
+		{"--refresh", "-r", "bring existing link directories up to date", false, ""}},
+	Exec: linkExec,
+}
+
+func linkExec(store *storage.Storage, options Options, args []string) error {
+	if options.HasOption("--refresh") {
+		if len(args) < 1 {
+			return fmt.Errorf("link directory must be specified")
+		}
+
+		wereErrors := false
+		for _, dirPath := range args {
+			if err := refreshLinkDirectory(store, dirPath); err != nil {
+				log.Warnf("%v: %v", dirPath, err)
+				wereErrors = true
+			}
+		}
+
+		if wereErrors {
+			return errBlank
+		}
+
+		return nil
+	}
+
+	if len(args) < 2 {
+		return fmt.Errorf("query and directory must be specified")
+	}
+	if len(args) > 2 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	queryText, dirPath := args[0], args[1]
+
+	if entries, err := readDirNames(dirPath); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%v: directory is not empty", dirPath)
+	}
+
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return fmt.Errorf("%v: could not create directory: %v", dirPath, err)
+	}
+
+	manifest := linkManifest{queryText, options.HasOption("--hard"), []string{}}
+	return syncLinkDirectory(store, dirPath, manifest)
+}
+
+// unexported
+
+type linkManifest struct {
+	queryText string
+	hard      bool
+	names     []string
+}
+
+func refreshLinkDirectory(store *storage.Storage, dirPath string) error {
+	manifest, err := readLinkManifest(dirPath)
+	if err != nil {
+		return err
+	}
+
+	return syncLinkDirectory(store, dirPath, *manifest)
+}
+
+func syncLinkDirectory(store *storage.Storage, dirPath string, manifest linkManifest) error {
+	expression, err := store.ParseQuery(manifest.queryText)
+	if err != nil {
+		return querySyntaxError{err}
+	}
+
+	files, err := store.QueryFiles(expression, "", false)
+	if err != nil {
+		return fmt.Errorf("could not query files: %v", err)
+	}
+
+	wanted := linkNames(files)
+
+	wereErrors := false
+	names := make([]string, 0, len(wanted))
+
+	// remove the links for files that no longer match
+	for _, name := range manifest.names {
+		linkPath := filepath.Join(dirPath, name)
+
+		if target, ok := wanted[name]; ok && linkIsCurrent(linkPath, target, manifest.hard) {
+			names = append(names, name)
+			delete(wanted, name)
+			continue
+		}
+
+		log.Infof(2, "%v: removing link", linkPath)
+
+		if err := os.Remove(linkPath); err != nil && !os.IsNotExist(err) {
+			log.Warnf("%v: could not remove link: %v", linkPath, err)
+			names = append(names, name)
+			wereErrors = true
+		}
+	}
+
+	// add the links for newly matching files
+	for name, target := range wanted {
+		linkPath := filepath.Join(dirPath, name)
+
+		log.Infof(2, "%v: linking to '%v'", linkPath, target)
+
+		var err error
+		if manifest.hard {
+			err = os.Link(target, linkPath)
+		} else {
+			err = os.Symlink(target, linkPath)
+		}
+
+		if err != nil {
+			log.Warnf("%v: could not create link: %v", linkPath, err)
+			wereErrors = true
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	manifest.names = names
+	if err := writeLinkManifest(dirPath, manifest); err != nil {
+		return err
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+// Determines the link name for each file: the file's name or, where this is
+// already taken, the name with the file identifier added.
+func linkNames(files entities.Files) map[string]string {
+	links := make(map[string]string, len(files))
+
+	for _, file := range files {
+		name := file.Name
+		if _, taken := links[name]; taken || name == linkManifestName {
+			extension := filepath.Ext(name)
+			name = name[:len(name)-len(extension)] + "." + strconv.FormatUint(uint64(file.Id), 10) + extension
+		}
+
+		links[name] = file.Path()
+	}
+
+	return links
+}
+
+func linkIsCurrent(linkPath, target string, hard bool) bool {
+	if hard {
+		linkInfo, err := os.Stat(linkPath)
+		if err != nil {
+			return false
+		}
+
+		targetInfo, err := os.Stat(target)
+		if err != nil {
+			return false
+		}
+
+		return os.SameFile(linkInfo, targetInfo)
+	}
+
+	linkTarget, err := os.Readlink(linkPath)
+	return err == nil && linkTarget == target
+}
+
+func readLinkManifest(dirPath string) (*linkManifest, error) {
+	file, err := os.Open(filepath.Join(dirPath, linkManifestName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("not a link directory")
+		}
+
+		return nil, fmt.Errorf("could not open link manifest: %v", err)
+	}
+	defer file.Close()
+
+	lines := make([]string, 0, 100)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read link manifest: %v", err)
+	}
+
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("link manifest is corrupt")
+	}
+
+	return &linkManifest{lines[0], lines[1] == "hard", lines[2:]}, nil
+}
+
+func writeLinkManifest(dirPath string, manifest linkManifest) error {
+	kind := "symbolic"
+	if manifest.hard {
+		kind = "hard"
+	}
+
+	content := manifest.queryText + "\n" + kind + "\n"
+	if len(manifest.names) > 0 {
+		content += strings.Join(manifest.names, "\n") + "\n"
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dirPath, linkManifestName), []byte(content), 0644); err != nil {
+		return fmt.Errorf("could not write link manifest: %v", err)
+	}
+
+	return nil
+}
+
+func readDirNames(dirPath string) ([]string, error) {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	return dir.Readdirnames(0)
+}
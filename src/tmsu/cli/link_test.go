@@ -0,0 +1,99 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestLinkAndRefresh(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, path := range []string{"/tmp/tmsu/a", "/tmp/tmsu/b"} {
+		if err := createFile(path, path); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+	}
+
+	tags := Options{Option{"--tags", "-t", "", true, "beach"}}
+	if err := TagCommand.Exec(store, tags, []string{"/tmp/tmsu/a", "/tmp/tmsu/b"}); err != nil {
+		test.Fatal(err)
+	}
+
+	linkDir := "/tmp/tmsu/view"
+	defer os.RemoveAll(linkDir)
+
+	// test
+
+	if err := LinkCommand.Exec(store, Options{}, []string{"beach", linkDir}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	expectLinks(test, linkDir, "a", "b")
+
+	// test
+
+	if err := UntagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/b", "beach"}); err != nil {
+		test.Fatal(err)
+	}
+
+	refresh := Options{Option{"--refresh", "-r", "", false, ""}}
+	if err := LinkCommand.Exec(store, refresh, []string{linkDir}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	expectLinks(test, linkDir, "a")
+}
+
+func expectLinks(test *testing.T, dirPath string, names ...string) {
+	entries, err := readDirNames(dirPath)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if len(entries) != len(names)+1 {
+		test.Fatalf("Expected %v links but found %v entries.", len(names), len(entries))
+	}
+
+	for _, name := range names {
+		target, err := os.Readlink(filepath.Join(dirPath, name))
+		if err != nil {
+			test.Fatal(err)
+		}
+		if target != filepath.Join("/tmp/tmsu", name) {
+			test.Fatalf("Link '%v' has unexpected target '%v'.", name, target)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"tmsu/entities"
+	"tmsu/storage"
+)
+
+var LogCommand = Command{
+	Name:     "log",
+	Synopsis: "Show the tagging history of files or tags",
+	Usages: []string{"tmsu log FILE...",
+		"tmsu log --tag TAG"},
+	Description: `Lists, oldest first, the tags applied to and removed from each FILE, following the file back through any moves.
+
+With --tag lists instead when TAG was applied to or removed from each file, following the tag back through any renames.
+
+The history is taken from the change events (see the 'events' subcommand) so does not extend further back than the oldest event retained.`,
+	Examples: []string{"$ tmsu log song.mp3\n2015-06-01T09:12:44 tag /home/bob/song.mp3 music\n2015-06-03T18:40:02 untag /home/bob/song.mp3 rock",
+		"$ tmsu log --tag music"},
+	Options: Options{{"--tag", "-t", "show the history of TAG", true, ""}},
+	Exec:    logExec,
+}
+
+func logExec(store *storage.Storage, options Options, args []string) error {
+	if options.HasOption("--tag") {
+		if len(args) > 0 {
+			return usageError{fmt.Errorf("files cannot be specified with --tag")}
+		}
+
+		tagName := options.Get("--tag").Argument
+
+		events, err := store.TagEvents(tagName)
+		if err != nil {
+			return fmt.Errorf("could not retrieve events for tag '%v': %v", tagName, err)
+		}
+
+		printLog(events)
+
+		return nil
+	}
+
+	if len(args) == 0 {
+		return usageError{fmt.Errorf("files or a tag must be specified")}
+	}
+
+	for _, path := range args {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not get absolute path: %v", path, err)
+		}
+
+		events, err := store.FileEvents(absPath)
+		if err != nil {
+			return fmt.Errorf("%v: could not retrieve events: %v", path, err)
+		}
+
+		printLog(events)
+	}
+
+	return nil
+}
+
+// unexported
+
+func printLog(events entities.Events) {
+	for _, event := range events {
+		fmt.Printf("%v %v %v\n", event.Time.Local().Format("2006-01-02T15:04:05"), event.Type, describeEvent(event))
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestLogFileFollowsMoves(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/a", "a"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+	defer os.Remove("/tmp/tmsu/b")
+
+	if err := createFile("/tmp/tmsu/c", "c"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/c")
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "apple", "banana"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/c", "apple"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := UntagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "banana"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := MoveFileCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "/tmp/tmsu/b"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := RenameCommand.Exec(store, Options{}, []string{"apple", "pear"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := LogCommand.Exec(store, Options{}, []string{"/tmp/tmsu/b"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := LogCommand.Exec(store, Options{Option{"--tag", "-t", "", true, "pear"}}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// drop the times
+	lines := strings.Split(strings.TrimSpace(string(bytes)), "\n")
+	for index, line := range lines {
+		lines[index] = strings.SplitN(line, " ", 2)[1]
+	}
+
+	compareOutput(test, `tag /tmp/tmsu/a apple
+tag /tmp/tmsu/a banana
+untag /tmp/tmsu/a banana
+file-move /tmp/tmsu/a -> /tmp/tmsu/b
+tag /tmp/tmsu/a apple
+tag /tmp/tmsu/c apple
+tag-rename apple -> pear`, strings.Join(lines, "\n"))
+}
@@ -20,18 +20,27 @@ package cli
 import (
 	"fmt"
 	"tmsu/common/log"
+	"tmsu/entities"
 	"tmsu/storage"
 )
 
 var MergeCommand = Command{
-	Name:        "merge",
-	Synopsis:    "Merge tags",
-	Usages:      []string{"tmsu merge TAG... DEST"},
-	Description: `Merges TAGs into tag DEST resulting in a single tag of name DEST.`,
+	Name:     "merge",
+	Synopsis: "Merge tags",
+	Usages:   []string{"tmsu merge [OPTION]... TAG... DEST"},
+	Description: `Merges TAGs into tag DEST resulting in a single tag of name DEST.
+
+When --as-value is specified, files tagged with one of the TAGs without a value are instead tagged DEST=VALUE. Files tagged with a value keep that value.
+
+Each TAG is merged in full or, should an error occur, not at all.
+
+Tags named by the 'lockedTags' setting cannot be merged unless --force is specified.`,
 	Examples: []string{`$ tmsu merge cehese cheese`,
-		`$ tmsu merge outdoors outdoor outside`},
-	Options: Options{},
-	Exec:    mergeExec,
+		`$ tmsu merge outdoors outdoor outside`,
+		`$ tmsu merge --as-value high hi-res resolution`},
+	Options: Options{{"--force", "-f", "merge locked tags", false, ""},
+		{"--as-value", "-a", "tag files that had no value with DEST=VALUE", true, ""}},
+	Exec: mergeExec,
 }
 
 func mergeExec(store *storage.Storage, options Options, args []string) error {
@@ -39,13 +48,33 @@ func mergeExec(store *storage.Storage, options Options, args []string) error {
 		return fmt.Errorf("too few arguments")
 	}
 
+	store.Force = options.HasOption("--force")
+
 	destTagName := args[len(args)-1]
 	destTag, err := store.TagByName(destTagName)
 	if err != nil {
 		return fmt.Errorf("could not retrieve tag '%v': %v", destTagName, err)
 	}
 	if destTag == nil {
-		return fmt.Errorf("no such tag '%v'", destTagName)
+		return noSuchTagError{destTagName}
+	}
+
+	var valueId entities.ValueId
+	if options.HasOption("--as-value") {
+		valueName := options.Get("--as-value").Argument
+
+		value, err := getValue(store, valueName)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			value, err = createValue(store, valueName)
+			if err != nil {
+				return fmt.Errorf("could not create value '%v': %v", valueName, err)
+			}
+		}
+
+		valueId = value.Id
 	}
 
 	wereErrors := false
@@ -66,27 +95,16 @@ func mergeExec(store *storage.Storage, options Options, args []string) error {
 			continue
 		}
 
-		log.Infof(2, "finding files tagged '%v'.", sourceTagName)
-
-		fileTags, err := store.FileTagsByTagId(sourceTag.Id, true)
-		if err != nil {
-			return fmt.Errorf("could not retrieve files for tag '%v': %v", sourceTagName, err)
-		}
-
-		log.Infof(2, "applying tag '%v' to these files.", destTagName)
+		log.Infof(2, "merging tag '%v' into '%v'.", sourceTagName, destTagName)
 
-		for _, fileTag := range fileTags {
-			_, err = store.AddFileTag(fileTag.FileId, destTag.Id, fileTag.ValueId)
-			if err != nil {
-				return fmt.Errorf("could not apply tag '%v' to file #%v: %v", destTagName, fileTag.FileId, err)
-			}
+		err = store.MergeTag(sourceTag.Id, destTag.Id, valueId)
+		if _, ok := err.(storage.TagLockedError); ok {
+			log.Warnf("tag '%v' is locked: use --force to merge it.", sourceTagName)
+			wereErrors = true
+			continue
 		}
-
-		log.Infof(2, "deleting tag '%v'.", sourceTagName)
-
-		err = store.DeleteTag(sourceTag.Id)
 		if err != nil {
-			return fmt.Errorf("could not delete tag '%v': %v", sourceTagName, err)
+			return fmt.Errorf("could not merge tag '%v' into '%v': %v", sourceTagName, destTagName, err)
 		}
 	}
 
@@ -22,6 +22,7 @@ import (
 	"testing"
 	"time"
 	"tmsu/common/fingerprint"
+	"tmsu/entities"
 	"tmsu/storage"
 )
 
@@ -305,3 +306,90 @@ func TestMergeSourceAndDestinationTheSame(test *testing.T) {
 		test.Fatal("Expected source and destination the same tag to be identified.")
 	}
 }
+
+func TestMergeAsValue(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+	defer store.Rollback()
+
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	fileB, err := store.AddFile("/tmp/b", fingerprint.Fingerprint("def"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagHiRes, err := store.AddTag("hi-res")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagResolution, err := store.AddTag("resolution")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	valueExtra, err := store.AddValue("extra")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileA.Id, tagHiRes.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileB.Id, tagHiRes.Id, valueExtra.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	options := Options{Option{"--as-value", "-a", "", true, "high"}}
+	if err := MergeCommand.Exec(store, options, []string{"hi-res", "resolution"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	tagHiRes, err = store.TagByName("hi-res")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if tagHiRes != nil {
+		test.Fatal("Tag 'hi-res' still exists.")
+	}
+
+	valueHigh, err := store.ValueByName("high")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if valueHigh == nil {
+		test.Fatal("Value 'high' was not created.")
+	}
+
+	expected := map[entities.FileId]entities.ValueId{fileA.Id: valueHigh.Id, fileB.Id: valueExtra.Id}
+	for fileId, valueId := range expected {
+		fileTags, err := store.FileTagsByFileId(fileId, true)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if len(fileTags) != 1 || fileTags[0].TagId != tagResolution.Id || fileTags[0].ValueId != valueId {
+			test.Fatalf("File #%v has unexpected tags: %v.", fileId, fileTags)
+		}
+	}
+}
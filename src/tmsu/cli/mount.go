@@ -1,3 +1,5 @@
+// +build !windows
+
 /*
 Copyright 2011-2015 Paul Ruane.
 
@@ -15,8 +17,6 @@ You should have received a copy of the GNU General Public License
 along with this program.  If not, see <http://www.gnu.org/licenses/>.
 */
 
-// +build !windows
-
 package cli
 
 import (
@@ -24,6 +24,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 	"tmsu/common/log"
@@ -35,7 +36,8 @@ var MountCommand = Command{
 	Name:     "mount",
 	Synopsis: "Mount the virtual filesystem",
 	Usages: []string{"tmsu mount",
-		"tmsu mount [OPTION]... [FILE] MOUNTPOINT"},
+		"tmsu mount [OPTION]... [FILE] MOUNTPOINT",
+		"tmsu mount [OPTION]... FILE... MOUNTPOINT"},
 	Description: `Without arguments, lists the currently mounted file-systems, otherwise mounts a virtual file-system at the path MOUNTPOINT.
 
 Where FILE is specified, the database at FILE is mounted.
@@ -44,11 +46,59 @@ If FILE is not specified but the TMSU_DB environment variable is defined then th
 
 Where neither FILE is specified nor TMSU_DB defined then the default database is mounted.
 
-To allow other users access to the mounted filesystem, pass the 'allow_other' FUSE option, e.g. 'tmsu mount --option=allow_other mp'. (FUSE only allows the root user to use this option unless 'user_allow_other' is present in '/etc/fuse.conf'.)`,
+Where several FILEs are specified, each database is presented beneath a directory of its own named after it, e.g. 'MOUNTPOINT/photos/tags'. A database is named after its file or, for a '.tmsu/db' database, after the directory containing '.tmsu'.
+
+To allow other users access to the mounted filesystem, pass the 'allow_other' FUSE option, e.g. 'tmsu mount --option=allow_other mp'. (FUSE only allows the root user to use this option unless 'user_allow_other' is present in '/etc/fuse.conf'.)
+
+The naming of the symbolic links within the virtual filesystem can be chosen using the 'names' option:
+
+  names=id      the file identifier is added to the name (default)
+  names=hash    a prefix of the file's fingerprint is added to the name
+  names=mirror  the name is the path of the file with the directory separators escaped
+
+Where the chosen strategy would give two files the same name, the file identifier is used instead.
+
+The following options are also handled by TMSU itself rather than being passed to FUSE:
+
+  ro            mount the virtual filesystem read-only
+  uid=UID       report UID as the owner of all entries
+  gid=GID       report GID as the group of all entries
+  stat=SOURCE   report the size, modification time, permissions and owner of
+                each file's 'target' (default), or only the size and
+                modification time recorded in the 'database', which avoids
+                touching the files when large directories are listed
+  buckets=N     split the tag directories with more than N entries into
+                directories by initial, e.g. 'tags/(a)/apple', '(#)' holding
+                the entries not starting with a letter or digit
+  minfiles=N    hide the tags applied to fewer than N of the files of the
+                directory being listed
+  dialect=query allow the directories within 'tags' to be named with query
+                terms, e.g. 'tags/year=2014' or 'tags/photo/not private', with
+                any slashes escaped as '%2F' (default 'plain')
+
+All other options, such as 'allow_other' and 'default_permissions', are passed to FUSE.
+
+The links to files carry a 'user.tmsu.tags' extended attribute listing their tags, e.g. 'photo year=2014'. Setting the attribute replaces the file's tags (creating tags and values according to the 'autoCreateTags' and 'autoCreateValues' settings) and removing it untags the file. Linux does not permit 'user' attributes on symbolic links, so the attribute is available only on those systems that do, such as macOS and FreeBSD.
+
+Where --options is not specified, the 'mountOptions' setting of the configuration file, if any, is used.
+
+To mount from '/etc/fstab' install the 'mount.tmsu' helper from the 'misc/bin' directory and add an entry of the form:
+
+  /path/to/db  /path/to/mountpoint  tmsu  ro,allow_other,noauto  0  0
+
+With --persistent, the virtual filesystem is instead mounted by a systemd user unit that is enabled so that it is mounted again at each login. The unit is named after the mount point, e.g. 'tmsu-home-bob-mp.service', and can be removed with 'systemctl --user disable --now'. Where systemd is unavailable the corresponding '/etc/fstab' line is printed instead.`,
 	Examples: []string{"$ tmsu mount mp",
 		"$ tmsu mount /tmp/db mp",
-		"$ tmsu mount --options=allow_other mp"},
-	Options: Options{Option{"--options", "-o", "mount options (passed to fusermount)", true, ""}},
+		"$ tmsu mount ~/photos/.tmsu/db ~/music/.tmsu/db mp",
+		"$ tmsu mount --options=allow_other mp",
+		"$ tmsu mount --options=names=hash mp",
+		"$ tmsu mount --options=ro,uid=1000,gid=1000 mp",
+		"$ tmsu mount --options=stat=database mp",
+		"$ tmsu mount --options=buckets=500,minfiles=10 mp",
+		"$ tmsu mount --options=dialect=query mp",
+		"$ tmsu mount --persistent ~/mp"},
+	Options: Options{Option{"--options", "-o", "mount options (passed to fusermount)", true, ""},
+		Option{"--persistent", "-p", "mount automatically at login", false, ""}},
 	Exec:    mountExec,
 }
 
@@ -56,12 +106,20 @@ func mountExec(store *storage.Storage, options Options, args []string) error {
 	var mountOptions string
 	if options.HasOption("--options") {
 		mountOptions = options.Get("--options").Argument
+	} else {
+		mountOptions = configuration["mountOptions"]
 	}
 
+	persistent := options.HasOption("--persistent")
+
 	argCount := len(args)
 
 	switch argCount {
 	case 0:
+		if persistent {
+			return fmt.Errorf("mount point must be specified")
+		}
+
 		err := listMounts()
 		if err != nil {
 			return err
@@ -69,25 +127,35 @@ func mountExec(store *storage.Storage, options Options, args []string) error {
 	case 1:
 		mountPath := args[0]
 
-		err := mountExplicit(store.Db.Path, mountPath, mountOptions)
+		err := mountDatabases([]string{store.Db.Path}, mountPath, mountOptions, persistent)
 		if err != nil {
 			return err
 		}
-	case 2:
-		databasePath := args[0]
-		mountPath := args[1]
+	default:
+		databasePaths := args[:argCount-1]
+		mountPath := args[argCount-1]
 
-		err := mountExplicit(databasePath, mountPath, mountOptions)
+		err := mountDatabases(databasePaths, mountPath, mountOptions, persistent)
 		if err != nil {
 			return err
 		}
-	default:
-		return fmt.Errorf("too many arguments")
 	}
 
 	return nil
 }
 
+func mountDatabases(databasePaths []string, mountPath, mountOptions string, persistent bool) error {
+	if persistent {
+		if len(databasePaths) > 1 {
+			return fmt.Errorf("only a single database may be mounted persistently")
+		}
+
+		return mountPersistent(databasePaths[0], mountPath, mountOptions)
+	}
+
+	return mountExplicit(databasePaths, mountPath, mountOptions)
+}
+
 func listMounts() error {
 	log.Info(2, "retrieving mount table.")
 
@@ -107,7 +175,7 @@ func listMounts() error {
 	return nil
 }
 
-func mountExplicit(databasePath string, mountPath string, mountOptions string) error {
+func mountExplicit(databasePaths []string, mountPath string, mountOptions string) error {
 	if alreadyMounted(mountPath) {
 		return fmt.Errorf("%v: mount path already in use", mountPath)
 	}
@@ -123,17 +191,27 @@ func mountExplicit(databasePath string, mountPath string, mountOptions string) e
 		return fmt.Errorf("%v: mount point is not a directory", mountPath)
 	}
 
-	stat, err = os.Stat(databasePath)
-	if err != nil {
-		return fmt.Errorf("%v: could not stat: %v", databasePath, err)
-	}
-	if stat == nil {
-		return fmt.Errorf("%v: database does not exist")
+	absDatabasePaths := make([]string, len(databasePaths))
+	for index, databasePath := range databasePaths {
+		stat, err = os.Stat(databasePath)
+		if err != nil {
+			return fmt.Errorf("%v: could not stat: %v", databasePath, err)
+		}
+		if stat == nil {
+			return fmt.Errorf("%v: database does not exist")
+		}
+
+		absDatabasePaths[index], err = filepath.Abs(databasePath)
+		if err != nil {
+			return fmt.Errorf("%v: could not get absolute path: %v", databasePath, err)
+		}
 	}
 
-	log.Infof(2, "spawning daemon to mount VFS for database '%v' at '%v'", databasePath, mountPath)
+	log.Infof(2, "spawning daemon to mount VFS for databases '%v' at '%v'", strings.Join(absDatabasePaths, "', '"), mountPath)
 
-	args := []string{"vfs", "--database=" + databasePath, mountPath, "--options=" + mountOptions}
+	// the additional databases follow the mount point
+	args := []string{"vfs", "--database=" + absDatabasePaths[0], mountPath, "--options=" + mountOptions}
+	args = append(args, absDatabasePaths[1:]...)
 	daemon := exec.Command(os.Args[0], args...)
 
 	errorPipe, err := daemon.StderrPipe()
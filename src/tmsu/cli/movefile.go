@@ -0,0 +1,141 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"tmsu/common/log"
+	"tmsu/storage"
+)
+
+var MoveFileCommand = Command{
+	Name:     "move-file",
+	Synopsis: "Move files, updating the database",
+	Usages: []string{"tmsu move-file SOURCE DEST",
+		"tmsu move-file SOURCE... DIRECTORY"},
+	Description: `Moves (renames) SOURCE to DEST, or moves each SOURCE into DIRECTORY, and updates the database so that the files' tags follow them. When a directory is moved the database entries for its contents are updated too.
+
+Existing files are not overwritten.
+
+(To rename a tag use the 'rename' subcommand, or its alias 'mv'.)`,
+	Examples: []string{"$ tmsu move-file mountain.jpg alps.jpg",
+		"$ tmsu move-file mountain.jpg river.jpg ~/photos"},
+	Exec: moveFileExec,
+}
+
+func moveFileExec(store *storage.Storage, options Options, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("source and destination must be specified")
+	}
+
+	sources, dest, destIsDir, err := sourcesAndDestination(args)
+	if err != nil {
+		return err
+	}
+
+	wereErrors := false
+	for _, source := range sources {
+		target := destinationPath(source, dest, destIsDir)
+
+		if err := movePath(store, source, target); err != nil {
+			log.Warnf("%v: could not move to '%v': %v", source, target, err)
+			wereErrors = true
+		}
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+// unexported
+
+func sourcesAndDestination(args []string) ([]string, string, bool, error) {
+	sources := args[:len(args)-1]
+	dest := args[len(args)-1]
+
+	destIsDir := false
+	if stat, err := os.Stat(dest); err == nil && stat.IsDir() {
+		destIsDir = true
+	}
+
+	if len(sources) > 1 && !destIsDir {
+		return nil, "", false, fmt.Errorf("%v: not a directory", dest)
+	}
+
+	return sources, dest, destIsDir, nil
+}
+
+func destinationPath(source, dest string, destIsDir bool) string {
+	if destIsDir {
+		return filepath.Join(dest, filepath.Base(source))
+	}
+
+	return dest
+}
+
+func movePath(store *storage.Storage, source, dest string) error {
+	absSource, err := filepath.Abs(source)
+	if err != nil {
+		return err
+	}
+
+	absDest, err := filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Lstat(absDest); err == nil {
+		return fmt.Errorf("destination already exists")
+	}
+
+	log.Infof(2, "%v: moving to '%v'", source, dest)
+
+	if err := os.Rename(absSource, absDest); err != nil {
+		return err
+	}
+
+	file, err := store.FileByPath(absSource)
+	if err != nil {
+		return fmt.Errorf("could not retrieve file: %v", err)
+	}
+	if file != nil {
+		if _, err := store.UpdateFile(file.Id, absDest, file.Fingerprint, file.ModTime, file.Size, file.IsDir); err != nil {
+			return fmt.Errorf("could not update file: %v", err)
+		}
+	}
+
+	childFiles, err := store.FilesByDirectory(absSource)
+	if err != nil {
+		return fmt.Errorf("could not retrieve files for directory: %v", err)
+	}
+
+	for _, childFile := range childFiles {
+		childDest := absDest + childFile.Path()[len(absSource):]
+
+		if _, err := store.UpdateFile(childFile.Id, childDest, childFile.Fingerprint, childFile.ModTime, childFile.Size, childFile.IsDir); err != nil {
+			return fmt.Errorf("%v: could not update file: %v", childFile.Path(), err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"os"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestMoveFileMovesFileAndEntry(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/mv/a", "a"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll("/tmp/tmsu/mv")
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/mv/a", "apple"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := MoveFileCommand.Exec(store, Options{}, []string{"/tmp/tmsu/mv/a", "/tmp/tmsu/mv/b"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	if _, err := os.Stat("/tmp/tmsu/mv/b"); err != nil {
+		test.Fatal(err)
+	}
+
+	files, err := store.Files()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Path() != "/tmp/tmsu/mv/b" {
+		test.Fatalf("Expected file entry to be moved.")
+	}
+
+	apple, err := store.TagByName("apple")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	expectTags(test, store, files[0], apple)
+}
+
+func TestMoveFileMovesDirectoryContents(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/mv/dir/a", "a"); err != nil {
+		test.Fatal(err)
+	}
+	if err := os.MkdirAll("/tmp/tmsu/mv/dest", 0777); err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll("/tmp/tmsu/mv")
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/mv/dir/a", "apple"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := MoveFileCommand.Exec(store, Options{}, []string{"/tmp/tmsu/mv/dir", "/tmp/tmsu/mv/dest"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	files, err := store.Files()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Path() != "/tmp/tmsu/mv/dest/dir/a" {
+		test.Fatalf("Expected directory contents to be moved.")
+	}
+}
+
+func TestMvIsAliasOfRename(test *testing.T) {
+	// test
+
+	command := findCommand(commands, "mv")
+
+	// validate
+
+	if command != &RenameCommand {
+		test.Fatalf("Expected 'mv' to be the 'rename' subcommand but was '%v'.", command.Name)
+	}
+}
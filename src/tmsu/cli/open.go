@@ -0,0 +1,171 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+	"tmsu/common/log"
+	"tmsu/entities"
+	"tmsu/query"
+	"tmsu/storage"
+)
+
+var OpenCommand = Command{
+	Name:     "open",
+	Synopsis: "Open the files matching a query",
+	Usages:   []string{"tmsu open [OPTION]... QUERY"},
+	Description: `Opens the files matching QUERY, which uses the same syntax as the 'files' subcommand.
+
+By default each file is opened with the desktop's preferred application using 'xdg-open' ('open' on macOS). With --program the files are instead passed together to PROGRAM, which may include arguments, so that a media player can queue them as a playlist.
+
+The files are opened in path order unless --shuffle is specified. Files that no longer exist are skipped.`,
+	Examples: []string{"$ tmsu open report and year=2014",
+		"$ tmsu open --program=mpv --shuffle jazz and not heard",
+		"$ tmsu open --limit=1 --shuffle wallpaper"},
+	Options: Options{{"--program", "-p", "open the files with PROGRAM", true, ""},
+		{"--shuffle", "-s", "open the files in a random order", false, ""},
+		{"--limit", "-n", "open at most COUNT files", true, ""}},
+	Exec: openExec,
+}
+
+func openExec(store *storage.Storage, options Options, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("query must be specified")
+	}
+
+	limit := 0
+	if options.HasOption("--limit") {
+		var err error
+		limit, err = strconv.Atoi(options.Get("--limit").Argument)
+		if err != nil || limit < 1 {
+			return fmt.Errorf("invalid limit '%v'", options.Get("--limit").Argument)
+		}
+	}
+
+	paths, err := openablePaths(store, strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+
+	if options.HasOption("--shuffle") {
+		random := rand.New(rand.NewSource(time.Now().UnixNano()))
+		random.Shuffle(len(paths), func(i, j int) { paths[i], paths[j] = paths[j], paths[i] })
+	}
+
+	if limit > 0 && len(paths) > limit {
+		paths = paths[:limit]
+	}
+
+	if len(paths) == 0 {
+		log.Warn("no files match the query")
+		return nil
+	}
+
+	if options.HasOption("--program") {
+		words := strings.Fields(options.Get("--program").Argument)
+		if len(words) == 0 {
+			return fmt.Errorf("program must be specified")
+		}
+
+		return runOpener(words[0], append(words[1:], paths...))
+	}
+
+	if runtime.GOOS == "darwin" {
+		return runOpener("open", paths)
+	}
+
+	// 'xdg-open' accepts only a single file
+	for _, path := range paths {
+		if err := runOpener("xdg-open", []string{path}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unexported
+
+// The paths of the files matching the query that still exist, in path order.
+func openablePaths(store *storage.Storage, queryText string) ([]string, error) {
+	files, err := queryFilesByText(store, queryText)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		path := file.Path()
+		if _, err := os.Stat(path); err != nil {
+			log.Infof(2, "%v: skipping: %v", path, err)
+			continue
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// Retrieves the files matching the query, checking that its tags exist.
+func queryFilesByText(store *storage.Storage, queryText string) (entities.Files, error) {
+	expression, err := store.ParseQuery(queryText)
+	if err != nil {
+		return nil, querySyntaxError{err}
+	}
+
+	tagNames := query.TagNames(expression)
+	tags, err := store.TagsByNames(tagNames)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve tags: %v", err)
+	}
+	for _, tagName := range tagNames {
+		if !tags.ContainsName(tagName) {
+			return nil, noSuchTagError{tagName}
+		}
+	}
+
+	files, err := store.QueryFiles(expression, "", false)
+	if err != nil {
+		return nil, fmt.Errorf("could not query files: %v", err)
+	}
+
+	return files, nil
+}
+
+func runOpener(program string, args []string) error {
+	log.Infof(2, "running %v %v", program, strings.Join(args, " "))
+
+	command := exec.Command(program, args...)
+	command.Stdin = os.Stdin
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("could not run '%v': %v", program, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestOpenWithProgram(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, path := range []string{"/tmp/tmsu/a.mp3", "/tmp/tmsu/b.mp3", "/tmp/tmsu/c.mp3"} {
+		if err := createFile(path, path); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+
+		if err := TagCommand.Exec(store, Options{}, []string{path, "jazz"}); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/b.mp3", "heard"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := redirectStreams(); err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	// test
+
+	options := Options{Option{"--program", "-p", "", true, "echo playing"}}
+	if err := OpenCommand.Exec(store, options, []string{"jazz", "and", "not", "heard"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "playing /tmp/tmsu/a.mp3 /tmp/tmsu/c.mp3\n", string(bytes))
+}
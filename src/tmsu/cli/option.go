@@ -64,6 +64,18 @@ func (options Options) Get(name string) *Option {
 	return nil
 }
 
+func (options Options) GetAll(name string) Options {
+	matches := make(Options, 0, len(options))
+
+	for _, option := range options {
+		if option.LongName == name || option.ShortName == name {
+			matches = append(matches, option)
+		}
+	}
+
+	return matches
+}
+
 type OptionParser struct {
 	globalOptions Options
 	commandByName map[string]*Command
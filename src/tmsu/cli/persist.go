@@ -0,0 +1,180 @@
+// +build !windows
+
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"tmsu/common/log"
+)
+
+// Arranges for the virtual filesystem to be mounted at login. Where the
+// systemd user instance is available a unit is installed and enabled that
+// hosts the virtual filesystem, otherwise a line is printed for the user to
+// add to '/etc/fstab'.
+func mountPersistent(databasePath, mountPath, mountOptions string) error {
+	absDatabasePath, err := filepath.Abs(databasePath)
+	if err != nil {
+		return fmt.Errorf("%v: could not get absolute path: %v", databasePath, err)
+	}
+
+	absMountPath, err := filepath.Abs(mountPath)
+	if err != nil {
+		return fmt.Errorf("%v: could not get absolute path: %v", mountPath, err)
+	}
+
+	if _, err := exec.LookPath("systemctl"); err != nil || !systemdUserRunning() {
+		log.Infof(2, "systemd user instance is unavailable: using fstab instead")
+
+		fmt.Printf("add the following line to '/etc/fstab' (and install 'mount.tmsu'):\n%v\n", fstabEntry(absDatabasePath, absMountPath, mountOptions))
+
+		return nil
+	}
+
+	tmsuPath, err := exec.LookPath(os.Args[0])
+	if err == nil {
+		tmsuPath, err = filepath.Abs(tmsuPath)
+	}
+	if err != nil {
+		return fmt.Errorf("could not identify path of 'tmsu' executable: %v", err)
+	}
+
+	fusermountPath, err := exec.LookPath("fusermount")
+	if err != nil {
+		return fmt.Errorf("could not find 'fusermount': ensure fuse is installed: %v", err)
+	}
+
+	unitDirectory, err := systemdUserUnitDirectory()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(unitDirectory, 0755); err != nil {
+		return fmt.Errorf("%v: could not create directory: %v", unitDirectory, err)
+	}
+
+	unitName := systemdUnitName(absMountPath)
+	unitPath := filepath.Join(unitDirectory, unitName)
+	unit := systemdUnit(tmsuPath, fusermountPath, absDatabasePath, absMountPath, mountOptions)
+
+	log.Infof(2, "writing systemd unit '%v'", unitPath)
+
+	if err := ioutil.WriteFile(unitPath, []byte(unit), 0644); err != nil {
+		return fmt.Errorf("%v: could not write systemd unit: %v", unitPath, err)
+	}
+
+	for _, args := range [][]string{{"--user", "daemon-reload"}, {"--user", "enable", "--now", unitName}} {
+		log.Infof(2, "running: systemctl %v", strings.Join(args, " "))
+
+		output, err := exec.Command("systemctl", args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("could not enable systemd unit '%v': %v: %v", unitName, err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	fmt.Printf("%v: mounted by systemd unit '%v'\n", absMountPath, unitName)
+
+	return nil
+}
+
+// The systemd user manager reports 'degraded' when any unit has failed, which
+// is no reason not to use it.
+func systemdUserRunning() bool {
+	output, _ := exec.Command("systemctl", "--user", "is-system-running").Output()
+	state := strings.TrimSpace(string(output))
+
+	return state == "running" || state == "degraded" || state == "starting"
+}
+
+func systemdUserUnitDirectory() (string, error) {
+	if configHome := os.Getenv("XDG_CONFIG_HOME"); configHome != "" {
+		return filepath.Join(configHome, "systemd", "user"), nil
+	}
+
+	home := os.Getenv("HOME")
+	if home == "" {
+		return "", fmt.Errorf("could not identify home directory: HOME is not set")
+	}
+
+	return filepath.Join(home, ".config", "systemd", "user"), nil
+}
+
+// Names the unit after the mount path in the manner of 'systemd-escape --path'.
+func systemdUnitName(mountPath string) string {
+	path := strings.Trim(mountPath, "/")
+
+	var name []byte
+	for index := 0; index < len(path); index++ {
+		char := path[index]
+
+		switch {
+		case char == '/':
+			name = append(name, '-')
+		case char >= 'a' && char <= 'z', char >= 'A' && char <= 'Z', char >= '0' && char <= '9', char == '_', char == '.' && index > 0:
+			name = append(name, char)
+		default:
+			name = append(name, []byte(fmt.Sprintf(`\x%02x`, char))...)
+		}
+	}
+
+	return "tmsu-" + string(name) + ".service"
+}
+
+func systemdUnit(tmsuPath, fusermountPath, databasePath, mountPath, mountOptions string) string {
+	return fmt.Sprintf(`[Unit]
+Description=TMSU virtual filesystem at %[6]v
+
+[Service]
+Type=simple
+ExecStartPre=/bin/mkdir -p %[4]v
+ExecStart=%[1]v vfs %[3]v %[4]v %[5]v
+ExecStop=%[2]v -u %[4]v
+Restart=on-failure
+
+[Install]
+WantedBy=default.target
+`, systemdQuote(tmsuPath), systemdQuote(fusermountPath), systemdQuote("--database="+databasePath), systemdQuote(mountPath), systemdQuote("--options="+mountOptions), strings.Replace(mountPath, "%", "%%", -1))
+}
+
+// Quotes a word of a unit's command line. Within the quotes a backslash
+// introduces an escape, whilst '%' introduces a specifier and '$' a variable
+// unless doubled.
+func systemdQuote(word string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "%", "%%", "$", "$$")
+
+	return `"` + escape.Replace(word) + `"`
+}
+
+// fstab fields are separated by whitespace, so whitespace in the paths must be
+// escaped as octal.
+func fstabEntry(databasePath, mountPath, mountOptions string) string {
+	options := "user,nofail"
+	if mountOptions != "" {
+		options = mountOptions + "," + options
+	}
+
+	escape := strings.NewReplacer(" ", `\040`, "\t", `\011`)
+
+	return fmt.Sprintf("%v  %v  tmsu  %v  0  0", escape.Replace(databasePath), escape.Replace(mountPath), options)
+}
@@ -0,0 +1,64 @@
+// +build !windows
+
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSystemdUnitName(test *testing.T) {
+	name := systemdUnitName("/home/bob/my mp")
+
+	if name != `tmsu-home-bob-my\x20mp.service` {
+		test.Fatalf("Unexpected unit name '%v'.", name)
+	}
+}
+
+func TestSystemdUnit(test *testing.T) {
+	unit := systemdUnit("/usr/bin/tmsu", "/bin/fusermount", "/home/bob/.tmsu/db", "/home/bob/mp", "ro")
+
+	if !strings.Contains(unit, "\nExecStart=\"/usr/bin/tmsu\" vfs \"--database=/home/bob/.tmsu/db\" \"/home/bob/mp\" \"--options=ro\"\n") {
+		test.Fatalf("Unit does not start the virtual filesystem:\n%v", unit)
+	}
+	if !strings.Contains(unit, "\nExecStop=\"/bin/fusermount\" -u \"/home/bob/mp\"\n") {
+		test.Fatalf("Unit does not stop the virtual filesystem:\n%v", unit)
+	}
+	if !strings.Contains(unit, "\nWantedBy=default.target\n") {
+		test.Fatalf("Unit is not installed for login:\n%v", unit)
+	}
+}
+
+func TestSystemdUnitEscapesPaths(test *testing.T) {
+	unit := systemdUnit("/usr/bin/tmsu", "/bin/fusermount", `/home/bob/$HOME/"db"`, `/home/bob/100% \mp`, "ro")
+
+	if !strings.Contains(unit, "\nDescription=TMSU virtual filesystem at /home/bob/100%% \\mp\n") {
+		test.Fatalf("Unit does not describe the mount path:\n%v", unit)
+	}
+	if !strings.Contains(unit, `ExecStart="/usr/bin/tmsu" vfs "--database=/home/bob/$$HOME/\"db\"" "/home/bob/100%% \\mp" "--options=ro"`+"\n") {
+		test.Fatalf("Unit does not escape the paths:\n%v", unit)
+	}
+}
+
+func TestFstabEntry(test *testing.T) {
+	entry := fstabEntry("/home/bob/.tmsu/db", "/home/bob/my mp", "allow_other")
+
+	compareOutput(test, `/home/bob/.tmsu/db  /home/bob/my\040mp  tmsu  allow_other,user,nofail  0  0`, entry)
+}
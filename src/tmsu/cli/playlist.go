@@ -0,0 +1,244 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"tmsu/common/log"
+	"tmsu/storage"
+)
+
+var PlaylistCommand = Command{
+	Name:     "playlist",
+	Synopsis: "Write a playlist of the files matching a query",
+	Usages:   []string{"tmsu playlist [OPTION]... QUERY"},
+	Description: `Writes a playlist of the files matching QUERY, which uses the same syntax as the 'files' subcommand. Directories are omitted.
+
+The playlist is written to standard output unless --output is specified. FORMAT is one of:
+
+  m3u    extended M3U (default)
+  m3u8   extended M3U, UTF-8 encoded
+  xspf   XML Shareable Playlist Format
+
+The paths are absolute unless --relative is specified, in which case they are relative to the directory of the playlist (or the working directory when writing to standard output) so that the playlist can be moved along with the files.
+
+With --watch the command does not exit but rewrites the playlist whenever the database changes. It can be left running, for example as a systemd user service, to keep the playlist up to date.`,
+	Examples: []string{"$ tmsu playlist genre=jazz and rating '>=' 4",
+		"$ tmsu playlist --format=m3u8 --relative --output ~/Music/jazz.m3u8 genre=jazz",
+		"$ tmsu playlist --watch --format=xspf --output=favourites.xspf favourite"},
+	Options: Options{{"--format", "-f", "the playlist format: m3u (default), m3u8 or xspf", true, ""},
+		{"--output", "-o", "write the playlist to FILE", true, ""},
+		{"--relative", "-r", "write paths relative to the playlist", false, ""},
+		{"--watch", "-w", "rewrite the playlist whenever the database changes", false, ""}},
+	Exec: playlistExec,
+}
+
+func playlistExec(store *storage.Storage, options Options, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("query must be specified")
+	}
+
+	format := "m3u"
+	if options.HasOption("--format") {
+		format = options.Get("--format").Argument
+	}
+	switch format {
+	case "m3u", "m3u8", "xspf":
+	default:
+		return usageError{fmt.Errorf("invalid format '%v': must be 'm3u', 'm3u8' or 'xspf'", format)}
+	}
+
+	outputPath := ""
+	if options.HasOption("--output") {
+		var err error
+		outputPath, err = filepath.Abs(options.Get("--output").Argument)
+		if err != nil {
+			return fmt.Errorf("could not get absolute path: %v", err)
+		}
+	}
+
+	relativeTo := ""
+	if options.HasOption("--relative") {
+		if outputPath != "" {
+			relativeTo = filepath.Dir(outputPath)
+		} else {
+			var err error
+			relativeTo, err = os.Getwd()
+			if err != nil {
+				return fmt.Errorf("could not identify working directory: %v", err)
+			}
+		}
+	}
+
+	queryText := strings.Join(args, " ")
+
+	if !options.HasOption("--watch") {
+		return writePlaylist(store, queryText, format, outputPath, relativeTo)
+	}
+
+	if outputPath == "" {
+		return fmt.Errorf("--watch requires --output")
+	}
+
+	return watchPlaylist(store, queryText, format, outputPath, relativeTo)
+}
+
+// unexported
+
+const playlistPollInterval = time.Second
+
+func writePlaylist(store *storage.Storage, queryText, format, outputPath, relativeTo string) error {
+	files, err := queryFilesByText(store, queryText)
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		if file.IsDir {
+			continue
+		}
+
+		path := file.Path()
+		if relativeTo != "" {
+			if relPath, err := filepath.Rel(relativeTo, path); err == nil {
+				path = relPath
+			}
+		}
+
+		paths = append(paths, path)
+	}
+
+	var playlist []byte
+	switch format {
+	case "xspf":
+		playlist = xspfPlaylist(paths)
+	default:
+		playlist = m3uPlaylist(paths)
+	}
+
+	if outputPath == "" {
+		_, err := os.Stdout.Write(playlist)
+		return err
+	}
+
+	log.Infof(2, "%v: writing playlist of %v files", outputPath, len(paths))
+
+	// replace the playlist in one go so that it is never seen half written
+	tempPath := outputPath + ".tmp"
+	if err := ioutil.WriteFile(tempPath, playlist, 0666); err != nil {
+		return fmt.Errorf("%v: could not write playlist: %v", outputPath, err)
+	}
+	if err := os.Rename(tempPath, outputPath); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("%v: could not write playlist: %v", outputPath, err)
+	}
+
+	return nil
+}
+
+// Rewrites the playlist whenever the database changes. This does not return
+// unless there is an error.
+func watchPlaylist(store *storage.Storage, queryText, format, outputPath, relativeTo string) error {
+	// the transaction must be closed so as to see changes made by others: the
+	// changes made so far, e.g. by earlier commands read from standard input,
+	// are kept
+	if err := store.Commit(); err != nil {
+		return err
+	}
+
+	watchErr := pollPlaylist(store, queryText, format, outputPath, relativeTo)
+
+	if err := store.Begin(); err != nil {
+		return err
+	}
+
+	return watchErr
+}
+
+func pollPlaylist(store *storage.Storage, queryText, format, outputPath, relativeTo string) error {
+	lastSeq, err := store.LatestEventSeq()
+	if err != nil {
+		return fmt.Errorf("could not retrieve latest event: %v", err)
+	}
+
+	if err := writePlaylist(store, queryText, format, outputPath, relativeTo); err != nil {
+		return err
+	}
+
+	for {
+		time.Sleep(playlistPollInterval)
+
+		log.Info(3, "polling for changes")
+
+		seq, err := store.LatestEventSeq()
+		if err != nil {
+			return fmt.Errorf("could not retrieve latest event: %v", err)
+		}
+		if seq == lastSeq {
+			continue
+		}
+		lastSeq = seq
+
+		if err := writePlaylist(store, queryText, format, outputPath, relativeTo); err != nil {
+			log.Warnf("%v", err)
+		}
+	}
+}
+
+func m3uPlaylist(paths []string) []byte {
+	var playlist bytes.Buffer
+
+	playlist.WriteString("#EXTM3U\n")
+	for _, path := range paths {
+		playlist.WriteString(path)
+		playlist.WriteString("\n")
+	}
+
+	return playlist.Bytes()
+}
+
+func xspfPlaylist(paths []string) []byte {
+	var playlist bytes.Buffer
+
+	playlist.WriteString(xml.Header)
+	playlist.WriteString(`<playlist version="1" xmlns="http://xspf.org/ns/0/">` + "\n")
+	playlist.WriteString("  <trackList>\n")
+	for _, path := range paths {
+		location := url.URL{Path: filepath.ToSlash(path)}
+		if filepath.IsAbs(path) {
+			location.Scheme = "file"
+		}
+
+		playlist.WriteString("    <track><location>")
+		xml.EscapeText(&playlist, []byte(location.String()))
+		playlist.WriteString("</location></track>\n")
+	}
+	playlist.WriteString("  </trackList>\n")
+	playlist.WriteString("</playlist>\n")
+
+	return playlist.Bytes()
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestPlaylistRelativeM3u(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, path := range []string{"/tmp/tmsu/music/a.mp3", "/tmp/tmsu/music/b c.mp3"} {
+		if err := createFile(path, path); err != nil {
+			test.Fatal(err)
+		}
+
+		if err := TagCommand.Exec(store, Options{}, []string{path, "genre=jazz"}); err != nil {
+			test.Fatal(err)
+		}
+	}
+	defer os.RemoveAll("/tmp/tmsu/music")
+
+	// test
+
+	options := Options{Option{"--output", "-o", "", true, "/tmp/tmsu/music/jazz.m3u"},
+		Option{"--relative", "-r", "", false, ""}}
+	if err := PlaylistCommand.Exec(store, options, []string{"genre=jazz"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	bytes, err := ioutil.ReadFile("/tmp/tmsu/music/jazz.m3u")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	compareOutput(test, "#EXTM3U\na.mp3\nb c.mp3\n", string(bytes))
+}
+
+func TestPlaylistWatchKeepsEarlierChanges(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+
+	// tagging would commit the transaction to run the hooks
+	if _, err := store.AddTag("jazz"); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	options := Options{Option{"--output", "-o", "", true, "/tmp/tmsu/missing/jazz.m3u"},
+		Option{"--watch", "-w", "", false, ""}}
+	if err := PlaylistCommand.Exec(store, options, []string{"jazz"}); err == nil {
+		test.Fatal("Expected watching a playlist in a missing directory to fail.")
+	}
+
+	// validate
+
+	if err := store.Rollback(); err != nil {
+		test.Fatal(err)
+	}
+
+	tag, err := store.TagByName("jazz")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if tag == nil {
+		test.Fatal("The earlier change was discarded.")
+	}
+}
+
+func TestXspfPlaylist(test *testing.T) {
+	playlist := xspfPlaylist([]string{"/music/b c.mp3", "d&e.mp3"})
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/">
+  <trackList>
+    <track><location>file:///music/b%20c.mp3</location></track>
+    <track><location>d&amp;e.mp3</location></track>
+  </trackList>
+</playlist>
+`
+	compareOutput(test, expected, string(playlist))
+}
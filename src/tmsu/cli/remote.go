@@ -0,0 +1,401 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"tmsu/common/log"
+	"tmsu/storage"
+)
+
+// Paths of the form 'ssh://[USER@]HOST[:PORT]/PATH' refer to files on a remote
+// machine. Commands with such paths are sent to 'tmsu serve --stdio' on the
+// remote machine, over SSH, which runs them against the remote database.
+const remoteScheme = "ssh://"
+
+type remoteHost struct {
+	user string
+	host string
+	port string
+}
+
+// A command to run on the remote machine. The directory is that of the first
+// remote path, from which the remote database is located.
+type remoteRequest struct {
+	Command   string   `json:"command"`
+	Options   Options  `json:"options"`
+	Arguments []string `json:"arguments"`
+	Directory string   `json:"directory"`
+	Verbosity uint     `json:"verbosity"`
+}
+
+type remoteResponse struct {
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+	Error  string `json:"error"`
+	Failed bool   `json:"failed"`
+}
+
+// Options that apply to the local invocation rather than the command.
+var localOptions = map[string]bool{"--verbose": true, "--database": true, "--color": true, "--help": true, "--version": true, "--porcelain": true}
+
+// Identifies the remote host, if any, to which the command refers and builds
+// the request with the remote paths stripped of their scheme and host.
+func remoteRequestFor(commandName string, options Options, arguments []string) (*remoteHost, *remoteRequest, error) {
+	var host *remoteHost
+	request := remoteRequest{Command: commandName, Options: make(Options, 0, len(options)), Arguments: make([]string, len(arguments)), Verbosity: log.Verbosity}
+
+	rewrite := func(value string) (string, error) {
+		if !strings.HasPrefix(value, remoteScheme) {
+			return value, nil
+		}
+
+		valueHost, remotePath, err := parseRemotePath(value)
+		if err != nil {
+			return "", err
+		}
+
+		if host == nil {
+			host = valueHost
+			request.Directory = remotePath
+		} else if *host != *valueHost {
+			return "", fmt.Errorf("paths on different remote hosts cannot be mixed")
+		}
+
+		return remotePath, nil
+	}
+
+	for index, argument := range arguments {
+		remoteArgument, err := rewrite(argument)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		request.Arguments[index] = remoteArgument
+	}
+
+	for _, option := range options {
+		if localOptions[option.LongName] {
+			continue
+		}
+
+		remoteArgument, err := rewrite(option.Argument)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		option.Argument = remoteArgument
+		request.Options = append(request.Options, option)
+	}
+
+	if host == nil {
+		return nil, nil, nil
+	}
+
+	return host, &request, nil
+}
+
+func parseRemotePath(value string) (*remoteHost, string, error) {
+	remoteUrl, err := url.Parse(value)
+	if err != nil {
+		return nil, "", fmt.Errorf("%v: invalid remote path: %v", value, err)
+	}
+	if remoteUrl.Host == "" {
+		return nil, "", fmt.Errorf("%v: remote path has no host", value)
+	}
+
+	remotePath := remoteUrl.Path
+	if remotePath == "" {
+		remotePath = "/"
+	}
+
+	host := remoteHost{host: remoteUrl.Hostname(), port: remoteUrl.Port()}
+	if remoteUrl.User != nil {
+		host.user = remoteUrl.User.Username()
+	}
+
+	// a leading hyphen would have 'ssh' treat the destination as an option
+	if strings.HasPrefix(host.host, "-") {
+		return nil, "", fmt.Errorf("%v: invalid remote host '%v'", value, host.host)
+	}
+	if strings.HasPrefix(host.user, "-") {
+		return nil, "", fmt.Errorf("%v: invalid remote user '%v'", value, host.user)
+	}
+
+	return &host, remotePath, nil
+}
+
+// Builds the 'ssh' arguments that run 'tmsu serve --stdio' on the host. The
+// destination follows '--' so that it can never be read as an option.
+func sshArguments(host *remoteHost) []string {
+	args := make([]string, 0, 7)
+	if host.port != "" {
+		args = append(args, "-p", host.port)
+	}
+	args = append(args, "--")
+	if host.user != "" {
+		args = append(args, host.user+"@"+host.host)
+	} else {
+		args = append(args, host.host)
+	}
+	args = append(args, "tmsu", "serve", "--stdio")
+
+	return args
+}
+
+// Runs the command on the remote host, relaying its output.
+func runRemote(host *remoteHost, request *remoteRequest) error {
+	args := sshArguments(host)
+
+	log.Infof(2, "running: ssh %v", strings.Join(args, " "))
+
+	command := exec.Command("ssh", args...)
+	command.Stderr = os.Stderr
+
+	input, err := command.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("could not open standard input pipe: %v", err)
+	}
+
+	output, err := command.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("could not open standard output pipe: %v", err)
+	}
+
+	if err := command.Start(); err != nil {
+		return fmt.Errorf("could not start 'ssh': %v", err)
+	}
+
+	response, err := exchangeRemote(input, output, request)
+	input.Close()
+
+	if waitErr := command.Wait(); err == nil && waitErr != nil {
+		err = fmt.Errorf("ssh to '%v' failed: %v", host.host, waitErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(response.Stdout)
+	fmt.Fprint(os.Stderr, response.Stderr)
+
+	switch {
+	case response.Error != "":
+		return fmt.Errorf("%v", response.Error)
+	case response.Failed:
+		return errBlank
+	}
+
+	return nil
+}
+
+func exchangeRemote(writer io.Writer, reader io.Reader, request *remoteRequest) (*remoteResponse, error) {
+	if err := json.NewEncoder(writer).Encode(request); err != nil {
+		return nil, fmt.Errorf("could not send command to remote: %v", err)
+	}
+
+	var response remoteResponse
+	if err := json.NewDecoder(reader).Decode(&response); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("remote 'tmsu serve --stdio' exited without responding")
+		}
+
+		return nil, fmt.Errorf("could not read response from remote: %v", err)
+	}
+
+	return &response, nil
+}
+
+// Runs the commands read from reader, writing the responses to writer, until
+// reader is exhausted. Unless explicitly specified, the database is located
+// from each request's directory just as it would be for a local command.
+func serveStdio(store *storage.Storage, explicitDatabase bool, reader io.Reader, writer io.Writer) error {
+	decoder := json.NewDecoder(reader)
+	encoder := json.NewEncoder(writer)
+
+	for {
+		var request remoteRequest
+		if err := decoder.Decode(&request); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return fmt.Errorf("could not read request: %v", err)
+		}
+
+		response := handleRemoteRequest(store, explicitDatabase, &request)
+
+		if err := encoder.Encode(response); err != nil {
+			return fmt.Errorf("could not write response: %v", err)
+		}
+	}
+}
+
+func handleRemoteRequest(store *storage.Storage, explicitDatabase bool, request *remoteRequest) *remoteResponse {
+	response := remoteResponse{}
+
+	command := findCommand(helpCommands, request.Command)
+	if command == nil || request.Command == "-" {
+		response.Error = fmt.Sprintf("invalid command '%v'", request.Command)
+		return &response
+	}
+	if command.NoTransaction || command.Name == "mount" {
+		response.Error = fmt.Sprintf("command '%v' cannot be run remotely", command.Name)
+		return &response
+	}
+
+	if request.Directory != "" {
+		directory := request.Directory
+		if stat, err := os.Stat(directory); err != nil || !stat.IsDir() {
+			directory = path.Dir(directory)
+		}
+
+		if err := os.Chdir(directory); err != nil {
+			response.Error = fmt.Sprintf("%v: could not change directory: %v", directory, err)
+			return &response
+		}
+	}
+
+	databasePath := store.Db.Path
+	if !explicitDatabase {
+		var err error
+		if databasePath, err = findDatabase(); err != nil {
+			response.Error = fmt.Sprintf("could not find database: %v", err)
+			return &response
+		}
+	}
+
+	if databasePath != store.Db.Path {
+		requestStore, err := storage.OpenAt(databasePath)
+		if err != nil {
+			response.Error = fmt.Sprintf("could not open storage: %v", err)
+			return &response
+		}
+		defer requestStore.Close()
+		requestStore.Config = configuration
+
+		store = requestStore
+	}
+
+	if err := store.Begin(); err != nil {
+		response.Error = fmt.Sprintf("could not begin transaction: %v", err)
+		return &response
+	}
+
+	verbosity := log.Verbosity
+	log.Verbosity = request.Verbosity
+	defer func() { log.Verbosity = verbosity }()
+
+	stdout, stderr, err := captureOutput(func() error {
+		options, err := applyDefaultOptions(store, command, request.Options)
+		if err != nil {
+			return err
+		}
+
+		if option := untilInterruptedOption(command, options); option != "" {
+			return fmt.Errorf("option '%v' of command '%v' cannot be used remotely", option, command.Name)
+		}
+
+		return command.Exec(store, options, request.Arguments)
+	})
+
+	response.Stdout = stdout
+	response.Stderr = stderr
+
+	switch {
+	case err == errBlank:
+		response.Failed = true
+	case err != nil:
+		response.Error = err.Error()
+	}
+
+	if err := store.Commit(); err != nil {
+		response.Error = fmt.Sprintf("could not commit transaction: %v", err)
+	}
+
+	return &response
+}
+
+// The option, if any, with which the command runs until interrupted, which it
+// cannot do remotely as the response is sent only once the command completes.
+func untilInterruptedOption(command *Command, options Options) string {
+	var name string
+	switch command.Name {
+	case "events":
+		name = "--follow"
+	case "playlist":
+		name = "--watch"
+	default:
+		return ""
+	}
+
+	if options.HasOption(name) {
+		return name
+	}
+
+	return ""
+}
+
+// Runs fn with the standard output and error streams captured. Standard input
+// is empty so that a command cannot prompt the remote user.
+func captureOutput(fn func() error) (string, string, error) {
+	stdinFile, err := os.Open(os.DevNull)
+	if err != nil {
+		return "", "", fmt.Errorf("could not open '%v': %v", os.DevNull, err)
+	}
+	defer stdinFile.Close()
+
+	stdoutFile, err := ioutil.TempFile("", "tmsu-stdout")
+	if err != nil {
+		return "", "", fmt.Errorf("could not create temporary file: %v", err)
+	}
+	defer os.Remove(stdoutFile.Name())
+	defer stdoutFile.Close()
+
+	stderrFile, err := ioutil.TempFile("", "tmsu-stderr")
+	if err != nil {
+		return "", "", fmt.Errorf("could not create temporary file: %v", err)
+	}
+	defer os.Remove(stderrFile.Name())
+	defer stderrFile.Close()
+
+	stdin, stdout, stderr := os.Stdin, os.Stdout, os.Stderr
+	os.Stdin, os.Stdout, os.Stderr = stdinFile, stdoutFile, stderrFile
+	fnErr := fn()
+	os.Stdin, os.Stdout, os.Stderr = stdin, stdout, stderr
+
+	stdoutBytes, err := ioutil.ReadFile(stdoutFile.Name())
+	if err != nil {
+		return "", "", fmt.Errorf("could not read captured output: %v", err)
+	}
+
+	stderrBytes, err := ioutil.ReadFile(stderrFile.Name())
+	if err != nil {
+		return "", "", fmt.Errorf("could not read captured output: %v", err)
+	}
+
+	return string(stdoutBytes), string(stderrBytes), fnErr
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestRemoteRequestFor(test *testing.T) {
+	// test
+
+	options := Options{Option{"--verbose", "-v", "", false, ""}, Option{"--tags", "-t", "", true, "beach sea"}}
+	host, request, err := remoteRequestFor("tag", options, []string{"ssh://bob@nas:2222/media/a.jpg", "ssh://bob@nas:2222/media/b.jpg"})
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	if host == nil || *host != (remoteHost{"bob", "nas", "2222"}) {
+		test.Fatalf("Incorrect remote host %v.", host)
+	}
+	if len(request.Arguments) != 2 || request.Arguments[0] != "/media/a.jpg" || request.Arguments[1] != "/media/b.jpg" {
+		test.Fatalf("Incorrect remote arguments %v.", request.Arguments)
+	}
+	if len(request.Options) != 1 || request.Options[0].LongName != "--tags" || request.Options[0].Argument != "beach sea" {
+		test.Fatalf("Incorrect remote options %v.", request.Options)
+	}
+	if request.Directory != "/media/a.jpg" {
+		test.Fatalf("Incorrect remote directory '%v'.", request.Directory)
+	}
+}
+
+func TestRemoteRequestForLocalPaths(test *testing.T) {
+	// test
+
+	host, _, err := remoteRequestFor("tag", Options{}, []string{"/tmp/a.jpg", "beach"})
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	if host != nil {
+		test.Fatalf("Expected local paths not to be remote.")
+	}
+}
+
+func TestRemoteRequestForOptionLikeHost(test *testing.T) {
+	// test
+
+	paths := []string{"ssh://-oProxyCommand=sh/x", "ssh://-oProxyCommand=sh@nas/x"}
+	for _, path := range paths {
+		_, _, err := remoteRequestFor("tags", Options{}, []string{path})
+
+		// validate
+
+		if err == nil {
+			test.Fatalf("Expected remote path '%v' to be rejected.", path)
+		}
+	}
+}
+
+func TestSshArguments(test *testing.T) {
+	// test
+
+	args := sshArguments(&remoteHost{"bob", "nas", "2222"})
+
+	// validate
+
+	expected := []string{"-p", "2222", "--", "bob@nas", "tmsu", "serve", "--stdio"}
+	if len(args) != len(expected) {
+		test.Fatalf("Incorrect ssh arguments %v.", args)
+	}
+	for index := range expected {
+		if args[index] != expected[index] {
+			test.Fatalf("Incorrect ssh arguments %v.", args)
+		}
+	}
+}
+
+func TestServeStdio(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.Chdir(workingDirectory)
+
+	helpCommands = commands
+
+	var input bytes.Buffer
+	encoder := json.NewEncoder(&input)
+	encoder.Encode(remoteRequest{Command: "tag", Arguments: []string{"/tmp/tmsu/a", "apple"}, Directory: "/tmp/tmsu/a"})
+	encoder.Encode(remoteRequest{Command: "tags", Arguments: []string{"/tmp/tmsu/a"}, Directory: "/tmp/tmsu/a"})
+
+	var output bytes.Buffer
+
+	// test
+
+	if err := serveStdio(store, true, &input, &output); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	decoder := json.NewDecoder(&output)
+
+	var response remoteResponse
+	if err := decoder.Decode(&response); err != nil {
+		test.Fatal(err)
+	}
+	if response.Error != "" || response.Failed {
+		test.Fatalf("Tagging failed: %v", response.Error)
+	}
+
+	if err := decoder.Decode(&response); err != nil {
+		test.Fatal(err)
+	}
+	compareOutput(test, "/tmp/tmsu/a: apple\n", response.Stdout)
+}
+
+func TestServeStdioRejectsCommandsThatRunUntilInterrupted(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	helpCommands = commands
+
+	requests := []remoteRequest{
+		{Command: "serve", Options: Options{Option{"--stdio", "", "", false, ""}}},
+		{Command: "vfs", Arguments: []string{"/tmp/tmsu/mnt"}},
+		{Command: "mount", Arguments: []string{"/tmp/tmsu/mnt"}},
+		{Command: "-"},
+		{Command: "events", Options: Options{Option{"--follow", "-f", "", false, ""}}},
+		{Command: "playlist", Options: Options{Option{"--watch", "-w", "", false, ""}}, Arguments: []string{"apple"}},
+	}
+
+	var input bytes.Buffer
+	encoder := json.NewEncoder(&input)
+	for _, request := range requests {
+		encoder.Encode(request)
+	}
+
+	var output bytes.Buffer
+
+	// test
+
+	if err := serveStdio(store, true, &input, &output); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	decoder := json.NewDecoder(&output)
+	for _, request := range requests {
+		var response remoteResponse
+		if err := decoder.Decode(&response); err != nil {
+			test.Fatal(err)
+		}
+		if response.Error == "" {
+			test.Fatalf("Expected command '%v' with options %v to be rejected.", request.Command, request.Options)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"tmsu/common/filesystem"
+	"tmsu/common/log"
+	"tmsu/entities"
+	"tmsu/storage"
+)
+
+var RemoveFileCommand = Command{
+	Name:     "remove-file",
+	Synopsis: "Remove files and their tags",
+	Usages:   []string{"tmsu remove-file [OPTION]... FILE..."},
+	Description: `Removes each FILE from the filesystem along with its entry and tags in the database.
+
+With --trash the files are moved to the trash rather than being removed. With --keep-entry the database entries and tags are retained so that the deleted files can still be queried.
+
+When the 'retainDeletedFiles' setting is enabled, a record of each removed file and its tags is kept: see 'files --missing'.
+
+Each file's database entry is removed before the file itself. Any file that cannot be removed is reported and its database entry kept.
+
+(To delete a tag use the 'delete' subcommand, or its alias 'rm'.)`,
+	Examples: []string{"$ tmsu remove-file mountain.jpg",
+		"$ tmsu remove-file --trash --recursive old-photos"},
+	Options: Options{{"--trash", "-T", "move the files to the trash rather than removing them", false, ""},
+		{"--keep-entry", "-k", "retain the files' database entries and tags", false, ""},
+		{"--recursive", "-r", "remove directories and their contents", false, ""}},
+	Exec: removeFileExec,
+}
+
+func removeFileExec(store *storage.Storage, options Options, args []string) error {
+	trash := options.HasOption("--trash")
+	keepEntry := options.HasOption("--keep-entry")
+	recursive := options.HasOption("--recursive")
+
+	if len(args) == 0 {
+		return fmt.Errorf("files to remove must be specified")
+	}
+
+	wereErrors := false
+	for _, path := range args {
+		if err := removePath(store, path, trash, keepEntry, recursive); err != nil {
+			if err != errBlank {
+				log.Warnf("%v: could not remove: %v", path, err)
+			}
+			wereErrors = true
+		}
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+// unexported
+
+// Removes the path from the database and then from the filesystem. Should the
+// path not be removed from the filesystem entirely, the database entries are
+// kept for those files that remain.
+func removePath(store *storage.Storage, path string, trash, keepEntry, recursive bool) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	stat, err := os.Lstat(absPath)
+	if err != nil {
+		return err
+	}
+	if stat.IsDir() && !recursive {
+		return fmt.Errorf("is a directory (use --recursive)")
+	}
+
+	if keepEntry {
+		return removeFromDisk(path, absPath, trash)
+	}
+
+	files, err := filesUnder(store, absPath)
+	if err != nil {
+		return err
+	}
+
+	err = store.Atomically("remove_file", func() error {
+		for _, file := range files {
+			if err := removeFileEntry(store, file.Id); err != nil {
+				return err
+			}
+		}
+
+		return removeFromDisk(path, absPath, trash)
+	})
+	if err == nil {
+		return nil
+	}
+
+	// keep the entries of only those files that could not be removed
+	for _, file := range files {
+		if _, statErr := os.Lstat(file.Path()); !os.IsNotExist(statErr) {
+			continue
+		}
+
+		if err := removeFileEntry(store, file.Id); err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// Retrieves the database entries for the path and, if a directory, its
+// contents.
+func filesUnder(store *storage.Storage, absPath string) (entities.Files, error) {
+	files := make(entities.Files, 0, 1)
+
+	file, err := store.FileByPath(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve file: %v", err)
+	}
+	if file != nil {
+		files = append(files, file)
+	}
+
+	childFiles, err := store.FilesByDirectory(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve files for directory: %v", err)
+	}
+
+	return append(files, childFiles...), nil
+}
+
+func removeFromDisk(path, absPath string, trash bool) error {
+	if trash {
+		log.Infof(2, "%v: moving to trash", path)
+		return filesystem.Trash(absPath)
+	}
+
+	log.Infof(2, "%v: removing", path)
+	return removeTree(absPath)
+}
+
+// Removes the file or directory and its contents, children before their
+// parents. Each path that cannot be removed is reported, in which case the
+// directories containing it are left in place and errBlank is returned.
+func removeTree(absPath string) error {
+	paths := make([]string, 0, 1)
+	err := filepath.Walk(absPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	remaining := make(map[string]bool)
+	for index := len(paths) - 1; index >= 0; index-- {
+		path := paths[index]
+		if remaining[path] {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			log.Warnf("%v: could not remove: %v", path, err)
+			failed = true
+
+			for dir := path; dir != absPath; {
+				dir = filepath.Dir(dir)
+				remaining[dir] = true
+			}
+		}
+	}
+
+	if failed {
+		return errBlank
+	}
+
+	return nil
+}
+
+// Removes the file's tags and thereby the file's database entry.
+func removeFileEntry(store *storage.Storage, fileId entities.FileId) error {
+	if err := store.RetainDeletedFile(fileId); err != nil {
+		return fmt.Errorf("could not record deleted file: %v", err)
+	}
+
+	if err := store.DeleteFileTagsByFileId(fileId); err != nil {
+		return fmt.Errorf("could not remove file's tags: %v", err)
+	}
+
+	return nil
+}
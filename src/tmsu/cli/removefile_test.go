@@ -0,0 +1,211 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestRemoveFileRemovesFileAndEntry(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/rm/a", "a"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll("/tmp/tmsu/rm")
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/rm/a", "apple"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := RemoveFileCommand.Exec(store, Options{}, []string{"/tmp/tmsu/rm/a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	if _, err := os.Stat("/tmp/tmsu/rm/a"); !os.IsNotExist(err) {
+		test.Fatalf("File was not removed.")
+	}
+
+	files, err := store.Files()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(files) != 0 {
+		test.Fatalf("Expected no files in the database but are %v.", len(files))
+	}
+}
+
+func TestRemoveFileTrashKeepEntry(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/rm/a", "a"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll("/tmp/tmsu/rm")
+
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	os.Setenv("XDG_DATA_HOME", "/tmp/tmsu/rm/data")
+	defer os.Setenv("XDG_DATA_HOME", dataHome)
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/rm/a", "apple"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	options := Options{Option{"--trash", "-T", "", false, ""}, Option{"--keep-entry", "-k", "", false, ""}}
+	if err := RemoveFileCommand.Exec(store, options, []string{"/tmp/tmsu/rm/a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	bytes, err := ioutil.ReadFile("/tmp/tmsu/rm/data/Trash/files/a")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if string(bytes) != "a" {
+		test.Fatalf("Trashed file has unexpected contents.")
+	}
+
+	if _, err := os.Stat("/tmp/tmsu/rm/data/Trash/info/a.trashinfo"); err != nil {
+		test.Fatal(err)
+	}
+
+	files, err := store.Files()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(files) != 1 {
+		test.Fatalf("Expected database entry to be retained.")
+	}
+}
+
+func TestRemoveFileKeepsEntriesOfFilesNotRemoved(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/rm/dir/a", "a"); err != nil {
+		test.Fatal(err)
+	}
+	if err := createFile("/tmp/tmsu/rm/dir/locked/b", "b"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll("/tmp/tmsu/rm")
+
+	if err := TagCommand.Exec(store, Options{Option{"--tags", "-t", "", true, "apple"}}, []string{"/tmp/tmsu/rm/dir/a", "/tmp/tmsu/rm/dir/locked/b"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// files cannot be removed from a directory without write permission
+	if err := os.Chmod("/tmp/tmsu/rm/dir/locked", 0555); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Chmod("/tmp/tmsu/rm/dir/locked", 0755)
+
+	if err := createFile("/tmp/tmsu/rm/dir/locked/probe", ""); err == nil {
+		os.Remove("/tmp/tmsu/rm/dir/locked/probe")
+		test.Skip("Directory permissions are not enforced for this user.")
+	}
+
+	// test
+
+	err = RemoveFileCommand.Exec(store, Options{Option{"--recursive", "-r", "", false, ""}}, []string{"/tmp/tmsu/rm/dir"})
+
+	// validate
+
+	if err == nil {
+		test.Fatal("Expected removal of the locked file to fail.")
+	}
+
+	if _, err := os.Stat("/tmp/tmsu/rm/dir/a"); !os.IsNotExist(err) {
+		test.Fatal("Removable file was not removed.")
+	}
+	if _, err := os.Stat("/tmp/tmsu/rm/dir/locked/b"); err != nil {
+		test.Fatal(err)
+	}
+
+	files, err := store.Files()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Path() != "/tmp/tmsu/rm/dir/locked/b" {
+		test.Fatalf("Expected only the locked file to remain in the database but were %v.", files)
+	}
+
+	errFile.Seek(0, 0)
+	bytes, err := ioutil.ReadAll(errFile)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if !strings.Contains(string(bytes), "/tmp/tmsu/rm/dir/locked/b: could not remove") {
+		test.Fatalf("Expected the locked file to be reported but error output was '%v'.", string(bytes))
+	}
+}
+
+func TestRmIsAliasOfDelete(test *testing.T) {
+	// test
+
+	command := findCommand(commands, "rm")
+
+	// validate
+
+	if command != &DeleteCommand {
+		test.Fatalf("Expected 'rm' to be the 'delete' subcommand but was '%v'.", command.Name)
+	}
+}
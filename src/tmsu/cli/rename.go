@@ -53,7 +53,7 @@ func renameExec(store *storage.Storage, options Options, args []string) error {
 		return fmt.Errorf("could not retrieve tag '%v': %v", sourceTagName, err)
 	}
 	if sourceTag == nil {
-		return fmt.Errorf("no such tag '%v'", sourceTagName)
+		return noSuchTagError{sourceTagName}
 	}
 
 	destTag, err := store.TagByName(destTagName)
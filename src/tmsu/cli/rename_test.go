@@ -169,3 +169,54 @@ func TestRenameDestTagAlreadyExists(test *testing.T) {
 		test.Fatal("Existing dest tag not identified.")
 	}
 }
+
+func TestRenameWithinTransaction(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	sourceTag, err := store.AddTag("source")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+	defer store.Commit()
+
+	if _, err := store.TagByName("source"); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := RenameCommand.Exec(store, Options{}, []string{"source", "dest"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	originalTag, err := store.TagByName("source")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if originalTag != nil {
+		test.Fatal("Tag with original name is still returned.")
+	}
+
+	destTag, err := store.TagByName("dest")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if destTag == nil || destTag.Id != sourceTag.Id {
+		test.Fatal("Renamed tag is not returned by its new name.")
+	}
+}
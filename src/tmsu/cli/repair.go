@@ -21,8 +21,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
+	"tmsu/common/filesystem"
 	"tmsu/common/fingerprint"
 	"tmsu/common/log"
 	_path "tmsu/common/path"
@@ -38,23 +40,33 @@ var RepairCommand = Command{
 		"tmsu repair [OPTION]... repair --manual OLD NEW"},
 	Description: `Fixes broken paths and stale fingerprints in the database caused by file modifications and moves.
 
-Modified files are identified by a change to the file's modification time or file size. These files are repaired by updating the details in the database.
+Modified files are identified by a change to the file's modification time or file size. These files are repaired by updating the details in the database, including the tags the 'modTimeTags' setting derives from the modification time.
 
-An attempt is made to find missing files under PATHs specified. If a file with the same fingerprint is found then the database is updated with the new file's details. If no PATHs are specified, or no match can be found, then the file is instead reported as missing.
+An attempt is made to find missing files under PATHs specified. If a file with the same fingerprint is found then the database is updated with the new file's details. If no PATHs are specified, or no match can be found, then the file is instead reported as missing. Missing files are removed from the database when --remove is specified: if the 'retainDeletedFiles' setting is enabled then a record of each is kept and can be queried with 'files --missing'.
+
+When searching for moved files, the fingerprint under the first of the algorithms named by the 'extraFingerprintAlgorithms' setting is compared before the primary fingerprint, so a fast algorithm such as 'CRC64' is best listed first.
 
 Files that have been both moved and modified cannot be repaired and must be manually relocated.
 
-When run with the --manual option, any paths that begin with OLD are updated to begin with NEW. Any affected files' fingerprints are updated providing the file exists at the new location. No further repairs are attempted in this mode.`,
+The files are repaired in batches, in path order, and the changes are saved after each. Should a repair be interrupted, the next repair of the same --path resumes after the last file of the last complete batch: specify --restart to start from the beginning instead. When --since is specified only files modified within the DURATION (e.g. '36h', '7d' or '2w') are examined for modification: missing files are still reported.
+
+When run with the --manual option, any paths that begin with OLD are updated to begin with NEW. Any affected files' fingerprints are updated providing the file exists at the new location. No further repairs are attempted in this mode.
+
+Any executable 'pre-repair' and 'post-repair' hooks are run before and after the repair. (See 'tmsu help tag'.)`,
 	Examples: []string{"$ tmsu repair",
 		"$ tmsu repair /new/path  # look for missing files here",
 		"$ tmsu repair --path=/home/sally  # repair subset of database",
+		"$ tmsu repair --path=/archive --since=7d  # files modified this week",
 		"$ tmsu repair --manual /home/bob /home/fred  # manually repair paths"},
 	Options: Options{{"--path", "-p", "limit repair to files in database under path", true, ""},
 		{"--pretend", "-P", "do not make any changes", false, ""},
 		{"--remove", "-R", "remove missing files from the database", false, ""},
 		{"--manual", "-m", "manually relocate files", false, ""},
 		{"--unmodified", "-u", "recalculate fingerprints for unmodified files", false, ""},
-		{"--rationalize", "", "remove explicit taggings where an implicit tagging exists", false, ""}},
+		{"--rationalize", "", "remove explicit taggings where an implicit tagging exists", false, ""},
+		{"--since", "-s", "examine only files modified within DURATION", true, ""},
+		{"--restart", "", "ignore the checkpoint of an interrupted repair", false, ""},
+		{"--symlinks", "", "treat symbolic links per POLICY: follow, target or link", true, ""}},
 	Exec: repairExec,
 }
 
@@ -67,7 +79,9 @@ func repairExec(store *storage.Storage, options Options, args []string) error {
 		fromPath := args[0]
 		toPath := args[1]
 
-		if err := manualRepair(store, fromPath, toPath, pretend); err != nil {
+		if err := withHooks(store, "repair", args[0:2], nil, func() error {
+			return manualRepair(store, fromPath, toPath, pretend)
+		}); err != nil {
 			return err
 		}
 	} else {
@@ -76,12 +90,36 @@ func repairExec(store *storage.Storage, options Options, args []string) error {
 		recalcUnmodified := options.HasOption("--unmodified")
 		rationalize := options.HasOption("--rationalize")
 
-		limitPath := string(filepath.Separator) //TODO Windows
+		workingDirectory, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("could not identify working directory: %v", err)
+		}
+
+		limitPath := _path.Root(workingDirectory)
 		if options.HasOption("--path") {
 			limitPath = options.Get("--path").Argument
 		}
 
-		if err := fullRepair(store, searchPaths, limitPath, removeMissing, recalcUnmodified, rationalize, pretend); err != nil {
+		var since time.Time
+		if options.HasOption("--since") {
+			duration, err := parseDuration(options.Get("--since").Argument)
+			if err != nil {
+				return usageError{err}
+			}
+
+			since = time.Now().Add(-duration)
+		}
+
+		restart := options.HasOption("--restart")
+
+		policy, err := symlinkPolicy(store, options)
+		if err != nil {
+			return err
+		}
+
+		if err := withHooks(store, "repair", searchPaths, nil, func() error {
+			return fullRepair(store, searchPaths, limitPath, since, policy, removeMissing, recalcUnmodified, rationalize, restart, pretend)
+		}); err != nil {
 			return err
 		}
 	}
@@ -121,6 +159,7 @@ func manualRepair(store *storage.Storage, fromPath, toPath string, pretend bool)
 	if err != nil {
 		return fmt.Errorf("could not retrieve files from storage: %v", err)
 	}
+	dbFiles = localFiles(dbFiles)
 
 	for _, dbFile = range dbFiles {
 		relFileFromPath := _path.Rel(dbFile.Path())
@@ -170,7 +209,10 @@ func manualRepairFile(store *storage.Storage, file *entities.File, toPath string
 	return err
 }
 
-func fullRepair(store *storage.Storage, searchPaths []string, limitPath string, removeMissing, recalcUnmodified, rationalize, pretend bool) error {
+// The number of files repaired between checkpoints.
+const repairBatchSize = 1000
+
+func fullRepair(store *storage.Storage, searchPaths []string, limitPath string, since time.Time, policy filesystem.SymlinkPolicy, removeMissing, recalcUnmodified, rationalize, restart, pretend bool) error {
 	absLimitPath, err := filepath.Abs(limitPath)
 	if err != nil {
 		return fmt.Errorf("%v: could not determine absolute path", err)
@@ -187,6 +229,7 @@ func fullRepair(store *storage.Storage, searchPaths []string, limitPath string,
 	if err != nil {
 		return fmt.Errorf("could not retrieve files from storage: %v", err)
 	}
+	dbFiles = localFiles(dbFiles)
 
 	dbFile, err := store.FileByPath(absLimitPath)
 	if err != nil {
@@ -199,36 +242,87 @@ func fullRepair(store *storage.Storage, searchPaths []string, limitPath string,
 
 	log.Infof(2, "retrieved %v files from the database", len(dbFiles))
 
-	unmodfied, modified, missing := determineStatuses(dbFiles)
+	sort.Slice(dbFiles, func(i, j int) bool { return dbFiles[i].Path() < dbFiles[j].Path() })
 
-	if recalcUnmodified {
-		if err = repairUnmodified(store, unmodfied, pretend, fingerprintAlgorithm); err != nil {
+	checkpoint := ""
+	if !restart {
+		checkpoint, err = store.RepairCheckpoint(absLimitPath)
+		if err != nil {
+			return fmt.Errorf("could not retrieve repair checkpoint: %v", err)
+		}
+	}
+
+	if checkpoint != "" {
+		log.Infof(1, "resuming repair of '%v' after '%v' (use --restart to start again).", absLimitPath, checkpoint)
+
+		index := sort.Search(len(dbFiles), func(i int) bool { return dbFiles[i].Path() > checkpoint })
+		dbFiles = dbFiles[index:]
+	}
+
+	candidates := &moveCandidates{searchPaths: searchPaths, policy: policy}
+
+	for start := 0; start < len(dbFiles); start += repairBatchSize {
+		end := start + repairBatchSize
+		if end > len(dbFiles) {
+			end = len(dbFiles)
+		}
+		batch := dbFiles[start:end]
+
+		if err := repairBatch(store, batch, candidates, since, removeMissing, recalcUnmodified, rationalize, pretend, fingerprintAlgorithm); err != nil {
 			return err
 		}
+
+		if !pretend && end < len(dbFiles) {
+			if err := store.UpdateRepairCheckpoint(absLimitPath, batch[len(batch)-1].Path()); err != nil {
+				return fmt.Errorf("could not record repair checkpoint: %v", err)
+			}
+
+			if err := store.Checkpoint(); err != nil {
+				return fmt.Errorf("could not save changes: %v", err)
+			}
+		}
 	}
 
-	if err = repairModified(store, modified, pretend, fingerprintAlgorithm); err != nil {
+	if err = deleteUnusedValues(store); err != nil {
 		return err
 	}
 
-	if err = repairMoved(store, missing, searchPaths, pretend, fingerprintAlgorithm); err != nil {
+	if !pretend {
+		if err := store.DeleteRepairCheckpoint(absLimitPath); err != nil {
+			return fmt.Errorf("could not remove repair checkpoint: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func repairBatch(store *storage.Storage, dbFiles entities.Files, candidates *moveCandidates, since time.Time, removeMissing, recalcUnmodified, rationalize, pretend bool, fingerprintAlgorithm string) error {
+	unmodfied, modified, missing := determineStatuses(dbFiles, since, candidates.policy)
+
+	if recalcUnmodified {
+		if err := repairUnmodified(store, unmodfied, pretend, fingerprintAlgorithm, candidates.policy); err != nil {
+			return err
+		}
+	}
+
+	if err := repairModified(store, modified, pretend, fingerprintAlgorithm, candidates.policy); err != nil {
 		return err
 	}
 
-	if err = repairMissing(store, missing, pretend, removeMissing); err != nil {
+	if err := repairMoved(store, missing, candidates, pretend, fingerprintAlgorithm); err != nil {
 		return err
 	}
 
-	if err = deleteUntaggedFiles(store, dbFiles); err != nil {
+	if err := repairMissing(store, missing, pretend, removeMissing); err != nil {
 		return err
 	}
 
-	if err = deleteUnusedValues(store); err != nil {
+	if err := deleteUntaggedFiles(store, dbFiles); err != nil {
 		return err
 	}
 
 	if rationalize {
-		if err = rationalizeFileTags(store, dbFiles); err != nil {
+		if err := rationalizeFileTags(store, dbFiles); err != nil {
 			return err
 		}
 	}
@@ -277,6 +371,11 @@ func rationalizeFileTags(store *storage.Storage, files entities.Files) error {
 				log.Infof(2, "%v: removing explicit tagging %v as implicit tagging exists", file.Path(), fileTag.TagId)
 
 				if err := store.DeleteFileTag(fileTag.FileId, fileTag.TagId, fileTag.ValueId); err != nil {
+					if _, ok := err.(storage.TagLockedError); ok {
+						log.Warnf("%v: not removing explicit tagging %v as the tag is locked", file.Path(), fileTag.TagId)
+						continue
+					}
+
 					return fmt.Errorf("could not delete file tag for file %v, tag %v and value %v", fileTag.FileId, fileTag.TagId, fileTag.ValueId)
 				}
 			}
@@ -286,7 +385,7 @@ func rationalizeFileTags(store *storage.Storage, files entities.Files) error {
 	return nil
 }
 
-func determineStatuses(dbFiles entities.Files) (unmodified, modified, missing entities.Files) {
+func determineStatuses(dbFiles entities.Files, since time.Time, policy filesystem.SymlinkPolicy) (unmodified, modified, missing entities.Files) {
 	log.Infof(2, "determining file statuses")
 
 	unmodified = make(entities.Files, 0, 10)
@@ -294,7 +393,7 @@ func determineStatuses(dbFiles entities.Files) (unmodified, modified, missing en
 	missing = make(entities.Files, 0, 10)
 
 	for _, dbFile := range dbFiles {
-		stat, err := os.Stat(dbFile.Path())
+		stat, err := policy.Stat(dbFile.Path())
 		if err != nil {
 			switch {
 			case os.IsPermission(err):
@@ -307,6 +406,11 @@ func determineStatuses(dbFiles entities.Files) (unmodified, modified, missing en
 			}
 		}
 
+		if !since.IsZero() && stat.ModTime().Before(since) {
+			log.Infof(2, "%v: not modified since %v", dbFile.Path(), since)
+			continue
+		}
+
 		if dbFile.ModTime.Equal(stat.ModTime().UTC()) && dbFile.Size == stat.Size() {
 			log.Infof(2, "%v: unmodified", dbFile.Path())
 			unmodified = append(unmodified, dbFile)
@@ -319,26 +423,34 @@ func determineStatuses(dbFiles entities.Files) (unmodified, modified, missing en
 	return
 }
 
-func repairUnmodified(store *storage.Storage, unmodified entities.Files, pretend bool, fingerprintAlgorithm string) error {
+func repairUnmodified(store *storage.Storage, unmodified entities.Files, pretend bool, fingerprintAlgorithm string, policy filesystem.SymlinkPolicy) error {
 	log.Infof(2, "recalculating fingerprints for unmodified files")
 
 	for _, dbFile := range unmodified {
-		stat, err := os.Stat(dbFile.Path())
+		stat, err := policy.Stat(dbFile.Path())
 		if err != nil {
 			return err
 		}
 
-		fingerprint, err := fingerprint.Create(dbFile.Path(), fingerprintAlgorithm)
+		fingerprint, err := createFingerprint(dbFile.Path(), fingerprintAlgorithm, policy)
 		if err != nil {
 			log.Warnf("%v: could not create fingerprint: %v", dbFile.Path(), err)
 			continue
 		}
 
 		if !pretend {
-			_, err := store.UpdateFile(dbFile.Id, dbFile.Path(), fingerprint, stat.ModTime(), stat.Size(), stat.IsDir())
+			file, err := store.UpdateFile(dbFile.Id, dbFile.Path(), fingerprint, stat.ModTime(), stat.Size(), stat.IsDir())
 			if err != nil {
 				return fmt.Errorf("%v: could not update file in database: %v", dbFile.Path(), err)
 			}
+
+			if err := recordInode(store, file.Id, stat); err != nil {
+				return fmt.Errorf("%v: %v", dbFile.Path(), err)
+			}
+
+			if err := store.UpdateExtraFingerprints(file); err != nil {
+				return err
+			}
 		}
 
 		fmt.Printf("%v: recalculated fingerprint\n", dbFile.Path())
@@ -347,27 +459,35 @@ func repairUnmodified(store *storage.Storage, unmodified entities.Files, pretend
 	return nil
 }
 
-func repairModified(store *storage.Storage, modified entities.Files, pretend bool, fingerprintAlgorithm string) error {
+func repairModified(store *storage.Storage, modified entities.Files, pretend bool, fingerprintAlgorithm string, policy filesystem.SymlinkPolicy) error {
 	log.Infof(2, "repairing modified files")
 
 	for _, dbFile := range modified {
-		stat, err := os.Stat(dbFile.Path())
+		stat, err := policy.Stat(dbFile.Path())
 		if err != nil {
 			return err
 		}
 
-		fingerprint, err := fingerprint.Create(dbFile.Path(), fingerprintAlgorithm)
+		fingerprint, err := createFingerprint(dbFile.Path(), fingerprintAlgorithm, policy)
 		if err != nil {
 			log.Warnf("%v: could not create fingerprint: %v", dbFile.Path(), err)
 			continue
 		}
 
 		if !pretend {
-			_, err := store.UpdateFile(dbFile.Id, dbFile.Path(), fingerprint, stat.ModTime(), stat.Size(), stat.IsDir())
+			file, err := store.UpdateFile(dbFile.Id, dbFile.Path(), fingerprint, stat.ModTime(), stat.Size(), stat.IsDir())
 			if err != nil {
 				return fmt.Errorf("%v: could not update file in database: %v", dbFile.Path(), err)
 			}
 
+			if err := recordInode(store, file.Id, stat); err != nil {
+				return fmt.Errorf("%v: %v", dbFile.Path(), err)
+			}
+
+			if err := store.UpdateExtraFingerprints(file); err != nil {
+				return err
+			}
+
 		}
 
 		fmt.Printf("%v: updated fingerprint\n", dbFile.Path())
@@ -376,15 +496,15 @@ func repairModified(store *storage.Storage, modified entities.Files, pretend boo
 	return nil
 }
 
-func repairMoved(store *storage.Storage, missing entities.Files, searchPaths []string, pretend bool, fingerprintAlgorithm string) error {
+func repairMoved(store *storage.Storage, missing entities.Files, candidates *moveCandidates, pretend bool, fingerprintAlgorithm string) error {
 	log.Infof(2, "repairing moved files")
 
-	if len(missing) == 0 || len(searchPaths) == 0 {
+	if len(missing) == 0 || len(candidates.searchPaths) == 0 {
 		// don't bother enumerating filesystem if nothing to do
 		return nil
 	}
 
-	pathsBySize, err := buildPathBySizeMap(searchPaths)
+	extraAlgorithms, err := store.ExtraFingerprintAlgorithms()
 	if err != nil {
 		return err
 	}
@@ -392,7 +512,15 @@ func repairMoved(store *storage.Storage, missing entities.Files, searchPaths []s
 	for index, dbFile := range missing {
 		log.Infof(2, "%v: searching for new location", dbFile.Path())
 
-		pathsOfSize := pathsBySize[dbFile.Size]
+		quickAlgorithm, quickFingerprint, err := quickFingerprintOf(store, dbFile, extraAlgorithms)
+		if err != nil {
+			return err
+		}
+
+		pathsOfSize, err := candidates.ofSize(dbFile.Size)
+		if err != nil {
+			return err
+		}
 		log.Infof(2, "%v: file is of size %v, identified %v files of this size", dbFile.Path(), dbFile.Size, len(pathsOfSize))
 
 		for _, candidatePath := range pathsOfSize {
@@ -405,12 +533,23 @@ func repairMoved(store *storage.Storage, missing entities.Files, searchPaths []s
 				continue
 			}
 
-			stat, err := os.Stat(candidatePath)
+			stat, err := candidates.policy.Stat(candidatePath)
 			if err != nil {
 				return fmt.Errorf("%v: could not stat file: %v", candidatePath, err)
 			}
 
-			fingerprint, err := fingerprint.Create(candidatePath, fingerprintAlgorithm)
+			if quickAlgorithm != "" {
+				candidateFingerprint, err := fingerprint.Create(candidatePath, quickAlgorithm)
+				if err != nil {
+					return fmt.Errorf("%v: could not create fingerprint: %v", candidatePath, err)
+				}
+
+				if candidateFingerprint != quickFingerprint {
+					continue
+				}
+			}
+
+			fingerprint, err := createFingerprint(candidatePath, fingerprintAlgorithm, candidates.policy)
 			if err != nil {
 				return fmt.Errorf("%v: could not create fingerprint: %v", candidatePath, err)
 			}
@@ -421,6 +560,10 @@ func repairMoved(store *storage.Storage, missing entities.Files, searchPaths []s
 					if err != nil {
 						return fmt.Errorf("%v: could not update file in database: %v", dbFile.Path(), err)
 					}
+
+					if err := recordInode(store, dbFile.Id, stat); err != nil {
+						return fmt.Errorf("%v: %v", candidatePath, err)
+					}
 				}
 
 				fmt.Printf("%v: updated path to %v\n", dbFile.Path(), candidatePath)
@@ -435,6 +578,28 @@ func repairMoved(store *storage.Storage, missing entities.Files, searchPaths []s
 	return nil
 }
 
+// Retrieves the file's fingerprint under the first of the additional
+// algorithms for which one is recorded. As this is cheaper to calculate it is
+// used to rule out candidates before the primary fingerprint is compared.
+func quickFingerprintOf(store *storage.Storage, file *entities.File, algorithms []string) (string, fingerprint.Fingerprint, error) {
+	if len(algorithms) == 0 {
+		return "", fingerprint.EMPTY, nil
+	}
+
+	fingerprints, err := store.FileFingerprints(file.Id)
+	if err != nil {
+		return "", fingerprint.EMPTY, fmt.Errorf("%v: could not retrieve fingerprints: %v", file.Path(), err)
+	}
+
+	for _, algorithm := range algorithms {
+		if fp, ok := fingerprints[algorithm]; ok {
+			return algorithm, fp, nil
+		}
+	}
+
+	return "", fingerprint.EMPTY, nil
+}
+
 func repairMissing(store *storage.Storage, missing entities.Files, pretend, force bool) error {
 	for _, dbFile := range missing {
 		if dbFile == nil {
@@ -443,6 +608,10 @@ func repairMissing(store *storage.Storage, missing entities.Files, pretend, forc
 
 		if force {
 			if !pretend {
+				if err := store.RetainDeletedFile(dbFile.Id); err != nil {
+					return fmt.Errorf("%v: could not record deleted file: %v", dbFile.Path(), err)
+				}
+
 				if err := store.DeleteFileTagsByFileId(dbFile.Id); err != nil {
 					return fmt.Errorf("%v: could not delete file-tags: %v", dbFile.Path(), err)
 				}
@@ -457,13 +626,35 @@ func repairMissing(store *storage.Storage, missing entities.Files, pretend, forc
 	return nil
 }
 
-func buildPathBySizeMap(paths []string) (map[int64][]string, error) {
+// The files under the search paths by size. The file-system is only enumerated
+// once there is a missing file to look for and then only the once.
+type moveCandidates struct {
+	searchPaths []string
+	policy      filesystem.SymlinkPolicy
+	pathsBySize map[int64][]string
+}
+
+func (candidates *moveCandidates) ofSize(size int64) ([]string, error) {
+	if candidates.pathsBySize == nil {
+		pathsBySize, err := buildPathBySizeMap(candidates.searchPaths, candidates.policy)
+		if err != nil {
+			return nil, err
+		}
+
+		candidates.pathsBySize = pathsBySize
+	}
+
+	return candidates.pathsBySize[size], nil
+}
+
+func buildPathBySizeMap(paths []string, policy filesystem.SymlinkPolicy) (map[int64][]string, error) {
 	log.Infof(2, "building map of paths by size")
 
 	pathsBySize := make(map[int64][]string, 10)
+	trail := filesystem.NewDirectoryTrail(policy)
 
 	for _, path := range paths {
-		if err := buildPathBySizeMapRecursive(path, pathsBySize); err != nil {
+		if err := buildPathBySizeMapRecursive(trail, path, pathsBySize); err != nil {
 			return nil, err
 		}
 	}
@@ -473,17 +664,18 @@ func buildPathBySizeMap(paths []string) (map[int64][]string, error) {
 	return pathsBySize, nil
 }
 
-func buildPathBySizeMapRecursive(path string, pathBySizeMap map[int64][]string) error {
+func buildPathBySizeMapRecursive(trail *filesystem.DirectoryTrail, path string, pathBySizeMap map[int64][]string) error {
 	absPath, err := filepath.Abs(path)
 	if err != nil {
 		return fmt.Errorf("%v: could not get absolute path", path)
 	}
 
-	stat, err := os.Stat(absPath)
+	stat, err := trail.Policy.Stat(absPath)
 	if err != nil {
 		switch {
 		case os.IsPermission(err):
 			log.Warnf("%v: permission denied", path)
+			return nil
 		default:
 			return err
 		}
@@ -492,22 +684,29 @@ func buildPathBySizeMapRecursive(path string, pathBySizeMap map[int64][]string)
 	if stat.IsDir() {
 		log.Infof(3, "%v: examining directory contents", absPath)
 
-		dir, err := os.Open(absPath)
-		if err != nil {
-			return fmt.Errorf("%v: could not open directory: %v", path, err)
-		}
+		err := trail.Descend(absPath, func() error {
+			dir, err := os.Open(absPath)
+			if err != nil {
+				return fmt.Errorf("%v: could not open directory: %v", path, err)
+			}
 
-		names, err := dir.Readdirnames(0)
-		dir.Close()
-		if err != nil {
-			return fmt.Errorf("%v: could not read directory entries: %v", path, err)
-		}
+			names, err := dir.Readdirnames(0)
+			dir.Close()
+			if err != nil {
+				return fmt.Errorf("%v: could not read directory entries: %v", path, err)
+			}
 
-		for _, name := range names {
-			childPath := filepath.Join(path, name)
-			if err := buildPathBySizeMapRecursive(childPath, pathBySizeMap); err != nil {
-				return err
+			for _, name := range names {
+				childPath := filepath.Join(path, name)
+				if err := buildPathBySizeMapRecursive(trail, childPath, pathBySizeMap); err != nil {
+					return err
+				}
 			}
+
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 	} else {
 		log.Infof(3, "%v: file is of size %v", absPath, stat.Size())
@@ -21,6 +21,7 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 	"tmsu/storage"
 )
 
@@ -161,3 +162,224 @@ func TestReportsMissingFiles(test *testing.T) {
 	bytes, err := ioutil.ReadAll(outFile)
 	compareOutput(test, "/tmp/tmsu/a: missing\n", string(bytes))
 }
+
+func TestRepairResumesFromCheckpoint(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, path := range []string{"/tmp/tmsu/a", "/tmp/tmsu/b"} {
+		if err := createFile(path, "hello"); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+
+		if err := TagCommand.Exec(store, Options{}, []string{path, "a"}); err != nil {
+			test.Fatal(err)
+		}
+
+		if err := createFile(path, "banana"); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	if err := store.UpdateRepairCheckpoint("/tmp/tmsu", "/tmp/tmsu/a"); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := RepairCommand.Exec(store, Options{Option{"--path", "-p", "", true, "/tmp/tmsu"}}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "tmsu: resuming repair of '/tmp/tmsu' after '/tmp/tmsu/a' (use --restart to start again).\n/tmp/tmsu/b: updated fingerprint\n", string(bytes))
+
+	checkpoint, err := store.RepairCheckpoint("/tmp/tmsu")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if checkpoint != "" {
+		test.Fatalf("Expected checkpoint to be removed but is '%v'.", checkpoint)
+	}
+}
+
+func TestRepairSince(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, path := range []string{"/tmp/tmsu/a", "/tmp/tmsu/b"} {
+		if err := createFile(path, "hello"); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+
+		if err := TagCommand.Exec(store, Options{}, []string{path, "a"}); err != nil {
+			test.Fatal(err)
+		}
+
+		if err := createFile(path, "banana"); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	lastMonth := time.Now().AddDate(0, -1, 0)
+	if err := os.Chtimes("/tmp/tmsu/a", lastMonth, lastMonth); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := RepairCommand.Exec(store, Options{Option{"--path", "-p", "", true, "/tmp/tmsu"}, Option{"--since", "-s", "", true, "7d"}}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/tmsu/b: updated fingerprint\n", string(bytes))
+}
+
+func TestRepairMovedFileWithQuickFingerprint(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.UpdateSetting("extraFingerprintAlgorithms", "CRC64"); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := createFile("/tmp/tmsu/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := os.Rename("/tmp/tmsu/a", "/tmp/tmsu/c"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/c")
+
+	// same size but different contents
+	if err := createFile("/tmp/tmsu/b", "jello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/b")
+
+	// test
+
+	if err := RepairCommand.Exec(store, Options{}, []string{"/tmp/tmsu"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	files, err := store.Files()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if len(files) != 1 {
+		test.Fatalf("Expected one file but are %v", len(files))
+	}
+
+	if files[0].Path() != "/tmp/tmsu/c" {
+		test.Fatalf("File move was not repaired: path is %v.", files[0].Path())
+	}
+
+	fingerprints, err := store.FileFingerprints(files[0].Id)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if _, ok := fingerprints["CRC64"]; !ok {
+		test.Fatal("Expected the CRC64 fingerprint to be retained")
+	}
+}
+
+func TestRepairIgnoresUrls(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := TagCommand.Exec(store, Options{}, []string{"s3://bucket/a.jpg", "photo"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := RepairCommand.Exec(store, Options{Option{"--remove", "-R", "", false, ""}}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "", string(bytes))
+
+	file, err := store.FileByPath("s3://bucket/a.jpg")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if file == nil {
+		test.Fatalf("s3://bucket/a.jpg: file was removed")
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"strings"
+	"text/template"
+	"time"
+	"tmsu/common/path"
+	"tmsu/entities"
+	"tmsu/storage"
+)
+
+// The details of a file to which 'files --format' templates may refer. The
+// JSON names allow the same model to be used for encoded output.
+type fileReport struct {
+	Path        string    `json:"path"`
+	AbsPath     string    `json:"absPath"`
+	Id          uint      `json:"id"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"modTime"`
+	Fingerprint string    `json:"fingerprint"`
+	IsDir       bool      `json:"isDir"`
+	Tags        []string  `json:"tags"`
+}
+
+// Builds the report for the file, with its tags as 'TAG' or 'TAG=VALUE'.
+func newFileReport(store *storage.Storage, file *entities.File, explicitOnly bool) (*fileReport, error) {
+	report := fileReport{Path: path.Rel(file.Path()),
+		AbsPath:     file.Path(),
+		Id:          uint(file.Id),
+		Size:        file.Size,
+		ModTime:     file.ModTime,
+		Fingerprint: string(file.Fingerprint),
+		IsDir:       file.IsDir,
+		Tags:        []string{}}
+
+	// the file may have come from an ancestor database
+	storeFile, err := store.FileByPath(file.Path())
+	if err != nil {
+		return nil, err
+	}
+	if storeFile != nil {
+		report.Tags, err = tagNamesForFile(store, storeFile.Id, explicitOnly, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &report, nil
+}
+
+// Parses a --format template, in which '\t', '\n' and '\\' stand for a tab,
+// a newline and a backslash respectively.
+func parseFormat(format string) (*template.Template, error) {
+	format = strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n").Replace(format)
+
+	return template.New("format").Funcs(formatFunctions).Parse(format)
+}
+
+var formatFunctions = template.FuncMap{
+	"join": func(items []string, separator string) string {
+		return strings.Join(items, separator)
+	},
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"tmsu/storage"
+)
+
+var RestoreCommand = Command{
+	Name:     "restore",
+	Synopsis: "Roll the database back to a snapshot",
+	Usages:   []string{"tmsu restore [PATH]"},
+	Description: `Replaces the contents of the database with the snapshot at PATH. If PATH is not specified then the newest backup taken by the 'backup' subcommand, DB.bak.1, is used.
+
+All changes made since the snapshot was taken are lost: consider taking a further backup first. Where commands are read from standard input, the changes made by the earlier commands are committed before the snapshot is restored.`,
+	Examples: []string{"$ tmsu restore",
+		"$ tmsu restore ~/.tmsu/default.db.bak.3"},
+	Options: Options{},
+	Exec:    restoreExec,
+}
+
+func restoreExec(store *storage.Storage, options Options, args []string) error {
+	var path string
+	switch len(args) {
+	case 0:
+		path = backupPath(store.Db.Path, 1)
+	case 1:
+		path = args[0]
+	default:
+		return fmt.Errorf("too many arguments")
+	}
+
+	// the changes made so far, e.g. by earlier commands read from standard
+	// input, are kept should the restore fail
+	if err := store.Commit(); err != nil {
+		return err
+	}
+
+	restoreErr := store.Restore(path)
+
+	if err := store.Begin(); err != nil {
+		return err
+	}
+
+	if restoreErr != nil {
+		return fmt.Errorf("could not restore database from '%v': %v", path, restoreErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"tmsu/common/log"
+	_path "tmsu/common/path"
+	"tmsu/storage"
+)
+
+var SearchCommand = Command{
+	Name:     "search",
+	Synopsis: "Search for files by approximate tag or value name",
+	Usages:   []string{"tmsu search [OPTION]... TEXT..."},
+	Description: `Lists the files with tag or value names matching the TEXT specified, most relevant first.
+
+Each word of TEXT is matched against the start of the words in the tag and value names applied to each file so that files can be found without remembering the exact tag names. Files matching more of the words, or matching on the tag name rather than the value, are listed first.`,
+	Examples: []string{"$ tmsu search holi\nholiday.jpg\nbeach.jpg",
+		"$ tmsu search --scores sea 2015\n1.333  sea.jpg\n0.500  holiday.jpg"},
+	Options: Options{{"--count", "-c", "list the number of files rather than their names", false, ""},
+		{"--scores", "-s", "show the relevance score of each file", false, ""}},
+	Exec: searchExec,
+}
+
+func searchExec(store *storage.Storage, options Options, args []string) error {
+	showCount := options.HasOption("--count")
+	showScores := options.HasOption("--scores")
+
+	if len(args) == 0 {
+		return fmt.Errorf("search text must be specified")
+	}
+
+	text := strings.Join(args, " ")
+
+	log.Infof(2, "searching for '%v'", text)
+
+	results, err := store.SearchFiles(text)
+	if err != nil {
+		return fmt.Errorf("could not search files: %v", err)
+	}
+
+	if showCount {
+		fmt.Println(len(results))
+		return nil
+	}
+
+	for _, result := range results {
+		relPath := _path.Rel(result.File.Path())
+
+		if showScores {
+			fmt.Printf("%.3f  %v\n", result.Score, relPath)
+		} else {
+			fmt.Println(relPath)
+		}
+	}
+
+	return nil
+}
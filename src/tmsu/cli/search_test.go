@@ -0,0 +1,160 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+	"tmsu/common/fingerprint"
+	"tmsu/storage"
+)
+
+func TestSearchRanksTagMatchesFirst(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	fileB, err := store.AddFile("/tmp/b", fingerprint.Fingerprint("def"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	fileC, err := store.AddFile("/tmp/c", fingerprint.Fingerprint("ghi"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	holiday, err := store.AddTag("holiday")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	place, err := store.AddTag("place")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	music, err := store.AddTag("music")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	holidayInn, err := store.AddValue("holiday-inn")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileA.Id, place.Id, holidayInn.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileB.Id, holiday.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileC.Id, music.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := SearchCommand.Exec(store, Options{}, []string{"holi"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/b\n/tmp/a\n", string(bytes))
+}
+
+func TestSearchFollowsTagRename(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	file, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tag, err := store.AddTag("beach")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(file.Id, tag.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.RenameTag(tag.Id, "seaside"); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := SearchCommand.Exec(store, Options{Option{"--count", "-c", "", false, ""}}, []string{"beach"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := SearchCommand.Exec(store, Options{Option{"--count", "-c", "", false, ""}}, []string{"sea"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "0\n1\n", string(bytes))
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"tmsu/common/log"
+	"tmsu/storage"
+	"tmsu/vfs"
+)
+
+const defaultWebDavAddress = "localhost:8080"
+const defaultNinePAddress = "localhost:5640"
+
+var ServeCommand = Command{
+	Name:     "serve",
+	Synopsis: "Serve the virtual filesystem over the network",
+	Usages:   []string{"tmsu serve [OPTION]..."},
+	Description: `Serves the same tag and query directory structure as the virtual filesystem over a network protocol, so that machines without FUSE can browse the tagged files.
+
+With --webdav the virtual filesystem is served, read-only, over WebDAV. The server listens on ` + defaultWebDavAddress + ` by default.
+
+With --9p the virtual filesystem is served, read-only, over the 9P2000 protocol so that it can be mounted by Plan 9, WSL2 or Linux's v9fs. The server listens on ` + defaultNinePAddress + ` by default.
+
+In both cases the files appear as regular files rather than symbolic links. An alternative address can be specified with --address.
+
+No authentication or encryption is performed: anyone able to connect can list the tags and read every tagged file. For this reason the servers listen only on the loopback interface by default. Only specify an address on another interface for a trusted network, or place the server behind a proxy that authenticates its clients. A warning is shown when listening on any other interface.
+
+With --feed an Atom feed of the files tagged within the last seven days is served over HTTP at '` + feedPath + `', alongside the WebDAV server if --webdav is also specified, so that automation can react to newly tagged files. The files can be restricted to those matching a query with the 'query' parameter and the period changed with the 'since' parameter, e.g. '` + feedPath + `?query=film+and+not+watched&since=2d'.
+
+With --stdio the server communicates over its standard input and output rather than listening on an address. On its own, --stdio serves the commands sent by a remote TMSU for paths of the form 'ssh://[USER@]HOST[:PORT]/PATH', allowing files on another machine to be tagged and queried. (It is not normally necessary to run this manually: TMSU runs it over SSH.) Combined with --9p, the virtual filesystem is served over 9P instead.`,
+	Examples: []string{"$ tmsu serve --webdav",
+		"$ tmsu serve --webdav --address :8000",
+		"$ tmsu serve --webdav --feed",
+		"$ curl 'http://localhost:8080/feed?query=podcast&since=1d'",
+		"$ tmsu serve --9p",
+		"$ sudo mount -t 9p -o trans=tcp,port=5640,version=9p2000 127.0.0.1 /mnt/tags",
+		"$ tmsu tag ssh://nas/media/film.mkv drama",
+		"$ tmsu files --path=ssh://bob@nas/media drama"},
+	Options: Options{{"--webdav", "-w", "serve over WebDAV", false, ""},
+		{"--9p", "", "serve over 9P", false, ""},
+		{"--feed", "", "serve an Atom feed of recently tagged files", false, ""},
+		{"--address", "-a", "listen on ADDRESS", true, ""},
+		{"--stdio", "", "serve over standard input and output", false, ""}},
+	Exec:          serveExec,
+	NoTransaction: true,
+}
+
+func serveExec(store *storage.Storage, options Options, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	webDav := options.HasOption("--webdav")
+	ninePee := options.HasOption("--9p")
+	stdio := options.HasOption("--stdio")
+	feed := options.HasOption("--feed")
+
+	switch {
+	case webDav && ninePee:
+		return fmt.Errorf("only one protocol may be specified")
+	case webDav && stdio:
+		return fmt.Errorf("WebDAV cannot be served over standard input and output")
+	case feed && (ninePee || stdio):
+		return fmt.Errorf("the feed can only be served over HTTP")
+	case !webDav && !ninePee && !stdio && !feed:
+		return fmt.Errorf("a protocol must be specified, e.g. --webdav")
+	}
+
+	store.EnableQueryCache()
+
+	if stdio {
+		conn := stdioConn{os.Stdin, os.Stdout}
+
+		if ninePee {
+			if err := vfs.NewNinePServer(store).ServeConn(conn); err != nil && err != io.EOF {
+				return fmt.Errorf("could not serve 9P: %v", err)
+			}
+
+			return nil
+		}
+
+		return serveStdio(store, options.HasOption("--database"), conn, conn)
+	}
+
+	if webDav || feed {
+		return serveHttp(store, serveAddress(options, defaultWebDavAddress), webDav, feed)
+	}
+
+	return serveNineP(store, serveAddress(options, defaultNinePAddress))
+}
+
+// unexported
+
+func serveAddress(options Options, defaultAddress string) string {
+	if options.HasOption("--address") {
+		return options.Get("--address").Argument
+	}
+
+	return defaultAddress
+}
+
+func serveHttp(store *storage.Storage, address string, webDav, feed bool) error {
+	warnIfPublic(address)
+
+	mux := http.NewServeMux()
+
+	if webDav {
+		log.Infof(2, "serving WebDAV on %v", address)
+		mux.Handle("/", vfs.NewWebDavHandler(store))
+	}
+
+	if feed {
+		log.Infof(2, "serving feed on %v%v", address, feedPath)
+		mux.Handle(feedPath, newFeedHandler(store))
+	}
+
+	server := &http.Server{Addr: address, Handler: mux}
+
+	// stop serving when interrupted
+	go func() {
+		<-store.Context().Done()
+		server.Close()
+	}()
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("could not serve HTTP on '%v': %v", address, err)
+	}
+
+	return nil
+}
+
+type stdioConn struct {
+	io.Reader
+	io.Writer
+}
+
+func serveNineP(store *storage.Storage, address string) error {
+	warnIfPublic(address)
+
+	log.Infof(2, "serving 9P on %v", address)
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("could not listen on '%v': %v", address, err)
+	}
+	defer listener.Close()
+
+	// stop serving when interrupted
+	go func() {
+		<-store.Context().Done()
+		listener.Close()
+	}()
+
+	if err := vfs.NewNinePServer(store).Serve(listener); err != nil && store.Context().Err() == nil {
+		return fmt.Errorf("could not serve 9P on '%v': %v", address, err)
+	}
+
+	return nil
+}
+
+// Warns that the files are served without authentication if the address is
+// not on the loopback interface.
+func warnIfPublic(address string) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return
+	}
+
+	if host == "localhost" {
+		return
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return
+	}
+
+	log.Warnf("listening on '%v' without authentication: anyone able to connect can read the tagged files.", address)
+}
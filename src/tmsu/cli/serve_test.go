@@ -0,0 +1,423 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"tmsu/storage"
+	"tmsu/vfs"
+)
+
+func TestServeWebDav(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/a", "apple"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "fruit"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := store.Commit(); err != nil {
+		test.Fatal(err)
+	}
+
+	file, err := store.FileByPath("/tmp/tmsu/a")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	handler := vfs.NewWebDavHandler(store)
+
+	// test
+
+	request := httptest.NewRequest("PROPFIND", "/tags/fruit/", nil)
+	request.Header.Set("Depth", "1")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	// validate
+
+	if recorder.Code != 207 {
+		test.Fatalf("Expected status 207 but was %v.", recorder.Code)
+	}
+
+	linkName := "a." + strconv.FormatUint(uint64(file.Id), 10)
+	body := recorder.Body.String()
+	if !strings.Contains(body, "<D:href>/tags/fruit/</D:href>") || !strings.Contains(body, "<D:href>/tags/fruit/"+linkName+"</D:href>") {
+		test.Fatalf("Unexpected response:\n%v", body)
+	}
+
+	// test
+
+	request = httptest.NewRequest("GET", "/tags/fruit/"+linkName, nil)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	// validate
+
+	content, err := ioutil.ReadAll(recorder.Body)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if string(content) != "apple" {
+		test.Fatalf("Unexpected content '%v'.", string(content))
+	}
+
+	// test
+
+	request = httptest.NewRequest("DELETE", "/tags/fruit/"+linkName, nil)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	// validate
+
+	if recorder.Code != 405 {
+		test.Fatalf("Expected status 405 but was %v.", recorder.Code)
+	}
+}
+
+func TestServeNineP(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/a", "apple"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "fruit"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := store.Commit(); err != nil {
+		test.Fatal(err)
+	}
+
+	file, err := store.FileByPath("/tmp/tmsu/a")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go vfs.NewNinePServer(store).ServeConn(server)
+
+	// test
+
+	ninePRequest(test, client, 100, uint32(8192), "9P2000")                    // Tversion
+	ninePRequest(test, client, 104, uint32(1), uint32(0xFFFFFFFF), "user", "") // Tattach
+	linkName := "a." + strconv.FormatUint(uint64(file.Id), 10)
+	ninePRequest(test, client, 110, uint32(1), uint32(2), uint16(3), "tags", "fruit", linkName) // Twalk
+	ninePRequest(test, client, 112, uint32(2), byte(0))                                         // Topen
+	response := ninePRequest(test, client, 116, uint32(2), uint64(0), uint32(100))              // Tread
+
+	// validate
+
+	count := binary.LittleEndian.Uint32(response[0:4])
+	if string(response[4:4+count]) != "apple" {
+		test.Fatalf("Unexpected content '%v'.", string(response[4:4+count]))
+	}
+
+	// test
+
+	response = ninePRequest(test, client, 110, uint32(1), uint32(3), uint16(1), "bogus") // Twalk
+
+	// validate
+
+	if response != nil {
+		test.Fatalf("Expected walk to missing directory to fail.")
+	}
+}
+
+func TestServeConcurrentRequests(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := redirectStreams(); err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	for _, path := range []string{"/tmp/tmsu/a", "/tmp/tmsu/b", "/tmp/tmsu/c"} {
+		if err := createFile(path, path); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+	}
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+	if err := TagCommand.Exec(store, Options{Option{"--tags", "-t", "", true, "fruit apple"}}, []string{"/tmp/tmsu/a", "/tmp/tmsu/b", "/tmp/tmsu/c"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := store.Commit(); err != nil {
+		test.Fatal(err)
+	}
+
+	store.EnableQueryCache()
+
+	mux := http.NewServeMux()
+	mux.Handle("/", vfs.NewWebDavHandler(store))
+	mux.Handle(feedPath, newFeedHandler(store))
+
+	// test
+
+	const requests = 20
+
+	var wait sync.WaitGroup
+	codes := make(chan int, 2*requests)
+	for index := 0; index < requests; index++ {
+		wait.Add(2)
+
+		go func() {
+			defer wait.Done()
+
+			request := httptest.NewRequest("PROPFIND", "/tags/fruit/apple/", nil)
+			request.Header.Set("Depth", "1")
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, request)
+
+			codes <- recorder.Code
+		}()
+
+		go func() {
+			defer wait.Done()
+
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, httptest.NewRequest("GET", feedPath+"?query=fruit", nil))
+
+			codes <- recorder.Code
+		}()
+	}
+
+	wait.Wait()
+	close(codes)
+
+	// validate
+
+	for code := range codes {
+		if code != 207 && code != 200 {
+			test.Fatalf("Unexpected status %v.", code)
+		}
+	}
+}
+
+func TestServeOutsideTransaction(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := redirectStreams(); err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	if !ServeCommand.NoTransaction {
+		test.Fatal("Expected the serve command to run outside of a transaction.")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+
+	// test
+
+	go func() {
+		options := Options{Option{"--webdav", "-w", "", false, ""}, Option{"--address", "-a", "", true, "127.0.0.1:0"}}
+		served <- ServeCommand.Exec(store.WithContext(ctx), options, []string{})
+	}()
+
+	// the database can be changed by another connection whilst serving
+	other, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer other.Close()
+
+	if err := other.Begin(); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := other.AddTag("apple"); err != nil {
+		test.Fatal(err)
+	}
+	if err := other.Commit(); err != nil {
+		test.Fatal(err)
+	}
+
+	cancel()
+
+	// validate
+
+	select {
+	case err := <-served:
+		if err != nil {
+			test.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		test.Fatal("Serving did not stop when interrupted.")
+	}
+
+	if store.Db.InTransaction() {
+		test.Fatal("Expected no transaction to be open after serving.")
+	}
+}
+
+func TestWarnIfPublic(test *testing.T) {
+	// set-up
+
+	if err := redirectStreams(); err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	// test
+
+	for _, address := range []string{"localhost:8080", "127.0.0.1:8080", "[::1]:5640"} {
+		warnIfPublic(address)
+	}
+
+	// validate
+
+	errFile.Seek(0, 0)
+	bytes, err := ioutil.ReadAll(errFile)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(bytes) > 0 {
+		test.Fatalf("Unexpected warning '%v'.", string(bytes))
+	}
+
+	// test
+
+	warnIfPublic(":8000")
+
+	// validate
+
+	errFile.Seek(0, 0)
+	bytes, err = ioutil.ReadAll(errFile)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if !strings.Contains(string(bytes), "without authentication") {
+		test.Fatalf("Expected a warning but was '%v'.", string(bytes))
+	}
+}
+
+// Sends a 9P request and returns the body of the response, or nil for an error
+// response.
+func ninePRequest(test *testing.T, conn net.Conn, kind byte, fields ...interface{}) []byte {
+	body := make([]byte, 0, 100)
+	for _, field := range fields {
+		switch value := field.(type) {
+		case byte:
+			body = append(body, value)
+		case uint16:
+			body = append(body, byte(value), byte(value>>8))
+		case uint32:
+			body = binary.LittleEndian.AppendUint32(body, value)
+		case uint64:
+			body = binary.LittleEndian.AppendUint64(body, value)
+		case string:
+			body = append(body, byte(len(value)), byte(len(value)>>8))
+			body = append(body, value...)
+		}
+	}
+
+	message := binary.LittleEndian.AppendUint32(nil, uint32(7+len(body)))
+	message = append(message, kind, 1, 0)
+	message = append(message, body...)
+
+	if _, err := conn.Write(message); err != nil {
+		test.Fatal(err)
+	}
+
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		test.Fatal(err)
+	}
+
+	response := make([]byte, binary.LittleEndian.Uint32(header[0:4])-7)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		test.Fatal(err)
+	}
+
+	if header[4] == 107 { // Rerror
+		return nil
+	}
+	if header[4] != kind+1 {
+		test.Fatalf("Unexpected response type %v.", header[4])
+	}
+
+	return response
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"tmsu/common/log"
+	"tmsu/storage"
+	"tmsu/storage/database"
+)
+
+var SetCommand = Command{
+	Name:     "set",
+	Synopsis: "Manage named sets of tags",
+	Usages: []string{"tmsu set [NAME]...",
+		"tmsu set add NAME TAG[=VALUE]...",
+		"tmsu set remove NAME..."},
+	Description: `Manages named sets of tags, which can then be applied together by giving +NAME in place of a tag to the 'tag' subcommand.
+
+Without arguments, lists the tag sets. Where NAMEs are specified, shows those sets.
+
+The 'add' form creates the set NAME of the TAGs specified, replacing any existing set of that name. The 'remove' form deletes the sets.
+
+A set may include another set by its +NAME. Where a tag is to be applied whose name starts with '+' and there is no set of that name, the tag is applied as it is.`,
+	Examples: []string{`$ tmsu set add camera-import "photo unprocessed year=2015"`,
+		"$ tmsu tag IMG_0001.JPG +camera-import",
+		"$ tmsu set\ncamera-import: photo unprocessed year=2015",
+		"$ tmsu set remove camera-import"},
+	Options: Options{},
+	Exec:    setExec,
+}
+
+func setExec(store *storage.Storage, options Options, args []string) error {
+	if len(args) == 0 {
+		return listTagSets(store)
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) < 3 {
+			return fmt.Errorf("set name and tags must be specified")
+		}
+
+		if _, err := store.UpdateTagSet(args[1], strings.Join(args[2:], " ")); err != nil {
+			return fmt.Errorf("could not add tag set '%v': %v", args[1], err)
+		}
+	case "remove":
+		if len(args) < 2 {
+			return fmt.Errorf("set names must be specified")
+		}
+
+		wereErrors := false
+		for _, name := range args[1:] {
+			err := store.DeleteTagSet(name)
+			if _, ok := err.(database.NoSuchTagSetError); ok {
+				log.Warnf("no such tag set '%v'.", name)
+				wereErrors = true
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("could not remove tag set '%v': %v", name, err)
+			}
+		}
+
+		if wereErrors {
+			return errBlank
+		}
+	default:
+		return showTagSets(store, args)
+	}
+
+	return nil
+}
+
+// unexported
+
+func listTagSets(store *storage.Storage) error {
+	tagSets, err := store.TagSets()
+	if err != nil {
+		return fmt.Errorf("could not retrieve tag sets: %v", err)
+	}
+
+	for _, tagSet := range tagSets {
+		fmt.Printf("%v: %v\n", tagSet.Name, tagSet.Tags)
+	}
+
+	return nil
+}
+
+func showTagSets(store *storage.Storage, names []string) error {
+	wereErrors := false
+	for _, name := range names {
+		tagSet, err := store.TagSet(name)
+		if err != nil {
+			return fmt.Errorf("could not retrieve tag set '%v': %v", name, err)
+		}
+		if tagSet == nil {
+			log.Warnf("no such tag set '%v'.", name)
+			wereErrors = true
+			continue
+		}
+
+		fmt.Printf("%v: %v\n", tagSet.Name, tagSet.Tags)
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+// Replaces each +NAME amongst the tag arguments with the tags of the set of
+// that name, including those of any sets it includes in turn. Arguments that
+// do not name a set are left as they are.
+func expandTagSets(store *storage.Storage, tagArgs []string) ([]string, error) {
+	return expandTagSetsWithin(store, tagArgs, make(map[string]bool))
+}
+
+func expandTagSetsWithin(store *storage.Storage, tagArgs []string, expanding map[string]bool) ([]string, error) {
+	expanded := make([]string, 0, len(tagArgs))
+	for _, tagArg := range tagArgs {
+		if !strings.HasPrefix(tagArg, "+") || len(tagArg) == 1 {
+			expanded = append(expanded, tagArg)
+			continue
+		}
+
+		name := tagArg[1:]
+		tagSet, err := store.TagSet(name)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve tag set '%v': %v", name, err)
+		}
+		if tagSet == nil {
+			expanded = append(expanded, tagArg)
+			continue
+		}
+
+		if expanding[name] {
+			return nil, fmt.Errorf("tag set '%v' includes itself", name)
+		}
+
+		expanding[name] = true
+		setTagArgs, err := expandTagSetsWithin(store, strings.Fields(tagSet.Tags), expanding)
+		if err != nil {
+			return nil, err
+		}
+		delete(expanding, name)
+
+		expanded = append(expanded, setTagArgs...)
+	}
+
+	return expanded, nil
+}
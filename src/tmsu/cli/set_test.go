@@ -0,0 +1,126 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestSetApplyWithTag(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	if err := SetCommand.Exec(store, Options{}, []string{"add", "raw", "unprocessed"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := SetCommand.Exec(store, Options{}, []string{"add", "camera-import", "photo +raw year=2015"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "+camera-import", "beach"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	if err := TagsCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/tmsu/a: beach photo unprocessed year=2015\n", string(bytes))
+}
+
+func TestSetAddReplaceAndRemove(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	// test
+
+	if err := SetCommand.Exec(store, Options{}, []string{"add", "camera-import", "photo"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := SetCommand.Exec(store, Options{}, []string{"add", "camera-import", "photo", "unprocessed"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := SetCommand.Exec(store, Options{}, []string{"add", "scan", "document"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := SetCommand.Exec(store, Options{}, []string{"remove", "scan"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := SetCommand.Exec(store, Options{}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "camera-import: photo unprocessed\n", string(bytes))
+
+	if err := SetCommand.Exec(store, Options{}, []string{"remove", "scan"}); err != errBlank {
+		test.Fatalf("Expected removal of a non-existent set to be reported but got: %v", err)
+	}
+}
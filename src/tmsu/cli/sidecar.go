@@ -0,0 +1,135 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"tmsu/common/log"
+	"tmsu/storage"
+)
+
+// Adds to the paths the sidecar files alongside them, i.e. the other members
+// of the groups of the 'sidecarGroups' setting that exist in the same
+// directory, so that the members of a group are tagged as one.
+func withSidecars(store *storage.Storage, paths []string) ([]string, error) {
+	groups, err := store.SidecarGroups()
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return paths, nil
+	}
+
+	seen := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("%v: could not get absolute path: %v", path, err)
+		}
+
+		seen[absPath] = true
+	}
+
+	expanded := paths
+	for _, path := range paths {
+		sidecarPaths, err := sidecars(groups, path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, sidecarPath := range sidecarPaths {
+			if seen[sidecarPath] {
+				continue
+			}
+			seen[sidecarPath] = true
+
+			log.Infof(2, "%v: including sidecar '%v'", path, sidecarPath)
+
+			expanded = append(expanded, sidecarPath)
+		}
+	}
+
+	return expanded, nil
+}
+
+// Finds the files in the same directory as the path that share a group with
+// it. Names are compared without regard to case, so that 'IMG_0001.CR2' and
+// 'IMG_0001.jpg' are grouped by the patterns '*.cr2' and '*.jpg'.
+func sidecars(groups [][]string, path string) ([]string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("%v: could not get absolute path: %v", path, err)
+	}
+
+	dir, name := filepath.Split(absPath)
+
+	var entries []os.FileInfo
+	sidecarPaths := make([]string, 0, 2)
+	for _, group := range groups {
+		for _, pattern := range group {
+			stem, matched := matchSidecarPattern(pattern, name)
+			if !matched {
+				continue
+			}
+
+			if entries == nil {
+				entries, err = ioutil.ReadDir(dir)
+				if err != nil {
+					if os.IsNotExist(err) {
+						return sidecarPaths, nil
+					}
+
+					return nil, fmt.Errorf("%v: could not list directory: %v", dir, err)
+				}
+			}
+
+			for _, otherPattern := range group {
+				sidecarName := strings.Replace(otherPattern, "*", stem, 1)
+
+				for _, entry := range entries {
+					if !entry.IsDir() && entry.Name() != name && strings.EqualFold(entry.Name(), sidecarName) {
+						sidecarPaths = append(sidecarPaths, filepath.Join(dir, entry.Name()))
+					}
+				}
+			}
+		}
+	}
+
+	return sidecarPaths, nil
+}
+
+// Matches a name against a sidecar pattern, returning the part of the name
+// standing in for the '*'.
+func matchSidecarPattern(pattern, name string) (string, bool) {
+	index := strings.Index(pattern, "*")
+	prefix, suffix := pattern[:index], pattern[index+1:]
+
+	if len(name) <= len(prefix)+len(suffix) {
+		return "", false
+	}
+
+	if !strings.EqualFold(name[:len(prefix)], prefix) || !strings.EqualFold(name[len(name)-len(suffix):], suffix) {
+		return "", false
+	}
+
+	return name[len(prefix) : len(name)-len(suffix)], true
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"tmsu/common/log"
+	"tmsu/common/path"
+	"tmsu/storage"
+)
+
+const defaultSimilarLimit = 10
+
+var SimilarCommand = Command{
+	Name:     "similar",
+	Synopsis: "List files tagged like a file",
+	Usages:   []string{"tmsu similar [OPTION]... FILE"},
+	Description: `Lists the other files whose tags are most like those of FILE, most similar first.
+
+The similarity of two files is the number of tags (with values) they have in common divided by the number of distinct tags they have between them, from 0 for no tags in common to 1 for exactly the same tags. Only explicitly applied tags are compared, and a tag with a different value counts as a different tag. Files with no tags in common with FILE are not listed.
+
+The 10 most similar files are listed unless another limit is specified with --limit. A limit of 0 lists every file with a tag in common.`,
+	Examples: []string{"$ tmsu similar song.mp3\n1.00  other.mp3\n0.67  another.mp3",
+		"$ tmsu similar --limit=3 --json song.mp3"},
+	Options: Options{{"--limit", "-n", "list at most COUNT files", true, ""},
+		{"--json", "-j", "list the files as JSON", false, ""}},
+	Exec: similarExec,
+}
+
+func similarExec(store *storage.Storage, options Options, args []string) error {
+	if len(args) != 1 {
+		return usageError{fmt.Errorf("a single file must be specified")}
+	}
+
+	limit := uint(defaultSimilarLimit)
+	if options.HasOption("--limit") {
+		value, err := strconv.ParseUint(options.Get("--limit").Argument, 10, 0)
+		if err != nil {
+			return fmt.Errorf("invalid limit '%v'", options.Get("--limit").Argument)
+		}
+
+		limit = uint(value)
+	}
+
+	absPath, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("%v: could not get absolute path: %v", args[0], err)
+	}
+
+	file, err := store.FileByPath(absPath)
+	if err != nil {
+		return fmt.Errorf("%v: could not retrieve file: %v", args[0], err)
+	}
+	if file == nil {
+		return fmt.Errorf("%v: file is not tagged", args[0])
+	}
+
+	log.Infof(2, "%v: ranking files by tag similarity", args[0])
+
+	similarFiles, err := store.SimilarFiles(file.Id, limit)
+	if err != nil {
+		return fmt.Errorf("%v: could not retrieve similar files: %v", args[0], err)
+	}
+
+	if options.HasOption("--json") {
+		entries := make([]similarFileEntry, len(similarFiles))
+		for index, similarFile := range similarFiles {
+			entries[index] = similarFileEntry{similarFile.File.Path(), similarFile.Score, similarFile.SharedTags}
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		if err := encoder.Encode(entries); err != nil {
+			return fmt.Errorf("could not write similar files: %v", err)
+		}
+
+		return nil
+	}
+
+	for _, similarFile := range similarFiles {
+		fmt.Printf("%.2f  %v\n", similarFile.Score, path.Rel(similarFile.File.Path()))
+	}
+
+	return nil
+}
+
+// unexported
+
+// A similar file, as printed by --json.
+type similarFileEntry struct {
+	Path       string  `json:"path"`
+	Score      float64 `json:"score"`
+	SharedTags uint    `json:"sharedTags"`
+}
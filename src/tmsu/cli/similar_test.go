@@ -0,0 +1,125 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestSimilarRanksByTagSimilarity(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	taggings := map[string][]string{"/tmp/tmsu/a": {"jazz", "live", "year=1959"},
+		"/tmp/tmsu/b": {"jazz", "live", "year=1959"},
+		"/tmp/tmsu/c": {"jazz", "year=1960"},
+		"/tmp/tmsu/d": {"jazz", "live", "year=1959", "vinyl"},
+		"/tmp/tmsu/e": {"rock"}}
+	for path, tagNames := range taggings {
+		if err := createFile(path, path); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+
+		if err := TagCommand.Exec(store, Options{}, append([]string{path}, tagNames...)); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	if err := redirectStreams(); err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	// test
+
+	if err := SimilarCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "1.00  /tmp/tmsu/b\n0.75  /tmp/tmsu/d\n0.25  /tmp/tmsu/c\n", string(bytes))
+}
+
+func TestSimilarJsonWithLimit(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	taggings := map[string][]string{"/tmp/tmsu/a": {"jazz", "live"},
+		"/tmp/tmsu/b": {"jazz"},
+		"/tmp/tmsu/c": {"jazz", "live"}}
+	for path, tagNames := range taggings {
+		if err := createFile(path, path); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+
+		if err := TagCommand.Exec(store, Options{}, append([]string{path}, tagNames...)); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	if err := redirectStreams(); err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	// test
+
+	options := Options{Option{"--limit", "-n", "", true, "1"}, Option{"--json", "-j", "", false, ""}}
+	if err := SimilarCommand.Exec(store, options, []string{"/tmp/tmsu/a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, `[
+  {
+    "path": "/tmp/tmsu/c",
+    "score": 1,
+    "sharedTags": 2
+  }
+]
+`, string(bytes))
+}
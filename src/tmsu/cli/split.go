@@ -0,0 +1,184 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"tmsu/common/log"
+	"tmsu/storage"
+)
+
+var SplitCommand = Command{
+	Name:     "split",
+	Aliases:  []string{"unvalue"},
+	Synopsis: "Convert tag values to tags and back",
+	Usages: []string{"tmsu split [OPTION]... TAG=VALUE...",
+		"tmsu split [OPTION]... --promote DEST TAG..."},
+	Description: `Replaces every application of TAG=VALUE with a tag named VALUE that has no value. The tag is created if it does not yet exist.
+
+With --promote each TAG is instead made a value of tag DEST: files tagged TAG are tagged DEST=TAG and TAG is deleted. Files tagged TAG with a value keep that value.
+
+Each TAG is converted in full or, should an error occur, not at all.
+
+Tags named by the 'lockedTags' setting cannot be converted unless --force is specified.`,
+	Examples: []string{"$ tmsu split format=mp3",
+		"$ tmsu split format=mp3 format=ogg",
+		"$ tmsu split --promote format mp3 ogg"},
+	Options: Options{{"--promote", "-p", "make each TAG a value of tag DEST", true, ""},
+		{"--force", "-f", "convert locked tags", false, ""}},
+	Exec: splitExec,
+}
+
+func splitExec(store *storage.Storage, options Options, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("too few arguments")
+	}
+
+	store.Force = options.HasOption("--force")
+
+	if options.HasOption("--promote") {
+		return promoteTags(store, options.Get("--promote").Argument, args)
+	}
+
+	return splitTagValues(store, args)
+}
+
+// unexported
+
+func splitTagValues(store *storage.Storage, tagArgs []string) error {
+	wereErrors := false
+	for _, tagArg := range tagArgs {
+		index := strings.Index(tagArg, "=")
+		if index < 1 || index == len(tagArg)-1 {
+			log.Warnf("'%v' is not of the form TAG=VALUE.", tagArg)
+			wereErrors = true
+			continue
+		}
+
+		tagName := tagArg[0:index]
+		valueName := tagArg[index+1:]
+
+		tag, err := getTag(store, tagName)
+		if err != nil {
+			return err
+		}
+		if tag == nil {
+			log.Warnf("no such tag '%v'.", tagName)
+			wereErrors = true
+			continue
+		}
+
+		value, err := getValue(store, valueName)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			log.Warnf("no such value '%v'.", valueName)
+			wereErrors = true
+			continue
+		}
+
+		destTag, err := getTag(store, valueName)
+		if err != nil {
+			return err
+		}
+		if destTag == nil {
+			destTag, err = createTag(store, valueName)
+			if err != nil {
+				return err
+			}
+		}
+
+		log.Infof(2, "converting '%v' to tag '%v'.", tagArg, valueName)
+
+		err = store.SplitTagValue(tag.Id, value.Id, destTag.Id)
+		if _, ok := err.(storage.TagLockedError); ok {
+			log.Warnf("tag '%v' is locked: use --force to convert it.", tagName)
+			wereErrors = true
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not convert '%v' to tag '%v': %v", tagArg, valueName, err)
+		}
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+func promoteTags(store *storage.Storage, destTagName string, tagNames []string) error {
+	destTag, err := getTag(store, destTagName)
+	if err != nil {
+		return err
+	}
+	if destTag == nil {
+		return noSuchTagError{destTagName}
+	}
+
+	wereErrors := false
+	for _, tagName := range tagNames {
+		if tagName == destTagName {
+			log.Warnf("cannot make tag '%v' a value of itself.", tagName)
+			wereErrors = true
+			continue
+		}
+
+		tag, err := getTag(store, tagName)
+		if err != nil {
+			return err
+		}
+		if tag == nil {
+			log.Warnf("no such tag '%v'.", tagName)
+			wereErrors = true
+			continue
+		}
+
+		value, err := getValue(store, tagName)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			value, err = createValue(store, tagName)
+			if err != nil {
+				return fmt.Errorf("could not create value '%v': %v", tagName, err)
+			}
+		}
+
+		log.Infof(2, "converting tag '%v' to '%v=%v'.", tagName, destTagName, tagName)
+
+		err = store.MergeTag(tag.Id, destTag.Id, value.Id)
+		if _, ok := err.(storage.TagLockedError); ok {
+			log.Warnf("tag '%v' is locked: use --force to convert it.", tagName)
+			wereErrors = true
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not convert tag '%v' to '%v=%v': %v", tagName, destTagName, tagName, err)
+		}
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
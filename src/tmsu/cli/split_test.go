@@ -0,0 +1,164 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"os"
+	"testing"
+	"time"
+	"tmsu/common/fingerprint"
+	"tmsu/storage"
+)
+
+func TestSplitTagValue(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	fileB, err := store.AddFile("/tmp/b", fingerprint.Fingerprint("def"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagFormat, err := store.AddTag("format")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	valueMp3, err := store.AddValue("mp3")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	valueOgg, err := store.AddValue("ogg")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileA.Id, tagFormat.Id, valueMp3.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileB.Id, tagFormat.Id, valueOgg.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := SplitCommand.Exec(store, Options{}, []string{"format=mp3"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	tagMp3, err := store.TagByName("mp3")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if tagMp3 == nil {
+		test.Fatal("Tag 'mp3' was not created.")
+	}
+
+	expectTags(test, store, fileA, tagMp3)
+	expectTags(test, store, fileB, tagFormat)
+
+	valueMp3, err = store.ValueByName("mp3")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if valueMp3 != nil {
+		test.Fatal("Value 'mp3' is still present.")
+	}
+}
+
+func TestSplitPromote(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	fileA, err := store.AddFile("/tmp/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagFormat, err := store.AddTag("format")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagMp3, err := store.AddTag("mp3")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(fileA.Id, tagMp3.Id, 0); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	options := Options{Option{"--promote", "-p", "", true, "format"}}
+	if err := SplitCommand.Exec(store, options, []string{"mp3"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	tagMp3, err = store.TagByName("mp3")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if tagMp3 != nil {
+		test.Fatal("Tag 'mp3' still exists.")
+	}
+
+	valueMp3, err := store.ValueByName("mp3")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if valueMp3 == nil {
+		test.Fatal("Value 'mp3' was not created.")
+	}
+
+	exists, err := store.FileTagExists(fileA.Id, tagFormat.Id, valueMp3.Id, true)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if !exists {
+		test.Fatal("File is not tagged 'format=mp3'.")
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+	"tmsu/storage"
+)
+
+var SqlCommand = Command{
+	Name:     "sql",
+	Synopsis: "Run a read-only SQL query",
+	Usages:   []string{"tmsu sql [OPTION]... QUERY"},
+	Description: `Runs the SQL QUERY against the database and prints the results as CSV, with a header row of column names, or with --json as a JSON array of objects whose members follow the order of the columns. This is intended for reports that the other subcommands do not provide.
+
+The query is run through a read-only connection so cannot change the database. It sees the changes that have been committed.
+
+The following views are provided for querying and will be kept stable between releases, unlike the underlying tables:
+
+  v_files      id, path, directory, name, fingerprint, mod_time, size, is_dir
+  v_tags       id, name, file_count
+  v_file_tags  file_id, path, tag_id, tag, value_id, value, owner, applied
+
+The paths are those stored in the database, which are relative to the root path, if any (see 'info'). Only the explicit file tags are stored: the tags applied by implication are not included in v_file_tags or the file counts.`,
+	Examples: []string{"$ tmsu sql 'SELECT name, file_count FROM v_tags ORDER BY file_count DESC LIMIT 3'\nname,file_count\nmusic,1204\nphoto,873\nyear,650",
+		"$ tmsu sql --json 'SELECT path FROM v_files WHERE size > 1000000000'"},
+	Options: Options{{"--json", "-j", "print the results as JSON", false, ""}},
+	Exec:    sqlExec,
+}
+
+func sqlExec(store *storage.Storage, options Options, args []string) error {
+	switch {
+	case len(args) == 0:
+		return fmt.Errorf("query must be specified")
+	case len(args) > 1:
+		return fmt.Errorf("too many arguments: quote the query")
+	}
+
+	if options.HasOption("--json") {
+		return printSqlJson(store, args[0])
+	}
+
+	return printSqlCsv(store, args[0])
+}
+
+// unexported
+
+func printSqlCsv(store *storage.Storage, query string) error {
+	writer := csv.NewWriter(os.Stdout)
+
+	header := func(columns []string) error {
+		return writer.Write(columns)
+	}
+
+	row := func(values []interface{}) error {
+		record := make([]string, len(values))
+		for index, value := range values {
+			record[index] = sqlText(value)
+		}
+
+		return writer.Write(record)
+	}
+
+	if err := store.ReadOnlyQuery(query, header, row); err != nil {
+		return fmt.Errorf("could not run query: %v", err)
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func printSqlJson(store *storage.Storage, query string) error {
+	var columns []string
+	results := make([]sqlJsonRow, 0, 10)
+
+	header := func(names []string) error {
+		columns = names
+		return nil
+	}
+
+	row := func(values []interface{}) error {
+		results = append(results, sqlJsonRow{columns, values})
+		return nil
+	}
+
+	if err := store.ReadOnlyQuery(query, header, row); err != nil {
+		return fmt.Errorf("could not run query: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(results); err != nil {
+		return fmt.Errorf("could not write results: %v", err)
+	}
+
+	return nil
+}
+
+// A row of results, written as a JSON object with a member for each column in
+// the order of the columns, so that columns of the same name are all kept.
+type sqlJsonRow struct {
+	columns []string
+	values  []interface{}
+}
+
+func (row sqlJsonRow) MarshalJSON() ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+
+	for index, column := range row.columns {
+		if index > 0 {
+			buffer.WriteByte(',')
+		}
+
+		name, err := json.Marshal(column)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := json.Marshal(row.values[index])
+		if err != nil {
+			return nil, err
+		}
+
+		buffer.Write(name)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+func sqlText(value interface{}) string {
+	switch typedValue := value.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return typedValue.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(value)
+	}
+}
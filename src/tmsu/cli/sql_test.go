@@ -0,0 +1,159 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+	"tmsu/common/fingerprint"
+	"tmsu/storage"
+)
+
+func TestSqlViews(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	file, err := store.AddFile("/tmp/tmsu/a", fingerprint.Fingerprint("abc123"), time.Now(), 0, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	appleTag, err := store.AddTag("apple")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddTag("banana"); err != nil {
+		test.Fatal(err)
+	}
+
+	redValue, err := store.AddValue("red")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFileTag(file.Id, appleTag.Id, redValue.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := SqlCommand.Exec(store, Options{}, []string{"SELECT name, file_count FROM v_tags ORDER BY name"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := SqlCommand.Exec(store, Options{}, []string{"SELECT path, tag, value FROM v_file_tags"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "name,file_count\napple,1\nbanana,0\npath,tag,value\n/tmp/tmsu/a,apple,red\n", string(bytes))
+}
+
+func TestSqlJsonKeepsColumnOrder(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	// test
+
+	if err := SqlCommand.Exec(store, Options{Option{"--json", "-j", "", false, ""}}, []string{"SELECT 'z' AS name, 2 AS count, 'a' AS name"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "[\n  {\n    \"name\": \"z\",\n    \"count\": 2,\n    \"name\": \"a\"\n  }\n]\n", string(bytes))
+}
+
+func TestSqlIsReadOnly(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.AddTag("apple"); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	err = SqlCommand.Exec(store, Options{}, []string{"SELECT 1; DELETE FROM tag"})
+
+	// validate
+
+	if err == nil {
+		test.Fatal("Query changing the database was not rejected.")
+	}
+
+	tagCount, err := store.TagCount()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if tagCount != 1 {
+		test.Fatalf("Expected 1 tag but there are %v.", tagCount)
+	}
+}
@@ -21,7 +21,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"tmsu/common/filesystem"
 	"tmsu/common/log"
 	"tmsu/common/path"
 	"tmsu/entities"
@@ -41,14 +43,21 @@ Where PATHs are not specified the status of the database is shown.
   ! - Missing
   U - Untagged
 
-Status codes of T, M and ! mean that the file has been tagged (and thus is in the TMSU database). Modified files are those with a different modification time or size to that in the database. Missing files are those in the database but that no longer exist in the file-system.
+Status codes of T, M and ! mean that the file has been tagged (and thus is in the TMSU database). Modified files are those with a different modification time or size to that in the database: file contents are not examined. Missing files are those in the database but that no longer exist in the file-system.
+
+The files are listed grouped by status once every file has been examined. With --stream each file is instead listed as soon as its status is known, which suits large databases.
+
+Symbolic links are treated according to the 'symlinkPolicy' setting or --symlinks: under 'follow' (the default) and 'target' a link is examined by its target, so a broken link is missing, and under 'link' the link itself is examined. Only 'follow' descends into linked directories.
 
 Note: The 'repair' subcommand can be used to fix problems caused by files that have been modified or moved on disk.`,
 	Examples: []string{"$ tmsu status",
 		"$ tmsu status .",
-		"$ tmsu status --directory *"},
-	Options: Options{Option{"--directory", "-d", "do not examine directory contents (non-recursive)", false, ""}},
-	Exec:    statusExec,
+		"$ tmsu status --directory *",
+		"$ tmsu status --stream /archive"},
+	Options: Options{Option{"--directory", "-d", "do not examine directory contents (non-recursive)", false, ""},
+		Option{"--stream", "-s", "print each file's status as soon as it is known rather than grouped by status", false, ""},
+		Option{"--symlinks", "", "treat symbolic links per POLICY: follow, target or link", true, ""}},
+	Exec: statusExec,
 }
 
 type Status byte
@@ -60,22 +69,29 @@ const (
 	MISSING  Status = '!'
 )
 
+// The number of files whose status is checked concurrently.
+var statusWorkers = 4 * runtime.NumCPU()
+
 type StatusReport struct {
-	Rows []Row
+	Rows   []Row
+	Stream bool
+	paths  map[string]bool
 }
 
+// Adds a row to the report or, if the report is streamed, prints it.
 func (report *StatusReport) AddRow(row Row) {
+	report.paths[row.Path] = true
+
+	if report.Stream {
+		printRow(row)
+		return
+	}
+
 	report.Rows = append(report.Rows, row)
 }
 
 func (report *StatusReport) ContainsRow(path string) bool {
-	for _, row := range report.Rows {
-		if row.Path == path {
-			return true
-		}
-	}
-
-	return false
+	return report.paths[path]
 }
 
 type Row struct {
@@ -84,23 +100,26 @@ type Row struct {
 }
 
 func NewReport() *StatusReport {
-	return &StatusReport{make([]Row, 0, 10)}
+	return &StatusReport{make([]Row, 0, 10), false, make(map[string]bool)}
 }
 
 func statusExec(store *storage.Storage, options Options, args []string) error {
 	dirOnly := options.HasOption("--directory")
 
-	var report *StatusReport
-	var err error
+	report := NewReport()
+	report.Stream = options.HasOption("--stream")
+
+	policy, err := symlinkPolicy(store, options)
+	if err != nil {
+		return err
+	}
 
 	if len(args) == 0 {
-		report, err = statusDatabase(store, dirOnly)
-		if err != nil {
+		if err := statusDatabase(store, report, dirOnly, policy); err != nil {
 			return err
 		}
 	} else {
-		report, err = statusPaths(store, args, dirOnly)
-		if err != nil {
+		if err := statusPaths(store, report, args, dirOnly, policy); err != nil {
 			return err
 		}
 	}
@@ -110,19 +129,17 @@ func statusExec(store *storage.Storage, options Options, args []string) error {
 	return nil
 }
 
-func statusDatabase(store *storage.Storage, dirOnly bool) (*StatusReport, error) {
-	report := NewReport()
-
+func statusDatabase(store *storage.Storage, report *StatusReport, dirOnly bool, policy filesystem.SymlinkPolicy) error {
 	log.Info(2, "retrieving all files from database.")
 
 	files, err := store.Files()
 	if err != nil {
-		return nil, fmt.Errorf("could not retrieve files: %v", err)
+		return fmt.Errorf("could not retrieve files: %v", err)
 	}
+	files = localFiles(files)
 
-	err = statusCheckFiles(files, report)
-	if err != nil {
-		return nil, err
+	if err := statusCheckFiles(files, report, policy); err != nil {
+		return err
 	}
 
 	tree := path.NewTree()
@@ -130,111 +147,166 @@ func statusDatabase(store *storage.Storage, dirOnly bool) (*StatusReport, error)
 		tree.Add(file.Path(), file.IsDir)
 	}
 
-	topLevelPaths := tree.TopLevel().Paths()
-	if err != nil {
-		return nil, err
-	}
-
-	for _, path := range topLevelPaths {
-		if err = findNewFiles(path, report, dirOnly); err != nil {
-			return nil, err
+	trail := filesystem.NewDirectoryTrail(policy)
+	for _, path := range tree.TopLevel().Paths() {
+		if err = findNewFiles(trail, path, report, dirOnly); err != nil {
+			return err
 		}
 	}
 
-	return report, nil
+	return nil
 }
 
-func statusPaths(store *storage.Storage, paths []string, dirOnly bool) (*StatusReport, error) {
-	report := NewReport()
+func statusPaths(store *storage.Storage, report *StatusReport, paths []string, dirOnly bool, policy filesystem.SymlinkPolicy) error {
+	absPaths := make([]string, len(paths))
+	files := make(entities.Files, 0, len(paths))
+	seen := make(map[entities.FileId]bool)
 
-	for _, path := range paths {
+	addFile := func(file *entities.File) {
+		if !seen[file.Id] {
+			seen[file.Id] = true
+			files = append(files, file)
+		}
+	}
+
+	for index, path := range paths {
 		absPath, err := filepath.Abs(path)
 		if err != nil {
-			return nil, fmt.Errorf("%v: could not get absolute path: %v", path, err)
+			return fmt.Errorf("%v: could not get absolute path: %v", path, err)
 		}
+		absPaths[index] = absPath
 
 		file, err := store.FileByPath(absPath)
 		if err != nil {
-			return nil, fmt.Errorf("%v: could not retrieve file: %v", path, err)
+			return fmt.Errorf("%v: could not retrieve file: %v", path, err)
 		}
 		if file != nil {
-			err = statusCheckFile(file, report)
-			if err != nil {
-				return nil, err
-			}
+			addFile(file)
 		}
 
 		if !dirOnly {
 			log.Infof(2, "%v: retrieving files from database.", path)
 
-			files, err := store.FilesByDirectory(absPath)
+			dirFiles, err := store.FilesByDirectory(absPath)
 			if err != nil {
-				return nil, fmt.Errorf("%v: could not retrieve files for directory: %v", path, err)
+				return fmt.Errorf("%v: could not retrieve files for directory: %v", path, err)
 			}
 
-			err = statusCheckFiles(files, report)
-			if err != nil {
-				return nil, err
+			for _, dirFile := range dirFiles {
+				addFile(dirFile)
 			}
 		}
+	}
 
-		err = findNewFiles(absPath, report, dirOnly)
-		if err != nil {
-			return nil, err
+	if err := statusCheckFiles(files, report, policy); err != nil {
+		return err
+	}
+
+	trail := filesystem.NewDirectoryTrail(policy)
+	for _, absPath := range absPaths {
+		if err := findNewFiles(trail, absPath, report, dirOnly); err != nil {
+			return err
 		}
 	}
 
-	return report, nil
+	return nil
 }
 
-func statusCheckFiles(files entities.Files, report *StatusReport) error {
-	for _, file := range files {
-		err := statusCheckFile(file, report)
-		if err != nil {
-			return err
+// Checks the status of the files using a pool of workers. The rows are added
+// to the report in the order of the files, each as soon as it and those
+// before it have been checked.
+func statusCheckFiles(files entities.Files, report *StatusReport, policy filesystem.SymlinkPolicy) error {
+	type result struct {
+		row Row
+		ok  bool
+		err error
+	}
+
+	indices := make(chan int)
+	results := make([]chan result, len(files))
+	for index := range results {
+		results[index] = make(chan result, 1)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	workers := statusWorkers
+	if workers > len(files) {
+		workers = len(files)
+	}
+
+	for worker := 0; worker < workers; worker++ {
+		go func() {
+			for index := range indices {
+				row, ok, err := statusCheckFile(files[index], policy)
+				results[index] <- result{row, ok, err}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(indices)
+
+		for index := range files {
+			select {
+			case indices <- index:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for _, resultChan := range results {
+		result := <-resultChan
+		if result.err != nil {
+			return result.err
+		}
+		if result.ok {
+			report.AddRow(result.row)
 		}
 	}
 
 	return nil
 }
 
-func statusCheckFile(file *entities.File, report *StatusReport) error {
+// Determines the status of a tagged file from its size and modification time.
+// The file's contents are not examined.
+func statusCheckFile(file *entities.File, policy filesystem.SymlinkPolicy) (Row, bool, error) {
 	relPath := path.Rel(file.Path())
 
 	log.Infof(2, "%v: checking file status.", file.Path())
 
-	stat, err := os.Stat(file.Path())
+	stat, err := policy.Stat(file.Path())
 	if err != nil {
 		switch {
 		case os.IsNotExist(err):
 			log.Infof(2, "%v: file is missing.", file.Path())
 
-			report.AddRow(Row{relPath, MISSING})
-			return nil
+			return Row{relPath, MISSING}, true, nil
 		case os.IsPermission(err):
 			log.Warnf("%v: permission denied.", file.Path())
+
+			return Row{}, false, nil
 		case strings.Contains(err.Error(), "not a directory"):
-			report.AddRow(Row{relPath, MISSING})
-			return nil
+			return Row{relPath, MISSING}, true, nil
 		default:
-			return fmt.Errorf("%v: could not stat: %v", file.Path(), err)
+			return Row{}, false, fmt.Errorf("%v: could not stat: %v", file.Path(), err)
 		}
-	} else {
-		if stat.Size() != file.Size || !stat.ModTime().UTC().Equal(file.ModTime) {
-			log.Infof(2, "%v: file is modified.", file.Path())
+	}
 
-			report.AddRow(Row{relPath, MODIFIED})
-		} else {
-			log.Infof(2, "%v: file is unchanged.", file.Path())
+	if stat.Size() != file.Size || !stat.ModTime().UTC().Equal(file.ModTime) {
+		log.Infof(2, "%v: file is modified.", file.Path())
 
-			report.AddRow(Row{relPath, TAGGED})
-		}
+		return Row{relPath, MODIFIED}, true, nil
 	}
 
-	return nil
+	log.Infof(2, "%v: file is unchanged.", file.Path())
+
+	return Row{relPath, TAGGED}, true, nil
 }
 
-func findNewFiles(searchPath string, report *StatusReport, dirOnly bool) error {
+func findNewFiles(trail *filesystem.DirectoryTrail, searchPath string, report *StatusReport, dirOnly bool) error {
 	log.Infof(2, "%v: finding new files.", searchPath)
 
 	relPath := path.Rel(searchPath)
@@ -248,7 +320,7 @@ func findNewFiles(searchPath string, report *StatusReport, dirOnly bool) error {
 		return fmt.Errorf("%v: could not get absolute path: %v", searchPath, err)
 	}
 
-	stat, err := os.Stat(absPath)
+	stat, err := trail.Policy.Stat(absPath)
 	if err != nil {
 		switch {
 		case os.IsNotExist(err):
@@ -262,23 +334,27 @@ func findNewFiles(searchPath string, report *StatusReport, dirOnly bool) error {
 	}
 
 	if !dirOnly && stat.IsDir() {
-		dir, err := os.Open(absPath)
-		if err != nil {
-			return fmt.Errorf("%v: could not open file: %v", searchPath, err)
-		}
-
-		dirNames, err := dir.Readdirnames(0)
-		if err != nil {
-			return fmt.Errorf("%v: could not read directory listing: %v", searchPath, err)
-		}
+		return trail.Descend(absPath, func() error {
+			dir, err := os.Open(absPath)
+			if err != nil {
+				return fmt.Errorf("%v: could not open file: %v", searchPath, err)
+			}
 
-		for _, dirName := range dirNames {
-			dirPath := filepath.Join(searchPath, dirName)
-			err = findNewFiles(dirPath, report, dirOnly)
+			dirNames, err := dir.Readdirnames(0)
+			dir.Close()
 			if err != nil {
-				return err
+				return fmt.Errorf("%v: could not read directory listing: %v", searchPath, err)
 			}
-		}
+
+			for _, dirName := range dirNames {
+				dirPath := filepath.Join(searchPath, dirName)
+				if err := findNewFiles(trail, dirPath, report, dirOnly); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
 	}
 
 	return nil
@@ -98,3 +98,62 @@ func TestStatusReport(test *testing.T) {
 	bytes, err := ioutil.ReadAll(outFile)
 	compareOutput(test, "T /tmp/tmsu/a\nM /tmp/tmsu/b\n! /tmp/tmsu/d\nU /tmp/tmsu/c\n", string(bytes))
 }
+
+func TestStatusStream(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	err = redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	if err := createFile("/tmp/tmsu/a", "a"); err != nil {
+		test.Fatalf("Could not create file: %v", err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	if err := createFile("/tmp/tmsu/b", "b"); err != nil {
+		test.Fatalf("Could not create file: %v", err)
+	}
+	defer os.Remove("/tmp/tmsu/b")
+
+	if err := createFile("/tmp/tmsu/c", "c"); err != nil {
+		test.Fatalf("Could not create file: %v", err)
+	}
+	defer os.Remove("/tmp/tmsu/c")
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/b", "b"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := createFile("/tmp/tmsu/b", "b2"); err != nil {
+		test.Fatalf("Could not create file: %v", err)
+	}
+
+	// test
+
+	if err := StatusCommand.Exec(store, Options{Option{"--stream", "-s", "", false, ""}}, []string{"/tmp/tmsu/b", "/tmp/tmsu/c", "/tmp/tmsu/a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "M /tmp/tmsu/b\nT /tmp/tmsu/a\nU /tmp/tmsu/c\n", string(bytes))
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"tmsu/common/log"
+	"tmsu/common/text"
+	"tmsu/storage"
+)
+
+const defaultMinConfidence = 0.8
+
+var SuggestCommand = Command{
+	Name:     "suggest",
+	Synopsis: "Suggest tags for files",
+	Usages:   []string{"tmsu suggest --from-name [OPTION]... FILE..."},
+	Description: `Suggests existing tags for each FILE, most likely first.
+
+With --from-name the words of the file's name, and of the name of the directory containing it, are matched against the names of the existing tags and values. Words match approximately, so 'holidays' suggests 'holiday', and adjacent words match a tag together, so 'New York' suggests 'new-york'. A word matching a value suggests the tag with that value, e.g. '2014' suggests 'year=2014'.
+
+Each suggestion has a confidence, from 0 to 1 for an exact match. Only the suggestions with at least the confidence given by --min-confidence (default 0.8) are listed. Tags the file already has are not suggested.
+
+With --apply the suggestions are applied rather than listed.`,
+	Examples: []string{"$ tmsu suggest --from-name 'Beach Holidays 2014.jpg'\nBeach Holidays 2014.jpg: beach holiday year=2014",
+		"$ tmsu suggest --from-name --scores IMG_mountain.jpg\nIMG_mountain.jpg:\n  1.00  mountain\n  0.89  mountains",
+		"$ tmsu suggest --from-name --apply --min-confidence=0.9 *.mp3"},
+	Options: Options{{"--from-name", "-n", "suggest tags from the file names", false, ""},
+		{"--min-confidence", "-m", "the minimum confidence of the suggestions, from 0 to 1", true, ""},
+		{"--scores", "-s", "show the confidence of each suggestion", false, ""},
+		{"--apply", "-a", "apply the suggested tags", false, ""}},
+	Exec: suggestExec,
+}
+
+func suggestExec(store *storage.Storage, options Options, args []string) error {
+	if !options.HasOption("--from-name") {
+		return usageError{fmt.Errorf("the source of the suggestions must be specified: --from-name")}
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("files to suggest tags for must be specified")
+	}
+
+	minConfidence := defaultMinConfidence
+	if options.HasOption("--min-confidence") {
+		var err error
+		minConfidence, err = strconv.ParseFloat(options.Get("--min-confidence").Argument, 64)
+		if err != nil || minConfidence < 0 || minConfidence > 1 {
+			return fmt.Errorf("invalid confidence '%v': must be from 0 to 1", options.Get("--min-confidence").Argument)
+		}
+	}
+
+	candidates, err := suggestionCandidates(store)
+	if err != nil {
+		return err
+	}
+
+	policy, err := symlinkPolicy(store, nil)
+	if err != nil {
+		return err
+	}
+
+	wereErrors := false
+	for _, path := range args {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not get absolute path: %v", path, err)
+		}
+
+		appliedTagNames, err := appliedTagNames(store, absPath)
+		if err != nil {
+			return err
+		}
+
+		suggestions := suggestTags(nameWords(absPath), candidates, appliedTagNames, minConfidence)
+
+		if options.HasOption("--apply") {
+			if len(suggestions) == 0 {
+				continue
+			}
+
+			tagArgs := make([]string, len(suggestions))
+			for index, suggestion := range suggestions {
+				tagArgs[index] = suggestion.name
+			}
+
+			log.Infof(2, "%v: applying suggested tags %v", path, strings.Join(tagArgs, " "))
+
+			if err := tagPaths(store, tagArgs, []string{path}, false, false, false, policy); err != nil {
+				if err != errBlank {
+					return err
+				}
+
+				wereErrors = true
+			}
+
+			continue
+		}
+
+		printSuggestions(path, suggestions, options.HasOption("--scores"))
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+// unexported
+
+// A tag, or tag and value, suggested for a file.
+type tagSuggestion struct {
+	name       string
+	confidence float64
+}
+
+// A tag, or tag and value, that may be suggested, along with the name it is
+// matched by.
+type suggestionCandidate struct {
+	name  string
+	match string
+}
+
+func suggestionCandidates(store *storage.Storage) ([]suggestionCandidate, error) {
+	tags, err := store.Tags()
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve tags: %v", err)
+	}
+
+	candidates := make([]suggestionCandidate, 0, len(tags))
+	for _, tag := range tags {
+		candidates = append(candidates, suggestionCandidate{tag.Name, strings.Join(text.Words(tag.Name), "")})
+
+		values, err := store.ValuesByTag(tag.Id)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve values for tag '%v': %v", tag.Name, err)
+		}
+
+		for _, value := range values {
+			candidates = append(candidates, suggestionCandidate{tag.Name + "=" + value.Name, strings.Join(text.Words(value.Name), "")})
+		}
+	}
+
+	return candidates, nil
+}
+
+// The names of the tags the file already has, both as TAG and TAG=VALUE.
+func appliedTagNames(store *storage.Storage, absPath string) ([]string, error) {
+	file, err := store.FileByPath(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("%v: could not retrieve file: %v", absPath, err)
+	}
+	if file == nil {
+		return []string{}, nil
+	}
+
+	tagNames, err := tagNamesForFile(store, file.Id, false, false)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tagName := range tagNames {
+		if index := strings.Index(tagName, "="); index != -1 {
+			tagNames = append(tagNames, tagName[:index])
+		}
+	}
+
+	return tagNames, nil
+}
+
+// The words of the file's name, without its extension, and of the name of the
+// directory containing it.
+func nameWords(absPath string) []string {
+	name := filepath.Base(absPath)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+
+	return append(text.Words(filepath.Base(filepath.Dir(absPath))), text.Words(name)...)
+}
+
+// Matches the words against the candidates, returning those that match with
+// at least the minimum confidence, most confident first.
+func suggestTags(words []string, candidates []suggestionCandidate, appliedTagNames []string, minConfidence float64) []tagSuggestion {
+	// adjacent words may together form a tag name
+	terms := append([]string{}, words...)
+	for index := 1; index < len(words); index++ {
+		terms = append(terms, words[index-1]+words[index])
+	}
+
+	suggestions := make([]tagSuggestion, 0, 5)
+	for _, candidate := range candidates {
+		if candidate.match == "" || containsTag(appliedTagNames, candidate.name) {
+			continue
+		}
+
+		confidence := 0.0
+		for _, term := range terms {
+			similarity := text.Similarity(term, candidate.match)
+
+			// short words must match exactly
+			if similarity < 1 && len([]rune(candidate.match)) < 4 {
+				continue
+			}
+
+			if similarity > confidence {
+				confidence = similarity
+			}
+		}
+
+		if confidence > 0 && confidence >= minConfidence {
+			suggestions = append(suggestions, tagSuggestion{candidate.name, confidence})
+		}
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		if suggestions[i].confidence != suggestions[j].confidence {
+			return suggestions[i].confidence > suggestions[j].confidence
+		}
+
+		return suggestions[i].name < suggestions[j].name
+	})
+
+	return suggestions
+}
+
+func printSuggestions(path string, suggestions []tagSuggestion, showScores bool) {
+	if showScores {
+		fmt.Printf("%v:\n", path)
+		for _, suggestion := range suggestions {
+			fmt.Printf("  %.2f  %v\n", suggestion.confidence, suggestion.name)
+		}
+
+		return
+	}
+
+	names := make([]string, len(suggestions))
+	for index, suggestion := range suggestions {
+		names[index] = suggestion.name
+	}
+
+	fmt.Printf("%v: %v\n", path, strings.Join(names, " "))
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestSuggestFromName(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/a", "a"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "beach", "holiday", "new-york", "year=2014", "bar"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := redirectStreams(); err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	// test
+
+	options := Options{Option{"--from-name", "-n", "", false, ""}, Option{"--scores", "-s", "", false, ""}}
+	if err := SuggestCommand.Exec(store, options, []string{"/tmp/tmsu/Holidays/New York Beach 2014 bars.jpg"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/tmsu/Holidays/New York Beach 2014 bars.jpg:\n  1.00  beach\n  1.00  new-york\n  1.00  year=2014\n  0.88  holiday\n", string(bytes))
+}
+
+func TestSuggestFromNameApply(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, path := range []string{"/tmp/tmsu/a", "/tmp/tmsu/beach_2014.jpg"} {
+		if err := createFile(path, path); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "beach", "beaches", "year=2014"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/beach_2014.jpg", "year=2014"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	options := Options{Option{"--from-name", "-n", "", false, ""},
+		Option{"--apply", "-a", "", false, ""},
+		Option{"--min-confidence", "-m", "", true, "0.9"}}
+	if err := SuggestCommand.Exec(store, options, []string{"/tmp/tmsu/beach_2014.jpg"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	file, err := store.FileByPath("/tmp/tmsu/beach_2014.jpg")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagNames, err := tagNamesForFile(store, file.Id, true, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if len(tagNames) != 2 || tagNames[0] != "beach" || tagNames[1] != "year=2014" {
+		test.Fatalf("Expected tags 'beach year=2014' but were '%v'.", strings.Join(tagNames, " "))
+	}
+}
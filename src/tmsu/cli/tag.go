@@ -22,9 +22,10 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
+	"tmsu/common/filesystem"
 	"tmsu/common/fingerprint"
 	"tmsu/common/log"
+	_path "tmsu/common/path"
 	"tmsu/entities"
 	"tmsu/storage"
 )
@@ -40,22 +41,61 @@ var TagCommand = Command{
 
 Tag names may consist of one or more letter, number, punctuation and symbol characters (from the corresponding Unicode categories). Tag names may not contain whitespace characters, the comparison operator symbols ('=', '<' and '>"), parentheses ('(' and ')'), commas (',') or the slash symbol ('/'). In addition, the tag names '.' and '..' are not valid.
 
-Optionally tags applied to files may be attributed with a VALUE using the TAG=VALUE syntax.`,
+Optionally tags applied to files may be attributed with a VALUE using the TAG=VALUE syntax.
+
+With --from the tags of the SOURCE file are applied. Where --from is repeated the tags of any of the SOURCEs are applied or, with --intersect, only those applied to every SOURCE. --only and --except restrict the tags copied to, or exclude, the comma-separated TAGS, whatever their values.
+
+With --aggregate a SOURCE directory contributes the tags of every file beneath it, as well as its own, which is useful when tagging the re-encoded copy of an album or a processed batch of photos. Each directory counts as a single SOURCE for --intersect.
+
+A TAG of the form +NAME applies the tags of the set NAME created with the 'set' subcommand.
+
+The 'tagFileLimit' and 'fileTagLimit' settings, where not zero, limit the number of files each tag may be applied to and the number of tags each file may have, helping to keep the vocabulary of tags disciplined. A file that would exceed either limit is not tagged, with a warning, unless --force is specified.
+
+Where the 'tagHardLinks' setting is enabled, tagging a file also applies the tags to the other files in the database that are hard links to it.
+
+The 'sidecarGroups' setting groups files that are tagged as one, such as a RAW photograph and the JPEG and XMP files alongside it. Each group is a comma-separated list of patterns in which '*' stands for the name the files share, e.g. '*.cr2,*.jpg,*.xmp', with several groups separated by spaces. Tagging a file also tags the other members of its groups that exist in the same directory, names being compared without regard to case.
+
+Symbolic links are treated according to the 'symlinkPolicy' setting, which may be overridden with --symlinks: 'follow' (the default) tags the link by its target's contents and descends into linked directories when tagging recursively; 'target' does likewise but does not descend into linked directories; 'link' stores the link itself, identified by the path it points to. Links leading back to a directory already being tagged are never followed.
+
+If the 'hooks' directory alongside the database (e.g. '.tmsu/hooks') contains executable 'pre-tag' or 'post-tag' scripts then these are run before and after the files are tagged. The files and tags are listed, each terminated by a NUL character, in the files named by the TMSU_FILE_LIST and TMSU_TAG_LIST environment variables and a 'pre-tag' script that fails prevents the tagging. The 'post-tag' script is run once the changes have been committed, even if some of the files could not be tagged, with TMSU_STATUS set to 'success' or 'failure'; a 'post-tag' script that fails does not undo the tagging.
+
+Fingerprinting a file reads the whole of it, which is slow for large files or slow disks. With --no-fingerprint new files are added without a fingerprint and queued so that 'tmsu fingerprint --pending' can calculate the fingerprints later.
+
+A FILE may also be a URL, such as 's3://bucket/key' or 'https://example.org/a.jpg', so that files that do not live on the local filesystem can be tagged. An 'http' or 'https' URL is fetched to calculate its fingerprint; for other schemes the fingerprint may be supplied with 'tmsu import --checksums'. Queries list such files by their URLs, whereas 'repair', 'status' and 'verify' and the virtual filesystem pass over them.`,
 	Examples: []string{"$ tmsu tag mountain1.jpg photo landscape holiday good country=france",
 		"$ tmsu tag --from=mountain1.jpg mountain2.jpg",
+		"$ tmsu tag --from=a.jpg --from=b.jpg --intersect --except=rating,draft c.jpg",
+		"$ tmsu tag --from=album --aggregate --recursive album-mp3",
 		`$ tmsu tag --tags="landscape" field1.jpg field2.jpg`,
-		"$ tmsu tag --create bad rubbish awful"},
+		"$ tmsu tag --create bad rubbish awful",
+		"$ tmsu tag IMG_0001.JPG +camera-import",
+		"$ tmsu tag --recursive --no-fingerprint /media/videos video",
+		"$ tmsu tag s3://photos/2015/beach.jpg holiday"},
 	Options: Options{{"--tags", "-t", "the set of tags to apply", true, ""},
 		{"--recursive", "-r", "recursively apply tags to directory contents", false, ""},
-		{"--from", "-f", "copy tags from the SOURCE file", true, ""},
+		{"--from", "-f", "copy tags from the SOURCE file (may be repeated)", true, ""},
+		{"--aggregate", "", "copy the tags of every file under each SOURCE directory", false, ""},
+		{"--intersect", "", "copy only the tags common to every SOURCE", false, ""},
+		{"--only", "", "copy only the comma-separated TAGS from the SOURCEs", true, ""},
+		{"--except", "", "do not copy the comma-separated TAGS from the SOURCEs", true, ""},
 		{"--create", "-c", "create tags without tagging any files", false, ""},
-		{"--explicit", "-e", "explicitly apply tags even if they are already implied", false, ""}},
+		{"--explicit", "-e", "explicitly apply tags even if they are already implied", false, ""},
+		{"--symlinks", "", "treat symbolic links per POLICY: follow, target or link", true, ""},
+		{"--no-fingerprint", "", "add files without fingerprints, leaving them for 'fingerprint --pending'", false, ""},
+		{"--force", "", "apply tags beyond the limits of the 'tagFileLimit' and 'fileTagLimit' settings", false, ""}},
 	Exec: tagExec,
 }
 
 func tagExec(store *storage.Storage, options Options, args []string) error {
 	recursive := options.HasOption("--recursive")
 	explicit := options.HasOption("--explicit")
+	deferFingerprint := options.HasOption("--no-fingerprint")
+	store.Force = options.HasOption("--force")
+
+	policy, err := symlinkPolicy(store, options)
+	if err != nil {
+		return err
+	}
 
 	switch {
 	case options.HasOption("--create"):
@@ -76,12 +116,19 @@ func tagExec(store *storage.Storage, options Options, args []string) error {
 			return fmt.Errorf("set of tags to apply must be specified")
 		}
 
+		tagArgs, err := expandTagSets(store, tagArgs)
+		if err != nil {
+			return err
+		}
+
 		paths := args
 		if len(paths) < 1 {
 			return fmt.Errorf("at least one file to tag must be specified")
 		}
 
-		if err := tagPaths(store, tagArgs, paths, explicit, recursive); err != nil {
+		if err := withHooks(store, "tag", paths, tagArgs, func() error {
+			return tagPaths(store, tagArgs, paths, explicit, recursive, deferFingerprint, policy)
+		}); err != nil {
 			return err
 		}
 	case options.HasOption("--from"):
@@ -89,14 +136,41 @@ func tagExec(store *storage.Storage, options Options, args []string) error {
 			return fmt.Errorf("files to tag must be specified")
 		}
 
-		fromPath, err := filepath.Abs(options.Get("--from").Argument)
+		fromPaths := make([]string, 0, 1)
+		for _, option := range options.GetAll("--from") {
+			fromPath, err := filepath.Abs(option.Argument)
+			if err != nil {
+				return fmt.Errorf("%v: could not get absolute path: %v", option.Argument, err)
+			}
+
+			fromPaths = append(fromPaths, fromPath)
+		}
+
+		onlyTagIds, err := tagIdsOption(store, options, "--only")
+		if err != nil {
+			return err
+		}
+
+		exceptTagIds, err := tagIdsOption(store, options, "--except")
+		if err != nil {
+			return err
+		}
+
+		tagValuePairs, err := sourceTagValuePairs(store, fromPaths, options.HasOption("--aggregate"), options.HasOption("--intersect"), onlyTagIds, exceptTagIds)
 		if err != nil {
-			return fmt.Errorf("%v: could not get absolute path: %v", fromPath, err)
+			return err
 		}
 
 		paths := args
 
-		if err := tagFrom(store, fromPath, paths, explicit, recursive); err != nil {
+		tagArgs, err := hookTagArgs(store, tagValuePairs)
+		if err != nil {
+			return err
+		}
+
+		if err := withHooks(store, "tag", paths, tagArgs, func() error {
+			return tagFrom(store, tagValuePairs, paths, explicit, recursive, deferFingerprint, policy)
+		}); err != nil {
 			return err
 		}
 	default:
@@ -105,9 +179,14 @@ func tagExec(store *storage.Storage, options Options, args []string) error {
 		}
 
 		paths := args[0:1]
-		tagArgs := args[1:]
+		tagArgs, err := expandTagSets(store, args[1:])
+		if err != nil {
+			return err
+		}
 
-		if err := tagPaths(store, tagArgs, paths, explicit, recursive); err != nil {
+		if err := withHooks(store, "tag", paths, tagArgs, func() error {
+			return tagPaths(store, tagArgs, paths, explicit, recursive, deferFingerprint, policy)
+		}); err != nil {
 			return err
 		}
 	}
@@ -143,12 +222,17 @@ func createTags(store *storage.Storage, tagNames []string) error {
 	return nil
 }
 
-func tagPaths(store *storage.Storage, tagArgs, paths []string, explicit, recursive bool) error {
+func tagPaths(store *storage.Storage, tagArgs, paths []string, explicit, recursive, deferFingerprint bool, policy filesystem.SymlinkPolicy) error {
 	fingerprintAlgorithm, err := store.SettingAsString("fingerprintAlgorithm")
 	if err != nil {
 		return err
 	}
 
+	hardLinks, err := store.SettingAsBool("tagHardLinks")
+	if err != nil {
+		return err
+	}
+
 	autoCreateTags, err := store.SettingAsBool("autoCreateTags")
 	if err != nil {
 		return err
@@ -160,7 +244,7 @@ func tagPaths(store *storage.Storage, tagArgs, paths []string, explicit, recursi
 	}
 
 	wereErrors := false
-	tagValuePairs := make([]TagValuePair, 0, 10)
+	tagValuePairs := make([]entities.TagValuePair, 0, 10)
 	for _, tagArg := range tagArgs {
 		var tagName, valueName string
 		index := strings.Index(tagArg, "=")
@@ -207,11 +291,17 @@ func tagPaths(store *storage.Storage, tagArgs, paths []string, explicit, recursi
 			}
 		}
 
-		tagValuePairs = append(tagValuePairs, TagValuePair{tag.Id, value.Id})
+		tagValuePairs = append(tagValuePairs, entities.TagValuePair{tag.Id, value.Id})
 	}
 
+	paths, err = withSidecars(store, paths)
+	if err != nil {
+		return err
+	}
+
+	trail := filesystem.NewDirectoryTrail(policy)
 	for _, path := range paths {
-		if err := tagPath(store, path, tagValuePairs, explicit, recursive, fingerprintAlgorithm); err != nil {
+		if err := tagPath(store, trail, path, tagValuePairs, explicit, recursive, hardLinks, fingerprintAlgorithm, deferFingerprint); err != nil {
 			switch {
 			case os.IsPermission(err):
 				log.Warnf("%v: permisison denied", path)
@@ -219,6 +309,8 @@ func tagPaths(store *storage.Storage, tagArgs, paths []string, explicit, recursi
 			case os.IsNotExist(err):
 				log.Warnf("%v: no such file", path)
 				wereErrors = true
+			case err == errBlank:
+				wereErrors = true
 			default:
 				return fmt.Errorf("%v: could not stat file: %v", path, err)
 			}
@@ -232,33 +324,139 @@ func tagPaths(store *storage.Storage, tagArgs, paths []string, explicit, recursi
 	return nil
 }
 
-func tagFrom(store *storage.Storage, fromPath string, paths []string, explicit, recursive bool) error {
-	fingerprintAlgorithmSetting, err := store.Setting("fingerprintAlgorithm")
-	if err != nil {
-		return fmt.Errorf("could not retrieve fingerprint algorithm: %v", err)
+// Retrieves the tags of the source files: those applied to any of them or,
+// if intersect, to all of them. Where onlyTagIds is not empty only those tags
+// are included, and the tags of exceptTagIds are excluded.
+func sourceTagValuePairs(store *storage.Storage, fromPaths []string, aggregate, intersect bool, onlyTagIds, exceptTagIds entities.TagIds) ([]entities.TagValuePair, error) {
+	tagValuePairs := make([]entities.TagValuePair, 0, 10)
+	counts := make(map[entities.TagValuePair]int)
+	for _, fromPath := range fromPaths {
+		files, err := sourceFiles(store, fromPath, aggregate)
+		if err != nil {
+			return nil, err
+		}
+
+		// a pair counts once per source however many of its files have it
+		seen := make(map[entities.TagValuePair]bool)
+		for _, file := range files {
+			fileTags, err := store.FileTagsByFileId(file.Id, true)
+			if err != nil {
+				return nil, fmt.Errorf("%v: could not retrieve filetags: %v", file.Path(), err)
+			}
+
+			for _, fileTag := range fileTags {
+				pair := entities.TagValuePair{fileTag.TagId, fileTag.ValueId}
+				if seen[pair] {
+					continue
+				}
+				seen[pair] = true
+
+				if counts[pair] == 0 {
+					tagValuePairs = append(tagValuePairs, pair)
+				}
+				counts[pair]++
+			}
+		}
+	}
+
+	filtered := make([]entities.TagValuePair, 0, len(tagValuePairs))
+	for _, pair := range tagValuePairs {
+		switch {
+		case intersect && counts[pair] < len(fromPaths):
+		case len(onlyTagIds) > 0 && !containsTagId(onlyTagIds, pair.TagId):
+		case containsTagId(exceptTagIds, pair.TagId):
+		default:
+			filtered = append(filtered, pair)
+		}
 	}
 
+	return filtered, nil
+}
+
+// Retrieves the file to copy tags from or, when aggregating, the tagged files
+// under it too.
+func sourceFiles(store *storage.Storage, fromPath string, aggregate bool) (entities.Files, error) {
+	files := make(entities.Files, 0, 1)
+
 	file, err := store.FileByPath(fromPath)
 	if err != nil {
-		return fmt.Errorf("%v: could not retrieve file: %v", fromPath, err)
+		return nil, fmt.Errorf("%v: could not retrieve file: %v", fromPath, err)
 	}
-	if file == nil {
-		return fmt.Errorf("%v: path is not tagged")
+	if file != nil {
+		files = append(files, file)
 	}
 
-	fileTags, err := store.FileTagsByFileId(file.Id, true)
+	if aggregate {
+		childFiles, err := store.FilesByDirectory(fromPath)
+		if err != nil {
+			return nil, fmt.Errorf("%v: could not retrieve files for directory: %v", fromPath, err)
+		}
+
+		files = append(files, childFiles...)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%v: path is not tagged", fromPath)
+	}
+
+	return files, nil
+}
+
+// Looks up the tags named, separated by commas, by the option.
+func tagIdsOption(store *storage.Storage, options Options, name string) (entities.TagIds, error) {
+	tagIds := make(entities.TagIds, 0, 10)
+	for _, option := range options.GetAll(name) {
+		for _, tagName := range strings.Split(option.Argument, ",") {
+			tagName = strings.TrimSpace(tagName)
+			if tagName == "" {
+				continue
+			}
+
+			tag, err := store.TagByName(tagName)
+			if err != nil {
+				return nil, fmt.Errorf("could not retrieve tag '%v': %v", tagName, err)
+			}
+			if tag == nil {
+				return nil, noSuchTagError{tagName}
+			}
+
+			tagIds = append(tagIds, tag.Id)
+		}
+	}
+
+	return tagIds, nil
+}
+
+func containsTagId(tagIds entities.TagIds, tagId entities.TagId) bool {
+	for _, id := range tagIds {
+		if id == tagId {
+			return true
+		}
+	}
+
+	return false
+}
+
+func tagFrom(store *storage.Storage, tagValuePairs []entities.TagValuePair, paths []string, explicit, recursive, deferFingerprint bool, policy filesystem.SymlinkPolicy) error {
+	fingerprintAlgorithmSetting, err := store.Setting("fingerprintAlgorithm")
 	if err != nil {
-		return fmt.Errorf("%v: could not retrieve filetags: %v", fromPath, err)
+		return fmt.Errorf("could not retrieve fingerprint algorithm: %v", err)
 	}
 
-	tagValuePairs := make([]TagValuePair, len(fileTags))
-	for index, fileTag := range fileTags {
-		tagValuePairs[index] = TagValuePair{fileTag.TagId, fileTag.ValueId}
+	hardLinks, err := store.SettingAsBool("tagHardLinks")
+	if err != nil {
+		return err
+	}
+
+	paths, err = withSidecars(store, paths)
+	if err != nil {
+		return err
 	}
 
 	wereErrors := false
+	trail := filesystem.NewDirectoryTrail(policy)
 	for _, path := range paths {
-		if err := tagPath(store, path, tagValuePairs, explicit, recursive, fingerprintAlgorithmSetting.Value); err != nil {
+		if err := tagPath(store, trail, path, tagValuePairs, explicit, recursive, hardLinks, fingerprintAlgorithmSetting.Value, deferFingerprint); err != nil {
 			switch {
 			case os.IsPermission(err):
 				log.Warnf("%v: permisison denied", path)
@@ -266,6 +464,8 @@ func tagFrom(store *storage.Storage, fromPath string, paths []string, explicit,
 			case os.IsNotExist(err):
 				log.Warnf("%v: no such file", path)
 				wereErrors = true
+			case err == errBlank:
+				wereErrors = true
 			default:
 				return fmt.Errorf("%v: could not stat file: %v", path, err)
 			}
@@ -279,23 +479,44 @@ func tagFrom(store *storage.Storage, fromPath string, paths []string, explicit,
 	return nil
 }
 
-func tagPath(store *storage.Storage, path string, tagValuePairs []TagValuePair, explicit, recursive bool, fingerprintAlgorithm string) error {
+func tagPath(store *storage.Storage, trail *filesystem.DirectoryTrail, path string, tagValuePairs []entities.TagValuePair, explicit, recursive, hardLinks bool, fingerprintAlgorithm string, deferFingerprint bool) error {
+	if _path.IsUrl(path) {
+		return tagUrl(store, path, tagValuePairs, explicit, fingerprintAlgorithm)
+	}
+
+	absPath, stat, file, err := lookupPath(store, trail, path)
+	if err != nil {
+		return err
+	}
+	if file == nil {
+		file, err = addFile(store, absPath, stat, fingerprintAlgorithm, trail.Policy, deferFingerprint)
+		if err != nil {
+			return fmt.Errorf("%v: could not add file: %v", path, err)
+		}
+	}
+
+	return tagFile(store, trail, path, stat, file, tagValuePairs, explicit, recursive, hardLinks, fingerprintAlgorithm, deferFingerprint)
+}
+
+// Stats the path and retrieves its file from the database, which is nil if
+// the path is not yet tagged.
+func lookupPath(store *storage.Storage, trail *filesystem.DirectoryTrail, path string) (string, os.FileInfo, *entities.File, error) {
 	absPath, err := filepath.Abs(path)
 	if err != nil {
-		return fmt.Errorf("%v: could not get absolute path: %v", path, err)
+		return "", nil, nil, fmt.Errorf("%v: could not get absolute path: %v", path, err)
 	}
 
-	stat, err := os.Stat(path)
+	stat, err := trail.Policy.Stat(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			stat, err = os.Lstat(path)
 			if err != nil {
-				return err
+				return "", nil, nil, err
 			}
 
 			log.Warnf("%v: tagging broken symbolic link", path)
 		} else {
-			return err
+			return "", nil, nil, err
 		}
 	}
 
@@ -303,12 +524,22 @@ func tagPath(store *storage.Storage, path string, tagValuePairs []TagValuePair,
 
 	file, err := store.FileByPath(absPath)
 	if err != nil {
-		return fmt.Errorf("%v: could not retrieve file: %v", path, err)
+		return "", nil, nil, fmt.Errorf("%v: could not retrieve file: %v", path, err)
 	}
-	if file == nil {
-		file, err = addFile(store, absPath, stat.ModTime(), uint(stat.Size()), stat.IsDir(), fingerprintAlgorithm)
-		if err != nil {
-			return fmt.Errorf("%v: could not add file: %v", path, err)
+
+	return absPath, stat, file, nil
+}
+
+func tagFile(store *storage.Storage, trail *filesystem.DirectoryTrail, path string, stat os.FileInfo, file *entities.File, tagValuePairs []entities.TagValuePair, explicit, recursive, hardLinks bool, fingerprintAlgorithm string, deferFingerprint bool) error {
+	var err error
+
+	if err := recordInode(store, file.Id, stat); err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+
+	if hardLinks {
+		if err := tagHardLinks(store, file, tagValuePairs); err != nil {
+			return err
 		}
 	}
 
@@ -319,16 +550,14 @@ func tagPath(store *storage.Storage, path string, tagValuePairs []TagValuePair,
 		}
 	}
 
-	log.Infof(2, "%v: applying tags.", path)
-
-	for _, tagValuePair := range tagValuePairs {
-		if _, err = store.AddFileTag(file.Id, tagValuePair.TagId, tagValuePair.ValueId); err != nil {
-			return fmt.Errorf("%v: could not apply tags: %v", file.Path(), err)
-		}
+	if err := applyTags(store, path, file, tagValuePairs); err != nil {
+		return err
 	}
 
 	if recursive && stat.IsDir() {
-		if err = tagRecursively(store, path, tagValuePairs, explicit, fingerprintAlgorithm); err != nil {
+		if err = trail.Descend(path, func() error {
+			return tagRecursively(store, trail, path, tagValuePairs, explicit, hardLinks, fingerprintAlgorithm, deferFingerprint)
+		}); err != nil {
 			return err
 		}
 	}
@@ -336,7 +565,29 @@ func tagPath(store *storage.Storage, path string, tagValuePairs []TagValuePair,
 	return nil
 }
 
-func tagRecursively(store *storage.Storage, path string, tagValuePairs []TagValuePair, explicit bool, fingerprintAlgorithm string) error {
+// Applies the tags to the file. A file that would exceed a quota is left
+// untagged, with a warning.
+func applyTags(store *storage.Storage, path string, file *entities.File, tagValuePairs []entities.TagValuePair) error {
+	log.Infof(2, "%v: applying tags.", path)
+
+	if _, err := store.AddFileTags(file.Id, tagValuePairs); err != nil {
+		if warning := quotaWarning(err); warning != "" {
+			log.Warnf("%v: %v", path, warning)
+
+			if err := store.DeleteFileIfUntagged(file.Id); err != nil {
+				return fmt.Errorf("%v: could not remove file: %v", path, err)
+			}
+
+			return errBlank
+		}
+
+		return fmt.Errorf("%v: could not apply tags: %v", file.Path(), err)
+	}
+
+	return nil
+}
+
+func tagRecursively(store *storage.Storage, trail *filesystem.DirectoryTrail, path string, tagValuePairs []entities.TagValuePair, explicit, hardLinks bool, fingerprintAlgorithm string, deferFingerprint bool) error {
 	osFile, err := os.Open(path)
 	if err != nil {
 		return fmt.Errorf("%v: could not open path: %v", path, err)
@@ -348,17 +599,71 @@ func tagRecursively(store *storage.Storage, path string, tagValuePairs []TagValu
 		return fmt.Errorf("%v: could not retrieve directory contents: %v", path, err)
 	}
 
-	for _, childName := range childNames {
-		childPath := filepath.Join(path, childName)
+	childPaths := make([]string, len(childNames))
+	childStats := make([]os.FileInfo, len(childNames))
+	childFiles := make(entities.Files, len(childNames))
+	newFiles := make(entities.Files, 0, len(childNames))
+	for index, childName := range childNames {
+		childPaths[index] = filepath.Join(path, childName)
 
-		if err = tagPath(store, childPath, tagValuePairs, explicit, true, fingerprintAlgorithm); err != nil {
+		absPath, stat, file, err := lookupPath(store, trail, childPaths[index])
+		if err != nil {
 			return err
 		}
+		if file == nil {
+			if file, err = newFile(absPath, stat, fingerprintAlgorithm, trail.Policy, deferFingerprint); err != nil {
+				return err
+			}
+
+			newFiles = append(newFiles, file)
+		}
+
+		childStats[index] = stat
+		childFiles[index] = file
+	}
+
+	// the untagged children are added together, which replaces each of the
+	// new file entries with the added file
+	if len(newFiles) > 0 {
+		log.Infof(2, "%v: adding %v files.", path, len(newFiles))
+
+		if err := addFiles(store, newFiles, deferFingerprint); err != nil {
+			return fmt.Errorf("%v: could not add files: %v", path, err)
+		}
+	}
+
+	// carry on past the files that would exceed a quota
+	exceeded := false
+	for index, childPath := range childPaths {
+		if err = tagFile(store, trail, childPath, childStats[index], childFiles[index], tagValuePairs, explicit, true, hardLinks, fingerprintAlgorithm, deferFingerprint); err != nil {
+			if err != errBlank {
+				return err
+			}
+
+			exceeded = true
+		}
+	}
+
+	if exceeded {
+		return errBlank
 	}
 
 	return nil
 }
 
+// The warning for an error that tagging would exceed a quota, or empty if it
+// is some other error.
+func quotaWarning(err error) string {
+	switch typedErr := err.(type) {
+	case storage.TagQuotaError:
+		return fmt.Sprintf("tag '%v' may not be applied to more than %v files: use --force to exceed the limit.", typedErr.Name, typedErr.Limit)
+	case storage.FileQuotaError:
+		return fmt.Sprintf("no more than %v tags may be applied: use --force to exceed the limit.", typedErr.Limit)
+	default:
+		return ""
+	}
+}
+
 func getTag(store *storage.Storage, tagName string) (*entities.Tag, error) {
 	tag, err := store.TagByName(tagName)
 	if err != nil {
@@ -399,25 +704,113 @@ func createValue(store *storage.Storage, valueName string) (*entities.Value, err
 	return value, nil
 }
 
-func addFile(store *storage.Storage, path string, modTime time.Time, size uint, isDir bool, fingerprintAlgorithm string) (*entities.File, error) {
+// Creates, but does not add, the file for the path. Where the fingerprint is
+// deferred a regular file is left without one, to be fingerprinted later.
+func newFile(path string, stat os.FileInfo, fingerprintAlgorithm string, policy filesystem.SymlinkPolicy, deferFingerprint bool) (*entities.File, error) {
+	file := &entities.File{Directory: filepath.Dir(path), Name: filepath.Base(path), ModTime: stat.ModTime(), Size: stat.Size(), IsDir: stat.IsDir()}
+
+	if deferFingerprint && stat.Mode().IsRegular() && !policy.StoresAsLink(path) {
+		return file, nil
+	}
+
 	log.Infof(2, "%v: creating fingerprint", path)
 
-	fingerprint, err := fingerprint.Create(path, fingerprintAlgorithm)
+	fp, err := createFingerprint(path, fingerprintAlgorithm, policy)
 	if err != nil {
 		return nil, fmt.Errorf("%v: could not create fingerprint: %v", path, err)
 	}
 
+	file.Fingerprint = fp
+
+	return file, nil
+}
+
+// Adds the files to the database at once, updating each entry with its
+// identifier.
+func addFiles(store *storage.Storage, files entities.Files, deferFingerprint bool) error {
+	addedFiles, err := store.AddFiles(files)
+	if err != nil {
+		return err
+	}
+
+	for index, file := range addedFiles {
+		if err := completeFingerprints(store, file, deferFingerprint); err != nil {
+			return err
+		}
+
+		*files[index] = *file
+	}
+
+	return nil
+}
+
+func addFile(store *storage.Storage, path string, stat os.FileInfo, fingerprintAlgorithm string, policy filesystem.SymlinkPolicy, deferFingerprint bool) (*entities.File, error) {
+	file, err := newFile(path, stat, fingerprintAlgorithm, policy, deferFingerprint)
+	if err != nil {
+		return nil, err
+	}
+
 	log.Infof(2, "%v: adding file.", path)
 
-	file, err := store.AddFile(path, fingerprint, modTime, int64(size), isDir)
+	file, err = store.AddFile(path, file.Fingerprint, file.ModTime, file.Size, file.IsDir)
 	if err != nil {
 		return nil, fmt.Errorf("%v: could not add file to database: %v", path, err)
 	}
 
+	if err := completeFingerprints(store, file, deferFingerprint); err != nil {
+		return nil, err
+	}
+
 	return file, nil
 }
 
-func removeAlreadyAppliedTagValuePairs(store *storage.Storage, tagValuePairs []TagValuePair, file *entities.File) ([]TagValuePair, error) {
+// Calculates the file's additional fingerprints or, if its fingerprint was
+// deferred, queues it for 'fingerprint --pending'.
+func completeFingerprints(store *storage.Storage, file *entities.File, deferFingerprint bool) error {
+	if deferFingerprint && !file.IsDir && file.Fingerprint == fingerprint.EMPTY {
+		log.Infof(2, "%v: deferring fingerprint.", file.Path())
+
+		return store.AddPendingFingerprint(file.Id)
+	}
+
+	return store.UpdateExtraFingerprints(file)
+}
+
+// Records the file's device and inode numbers so that other hard links to it
+// may be identified.
+func recordInode(store *storage.Storage, fileId entities.FileId, stat os.FileInfo) error {
+	dev, inode, ok := filesystem.Inode(stat)
+	if !ok {
+		return nil
+	}
+
+	if err := store.UpdateFileInode(fileId, dev, inode); err != nil {
+		return fmt.Errorf("could not record inode: %v", err)
+	}
+
+	return nil
+}
+
+// Applies the tags to the other files in the database that are hard links to
+// the same file, so that they are tagged as one.
+func tagHardLinks(store *storage.Storage, file *entities.File, tagValuePairs []entities.TagValuePair) error {
+	linkedFiles, err := store.HardLinkedFiles(file)
+	if err != nil {
+		return fmt.Errorf("%v: could not retrieve hard links: %v", file.Path(), err)
+	}
+
+	for _, linkedFile := range linkedFiles {
+		log.Infof(2, "%v: applying tags to hard link.", linkedFile.Path())
+
+		if _, err = store.AddFileTags(linkedFile.Id, tagValuePairs); err != nil {
+			return fmt.Errorf("%v: could not apply tags: %v", linkedFile.Path(), err)
+		}
+	}
+
+	return nil
+}
+
+func removeAlreadyAppliedTagValuePairs(store *storage.Storage, tagValuePairs []entities.TagValuePair, file *entities.File) ([]entities.TagValuePair, error) {
 	log.Infof(2, "%v: determining existing file-tags", file.Path())
 
 	existingFileTags, err := store.FileTagsByFileId(file.Id, false)
@@ -432,14 +825,19 @@ func removeAlreadyAppliedTagValuePairs(store *storage.Storage, tagValuePairs []T
 		tagIds[index] = tagValuePair.TagId
 	}
 
-	newlyImpliedTags, err := store.ImplicationsForTags(tagIds...)
+	implications, err := store.ImplicationsForTags(tagIds...)
 	if err != nil {
 		return nil, fmt.Errorf("%v: could not determine implied tags: %v", file.Path(), err)
 	}
 
+	newlyImpliedTags := make(entities.Implications, 0, len(implications))
+	for _, tagValuePair := range tagValuePairs {
+		newlyImpliedTags = append(newlyImpliedTags, implications.Holding(tagValuePair.TagId, tagValuePair.ValueId)...)
+	}
+
 	log.Infof(2, "%v: revising set of tags to apply", file.Path())
 
-	revisedTagValuePairs := make([]TagValuePair, 0, len(tagValuePairs))
+	revisedTagValuePairs := make([]entities.TagValuePair, 0, len(tagValuePairs))
 	for _, tagValuePair := range tagValuePairs {
 		if existingFileTags.Contains(tagValuePair.TagId, tagValuePair.ValueId) {
 			continue
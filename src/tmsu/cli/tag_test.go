@@ -18,8 +18,16 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package cli
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
+	"tmsu/common/config"
 	"tmsu/storage"
 )
 
@@ -258,3 +266,702 @@ func TestTagMultipleFiles(test *testing.T) {
 }
 
 //TODO recursive
+
+func TestTagConfigSettings(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	store.Config = config.Settings{"autoCreateTags": "no", "autoCreateValues": "no"}
+
+	if _, err := store.Db.Exec("INSERT INTO setting (name, value) VALUES ('autoCreateValues', 'yes')"); err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddTag("apple"); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := createFile("/tmp/tmsu/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	// test
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "apple=red", "banana"}); err != errBlank {
+		test.Fatalf("Expected tag creation to be refused but got %v", err)
+	}
+
+	// validate
+
+	tags, err := store.Tags()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(tags) != 1 {
+		test.Fatalf("Expected one tag as the configuration prevents creation but are %v", len(tags))
+	}
+
+	values, err := store.Values()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(values) != 1 {
+		test.Fatalf("Expected one value as the database setting overrides the configuration but are %v", len(values))
+	}
+}
+
+func TestTagRecursiveSymlinkCycle(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/cycle/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll("/tmp/tmsu/cycle")
+
+	if err := os.Symlink("/tmp/tmsu/cycle", "/tmp/tmsu/cycle/loop"); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := TagCommand.Exec(store, Options{Option{"--recursive", "-r", "", false, ""}}, []string{"/tmp/tmsu/cycle", "apple"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	files, err := store.Files()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(files) != 3 {
+		test.Fatalf("Expected three files but are %v", len(files))
+	}
+	if files[0].Path() != "/tmp/tmsu/cycle" || files[1].Path() != "/tmp/tmsu/cycle/a" || files[2].Path() != "/tmp/tmsu/cycle/loop" {
+		test.Fatalf("Incorrect files were added.")
+	}
+}
+
+func TestTagRecursiveManyFiles(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	defer os.RemoveAll("/tmp/tmsu/many")
+	for index := 0; index < 250; index++ {
+		if err := createFile(fmt.Sprintf("/tmp/tmsu/many/%03d", index), fmt.Sprintf("%v", index)); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// test
+
+	if err := TagCommand.Exec(store, Options{Option{"--recursive", "-r", "", false, ""}}, []string{"/tmp/tmsu/many", "apple=red", "banana"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	files, err := store.Files()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(files) != 251 {
+		test.Fatalf("Expected 251 files but are %v", len(files))
+	}
+
+	for index, file := range files[1:] {
+		if file.Path() != fmt.Sprintf("/tmp/tmsu/many/%03d", index) {
+			test.Fatalf("Expected file '/tmp/tmsu/many/%03d' but was '%v'.", index, file.Path())
+		}
+		if file.Fingerprint == "" {
+			test.Fatalf("File '%v' has no fingerprint.", file.Path())
+		}
+
+		fileTags, err := store.FileTagsByFileId(file.Id, true)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if len(fileTags) != 2 {
+			test.Fatalf("File '%v' has %v tags but expected 2.", file.Path(), len(fileTags))
+		}
+	}
+}
+
+func TestTagSymlinkPolicyLink(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/target", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/target")
+
+	if err := os.Symlink("/tmp/tmsu/target", "/tmp/tmsu/link"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/link")
+
+	// test
+
+	if err := TagCommand.Exec(store, Options{Option{"--symlinks", "", "", true, "link"}}, []string{"/tmp/tmsu/link", "apple"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	file, err := store.FileByPath("/tmp/tmsu/link")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if file == nil {
+		test.Fatalf("Link was not added.")
+	}
+	if file.Fingerprint != "/tmp/tmsu/target" {
+		test.Fatalf("Expected link to be identified by its target but fingerprint is '%v'.", file.Fingerprint)
+	}
+}
+
+func TestTagHardLinks(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.UpdateSetting("tagHardLinks", "yes"); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := createFile("/tmp/tmsu/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/a")
+
+	if err := os.Link("/tmp/tmsu/a", "/tmp/tmsu/b"); err != nil {
+		test.Fatal(err)
+	}
+	defer os.Remove("/tmp/tmsu/b")
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/b", "banana"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "apple"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	file, err := store.FileByPath("/tmp/tmsu/b")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	apple, err := store.TagByName("apple")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	banana, err := store.TagByName("banana")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	expectTags(test, store, file, banana, apple)
+}
+
+func TestTagQuotas(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.UpdateSetting("tagFileLimit", "1"); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := store.UpdateSetting("fileTagLimit", "2"); err != nil {
+		test.Fatal(err)
+	}
+
+	for _, path := range []string{"/tmp/tmsu/a", "/tmp/tmsu/b"} {
+		if err := createFile(path, path); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "apple", "banana"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "cherry"}); err != errBlank {
+		test.Fatalf("Expected the file tag limit to be enforced but error was: %v", err)
+	}
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/b", "apple"}); err != errBlank {
+		test.Fatalf("Expected the tag file limit to be enforced but error was: %v", err)
+	}
+
+	force := Options{Option{"--force", "", "", false, ""}}
+	if err := TagCommand.Exec(store, force, []string{"/tmp/tmsu/b", "apple"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	fileA, err := store.FileByPath("/tmp/tmsu/a")
+	if err != nil {
+		test.Fatal(err)
+	}
+	fileB, err := store.FileByPath("/tmp/tmsu/b")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	apple, err := store.TagByName("apple")
+	if err != nil {
+		test.Fatal(err)
+	}
+	banana, err := store.TagByName("banana")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	expectTags(test, store, fileA, apple, banana)
+	expectTags(test, store, fileB, apple)
+}
+
+func TestTagFromMultipleSourcesFiltered(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, path := range []string{"/tmp/tmsu/a", "/tmp/tmsu/b", "/tmp/tmsu/c", "/tmp/tmsu/d"} {
+		if err := createFile(path, path); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "apple", "banana", "year=2015"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/b", "apple", "cherry", "year=2015"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	intersectOptions := Options{Option{"--from", "-f", "", true, "/tmp/tmsu/a"},
+		Option{"--from", "-f", "", true, "/tmp/tmsu/b"},
+		Option{"--intersect", "", "", false, ""},
+		Option{"--except", "", "", true, "year"}}
+	if err := TagCommand.Exec(store, intersectOptions, []string{"/tmp/tmsu/c"}); err != nil {
+		test.Fatal(err)
+	}
+
+	unionOptions := Options{Option{"--from", "-f", "", true, "/tmp/tmsu/a"},
+		Option{"--from", "-f", "", true, "/tmp/tmsu/b"},
+		Option{"--only", "", "", true, "banana,cherry"}}
+	if err := TagCommand.Exec(store, unionOptions, []string{"/tmp/tmsu/d"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	if err := TagsCommand.Exec(store, Options{}, []string{"/tmp/tmsu/c", "/tmp/tmsu/d"}); err != nil {
+		test.Fatal(err)
+	}
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/tmsu/c: apple\n/tmp/tmsu/d: banana cherry\n", string(bytes))
+}
+
+func TestTagFromAggregatedDirectory(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := os.MkdirAll("/tmp/tmsu/album", 0755); err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll("/tmp/tmsu/album")
+
+	for _, path := range []string{"/tmp/tmsu/album/a", "/tmp/tmsu/album/b", "/tmp/tmsu/c"} {
+		if err := createFile(path, path); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/album/a", "music", "year=2015"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/album/b", "music", "live"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	options := Options{Option{"--from", "-f", "", true, "/tmp/tmsu/album"},
+		Option{"--aggregate", "", "", false, ""}}
+	if err := TagCommand.Exec(store, options, []string{"/tmp/tmsu/c"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	if err := TagsCommand.Exec(store, Options{}, []string{"/tmp/tmsu/c"}); err != nil {
+		test.Fatal(err)
+	}
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/tmsu/c: live music year=2015\n", string(bytes))
+}
+
+func TestTagSidecars(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.UpdateSetting("sidecarGroups", "*.cr2,*.jpg,*.xmp"); err != nil {
+		test.Fatal(err)
+	}
+
+	for _, path := range []string{"/tmp/tmsu/IMG_0001.CR2", "/tmp/tmsu/IMG_0001.jpg", "/tmp/tmsu/IMG_0001.xmp", "/tmp/tmsu/IMG_0002.jpg"} {
+		if err := createFile(path, path); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+	}
+
+	// test
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/IMG_0001.CR2", "mountain"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	mountain, err := store.TagByName("mountain")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	for _, path := range []string{"/tmp/tmsu/IMG_0001.CR2", "/tmp/tmsu/IMG_0001.jpg", "/tmp/tmsu/IMG_0001.xmp"} {
+		file, err := store.FileByPath(path)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if file == nil {
+			test.Fatalf("%v: file was not tagged", path)
+		}
+
+		expectTags(test, store, file, mountain)
+	}
+
+	file, err := store.FileByPath("/tmp/tmsu/IMG_0002.jpg")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if file != nil {
+		test.Fatal("file outside of the group was tagged")
+	}
+}
+
+func TestTagModTimeTags(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := redirectStreams(); err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	modTimes := map[string]time.Time{"/tmp/tmsu/a": time.Date(2015, 6, 15, 12, 0, 0, 0, time.Local),
+		"/tmp/tmsu/b": time.Date(2016, 1, 2, 12, 0, 0, 0, time.Local)}
+	for path, modTime := range modTimes {
+		if err := createFile(path, path); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "jazz"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := ConfigCommand.Exec(store, Options{}, []string{"modTimeTags=year,month"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/b", "rock"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	expectFileTagNames(test, store, "/tmp/tmsu/a", "jazz mtime-month=06 mtime-year=2015")
+	expectFileTagNames(test, store, "/tmp/tmsu/b", "mtime-month=01 mtime-year=2016 rock")
+
+	// test
+
+	if err := UntagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/b", "rock"}); err != nil {
+		test.Fatal(err)
+	}
+
+	modTime := time.Date(2014, 3, 1, 12, 0, 0, 0, time.Local)
+	if err := os.Chtimes("/tmp/tmsu/a", modTime, modTime); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := RepairCommand.Exec(store, Options{}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	file, err := store.FileByPath("/tmp/tmsu/b")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if file != nil {
+		test.Fatal("Expected file with only modification time tags to be removed.")
+	}
+
+	expectFileTagNames(test, store, "/tmp/tmsu/a", "jazz mtime-month=03 mtime-year=2014")
+
+	// test
+
+	if err := ConfigCommand.Exec(store, Options{Option{"--unset", "-u", "", false, ""}}, []string{"modTimeTags"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	expectFileTagNames(test, store, "/tmp/tmsu/a", "jazz")
+}
+
+func expectFileTagNames(test *testing.T, store *storage.Storage, path, expected string) {
+	file, err := store.FileByPath(path)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if file == nil {
+		test.Fatalf("%v: file is not tagged.", path)
+	}
+
+	tagNames, err := tagNamesForFile(store, file.Id, true, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if actual := strings.Join(tagNames, " "); actual != expected {
+		test.Fatalf("%v: expected tags '%v' but were '%v'.", path, expected, actual)
+	}
+}
+
+func TestTagUrl(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	modTime := time.Date(2015, time.March, 4, 12, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+		fmt.Fprint(writer, "They were the footprints of a giagantic hound.")
+	}))
+	defer server.Close()
+
+	url := server.URL + "/hound.txt"
+
+	// test
+
+	if err := TagCommand.Exec(store, Options{}, []string{url, "hound"}); err != nil {
+		test.Fatal(err)
+	}
+	if err := TagCommand.Exec(store, Options{}, []string{"s3://bucket/photos/a.jpg", "hound"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	file, err := store.FileByPath(url)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if file == nil {
+		test.Fatalf("%v: file was not added", url)
+	}
+	if file.Fingerprint != "87d74123749a45e4c4e5e9053986d7ae878268a8e301d1b8125791517c0d39bf" {
+		test.Fatalf("%v: incorrect fingerprint '%v'", url, file.Fingerprint)
+	}
+	if !file.ModTime.Equal(modTime) {
+		test.Fatalf("%v: expected modification time %v but was %v", url, modTime, file.ModTime)
+	}
+
+	file, err = store.FileByPath("s3://bucket/photos/a.jpg")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if file == nil {
+		test.Fatalf("s3://bucket/photos/a.jpg: file was not added")
+	}
+	if file.Fingerprint != "" {
+		test.Fatalf("s3://bucket/photos/a.jpg: expected no fingerprint but was '%v'", file.Fingerprint)
+	}
+
+	if err := FilesCommand.Exec(store, Options{}, []string{"hound"}); err != nil {
+		test.Fatal(err)
+	}
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, url+"\ns3://bucket/photos/a.jpg\n", string(bytes))
+}
+
+func TestFetchUrlAbandonedWithContext(test *testing.T) {
+	// set-up
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		select {
+		case <-release:
+		case <-request.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// test
+
+	started := time.Now()
+	_, _, _, err := fetchUrl(ctx, server.URL+"/stalled.txt", "SHA256")
+
+	// validate
+
+	if err == nil {
+		test.Fatal("Expected the stalled fetch to fail.")
+	}
+	if time.Since(started) > 5*time.Second {
+		test.Fatalf("Fetch was not abandoned promptly: %v.", time.Since(started))
+	}
+}
@@ -20,9 +20,9 @@ package cli
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 	"strconv"
 	"tmsu/common/log"
+	_path "tmsu/common/path"
 	"tmsu/common/terminal"
 	"tmsu/common/terminal/ansi"
 	"tmsu/entities"
@@ -35,20 +35,26 @@ var TagsCommand = Command{
 	Usages:   []string{"tmsu tags [OPTION]... [FILE]..."},
 	Description: `Lists the tags applied to FILEs. If no FILE is specified then all tags in the database are listed.
 
+When the output is a terminal the tags are listed in columns, the width of which is chosen to fit the terminal. The --columns option lists the tags in columns regardless, using the width given by the COLUMNS environment variable if the output is not a terminal.
+
 When color is turned on, tags are shown in the following colors:
 
   Normal  An explicitly applied (regular) tag
   $CYANCyan$RESET    Tag implied by other tags
   $YELLOWYellow$RESET  Tag is both explicitly applied and implied by other tags
 
+Tag values are shown in $GREENgreen$RESET and file paths in the normal color.
+
 See the 'imply' subcommand for more information on implied tags.`,
 	Examples: []string{"$ tmsu tags\nmp3  music  opera",
 		"$ tmsu tags tralala.mp3\nmp3  music  opera",
 		"$ tmsu tags tralala.mp3 boom.mp3\n./tralala.mp3: mp3 music opera\n./boom.mp3: mp3 music drum-n-bass",
-		"$ tmsu tags --count tralala.mp3"},
+		"$ tmsu tags --count tralala.mp3",
+		"$ tmsu tags --columns --color=always | less -R"},
 	Options: Options{{"--count", "-c", "lists the number of tags rather than their names", false, ""},
 		{"", "-1", "list one tag per line", false, ""},
-		{"--explicit", "-e", "do not show implied tags", false, ""}},
+		{"--explicit", "-e", "do not show implied tags", false, ""},
+		{"--columns", "-C", "list the tags in columns even if not a terminal", false, ""}},
 	Exec: tagsExec,
 }
 
@@ -57,32 +63,26 @@ func tagsExec(store *storage.Storage, options Options, args []string) error {
 	onePerLine := options.HasOption("-1")
 	explicitOnly := options.HasOption("--explicit")
 
-	var colour bool
-	if options.HasOption("--color") {
-		when := options.Get("--color").Argument
-		switch when {
-		case "auto":
-			colour = terminal.Colour() && terminal.Width() > 0
-		case "":
-		case "always":
-			colour = true
-		case "never":
-			colour = false
-		default:
-			return fmt.Errorf("invalid argument '%v' for '--color'", when)
-		}
-	} else {
-		colour = terminal.Colour() && terminal.Width() > 0
+	columns := options.HasOption("--columns")
+
+	width := terminal.Width()
+	if columns {
+		width = terminal.ColumnWidth()
+	}
+
+	colour, err := useColour(options)
+	if err != nil {
+		return err
 	}
 
 	if len(args) == 0 {
-		return listAllTags(store, showCount, onePerLine, colour)
+		return listAllTags(store, showCount, onePerLine, colour, width)
 	}
 
-	return listTagsForPaths(store, args, showCount, onePerLine, explicitOnly, colour)
+	return listTagsForPaths(store, args, showCount, onePerLine, explicitOnly, colour, columns, width)
 }
 
-func listAllTags(store *storage.Storage, showCount, onePerLine, colour bool) error {
+func listAllTags(store *storage.Storage, showCount, onePerLine, colour bool, width int) error {
 	log.Info(2, "retrieving all tags.")
 
 	if showCount {
@@ -108,19 +108,19 @@ func listAllTags(store *storage.Storage, showCount, onePerLine, colour bool) err
 				fmt.Println(tagName)
 			}
 		} else {
-			terminal.PrintColumns(tagNames)
+			terminal.PrintColumnsWidth(tagNames, width)
 		}
 	}
 
 	return nil
 }
 
-func listTagsForPaths(store *storage.Storage, paths []string, showCount, onePerLine, explicitOnly, colour bool) error {
+func listTagsForPaths(store *storage.Storage, paths []string, showCount, onePerLine, explicitOnly, colour, columns bool, width int) error {
 	wereErrors := false
-	printPath := len(paths) > 1 || terminal.Width() == 0
+	printPath := len(paths) > 1 || width == 0
 
 	for index, path := range paths {
-        absPath, err := filepath.Abs(path)
+        absPath, err := _path.Abs(path)
         if err != nil {
             return err
         }
@@ -176,18 +176,23 @@ func listTagsForPaths(store *storage.Storage, paths []string, showCount, onePerL
 			for _, tagName := range tagNames {
 				fmt.Println(tagName)
 			}
-		default:
-			if printPath {
-				fmt.Print(path + ":")
+		case printPath && columns:
+			if index > 0 {
+				fmt.Println()
+			}
 
-				for _, tagName := range tagNames {
-					fmt.Print(" " + tagName)
-				}
+			fmt.Println(path + ":")
+			terminal.PrintColumnsWidth(tagNames, width)
+		case printPath:
+			fmt.Print(path + ":")
 
-				fmt.Println()
-			} else {
-				terminal.PrintColumns(tagNames)
+			for _, tagName := range tagNames {
+				fmt.Print(" " + tagName)
 			}
+
+			fmt.Println()
+		default:
+			terminal.PrintColumnsWidth(tagNames, width)
 		}
 	}
 
@@ -227,7 +232,11 @@ func tagNamesForFile(store *storage.Storage, fileId entities.FileId, explicitOnl
 				return nil, fmt.Errorf("value '%v' does not exist", fileTag.ValueId)
 			}
 
-			tagName = tag.Name + "=" + value.Name
+			if colour {
+				tagName = tag.Name + "=" + ansi.Green(value.Name)
+			} else {
+				tagName = tag.Name + "=" + value.Name
+			}
 		}
 
 		if colour {
@@ -230,11 +230,11 @@ func TestImpliedTags(test *testing.T) {
 		test.Fatal(err)
 	}
 
-	if err := store.AddImplication(appleTag.Id, fruitTag.Id); err != nil {
+	if err := store.AddImplication(appleTag.Id, 0, fruitTag.Id); err != nil {
 		test.Fatal(err)
 	}
 
-	if err := store.AddImplication(fruitTag.Id, foodTag.Id); err != nil {
+	if err := store.AddImplication(fruitTag.Id, 0, foodTag.Id); err != nil {
 		test.Fatal(err)
 	}
 
@@ -256,3 +256,97 @@ func TestImpliedTags(test *testing.T) {
 	bytes, err := ioutil.ReadAll(outFile)
 	compareOutput(test, "/tmp/tmsu/a: apple food fruit\n", string(bytes))
 }
+
+func TestTagsInColumns(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	columns := os.Getenv("COLUMNS")
+	os.Setenv("COLUMNS", "20")
+	defer os.Setenv("COLUMNS", columns)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, tagName := range []string{"apple", "banana", "cherry", "date", "elderberry"} {
+		if _, err := store.AddTag(tagName); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// test
+
+	if err := TagsCommand.Exec(store, Options{Option{"--columns", "-C", "", false, ""}}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// verify
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "apple   date\nbanana  elderberry\ncherry\n", string(bytes))
+}
+
+func TestTagsWithColouredValues(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	file, err := store.AddFile("/tmp/tmsu/a", fingerprint.Fingerprint("123"), time.Now(), 0, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	yearTag, err := store.AddTag("year")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	value, err := store.AddValue("2015")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	_, err = store.AddFileTag(file.Id, yearTag.Id, value.Id)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := TagsCommand.Exec(store, Options{Option{"--color", "", "", true, "always"}}, []string{"/tmp/tmsu/a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// verify
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/tmsu/a: year=\x1b[32m2015\x1b[0m\n", string(bytes))
+}
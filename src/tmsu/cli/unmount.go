@@ -1,3 +1,5 @@
+// +build !windows
+
 /*
 Copyright 2011-2015 Paul Ruane.
 
@@ -15,8 +17,6 @@ You should have received a copy of the GNU General Public License
 along with this program.  If not, see <http://www.gnu.org/licenses/>.
 */
 
-// +build !windows
-
 package cli
 
 import (
@@ -34,7 +34,11 @@ var UnmountCommand = Command{
 	Synopsis: "Unmount the virtual filesystem",
 	Usages: []string{"tmsu unmount MOUNTPOINT",
 		"tmsu unmount --all"},
-	Description: "Unmounts the virtual file-system at MOUNTPOINT.",
+	Description: `Unmounts the virtual file-system at MOUNTPOINT.
+
+With --all, every TMSU virtual file-system in the system mount table ('/proc/mounts') is unmounted.
+
+(A virtual file-system mounted with 'mount --persistent' will be mounted again at the next login unless its systemd unit is disabled.)`,
 	Options:     Options{{"--all", "-a", "unmounts all mounted TMSU file-systems", false, ""}},
 	Exec:        unmountExec,
 }
@@ -91,12 +95,17 @@ func unmountAll() error {
 		log.Info(2, "mount table is empty.")
 	}
 
+	wereErrors := false
 	for _, mount := range mt {
-		err = unmount(mount.MountPath)
-		if err != nil {
-			return err
+		if err := unmount(mount.MountPath); err != nil {
+			log.Warnf("%v: %v", mount.MountPath, err)
+			wereErrors = true
 		}
 	}
 
+	if wereErrors {
+		return errBlank
+	}
+
 	return nil
 }
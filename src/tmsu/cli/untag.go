@@ -19,9 +19,9 @@ package cli
 
 import (
 	"fmt"
-	"path/filepath"
 	"strings"
 	"tmsu/common/log"
+	_path "tmsu/common/path"
 	"tmsu/entities"
 	"tmsu/storage"
 )
@@ -32,13 +32,26 @@ var UntagCommand = Command{
 	Usages: []string{"tmsu untag [OPTION]... FILE TAG[=VALUE]...",
 		"tmsu untag [OPTION]... --all FILE...",
 		`tmsu untag [OPTION]... --tags="TAG[=VALUE]..." FILE...`},
-	Description: "Disassociates FILE with the TAGs specified.",
+	Description: `Disassociates FILE with the TAGs specified.
+
+When --recursive would affect more files than the 'untagConfirmThreshold' setting (default 100) permits, confirmation is requested first unless --yes is specified. A threshold of 0 never asks. The number of tags and files affected is reported afterwards and each removal is listed when --verbose is specified.
+
+With --with-implied, the tags that the TAGs imply are removed too, including any explicit applications of them, unless they are still implied by one of the file's remaining tags. This keeps a file's tags consistent after the implications have been changed.
+
+Tags named by the 'lockedTags' setting cannot be removed unless --force is specified.
+
+Any executable 'pre-untag' and 'post-untag' hooks are run before and after the tags are removed. (See 'tmsu help tag'.)`,
 	Examples: []string{"$ tmsu untag mountain.jpg hill county=germany",
 		"$ tmsu untag --all mountain-copy.jpg",
-		`$ tmsu untag --tags="river underwater year=2015" forest.jpg desert.jpg`},
+		`$ tmsu untag --tags="river underwater year=2015" forest.jpg desert.jpg`,
+		"$ tmsu --verbose untag --recursive --yes photos holiday",
+		"$ tmsu untag --with-implied song.mp3 mp3"},
 	Options: Options{{"--all", "-a", "strip each file of all tags", false, ""},
 		{"--tags", "-t", "the set of tags to remove", true, ""},
-		{"--recursive", "-r", "recursively remove tags from directory contents", false, ""}},
+		{"--recursive", "-r", "recursively remove tags from directory contents", false, ""},
+		{"--force", "-f", "remove locked tags", false, ""},
+		{"--yes", "-y", "do not ask for confirmation", false, ""},
+		{"--with-implied", "", "also remove the tags that were only present by implication from the TAGs", false, ""}},
 	Exec: untagExec,
 }
 
@@ -48,6 +61,9 @@ func untagExec(store *storage.Storage, options Options, args []string) error {
 	}
 
 	recursive := options.HasOption("--recursive")
+	confirmed := options.HasOption("--yes")
+	withImplied := options.HasOption("--with-implied")
+	store.Force = options.HasOption("--force")
 
 	if options.HasOption("--all") {
 		if len(args) < 1 {
@@ -56,7 +72,9 @@ func untagExec(store *storage.Storage, options Options, args []string) error {
 
 		paths := args
 
-		if err := untagPathsAll(store, paths, recursive); err != nil {
+		if err := withHooks(store, "untag", paths, nil, func() error {
+			return untagPathsAll(store, paths, recursive, confirmed)
+		}); err != nil {
 			return err
 		}
 	} else if options.HasOption("--tags") {
@@ -70,7 +88,9 @@ func untagExec(store *storage.Storage, options Options, args []string) error {
 			return fmt.Errorf("at least one file to untag must be specified")
 		}
 
-		if err := untagPaths(store, paths, tagArgs, recursive); err != nil {
+		if err := withHooks(store, "untag", paths, tagArgs, func() error {
+			return untagPaths(store, paths, tagArgs, recursive, confirmed, withImplied)
+		}); err != nil {
 			return err
 		}
 	} else {
@@ -81,7 +101,9 @@ func untagExec(store *storage.Storage, options Options, args []string) error {
 		paths := args[0:1]
 		tagArgs := args[1:]
 
-		if err := untagPaths(store, paths, tagArgs, recursive); err != nil {
+		if err := withHooks(store, "untag", paths, tagArgs, func() error {
+			return untagPaths(store, paths, tagArgs, recursive, confirmed, withImplied)
+		}); err != nil {
 			return err
 		}
 	}
@@ -89,44 +111,47 @@ func untagExec(store *storage.Storage, options Options, args []string) error {
 	return nil
 }
 
-func untagPathsAll(store *storage.Storage, paths []string, recursive bool) error {
-	wereErrors := false
-	for _, path := range paths {
-		absPath, err := filepath.Abs(path)
-		if err != nil {
-			return fmt.Errorf("%v: could not get absolute path: %v", path, err)
+func untagPathsAll(store *storage.Storage, paths []string, recursive, confirmed bool) error {
+	files, wereErrors, err := untagTargets(store, paths, recursive)
+	if err != nil {
+		return err
+	}
+
+	if recursive && !confirmed {
+		if err := confirmUntag(store, len(files)); err != nil {
+			return err
 		}
+	}
 
-		file, err := store.FileByPath(absPath)
+	var tagCount, fileCount uint
+	for _, file := range files {
+		count, err := store.FileTagCountByFileId(file.Id, true)
 		if err != nil {
-			return fmt.Errorf("%v: could not retrieve file: %v", path, err)
-		}
-		if file == nil {
-			log.Warnf("%v: file is not tagged.", path)
-			wereErrors = true
-			continue
+			return fmt.Errorf("%v: could not count file's tags: %v", file.Path(), err)
 		}
 
 		log.Infof(2, "%v: removing all tags.", file.Path())
 
 		if err := store.DeleteFileTagsByFileId(file.Id); err != nil {
+			if lockedErr, ok := err.(storage.TagLockedError); ok {
+				log.Warnf("%v: tag '%v' is locked: use --force to remove it.", file.Path(), lockedErr.Name)
+				wereErrors = true
+				continue
+			}
+
 			return fmt.Errorf("%v: could not remove file's tags: %v", file.Path(), err)
 		}
 
-		if recursive {
-			childFiles, err := store.FilesByDirectory(file.Path())
-			if err != nil {
-				return fmt.Errorf("%v: could not retrieve files for directory: %v", file.Path())
-			}
-
-			for _, childFile := range childFiles {
-				if err := store.DeleteFileTagsByFileId(childFile.Id); err != nil {
-					return fmt.Errorf("%v: could not remove file's tags: %v", childFile.Path(), err)
-				}
-			}
+		if count > 0 {
+			tagCount += count
+			fileCount++
 		}
 	}
 
+	if recursive {
+		log.Infof(1, "removed %v tags from %v files.", tagCount, fileCount)
+	}
+
 	if wereErrors {
 		return errBlank
 	}
@@ -134,38 +159,18 @@ func untagPathsAll(store *storage.Storage, paths []string, recursive bool) error
 	return nil
 }
 
-func untagPaths(store *storage.Storage, paths, tagArgs []string, recursive bool) error {
-	wereErrors := false
-
-	files := make(entities.Files, 0, len(paths))
-	for _, path := range paths {
-		absPath, err := filepath.Abs(path)
-		if err != nil {
-			return fmt.Errorf("%v: could not get absolute path: %v", path, err)
-		}
-
-		file, err := store.FileByPath(absPath)
-		if err != nil {
-			return fmt.Errorf("%v: could not retrieve file: %v", path, err)
-		}
-		if file == nil {
-			log.Warnf("%v: file is not tagged", path)
-			wereErrors = true
-			continue
-		}
-
-		files = append(files, file)
-
-		if recursive {
-			childFiles, err := store.FilesByDirectory(file.Path())
-			if err != nil {
-				return fmt.Errorf("%v: could not retrieve files for directory: %v", file.Path())
-			}
+func untagPaths(store *storage.Storage, paths, tagArgs []string, recursive, confirmed, withImplied bool) error {
+	files, wereErrors, err := untagTargets(store, paths, recursive)
+	if err != nil {
+		return err
+	}
 
-			files = append(files, childFiles...)
-		}
+	type tagValue struct {
+		tag   *entities.Tag
+		value *entities.Value
 	}
 
+	tagValues := make([]tagValue, 0, len(tagArgs))
 	for _, tagArg := range tagArgs {
 		var tagName, valueName string
 		index := strings.Index(tagArg, "=")
@@ -198,8 +203,34 @@ func untagPaths(store *storage.Storage, paths, tagArgs []string, recursive bool)
 			continue
 		}
 
+		tagValues = append(tagValues, tagValue{tag, value})
+	}
+
+	if recursive && !confirmed && len(tagValues) > 0 {
+		if err := confirmUntag(store, len(files)); err != nil {
+			return err
+		}
+	}
+
+	var tagCount uint
+	untagged := make(map[entities.FileId]bool, len(files))
+	for _, pair := range tagValues {
+		tag, value := pair.tag, pair.value
+
 		for _, file := range files {
-			if err := store.DeleteFileTag(file.Id, tag.Id, value.Id); err != nil {
+			if value.Id != 0 {
+				log.Infof(2, "%v: removing tag '%v=%v'.", file.Path(), tag.Name, value.Name)
+			} else {
+				log.Infof(2, "%v: removing tag '%v'.", file.Path(), tag.Name)
+			}
+
+			var impliedFileTags entities.FileTags
+			if withImplied {
+				impliedFileTags, err = store.DeleteFileTagWithImplied(file.Id, tag.Id, value.Id)
+			} else {
+				err = store.DeleteFileTag(file.Id, tag.Id, value.Id)
+			}
+			if err != nil {
 				switch err.(type) {
 				case storage.FileTagDoesNotExist:
 					exists, err := store.FileTagExists(file.Id, tag.Id, value.Id, false)
@@ -221,17 +252,107 @@ func untagPaths(store *storage.Storage, paths, tagArgs []string, recursive bool)
 						}
 					}
 
+					wereErrors = true
+				case storage.TagLockedError:
+					log.Warnf("%v: tag '%v' is locked: use --force to remove it.", file.Path(), err.(storage.TagLockedError).Name)
 					wereErrors = true
 				default:
 					return fmt.Errorf("%v: could not remove tag '%v', value '%v': %v", file.Path(), tag.Name, value.Name, err)
 				}
+
+				continue
 			}
+
+			for _, impliedFileTag := range impliedFileTags {
+				impliedTag, err := store.Tag(impliedFileTag.TagId)
+				if err != nil {
+					return fmt.Errorf("could not retrieve tag #%v: %v", impliedFileTag.TagId, err)
+				}
+
+				log.Infof(2, "%v: removing tag '%v' implied by '%v'.", file.Path(), impliedTag.Name, tag.Name)
+			}
+
+			tagCount += 1 + uint(len(impliedFileTags))
+			untagged[file.Id] = true
 		}
 	}
 
+	if recursive {
+		log.Infof(1, "removed %v tags from %v files.", tagCount, len(untagged))
+	}
+
 	if wereErrors {
 		return errBlank
 	}
 
 	return nil
 }
+
+// Retrieves the files at the specified paths and, if recursive, the files
+// beneath them, omitting any duplicates.
+func untagTargets(store *storage.Storage, paths []string, recursive bool) (entities.Files, bool, error) {
+	wereErrors := false
+
+	files := make(entities.Files, 0, len(paths))
+	seen := make(map[entities.FileId]bool, len(paths))
+	add := func(file *entities.File) {
+		if !seen[file.Id] {
+			seen[file.Id] = true
+			files = append(files, file)
+		}
+	}
+
+	for _, path := range paths {
+		absPath, err := _path.Abs(path)
+		if err != nil {
+			return nil, false, fmt.Errorf("%v: could not get absolute path: %v", path, err)
+		}
+
+		file, err := store.FileByPath(absPath)
+		if err != nil {
+			return nil, false, fmt.Errorf("%v: could not retrieve file: %v", path, err)
+		}
+		if file == nil {
+			log.Warnf("%v: file is not tagged.", path)
+			wereErrors = true
+			continue
+		}
+
+		add(file)
+
+		if recursive {
+			childFiles, err := store.FilesByDirectory(file.Path())
+			if err != nil {
+				return nil, false, fmt.Errorf("%v: could not retrieve files for directory: %v", file.Path(), err)
+			}
+
+			for _, childFile := range childFiles {
+				add(childFile)
+			}
+		}
+	}
+
+	return files, wereErrors, nil
+}
+
+// Asks for confirmation when more files would be affected than the
+// 'untagConfirmThreshold' setting allows.
+func confirmUntag(store *storage.Storage, fileCount int) error {
+	threshold, err := store.SettingAsUint("untagConfirmThreshold")
+	if err != nil {
+		return err
+	}
+	if threshold == 0 || uint(fileCount) <= threshold {
+		return nil
+	}
+
+	confirmed, err := confirm(fmt.Sprintf("remove tags from %v files?", fileCount))
+	if err != nil {
+		return err
+	}
+	if !confirmed {
+		return fmt.Errorf("untag cancelled: %v files would have been affected (use --yes to proceed)", fileCount)
+	}
+
+	return nil
+}
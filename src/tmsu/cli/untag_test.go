@@ -18,10 +18,13 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package cli
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 	"tmsu/common/fingerprint"
+	"tmsu/entities"
 	"tmsu/storage"
 )
 
@@ -267,3 +270,205 @@ func TestUntagAll(test *testing.T) {
 		test.Fatalf("Expected no files but are %v", len(files))
 	}
 }
+
+func TestUntagLockedTag(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.Db.Exec("INSERT INTO setting (name, value) VALUES ('lockedTags', 'archive legal-hold')"); err != nil {
+		test.Fatal(err)
+	}
+
+	fileA, err := store.AddFile("/tmp/tmsu/a", fingerprint.Fingerprint("abc123"), time.Now(), 0, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	archiveTag, err := store.AddTag("archive")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	_, err = store.AddFileTag(fileA.Id, archiveTag.Id, 0)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := UntagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "archive"}); err != errBlank {
+		test.Fatalf("Expected locked tag to be reported but got %v", err)
+	}
+
+	fileTags, err := store.FileTags()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(fileTags) != 1 {
+		test.Fatalf("Expected locked file-tag to remain but there are %v file-tags", len(fileTags))
+	}
+
+	if err := UntagCommand.Exec(store, Options{Option{"--force", "-f", "", false, ""}}, []string{"/tmp/tmsu/a", "archive"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	fileTags, err = store.FileTags()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(fileTags) != 0 {
+		test.Fatalf("Expected no file-tags but are %v", len(fileTags))
+	}
+}
+
+func TestUntagRecursiveConfirmation(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.UpdateSetting("untagConfirmThreshold", "2"); err != nil {
+		test.Fatal(err)
+	}
+
+	appleTag, err := store.AddTag("apple")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	for _, path := range []string{"/tmp/tmsu/d", "/tmp/tmsu/d/a", "/tmp/tmsu/d/b"} {
+		file, err := store.AddFile(path, fingerprint.Fingerprint("abc123"), time.Now(), 0, path == "/tmp/tmsu/d")
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		if _, err := store.AddFileTag(file.Id, appleTag.Id, 0); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+
+	answer := func(text string) {
+		path := filepath.Join(os.TempDir(), "tmsu_test.in")
+		if err := ioutil.WriteFile(path, []byte(text), 0600); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+
+		file, err := os.Open(path)
+		if err != nil {
+			test.Fatal(err)
+		}
+		os.Stdin = file
+	}
+
+	options := Options{Option{"--recursive", "-r", "", false, ""}}
+
+	// test
+
+	answer("n\n")
+	if err := UntagCommand.Exec(store, options, []string{"/tmp/tmsu/d", "apple"}); err == nil {
+		test.Fatal("Expected untag to be cancelled")
+	}
+
+	fileTags, err := store.FileTags()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(fileTags) != 3 {
+		test.Fatalf("Expected file-tags to remain but there are %v", len(fileTags))
+	}
+
+	answer("y\n")
+	if err := UntagCommand.Exec(store, options, []string{"/tmp/tmsu/d", "apple"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	fileTags, err = store.FileTags()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(fileTags) != 0 {
+		test.Fatalf("Expected no file-tags but are %v", len(fileTags))
+	}
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "tmsu: removed 3 tags from 3 files.\n", string(bytes))
+}
+
+func TestUntagWithImplied(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	file, err := store.AddFile("/tmp/tmsu/a", fingerprint.Fingerprint("abc123"), time.Now(), 0, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tags := make(map[string]*entities.Tag)
+	for _, name := range []string{"mp3", "music", "audio", "podcast", "favourite"} {
+		tag, err := store.AddTag(name)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		if _, err := store.AddFileTag(file.Id, tag.Id, 0); err != nil {
+			test.Fatal(err)
+		}
+
+		tags[name] = tag
+	}
+
+	// mp3 -> music -> audio <- podcast
+	for _, pair := range [][2]string{{"mp3", "music"}, {"music", "audio"}, {"podcast", "audio"}} {
+		if err := store.AddImplication(tags[pair[0]].Id, 0, tags[pair[1]].Id); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// test
+
+	if err := UntagCommand.Exec(store, Options{Option{"--with-implied", "", "", false, ""}}, []string{"/tmp/tmsu/a", "mp3"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	expectTags(test, store, file, tags["audio"], tags["podcast"], tags["favourite"])
+}
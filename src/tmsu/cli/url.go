@@ -0,0 +1,141 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	"tmsu/common/fingerprint"
+	"tmsu/common/log"
+	_path "tmsu/common/path"
+	"tmsu/entities"
+	"tmsu/storage"
+)
+
+// Tags the file at a URL, such as an object in cloud storage, adding it to
+// the database if it is not already present.
+func tagUrl(store *storage.Storage, url string, tagValuePairs []entities.TagValuePair, explicit bool, fingerprintAlgorithm string) error {
+	file, err := store.FileByPath(url)
+	if err != nil {
+		return fmt.Errorf("%v: could not retrieve file: %v", url, err)
+	}
+	if file == nil {
+		file, err = addUrl(store, url, fingerprintAlgorithm)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !explicit {
+		tagValuePairs, err = removeAlreadyAppliedTagValuePairs(store, tagValuePairs, file)
+		if err != nil {
+			return fmt.Errorf("%v: could not remove applied tags: %v", url, err)
+		}
+	}
+
+	return applyTags(store, url, file, tagValuePairs)
+}
+
+// Adds the file at a URL. An 'http' or 'https' URL is fetched for its
+// fingerprint, size and modification time; for other schemes these are left
+// empty, the fingerprint to be supplied by 'import --checksums'.
+func addUrl(store *storage.Storage, url, fingerprintAlgorithm string) (*entities.File, error) {
+	fp, modTime, size := fingerprint.EMPTY, time.Time{}, int64(0)
+
+	if isHttpUrl(url) {
+		var err error
+		fp, modTime, size, err = fetchUrl(store.Context(), url, fingerprintAlgorithm)
+		if err != nil {
+			return nil, fmt.Errorf("%v: could not fetch: %v", url, err)
+		}
+	}
+
+	log.Infof(2, "%v: adding file.", url)
+
+	file, err := store.AddFile(url, fp, modTime, size, false)
+	if err != nil {
+		return nil, fmt.Errorf("%v: could not add file to database: %v", url, err)
+	}
+
+	return file, nil
+}
+
+// The files on the local filesystem, omitting those at URLs, which cannot be
+// examined.
+func localFiles(files entities.Files) entities.Files {
+	return files.Where(func(file *entities.File) bool {
+		return !_path.IsUrl(file.Directory)
+	})
+}
+
+func isHttpUrl(url string) bool {
+	lowerUrl := strings.ToLower(url)
+
+	return strings.HasPrefix(lowerUrl, "http://") || strings.HasPrefix(lowerUrl, "https://")
+}
+
+// The client with which URLs are fetched. The connection and the server's
+// response are each subject to a timeout. The download itself is not, as large
+// files may legitimately take a long time, but is abandoned with the context.
+var urlClient = &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment,
+	DialContext:           (&net.Dialer{Timeout: 30 * time.Second}).DialContext,
+	TLSHandshakeTimeout:   30 * time.Second,
+	ResponseHeaderTimeout: 60 * time.Second}}
+
+// Downloads the file at the URL to calculate its fingerprint, also returning
+// its modification time and size where the server reports them.
+func fetchUrl(ctx context.Context, url, fingerprintAlgorithm string) (fingerprint.Fingerprint, time.Time, int64, error) {
+	log.Infof(2, "%v: fetching", url)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fingerprint.EMPTY, time.Time{}, 0, err
+	}
+
+	response, err := urlClient.Do(request)
+	if err != nil {
+		return fingerprint.EMPTY, time.Time{}, 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fingerprint.EMPTY, time.Time{}, 0, fmt.Errorf("%v", response.Status)
+	}
+
+	fp, err := fingerprint.CreateFromReader(response.Body, response.ContentLength, fingerprintAlgorithm)
+	if err != nil {
+		return fingerprint.EMPTY, time.Time{}, 0, err
+	}
+
+	var modTime time.Time
+	if lastModified := response.Header.Get("Last-Modified"); lastModified != "" {
+		// an unparseable time is left unknown
+		modTime, _ = http.ParseTime(lastModified)
+	}
+
+	size := response.ContentLength
+	if size < 0 {
+		size = 0
+	}
+
+	return fp, modTime, size, nil
+}
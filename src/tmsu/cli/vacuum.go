@@ -0,0 +1,114 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"tmsu/common/log"
+	"tmsu/storage"
+)
+
+var VacuumCommand = Command{
+	Name:     "vacuum",
+	Synopsis: "Compact the database",
+	Usages:   []string{"tmsu vacuum"},
+	Description: `Rebuilds the database file, reclaiming the space left behind by deleted tags, values and files, and then gathers the statistics used to choose how queries are run. Databases that have seen many deletions, merges or repairs grow larger and slower over time: vacuuming restores them.
+
+Vacuuming rewrites the whole database so may take some time for a large database, during which the database may not be changed by other processes.
+
+The database is also vacuumed automatically after a subcommand has run when the proportion of the database file that is free space reaches the 'autoVacuumThreshold' setting, a percentage (default 0, which disables this). The 'info --usage' subcommand shows the free space.`,
+	Examples: []string{"$ tmsu vacuum",
+		"$ tmsu config autoVacuumThreshold=25"},
+	Options: Options{},
+	Exec:    vacuumExec,
+}
+
+func vacuumExec(store *storage.Storage, options Options, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	// the database cannot be vacuumed within a transaction
+	if err := store.Commit(); err != nil {
+		return err
+	}
+
+	err := vacuumDatabase(store)
+
+	if err := store.Begin(); err != nil {
+		return err
+	}
+
+	return err
+}
+
+// unexported
+
+// Vacuums the database if the proportion of free space has reached the
+// 'autoVacuumThreshold' setting. There must not be an open transaction.
+func autoVacuum(store *storage.Storage) error {
+	threshold, err := store.SettingAsUint("autoVacuumThreshold")
+	if err != nil {
+		return fmt.Errorf("could not retrieve setting 'autoVacuumThreshold': %v", err)
+	}
+
+	if threshold == 0 {
+		return nil
+	}
+
+	pageUsage, err := store.PageUsage()
+	if err != nil {
+		return fmt.Errorf("could not retrieve database size: %v", err)
+	}
+
+	if pageUsage.Pages == 0 || pageUsage.FreePages*100 < threshold*pageUsage.Pages {
+		return nil
+	}
+
+	log.Infof(2, "%v of %v pages are free: vacuuming automatically.", pageUsage.FreePages, pageUsage.Pages)
+
+	return vacuumDatabase(store)
+}
+
+func vacuumDatabase(store *storage.Storage) error {
+	before, err := store.PageUsage()
+	if err != nil {
+		return fmt.Errorf("could not retrieve database size: %v", err)
+	}
+
+	log.Infof(1, "vacuuming database (%v bytes, %v bytes free).", before.Pages*before.PageSize, before.FreePages*before.PageSize)
+
+	if err := store.Vacuum(); err != nil {
+		return fmt.Errorf("could not vacuum database: %v", err)
+	}
+
+	log.Info(1, "analysing database.")
+
+	if err := store.Analyze(); err != nil {
+		return fmt.Errorf("could not analyse database: %v", err)
+	}
+
+	after, err := store.PageUsage()
+	if err != nil {
+		return fmt.Errorf("could not retrieve database size: %v", err)
+	}
+
+	log.Infof(1, "database is now %v bytes (was %v bytes).", after.Pages*after.PageSize, before.Pages*before.PageSize)
+
+	return nil
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+	"tmsu/common/fingerprint"
+	"tmsu/entities"
+	"tmsu/storage"
+)
+
+func TestVacuumReclaimsFreeSpace(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFreeSpace(store); err != nil {
+		test.Fatal(err)
+	}
+
+	before, err := store.PageUsage()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if before.FreePages == 0 {
+		test.Fatal("Expected free pages before vacuuming.")
+	}
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+	defer store.Rollback()
+
+	// test
+
+	if err := VacuumCommand.Exec(store, Options{}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	pageUsage, err := store.PageUsage()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if pageUsage.FreePages != 0 {
+		test.Fatalf("Expected no free pages but there are %v.", pageUsage.FreePages)
+	}
+
+	if !store.Db.InTransaction() {
+		test.Fatal("Transaction was not resumed.")
+	}
+}
+
+func TestVacuumDeletesUnusedDirectories(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+	defer store.Rollback()
+
+	fileA, err := store.AddFile("/tmp/a/a", fingerprint.Fingerprint("abc"), time.Now(), 123, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.AddFile("/tmp/b/b", fingerprint.Fingerprint("def"), time.Now(), 456, false); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := store.DeleteFile(fileA.Id); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := VacuumCommand.Exec(store, Options{}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	rows, err := store.Db.ExecQuery("SELECT path FROM directory")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer rows.Close()
+
+	paths := []string{}
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			test.Fatal(err)
+		}
+
+		paths = append(paths, path)
+	}
+
+	if len(paths) != 1 || paths[0] != "/tmp/b" {
+		test.Fatalf("Expected only directory '/tmp/b' to remain but found %v.", paths)
+	}
+}
+
+func TestAutoVacuumThreshold(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFreeSpace(store); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := autoVacuum(store); err != nil {
+		test.Fatal(err)
+	}
+
+	before, err := store.PageUsage()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := store.UpdateSetting("autoVacuumThreshold", "1"); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := autoVacuum(store); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	if before.FreePages == 0 {
+		test.Fatal("Database was vacuumed despite automatic vacuuming being disabled.")
+	}
+
+	after, err := store.PageUsage()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if after.FreePages != 0 {
+		test.Fatalf("Expected no free pages but there are %v.", after.FreePages)
+	}
+}
+
+// unexported
+
+func createFreeSpace(store *storage.Storage) error {
+	if err := store.Begin(); err != nil {
+		return err
+	}
+
+	tagIds := make(entities.TagIds, 2000)
+	for index := range tagIds {
+		tag, err := store.AddTag(fmt.Sprintf("tag%v", index))
+		if err != nil {
+			return err
+		}
+
+		tagIds[index] = tag.Id
+	}
+
+	if err := store.Checkpoint(); err != nil {
+		return err
+	}
+
+	for _, tagId := range tagIds {
+		if err := store.DeleteTag(tagId); err != nil {
+			return err
+		}
+	}
+
+	return store.Commit()
+}
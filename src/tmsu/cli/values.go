@@ -101,7 +101,7 @@ func listValuesForTag(store *storage.Storage, tagName string, showCount, onePerL
 		return fmt.Errorf("could not retrieve tag '%v': %v", tagName, err)
 	}
 	if tag == nil {
-		return fmt.Errorf("no such tag, '%v'", tagName)
+		return noSuchTagError{tagName}
 	}
 
 	log.Infof(2, "retrieving values for tag '%v'.", tagName)
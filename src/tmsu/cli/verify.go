@@ -0,0 +1,237 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"tmsu/common/fingerprint"
+	"tmsu/common/log"
+	"tmsu/common/path"
+	"tmsu/entities"
+	"tmsu/storage"
+)
+
+var VerifyCommand = Command{
+	Name:     "verify",
+	Synopsis: "Check file contents against their fingerprints",
+	Usages:   []string{"tmsu verify [OPTION]... [PATH]..."},
+	Description: `Recalculates the fingerprints of tagged files to detect contents that have changed without the file's modification time or size changing, such as through disk corruption ('bit rot').
+
+Where PATHs are not specified every file in the database is verified, otherwise the files at and under the PATHs are.
+
+Each file with a problem is listed with its status:
+
+  corrupt   the contents no longer match the fingerprint
+  updated   the contents did not match and the fingerprint was updated
+  modified  the file has been modified (use 'repair' instead)
+  missing   the file no longer exists
+
+With --update the fingerprints of corrupt files are replaced, accepting their current contents. The fingerprints are calculated with the algorithm given by the 'fingerprintAlgorithm' setting so, if the setting has been changed since the files were tagged, use 'repair --unmodified' instead.
+
+With --json the problems are reported as a JSON document instead.
+
+The exit code is 2 if corrupt files were found (and not updated).`,
+	Examples: []string{"$ tmsu verify",
+		"$ tmsu verify /archive\narchive/2009/beach.jpg: corrupt",
+		"$ tmsu verify --update /archive/2009/beach.jpg\narchive/2009/beach.jpg: updated",
+		"$ tmsu verify --json"},
+	Options: Options{{"--update", "-u", "accept the current contents of corrupt files", false, ""},
+		{"--json", "-j", "report the problems as JSON", false, ""}},
+	Exec: verifyExec,
+}
+
+// A file whose contents could not be verified against its fingerprint.
+type verifyProblem struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	Stored string `json:"storedFingerprint,omitempty"`
+	Actual string `json:"actualFingerprint,omitempty"`
+}
+
+// The result of verifying the files, as reported by --json.
+type verifyReport struct {
+	Checked  uint            `json:"checked"`
+	Problems []verifyProblem `json:"problems"`
+}
+
+func verifyExec(store *storage.Storage, options Options, args []string) error {
+	update := options.HasOption("--update")
+	asJson := options.HasOption("--json")
+
+	files, err := verifyFiles(store, args)
+	if err != nil {
+		return err
+	}
+
+	fingerprintAlgorithm, err := store.SettingAsString("fingerprintAlgorithm")
+	if err != nil {
+		return err
+	}
+
+	report := verifyReport{Problems: []verifyProblem{}}
+	wereCorrupt := false
+
+	for _, file := range files {
+		problem, err := verifyFile(store, file, fingerprintAlgorithm, update)
+		if err != nil {
+			return err
+		}
+
+		report.Checked++
+
+		if problem == nil {
+			continue
+		}
+
+		if problem.Status == "corrupt" {
+			wereCorrupt = true
+		}
+
+		if asJson {
+			report.Problems = append(report.Problems, *problem)
+		} else {
+			fmt.Printf("%v: %v\n", problem.Path, problem.Status)
+		}
+	}
+
+	if asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		if err := encoder.Encode(report); err != nil {
+			return fmt.Errorf("could not write report: %v", err)
+		}
+	}
+
+	if wereCorrupt {
+		return errBlank
+	}
+
+	return nil
+}
+
+// unexported
+
+// Retrieves the files to verify, ordered by path: all files or those at and
+// under the specified paths.
+func verifyFiles(store *storage.Storage, paths []string) (entities.Files, error) {
+	if len(paths) == 0 {
+		log.Info(2, "retrieving all files from database.")
+
+		files, err := store.Files()
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve files: %v", err)
+		}
+
+		return localFiles(files), nil
+	}
+
+	files := make(entities.Files, 0, len(paths))
+	seen := make(map[entities.FileId]bool)
+
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("%v: could not get absolute path: %v", path, err)
+		}
+
+		file, err := store.FileByPath(absPath)
+		if err != nil {
+			return nil, fmt.Errorf("%v: could not retrieve file: %v", path, err)
+		}
+
+		dirFiles, err := store.FilesByDirectory(absPath)
+		if err != nil {
+			return nil, fmt.Errorf("%v: could not retrieve files for directory: %v", path, err)
+		}
+
+		if file != nil {
+			dirFiles = append(dirFiles, file)
+		}
+
+		for _, dirFile := range dirFiles {
+			if !seen[dirFile.Id] {
+				seen[dirFile.Id] = true
+				files = append(files, dirFile)
+			}
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path() < files[j].Path() })
+
+	return files, nil
+}
+
+// Recalculates the fingerprint of the file, returning the problem found, if
+// any.
+func verifyFile(store *storage.Storage, file *entities.File, fingerprintAlgorithm string, update bool) (*verifyProblem, error) {
+	relPath := path.Rel(file.Path())
+
+	log.Infof(2, "%v: verifying file.", file.Path())
+
+	stat, err := os.Stat(file.Path())
+	if err != nil {
+		switch {
+		case os.IsNotExist(err):
+			return &verifyProblem{Path: relPath, Status: "missing"}, nil
+		case os.IsPermission(err):
+			log.Warnf("%v: permission denied.", file.Path())
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("%v: could not stat: %v", file.Path(), err)
+		}
+	}
+
+	if stat.Size() != file.Size || !stat.ModTime().UTC().Equal(file.ModTime) {
+		return &verifyProblem{Path: relPath, Status: "modified"}, nil
+	}
+
+	if file.IsDir || file.Fingerprint == fingerprint.EMPTY {
+		return nil, nil
+	}
+
+	actual, err := fingerprint.Create(file.Path(), fingerprintAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("%v: could not create fingerprint: %v", file.Path(), err)
+	}
+
+	if actual == file.Fingerprint {
+		return nil, nil
+	}
+
+	problem := verifyProblem{Path: relPath, Status: "corrupt", Stored: string(file.Fingerprint), Actual: string(actual)}
+
+	if update {
+		updatedFile, err := store.UpdateFile(file.Id, file.Path(), actual, file.ModTime, file.Size, file.IsDir)
+		if err != nil {
+			return nil, fmt.Errorf("%v: could not update file in database: %v", file.Path(), err)
+		}
+
+		if err := store.UpdateExtraFingerprints(updatedFile); err != nil {
+			return nil, err
+		}
+
+		problem.Status = "updated"
+	}
+
+	return &problem, nil
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestVerifyCorruptFile(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, path := range []string{"/tmp/tmsu/a", "/tmp/tmsu/b"} {
+		if err := createFile(path, "hello"); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+
+		if err := TagCommand.Exec(store, Options{}, []string{path, "a"}); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	file, err := store.FileByPath("/tmp/tmsu/b")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// same size and modification time but different contents
+	if err := createFile("/tmp/tmsu/b", "jello"); err != nil {
+		test.Fatal(err)
+	}
+	if err := os.Chtimes("/tmp/tmsu/b", file.ModTime, file.ModTime); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := VerifyCommand.Exec(store, Options{}, []string{"/tmp/tmsu"}); err != errBlank {
+		test.Fatalf("Expected corruption to be reported but got %v", err)
+	}
+
+	if err := VerifyCommand.Exec(store, Options{Option{"--update", "-u", "", false, ""}}, []string{"/tmp/tmsu"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := VerifyCommand.Exec(store, Options{}, []string{"/tmp/tmsu"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, "/tmp/tmsu/b: corrupt\n/tmp/tmsu/b: updated\n", string(bytes))
+}
+
+func TestVerifyJson(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	err := redirectStreams()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer restoreStreams()
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := createFile("/tmp/tmsu/a", "hello"); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a", "a"}); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := os.Remove("/tmp/tmsu/a"); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := VerifyCommand.Exec(store, Options{Option{"--json", "-j", "", false, ""}}, []string{}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	outFile.Seek(0, 0)
+
+	bytes, err := ioutil.ReadAll(outFile)
+	compareOutput(test, `{
+  "checked": 1,
+  "problems": [
+    {
+      "path": "/tmp/tmsu/a",
+      "status": "missing"
+    }
+  ]
+}
+`, string(bytes))
+}
@@ -15,13 +15,13 @@ You should have received a copy of the GNU General Public License
 along with this program.  If not, see <http://www.gnu.org/licenses/>.
 */
 
-// +build !windows
-
 package cli
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
+	"sync"
 	"tmsu/storage"
 	"tmsu/vfs"
 )
@@ -29,13 +29,18 @@ import (
 var VfsCommand = Command{
 	Name:     "vfs",
 	Synopsis: "Hosts the virtual filesystem",
-	Usages:   []string{"tmsu vfs [OPTION]... MOUNTPOINT"},
+	Usages:   []string{"tmsu vfs [OPTION]... MOUNTPOINT [FILE]..."},
 	Description: `This subcommand is the foreground process which hosts the virtual filesystem. It is run automatically when a virtual filesystem is mounted using the 'mount' subcommand and terminated when the virtual filesystem is unmounted.
 
-It is not normally necessary to issue this subcommand manually unless debugging the virtual filesystem. For debug output use the --verbose option.`,
-	Options: Options{{"--options", "-o", "mount options", true, ""}},
-	Exec:    vfsExec,
-	Hidden:  true,
+It is not normally necessary to issue this subcommand manually unless debugging the virtual filesystem. For debug output use the --verbose option.
+
+Where database FILEs are specified, these are presented alongside the database, each beneath a directory named after it.
+
+On Windows, which lacks FUSE, this subcommand is the means of mounting the virtual filesystem. MOUNTPOINT must be a drive letter, e.g. 'T:', to which the virtual filesystem is mapped as a WebDAV share. The drive remains mapped until the subcommand is interrupted.`,
+	Options:       Options{{"--options", "-o", "mount options", true, ""}},
+	Exec:          vfsExec,
+	Hidden:        true,
+	NoTransaction: true,
 }
 
 func vfsExec(store *storage.Storage, options Options, args []string) error {
@@ -43,8 +48,6 @@ func vfsExec(store *storage.Storage, options Options, args []string) error {
 		fmt.Errorf("mountpoint not specified")
 	}
 
-    store.Rollback() // ensure no open transaction
-
 	mountOptions := []string{}
 	if options.HasOption("--options") {
 		mountOptions = strings.Split(options.Get("--options").Argument, ",")
@@ -52,13 +55,60 @@ func vfsExec(store *storage.Storage, options Options, args []string) error {
 
 	mountPath := args[0]
 
-	vfs, err := vfs.MountVfs(store, mountPath, mountOptions)
+	var mounted vfs.Vfs
+	var err error
+	if len(args) > 1 {
+		mounted, err = mountMultiVfs(store, args[1:], mountPath, mountOptions)
+	} else {
+		mounted, err = vfs.MountVfs(store, mountPath, mountOptions)
+	}
 	if err != nil {
 		return fmt.Errorf("could not mount virtual filesystem at '%v': %v", mountPath, err)
 	}
-	defer vfs.Unmount()
+	var unmount sync.Once
+	defer unmount.Do(mounted.Unmount)
 
-	vfs.Serve()
+	// unmount when interrupted, which ends the serving
+	go func() {
+		<-store.Context().Done()
+		unmount.Do(mounted.Unmount)
+	}()
+
+	mounted.Serve()
 
 	return nil
 }
+
+// unexported
+
+func mountMultiVfs(store *storage.Storage, databasePaths []string, mountPath string, mountOptions []string) (vfs.Vfs, error) {
+	stores := []*storage.Storage{store}
+	names := []string{databaseName(store.Db.Path)}
+
+	for _, databasePath := range databasePaths {
+		otherStore, err := storage.OpenAt(databasePath)
+		if err != nil {
+			return nil, fmt.Errorf("could not open database '%v': %v", databasePath, err)
+		}
+
+		stores = append(stores, otherStore)
+		names = append(names, databaseName(databasePath))
+	}
+
+	return vfs.MountMultiVfs(stores, names, mountPath, mountOptions)
+}
+
+// Names a database after its file or, for a '.tmsu/db' database, the directory
+// containing '.tmsu'.
+func databaseName(databasePath string) string {
+	dir, name := filepath.Split(filepath.Clean(databasePath))
+	if filepath.Base(dir) == ".tmsu" {
+		return filepath.Base(filepath.Dir(filepath.Clean(dir)))
+	}
+
+	if baseName := strings.TrimSuffix(name, filepath.Ext(name)); baseName != "" {
+		return baseName
+	}
+
+	return name
+}
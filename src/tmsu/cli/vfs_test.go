@@ -0,0 +1,39 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"testing"
+)
+
+func TestDatabaseName(test *testing.T) {
+	for path, expected := range map[string]string{"/home/bob/photos/.tmsu/db": "photos",
+		"/tmp/music.db":     "music",
+		"/tmp/db":           "db",
+		"relative/.tmsu/db": "relative"} {
+		// test
+
+		name := databaseName(path)
+
+		// validate
+
+		if name != expected {
+			test.Fatalf("%v: expected name '%v' but was '%v'", path, expected, name)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"tmsu/common/log"
+	"tmsu/common/xmp"
+	"tmsu/storage"
+)
+
+var XmpCommand = Command{
+	Name:     "xmp",
+	Synopsis: "Exchange tags with XMP keywords",
+	Usages: []string{"tmsu xmp export [OPTION]... FILE...",
+		"tmsu xmp import FILE..."},
+	Description: `Exchanges the tags of each FILE with the keywords ('dc:subject') of its XMP metadata, as used by photo managers such as Lightroom and digiKam.
+
+export writes each FILE's explicit tags to its XMP sidecar, replacing the keywords already there whilst leaving its other metadata alone. The sidecar is 'FILE.xmp' or, where it exists, FILE with its extension replaced by '.xmp'. Where there is no sidecar, 'FILE.xmp' is created unless --replace-extension is specified. Metadata embedded within the file itself is never modified.
+
+import applies the keywords from each FILE's XMP sidecar or, where it has none, from the metadata embedded within FILE. Keywords that are not valid tag names are skipped with a warning.
+
+A tag with a value is written as a keyword of the form TAG=VALUE. The separator can be changed, for example to ':' for 'year:2014', with the 'xmpValueSeparator' setting; an empty separator imports keywords as plain tags.`,
+	Examples: []string{"$ tmsu xmp export *.jpg",
+		"$ tmsu xmp export --replace-extension IMG_0001.CR2",
+		"$ tmsu xmp import ~/Pictures/2014/*.jpg",
+		"$ tmsu config xmpValueSeparator=:"},
+	Options: Options{{"--replace-extension", "-e", "name new sidecars after the file without its extension", false, ""}},
+	Exec:    xmpExec,
+}
+
+func xmpExec(store *storage.Storage, options Options, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("too few arguments")
+	}
+
+	separator, err := store.SettingAsString("xmpValueSeparator")
+	if err != nil {
+		return fmt.Errorf("could not retrieve setting 'xmpValueSeparator': %v", err)
+	}
+
+	switch args[0] {
+	case "export":
+		if len(args) < 2 {
+			return fmt.Errorf("too few arguments")
+		}
+
+		return exportXmp(store, args[1:], separator, options.HasOption("--replace-extension"))
+	case "import":
+		if len(args) < 2 {
+			return fmt.Errorf("too few arguments")
+		}
+
+		return importXmp(store, args[1:], separator)
+	default:
+		return fmt.Errorf("unknown action '%v': must be 'export' or 'import'", args[0])
+	}
+}
+
+// unexported
+
+func exportXmp(store *storage.Storage, paths []string, separator string, replaceExtension bool) error {
+	wereErrors := false
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not get absolute path: %v", path, err)
+		}
+
+		file, err := store.FileByPath(absPath)
+		if err != nil {
+			return fmt.Errorf("%v: could not retrieve file: %v", path, err)
+		}
+		if file == nil {
+			log.Warnf("%v: file is not tagged", path)
+			wereErrors = true
+			continue
+		}
+
+		tagNames, err := tagNamesForFile(store, file.Id, true, false)
+		if err != nil {
+			return err
+		}
+
+		keywords := make([]string, len(tagNames))
+		for index, tagName := range tagNames {
+			keywords[index] = xmpKeyword(tagName, separator)
+		}
+
+		sidecarPath := xmpSidecarPath(absPath)
+		if sidecarPath == "" {
+			sidecarPath = newXmpSidecarPath(absPath, replaceExtension)
+		}
+
+		sidecar, err := ioutil.ReadFile(sidecarPath)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("%v: could not read sidecar: %v", sidecarPath, err)
+		}
+
+		sidecar, err = xmp.WriteKeywords(sidecar, keywords)
+		if err != nil {
+			log.Warnf("%v: %v", sidecarPath, err)
+			wereErrors = true
+			continue
+		}
+
+		log.Infof(2, "%v: writing %v keywords", sidecarPath, len(keywords))
+
+		if err := ioutil.WriteFile(sidecarPath, sidecar, 0666); err != nil {
+			return fmt.Errorf("%v: could not write sidecar: %v", sidecarPath, err)
+		}
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+func importXmp(store *storage.Storage, paths []string, separator string) error {
+	policy, err := symlinkPolicy(store, nil)
+	if err != nil {
+		return err
+	}
+
+	wereErrors := false
+	for _, path := range paths {
+		metadataPath := xmpSidecarPath(path)
+		if metadataPath == "" {
+			metadataPath = path
+		}
+
+		data, err := ioutil.ReadFile(metadataPath)
+		if err != nil {
+			log.Warnf("%v: could not read file: %v", metadataPath, err)
+			wereErrors = true
+			continue
+		}
+
+		packet := xmp.FindPacket(data)
+		if packet == nil {
+			log.Infof(2, "%v: no XMP metadata", metadataPath)
+			continue
+		}
+
+		keywords, err := xmp.ReadKeywords(packet)
+		if err != nil {
+			log.Warnf("%v: %v", metadataPath, err)
+			wereErrors = true
+			continue
+		}
+
+		tagArgs := make([]string, 0, len(keywords))
+		for _, keyword := range keywords {
+			tagArg, err := xmpTagArg(keyword, separator)
+			if err != nil {
+				log.Warnf("%v: skipping keyword '%v': %v", metadataPath, keyword, err)
+				wereErrors = true
+				continue
+			}
+
+			tagArgs = append(tagArgs, tagArg)
+		}
+
+		if len(tagArgs) == 0 {
+			continue
+		}
+
+		if err := tagPaths(store, tagArgs, []string{path}, false, false, false, policy); err != nil {
+			if err != errBlank {
+				return err
+			}
+
+			wereErrors = true
+		}
+	}
+
+	if wereErrors {
+		return errBlank
+	}
+
+	return nil
+}
+
+// Identifies the existing XMP sidecar for a file: 'photo.jpg.xmp' or
+// 'photo.xmp'. Returns an empty string if the file has no sidecar.
+func xmpSidecarPath(path string) string {
+	withoutExtension := strings.TrimSuffix(path, filepath.Ext(path))
+
+	for _, candidate := range []string{path + ".xmp", path + ".XMP", withoutExtension + ".xmp", withoutExtension + ".XMP"} {
+		if candidate == path {
+			continue
+		}
+
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+
+	return ""
+}
+
+func newXmpSidecarPath(path string, replaceExtension bool) string {
+	if replaceExtension {
+		return strings.TrimSuffix(path, filepath.Ext(path)) + ".xmp"
+	}
+
+	return path + ".xmp"
+}
+
+// Converts a tag name, of the form TAG or TAG=VALUE, to a keyword.
+func xmpKeyword(tagName, separator string) string {
+	index := strings.Index(tagName, "=")
+	if index == -1 {
+		return tagName
+	}
+
+	return tagName[:index] + separator + tagName[index+1:]
+}
+
+// Converts a keyword to a tag argument, of the form TAG or TAG=VALUE.
+func xmpTagArg(keyword, separator string) (string, error) {
+	tagName, valueName := keyword, ""
+	if separator != "" {
+		if index := strings.Index(keyword, separator); index > 0 {
+			tagName, valueName = keyword[:index], keyword[index+len(separator):]
+		}
+	}
+
+	return importedTagArg(tagName, valueName)
+}
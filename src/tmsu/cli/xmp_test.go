@@ -0,0 +1,106 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"tmsu/storage"
+)
+
+func TestXmpExportThenImport(test *testing.T) {
+	// set-up
+
+	databasePath := testDatabase()
+	defer os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.UpdateSetting("xmpValueSeparator", ":"); err != nil {
+		test.Fatal(err)
+	}
+
+	for _, path := range []string{"/tmp/tmsu/a.jpg", "/tmp/tmsu/b.jpg"} {
+		if err := createFile(path, path); err != nil {
+			test.Fatal(err)
+		}
+		defer os.Remove(path)
+		defer os.Remove(path + ".xmp")
+	}
+
+	if err := TagCommand.Exec(store, Options{}, []string{"/tmp/tmsu/a.jpg", "mountain", "year=2014"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	if err := XmpCommand.Exec(store, Options{}, []string{"export", "/tmp/tmsu/a.jpg"}); err != nil {
+		test.Fatal(err)
+	}
+
+	sidecar, err := ioutil.ReadFile("/tmp/tmsu/a.jpg.xmp")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile("/tmp/tmsu/b.jpg.xmp", sidecar, 0666); err != nil {
+		test.Fatal(err)
+	}
+
+	if err := XmpCommand.Exec(store, Options{}, []string{"import", "/tmp/tmsu/b.jpg"}); err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	file, err := store.FileByPath("/tmp/tmsu/b.jpg")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if file == nil {
+		test.Fatal("file was not tagged")
+	}
+
+	tagNames, err := tagNamesForFile(store, file.Id, true, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if len(tagNames) != 2 || tagNames[0] != "mountain" || tagNames[1] != "year=2014" {
+		test.Fatalf("unexpected tags: %v", tagNames)
+	}
+}
+
+func TestXmpTagArg(test *testing.T) {
+	tagArg, err := xmpTagArg("year:2014", ":")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if tagArg != "year=2014" {
+		test.Fatalf("expected 'year=2014' but was '%v'", tagArg)
+	}
+
+	if _, err := xmpTagArg("New York", ":"); err == nil {
+		test.Fatal("keyword with a space was accepted")
+	}
+}
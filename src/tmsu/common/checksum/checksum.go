@@ -0,0 +1,136 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package checksum reads and writes checksum manifests in the format of
+// 'sha256sum' and the other GNU coreutils checksum programs.
+package checksum
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A line of a manifest: the checksum of a file and its path.
+type Entry struct {
+	Checksum string
+	Path     string
+}
+
+// Reads the entries of a manifest whose checksums are of the specified length
+// in bytes, e.g. 32 for SHA-256. Each line is of the form 'CHECKSUM  PATH' or,
+// for a file read in binary mode, 'CHECKSUM *PATH'. Blank lines and lines
+// starting with '#' are ignored. The checksums are returned in lower case.
+func ReadManifest(reader io.Reader, size int) ([]Entry, error) {
+	entries := make([]Entry, 0, 10)
+
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		entry, err := parseLine(line, size)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", lineNumber, err)
+		}
+
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Writes a manifest line for the entry, escaping the path as 'sha256sum' does
+// should it contain a backslash or newline.
+func WriteEntry(writer io.Writer, entry Entry) error {
+	prefix, path := "", entry.Path
+	if strings.ContainsAny(path, "\\\n\r") {
+		prefix = "\\"
+		path = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r").Replace(path)
+	}
+
+	_, err := fmt.Fprintf(writer, "%v%v  %v\n", prefix, entry.Checksum, path)
+	return err
+}
+
+// unexported
+
+func parseLine(line string, size int) (Entry, error) {
+	escaped := strings.HasPrefix(line, "\\")
+	if escaped {
+		line = line[1:]
+	}
+
+	length := size * 2
+	if len(line) < length+2 || line[length] != ' ' || (line[length+1] != ' ' && line[length+1] != '*') {
+		return Entry{}, fmt.Errorf("expected 'CHECKSUM  PATH'")
+	}
+
+	checksum := strings.ToLower(line[:length])
+	if _, err := hex.DecodeString(checksum); err != nil {
+		return Entry{}, fmt.Errorf("invalid checksum '%v'", line[:length])
+	}
+
+	path := line[length+2:]
+	if escaped {
+		var err error
+		path, err = unescape(path)
+		if err != nil {
+			return Entry{}, err
+		}
+	}
+	if path == "" {
+		return Entry{}, fmt.Errorf("path must be specified")
+	}
+
+	return Entry{checksum, path}, nil
+}
+
+func unescape(path string) (string, error) {
+	var builder strings.Builder
+	for index := 0; index < len(path); index++ {
+		if path[index] != '\\' {
+			builder.WriteByte(path[index])
+			continue
+		}
+
+		index++
+		if index == len(path) {
+			return "", fmt.Errorf("incomplete escape sequence in path")
+		}
+
+		switch path[index] {
+		case '\\':
+			builder.WriteByte('\\')
+		case 'n':
+			builder.WriteByte('\n')
+		case 'r':
+			builder.WriteByte('\r')
+		default:
+			return "", fmt.Errorf("invalid escape sequence '\\%c' in path", path[index])
+		}
+	}
+
+	return builder.String(), nil
+}
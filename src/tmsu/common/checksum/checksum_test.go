@@ -0,0 +1,78 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package checksum
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const helloSum = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+func TestReadManifest(test *testing.T) {
+	manifest := "# checksums\n" +
+		strings.ToUpper(helloSum) + "  a.txt\n" +
+		"\n" +
+		helloSum + " *dir/b c.bin\r\n" +
+		"\\" + helloSum + "  odd\\nname\\\\x\n"
+
+	entries, err := ReadManifest(strings.NewReader(manifest), 32)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	expected := []Entry{{helloSum, "a.txt"}, {helloSum, "dir/b c.bin"}, {helloSum, "odd\nname\\x"}}
+	if len(entries) != len(expected) {
+		test.Fatalf("Expected %v entries but were %v.", len(expected), len(entries))
+	}
+	for index, entry := range entries {
+		if entry != expected[index] {
+			test.Fatalf("Expected entry %v to be %v but was %v.", index, expected[index], entry)
+		}
+	}
+}
+
+func TestReadManifestRejectsMalformedLines(test *testing.T) {
+	for _, line := range []string{"abc  a.txt", helloSum + "a.txt", strings.Repeat("z", 64) + "  a.txt", helloSum + "  "} {
+		if _, err := ReadManifest(strings.NewReader(line+"\n"), 32); err == nil {
+			test.Fatalf("Expected line '%v' to be rejected.", line)
+		}
+	}
+}
+
+func TestWriteEntryRoundTrip(test *testing.T) {
+	var buffer bytes.Buffer
+	for _, path := range []string{"a.txt", "odd\nname\\x"} {
+		if err := WriteEntry(&buffer, Entry{helloSum, path}); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	if !strings.HasPrefix(buffer.String(), helloSum+"  a.txt\n\\"+helloSum+"  odd\\nname\\\\x\n") {
+		test.Fatalf("Unexpected manifest '%v'.", buffer.String())
+	}
+
+	entries, err := ReadManifest(&buffer, 32)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(entries) != 2 || entries[1].Path != "odd\nname\\x" {
+		test.Fatalf("Unexpected entries %v.", entries)
+	}
+}
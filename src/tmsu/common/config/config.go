@@ -0,0 +1,107 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// The settings read from a configuration file, by name.
+type Settings map[string]string
+
+// The path of the user's configuration file: 'tmsu/config' within
+// XDG_CONFIG_HOME, which defaults to '~/.config'.
+func Path() (string, error) {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		u, err := user.Current()
+		if err != nil {
+			return "", fmt.Errorf("could not identify current user: %v", err)
+		}
+
+		configHome = filepath.Join(u.HomeDir, ".config")
+	}
+
+	return filepath.Join(configHome, "tmsu", "config"), nil
+}
+
+// Reads the user's configuration file. A missing file yields no settings.
+func Load() (Settings, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+
+	return Read(path)
+}
+
+// Reads the configuration file at the specified path. A missing file yields
+// no settings.
+func Read(path string) (Settings, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Settings{}, nil
+		}
+
+		return nil, err
+	}
+	defer file.Close()
+
+	settings, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	return settings, nil
+}
+
+// Parses configuration of the form 'NAME = VALUE', one setting per line. Blank
+// lines and lines starting with '#' are ignored.
+func Parse(reader io.Reader) (Settings, error) {
+	settings := Settings{}
+
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		index := strings.Index(line, "=")
+		if index < 1 {
+			return nil, fmt.Errorf("line %v: expected 'NAME = VALUE'", lineNumber)
+		}
+
+		name := strings.TrimSpace(line[:index])
+		value := strings.TrimSpace(line[index+1:])
+		settings[name] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(test *testing.T) {
+	text := `# defaults for all databases
+database = /home/bob/tags.db
+
+fingerprintAlgorithm=SHA1
+  mountOptions = allow_other,names=hash  
+`
+
+	settings, err := Parse(strings.NewReader(text))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if len(settings) != 3 {
+		test.Fatalf("Expected 3 settings but got %v.", len(settings))
+	}
+	validateSetting(test, settings, "database", "/home/bob/tags.db")
+	validateSetting(test, settings, "fingerprintAlgorithm", "SHA1")
+	validateSetting(test, settings, "mountOptions", "allow_other,names=hash")
+}
+
+func TestParseMalformedLine(test *testing.T) {
+	_, err := Parse(strings.NewReader("database = a.db\nbogus\n"))
+	if err == nil {
+		test.Fatal("Expected malformed line to be reported.")
+	}
+	if !strings.Contains(err.Error(), "line 2") {
+		test.Fatalf("Expected error to identify line 2 but was '%v'.", err)
+	}
+}
+
+// unexported
+
+func validateSetting(test *testing.T, settings Settings, name, expectedValue string) {
+	value, ok := settings[name]
+	if !ok {
+		test.Fatalf("Setting '%v' is missing.", name)
+	}
+	if value != expectedValue {
+		test.Fatalf("Expected setting '%v' to be '%v' but was '%v'.", name, expectedValue, value)
+	}
+}
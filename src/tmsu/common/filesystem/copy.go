@@ -0,0 +1,101 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package filesystem
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Copies a file, or a directory and its contents, preserving permissions and
+// modification times.
+func Copy(sourcePath, destPath string) error {
+	stat, err := os.Lstat(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case stat.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(sourcePath)
+		if err != nil {
+			return err
+		}
+
+		return os.Symlink(target, destPath)
+	case stat.IsDir():
+		if err := copyDirectory(sourcePath, destPath, stat); err != nil {
+			return err
+		}
+	default:
+		if err := copyFile(sourcePath, destPath, stat); err != nil {
+			return err
+		}
+	}
+
+	return os.Chtimes(destPath, stat.ModTime(), stat.ModTime())
+}
+
+// unexported
+
+func copyDirectory(sourcePath, destPath string, stat os.FileInfo) error {
+	if err := os.Mkdir(destPath, stat.Mode().Perm()); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	names, err := dir.Readdirnames(0)
+	dir.Close()
+	if err != nil {
+		return fmt.Errorf("%v: could not read directory entries: %v", sourcePath, err)
+	}
+
+	for _, name := range names {
+		if err := Copy(filepath.Join(sourcePath, name), filepath.Join(destPath, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyFile(sourcePath, destPath string, stat os.FileInfo) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	dest, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dest, source); err != nil {
+		dest.Close()
+		return err
+	}
+
+	return dest.Close()
+}
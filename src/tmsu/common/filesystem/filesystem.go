@@ -30,11 +30,18 @@ type FileSystemFile struct {
 }
 
 func Enumerate(paths ...string) ([]FileSystemFile, error) {
+	return EnumerateUsing(FollowSymlinks, paths...)
+}
+
+// Enumerates the paths recursively, treating symbolic links according to the
+// policy.
+func EnumerateUsing(policy SymlinkPolicy, paths ...string) ([]FileSystemFile, error) {
 	resultFiles := make([]FileSystemFile, 0, len(paths)*5)
+	trail := NewDirectoryTrail(policy)
 
 	for _, path := range paths {
 		var err error
-		resultFiles, err = enumerate(path, resultFiles)
+		resultFiles, err = enumerate(trail, path, resultFiles)
 		if err != nil {
 			return nil, err
 		}
@@ -57,8 +64,8 @@ func EnumeratePaths(paths ...string) ([]string, error) {
 	return resultPaths, nil
 }
 
-func enumerate(path string, files []FileSystemFile) ([]FileSystemFile, error) {
-	stat, err := os.Stat(path)
+func enumerate(trail *DirectoryTrail, path string, files []FileSystemFile) ([]FileSystemFile, error) {
+	stat, err := trail.Policy.Stat(path)
 	if err != nil {
 		switch {
 		case os.IsNotExist(err):
@@ -74,23 +81,30 @@ func enumerate(path string, files []FileSystemFile) ([]FileSystemFile, error) {
 	files = append(files, FileSystemFile{path, stat.IsDir()})
 
 	if stat.IsDir() {
-		dir, err := os.Open(path)
-		if err != nil {
-			return nil, fmt.Errorf("%v: could not open directory: %v", path, err)
-		}
-
-		names, err := dir.Readdirnames(0)
-		dir.Close()
-		if err != nil {
-			return nil, fmt.Errorf("%v: could not read directory entries: %v", path, err)
-		}
+		err = trail.Descend(path, func() error {
+			dir, err := os.Open(path)
+			if err != nil {
+				return fmt.Errorf("%v: could not open directory: %v", path, err)
+			}
 
-		for _, name := range names {
-			childPath := filepath.Join(path, name)
-			files, err = enumerate(childPath, files)
+			names, err := dir.Readdirnames(0)
+			dir.Close()
 			if err != nil {
-				return nil, err
+				return fmt.Errorf("%v: could not read directory entries: %v", path, err)
+			}
+
+			for _, name := range names {
+				childPath := filepath.Join(path, name)
+				files, err = enumerate(trail, childPath, files)
+				if err != nil {
+					return err
+				}
 			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
 		}
 	}
 
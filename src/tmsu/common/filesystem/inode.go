@@ -0,0 +1,36 @@
+// +build !windows
+
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package filesystem
+
+import (
+	"os"
+	"syscall"
+)
+
+// Retrieves the device and inode numbers of the file described by stat, which
+// are shared by all of the hard links to the same file.
+func Inode(stat os.FileInfo) (dev uint64, inode uint64, ok bool) {
+	sys, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+
+	return uint64(sys.Dev), uint64(sys.Ino), true
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package filesystem
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"tmsu/common/log"
+)
+
+// How symbolic links are treated when files are tagged, repaired or examined.
+type SymlinkPolicy int
+
+const (
+	// Symbolic links are followed: a link to a file is treated as that file
+	// and a link to a directory is descended into.
+	FollowSymlinks SymlinkPolicy = iota
+
+	// A link to a file is treated as that file but links to directories are
+	// not descended into.
+	TargetSymlinks
+
+	// Symbolic links are stored as links: they are identified by their target
+	// path rather than by the contents of the target and are never descended
+	// into.
+	LinkSymlinks
+)
+
+var symlinkPolicyNames = []string{"follow", "target", "link"}
+
+func ParseSymlinkPolicy(text string) (SymlinkPolicy, error) {
+	for index, name := range symlinkPolicyNames {
+		if name == text {
+			return SymlinkPolicy(index), nil
+		}
+	}
+
+	return FollowSymlinks, fmt.Errorf("invalid symbolic link policy '%v': must be one of 'follow', 'target' or 'link'", text)
+}
+
+func (policy SymlinkPolicy) String() string {
+	return symlinkPolicyNames[policy]
+}
+
+// Retrieves the file information for the path under the policy: for links
+// stored as links this describes the link itself, otherwise its target.
+func (policy SymlinkPolicy) Stat(path string) (os.FileInfo, error) {
+	if policy == LinkSymlinks {
+		return os.Lstat(path)
+	}
+
+	return os.Stat(path)
+}
+
+// Determines whether the path is a symbolic link that is to be stored as a link.
+func (policy SymlinkPolicy) StoresAsLink(path string) bool {
+	if policy != LinkSymlinks {
+		return false
+	}
+
+	stat, err := os.Lstat(path)
+	return err == nil && stat.Mode()&os.ModeSymlink != 0
+}
+
+// Tracks the directories being descended into during a recursive walk so that
+// a symbolic link leading back to one of them is not followed endlessly.
+type DirectoryTrail struct {
+	Policy    SymlinkPolicy
+	realPaths map[string]bool
+}
+
+func NewDirectoryTrail(policy SymlinkPolicy) *DirectoryTrail {
+	return &DirectoryTrail{policy, make(map[string]bool)}
+}
+
+// Calls descend to examine the contents of the directory at path unless the
+// policy does not permit descending into it or doing so would form a cycle.
+func (trail *DirectoryTrail) Descend(path string, descend func() error) error {
+	stat, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	if stat.Mode()&os.ModeSymlink != 0 && trail.Policy != FollowSymlinks {
+		log.Infof(2, "%v: not descending into symbolic link", path)
+		return nil
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("%v: could not get absolute path: %v", path, err)
+	}
+
+	realPath, err := filepath.EvalSymlinks(absPath)
+	if err != nil {
+		return fmt.Errorf("%v: could not resolve symbolic links: %v", path, err)
+	}
+
+	if trail.realPaths[realPath] {
+		log.Warnf("%v: not following symbolic link as it leads to an ancestor directory", path)
+		return nil
+	}
+
+	trail.realPaths[realPath] = true
+	defer delete(trail.realPaths, realPath)
+
+	return descend()
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package filesystem
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Moves a file or directory to the user's trash in accordance with the
+// freedesktop.org trash specification.
+func Trash(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	trashPath, err := trashDirectory()
+	if err != nil {
+		return err
+	}
+
+	filesPath := filepath.Join(trashPath, "files")
+	infoPath := filepath.Join(trashPath, "info")
+
+	for _, dir := range []string{filesPath, infoPath} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("could not create trash directory '%v': %v", dir, err)
+		}
+	}
+
+	// reserve a name by exclusively creating the info file
+	name := filepath.Base(absPath)
+	var info *os.File
+	for index := 1; ; index++ {
+		candidate := name
+		if index > 1 {
+			candidate = name + "." + strconv.Itoa(index)
+		}
+
+		info, err = os.OpenFile(filepath.Join(infoPath, candidate+".trashinfo"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			name = candidate
+			break
+		}
+		if !os.IsExist(err) {
+			return fmt.Errorf("could not create trash information: %v", err)
+		}
+	}
+
+	pathUrl := url.URL{Path: absPath}
+	fmt.Fprintf(info, "[Trash Info]\nPath=%v\nDeletionDate=%v\n", pathUrl.EscapedPath(), time.Now().Format("2006-01-02T15:04:05"))
+	info.Close()
+
+	destPath := filepath.Join(filesPath, name)
+	if err := os.Rename(absPath, destPath); err != nil {
+		// the trash may be on a different device
+		if err := Copy(absPath, destPath); err != nil {
+			os.Remove(info.Name())
+			return err
+		}
+
+		return os.RemoveAll(absPath)
+	}
+
+	return nil
+}
+
+// unexported
+
+func trashDirectory() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		u, err := user.Current()
+		if err != nil {
+			return "", fmt.Errorf("could not identify current user: %v", err)
+		}
+
+		dataHome = filepath.Join(u.HomeDir, ".local", "share")
+	}
+
+	return filepath.Join(dataHome, "Trash"), nil
+}
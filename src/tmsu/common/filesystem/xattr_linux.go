@@ -0,0 +1,37 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package filesystem
+
+import (
+	"syscall"
+)
+
+// Sets an extended attribute of the file at the specified path.
+func SetXattr(path, name string, value []byte) error {
+	return syscall.Setxattr(path, name, value, 0)
+}
+
+// Removes an extended attribute from the file at the specified path. It is not
+// an error for the file to lack the attribute.
+func RemoveXattr(path, name string) error {
+	if err := syscall.Removexattr(path, name); err != nil && err != syscall.ENODATA {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,283 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package finder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// The extended attribute in which macOS stores a file's Finder tags.
+const Attribute = "com.apple.metadata:_kMDItemUserTags"
+
+var plistMagic = []byte("bplist00")
+
+// The name of the tag in an entry of the attribute, which may be followed by
+// the index of the tag's colour, e.g. 'Red\n6'.
+func TagName(entry string) string {
+	if index := strings.IndexByte(entry, '\n'); index != -1 {
+		return entry[:index]
+	}
+
+	return entry
+}
+
+// Decodes the entries of the attribute: a binary property list holding an
+// array of strings.
+func Decode(data []byte) ([]string, error) {
+	if len(data) < len(plistMagic)+32 || !bytes.HasPrefix(data, plistMagic) {
+		return nil, errors.New("not a binary property list")
+	}
+
+	trailer := data[len(data)-32:]
+	offsetSize := int(trailer[6])
+	refSize := int(trailer[7])
+	objectCount := binary.BigEndian.Uint64(trailer[8:16])
+	topObject := binary.BigEndian.Uint64(trailer[16:24])
+	offsetTableOffset := binary.BigEndian.Uint64(trailer[24:32])
+
+	if offsetSize < 1 || offsetSize > 8 || refSize < 1 || refSize > 8 || topObject >= objectCount ||
+		offsetTableOffset+objectCount*uint64(offsetSize) > uint64(len(data)-32) {
+		return nil, errors.New("corrupt property list")
+	}
+
+	objectOffset := func(ref uint64) (int, error) {
+		if ref >= objectCount {
+			return 0, fmt.Errorf("object reference %v out of range", ref)
+		}
+
+		start := offsetTableOffset + ref*uint64(offsetSize)
+		offset := readUint(data[start : start+uint64(offsetSize)])
+		if offset >= offsetTableOffset {
+			return 0, fmt.Errorf("object offset %v out of range", offset)
+		}
+
+		return int(offset), nil
+	}
+
+	offset, err := objectOffset(topObject)
+	if err != nil {
+		return nil, err
+	}
+
+	marker := data[offset]
+	if marker>>4 != 0xA {
+		return nil, errors.New("property list does not hold an array")
+	}
+
+	count, offset, err := readCount(data, offset)
+	if err != nil {
+		return nil, err
+	}
+	if offset+count*refSize > int(offsetTableOffset) {
+		return nil, errors.New("corrupt property list")
+	}
+
+	entries := make([]string, 0, count)
+	for index := 0; index < count; index++ {
+		start := offset + index*refSize
+		ref := readUint(data[start : start+refSize])
+
+		stringOffset, err := objectOffset(ref)
+		if err != nil {
+			return nil, err
+		}
+
+		entry, err := readString(data[:offsetTableOffset], stringOffset)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Encodes the entries of the attribute as a binary property list.
+func Encode(entries []string) []byte {
+	objectCount := len(entries) + 1
+
+	refSize := 1
+	if objectCount > 0xFF {
+		refSize = 2
+	}
+
+	var buffer bytes.Buffer
+	buffer.Write(plistMagic)
+
+	offsets := make([]int, 0, objectCount)
+
+	offsets = append(offsets, buffer.Len())
+	writeMarker(&buffer, 0xA, len(entries))
+	for index := range entries {
+		writeUint(&buffer, uint64(index+1), refSize)
+	}
+
+	for _, entry := range entries {
+		offsets = append(offsets, buffer.Len())
+		writeString(&buffer, entry)
+	}
+
+	offsetTableOffset := buffer.Len()
+	offsetSize := uintSize(uint64(offsetTableOffset))
+	for _, offset := range offsets {
+		writeUint(&buffer, uint64(offset), offsetSize)
+	}
+
+	trailer := make([]byte, 32)
+	trailer[6] = byte(offsetSize)
+	trailer[7] = byte(refSize)
+	binary.BigEndian.PutUint64(trailer[8:16], uint64(objectCount))
+	binary.BigEndian.PutUint64(trailer[16:24], 0)
+	binary.BigEndian.PutUint64(trailer[24:32], uint64(offsetTableOffset))
+	buffer.Write(trailer)
+
+	return buffer.Bytes()
+}
+
+// unexported
+
+func readUint(data []byte) uint64 {
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+
+	return value
+}
+
+// Reads the count held in, or following, an object's marker, returning it
+// along with the offset of the object's contents.
+func readCount(data []byte, offset int) (int, int, error) {
+	count := int(data[offset] & 0x0F)
+	offset++
+
+	if count != 0x0F {
+		return count, offset, nil
+	}
+
+	if offset >= len(data) || data[offset]>>4 != 0x1 {
+		return 0, 0, errors.New("corrupt property list: bad object count")
+	}
+
+	size := 1 << (data[offset] & 0x0F)
+	offset++
+	if size > 8 || offset+size > len(data) {
+		return 0, 0, errors.New("corrupt property list: bad object count")
+	}
+
+	return int(readUint(data[offset : offset+size])), offset + size, nil
+}
+
+func readString(data []byte, offset int) (string, error) {
+	marker := data[offset] >> 4
+
+	count, offset, err := readCount(data, offset)
+	if err != nil {
+		return "", err
+	}
+
+	switch marker {
+	case 0x5:
+		if offset+count > len(data) {
+			return "", errors.New("corrupt property list: string out of range")
+		}
+
+		return string(data[offset : offset+count]), nil
+	case 0x6:
+		if offset+2*count > len(data) {
+			return "", errors.New("corrupt property list: string out of range")
+		}
+
+		units := make([]uint16, count)
+		for index := range units {
+			units[index] = binary.BigEndian.Uint16(data[offset+2*index:])
+		}
+
+		return string(utf16.Decode(units)), nil
+	default:
+		return "", errors.New("property list array holds an object that is not a string")
+	}
+}
+
+func writeMarker(buffer *bytes.Buffer, kind byte, count int) {
+	if count < 0x0F {
+		buffer.WriteByte(kind<<4 | byte(count))
+		return
+	}
+
+	buffer.WriteByte(kind<<4 | 0x0F)
+
+	size := uintSize(uint64(count))
+	switch size {
+	case 1:
+		buffer.WriteByte(0x10)
+	case 2:
+		buffer.WriteByte(0x11)
+	default:
+		buffer.WriteByte(0x12)
+	}
+	writeUint(buffer, uint64(count), size)
+}
+
+func writeString(buffer *bytes.Buffer, value string) {
+	ascii := true
+	for index := 0; index < len(value); index++ {
+		if value[index] >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+
+	if ascii {
+		writeMarker(buffer, 0x5, len(value))
+		buffer.WriteString(value)
+		return
+	}
+
+	units := utf16.Encode([]rune(value))
+	writeMarker(buffer, 0x6, len(units))
+	for _, unit := range units {
+		writeUint(buffer, uint64(unit), 2)
+	}
+}
+
+func writeUint(buffer *bytes.Buffer, value uint64, size int) {
+	for shift := uint(8 * (size - 1)); ; shift -= 8 {
+		buffer.WriteByte(byte(value >> shift))
+		if shift == 0 {
+			break
+		}
+	}
+}
+
+func uintSize(value uint64) int {
+	switch {
+	case value <= 0xFF:
+		return 1
+	case value <= 0xFFFF:
+		return 2
+	default:
+		return 4
+	}
+}
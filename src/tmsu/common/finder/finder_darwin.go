@@ -0,0 +1,55 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package finder
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// Reads the Finder tag entries of the file at the specified path.
+func ReadTags(path string) ([]string, error) {
+	size, err := unix.Getxattr(path, Attribute, nil)
+	if err != nil {
+		if err == unix.ENOATTR {
+			return []string{}, nil
+		}
+
+		return nil, err
+	}
+
+	data := make([]byte, size)
+	size, err = unix.Getxattr(path, Attribute, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return Decode(data[:size])
+}
+
+// Replaces the Finder tag entries of the file at the specified path.
+func WriteTags(path string, entries []string) error {
+	if len(entries) == 0 {
+		if err := unix.Removexattr(path, Attribute); err != nil && err != unix.ENOATTR {
+			return err
+		}
+
+		return nil
+	}
+
+	return unix.Setxattr(path, Attribute, Encode(entries), 0)
+}
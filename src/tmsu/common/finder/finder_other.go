@@ -0,0 +1,34 @@
+// +build !darwin
+
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package finder
+
+import (
+	"errors"
+)
+
+var errUnsupported = errors.New("Finder tags are only supported on macOS")
+
+func ReadTags(path string) ([]string, error) {
+	return nil, errUnsupported
+}
+
+func WriteTags(path string, entries []string) error {
+	return errUnsupported
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package finder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecode(test *testing.T) {
+	entries := []string{"Red\n6", "holiday", "année=2014", strings.Repeat("x", 20)}
+
+	decoded, err := Decode(Encode(entries))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if strings.Join(decoded, "|") != strings.Join(entries, "|") {
+		test.Fatalf("expected %q but was %q", entries, decoded)
+	}
+}
+
+func TestDecodeFinderAttribute(test *testing.T) {
+	// as written by Finder for the tags 'Red' and 'work'
+	data := []byte("bplist00\xa2\x01\x02\x55Red\n6\x54work\x08\x0b\x11" +
+		"\x00\x00\x00\x00\x00\x00\x01\x01\x00\x00\x00\x00\x00\x00\x00\x03\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x16")
+
+	entries, err := Decode(data)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if len(entries) != 2 || TagName(entries[0]) != "Red" || entries[1] != "work" {
+		test.Fatalf("unexpected entries: %q", entries)
+	}
+}
+
+func TestDecodeRejectsGarbage(test *testing.T) {
+	if _, err := Decode([]byte("not a property list")); err == nil {
+		test.Fatal("garbage was decoded")
+	}
+}
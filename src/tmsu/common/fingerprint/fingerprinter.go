@@ -24,6 +24,8 @@ import (
 	"encoding/hex"
 	"fmt"
 	"hash"
+	"hash/crc64"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -47,6 +49,12 @@ func Create(path, fingerprintAlgorithm string) (Fingerprint, error) {
 		return regularFingerprint(path, sha1.New())
 	case "MD5":
 		return regularFingerprint(path, md5.New())
+	case "dynamic:CRC64":
+		return dynamicFingerprint(path, crc64.New(crc64.MakeTable(crc64.ECMA)))
+	case "CRC64":
+		return regularFingerprint(path, crc64.New(crc64.MakeTable(crc64.ECMA)))
+	case "gitBlob":
+		return gitBlobFingerprint(path)
 	case "symlinkTargetName":
 		return symlinkTargetName(path, true)
 	case "symlinkTargetNameNoExt":
@@ -56,6 +64,90 @@ func Create(path, fingerprintAlgorithm string) (Fingerprint, error) {
 	}
 }
 
+// Determines whether the algorithm fingerprints a regular file of the specified
+// size with the SHA-256 hash of its entire contents, as 'sha256sum' reports.
+func IsSha256Sum(fingerprintAlgorithm string, fileSize int64) bool {
+	switch fingerprintAlgorithm {
+	case "SHA256":
+		return true
+	case "dynamic:SHA256", "":
+		return fileSize <= sparseFingerprintThreshold
+	}
+
+	return false
+}
+
+// Create a fingerprint from contents that can only be read once, such as a
+// download, of the specified size (or -1 if unknown). Where the algorithm
+// would sample a large file, or cannot be applied to a stream, the fingerprint
+// is empty.
+func CreateFromReader(reader io.Reader, size int64, fingerprintAlgorithm string) (Fingerprint, error) {
+	var h hash.Hash
+	sampled := false
+
+	switch fingerprintAlgorithm {
+	case "dynamic:SHA256", "":
+		h, sampled = sha256.New(), true
+	case "dynamic:SHA1":
+		h, sampled = sha1.New(), true
+	case "dynamic:MD5":
+		h, sampled = md5.New(), true
+	case "dynamic:CRC64":
+		h, sampled = crc64.New(crc64.MakeTable(crc64.ECMA)), true
+	case "SHA256":
+		h = sha256.New()
+	case "SHA1":
+		h = sha1.New()
+	case "MD5":
+		h = md5.New()
+	case "CRC64":
+		h = crc64.New(crc64.MakeTable(crc64.ECMA))
+	case "gitBlob":
+		if size < 0 {
+			return EMPTY, nil
+		}
+
+		h = sha1.New()
+		fmt.Fprintf(h, "blob %v\x00", size)
+	case "symlinkTargetName", "symlinkTargetNameNoExt":
+		return EMPTY, nil
+	default:
+		return "", fmt.Errorf("unsupported fingerprint algorithm '%v'.", fingerprintAlgorithm)
+	}
+
+	if sampled {
+		if size > sparseFingerprintThreshold {
+			return EMPTY, nil
+		}
+
+		// the size may be unknown, so stop reading once past the threshold
+		reader = io.LimitReader(reader, sparseFingerprintThreshold+1)
+	}
+
+	count, err := io.Copy(h, reader)
+	if err != nil {
+		return EMPTY, err
+	}
+	if sampled && count > sparseFingerprintThreshold {
+		return EMPTY, nil
+	}
+
+	sum := h.Sum(make([]byte, 0, 64))
+
+	return Fingerprint(hex.EncodeToString(sum)), nil
+}
+
+// Create a fingerprint for a symbolic link from the path it targets, such that
+// the link is identified by where it points rather than what it points to.
+func CreateForLink(path string) (Fingerprint, error) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return EMPTY, fmt.Errorf("'%v': could not determine target of symbolic link: %v", path, err)
+	}
+
+	return Fingerprint(target), nil
+}
+
 // unexported
 
 func regularFingerprint(path string, h hash.Hash) (Fingerprint, error) {
@@ -96,6 +188,27 @@ func dynamicFingerprint(path string, h hash.Hash) (Fingerprint, error) {
 	return calculateRegularFingerprint(path, h)
 }
 
+// Uses the hash git would give the file's contents as a blob so that files
+// within a git work tree can be matched to the objects in the repository.
+func gitBlobFingerprint(path string) (Fingerprint, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return EMPTY, nil
+		}
+
+		return EMPTY, fmt.Errorf("'%v': could not determine if path is a directory: %v", path, err)
+	}
+	if stat.IsDir() {
+		return EMPTY, nil
+	}
+
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %v\x00", stat.Size())
+
+	return calculateRegularFingerprint(path, h)
+}
+
 // Uses the symoblic target's filename as the fingerprint
 func symlinkTargetName(path string, includeExtension bool) (Fingerprint, error) {
 	stat, err := os.Lstat(path)
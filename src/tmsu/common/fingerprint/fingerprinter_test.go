@@ -20,6 +20,7 @@ package fingerprint
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -46,3 +47,58 @@ func TestRegularGeneration(test *testing.T) {
 		test.Fatal("Fingerprint incorrect.")
 	}
 }
+
+func TestGitBlobGeneration(test *testing.T) {
+	tempFilePath := filepath.Join(os.TempDir(), "tmsu-fingerprint")
+
+	file, err := os.Create(tempFilePath)
+	if err != nil {
+		test.Fatal(err.Error())
+	}
+	defer os.Remove(tempFilePath)
+
+	_, err = file.WriteString("hello\n")
+	if err != nil {
+		test.Fatal(err.Error())
+	}
+
+	fingerprint, err := Create(tempFilePath, "gitBlob")
+	if err != nil {
+		test.Fatal(err.Error())
+	}
+
+	if fingerprint != Fingerprint("ce013625030ba8dba906f756967f9e9ca394464a") {
+		test.Fatal("Fingerprint incorrect.")
+	}
+}
+
+func TestReaderGeneration(test *testing.T) {
+	content := "They were the footprints of a giagantic hound."
+
+	fingerprint, err := CreateFromReader(strings.NewReader(content), int64(len(content)), "")
+	if err != nil {
+		test.Fatal(err.Error())
+	}
+
+	if fingerprint != Fingerprint("87d74123749a45e4c4e5e9053986d7ae878268a8e301d1b8125791517c0d39bf") {
+		test.Fatal("Fingerprint incorrect.")
+	}
+
+	fingerprint, err = CreateFromReader(strings.NewReader("hello\n"), -1, "gitBlob")
+	if err != nil {
+		test.Fatal(err.Error())
+	}
+
+	if fingerprint != EMPTY {
+		test.Fatal("Fingerprint should be empty where the size is unknown.")
+	}
+
+	fingerprint, err = CreateFromReader(strings.NewReader("hello\n"), 6, "gitBlob")
+	if err != nil {
+		test.Fatal(err.Error())
+	}
+
+	if fingerprint != Fingerprint("ce013625030ba8dba906f756967f9e9ca394464a") {
+		test.Fatal("Fingerprint incorrect.")
+	}
+}
@@ -20,30 +20,50 @@ package log
 import (
 	"fmt"
 	"os"
+	"strings"
 )
 
 var Verbosity uint = 1
 
+// Whether warnings and errors are written in the tab-separated form intended
+// for scripts: 'warning MESSAGE' and 'error CODE KIND MESSAGE'.
+var Porcelain = false
+
 func Fatal(values ...interface{}) {
-	Warn(values...)
+	if Porcelain {
+		Error(1, "failure", strings.TrimSuffix(fmt.Sprintln(values...), "\n"))
+	} else {
+		Warn(values...)
+	}
+
 	os.Exit(1)
 }
 
 func Fatalf(format string, values ...interface{}) {
-	Warnf(format, values...)
+	if Porcelain {
+		Error(1, "failure", fmt.Sprintf(format, values...))
+	} else {
+		Warnf(format, values...)
+	}
+
 	os.Exit(1)
 }
 
 func Warn(values ...interface{}) {
-	fmt.Fprint(os.Stderr, "tmsu: ")
+	fmt.Fprint(os.Stderr, warningPrefix())
 	fmt.Fprintln(os.Stderr, values...)
 }
 
 func Warnf(format string, values ...interface{}) {
-	format = "tmsu: " + format + "\n"
+	format = warningPrefix() + format + "\n"
 	fmt.Fprintf(os.Stderr, format, values...)
 }
 
+// Writes the porcelain error line reporting the exit code and kind of error.
+func Error(code int, kind, message string) {
+	fmt.Fprintf(os.Stderr, "error\t%v\t%v\t%v\n", code, kind, message)
+}
+
 func Info(verbosity uint, values ...interface{}) {
 	if verbosity > Verbosity {
 		return
@@ -61,3 +81,13 @@ func Infof(verbosity uint, format string, values ...interface{}) {
 	format = "tmsu: " + format + "\n"
 	fmt.Printf(format, values...)
 }
+
+// unexported
+
+func warningPrefix() string {
+	if Porcelain {
+		return "warning\t"
+	}
+
+	return "tmsu: "
+}
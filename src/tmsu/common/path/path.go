@@ -28,6 +28,16 @@ type FileSystemFile struct {
 	IsDir bool
 }
 
+// The root of the filesystem containing the specified path, e.g. '/' or 'C:\'.
+func Root(path string) string {
+	return filepath.VolumeName(path) + string(filepath.Separator)
+}
+
+// Determines whether the path is the root of a filesystem.
+func IsRoot(path string) bool {
+	return filepath.Dir(path) == path
+}
+
 func Rel(path string) string {
 	workingDirectory, err := os.Getwd()
 	if err != nil {
@@ -49,3 +59,75 @@ func RelTo(path, to string) string {
 
 	return path
 }
+
+// Determines whether the path is a URL, e.g. 's3://bucket/key', rather than a
+// path on the local filesystem.
+func IsUrl(path string) bool {
+	index := strings.Index(path, "://")
+	if index < 1 {
+		return false
+	}
+
+	for position, char := range path[:index] {
+		switch {
+		case char >= 'a' && char <= 'z', char >= 'A' && char <= 'Z':
+		case position > 0 && (char >= '0' && char <= '9' || char == '+' || char == '-' || char == '.'):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// Splits the path into its directory and name. Unlike filepath.Dir and
+// filepath.Base, the separator of a URL is left intact.
+func Split(path string) (string, string) {
+	if !IsUrl(path) {
+		return filepath.Dir(path), filepath.Base(path)
+	}
+
+	path = strings.TrimRight(path, "/")
+	hostIndex := strings.Index(path, "://") + 3
+
+	index := strings.LastIndex(path, "/")
+	if index < hostIndex {
+		return path[:hostIndex], path[hostIndex:]
+	}
+
+	return path[:index], path[index+1:]
+}
+
+// The absolute path or, for a URL, the URL itself.
+func Abs(path string) (string, error) {
+	if IsUrl(path) {
+		return path, nil
+	}
+
+	return filepath.Abs(path)
+}
+
+// Cleans the path as filepath.Clean does but, for a URL, only removes any
+// trailing separators as cleaning would merge the separators after the scheme.
+func Clean(path string) string {
+	if !IsUrl(path) {
+		return filepath.Clean(path)
+	}
+
+	hostIndex := strings.Index(path, "://") + 3
+
+	return path[:hostIndex] + strings.TrimRight(path[hostIndex:], "/")
+}
+
+// Joins a directory and name split by Split.
+func Join(directory, name string) string {
+	if !IsUrl(directory) {
+		return filepath.Join(directory, name)
+	}
+
+	if strings.HasSuffix(directory, "/") {
+		return directory + name
+	}
+
+	return directory + "/" + name
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package path
+
+import (
+	"testing"
+)
+
+func TestIsUrl(test *testing.T) {
+	for _, path := range []string{"s3://bucket/key", "https://example.org/a.jpg", "git+ssh://host/repo"} {
+		if !IsUrl(path) {
+			test.Fatalf("Expected '%v' to be a URL.", path)
+		}
+	}
+
+	for _, path := range []string{"/tmp/a", "a/b", "://a", "/tmp/a://b", "1s3://bucket"} {
+		if IsUrl(path) {
+			test.Fatalf("Expected '%v' not to be a URL.", path)
+		}
+	}
+}
+
+func TestSplitUrl(test *testing.T) {
+	directory, name := Split("s3://bucket/photos/a.jpg")
+	if directory != "s3://bucket/photos" || name != "a.jpg" {
+		test.Fatalf("Unexpected split: '%v', '%v'.", directory, name)
+	}
+	if Join(directory, name) != "s3://bucket/photos/a.jpg" {
+		test.Fatalf("Unexpected join: '%v'.", Join(directory, name))
+	}
+
+	directory, name = Split("https://example.org")
+	if directory != "https://" || name != "example.org" {
+		test.Fatalf("Unexpected split: '%v', '%v'.", directory, name)
+	}
+	if Join(directory, name) != "https://example.org" {
+		test.Fatalf("Unexpected join: '%v'.", Join(directory, name))
+	}
+
+	directory, name = Split("/tmp/a/b")
+	if directory != "/tmp/a" || name != "b" {
+		test.Fatalf("Unexpected split: '%v', '%v'.", directory, name)
+	}
+}
+
+func TestCleanUrl(test *testing.T) {
+	cleaned := map[string]string{"s3://bucket/photos/": "s3://bucket/photos",
+		"s3://bucket":  "s3://bucket",
+		"s3://":        "s3://",
+		"/tmp/a/../b/": "/tmp/b"}
+
+	for path, expected := range cleaned {
+		if actual := Clean(path); actual != expected {
+			test.Fatalf("Expected '%v' to be cleaned to '%v' but was '%v'.", path, expected, actual)
+		}
+	}
+}
@@ -28,7 +28,7 @@ type Tree struct {
 }
 
 func NewTree() *Tree {
-	return &Tree{newNode("/", false, true)}
+	return &Tree{newNode("", false, true)}
 }
 
 // Adds a path to the tree
@@ -40,6 +40,11 @@ func (tree *Tree) Add(path string, isDir bool) {
 	for index, pathPart := range pathParts {
 		isReal := index == partCount-1
 
+		if index == 0 && filepath.VolumeName(pathPart) == pathPart {
+			// root of an absolute path, e.g. '/' or 'C:\'
+			pathPart += string(filepath.Separator)
+		}
+
 		if pathPart == "" {
 			pathPart = "/"
 		}
@@ -19,12 +19,28 @@ package terminal
 
 import (
 	"fmt"
+	"os"
+	"strconv"
 	"strings"
 	"tmsu/common/terminal/ansi"
 )
 
 const ETX rune = '\003'
 
+// The width to use when columns are requested: that of the terminal, else
+// that given by the COLUMNS environment variable, else 80 characters.
+func ColumnWidth() int {
+	if width := Width(); width > 0 {
+		return width
+	}
+
+	if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && width > 0 {
+		return width
+	}
+
+	return 80
+}
+
 func PrintColumns(items []string) {
 	PrintColumnsWidth(items, Width())
 }
@@ -93,7 +109,7 @@ func PrintColumnsWidth(items []string, width int) {
 
 			fmt.Print(item)
 
-			if columnIndex < cols-1 {
+			if columnIndex < cols-1 && itemIndex+rows < len(items) {
 				itemLength := len(ansi.Strip(item))
 				padding := (colWidths[columnIndex] + padding) - itemLength
 				fmt.Print(strings.Repeat(" ", padding))
@@ -1,3 +1,5 @@
+// +build !windows
+
 /*
 Copyright 2011-2015 Paul Ruane.
 
@@ -15,8 +17,6 @@ You should have received a copy of the GNU General Public License
 along with this program.  If not, see <http://www.gnu.org/licenses/>.
 */
 
-// +build !windows
-
 package terminal
 
 import (
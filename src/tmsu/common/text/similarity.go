@@ -0,0 +1,83 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package text
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Splits text, such as a file name, into lower-case words at the characters
+// that are neither letters nor digits, e.g. 'Beach_Holiday-2014' into 'beach',
+// 'holiday' and '2014'.
+func Words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// The similarity of two strings, from 0 for entirely different to 1 for
+// identical, based upon the edit distance between them.
+func Similarity(a, b string) float64 {
+	aRunes, bRunes := []rune(a), []rune(b)
+
+	longest := len(aRunes)
+	if len(bRunes) > longest {
+		longest = len(bRunes)
+	}
+	if longest == 0 {
+		return 1
+	}
+
+	return 1 - float64(editDistance(aRunes, bRunes))/float64(longest)
+}
+
+// unexported
+
+// The Levenshtein distance: the number of insertions, deletions and
+// substitutions needed to turn one string into the other.
+func editDistance(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
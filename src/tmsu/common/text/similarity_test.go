@@ -0,0 +1,44 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package text
+
+import (
+	"testing"
+)
+
+func TestWords(test *testing.T) {
+	words := Words("Beach_Holiday-2014 (copy).JPG")
+
+	if len(words) != 5 || words[0] != "beach" || words[1] != "holiday" || words[2] != "2014" || words[3] != "copy" || words[4] != "jpg" {
+		test.Fatalf("splitting into words failed: %v", words)
+	}
+}
+
+func TestSimilarity(test *testing.T) {
+	if similarity := Similarity("holiday", "holiday"); similarity != 1 {
+		test.Fatalf("identical strings have similarity %v", similarity)
+	}
+
+	if similarity := Similarity("holiday", "holidays"); similarity != 0.875 {
+		test.Fatalf("expected similarity 0.875 but was %v", similarity)
+	}
+
+	if similarity := Similarity("kitten", "sitting"); similarity != 1-3.0/7 {
+		test.Fatalf("expected similarity %v but was %v", 1-3.0/7, similarity)
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package xmp reads and writes the keywords (the 'dc:subject' property) of
+// Extensible Metadata Platform packets, as embedded in images or held in
+// sidecar files.
+package xmp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+const dublinCoreNamespace = "http://purl.org/dc/elements/1.1/"
+const rdfNamespace = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+
+var packetStart = []byte("<x:xmpmeta")
+var packetEnd = []byte("</x:xmpmeta>")
+
+var subjectPattern = regexp.MustCompile(`(?s)<dc:subject\s*>.*?</dc:subject\s*>|<dc:subject\s*/>`)
+var descriptionPattern = regexp.MustCompile(`<rdf:Description\b[^>]*[^/]>`)
+
+// Finds the XMP packet within the data of a file, such as a JPEG or TIFF image,
+// in which it is stored uncompressed. Returns nil if there is no packet.
+func FindPacket(data []byte) []byte {
+	start := bytes.Index(data, packetStart)
+	if start == -1 {
+		return nil
+	}
+
+	end := bytes.Index(data[start:], packetEnd)
+	if end == -1 {
+		return nil
+	}
+
+	return data[start : start+end+len(packetEnd)]
+}
+
+// Reads the keywords from an XMP packet.
+func ReadKeywords(packet []byte) ([]string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(packet))
+
+	keywords := make([]string, 0, 10)
+	inSubject, inItem := false, false
+	var item strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse XMP: %v", err)
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			switch {
+			case element.Name.Space == dublinCoreNamespace && element.Name.Local == "subject":
+				inSubject = true
+			case inSubject && element.Name.Space == rdfNamespace && element.Name.Local == "li":
+				inItem = true
+				item.Reset()
+			}
+		case xml.EndElement:
+			switch {
+			case element.Name.Space == dublinCoreNamespace && element.Name.Local == "subject":
+				inSubject = false
+			case inItem && element.Name.Space == rdfNamespace && element.Name.Local == "li":
+				inItem = false
+
+				if keyword := strings.TrimSpace(item.String()); keyword != "" {
+					keywords = append(keywords, keyword)
+				}
+			}
+		case xml.CharData:
+			if inItem {
+				item.Write(element)
+			}
+		}
+	}
+
+	return keywords, nil
+}
+
+// Sets the keywords of an XMP sidecar, leaving its other properties as they
+// are. Where there is no existing sidecar a new one is created.
+func WriteKeywords(sidecar []byte, keywords []string) ([]byte, error) {
+	if len(bytes.TrimSpace(sidecar)) == 0 {
+		return []byte(newSidecar(keywords)), nil
+	}
+
+	subject := subjectElement(keywords)
+
+	if subjectPattern.Match(sidecar) {
+		return subjectPattern.ReplaceAllLiteral(sidecar, []byte(subject)), nil
+	}
+
+	location := descriptionPattern.FindIndex(sidecar)
+	if location == nil {
+		return nil, fmt.Errorf("could not find an rdf:Description element to add the keywords to")
+	}
+
+	description := string(sidecar[location[0]:location[1]])
+	if !strings.Contains(string(sidecar), `xmlns:dc="`) {
+		description = description[:len(description)-1] + ` xmlns:dc="` + dublinCoreNamespace + `">`
+	}
+
+	var updated bytes.Buffer
+	updated.Write(sidecar[:location[0]])
+	updated.WriteString(description)
+	updated.WriteString("\n   " + subject)
+	updated.Write(sidecar[location[1]:])
+
+	return updated.Bytes(), nil
+}
+
+// unexported
+
+func newSidecar(keywords []string) string {
+	return `<?xpacket begin="` + "\uFEFF" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="` + rdfNamespace + `">
+  <rdf:Description rdf:about=""
+    xmlns:dc="` + dublinCoreNamespace + `">
+   ` + subjectElement(keywords) + `
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>
+`
+}
+
+func subjectElement(keywords []string) string {
+	var element strings.Builder
+
+	element.WriteString("<dc:subject>\n    <rdf:Bag>\n")
+	for _, keyword := range keywords {
+		element.WriteString("     <rdf:li>")
+		xml.EscapeText(&element, []byte(keyword))
+		element.WriteString("</rdf:li>\n")
+	}
+	element.WriteString("    </rdf:Bag>\n   </dc:subject>")
+
+	return element.String()
+}
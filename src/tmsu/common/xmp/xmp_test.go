@@ -0,0 +1,86 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+package xmp
+
+import (
+	"testing"
+)
+
+const lightroomSidecar = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmp:Rating="3">
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+func TestFindPacket(test *testing.T) {
+	data := []byte("\xff\xd8\xff\xe1 junk " + lightroomSidecar + " more junk")
+
+	packet := FindPacket(data)
+
+	if string(packet) != lightroomSidecar {
+		test.Fatalf("packet not found: %v", string(packet))
+	}
+}
+
+func TestWriteAndReadKeywords(test *testing.T) {
+	sidecar, err := WriteKeywords([]byte(lightroomSidecar), []string{"mountain", "year=2014", "a&b"})
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	keywords, err := ReadKeywords(sidecar)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if len(keywords) != 3 || keywords[0] != "mountain" || keywords[1] != "year=2014" || keywords[2] != "a&b" {
+		test.Fatalf("keywords not as expected: %v", keywords)
+	}
+
+	sidecar, err = WriteKeywords(sidecar, []string{"lake"})
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	keywords, err = ReadKeywords(sidecar)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if len(keywords) != 1 || keywords[0] != "lake" {
+		test.Fatalf("keywords not replaced: %v", keywords)
+	}
+}
+
+func TestNewSidecar(test *testing.T) {
+	sidecar, err := WriteKeywords(nil, []string{"mountain"})
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	keywords, err := ReadKeywords(sidecar)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if len(keywords) != 1 || keywords[0] != "mountain" {
+		test.Fatalf("keywords not as expected: %v", keywords)
+	}
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package entities
+
+import (
+	"time"
+	"tmsu/common/fingerprint"
+	_path "tmsu/common/path"
+)
+
+type DeletedFileId uint
+
+// A record of a file that was removed from the database, retained so that its
+// tagging history is not lost.
+type DeletedFile struct {
+	Id          DeletedFileId
+	Directory   string
+	Name        string
+	Fingerprint fingerprint.Fingerprint
+	Deleted     time.Time
+	Tags        DeletedFileTags
+}
+
+func (file DeletedFile) Path() string {
+	return _path.Join(file.Directory, file.Name)
+}
+
+type DeletedFiles []*DeletedFile
+
+// A tag (and optional value) that was applied to a deleted file. Names are
+// recorded rather than identifiers as the tags may since have been deleted.
+type DeletedFileTag struct {
+	TagName   string
+	ValueName string
+}
+
+type DeletedFileTags []DeletedFileTag
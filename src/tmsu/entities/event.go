@@ -0,0 +1,48 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package entities
+
+import (
+	"time"
+)
+
+// The types of change event.
+const (
+	EventTag        = "tag"
+	EventUntag      = "untag"
+	EventFileAdd    = "file-add"
+	EventFileMove   = "file-move"
+	EventFileRemove = "file-remove"
+	EventTagAdd     = "tag-add"
+	EventTagRename  = "tag-rename"
+	EventTagRemove  = "tag-remove"
+)
+
+// A change made to the database. Events are numbered sequentially in the
+// order they occurred.
+type Event struct {
+	Seq       uint
+	Time      time.Time
+	Type      string
+	Path      string
+	TagName   string
+	ValueName string
+	Previous  string
+}
+
+type Events []*Event
@@ -18,10 +18,10 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package entities
 
 import (
-	"path/filepath"
 	"sort"
 	"time"
 	"tmsu/common/fingerprint"
+	_path "tmsu/common/path"
 )
 
 type FileId uint
@@ -69,8 +69,19 @@ type File struct {
 	IsDir       bool
 }
 
+// Restricts the files matching a query by their type, by their position
+// relative to the other matching files and by when they were tagged.
+type FileFilter struct {
+	DirectoriesOnly bool      // only directories
+	FilesOnly       bool      // only regular files
+	TopOnly         bool      // omit items within another matching directory
+	LeavesOnly      bool      // omit directories containing other matching items
+	TaggedSince     time.Time // only files with a tag applied since (if not zero)
+	Sample          uint      // only this many files chosen at random (if not zero)
+}
+
 func (file File) Path() string {
-	return filepath.Join(file.Directory, file.Name)
+	return _path.Join(file.Directory, file.Name)
 }
 
 type Files []*File
@@ -87,6 +98,16 @@ func (files Files) Where(predicate func(*File) bool) Files {
 	return result
 }
 
+// A file along with how similar its tags are to those of another file, from 0
+// for no tags in common to 1 for exactly the same tags.
+type SimilarFile struct {
+	File       *File
+	SharedTags uint
+	Score      float64
+}
+
+type SimilarFiles []*SimilarFile
+
 type FileTagCount struct {
 	FileId    FileId
 	Directory string
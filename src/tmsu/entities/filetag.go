@@ -17,16 +17,27 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
 package entities
 
+import (
+	"time"
+)
+
 type FileTag struct {
 	FileId   FileId
 	TagId    TagId
 	ValueId  ValueId
+	Owner    string
 	Explicit bool
 	Implicit bool
 }
 
 type FileTags []*FileTag
 
+// A tag, optionally with a value, to be applied to a file.
+type TagValuePair struct {
+	TagId   TagId
+	ValueId ValueId
+}
+
 func (fileTags FileTags) Contains(tagId TagId, valueId ValueId) bool {
 	for _, fileTag := range fileTags {
 		if fileTag.TagId == tagId && fileTag.ValueId == valueId {
@@ -73,3 +84,15 @@ func (fileTags FileTags) Find(fileId FileId, tagId TagId, valueId ValueId) *File
 
 	return nil
 }
+
+// When and by which subcommand a tag (and optional value) was applied to a
+// file. The time is zero for file tags applied before this was recorded.
+type FileTagApplication struct {
+	TagName   string
+	ValueName string
+	Owner     string
+	Applied   time.Time
+	Command   string
+}
+
+type FileTagApplications []*FileTagApplication
@@ -17,9 +17,31 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
 package entities
 
+// A tag implication: whenever ImplyingTag is applied, ImpliedTag is implied.
+// Where ImplyingValue is specified the implication holds only when
+// ImplyingTag is applied with that value.
 type Implication struct {
-	ImplyingTag Tag
-	ImpliedTag  Tag
+	ImplyingTag   Tag
+	ImplyingValue Value
+	ImpliedTag    Tag
+}
+
+// Whether the implication holds for the tag applied with the value.
+func (implication Implication) AppliesTo(tagId TagId, valueId ValueId) bool {
+	if implication.ImplyingTag.Id != tagId {
+		return false
+	}
+
+	return implication.ImplyingValue.Id == 0 || implication.ImplyingValue.Id == valueId
+}
+
+// The implying tag and, if there is one, value as TAG or TAG=VALUE.
+func (implication Implication) ImplyingName() string {
+	if implication.ImplyingValue.Id == 0 {
+		return implication.ImplyingTag.Name
+	}
+
+	return implication.ImplyingTag.Name + "=" + implication.ImplyingValue.Name
 }
 
 type Implications []*Implication
@@ -33,3 +55,28 @@ func (implications Implications) Implies(tagId TagId) bool {
 
 	return false
 }
+
+// The implications that hold when the tag is applied with the value: those
+// that apply to it directly and those that follow from the tags they imply.
+func (implications Implications) Holding(tagId TagId, valueId ValueId) Implications {
+	holding := make(Implications, 0, len(implications))
+	seen := make(map[*Implication]bool, len(implications))
+
+	var visit func(tagId TagId, valueId ValueId)
+	visit = func(tagId TagId, valueId ValueId) {
+		for _, implication := range implications {
+			if seen[implication] || !implication.AppliesTo(tagId, valueId) {
+				continue
+			}
+
+			seen[implication] = true
+			holding = append(holding, implication)
+
+			visit(implication.ImpliedTag.Id, 0)
+		}
+	}
+
+	visit(tagId, valueId)
+
+	return holding
+}
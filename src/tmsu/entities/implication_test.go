@@ -0,0 +1,51 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package entities
+
+import (
+	"testing"
+)
+
+func TestImplicationsHolding(test *testing.T) {
+	// set-up
+
+	country, france, europe, germany := Tag{1, "country"}, Tag{2, "france"}, Tag{3, "europe"}, Tag{4, "germany"}
+	franceValue, germanyValue := Value{1, "france"}, Value{2, "germany"}
+
+	implications := Implications{
+		&Implication{country, franceValue, france},
+		&Implication{country, germanyValue, germany},
+		&Implication{france, Value{}, europe},
+		&Implication{germany, Value{}, europe}}
+
+	// test
+
+	holding := implications.Holding(country.Id, franceValue.Id)
+
+	// validate
+
+	if len(holding) != 2 || holding[0] != implications[0] || holding[1] != implications[2] {
+		test.Fatalf("Unexpected implications: %v", holding)
+	}
+	if holding.Implies(germany.Id) {
+		test.Fatalf("'germany' should not be implied by 'country=france'")
+	}
+	if len(implications.Holding(country.Id, 0)) != 0 {
+		test.Fatalf("No implication should hold for 'country' without a value")
+	}
+}
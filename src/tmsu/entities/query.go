@@ -22,3 +22,10 @@ type Query struct {
 }
 
 type Queries []*Query
+
+// The plan for a file query, as shown by 'files --explain'.
+type QueryPlan struct {
+	Sql    string        // the SQL that is run
+	Params []interface{} // the parameters of the SQL
+	Steps  []string      // the steps SQLite takes to run the SQL, indented by depth
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package entities
+
+type SearchResult struct {
+	File   *File
+	Tags   string
+	Values string
+	Score  float64
+}
+
+type SearchResults []*SearchResult
+
+func (results SearchResults) Len() int {
+	return len(results)
+}
+
+func (results SearchResults) Less(i, j int) bool {
+	if results[i].Score != results[j].Score {
+		return results[i].Score > results[j].Score
+	}
+
+	return results[i].File.Path() < results[j].File.Path()
+}
+
+func (results SearchResults) Swap(i, j int) {
+	results[i], results[j] = results[j], results[i]
+}
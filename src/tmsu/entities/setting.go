@@ -23,3 +23,15 @@ type Setting struct {
 }
 
 type Settings []*Setting
+
+func (settings Settings) Len() int {
+	return len(settings)
+}
+
+func (settings Settings) Swap(i, j int) {
+	settings[i], settings[j] = settings[j], settings[i]
+}
+
+func (settings Settings) Less(i, j int) bool {
+	return settings[i].Name < settings[j].Name
+}
@@ -0,0 +1,403 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package lib is the stable API for embedding TMSU in other Go programs.
+//
+// A Database is opened with Open and must be closed with Close. Each method
+// runs in its own transaction and a Database is safe for concurrent use.
+// Tags are identified by name and, where a value is applied, in the same
+// 'tag=value' form used on the command-line.
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"tmsu/common/fingerprint"
+	"tmsu/entities"
+	"tmsu/storage"
+)
+
+// A handle to a TMSU database.
+type Database struct {
+	store *storage.Storage
+	mutex sync.Mutex
+}
+
+// Opens the database at the specified path, creating it if necessary.
+func Open(path string) (*Database, error) {
+	store, err := storage.OpenAt(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Database{store: store}, nil
+}
+
+// Closes the database.
+func (db *Database) Close() error {
+	return db.store.Close()
+}
+
+// The path of the database file.
+func (db *Database) Path() string {
+	return db.store.Db.Path
+}
+
+// Retrieves the paths of the files matching the query, which uses the same
+// syntax as the 'files' subcommand.
+func (db *Database) Query(queryText string) ([]string, error) {
+	expression, err := db.store.ParseQuery(queryText)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse query: %v", err)
+	}
+
+	var paths []string
+	err = db.transaction(func(store *storage.Storage) error {
+		files, err := store.QueryFiles(expression, "", false)
+		if err != nil {
+			return err
+		}
+
+		paths = make([]string, len(files))
+		for index, file := range files {
+			paths[index] = file.Path()
+		}
+
+		return nil
+	})
+
+	return paths, err
+}
+
+// A change made to the database.
+type Event struct {
+	Seq      uint
+	Time     time.Time
+	Type     string
+	Path     string
+	Tag      string
+	Value    string
+	Previous string
+}
+
+// Retrieves the changes made to the database after the event with the
+// specified sequence number, oldest first. Pass zero to retrieve all events.
+func (db *Database) Events(since uint) ([]Event, error) {
+	var events []Event
+	err := db.transaction(func(store *storage.Storage) error {
+		storedEvents, err := store.EventsSince(since)
+		if err != nil {
+			return err
+		}
+
+		events = make([]Event, len(storedEvents))
+		for index, event := range storedEvents {
+			events[index] = Event{event.Seq, event.Time, event.Type, event.Path, event.TagName, event.ValueName, event.Previous}
+		}
+
+		return nil
+	})
+
+	return events, err
+}
+
+// Retrieves the names of all of the tags in the database.
+func (db *Database) AllTags() ([]string, error) {
+	var tagNames []string
+	err := db.transaction(func(store *storage.Storage) error {
+		tags, err := store.Tags()
+		if err != nil {
+			return err
+		}
+
+		tagNames = make([]string, len(tags))
+		for index, tag := range tags {
+			tagNames[index] = tag.Name
+		}
+
+		return nil
+	})
+
+	return tagNames, err
+}
+
+// Retrieves the tags applied to the file, including those implied. The result
+// is empty if the file is not tagged.
+func (db *Database) Tags(path string) ([]string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tagNames := []string{}
+	err = db.transaction(func(store *storage.Storage) error {
+		file, err := store.FileByPath(absPath)
+		if err != nil || file == nil {
+			return err
+		}
+
+		fileTags, err := store.FileTagsByFileId(file.Id, false)
+		if err != nil {
+			return err
+		}
+
+		for _, fileTag := range fileTags {
+			tagName, err := tagArgument(store, fileTag)
+			if err != nil {
+				return err
+			}
+
+			tagNames = append(tagNames, tagName)
+		}
+
+		sort.Strings(tagNames)
+
+		return nil
+	})
+
+	return tagNames, err
+}
+
+// Applies the tags to the file, adding the file to the database if necessary.
+// Tags and values are created as required, subject to the 'autoCreateTags'
+// and 'autoCreateValues' settings.
+func (db *Database) Tag(path string, tags ...string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	return db.transaction(func(store *storage.Storage) error {
+		file, err := store.FileByPath(absPath)
+		if err != nil {
+			return err
+		}
+		if file == nil {
+			if file, err = addFile(store, absPath); err != nil {
+				return err
+			}
+		}
+
+		pairs := make([]entities.TagValuePair, len(tags))
+		for index, tagArg := range tags {
+			tag, value, err := lookupTagArgument(store, tagArg, true)
+			if err != nil {
+				return err
+			}
+
+			pairs[index] = entities.TagValuePair{tag.Id, value.Id}
+		}
+
+		_, err = store.AddFileTags(file.Id, pairs)
+		return err
+	})
+}
+
+// Removes the tags from the file. The file is removed from the database if it
+// is left untagged.
+func (db *Database) Untag(path string, tags ...string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	return db.transaction(func(store *storage.Storage) error {
+		file, err := store.FileByPath(absPath)
+		if err != nil {
+			return err
+		}
+		if file == nil {
+			return fmt.Errorf("%v: file is not tagged", path)
+		}
+
+		for _, tagArg := range tags {
+			tag, value, err := lookupTagArgument(store, tagArg, false)
+			if err != nil {
+				return err
+			}
+
+			if err := store.DeleteFileTag(file.Id, tag.Id, value.Id); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Adds an implication such that files tagged with the first tag are also
+// considered to be tagged with the second. The first may be given as
+// TAG=VALUE for the implication to hold only for that value.
+func (db *Database) Imply(tagName, impliedTagName string) error {
+	return db.transaction(func(store *storage.Storage) error {
+		tag, value, err := lookupTagArgument(store, tagName, true)
+		if err != nil {
+			return err
+		}
+
+		impliedTag, impliedValue, err := lookupTagArgument(store, impliedTagName, true)
+		if err != nil {
+			return err
+		}
+		if impliedValue.Id != 0 {
+			return fmt.Errorf("implied tag '%v' cannot have a value", impliedTagName)
+		}
+
+		return store.AddImplication(tag.Id, value.Id, impliedTag.Id)
+	})
+}
+
+// Removes an implication.
+func (db *Database) Unimply(tagName, impliedTagName string) error {
+	return db.transaction(func(store *storage.Storage) error {
+		tag, value, err := lookupTagArgument(store, tagName, false)
+		if err != nil {
+			return err
+		}
+
+		impliedTag, _, err := lookupTagArgument(store, impliedTagName, false)
+		if err != nil {
+			return err
+		}
+
+		return store.RemoveImplication(tag.Id, value.Id, impliedTag.Id)
+	})
+}
+
+// unexported
+
+// Runs the function in a transaction, which is committed if the function
+// succeeds and rolled back otherwise.
+func (db *Database) transaction(fn func(*storage.Storage) error) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if err := db.store.Begin(); err != nil {
+		return err
+	}
+
+	if err := fn(db.store); err != nil {
+		db.store.Rollback()
+		return err
+	}
+
+	return db.store.Commit()
+}
+
+// Looks up the tag and value named by a 'tag' or 'tag=value' argument,
+// creating them if create is set and the settings permit.
+func lookupTagArgument(store *storage.Storage, tagArg string, create bool) (*entities.Tag, *entities.Value, error) {
+	tagName, valueName := tagArg, ""
+	if index := strings.Index(tagArg, "="); index > 0 {
+		tagName, valueName = tagArg[:index], tagArg[index+1:]
+	}
+
+	tag, err := store.TagByName(tagName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tag == nil {
+		autoCreateTags, err := store.SettingAsBool("autoCreateTags")
+		if err != nil {
+			return nil, nil, err
+		}
+		if !create || !autoCreateTags {
+			return nil, nil, fmt.Errorf("no such tag '%v'", tagName)
+		}
+
+		if tag, err = store.AddTag(tagName); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	value, err := store.ValueByName(valueName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if value == nil {
+		autoCreateValues, err := store.SettingAsBool("autoCreateValues")
+		if err != nil {
+			return nil, nil, err
+		}
+		if !create || !autoCreateValues {
+			return nil, nil, fmt.Errorf("no such value '%v'", valueName)
+		}
+
+		if value, err = store.AddValue(valueName); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return tag, value, nil
+}
+
+func tagArgument(store *storage.Storage, fileTag *entities.FileTag) (string, error) {
+	tag, err := store.Tag(fileTag.TagId)
+	if err != nil {
+		return "", err
+	}
+	if tag == nil {
+		return "", fmt.Errorf("tag '%v' does not exist", fileTag.TagId)
+	}
+
+	if fileTag.ValueId == 0 {
+		return tag.Name, nil
+	}
+
+	value, err := store.Value(fileTag.ValueId)
+	if err != nil {
+		return "", err
+	}
+	if value == nil {
+		return "", fmt.Errorf("value '%v' does not exist", fileTag.ValueId)
+	}
+
+	return tag.Name + "=" + value.Name, nil
+}
+
+func addFile(store *storage.Storage, path string) (*entities.File, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprintAlgorithm, err := store.SettingAsString("fingerprintAlgorithm")
+	if err != nil {
+		return nil, err
+	}
+
+	fp, err := fingerprint.Create(path, fingerprintAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := store.AddFile(path, fp, stat.ModTime(), stat.Size(), stat.IsDir())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.UpdateExtraFingerprints(file); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTagQueryAndUntag(test *testing.T) {
+	// set-up
+
+	databasePath := filepath.Join(os.TempDir(), "tmsu_lib_test.db")
+	defer os.Remove(databasePath)
+
+	if err := os.MkdirAll("/tmp/tmsu/lib", 0755); err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll("/tmp/tmsu/lib")
+
+	if err := ioutil.WriteFile("/tmp/tmsu/lib/a", []byte("a"), 0644); err != nil {
+		test.Fatal(err)
+	}
+
+	db, err := Open(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer db.Close()
+
+	// test
+
+	if err := db.Tag("/tmp/tmsu/lib/a", "music", "year=2015"); err != nil {
+		test.Fatal(err)
+	}
+	if err := db.Imply("music", "media"); err != nil {
+		test.Fatal(err)
+	}
+
+	paths, err := db.Query("media and year > 2014")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tags, err := db.Tags("/tmp/tmsu/lib/a")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if err := db.Untag("/tmp/tmsu/lib/a", "music", "year=2015"); err != nil {
+		test.Fatal(err)
+	}
+
+	remaining, err := db.Tags("/tmp/tmsu/lib/a")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	if len(paths) != 1 || paths[0] != "/tmp/tmsu/lib/a" {
+		test.Fatalf("Unexpected query results: %v", paths)
+	}
+
+	if len(tags) != 3 || tags[0] != "media" || tags[1] != "music" || tags[2] != "year=2015" {
+		test.Fatalf("Unexpected tags: %v", tags)
+	}
+
+	if len(remaining) != 0 {
+		test.Fatalf("Expected file to be untagged but has tags: %v", remaining)
+	}
+}
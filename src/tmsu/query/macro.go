@@ -0,0 +1,105 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Introduces a reference to a query macro, e.g. '$recent'.
+const MacroPrefix = "$"
+
+// Replaces the macro references within an expression, such as '$recent', with
+// the queries the macros stand for, which may themselves reference macros.
+// The macros are keyed by name, without the prefix.
+func ExpandMacros(expression Expression, macros map[string]string) (Expression, error) {
+	return expandMacros(expression, macros, make([]string, 0, 5))
+}
+
+// unexported
+
+func expandMacros(expression Expression, macros map[string]string, expanding []string) (Expression, error) {
+	switch exp := expression.(type) {
+	case EmptyExpression, PathExpression, TagExpression, ComparisonExpression:
+		return exp, nil
+	case MacroExpression:
+		return expandMacro(exp.Name, macros, expanding)
+	case NotExpression:
+		operand, err := expandMacros(exp.Operand, macros, expanding)
+		if err != nil {
+			return nil, err
+		}
+
+		return NotExpression{operand}, nil
+	case AndExpression:
+		left, right, err := expandOperands(exp.LeftOperand, exp.RightOperand, macros, expanding)
+		if err != nil {
+			return nil, err
+		}
+
+		return AndExpression{left, right}, nil
+	case OrExpression:
+		left, right, err := expandOperands(exp.LeftOperand, exp.RightOperand, macros, expanding)
+		if err != nil {
+			return nil, err
+		}
+
+		return OrExpression{left, right}, nil
+	default:
+		panic("unsupported token type")
+	}
+}
+
+func expandOperands(left, right Expression, macros map[string]string, expanding []string) (Expression, Expression, error) {
+	left, err := expandMacros(left, macros, expanding)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	right, err = expandMacros(right, macros, expanding)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return left, right, nil
+}
+
+func expandMacro(name string, macros map[string]string, expanding []string) (Expression, error) {
+	for index, expandingName := range expanding {
+		if expandingName == name {
+			cycle := append(append([]string{}, expanding[index:]...), name)
+			return nil, fmt.Errorf("macro '%v%v' is defined in terms of itself: %v%v", MacroPrefix, name, MacroPrefix, strings.Join(cycle, " -> "+MacroPrefix))
+		}
+	}
+
+	queryText, ok := macros[name]
+	if !ok {
+		return nil, fmt.Errorf("no such macro '%v%v'", MacroPrefix, name)
+	}
+
+	expression, err := Parse(queryText)
+	if err != nil {
+		return nil, fmt.Errorf("macro '%v%v': %v", MacroPrefix, name, err)
+	}
+	if _, ok := expression.(EmptyExpression); ok {
+		return nil, fmt.Errorf("macro '%v%v' is empty", MacroPrefix, name)
+	}
+
+	return expandMacros(expression, macros, append(expanding, name))
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package query
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandMacros(test *testing.T) {
+	macros := map[string]string{"jazz": "genre = jazz", "favourite": "$jazz and rating >= 4 or starred"}
+
+	expression, err := Parse("$favourite and not heard")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	expression, err = ExpandMacros(expression, macros)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	expected := "(genre = jazz and rating >= 4 or starred) and not heard"
+	if actual := Format(expression); actual != expected {
+		test.Fatalf("expected '%v' but was '%v'", expected, actual)
+	}
+}
+
+func TestExpandMacrosDetectsRecursion(test *testing.T) {
+	macros := map[string]string{"a": "x or $b", "b": "y and $a"}
+
+	expression, err := Parse("$a")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	_, err = ExpandMacros(expression, macros)
+	if err == nil || !strings.Contains(err.Error(), "$a -> $b -> $a") {
+		test.Fatalf("expected recursion to be reported but was: %v", err)
+	}
+}
+
+func TestExpandMacrosReportsMissingMacro(test *testing.T) {
+	expression, err := Parse("music and $missing")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if _, err := ExpandMacros(expression, map[string]string{}); err == nil {
+		test.Fatal("missing macro was not reported")
+	}
+}
+
+func TestExpandMacrosIgnoresEscapedReference(test *testing.T) {
+	expression, err := Parse(`\$jazz or $jazz`)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	expression, err = ExpandMacros(expression, map[string]string{"jazz": "genre = jazz"})
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	expected := `\$jazz or genre = jazz`
+	if actual := Format(expression); actual != expected {
+		test.Fatalf("expected '%v' but was '%v'", expected, actual)
+	}
+}
@@ -19,6 +19,31 @@ package query
 
 import (
 	"fmt"
+	"strings"
+)
+
+// The owner that stands for the current user, who is resolved when the query
+// is run. (The colon cannot occur in a user name.)
+const CurrentOwner = ":mine"
+
+const mineModifier = "mine:"
+const userModifier = "user:"
+const explicitModifier = "explicit:"
+const impliedModifier = "implied:"
+const underModifier = "under:"
+
+// How a tag within a query must have been applied for a file to match.
+type Provenance int
+
+const (
+	// The tag may be applied explicitly or implied by another tag.
+	AnyProvenance Provenance = iota
+
+	// The tag must be applied explicitly.
+	ExplicitProvenance
+
+	// The tag must be implied by another tag and not applied explicitly.
+	ImpliedProvenance
 )
 
 type Parser struct {
@@ -59,14 +84,33 @@ type NotExpression struct {
 	Operand Expression
 }
 
+// A tag within a query. Where Owner is specified only the taggings made by
+// that user match and, if Shared is also specified, those without an owner.
+// Where Inherited is specified the items within a directory that has the tag
+// also match.
 type TagExpression struct {
-	Name string
+	Name       string
+	Owner      string
+	Shared     bool
+	Provenance Provenance
+	Inherited  bool
 }
 
 type ValueExpression struct {
 	Name string
 }
 
+// Matches the items at or beneath a path.
+type PathExpression struct {
+	Path string
+}
+
+// A reference to a query macro, named without its prefix, which stands for the
+// query the macro defines until expanded.
+type MacroExpression struct {
+	Name string
+}
+
 // unexported
 
 func (parser Parser) expression() (Expression, error) {
@@ -195,6 +239,27 @@ func (parser Parser) not() (Expression, error) {
 			return nil, fmt.Errorf("unexpected token: %v", Type(token2))
 		}
 	case SymbolToken:
+		symbol := token.(SymbolToken)
+
+		if path, ok := parsePathTerm(symbol); ok {
+			parser.scanner.Next()
+			return PathExpression{path}, nil
+		}
+
+		if name, ok := parseMacroReference(symbol); ok {
+			parser.scanner.Next()
+
+			token, err := parser.scanner.LookAhead()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := token.(ComparisonOperatorToken); ok {
+				return nil, fmt.Errorf("macro '%v%v' cannot be compared", MacroPrefix, name)
+			}
+
+			return MacroExpression{name}, nil
+		}
+
 		operand, err := parser.comparison()
 		if err != nil {
 			return nil, err
@@ -240,7 +305,7 @@ func (parser Parser) tag() (TagExpression, error) {
 
 	switch typedToken := token.(type) {
 	case SymbolToken:
-		return TagExpression{typedToken.name}, nil
+		return parseTagModifiers(typedToken.name, typedToken.plain)
 	default:
 		return TagExpression{}, fmt.Errorf("unexpected token: %v.", Type(token))
 	}
@@ -259,3 +324,64 @@ func (parser Parser) value() (ValueExpression, error) {
 		return ValueExpression{}, fmt.Errorf("unexpected token: %v", Type(token))
 	}
 }
+
+// An 'under:PATH' term matches the items at or beneath PATH rather than a tag.
+func parsePathTerm(symbol SymbolToken) (string, bool) {
+	if !strings.HasPrefix(symbol.name[:symbol.plain], underModifier) || len(symbol.name) == len(underModifier) {
+		return "", false
+	}
+
+	return symbol.name[len(underModifier):], true
+}
+
+// A '$NAME' term refers to the macro NAME rather than a tag.
+func parseMacroReference(symbol SymbolToken) (string, bool) {
+	if !strings.HasPrefix(symbol.name[:symbol.plain], MacroPrefix) || len(symbol.name) == len(MacroPrefix) {
+		return "", false
+	}
+
+	return symbol.name[len(MacroPrefix):], true
+}
+
+// Tags may be prefixed with 'mine:' or 'user:NAME:' to match only the taggings
+// of the current or named user and, before that, with 'explicit:' or 'implied:'
+// to match only the taggings made explicitly or by implication. The prefixes
+// are recognised only within the leading plain part of the text, so escaping
+// a character of a prefix has it read as part of the tag name instead.
+func parseTagModifiers(text string, plain int) (TagExpression, error) {
+	modifiers := text[:plain]
+
+	switch {
+	case strings.HasPrefix(modifiers, explicitModifier) && len(text) > len(explicitModifier):
+		tag, err := parseTagModifiers(text[len(explicitModifier):], plain-len(explicitModifier))
+		tag.Provenance = ExplicitProvenance
+		return tag, err
+	case strings.HasPrefix(modifiers, impliedModifier) && len(text) > len(impliedModifier):
+		tag, err := parseTagModifiers(text[len(impliedModifier):], plain-len(impliedModifier))
+		tag.Provenance = ImpliedProvenance
+		return tag, err
+	case strings.HasPrefix(modifiers, mineModifier) && len(text) > len(mineModifier):
+		tag, err := parseTagName(text[len(mineModifier):], plain-len(mineModifier))
+		tag.Owner = CurrentOwner
+		return tag, err
+	case strings.HasPrefix(modifiers, userModifier):
+		owner := modifiers[len(userModifier):]
+		if index := strings.Index(owner, ":"); index > 0 && len(text) > len(userModifier)+index+1 {
+			tag, err := parseTagName(text[len(userModifier)+index+1:], plain-len(userModifier)-index-1)
+			tag.Owner = owner[:index]
+			return tag, err
+		}
+	}
+
+	return parseTagName(text, plain)
+}
+
+// Macro references are recognised before tags, so one found here follows
+// modifiers, which a macro cannot have.
+func parseTagName(text string, plain int) (TagExpression, error) {
+	if strings.HasPrefix(text[:plain], MacroPrefix) && len(text) > len(MacroPrefix) {
+		return TagExpression{}, fmt.Errorf("macro '%v' cannot have modifiers", text)
+	}
+
+	return TagExpression{Name: text}, nil
+}
@@ -379,6 +379,179 @@ func TestAndParenOrParsing(test *testing.T) {
 	validateTag(or.RightOperand, "sweetcorn", test)
 }
 
+func TestMineModifierParsing(test *testing.T) {
+	scanner := NewScanner("mine:year>2000")
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	comparison := validateComparison(expression, ">", test)
+	tag := validateTag(comparison.Tag, "year", test)
+	if tag.Owner != CurrentOwner {
+		test.Fatalf("Expected the current owner but was '%v'.", tag.Owner)
+	}
+}
+
+func TestUserModifierParsing(test *testing.T) {
+	scanner := NewScanner("user:alice:cheese user:bob")
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	and := validateAnd(expression)
+	tag := validateTag(and.LeftOperand, "cheese", test)
+	if tag.Owner != "alice" {
+		test.Fatalf("Expected owner 'alice' but was '%v'.", tag.Owner)
+	}
+	tag = validateTag(and.RightOperand, "user:bob", test)
+	if tag.Owner != "" {
+		test.Fatalf("Expected no owner but was '%v'.", tag.Owner)
+	}
+}
+
+func TestEscapedOwnerModifierParsing(test *testing.T) {
+	scanner := NewScanner(`mine\:cheese user\:alice:tomato user:alice\:bob:tomato`)
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	and := validateAnd(expression)
+	left := validateAnd(and.LeftOperand)
+	tag := validateTag(left.LeftOperand, "mine:cheese", test)
+	if tag.Owner != "" {
+		test.Fatalf("Expected no owner but was '%v'.", tag.Owner)
+	}
+	tag = validateTag(left.RightOperand, "user:alice:tomato", test)
+	if tag.Owner != "" {
+		test.Fatalf("Expected no owner but was '%v'.", tag.Owner)
+	}
+	tag = validateTag(and.RightOperand, "user:alice:bob:tomato", test)
+	if tag.Owner != "" {
+		test.Fatalf("Expected no owner but was '%v'.", tag.Owner)
+	}
+}
+
+func TestProvenanceModifierParsing(test *testing.T) {
+	scanner := NewScanner("explicit:cheese implied:mine:tomato")
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	and := validateAnd(expression)
+	tag := validateTag(and.LeftOperand, "cheese", test)
+	if tag.Provenance != ExplicitProvenance {
+		test.Fatalf("Expected explicit provenance but was %v.", tag.Provenance)
+	}
+	tag = validateTag(and.RightOperand, "tomato", test)
+	if tag.Provenance != ImpliedProvenance {
+		test.Fatalf("Expected implied provenance but was %v.", tag.Provenance)
+	}
+	if tag.Owner != CurrentOwner {
+		test.Fatalf("Expected the current owner but was '%v'.", tag.Owner)
+	}
+}
+
+func TestEscapedProvenanceModifierParsing(test *testing.T) {
+	scanner := NewScanner(`explicit\:cheese explicit:implied\:tomato`)
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	and := validateAnd(expression)
+	tag := validateTag(and.LeftOperand, "explicit:cheese", test)
+	if tag.Provenance != AnyProvenance {
+		test.Fatalf("Expected any provenance but was %v.", tag.Provenance)
+	}
+	tag = validateTag(and.RightOperand, "implied:tomato", test)
+	if tag.Provenance != ExplicitProvenance {
+		test.Fatalf("Expected explicit provenance but was %v.", tag.Provenance)
+	}
+}
+
+func TestUnderParsing(test *testing.T) {
+	scanner := NewScanner("beach and under:/mnt/photos/2015")
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	and := validateAnd(expression)
+	validateTag(and.LeftOperand, "beach", test)
+	path := and.RightOperand.(PathExpression)
+	if path.Path != "/mnt/photos/2015" {
+		test.Fatalf("Expected path '/mnt/photos/2015' but was '%v'.", path.Path)
+	}
+}
+
+func TestEscapedPathAndMacroParsing(test *testing.T) {
+	scanner := NewScanner(`under\:cheese under:/mnt/my\ photos \$recent $recent`)
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	and := validateAnd(expression)
+	left := validateAnd(and.LeftOperand)
+	leftLeft := validateAnd(left.LeftOperand)
+	validateTag(leftLeft.LeftOperand, "under:cheese", test)
+	if path := leftLeft.RightOperand.(PathExpression); path.Path != "/mnt/my photos" {
+		test.Fatalf("Expected path '/mnt/my photos' but was '%v'.", path.Path)
+	}
+	validateTag(left.RightOperand, "$recent", test)
+	if macro := and.RightOperand.(MacroExpression); macro.Name != "recent" {
+		test.Fatalf("Expected macro 'recent' but was '%v'.", macro.Name)
+	}
+}
+
+func TestMacroModifierParsing(test *testing.T) {
+	for _, text := range []string{"mine:$recent", "explicit:$recent", "user:alice:$recent", "$recent = 1"} {
+		if _, err := Parse(text); err == nil {
+			test.Fatalf("Expected '%v' to be rejected.", text)
+		}
+	}
+
+	tag, err := Parse(`mine:\$recent`)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if tag != (TagExpression{Name: "$recent", Owner: CurrentOwner}) {
+		test.Fatalf("Expected tag '$recent' of the current owner but was %+v.", tag)
+	}
+}
+
 // unexported
 
 func validateNot(expression Expression) NotExpression {
@@ -429,6 +602,8 @@ func dumpBranch(expression Expression) {
 	switch exp := expression.(type) {
 	case TagExpression:
 		fmt.Printf(exp.Name)
+	case PathExpression:
+		fmt.Printf("Under(%v)", exp.Path)
 	case NotExpression:
 		fmt.Printf("Not(")
 		dumpBranch(exp.Operand)
@@ -17,6 +17,15 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
 package query
 
+import (
+	"strings"
+	"unicode"
+)
+
+// The prefixes that, at the start of a term, are read as something other than
+// the tag name that follows.
+var termPrefixes = []string{explicitModifier, impliedModifier, mineModifier, userModifier, underModifier, MacroPrefix}
+
 func Parse(query string) (Expression, error) {
 	scanner := NewScanner(query)
 	parser := NewParser(scanner)
@@ -30,10 +39,10 @@ func HasAll(tagNames []string) Expression {
 		return EmptyExpression{}
 	}
 
-	var expression Expression = TagExpression{tagNames[0]}
+	var expression Expression = TagExpression{Name: tagNames[0]}
 
 	for _, tagName := range tagNames[1:] {
-		expression = AndExpression{expression, TagExpression{tagName}}
+		expression = AndExpression{expression, TagExpression{Name: tagName}}
 	}
 
 	return expression
@@ -55,11 +64,113 @@ func ValueNames(expression Expression) []string {
 	return names
 }
 
+// Formats an expression as query text. Expressions with no textual
+// equivalent, such as the expression that matches every file, are shown in
+// angle brackets.
+func Format(expression Expression) string {
+	switch exp := expression.(type) {
+	case EmptyExpression:
+		return "<all>"
+	case TagExpression:
+		return formatTag(exp)
+	case ComparisonExpression:
+		return formatTag(exp.Tag) + " " + exp.Operator + " " + escapeText(exp.Value.Name)
+	case PathExpression:
+		return underModifier + escapeText(exp.Path)
+	case MacroExpression:
+		return MacroPrefix + escapeText(exp.Name)
+	case NotExpression:
+		return "not " + formatOperand(exp.Operand, false)
+	case AndExpression:
+		// the empty expression that begins a conjunction is redundant
+		if _, ok := exp.LeftOperand.(EmptyExpression); ok {
+			return Format(exp.RightOperand)
+		}
+
+		return formatOperand(exp.LeftOperand, true) + " and " + formatOperand(exp.RightOperand, true)
+	case OrExpression:
+		return Format(exp.LeftOperand) + " or " + Format(exp.RightOperand)
+	default:
+		panic("unsupported token type")
+	}
+}
+
 // unexported
 
+func formatTag(tag TagExpression) string {
+	text := escapeTagName(tag.Name)
+
+	switch tag.Owner {
+	case "":
+	case CurrentOwner:
+		text = mineModifier + text
+	default:
+		text = userModifier + escapeText(tag.Owner) + ":" + text
+	}
+
+	switch tag.Provenance {
+	case ExplicitProvenance:
+		text = explicitModifier + text
+	case ImpliedProvenance:
+		text = impliedModifier + text
+	}
+
+	return text
+}
+
+// Formats an operand, parenthesised where it binds less tightly than the
+// operator it is an operand of.
+func formatOperand(expression Expression, ofAnd bool) string {
+	switch exp := expression.(type) {
+	case OrExpression:
+		return "(" + Format(exp) + ")"
+	case AndExpression:
+		if _, ok := exp.LeftOperand.(EmptyExpression); ok {
+			return formatOperand(exp.RightOperand, ofAnd)
+		}
+		if !ofAnd {
+			return "(" + Format(exp) + ")"
+		}
+	}
+
+	return Format(expression)
+}
+
+// Escapes the characters of the text that would otherwise end it, and the
+// text itself where it would otherwise be read as an operator.
+func escapeText(text string) string {
+	var builder strings.Builder
+	for _, r := range text {
+		if r == '\\' || endsText(r) || !unicode.IsOneOf(symbolChars, r) {
+			builder.WriteRune('\\')
+		}
+		builder.WriteRune(r)
+	}
+
+	escaped := builder.String()
+	if operatorToken(escaped) != nil {
+		return "\\" + escaped
+	}
+
+	return escaped
+}
+
+// Escapes the tag name as text and, where it begins with a term prefix, its
+// first character so that it is not read as the prefix.
+func escapeTagName(name string) string {
+	escaped := escapeText(name)
+	for _, prefix := range termPrefixes {
+		if strings.HasPrefix(escaped, prefix) {
+			return "\\" + escaped
+		}
+	}
+
+	return escaped
+}
+
 func tagNames(expression Expression, names []string) []string {
 	switch exp := expression.(type) {
-	case EmptyExpression:
+	case EmptyExpression, PathExpression, MacroExpression:
 		// nowt
 	case TagExpression:
 		names = append(names, exp.Name)
@@ -82,7 +193,7 @@ func tagNames(expression Expression, names []string) []string {
 
 func valueNames(expression Expression, names []string) []string {
 	switch exp := expression.(type) {
-	case EmptyExpression:
+	case EmptyExpression, PathExpression, MacroExpression:
 		// nowt
 	case TagExpression:
 		// nowt
@@ -0,0 +1,84 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package query
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFormat(test *testing.T) {
+	queries := map[string]string{
+		"music":                          "music",
+		"music mp3":                      "music and mp3",
+		"music and not (mp3 or flac)":    "music and not (mp3 or flac)",
+		"(a or b) and (c or d)":          "(a or b) and (c or d)",
+		"not (a and b)":                  "not (a and b)",
+		"year >= 2015 or explicit:music": "year >= 2015 or explicit:music",
+		"implied:mine:music":             "implied:mine:music",
+		"user:alice:music":               "user:alice:music",
+		"music under:/mnt/music":         "music and under:/mnt/music",
+	}
+
+	for text, expected := range queries {
+		expression, err := Parse(text)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		if actual := Format(expression); actual != expected {
+			test.Fatalf("Query '%v' formatted as '%v' but expected '%v'.", text, actual, expected)
+		}
+	}
+
+	if actual := Format(AndExpression{EmptyExpression{}, TagExpression{Name: "music"}}); actual != "music" {
+		test.Fatalf("Expected the empty expression to be omitted but got '%v'.", actual)
+	}
+}
+
+func TestFormatEscapes(test *testing.T) {
+	expressions := []Expression{
+		TagExpression{Name: "mine:cheese"},
+		TagExpression{Name: "user:alice:cheese"},
+		TagExpression{Name: "mine:cheese", Owner: "alice"},
+		TagExpression{Name: "user:bob", Owner: CurrentOwner},
+		TagExpression{Name: "explicit:cheese"},
+		TagExpression{Name: "implied:cheese", Provenance: ExplicitProvenance},
+		TagExpression{Name: "explicit:cheese", Owner: CurrentOwner, Provenance: ImpliedProvenance},
+		TagExpression{Name: "under:cheese"},
+		TagExpression{Name: "$recent"},
+		TagExpression{Name: `back\slash`},
+		TagExpression{Name: "and"},
+		ComparisonExpression{TagExpression{Name: "under:size"}, "=", ValueExpression{"big (ish) or"}},
+		ComparisonExpression{TagExpression{Name: "colour"}, "!=", ValueExpression{"not"}},
+		PathExpression{"/mnt/my photos/(2015)"},
+		MacroExpression{"recent"},
+	}
+
+	for _, expression := range expressions {
+		text := Format(expression)
+
+		parsed, err := Parse(text)
+		if err != nil {
+			test.Fatalf("Could not parse '%v': %v", text, err)
+		}
+		if !reflect.DeepEqual(parsed, expression) {
+			test.Fatalf("Expected '%v' to parse as %+v but was %+v.", text, expression, parsed)
+		}
+	}
+}
@@ -65,6 +65,10 @@ type CloseParenToken struct {
 
 type SymbolToken struct {
 	name string
+
+	// The length of the leading part of the name in which no character was
+	// escaped, within which any modifiers are recognised.
+	plain int
 }
 
 type NotOperatorToken struct {
@@ -138,8 +142,9 @@ func (scanner *Scanner) readToken() (Token, error) {
 		return CloseParenToken{}, nil
 	case r == rune('!'), r == rune('='), r == rune('<'), r == rune('>'):
 		return scanner.readComparisonOperatorToken(r)
-	case unicode.IsOneOf(symbolChars, r):
-		return scanner.readTextToken(r)
+	case r == rune('\\'), unicode.IsOneOf(symbolChars, r):
+		scanner.stream.UnreadRune()
+		return scanner.readTextToken()
 	default:
 		return nil, fmt.Errorf("Unepxected character '%v'.", r)
 	}
@@ -147,34 +152,46 @@ func (scanner *Scanner) readToken() (Token, error) {
 	panic("unreachable")
 }
 
-func (scanner *Scanner) readTextToken(r rune) (Token, error) {
-	text, err := scanner.readString(r)
+func (scanner *Scanner) readTextToken() (Token, error) {
+	text, plain, err := scanner.readString()
 	if err != nil {
 		return nil, err
 	}
 
+	// escaped text is never an operator
+	if plain == len(text) {
+		if token := operatorToken(text); token != nil {
+			return token, nil
+		}
+	}
+
+	return SymbolToken{text, plain}, nil
+}
+
+// The operator token for the text, if it is an operator, otherwise nil.
+func operatorToken(text string) Token {
 	switch text {
 	case "not", "NOT":
-		return NotOperatorToken{}, nil
+		return NotOperatorToken{}
 	case "and", "AND":
-		return AndOperatorToken{}, nil
+		return AndOperatorToken{}
 	case "or", "OR":
-		return OrOperatorToken{}, nil
+		return OrOperatorToken{}
 	case "eq", "EQ":
-		return ComparisonOperatorToken{"="}, nil
+		return ComparisonOperatorToken{"="}
 	case "ne", "NE":
-		return ComparisonOperatorToken{"!="}, nil
+		return ComparisonOperatorToken{"!="}
 	case "lt", "LT":
-		return ComparisonOperatorToken{"<"}, nil
+		return ComparisonOperatorToken{"<"}
 	case "gt", "GT":
-		return ComparisonOperatorToken{">"}, nil
+		return ComparisonOperatorToken{">"}
 	case "le", "LE":
-		return ComparisonOperatorToken{"<="}, nil
+		return ComparisonOperatorToken{"<="}
 	case "ge", "GE":
-		return ComparisonOperatorToken{">="}, nil
+		return ComparisonOperatorToken{">="}
 	}
 
-	return SymbolToken{text}, nil
+	return nil
 }
 
 func (scanner *Scanner) readComparisonOperatorToken(r rune) (Token, error) {
@@ -197,30 +214,56 @@ func (scanner *Scanner) readComparisonOperatorToken(r rune) (Token, error) {
 	}
 }
 
-func (scanner *Scanner) readString(initialRune rune) (string, error) {
-	text := string(initialRune)
+// Reads text up to the next space, parenthesis or operator character. A
+// backslash escapes the character that follows it, which is then read as text.
+// The length of the text before the first escaped character is also returned.
+func (scanner *Scanner) readString() (string, int, error) {
+	text := ""
+	plain := -1
 
 	stop := false
 	for !stop {
 		r, _, err := scanner.stream.ReadRune()
 
 		if err == io.EOF {
-			return text, nil
+			break
 		}
 		if err != nil {
-			return "", err
+			return "", 0, err
 		}
 
 		switch {
-		case unicode.IsSpace(r), r == rune(')'), r == rune('('), r == rune('='), r == rune('!'), r == rune('<'), r == rune('>'):
+		case r == rune('\\'):
+			escaped, _, err := scanner.stream.ReadRune()
+			if err == io.EOF {
+				return "", 0, fmt.Errorf("Unexpected end of query after '\\'.")
+			}
+			if err != nil {
+				return "", 0, err
+			}
+
+			if plain == -1 {
+				plain = len(text)
+			}
+			text += string(escaped)
+		case endsText(r):
 			scanner.stream.UnreadRune()
-			return text, nil
+			stop = true
 		case unicode.IsOneOf(symbolChars, r):
 			text += string(r)
 		default:
-			return "", fmt.Errorf("Unexpected character '%v'.", r)
+			return "", 0, fmt.Errorf("Unexpected character '%v'.", r)
 		}
 	}
 
-	panic("unreachable")
+	if plain == -1 {
+		plain = len(text)
+	}
+
+	return text, plain, nil
+}
+
+// Whether the character, unless escaped, ends the text it follows.
+func endsText(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune("()=!<>", r)
 }
@@ -204,6 +204,41 @@ func TestComplexQuery(test *testing.T) {
 	validateEnd(token, test)
 }
 
+func TestEscapedText(test *testing.T) {
+	scanner := NewScanner(`mine\:big\ cheese\=1 \and \\`)
+
+	token, err := scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateSymbolToken(token, "mine:big cheese=1", test)
+	if plain := token.(SymbolToken).plain; plain != 4 {
+		test.Fatalf("Expected the text before the first escape to be 4 long but was %v.", plain)
+	}
+
+	token, err = scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateSymbolToken(token, "and", test)
+
+	token, err = scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateSymbolToken(token, `\`, test)
+
+	token, err = scanner.Next()
+	if err != nil {
+		test.Fatal(err)
+	}
+	validateEnd(token, test)
+
+	if _, err := NewScanner(`cheese\`).Next(); err == nil {
+		test.Fatal("Expected a trailing escape character to be rejected.")
+	}
+}
+
 // unexported
 
 func validateSymbolToken(token Token, expectedName string, test *testing.T) {
@@ -0,0 +1,125 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"sync"
+	"tmsu/entities"
+)
+
+// Remembers the tags and values looked up by name so that bulk operations,
+// which repeat the same lookups for every file, need not query the database
+// each time. Entries are only kept whilst a transaction is open, as only then
+// is the database protected from changes made by other processes, and are
+// discarded whenever tags or values are changed. Lookups return copies so that
+// callers cannot alter the cached entries.
+type nameCache struct {
+	sync.Mutex
+	tags   map[string]entities.Tag
+	values map[string]entities.Value
+}
+
+func newNameCache() *nameCache {
+	return &nameCache{tags: make(map[string]entities.Tag), values: make(map[string]entities.Value)}
+}
+
+func (cache *nameCache) tag(name string) (*entities.Tag, bool) {
+	if cache == nil {
+		return nil, false
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	tag, ok := cache.tags[name]
+	if !ok {
+		return nil, false
+	}
+
+	return &tag, true
+}
+
+func (cache *nameCache) addTag(tag *entities.Tag) {
+	if cache == nil || tag == nil {
+		return
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	cache.tags[tag.Name] = *tag
+}
+
+func (cache *nameCache) value(name string) (*entities.Value, bool) {
+	if cache == nil {
+		return nil, false
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	value, ok := cache.values[name]
+	if !ok {
+		return nil, false
+	}
+
+	return &value, true
+}
+
+func (cache *nameCache) addValue(value *entities.Value) {
+	if cache == nil || value == nil {
+		return
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	cache.values[value.Name] = *value
+}
+
+// Discards the cached values with the specified identifiers.
+func (cache *nameCache) removeValues(valueIds entities.ValueIds) {
+	if cache == nil || len(valueIds) == 0 {
+		return
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	for name, value := range cache.values {
+		for _, valueId := range valueIds {
+			if value.Id == valueId {
+				delete(cache.values, name)
+				break
+			}
+		}
+	}
+}
+
+// Discards everything cached.
+func (cache *nameCache) clear() {
+	if cache == nil {
+		return
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	cache.tags = make(map[string]entities.Tag)
+	cache.values = make(map[string]entities.Value)
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"path/filepath"
+)
+
+// Retrieves the path of the last file examined by an interrupted repair of the
+// specified path or an empty string if there is none.
+func (storage *Storage) RepairCheckpoint(path string) (string, error) {
+	lastPath, err := storage.Db.RepairCheckpoint(storage.relPath(path))
+	if err != nil || lastPath == "" || filepath.IsAbs(lastPath) {
+		return lastPath, err
+	}
+
+	return filepath.Join(storage.RootPath, lastPath), nil
+}
+
+// Records the path of the last file examined by the repair of the specified
+// path.
+func (storage *Storage) UpdateRepairCheckpoint(path, lastPath string) error {
+	return storage.Db.UpdateRepairCheckpoint(storage.relPath(path), storage.relPath(lastPath))
+}
+
+// Removes the checkpoint for the repair of the specified path.
+func (storage *Storage) DeleteRepairCheckpoint(path string) error {
+	return storage.Db.DeleteRepairCheckpoint(storage.relPath(path))
+}
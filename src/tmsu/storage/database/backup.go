@@ -0,0 +1,89 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"fmt"
+	"github.com/mattn/go-sqlite3"
+	"os"
+	"tmsu/common/log"
+)
+
+// Writes a snapshot of the database to the specified path using the SQLite
+// online backup API, so the snapshot is consistent even if the database is in
+// use.
+func (db *Database) Backup(destPath string) error {
+	log.Infof(2, "backing up database to '%v'", destPath)
+
+	return copyDatabase(db.Path, destPath)
+}
+
+// Replaces the contents of the database with the snapshot at the specified
+// path. There must not be an open transaction.
+func (db *Database) Restore(sourcePath string) error {
+	db.state.RLock()
+	inTransaction := db.state.transaction != nil
+	db.state.RUnlock()
+
+	if inTransaction {
+		return fmt.Errorf("could not restore database: there is an open transaction")
+	}
+
+	if _, err := os.Stat(sourcePath); err != nil {
+		return err
+	}
+
+	log.Infof(2, "restoring database from '%v'", sourcePath)
+
+	defer db.changed()
+
+	return copyDatabase(sourcePath, db.Path)
+}
+
+// unexported
+
+func copyDatabase(sourcePath, destPath string) error {
+	driver := &sqlite3.SQLiteDriver{}
+
+	source, err := driver.Open(sourcePath)
+	if err != nil {
+		return DatabaseAccessError{sourcePath, err}
+	}
+	defer source.Close()
+
+	dest, err := driver.Open(destPath)
+	if err != nil {
+		return DatabaseAccessError{destPath, err}
+	}
+	defer dest.Close()
+
+	backup, err := dest.(*sqlite3.SQLiteConn).Backup("main", source.(*sqlite3.SQLiteConn), "main")
+	if err != nil {
+		return err
+	}
+
+	for done := false; !done; {
+		done, err = backup.Step(-1)
+		if err != nil {
+			backup.Close()
+			return err
+		}
+	}
+
+	return backup.Finish()
+}
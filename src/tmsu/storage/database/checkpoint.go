@@ -0,0 +1,66 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"time"
+)
+
+// Retrieves the path of the last file examined by an interrupted repair of the
+// specified path or an empty string if there is none.
+func (db *Database) RepairCheckpoint(path string) (string, error) {
+	sql := `SELECT last_path
+            FROM repair_checkpoint
+            WHERE path = ?`
+
+	rows, err := db.ExecQuery(sql, path)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return "", rows.Err()
+	}
+
+	var lastPath string
+	if err := rows.Scan(&lastPath); err != nil {
+		return "", err
+	}
+
+	return lastPath, nil
+}
+
+// Records the path of the last file examined by the repair of the specified
+// path.
+func (db *Database) UpdateRepairCheckpoint(path, lastPath string) error {
+	sql := `INSERT OR REPLACE INTO repair_checkpoint (path, last_path, updated)
+            VALUES (?, ?, ?)`
+
+	_, err := db.Exec(sql, path, lastPath, time.Now())
+	return err
+}
+
+// Removes the checkpoint for the repair of the specified path.
+func (db *Database) DeleteRepairCheckpoint(path string) error {
+	sql := `DELETE FROM repair_checkpoint
+            WHERE path = ?`
+
+	_, err := db.Exec(sql, path)
+	return err
+}
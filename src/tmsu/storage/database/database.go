@@ -18,22 +18,51 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package database
 
 import (
+	"context"
 	"database/sql"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	_ "github.com/mattn/go-sqlite3"
 	"tmsu/common/log"
+	"io"
 	"os"
+	"sync"
+	"sync/atomic"
 )
 
+// A database handle. Its statements may be run by multiple goroutines, which
+// share the connection and the current transaction. As the transaction is
+// shared, changes that must not interleave with those of other goroutines
+// should be serialized by the caller.
 type Database struct {
 	Path string
 
 	// unexported
-	connection  *sql.DB
+	connection *sql.DB
+	ctx        context.Context
+	state      *transactionState
+}
+
+type transactionState struct {
+	sync.RWMutex
 	transaction *sql.Tx
+
+	// The number of changes made through the handles.
+	writes uint64
 }
 
+// A point in the history of the database. Changes made through the handle, or
+// committed by other connections to the database, result in a new revision.
+type Revision struct {
+	commits uint32
+	writes  uint64
+}
+
+// The number of rows inserted by each statement of the batch operations, which
+// keeps the statements within SQLite's limit on the number of parameters.
+const insertBatchSize = 100
+
 // Opens the database at the specified path
 func OpenAt(path string) (*Database, error) {
 	log.Infof(2, "opening database at '%v'.", path)
@@ -52,21 +81,25 @@ func OpenAt(path string) (*Database, error) {
 		return nil, DatabaseAccessError{path, err}
 	}
 
-	database := &Database{path, connection, nil}
+	database := &Database{path, connection, context.Background(), &transactionState{}}
 
-	if err := database.Begin(); err != nil {
+	if err := database.Upgrade(); err != nil {
 		return nil, err
 	}
 
-	if err := database.CreateSchema(); err != nil {
-		return nil, err
-	}
+	return database, nil
+}
 
-	if err := database.Commit(); err != nil {
-		return nil, err
-	}
+// Creates a handle to the same database whose statements are bound to the
+// specified context, so that they are abandoned if the context is cancelled or
+// its deadline passes.
+func (db *Database) WithContext(ctx context.Context) *Database {
+	return &Database{db.Path, db.connection, ctx, db.state}
+}
 
-	return database, nil
+// The context to which the statements are bound.
+func (db *Database) Context() context.Context {
+	return db.ctx
 }
 
 // Executes a SQL query.
@@ -82,11 +115,15 @@ func (db *Database) Exec(query string, args ...interface{}) (sql.Result, error)
 	var result sql.Result
 	var err error
 
-	if db.transaction != nil {
-		result, err = db.transaction.Exec(query, args...)
+	db.state.RLock()
+	if db.state.transaction != nil {
+		result, err = db.state.transaction.ExecContext(db.ctx, query, args...)
 	} else {
-		result, err = db.connection.Exec(query, args...)
+		result, err = db.connection.ExecContext(db.ctx, query, args...)
 	}
+	db.state.RUnlock()
+
+	db.changed()
 
 	if err != nil {
 		return nil, DatabaseQueryError{db.Path, query, err}
@@ -108,11 +145,13 @@ func (db *Database) ExecQuery(query string, args ...interface{}) (*sql.Rows, err
 	var rows *sql.Rows
 	var err error
 
-	if db.transaction != nil {
-		rows, err = db.transaction.Query(query, args...)
+	db.state.RLock()
+	if db.state.transaction != nil {
+		rows, err = db.state.transaction.QueryContext(db.ctx, query, args...)
 	} else {
-		rows, err = db.connection.Query(query, args...)
+		rows, err = db.connection.QueryContext(db.ctx, query, args...)
 	}
+	db.state.RUnlock()
 
 	if err != nil {
 		return nil, DatabaseQueryError{db.Path, query, err}
@@ -123,56 +162,185 @@ func (db *Database) ExecQuery(query string, args ...interface{}) (*sql.Rows, err
 
 // Start a transaction
 func (db *Database) Begin() error {
-	if db.transaction != nil {
+	db.state.Lock()
+	defer db.state.Unlock()
+
+	if db.state.transaction != nil {
 		panic("could not begin transaction: there is already an open transaction")
 	}
 
-	log.Info(2, "beginning new transaction")
+	return db.begin()
+}
 
-	transaction, err := db.connection.Begin()
-	if err != nil {
-		return DatabaseTransactionError{db.Path, err}
+// Starts a transaction unless there is already one open, reporting whether it
+// did. The check is made under the same lock as the start, so goroutines
+// sharing the handle cannot both start one.
+func (db *Database) BeginUnlessOpen() (bool, error) {
+	db.state.Lock()
+	defer db.state.Unlock()
+
+	if db.state.transaction != nil {
+		return false, nil
 	}
 
-	db.transaction = transaction
+	if err := db.begin(); err != nil {
+		return false, err
+	}
 
-	return nil
+	return true, nil
 }
 
 // Commits the current transaction
 func (db *Database) Commit() error {
-	if db.transaction == nil {
+	db.state.Lock()
+	defer db.state.Unlock()
+
+	if db.state.transaction == nil {
 		return fmt.Errorf("could not commit transaction: there is no open transaciton")
 	}
 
 	log.Info(2, "committing transaction")
 
-	if err := db.transaction.Commit(); err != nil {
+	if err := db.state.transaction.Commit(); err != nil {
 		return DatabaseTransactionError{db.Path, err}
 	}
 
-	db.transaction = nil
+	db.state.transaction = nil
 
 	return nil
 }
 
 // Rolls back the current transaction
 func (db *Database) Rollback() error {
-	if db.transaction == nil {
+	db.state.Lock()
+	defer db.state.Unlock()
+
+	if db.state.transaction == nil {
 		return fmt.Errorf("could not rollback transaction: there is no open transaciton")
 	}
 
 	log.Info(2, "rolling back transaction")
 
-	if err := db.transaction.Rollback(); err != nil {
+	if err := db.state.transaction.Rollback(); err != nil {
 		return DatabaseTransactionError{db.Path, err}
 	}
 
-	db.transaction = nil
+	db.state.transaction = nil
+	db.changed()
 
 	return nil
 }
 
+// Whether there is an open transaction.
+func (db *Database) InTransaction() bool {
+	db.state.RLock()
+	defer db.state.RUnlock()
+
+	return db.state.transaction != nil
+}
+
+// Retrieves the current revision of the database. Commits made by other
+// connections are detected using the change counter in the database file's
+// header, which SQLite does not maintain in write-ahead log mode.
+func (db *Database) Revision() (Revision, error) {
+	file, err := os.Open(db.Path)
+	if err != nil {
+		return Revision{}, DatabaseAccessError{db.Path, err}
+	}
+	defer file.Close()
+
+	// a database that has yet to be written has no header
+	header := make([]byte, 4)
+	if _, err := file.ReadAt(header, 24); err != nil && err != io.EOF {
+		return Revision{}, DatabaseAccessError{db.Path, err}
+	}
+
+	return Revision{binary.BigEndian.Uint32(header), atomic.LoadUint64(&db.state.writes)}, nil
+}
+
+// Marks a point within the current transaction to which the changes may
+// subsequently be rolled back.
+func (db *Database) Savepoint(name string) error {
+	log.Infof(3, "creating savepoint '%v'", name)
+
+	if _, err := db.Exec("SAVEPOINT " + name); err != nil {
+		return DatabaseTransactionError{db.Path, err}
+	}
+
+	return nil
+}
+
+// Keeps the changes made since the named savepoint.
+func (db *Database) ReleaseSavepoint(name string) error {
+	log.Infof(3, "releasing savepoint '%v'", name)
+
+	if _, err := db.Exec("RELEASE SAVEPOINT " + name); err != nil {
+		return DatabaseTransactionError{db.Path, err}
+	}
+
+	return nil
+}
+
+// Discards the changes made since the named savepoint.
+func (db *Database) RollbackToSavepoint(name string) error {
+	log.Infof(3, "rolling back to savepoint '%v'", name)
+
+	if _, err := db.Exec("ROLLBACK TO SAVEPOINT " + name); err != nil {
+		return DatabaseTransactionError{db.Path, err}
+	}
+
+	return db.ReleaseSavepoint(name)
+}
+
+// Commits the current transaction, if there is one, and begins another so that
+// the changes made so far survive an interruption.
+func (db *Database) Checkpoint() error {
+	db.state.Lock()
+	defer db.state.Unlock()
+
+	if db.state.transaction == nil {
+		return nil
+	}
+
+	log.Info(2, "committing transaction")
+
+	if err := db.state.transaction.Commit(); err != nil {
+		return DatabaseTransactionError{db.Path, err}
+	}
+
+	db.state.transaction = nil
+
+	return db.begin()
+}
+
+// The use of the pages of the database file. Free pages are those left by
+// deleted rows, which are reclaimed only by vacuuming.
+type PageUsage struct {
+	PageSize  uint
+	Pages     uint
+	FreePages uint
+}
+
+// Retrieves the use of the pages of the database file.
+func (db *Database) PageUsage() (*PageUsage, error) {
+	pageSize, err := db.pragmaCount("page_size")
+	if err != nil {
+		return nil, err
+	}
+
+	pages, err := db.pragmaCount("page_count")
+	if err != nil {
+		return nil, err
+	}
+
+	freePages, err := db.pragmaCount("freelist_count")
+	if err != nil {
+		return nil, err
+	}
+
+	return &PageUsage{pageSize, pages, freePages}, nil
+}
+
 // Closes the database connection
 func (db *Database) Close() error {
 	log.Info(3, "closing database")
@@ -186,8 +354,43 @@ func (db *Database) Close() error {
 
 // unexported
 
+// Records that the database has been changed through the handle.
+func (db *Database) changed() {
+	atomic.AddUint64(&db.state.writes, 1)
+}
+
+// Starts a transaction. The caller must hold the state lock.
+func (db *Database) begin() error {
+	log.Info(2, "beginning new transaction")
+
+	// the transaction is not bound to the context, which would roll it back in
+	// the background once cancelled: it is instead rolled back by the caller
+	transaction, err := db.connection.BeginTx(context.Background(), nil)
+	if err != nil {
+		return DatabaseTransactionError{db.Path, err}
+	}
+
+	db.state.transaction = transaction
+
+	return nil
+}
+
+func (db *Database) pragmaCount(name string) (uint, error) {
+	rows, err := db.ExecQuery("PRAGMA " + name)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	return readCount(rows)
+}
+
 func readCount(rows *sql.Rows) (uint, error) {
 	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+
 		return 0, errors.New("Could not get count.")
 	}
 	if rows.Err() != nil {
@@ -0,0 +1,113 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"database/sql"
+	"time"
+	"tmsu/common/fingerprint"
+	"tmsu/entities"
+)
+
+// Retrieves the complete set of deleted file records.
+func (db *Database) DeletedFiles() (entities.DeletedFiles, error) {
+	sql := `SELECT df.id, df.directory, df.name, df.fingerprint, df.deleted, ifnull(dft.tag_name, ''), ifnull(dft.value_name, '')
+            FROM deleted_file df
+            LEFT OUTER JOIN deleted_file_tag dft ON dft.deleted_file_id = df.id
+            ORDER BY df.directory || '/' || df.name, df.id, dft.tag_name, dft.value_name`
+
+	rows, err := db.ExecQuery(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readDeletedFiles(rows, make(entities.DeletedFiles, 0, 10))
+}
+
+// Records the specified file, along with its explicit tags, as deleted.
+func (db *Database) InsertDeletedFile(fileId entities.FileId, deleted time.Time) (entities.DeletedFileId, error) {
+	sql := `INSERT INTO deleted_file (directory, name, fingerprint, deleted)
+            SELECT directory, name, fingerprint, ?1
+            FROM ` + sqlFiles + ` file
+            WHERE id = ?2`
+
+	result, err := db.Exec(sql, deleted, fileId)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rowsAffected == 0 {
+		return 0, NoSuchFileError{fileId}
+	}
+	if rowsAffected > 1 {
+		panic("expected only one row to be affected.")
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	sql = `INSERT INTO deleted_file_tag (deleted_file_id, tag_name, value_name)
+           SELECT DISTINCT ?1, t.name, ifnull(v.name, '')
+           FROM file_tag ft
+           INNER JOIN tag t ON t.id = ft.tag_id
+           LEFT OUTER JOIN value v ON v.id = ft.value_id
+           WHERE ft.file_id = ?2`
+
+	if _, err := db.Exec(sql, id, fileId); err != nil {
+		return 0, err
+	}
+
+	return entities.DeletedFileId(id), nil
+}
+
+// unexported
+
+func readDeletedFiles(rows *sql.Rows, files entities.DeletedFiles) (entities.DeletedFiles, error) {
+	var file *entities.DeletedFile
+
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var id entities.DeletedFileId
+		var directory, name, fp, tagName, valueName string
+		var deleted time.Time
+		if err := rows.Scan(&id, &directory, &name, &fp, &deleted, &tagName, &valueName); err != nil {
+			return nil, err
+		}
+
+		if file == nil || file.Id != id {
+			file = &entities.DeletedFile{id, directory, name, fingerprint.Fingerprint(fp), deleted, make(entities.DeletedFileTags, 0, 10)}
+			files = append(files, file)
+		}
+
+		if tagName != "" {
+			file.Tags = append(file.Tags, entities.DeletedFileTag{tagName, valueName})
+		}
+	}
+
+	return files, rows.Err()
+}
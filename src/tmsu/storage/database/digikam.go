@@ -0,0 +1,82 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"database/sql"
+	"net/url"
+	"path/filepath"
+	"tmsu/common/log"
+)
+
+// A tag applied to a file in a digiKam database. A nested tag has the name of
+// its parent tag too.
+type DigikamTagging struct {
+	Path       string
+	TagName    string
+	ParentName string
+}
+
+// Reads the taggings from the digiKam database at the specified path, which is
+// opened read-only. The tags digiKam uses internally are omitted.
+func DigikamTaggings(path string) ([]DigikamTagging, error) {
+	log.Infof(2, "opening digiKam database at '%v'.", path)
+
+	dataSource := url.URL{Scheme: "file", Path: path, RawQuery: "mode=ro"}
+
+	connection, err := sql.Open("sqlite3", dataSource.String())
+	if err != nil {
+		return nil, DatabaseAccessError{path, err}
+	}
+	defer connection.Close()
+
+	// the top-level tags are the children of digiKam's root tag
+	query := `SELECT r.specificPath, a.relativePath, i.name, t.name,
+                     CASE WHEN p.name IS NULL OR p.name = '_Digikam_root_tag_' THEN '' ELSE p.name END
+              FROM ImageTags it
+              INNER JOIN Images i ON i.id = it.imageid
+              INNER JOIN Albums a ON a.id = i.album
+              INNER JOIN AlbumRoots r ON r.id = a.albumRoot
+              INNER JOIN Tags t ON t.id = it.tagid
+              LEFT OUTER JOIN Tags p ON p.id = t.pid
+              WHERE t.name != '_Digikam_Internal_Tags_' AND coalesce(p.name, '') != '_Digikam_Internal_Tags_'
+              ORDER BY r.specificPath, a.relativePath, i.name, t.name`
+
+	rows, err := connection.Query(query)
+	if err != nil {
+		return nil, DatabaseQueryError{path, query, err}
+	}
+	defer rows.Close()
+
+	taggings := make([]DigikamTagging, 0, 10)
+	for rows.Next() {
+		var rootPath, albumPath, name string
+		var tagging DigikamTagging
+		if err := rows.Scan(&rootPath, &albumPath, &name, &tagging.TagName, &tagging.ParentName); err != nil {
+			return nil, DatabaseQueryError{path, query, err}
+		}
+
+		tagging.Path = filepath.Join(rootPath, albumPath, name)
+		taggings = append(taggings, tagging)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, DatabaseQueryError{path, query, err}
+	}
+
+	return taggings, nil
+}
@@ -75,6 +75,14 @@ func (err NoSuchQueryError) Error() string {
 	return fmt.Sprintf("no such query '%v'", err.Query)
 }
 
+type NoSuchTagSetError struct {
+	Name string
+}
+
+func (err NoSuchTagSetError) Error() string {
+	return fmt.Sprintf("no such tag set '%v'", err.Name)
+}
+
 type NoSuchFileTagError struct {
 	FileId  entities.FileId
 	TagId   entities.TagId
@@ -87,9 +95,14 @@ func (err NoSuchFileTagError) Error() string {
 
 type NoSuchImplicationError struct {
 	TagId        entities.TagId
+	ValueId      entities.ValueId
 	ImpliedTagId entities.TagId
 }
 
 func (err NoSuchImplicationError) Error() string {
+	if err.ValueId != 0 {
+		return fmt.Sprintf("no such implication where tag #%v with value #%v implies tag #%v", err.TagId, err.ValueId, err.ImpliedTagId)
+	}
+
 	return fmt.Sprintf("no such implication where tag #%v implies tag #%v", err.TagId, err.ImpliedTagId)
 }
@@ -0,0 +1,121 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"database/sql"
+	"time"
+	"tmsu/entities"
+)
+
+// Retrieves the sequence number of the latest event.
+func (db *Database) LatestEventSeq() (uint, error) {
+	sql := `SELECT ifnull(max(seq), 0)
+            FROM event`
+
+	rows, err := db.ExecQuery(sql)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	return readCount(rows)
+}
+
+// Retrieves the events after the specified sequence number, oldest first.
+func (db *Database) EventsSince(seq uint) (entities.Events, error) {
+	sql := `SELECT seq, time, type, path, tag, value, previous
+            FROM event
+            WHERE seq > ?
+            ORDER BY seq`
+
+	rows, err := db.ExecQuery(sql, seq)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readEvents(rows, make(entities.Events, 0, 10))
+}
+
+// Retrieves the tagging and move events for the specified path that occurred
+// before the specified sequence number, latest first.
+func (db *Database) EventsByPath(path string, beforeSeq uint) (entities.Events, error) {
+	sql := `SELECT seq, time, type, path, tag, value, previous
+            FROM event
+            WHERE path = ? AND type IN ('tag', 'untag', 'file-move') AND seq < ?
+            ORDER BY seq DESC`
+
+	rows, err := db.ExecQuery(sql, path, beforeSeq)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readEvents(rows, make(entities.Events, 0, 10))
+}
+
+// Retrieves the tagging and rename events for the specified tag that occurred
+// before the specified sequence number, latest first.
+func (db *Database) EventsByTag(tagName string, beforeSeq uint) (entities.Events, error) {
+	sql := `SELECT seq, time, type, path, tag, value, previous
+            FROM event
+            WHERE tag = ? AND type IN ('tag', 'untag', 'tag-rename') AND seq < ?
+            ORDER BY seq DESC`
+
+	rows, err := db.ExecQuery(sql, tagName, beforeSeq)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readEvents(rows, make(entities.Events, 0, 10))
+}
+
+// Deletes the events up to and including the specified sequence number.
+func (db *Database) DeleteEventsUpTo(seq uint) error {
+	sql := `DELETE FROM event
+            WHERE seq <= ?`
+
+	if _, err := db.Exec(sql, seq); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// unexported
+
+func readEvents(rows *sql.Rows, events entities.Events) (entities.Events, error) {
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var seq uint
+		var eventTime time.Time
+		var eventType, path, tagName, valueName, previous string
+		if err := rows.Scan(&seq, &eventTime, &eventType, &path, &tagName, &valueName, &previous); err != nil {
+			return nil, err
+		}
+
+		events = append(events, &entities.Event{seq, eventTime, eventType, path, tagName, valueName, previous})
+	}
+
+	return events, rows.Err()
+}
@@ -19,11 +19,13 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 	"tmsu/common/fingerprint"
+	_path "tmsu/common/path"
 	"tmsu/entities"
 	"tmsu/query"
 )
@@ -45,7 +47,7 @@ func (db *Database) FileCount() (uint, error) {
 // The complete set of tracked files.
 func (db *Database) Files() (entities.Files, error) {
 	sql := `SELECT id, directory, name, fingerprint, mod_time, size, is_dir
-	        FROM file
+	        FROM ` + sqlFiles + ` file
 	        ORDER BY directory || '/' || name`
 
 	rows, err := db.ExecQuery(sql)
@@ -60,7 +62,7 @@ func (db *Database) Files() (entities.Files, error) {
 // Retrieves a specific file.
 func (db *Database) File(id entities.FileId) (*entities.File, error) {
 	sql := `SELECT id, directory, name, fingerprint, mod_time, size, is_dir
-	        FROM file
+	        FROM ` + sqlFiles + ` file
 	        WHERE id = ?`
 
 	rows, err := db.ExecQuery(sql, id)
@@ -74,11 +76,10 @@ func (db *Database) File(id entities.FileId) (*entities.File, error) {
 
 // Retrieves the file with the specified path.
 func (db *Database) FileByPath(path string) (*entities.File, error) {
-	directory := filepath.Dir(path)
-	name := filepath.Base(path)
+	directory, name := _path.Split(path)
 
 	sql := `SELECT id, directory, name, fingerprint, mod_time, size, is_dir
-	        FROM file
+	        FROM ` + sqlFiles + ` file
 	        WHERE directory = ? AND name = ?`
 
 	rows, err := db.ExecQuery(sql, directory, name)
@@ -91,15 +92,20 @@ func (db *Database) FileByPath(path string) (*entities.File, error) {
 }
 
 // Retrieves all files that are under the specified directory.
+//
+// The directories are matched first, using the index on their paths, and the
+// files then found through the index on their directory identifiers.
 func (db *Database) FilesByDirectory(path string) (entities.Files, error) {
-	sql := `SELECT id, directory, name, fingerprint, mod_time, size, is_dir
-            FROM file
-            WHERE directory = ? OR directory LIKE ?
-            ORDER BY directory || '/' || name`
+	sql := `SELECT f.id, d.path, f.name, f.fingerprint, f.mod_time, f.size, f.is_dir
+            FROM directory d
+            INNER JOIN file f ON f.directory_id = d.id
+            WHERE d.path = ? OR (d.path >= ? AND d.path < ?)
+            ORDER BY d.path || '/' || f.name`
 
-	path = filepath.Clean(path)
+	path = _path.Clean(path)
+	lower, upper := directoryRange(path)
 
-	rows, err := db.ExecQuery(sql, path, filepath.Join(path, "%"))
+	rows, err := db.ExecQuery(sql, path, lower, upper)
 	if err != nil {
 		return nil, err
 	}
@@ -126,7 +132,7 @@ func (db *Database) FileCountByFingerprint(fingerprint fingerprint.Fingerprint)
 // Retrieves the set of files with the specified fingerprint.
 func (db *Database) FilesByFingerprint(fingerprint fingerprint.Fingerprint) (entities.Files, error) {
 	sql := `SELECT id, directory, name, fingerprint, mod_time, size, is_dir
-	        FROM file
+	        FROM ` + sqlFiles + ` file
 	        WHERE fingerprint = ?
 	        ORDER BY directory || '/' || name`
 
@@ -142,7 +148,7 @@ func (db *Database) FilesByFingerprint(fingerprint fingerprint.Fingerprint) (ent
 // Retrieves the set of untagged files.
 func (db *Database) UntaggedFiles() (entities.Files, error) {
 	sql := `SELECT id, directory, name, fingerprint, mod_time, size, is_dir
-            FROM file
+            FROM ` + sqlFiles + ` file
             WHERE id NOT IN (SELECT distinct(file_id)
                              FROM file_tag)`
 
@@ -155,6 +161,68 @@ func (db *Database) UntaggedFiles() (entities.Files, error) {
 	return readFiles(rows, make(entities.Files, 0, 10))
 }
 
+// Ranks the other files by the similarity of their tags to those of the
+// specified file: the Jaccard index of their sets of tag and value pairs. Only
+// the file tags without an owner, or owned by the specified owner (if any),
+// are compared. Files sharing no tags are omitted. A limit of zero returns all
+// of the similar files.
+func (db *Database) SimilarFiles(fileId entities.FileId, owner string, limit uint) (entities.SimilarFiles, error) {
+	sql := `WITH pairs AS (SELECT DISTINCT file_id, tag_id, value_id
+                           FROM file_tag
+                           WHERE ?2 = '' OR owner IN ('', ?2)),
+                 target AS (SELECT tag_id, value_id
+                            FROM pairs
+                            WHERE file_id = ?1),
+                 shared AS (SELECT p.file_id, count(1) AS count
+                            FROM pairs p
+                            INNER JOIN target t ON t.tag_id = p.tag_id AND t.value_id = p.value_id
+                            WHERE p.file_id != ?1
+                            GROUP BY p.file_id),
+                 totals AS (SELECT file_id, count(1) AS count
+                            FROM pairs
+                            WHERE file_id IN (SELECT file_id FROM shared)
+                            GROUP BY file_id)
+            SELECT f.id, f.directory, f.name, f.fingerprint, f.mod_time, f.size, f.is_dir, s.count,
+                   CAST(s.count AS REAL) / (t.count + (SELECT count(1) FROM target) - s.count) AS score
+            FROM shared s
+            INNER JOIN totals t ON t.file_id = s.file_id
+            INNER JOIN ` + sqlFiles + ` f ON f.id = s.file_id
+            ORDER BY score DESC, f.directory, f.name
+            LIMIT ?3`
+
+	sqlLimit := int64(limit)
+	if limit == 0 {
+		sqlLimit = -1
+	}
+
+	rows, err := db.ExecQuery(sql, fileId, owner, sqlLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	similarFiles := make(entities.SimilarFiles, 0, 10)
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var file entities.File
+		var fp string
+		var similarFile entities.SimilarFile
+		err := rows.Scan(&file.Id, &file.Directory, &file.Name, &fp, &file.ModTime, &file.Size, &file.IsDir, &similarFile.SharedTags, &similarFile.Score)
+		if err != nil {
+			return nil, err
+		}
+
+		file.Fingerprint = fingerprint.Fingerprint(fp)
+		similarFile.File = &file
+		similarFiles = append(similarFiles, &similarFile)
+	}
+
+	return similarFiles, rows.Err()
+}
+
 // Retrieves the count of files matching the specified query and matching the specified path.
 func (db *Database) QueryFileCount(expression query.Expression, path string) (uint, error) {
 	builder := buildCountQuery(expression, path)
@@ -170,20 +238,74 @@ func (db *Database) QueryFileCount(expression query.Expression, path string) (ui
 
 // Retrieves the set of files matching the specified query and matching the specified path.
 func (db *Database) QueryFiles(expression query.Expression, path string) (entities.Files, error) {
-	builder := buildQuery(expression, path)
+	return db.QueryFilteredFiles(expression, pathList(path), entities.FileFilter{})
+}
+
+// Retrieves the set of files matching the specified query, under any of the
+// specified paths, that pass the filter.
+func (db *Database) QueryFilteredFiles(expression query.Expression, paths []string, filter entities.FileFilter) (entities.Files, error) {
+	files := make(entities.Files, 0, 10)
+	err := db.EachQueryFilteredFile(expression, paths, filter, func(file *entities.File) error {
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// Calls the function with each file matching the specified query, under any of
+// the specified paths, that passes the filter. The files are read one at a time
+// rather than all at once. Iteration stops at the first error the function
+// returns, which is then returned.
+func (db *Database) EachQueryFilteredFile(expression query.Expression, paths []string, filter entities.FileFilter, each func(*entities.File) error) error {
+	builder := buildQuery(expression, paths, filter)
 	rows, err := db.ExecQuery(builder.Sql, builder.Params...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for {
+		file, err := readFile(rows)
+		if err != nil {
+			return err
+		}
+		if file == nil {
+			return nil
+		}
+
+		if err := each(file); err != nil {
+			return err
+		}
+	}
+}
+
+// Retrieves the SQL that QueryFilteredFiles runs for the query, paths and
+// filter together with SQLite's plan for running it.
+func (db *Database) ExplainQueryFiles(expression query.Expression, paths []string, filter entities.FileFilter) (*entities.QueryPlan, error) {
+	builder := buildQuery(expression, paths, filter)
+
+	rows, err := db.ExecQuery("EXPLAIN QUERY PLAN "+builder.Sql, builder.Params...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	return readFiles(rows, make(entities.Files, 0, 10))
+	steps, err := readQueryPlanSteps(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entities.QueryPlan{builder.Sql, builder.Params, steps}, nil
 }
 
 // Retrieves the sets of duplicate files within the database.
 func (db *Database) DuplicateFiles() ([]entities.Files, error) {
-	sql := `SELECT id, directory, name, fingerprint, mod_time, size, is_dir
-            FROM file
+	sql := `SELECT fingerprint, id, directory, name, fingerprint, mod_time, size, is_dir
+            FROM ` + sqlFiles + ` file
             WHERE fingerprint IN (
                 SELECT fingerprint
                 FROM file
@@ -199,55 +321,22 @@ func (db *Database) DuplicateFiles() ([]entities.Files, error) {
 	}
 	defer rows.Close()
 
-	fileSets := make([]entities.Files, 0, 10)
-	var fileSet entities.Files
-	var previousFingerprint fingerprint.Fingerprint
-
-	for rows.Next() {
-		if rows.Err() != nil {
-			return nil, err
-		}
-
-		var fileId entities.FileId
-		var directory, name, fp string
-		var modTime time.Time
-		var size int64
-		var isDir bool
-		err = rows.Scan(&fileId, &directory, &name, &fp, &modTime, &size, &isDir)
-		if err != nil {
-			return nil, err
-		}
-
-		fingerprint := fingerprint.Fingerprint(fp)
-
-		if fingerprint != previousFingerprint {
-			if fileSet != nil {
-				fileSets = append(fileSets, fileSet)
-			}
-			fileSet = make(entities.Files, 0, 10)
-			previousFingerprint = fingerprint
-		}
-
-		fileSet = append(fileSet, &entities.File{fileId, directory, name, fingerprint, modTime, size, isDir})
-	}
-
-	// ensure last file set is added
-	if len(fileSet) > 0 {
-		fileSets = append(fileSets, fileSet)
-	}
-
-	return fileSets, nil
+	return readFileSets(rows)
 }
 
 // Adds a file to the database.
 func (db *Database) InsertFile(path string, fingerprint fingerprint.Fingerprint, modTime time.Time, size int64, isDir bool) (*entities.File, error) {
-	directory := filepath.Dir(path)
-	name := filepath.Base(path)
+	directory, name := _path.Split(path)
 
-	sql := `INSERT INTO file (directory, name, fingerprint, mod_time, size, is_dir)
+	directoryId, err := db.directoryId(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	sql := `INSERT INTO file (directory_id, name, fingerprint, mod_time, size, is_dir)
 	        VALUES (?, ?, ?, ?, ?, ?)`
 
-	result, err := db.Exec(sql, directory, name, string(fingerprint), modTime, size, isDir)
+	result, err := db.Exec(sql, directoryId, name, string(fingerprint), modTime, size, isDir)
 	if err != nil {
 		return nil, err
 	}
@@ -268,16 +357,95 @@ func (db *Database) InsertFile(path string, fingerprint fingerprint.Fingerprint,
 	return &entities.File{entities.FileId(id), directory, name, fingerprint, modTime, size, isDir}, nil
 }
 
+// Adds a batch of files to the database, inserting many rows per statement.
+// Returns the files with their identifiers.
+func (db *Database) InsertFiles(files entities.Files) (entities.Files, error) {
+	inserted := make(entities.Files, 0, len(files))
+
+	for start := 0; start < len(files); start += insertBatchSize {
+		end := start + insertBatchSize
+		if end > len(files) {
+			end = len(files)
+		}
+		batch := files[start:end]
+
+		directoryIds := make(map[string]int64)
+		for _, file := range batch {
+			if _, ok := directoryIds[file.Directory]; ok {
+				continue
+			}
+
+			directoryId, err := db.directoryId(file.Directory)
+			if err != nil {
+				return nil, err
+			}
+
+			directoryIds[file.Directory] = directoryId
+		}
+
+		sql := `INSERT INTO file (directory_id, name, fingerprint, mod_time, size, is_dir)
+                VALUES (?, ?, ?, ?, ?, ?)`
+		sql += strings.Repeat(", (?, ?, ?, ?, ?, ?)", len(batch)-1)
+		sql += `
+                RETURNING id, directory_id, name`
+
+		params := make([]interface{}, 0, len(batch)*6)
+		for _, file := range batch {
+			params = append(params, directoryIds[file.Directory], file.Name, string(file.Fingerprint), file.ModTime, file.Size, file.IsDir)
+		}
+
+		rows, err := db.ExecQuery(sql, params...)
+		db.changed()
+		if err != nil {
+			return nil, err
+		}
+
+		// the order of the returned rows is unspecified so match them up by path
+		fileIds := make(map[string]entities.FileId, len(batch))
+		for rows.Next() {
+			var fileId entities.FileId
+			var directoryId int64
+			var name string
+			if err := rows.Scan(&fileId, &directoryId, &name); err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			fileIds[strconv.FormatInt(directoryId, 10)+"/"+name] = fileId
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range batch {
+			fileId, ok := fileIds[strconv.FormatInt(directoryIds[file.Directory], 10)+"/"+file.Name]
+			if !ok {
+				return nil, fmt.Errorf("file '%v' was not added", filepath.Join(file.Directory, file.Name))
+			}
+
+			inserted = append(inserted, &entities.File{fileId, file.Directory, file.Name, file.Fingerprint, file.ModTime, file.Size, file.IsDir})
+		}
+	}
+
+	return inserted, nil
+}
+
 // Updates a file in the database.
 func (db *Database) UpdateFile(fileId entities.FileId, path string, fingerprint fingerprint.Fingerprint, modTime time.Time, size int64, isDir bool) (*entities.File, error) {
-	directory := filepath.Dir(path)
-	name := filepath.Base(path)
+	directory, name := _path.Split(path)
+
+	directoryId, err := db.directoryId(directory)
+	if err != nil {
+		return nil, err
+	}
 
 	sql := `UPDATE file
-	        SET directory = ?, name = ?, fingerprint = ?, mod_time = ?, size = ?, is_dir = ?
+	        SET directory_id = ?, name = ?, fingerprint = ?, mod_time = ?, size = ?, is_dir = ?
 	        WHERE id = ?`
 
-	result, err := db.Exec(sql, directory, name, string(fingerprint), modTime, size, isDir, int(fileId))
+	result, err := db.Exec(sql, directoryId, name, string(fingerprint), modTime, size, isDir, int(fileId))
 	if err != nil {
 		return nil, err
 	}
@@ -349,9 +517,45 @@ func (db *Database) DeleteUntaggedFiles(fileIds entities.FileIds) error {
 
 // unexported
 
+// Retrieves the identifier of the directory with the specified path, adding
+// the directory if it is not yet present.
+func (db *Database) directoryId(path string) (int64, error) {
+	sql := `INSERT OR IGNORE INTO directory (path)
+            VALUES (?)`
+
+	if _, err := db.Exec(sql, path); err != nil {
+		return 0, err
+	}
+
+	sql = `SELECT id
+           FROM directory
+           WHERE path = ?`
+
+	rows, err := db.ExecQuery(sql, path)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+
+		return 0, fmt.Errorf("directory '%v' was not added", path)
+	}
+
+	var directoryId int64
+	if err := rows.Scan(&directoryId); err != nil {
+		return 0, err
+	}
+
+	return directoryId, nil
+}
+
 func readFile(rows *sql.Rows) (*entities.File, error) {
 	if !rows.Next() {
-		return nil, nil
+		return nil, rows.Err()
 	}
 	if rows.Err() != nil {
 		return nil, rows.Err()
@@ -386,47 +590,188 @@ func readFiles(rows *sql.Rows, files entities.Files) (entities.Files, error) {
 	return files, nil
 }
 
+// Reads the rows of an EXPLAIN QUERY PLAN, whose last column describes the
+// step. Where the rows identify their parent step (SQLite 3.24 onward) each
+// step is indented beneath its parent.
+func readQueryPlanSteps(rows *sql.Rows) ([]string, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	hasParent := len(columns) == 4 && columns[0] == "id" && columns[1] == "parent"
+	depths := make(map[int64]int)
+
+	steps := make([]string, 0, 10)
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for index := range values {
+			pointers[index] = &values[index]
+		}
+
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+
+		depth := 0
+		if hasParent {
+			id, _ := values[0].(int64)
+			parent, _ := values[1].(int64)
+
+			if parentDepth, ok := depths[parent]; ok {
+				depth = parentDepth + 1
+			}
+			depths[id] = depth
+		}
+
+		var detail string
+		switch typedValue := values[len(values)-1].(type) {
+		case []byte:
+			detail = string(typedValue)
+		case string:
+			detail = typedValue
+		}
+
+		steps = append(steps, strings.Repeat("  ", depth)+detail)
+	}
+
+	return steps, rows.Err()
+}
+
 func buildCountQuery(expression query.Expression, path string) *SqlBuilder {
 	builder := NewBuilder()
 	pBuilder := &builder
 
-	pBuilder.AppendSql("SELECT count(id) FROM file WHERE 1 == 1 AND\n")
+	pBuilder.AppendSql("SELECT count(id) FROM " + sqlFiles + " file WHERE 1 == 1 AND\n")
 	buildQueryBranch(expression, pBuilder)
-	buildPathClause(path, pBuilder)
+	buildPathClause(pathList(path), pBuilder)
 
 	return pBuilder
 }
 
-func buildQuery(expression query.Expression, path string) *SqlBuilder {
+func buildQuery(expression query.Expression, paths []string, filter entities.FileFilter) *SqlBuilder {
 	builder := NewBuilder()
 	pBuilder := &builder
 
-	pBuilder.AppendSql("SELECT id, directory, name, fingerprint, mod_time, size, is_dir FROM file WHERE 1==1 AND\n")
-	buildQueryBranch(expression, pBuilder)
-	buildPathClause(path, pBuilder)
+	if filter.Sample == 0 {
+		buildMatchQuery(expression, paths, filter, pBuilder)
+	} else {
+		// the sample is drawn by the database, so that only it is read, and
+		// then put into path order
+		pBuilder.AppendSql("SELECT id, directory, name, fingerprint, mod_time, size, is_dir FROM (")
+		buildMatchQuery(expression, paths, filter, pBuilder)
+		pBuilder.AppendSql("\nORDER BY random()\nLIMIT ")
+		pBuilder.AppendParam(filter.Sample)
+		pBuilder.AppendSql(")")
+	}
 
-	pBuilder.AppendSql("ORDER BY directory || '/' || name")
+	pBuilder.AppendSql("\nORDER BY directory || '/' || name")
 
 	return pBuilder
 }
 
+func buildMatchQuery(expression query.Expression, paths []string, filter entities.FileFilter, pBuilder *SqlBuilder) {
+	if !filter.TopOnly && !filter.LeavesOnly {
+		pBuilder.AppendSql("SELECT id, directory, name, fingerprint, mod_time, size, is_dir FROM " + sqlFiles + " file WHERE 1==1 AND\n")
+		buildQueryBranch(expression, pBuilder)
+		buildPathClause(paths, pBuilder)
+		buildTypeClause("file", filter, pBuilder)
+		buildTaggedSinceClause("file", filter, pBuilder)
+
+		return
+	}
+
+	// the position of each item is determined relative to the other matches
+	pBuilder.AppendSql("WITH matches AS (SELECT id, directory, name, fingerprint, mod_time, size, is_dir FROM " + sqlFiles + " file WHERE 1==1 AND\n")
+	buildQueryBranch(expression, pBuilder)
+	buildPathClause(paths, pBuilder)
+	pBuilder.AppendSql(")\nSELECT id, directory, name, fingerprint, mod_time, size, is_dir FROM matches m WHERE 1==1")
+	buildTypeClause("m", filter, pBuilder)
+	buildTaggedSinceClause("m", filter, pBuilder)
+
+	if filter.TopOnly {
+		// the leaves of the top-most items are the top-most items themselves
+		pBuilder.AppendSql("\nAND NOT EXISTS (SELECT 1 FROM matches a WHERE " + sqlWithin("m", sqlPath("a")) + ")")
+	} else {
+		pBuilder.AppendSql("\nAND NOT EXISTS (SELECT 1 FROM matches d WHERE " + sqlWithin("d", sqlPath("m")) + ")")
+	}
+}
+
+func buildTypeClause(table string, filter entities.FileFilter, builder *SqlBuilder) {
+	if filter.DirectoriesOnly {
+		builder.AppendSql("\nAND " + table + ".is_dir = 1")
+	}
+	if filter.FilesOnly {
+		builder.AppendSql("\nAND " + table + ".is_dir = 0")
+	}
+}
+
+func buildTaggedSinceClause(table string, filter entities.FileFilter, builder *SqlBuilder) {
+	if filter.TaggedSince.IsZero() {
+		return
+	}
+
+	builder.AppendSql("\nAND " + table + ".id IN (SELECT file_id FROM file_tag WHERE applied >= ")
+	builder.AppendParam(filter.TaggedSince.UTC())
+	builder.AppendSql(")")
+}
+
+// The files with the path of each file's directory in place of its identifier,
+// to be selected from rather than the file table itself. SQLite flattens the
+// sub-query into the enclosing query so that the indices of both tables are
+// used.
+const sqlFiles = `(SELECT f.id, d.path AS directory, f.name, f.fingerprint, f.mod_time, f.size, f.is_dir, f.dev, f.inode
+                   FROM file f
+                   INNER JOIN directory d ON d.id = f.directory_id)`
+
+// The SQL expression for the path of the file in the specified table.
+func sqlPath(table string) string {
+	return "CASE WHEN " + table + ".directory = '/' THEN '/' || " + table + ".name ELSE " + table + ".directory || '/' || " + table + ".name END"
+}
+
+// The SQL condition that the file in the specified table is within the
+// directory with the path given by the SQL expression.
+func sqlWithin(table, pathExpression string) string {
+	return "(" + table + ".directory = " + pathExpression + " OR substr(" + table + ".directory, 1, length(" + pathExpression + ") + 1) = " + pathExpression + " || '/')"
+}
+
+// Builds a sub-query selecting the identifiers of the files matching the
+// expression and path.
+func buildFileIdQuery(expression query.Expression, path string, builder *SqlBuilder) {
+	builder.AppendSql("SELECT id FROM " + sqlFiles + " file WHERE 1==1 AND\n")
+	buildQueryBranch(expression, builder)
+	buildPathClause(pathList(path), builder)
+}
+
 func buildQueryBranch(expression query.Expression, builder *SqlBuilder) {
 	switch exp := expression.(type) {
 	case query.TagExpression:
+		if exp.Inherited {
+			builder.AppendSql("(")
+		}
+
 		builder.AppendSql(`id IN (SELECT file_id
 FROM file_tag
 WHERE tag_id = (SELECT id
                 FROM tag
                 WHERE name = `)
 		builder.AppendParam(exp.Name)
-		builder.AppendSql(`))`)
+		builder.AppendSql(`)`)
+		buildOwnerClause(exp, builder)
+		builder.AppendSql(`)`)
+
+		if exp.Inherited {
+			buildInheritedClause(exp, nil, builder)
+			builder.AppendSql(")")
+		}
 	case query.ComparisonExpression:
-		var valueExpression string
-		_, err := strconv.ParseFloat(exp.Value.Name, 64)
-		if err == nil {
-			valueExpression = "CAST(name AS float)"
-		} else {
-			valueExpression = "name"
+		if exp.Tag.Inherited {
+			builder.AppendSql("(")
 		}
 
 		builder.AppendSql(`id IN (SELECT file_id
@@ -435,25 +780,34 @@ WHERE tag_id = (SELECT id
                 FROM tag
                 WHERE name = `)
 		builder.AppendParam(exp.Tag.Name)
-		builder.AppendSql(`)
-AND value_id IN (SELECT id
-                 FROM value
-                 WHERE ` + valueExpression + ` ` + exp.Operator + ` `)
-		builder.AppendParam(exp.Value.Name)
-		builder.AppendSql(`))`)
+		builder.AppendSql(`)`)
+		buildOwnerClause(exp.Tag, builder)
+		buildValueClause(exp, builder)
+		builder.AppendSql(`)`)
+
+		if exp.Tag.Inherited {
+			buildInheritedClause(exp.Tag, &exp, builder)
+			builder.AppendSql(")")
+		}
 	case query.NotExpression:
 		builder.AppendSql("\nNOT\n")
 		buildQueryBranch(exp.Operand, builder)
 	case query.AndExpression:
+		builder.AppendSql("(\n")
 		buildQueryBranch(exp.LeftOperand, builder)
 		builder.AppendSql("\nAND\n")
 		buildQueryBranch(exp.RightOperand, builder)
+		builder.AppendSql(")\n")
 	case query.OrExpression:
 		builder.AppendSql("(\n")
 		buildQueryBranch(exp.LeftOperand, builder)
 		builder.AppendSql("\nOR\n")
 		buildQueryBranch(exp.RightOperand, builder)
 		builder.AppendSql(")\n")
+	case query.PathExpression:
+		builder.AppendSql("(")
+		buildPathCondition(exp.Path, builder)
+		builder.AppendSql(")")
 	case query.EmptyExpression:
 		builder.AppendSql("1 == 1\n")
 	default:
@@ -461,13 +815,118 @@ AND value_id IN (SELECT id
 	}
 }
 
-func buildPathClause(path string, builder *SqlBuilder) {
-	path = filepath.Clean(path)
+func buildValueClause(comparison query.ComparisonExpression, builder *SqlBuilder) {
+	var valueExpression string
+	_, err := strconv.ParseFloat(comparison.Value.Name, 64)
+	if err == nil {
+		valueExpression = "CAST(name AS float)"
+	} else {
+		valueExpression = "name"
+	}
+
+	builder.AppendSql(`
+AND value_id IN (SELECT id
+                 FROM value
+                 WHERE ` + valueExpression + ` ` + comparison.Operator + ` `)
+	builder.AppendParam(comparison.Value.Name)
+	builder.AppendSql(`)`)
+}
+
+// Matches the items within a directory that has the tag (and, where there is
+// a comparison, a value satisfying it) by comparing their paths with those of
+// the tagged directories.
+func buildInheritedClause(tag query.TagExpression, comparison *query.ComparisonExpression, builder *SqlBuilder) {
+	builder.AppendSql(`
+OR EXISTS (SELECT 1
+           FROM file_tag
+           INNER JOIN ` + sqlFiles + ` d ON d.id = file_tag.file_id
+           WHERE d.is_dir = 1
+           AND ` + sqlWithin("file", sqlPath("d")) + `
+           AND tag_id = (SELECT id
+                         FROM tag
+                         WHERE name = `)
+	builder.AppendParam(tag.Name)
+	builder.AppendSql(`)`)
+	buildOwnerClause(tag, builder)
+	if comparison != nil {
+		buildValueClause(*comparison, builder)
+	}
+	builder.AppendSql(`)`)
+}
 
-	dir, name := filepath.Split(path)
-	dir = filepath.Clean(dir)
+func buildOwnerClause(tag query.TagExpression, builder *SqlBuilder) {
+	if tag.Owner == "" {
+		return
+	}
 
-	if path != "" {
-		builder.AppendSql("AND (directory = '" + path + "' OR directory LIKE '" + filepath.Join(path, "%") + "' OR (directory = '" + dir + "' AND name = '" + name + "'))\n")
+	if tag.Shared {
+		builder.AppendSql(`
+AND owner IN ('', `)
+	} else {
+		builder.AppendSql(`
+AND owner IN (`)
 	}
+	builder.AppendParam(tag.Owner)
+	builder.AppendSql(`)`)
+}
+
+// Restricts the query to files at or under any of the paths. The directory
+// comparisons are expressed as ranges, rather than with LIKE, so that they can
+// use the index on the file path.
+func buildPathClause(paths []string, builder *SqlBuilder) {
+	if len(paths) == 0 {
+		return
+	}
+
+	builder.AppendSql("AND (")
+	for index, path := range paths {
+		if index > 0 {
+			builder.AppendSql(" OR ")
+		}
+
+		buildPathCondition(path, builder)
+	}
+	builder.AppendSql(")\n")
+}
+
+// Matches the item at the path and those beneath it.
+func buildPathCondition(path string, builder *SqlBuilder) {
+	path = _path.Clean(path)
+	dir, name := _path.Split(path)
+
+	prefix, upper := directoryRange(path)
+
+	builder.AppendSql("(directory = ")
+	builder.AppendParam(path)
+	builder.AppendSql(" OR (directory >= ")
+	builder.AppendParam(prefix)
+	builder.AppendSql(" AND directory < ")
+	builder.AppendParam(upper)
+	builder.AppendSql(") OR (directory = ")
+	builder.AppendParam(dir)
+	builder.AppendSql(" AND name = ")
+	builder.AppendParam(name)
+	builder.AppendSql("))")
+}
+
+// The bounds of the range of directory paths beneath the (clean) path: the
+// lower bound is inclusive and the upper exclusive.
+func directoryRange(path string) (string, string) {
+	// '0' is the character after the separator so this range spans the
+	// directories beneath the path
+	lower := path
+	if !strings.HasSuffix(lower, string(filepath.Separator)) {
+		lower += string(filepath.Separator)
+	}
+	upper := lower[:len(lower)-1] + string(filepath.Separator+1)
+
+	return lower, upper
+}
+
+func pathList(path string) []string {
+	if path == "" {
+		return nil
+	}
+
+	return []string{path}
 }
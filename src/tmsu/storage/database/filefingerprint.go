@@ -0,0 +1,147 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"database/sql"
+	"time"
+	"tmsu/common/fingerprint"
+	"tmsu/entities"
+)
+
+// Retrieves the additional fingerprints of the specified file by algorithm.
+func (db *Database) FileFingerprints(fileId entities.FileId) (map[string]fingerprint.Fingerprint, error) {
+	sql := `SELECT algorithm, fingerprint
+            FROM file_fingerprint
+            WHERE file_id = ?`
+
+	rows, err := db.ExecQuery(sql, fileId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fingerprints := make(map[string]fingerprint.Fingerprint)
+	for rows.Next() {
+		var algorithm, fp string
+		if err := rows.Scan(&algorithm, &fp); err != nil {
+			return nil, err
+		}
+
+		fingerprints[algorithm] = fingerprint.Fingerprint(fp)
+	}
+
+	return fingerprints, rows.Err()
+}
+
+// Creates or updates the fingerprint of the specified file under the
+// specified algorithm.
+func (db *Database) UpdateFileFingerprint(fileId entities.FileId, algorithm string, fingerprint fingerprint.Fingerprint) error {
+	sql := `INSERT OR REPLACE INTO file_fingerprint (file_id, algorithm, fingerprint)
+            VALUES (?, ?, ?)`
+
+	_, err := db.Exec(sql, fileId, algorithm, string(fingerprint))
+	return err
+}
+
+// Retrieves the set of files with the specified fingerprint under the
+// specified additional algorithm.
+func (db *Database) FilesByAlgorithmFingerprint(algorithm string, fingerprint fingerprint.Fingerprint) (entities.Files, error) {
+	sql := `SELECT f.id, f.directory, f.name, f.fingerprint, f.mod_time, f.size, f.is_dir
+            FROM ` + sqlFiles + ` f
+            INNER JOIN file_fingerprint ff ON ff.file_id = f.id
+            WHERE ff.algorithm = ? AND ff.fingerprint = ?
+            ORDER BY f.directory || '/' || f.name`
+
+	rows, err := db.ExecQuery(sql, algorithm, string(fingerprint))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readFiles(rows, make(entities.Files, 0, 1))
+}
+
+// Retrieves the sets of duplicate files within the database according to
+// their fingerprints under the specified additional algorithm.
+func (db *Database) DuplicateFilesByAlgorithm(algorithm string) ([]entities.Files, error) {
+	sql := `SELECT ff.fingerprint, f.id, f.directory, f.name, f.fingerprint, f.mod_time, f.size, f.is_dir
+            FROM ` + sqlFiles + ` f
+            INNER JOIN file_fingerprint ff ON ff.file_id = f.id
+            WHERE ff.algorithm = ?1 AND ff.fingerprint IN (
+                SELECT fingerprint
+                FROM file_fingerprint
+                WHERE algorithm = ?1 AND fingerprint != ''
+                GROUP BY fingerprint
+                HAVING count(1) > 1
+            )
+            ORDER BY ff.fingerprint, f.directory || '/' || f.name`
+
+	rows, err := db.ExecQuery(sql, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readFileSets(rows)
+}
+
+// unexported
+
+// Reads the sets of files that share the key in the first column.
+func readFileSets(rows *sql.Rows) ([]entities.Files, error) {
+	fileSets := make([]entities.Files, 0, 10)
+	var fileSet entities.Files
+	var previousKey string
+
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var key string
+		var fileId entities.FileId
+		var directory, name, fp string
+		var modTime time.Time
+		var size int64
+		var isDir bool
+		if err := rows.Scan(&key, &fileId, &directory, &name, &fp, &modTime, &size, &isDir); err != nil {
+			return nil, err
+		}
+
+		if fileSet == nil || key != previousKey {
+			if fileSet != nil {
+				fileSets = append(fileSets, fileSet)
+			}
+			fileSet = make(entities.Files, 0, 10)
+			previousKey = key
+		}
+
+		fileSet = append(fileSet, &entities.File{fileId, directory, name, fingerprint.Fingerprint(fp), modTime, size, isDir})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// ensure last file set is added
+	if len(fileSet) > 0 {
+		fileSets = append(fileSets, fileSet)
+	}
+
+	return fileSets, nil
+}
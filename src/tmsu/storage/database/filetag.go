@@ -19,6 +19,9 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
+	"time"
 	"tmsu/entities"
 )
 
@@ -56,7 +59,7 @@ func (db *Database) FileTagCount() (uint, error) {
 
 // Retrieves the complete set of file tags.
 func (db *Database) FileTags() (entities.FileTags, error) {
-	sql := `SELECT file_id, tag_id, value_id
+	sql := `SELECT file_id, tag_id, value_id, owner
 	        FROM file_tag`
 
 	rows, err := db.ExecQuery(sql)
@@ -102,9 +105,24 @@ func (db *Database) FileTagCountByTagId(tagId entities.TagId) (uint, error) {
 	return readCount(rows)
 }
 
+// Retrieves the number of files to which the specified tag is applied.
+func (db *Database) FileCountByTagId(tagId entities.TagId) (uint, error) {
+	sql := `SELECT count(DISTINCT file_id)
+	        FROM file_tag
+	        WHERE tag_id = ?1`
+
+	rows, err := db.ExecQuery(sql, tagId)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	return readCount(rows)
+}
+
 // Retrieves the set of file tags with the specified tag ID.
 func (db *Database) FileTagsByTagId(tagId entities.TagId) (entities.FileTags, error) {
-	sql := `SELECT file_id, tag_id, value_id
+	sql := `SELECT file_id, tag_id, value_id, owner
 	        FROM file_tag
 	        WHERE tag_id = ?1`
 
@@ -136,7 +154,7 @@ func (db *Database) FileTagCountByValueId(valueId entities.ValueId) (uint, error
 
 // Retrieves the set of file tags with the specified value ID.
 func (db *Database) FileTagsByValueId(valueId entities.ValueId) (entities.FileTags, error) {
-	sql := `SELECT file_id, tag_id, value_id
+	sql := `SELECT file_id, tag_id, value_id, owner
 	        FROM file_tag
 	        WHERE value_id = ?1`
 
@@ -151,7 +169,7 @@ func (db *Database) FileTagsByValueId(valueId entities.ValueId) (entities.FileTa
 
 // Retrieves the set of file tags for the specified file.
 func (db *Database) FileTagsByFileId(fileId entities.FileId) (entities.FileTags, error) {
-	sql := `SELECT file_id, tag_id, value_id
+	sql := `SELECT file_id, tag_id, value_id, owner
             FROM file_tag
             WHERE file_id = ?1`
 
@@ -164,20 +182,53 @@ func (db *Database) FileTagsByFileId(fileId entities.FileId) (entities.FileTags,
 	return readFileTags(rows, make(entities.FileTags, 0, 10))
 }
 
-// Adds a file tag.
-func (db *Database) AddFileTag(fileId entities.FileId, tagId entities.TagId, valueId entities.ValueId) (*entities.FileTag, error) {
-	sql := `INSERT OR IGNORE INTO file_tag (file_id, tag_id, value_id)
-            VALUES (?1, ?2, ?3)`
+// Adds a file tag with the specified owner: an empty owner for a file tag that
+// belongs to no user in particular. The time it was applied and the subcommand
+// that applied it are recorded.
+func (db *Database) AddFileTag(fileId entities.FileId, tagId entities.TagId, valueId entities.ValueId, owner, command string) (*entities.FileTag, error) {
+	sql := `INSERT OR IGNORE INTO file_tag (file_id, tag_id, value_id, owner, applied, command)
+            VALUES (?1, ?2, ?3, ?4, ?5, ?6)`
 
-	_, err := db.Exec(sql, fileId, tagId, valueId)
+	_, err := db.Exec(sql, fileId, tagId, valueId, owner, time.Now().UTC(), command)
 	if err != nil {
 		return nil, err
 	}
 
-	return &entities.FileTag{fileId, tagId, valueId, true, false}, nil
+	return &entities.FileTag{fileId, tagId, valueId, owner, true, false}, nil
 }
 
-// Removes a file tag.
+// Applies a batch of tags (and values) to a file, inserting many rows per
+// statement. Tags already applied by the owner are ignored.
+func (db *Database) AddFileTags(fileId entities.FileId, pairs []entities.TagValuePair, owner, command string) (entities.FileTags, error) {
+	applied := time.Now().UTC()
+	fileTags := make(entities.FileTags, 0, len(pairs))
+
+	for start := 0; start < len(pairs); start += insertBatchSize {
+		end := start + insertBatchSize
+		if end > len(pairs) {
+			end = len(pairs)
+		}
+		batch := pairs[start:end]
+
+		sql := `INSERT OR IGNORE INTO file_tag (file_id, tag_id, value_id, owner, applied, command)
+                VALUES (?, ?, ?, ?, ?, ?)`
+		sql += strings.Repeat(", (?, ?, ?, ?, ?, ?)", len(batch)-1)
+
+		params := make([]interface{}, 0, len(batch)*6)
+		for _, pair := range batch {
+			params = append(params, fileId, pair.TagId, pair.ValueId, owner, applied, command)
+			fileTags = append(fileTags, &entities.FileTag{fileId, pair.TagId, pair.ValueId, owner, true, false})
+		}
+
+		if _, err := db.Exec(sql, params...); err != nil {
+			return nil, err
+		}
+	}
+
+	return fileTags, nil
+}
+
+// Removes a file tag, whichever users have applied it.
 func (db *Database) DeleteFileTag(fileId entities.FileId, tagId entities.TagId, valueId entities.ValueId) error {
 	sql := `DELETE FROM file_tag
 	        WHERE file_id = ?1 AND tag_id = ?2 AND value_id = ?3`
@@ -194,8 +245,27 @@ func (db *Database) DeleteFileTag(fileId entities.FileId, tagId entities.TagId,
 	if rowsAffected == 0 {
 		return NoSuchFileTagError{fileId, tagId, valueId}
 	}
-	if rowsAffected > 1 {
-		panic("expected only one row to be affected.")
+
+	return nil
+}
+
+// Removes the file tag applied by the specified owner along with any that has
+// no owner.
+func (db *Database) DeleteOwnedFileTag(fileId entities.FileId, tagId entities.TagId, valueId entities.ValueId, owner string) error {
+	sql := `DELETE FROM file_tag
+	        WHERE file_id = ?1 AND tag_id = ?2 AND value_id = ?3 AND owner IN ('', ?4)`
+
+	result, err := db.Exec(sql, fileId, tagId, valueId, owner)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return NoSuchFileTagError{fileId, tagId, valueId}
 	}
 
 	return nil
@@ -227,14 +297,42 @@ func (db *Database) DeleteFileTagsByTagId(tagId entities.TagId) error {
 	return nil
 }
 
-// Copies file tags from one tag to another.
-func (db *Database) CopyFileTags(sourceTagId entities.TagId, destTagId entities.TagId) error {
-	sql := `INSERT INTO file_tag (file_id, tag_id, value_id)
-            SELECT file_id, ?2, value_id
+// Copies file tags from one tag to another. If values are specified then only
+// the file tags with those values are copied.
+func (db *Database) CopyFileTags(sourceTagId entities.TagId, destTagId entities.TagId, valueIds entities.ValueIds) error {
+	sql := `INSERT INTO file_tag (file_id, tag_id, value_id, owner)
+            SELECT file_id, ?2, value_id, owner
             FROM file_tag
             WHERE tag_id = ?1`
 
-	_, err := db.Exec(sql, sourceTagId, destTagId)
+	params := []interface{}{sourceTagId, destTagId}
+	if len(valueIds) > 0 {
+		placeholders := make([]string, len(valueIds))
+		for index, valueId := range valueIds {
+			placeholders[index] = fmt.Sprintf("?%v", len(params)+1)
+			params = append(params, valueId)
+		}
+
+		sql += `
+            AND value_id IN (` + strings.Join(placeholders, ", ") + ")"
+	}
+
+	_, err := db.Exec(sql, params...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Copies the file tags of one file to another.
+func (db *Database) CopyFileTagsByFileId(sourceFileId, destFileId entities.FileId) error {
+	sql := `INSERT OR IGNORE INTO file_tag (file_id, tag_id, value_id, owner)
+            SELECT ?2, tag_id, value_id, owner
+            FROM file_tag
+            WHERE file_id = ?1`
+
+	_, err := db.Exec(sql, sourceFileId, destFileId)
 	if err != nil {
 		return err
 	}
@@ -244,6 +342,25 @@ func (db *Database) CopyFileTags(sourceTagId entities.TagId, destTagId entities.
 
 // helpers
 
+// Retrieves when, and by which subcommand, each of the file's tags was
+// applied, oldest first.
+func (db *Database) FileTagApplications(fileId entities.FileId) (entities.FileTagApplications, error) {
+	sql := `SELECT t.name, ifnull(v.name, ''), ft.owner, ft.applied, ft.command
+            FROM file_tag ft
+            INNER JOIN tag t ON t.id = ft.tag_id
+            LEFT OUTER JOIN value v ON v.id = ft.value_id
+            WHERE ft.file_id = ?
+            ORDER BY ft.applied IS NOT NULL, ft.applied, t.name, v.name`
+
+	rows, err := db.ExecQuery(sql, fileId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readFileTagApplications(rows, make(entities.FileTagApplications, 0, 10))
+}
+
 func readFileTags(rows *sql.Rows, fileTags entities.FileTags) (entities.FileTags, error) {
 	for rows.Next() {
 		if rows.Err() != nil {
@@ -253,13 +370,32 @@ func readFileTags(rows *sql.Rows, fileTags entities.FileTags) (entities.FileTags
 		var fileId entities.FileId
 		var tagId entities.TagId
 		var valueId entities.ValueId
-		err := rows.Scan(&fileId, &tagId, &valueId)
+		var owner string
+		err := rows.Scan(&fileId, &tagId, &valueId, &owner)
 		if err != nil {
 			return nil, err
 		}
 
-		fileTags = append(fileTags, &entities.FileTag{entities.FileId(fileId), tagId, valueId, true, false})
+		fileTags = append(fileTags, &entities.FileTag{entities.FileId(fileId), tagId, valueId, owner, true, false})
 	}
 
-	return fileTags, nil
+	return fileTags, rows.Err()
+}
+
+func readFileTagApplications(rows *sql.Rows, applications entities.FileTagApplications) (entities.FileTagApplications, error) {
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var tagName, valueName, owner, command string
+		var applied sql.NullTime
+		if err := rows.Scan(&tagName, &valueName, &owner, &applied, &command); err != nil {
+			return nil, err
+		}
+
+		applications = append(applications, &entities.FileTagApplication{tagName, valueName, owner, applied.Time, command})
+	}
+
+	return applications, rows.Err()
 }
@@ -25,11 +25,12 @@ import (
 
 // Retrieves the complete set of tag implications.
 func (db *Database) Implications() (entities.Implications, error) {
-	sql := `SELECT t1.id, t1.name, t2.id, t2.name
-            FROM implication, tag t1, tag t2
-            WHERE implication.tag_id = t1.id
-            AND implication.implied_tag_id = t2.id
-            ORDER BY t1.name, t2.name`
+	sql := `SELECT t1.id, t1.name, implication.value_id, ifnull(v.name, ''), t2.id, t2.name
+            FROM implication
+            INNER JOIN tag t1 ON t1.id = implication.tag_id
+            INNER JOIN tag t2 ON t2.id = implication.implied_tag_id
+            LEFT OUTER JOIN value v ON v.id = implication.value_id
+            ORDER BY t1.name, ifnull(v.name, ''), t2.name`
 
 	result, err := db.ExecQuery(sql)
 	if err != nil {
@@ -46,13 +47,14 @@ func (db *Database) Implications() (entities.Implications, error) {
 
 // Retrieves the set of tags implied by the specified tags.
 func (db *Database) ImplicationsForTags(tagIds entities.TagIds) (entities.Implications, error) {
-	sql := `SELECT t1.id, t1.name, t2.id, t2.name
-            FROM implication, tag t1, tag t2
+	sql := `SELECT t1.id, t1.name, implication.value_id, ifnull(v.name, ''), t2.id, t2.name
+            FROM implication
+            INNER JOIN tag t1 ON t1.id = implication.tag_id
+            INNER JOIN tag t2 ON t2.id = implication.implied_tag_id
+            LEFT OUTER JOIN value v ON v.id = implication.value_id
             WHERE implication.tag_id IN (?`
 	sql += strings.Repeat(",?", len(tagIds)-1)
-	sql += `)
-	        AND implication.tag_id = t1.id
-	        AND implication.implied_tag_id = t2.id`
+	sql += `)`
 
 	params := make([]interface{}, len(tagIds))
 	for index, tagId := range tagIds {
@@ -106,12 +108,13 @@ func (db Database) UpdateImplicationsForTagId(implyingTagId, impliedTagId entiti
 	return nil
 }
 
-// Adds the specified implications
-func (db Database) AddImplication(tagId, impliedTagId entities.TagId) error {
-	sql := `INSERT OR IGNORE INTO implication (tag_id, implied_tag_id)
-	        VALUES (?1, ?2)`
+// Adds the specified implication. A value of zero makes the implication hold
+// whatever the value of the implying tag.
+func (db Database) AddImplication(tagId entities.TagId, valueId entities.ValueId, impliedTagId entities.TagId) error {
+	sql := `INSERT OR IGNORE INTO implication (tag_id, value_id, implied_tag_id)
+	        VALUES (?1, ?2, ?3)`
 
-	_, err := db.Exec(sql, tagId, impliedTagId)
+	_, err := db.Exec(sql, tagId, valueId, impliedTagId)
 	if err != nil {
 		return err
 	}
@@ -120,11 +123,11 @@ func (db Database) AddImplication(tagId, impliedTagId entities.TagId) error {
 }
 
 // Deletes the specified implications
-func (db Database) DeleteImplication(tagId, impliedTagId entities.TagId) error {
+func (db Database) DeleteImplication(tagId entities.TagId, valueId entities.ValueId, impliedTagId entities.TagId) error {
 	sql := `DELETE FROM implication
-            WHERE tag_id = ?1 AND implied_tag_id = ?2`
+            WHERE tag_id = ?1 AND value_id = ?2 AND implied_tag_id = ?3`
 
-	result, err := db.Exec(sql, tagId, impliedTagId)
+	result, err := db.Exec(sql, tagId, valueId, impliedTagId)
 	if err != nil {
 		return err
 	}
@@ -135,7 +138,7 @@ func (db Database) DeleteImplication(tagId, impliedTagId entities.TagId) error {
 	}
 
 	if rowsAffected == 0 {
-		return NoSuchImplicationError{tagId, impliedTagId}
+		return NoSuchImplicationError{tagId, valueId, impliedTagId}
 	}
 	if rowsAffected > 1 {
 		panic("expected exactly one row to be affected")
@@ -157,11 +160,24 @@ func (db Database) DeleteImplicationsForTagId(tagId entities.TagId) error {
 	return nil
 }
 
+// Deletes the implications that hold only for the specified value.
+func (db Database) DeleteImplicationsForValueId(valueId entities.ValueId) error {
+	sql := `DELETE FROM implication
+            WHERE value_id = ?1`
+
+	_, err := db.Exec(sql, valueId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // unexported
 
 func readImplication(rows *sql.Rows) (*entities.Implication, error) {
 	if !rows.Next() {
-		return nil, nil
+		return nil, rows.Err()
 	}
 	if rows.Err() != nil {
 		return nil, rows.Err()
@@ -169,14 +185,16 @@ func readImplication(rows *sql.Rows) (*entities.Implication, error) {
 
 	var implyingTagId entities.TagId
 	var implyingTagName string
+	var implyingValueId entities.ValueId
+	var implyingValueName string
 	var impliedTagId entities.TagId
 	var impliedTagName string
-	err := rows.Scan(&implyingTagId, &implyingTagName, &impliedTagId, &impliedTagName)
+	err := rows.Scan(&implyingTagId, &implyingTagName, &implyingValueId, &implyingValueName, &impliedTagId, &impliedTagName)
 	if err != nil {
 		return nil, err
 	}
 
-	return &entities.Implication{entities.Tag{implyingTagId, implyingTagName}, entities.Tag{impliedTagId, impliedTagName}}, nil
+	return &entities.Implication{entities.Tag{implyingTagId, implyingTagName}, entities.Value{implyingValueId, implyingValueName}, entities.Tag{impliedTagId, impliedTagName}}, nil
 }
 
 func readImplications(rows *sql.Rows, implications entities.Implications) (entities.Implications, error) {
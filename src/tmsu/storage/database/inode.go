@@ -0,0 +1,74 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"tmsu/entities"
+)
+
+// Retrieves the device and inode numbers recorded for the file: both zero if
+// they are not known.
+func (db *Database) FileInode(fileId entities.FileId) (uint64, uint64, error) {
+	sql := `SELECT dev, inode
+            FROM file
+            WHERE id = ?`
+
+	rows, err := db.ExecQuery(sql, fileId)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return 0, 0, rows.Err()
+	}
+
+	var dev, inode int64
+	if err := rows.Scan(&dev, &inode); err != nil {
+		return 0, 0, err
+	}
+
+	return uint64(dev), uint64(inode), nil
+}
+
+// Retrieves the files with the specified device and inode numbers: the hard
+// links to the same file.
+func (db *Database) FilesByInode(dev, inode uint64) (entities.Files, error) {
+	sql := `SELECT id, directory, name, fingerprint, mod_time, size, is_dir
+            FROM ` + sqlFiles + ` file
+            WHERE dev = ? AND inode = ?
+            ORDER BY directory || '/' || name`
+
+	rows, err := db.ExecQuery(sql, int64(dev), int64(inode))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readFiles(rows, make(entities.Files, 0, 10))
+}
+
+// Records the device and inode numbers of the file.
+func (db *Database) UpdateFileInode(fileId entities.FileId, dev, inode uint64) error {
+	sql := `UPDATE file
+            SET dev = ?, inode = ?
+            WHERE id = ?`
+
+	_, err := db.Exec(sql, int64(dev), int64(inode), fileId)
+	return err
+}
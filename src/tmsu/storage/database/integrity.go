@@ -0,0 +1,109 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"tmsu/entities"
+)
+
+// Retrieves the file tags that refer to a file, tag or value that does not
+// exist.
+func (db *Database) OrphanedFileTags() (entities.FileTags, error) {
+	sql := `SELECT file_id, tag_id, value_id, owner
+            FROM file_tag
+            WHERE file_id NOT IN (SELECT id FROM file)
+            OR tag_id NOT IN (SELECT id FROM tag)
+            OR (value_id != 0 AND value_id NOT IN (SELECT id FROM value))
+            ORDER BY file_id, tag_id, value_id`
+
+	rows, err := db.ExecQuery(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readFileTags(rows, make(entities.FileTags, 0, 10))
+}
+
+// Deletes the file tags that refer to a file, tag or value that does not
+// exist.
+func (db *Database) DeleteOrphanedFileTags() error {
+	sql := `DELETE FROM file_tag
+            WHERE file_id NOT IN (SELECT id FROM file)
+            OR tag_id NOT IN (SELECT id FROM tag)
+            OR (value_id != 0 AND value_id NOT IN (SELECT id FROM value))`
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Retrieves the implications that refer to a tag or value that does not exist.
+// Names are blank for the tags and values that do not exist.
+func (db *Database) DanglingImplications() (entities.Implications, error) {
+	sql := `SELECT i.tag_id, ifnull(t1.name, ''), i.value_id, ifnull(v.name, ''), i.implied_tag_id, ifnull(t2.name, '')
+            FROM implication i
+            LEFT OUTER JOIN tag t1 ON t1.id = i.tag_id
+            LEFT OUTER JOIN value v ON v.id = i.value_id
+            LEFT OUTER JOIN tag t2 ON t2.id = i.implied_tag_id
+            WHERE t1.id IS NULL OR t2.id IS NULL OR (i.value_id != 0 AND v.id IS NULL)
+            ORDER BY i.tag_id, i.value_id, i.implied_tag_id`
+
+	rows, err := db.ExecQuery(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readImplications(rows, make(entities.Implications, 0, 10))
+}
+
+// Deletes the implications that refer to a tag or value that does not exist.
+func (db *Database) DeleteDanglingImplications() error {
+	sql := `DELETE FROM implication
+            WHERE tag_id NOT IN (SELECT id FROM tag)
+            OR implied_tag_id NOT IN (SELECT id FROM tag)
+            OR (value_id != 0 AND value_id NOT IN (SELECT id FROM value))`
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Retrieves the files, other than directories and those awaiting a
+// fingerprint, that have no fingerprint.
+func (db *Database) FilesWithoutFingerprint() (entities.Files, error) {
+	sql := `SELECT id, directory, name, fingerprint, mod_time, size, is_dir
+            FROM ` + sqlFiles + ` file
+            WHERE fingerprint = '' AND NOT is_dir
+            AND id NOT IN (SELECT file_id
+                           FROM pending_fingerprint)
+            ORDER BY directory || '/' || name`
+
+	rows, err := db.ExecQuery(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readFiles(rows, make(entities.Files, 0, 10))
+}
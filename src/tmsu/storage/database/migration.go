@@ -0,0 +1,212 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"tmsu/common/log"
+	"tmsu/entities"
+)
+
+type migration struct {
+	version     uint
+	description string
+	apply       func(*Database) error
+}
+
+// The schema migrations, in the order they must be applied. Each migration
+// must be idempotent as databases created prior to the introduction of the
+// migration table will have some of them applied already.
+var migrations = []migration{
+	{1, "create base schema", (*Database).CreateSchema},
+	{2, "add full-text search index", (*Database).CreateFileSearchTable},
+	{3, "add deleted file records", (*Database).CreateDeletedFileTable},
+	{4, "add change events", (*Database).CreateEventTable},
+	{5, "add file tag owners", (*Database).AddFileTagOwner},
+	{6, "add repair checkpoints", (*Database).CreateRepairCheckpointTable},
+	{7, "add additional file fingerprints", (*Database).CreateFileFingerprintTable},
+	{8, "add file device and inode numbers", (*Database).AddFileInode},
+	{9, "add file tag application times", (*Database).AddFileTagApplied},
+	{10, "add unused tag and value times", (*Database).AddUnusedSince},
+	{11, "add implying values", (*Database).AddImplicationValue},
+	{12, "add compound file tag index", (*Database).AddFileTagCompoundIndex},
+	{13, "add pending fingerprints", (*Database).CreatePendingFingerprintTable},
+	{14, "add reporting views", (*Database).CreateReportingViews},
+	{15, "add tag sets", (*Database).CreateTagSetTable},
+	{16, "normalise file directories", (*Database).NormaliseFileDirectories},
+}
+
+// The schema version that this build of the database package produces.
+func LatestSchemaVersion() uint {
+	return migrations[len(migrations)-1].version
+}
+
+// Retrieves the version of the database schema: zero if no migrations have
+// been applied.
+func (db *Database) SchemaVersion() (uint, error) {
+	exists, err := db.tableExists("migration")
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	sql := `SELECT ifnull(max(version), 0)
+            FROM migration`
+
+	rows, err := db.ExecQuery(sql)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	return readCount(rows)
+}
+
+// Retrieves the migrations that have been applied to the database.
+func (db *Database) Migrations() (entities.Migrations, error) {
+	exists, err := db.tableExists("migration")
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return entities.Migrations{}, nil
+	}
+
+	sql := `SELECT version, description, applied
+            FROM migration
+            ORDER BY version`
+
+	rows, err := db.ExecQuery(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readMigrations(rows, make(entities.Migrations, 0, len(migrations)))
+}
+
+// Upgrades the schema to the latest version by applying any outstanding
+// migrations in a single transaction. An existing database is first backed up
+// alongside the database file.
+func (db *Database) Upgrade() error {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	latestVersion := LatestSchemaVersion()
+
+	if version > latestVersion {
+		return fmt.Errorf("database schema version %v is newer than the latest supported version %v: upgrade TMSU", version, latestVersion)
+	}
+	if version == latestVersion {
+		return nil
+	}
+
+	existing, err := db.tableExists("tag")
+	if err != nil {
+		return err
+	}
+
+	if existing {
+		backupPath := fmt.Sprintf("%v.schema-%v.bak", db.Path, version)
+
+		log.Warnf("upgrading database schema from version %v to %v (backup at '%v').", version, latestVersion, backupPath)
+
+		if err := db.Backup(backupPath); err != nil {
+			return fmt.Errorf("could not back up database before upgrade: %v", err)
+		}
+	}
+
+	if err := db.Begin(); err != nil {
+		return err
+	}
+
+	if err := db.applyMigrations(version); err != nil {
+		db.Rollback()
+		return err
+	}
+
+	return db.Commit()
+}
+
+// unexported
+
+func (db *Database) applyMigrations(version uint) error {
+	if err := db.createMigrationTable(); err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		if migration.version <= version {
+			continue
+		}
+
+		log.Infof(2, "applying schema migration %v: %v", migration.version, migration.description)
+
+		if err := migration.apply(db); err != nil {
+			return fmt.Errorf("could not apply schema migration %v (%v): %v", migration.version, migration.description, err)
+		}
+
+		sql := `INSERT INTO migration (version, description, applied)
+                VALUES (?1, ?2, ?3)`
+
+		if _, err := db.Exec(sql, migration.version, migration.description, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *Database) createMigrationTable() error {
+	sql := `CREATE TABLE IF NOT EXISTS migration (
+                version INTEGER PRIMARY KEY,
+                description TEXT NOT NULL,
+                applied DATETIME NOT NULL
+            )`
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func readMigrations(rows *sql.Rows, migrations entities.Migrations) (entities.Migrations, error) {
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var version uint
+		var description string
+		var applied time.Time
+		if err := rows.Scan(&version, &description, &applied); err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, &entities.Migration{version, description, applied})
+	}
+
+	return migrations, rows.Err()
+}
@@ -0,0 +1,236 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNormaliseFileDirectoriesUpgrade(test *testing.T) {
+	// set-up
+
+	databasePath := filepath.Join(os.TempDir(), "tmsu_migration_test.db")
+	backupPath := databasePath + ".schema-15.bak"
+	os.Remove(databasePath)
+	defer os.Remove(databasePath)
+	defer os.Remove(backupPath)
+
+	db := openAtVersion(test, databasePath, 15)
+
+	now := time.Now()
+	statements := []struct {
+		sql  string
+		args []interface{}
+	}{
+		{`INSERT INTO tag (id, name) VALUES (1, 'apple'), (2, 'banana')`, nil},
+		{`INSERT INTO value (id, name) VALUES (1, 'red')`, nil},
+		{`INSERT INTO file (id, directory, name, fingerprint, mod_time, size, is_dir, dev, inode)
+          VALUES (1, '/', 'top', 'fp1', ?1, 1, 0, 0, 0),
+                 (2, '/some/dir', 'a', 'fp2', ?1, 2, 0, 8, 100),
+                 (3, '/some/dir', 'b', 'fp3', ?1, 3, 0, 8, 100),
+                 (4, '/some/dir/sub', 'c', 'fp4', ?1, 4, 0, 0, 0),
+                 (5, '/some/dirt', 'd', 'fp5', ?1, 5, 0, 0, 0)`, []interface{}{now}},
+		{`INSERT INTO file_tag (file_id, tag_id, value_id)
+          VALUES (1, 1, 0), (2, 1, 1), (2, 2, 0), (3, 2, 0), (4, 1, 0)`, nil},
+		{`INSERT INTO file_fingerprint (file_id, algorithm, fingerprint)
+          VALUES (2, 'MD5', 'md5-2'), (3, 'MD5', 'md5-3')`, nil},
+		{`INSERT INTO deleted_file (id, directory, name, fingerprint, deleted)
+          VALUES (1, '/some/dir', 'gone', 'fp6', ?1)`, []interface{}{now}},
+		{`INSERT INTO deleted_file_tag (deleted_file_id, tag_name, value_name)
+          VALUES (1, 'apple', '')`, nil},
+	}
+
+	if err := db.Begin(); err != nil {
+		test.Fatal(err)
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement.sql, statement.args...); err != nil {
+			test.Fatal(err)
+		}
+	}
+	if err := db.Commit(); err != nil {
+		test.Fatal(err)
+	}
+
+	triggers := triggerNames(test, db)
+	db.Close()
+
+	// test
+
+	db, err := OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer db.Close()
+
+	// validate
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if version != LatestSchemaVersion() {
+		test.Fatalf("Expected schema version %v but was %v.", LatestSchemaVersion(), version)
+	}
+
+	files, err := db.Files()
+	if err != nil {
+		test.Fatal(err)
+	}
+	paths := make([]string, len(files))
+	for index, file := range files {
+		paths[index] = file.Path()
+	}
+	expectedPaths := []string{"/top", "/some/dir/a", "/some/dir/b", "/some/dir/sub/c", "/some/dirt/d"}
+	if !reflect.DeepEqual(paths, expectedPaths) {
+		test.Fatalf("Expected files %v but were %v.", expectedPaths, paths)
+	}
+
+	underDir, err := db.FilesByDirectory("/some/dir")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(underDir) != 3 {
+		test.Fatalf("Expected 3 files under '/some/dir' but there were %v.", len(underDir))
+	}
+
+	expectCount(test, db, "SELECT count(1) FROM directory", 4)
+	expectCount(test, db, "SELECT count(1) FROM file_tag", 5)
+	expectCount(test, db, "SELECT count(1) FROM file_tag WHERE file_id = 2 AND value_id = 1", 1)
+	expectCount(test, db, "SELECT count(1) FROM v_files", 5)
+	expectCount(test, db, "SELECT count(1) FROM v_files WHERE path = '/top'", 1)
+	expectCount(test, db, "SELECT count(1) FROM v_file_tags WHERE path = '/some/dir/sub/c' AND tag = 'apple'", 1)
+	expectCount(test, db, "SELECT count(1) FROM v_tags WHERE name = 'banana' AND file_count = 2", 1)
+
+	fingerprints, err := db.FileFingerprints(2)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if fingerprints["MD5"] != "md5-2" {
+		test.Fatalf("Expected the MD5 fingerprint to survive but fingerprints were %v.", fingerprints)
+	}
+
+	links, err := db.FilesByInode(8, 100)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(links) != 2 {
+		test.Fatalf("Expected 2 files with the inode but there were %v.", len(links))
+	}
+
+	deletedFiles, err := db.DeletedFiles()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(deletedFiles) != 1 || deletedFiles[0].Path() != "/some/dir/gone" || len(deletedFiles[0].Tags) != 1 {
+		test.Fatalf("Deleted files did not survive: %v.", deletedFiles)
+	}
+
+	if upgradedTriggers := triggerNames(test, db); !reflect.DeepEqual(upgradedTriggers, triggers) {
+		test.Fatalf("Expected triggers %v but were %v.", triggers, upgradedTriggers)
+	}
+
+	// the recreated triggers refer to the directory table
+	if err := db.Begin(); err != nil {
+		test.Fatal(err)
+	}
+	defer db.Rollback()
+
+	if _, err := db.Exec(`UPDATE file SET name = 'moved' WHERE id = 2`); err != nil {
+		test.Fatal(err)
+	}
+	expectCount(test, db, "SELECT count(1) FROM event WHERE type = 'file-move' AND path = '/some/dir/moved' AND previous = '/some/dir/a'", 1)
+	expectCount(test, db, "SELECT count(1) FROM file_fingerprint WHERE file_id = 2", 1)
+
+	if _, err := db.Exec(`UPDATE file SET fingerprint = 'changed' WHERE id = 2`); err != nil {
+		test.Fatal(err)
+	}
+	expectCount(test, db, "SELECT count(1) FROM file_fingerprint WHERE file_id = 2", 0)
+
+	if _, err := db.Exec(`DELETE FROM file WHERE id = 3`); err != nil {
+		test.Fatal(err)
+	}
+	expectCount(test, db, "SELECT count(1) FROM event WHERE type = 'file-remove' AND path = '/some/dir/b'", 1)
+	expectCount(test, db, "SELECT count(1) FROM file_fingerprint WHERE file_id = 3", 0)
+}
+
+// unexported
+
+// Opens a new database at the path, upgrading its schema only as far as the
+// specified version.
+func openAtVersion(test *testing.T, path string, version uint) *Database {
+	allMigrations := migrations
+	defer func() { migrations = allMigrations }()
+
+	for index, migration := range migrations {
+		if migration.version == version {
+			migrations = migrations[:index+1]
+			break
+		}
+	}
+
+	db, err := OpenAt(path)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	return db
+}
+
+func triggerNames(test *testing.T, db *Database) []string {
+	rows, err := db.ExecQuery(`SELECT name FROM sqlite_master WHERE type = 'trigger' ORDER BY name`)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer rows.Close()
+
+	names := make([]string, 0, 20)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			test.Fatal(err)
+		}
+
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		test.Fatal(err)
+	}
+
+	return names
+}
+
+func expectCount(test *testing.T, db *Database, sql string, expected uint) {
+	rows, err := db.ExecQuery(sql)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer rows.Close()
+
+	count, err := readCount(rows)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if count != expected {
+		test.Fatalf("Expected %v from '%v' but was %v.", expected, sql, count)
+	}
+}
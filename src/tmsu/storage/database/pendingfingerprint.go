@@ -0,0 +1,71 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"time"
+	"tmsu/entities"
+)
+
+// Retrieves the number of files awaiting a fingerprint.
+func (db *Database) PendingFingerprintCount() (uint, error) {
+	sql := `SELECT count(1)
+            FROM pending_fingerprint`
+
+	rows, err := db.ExecQuery(sql)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	return readCount(rows)
+}
+
+// Retrieves the files awaiting a fingerprint.
+func (db *Database) PendingFingerprintFiles() (entities.Files, error) {
+	sql := `SELECT f.id, f.directory, f.name, f.fingerprint, f.mod_time, f.size, f.is_dir
+            FROM ` + sqlFiles + ` f
+            INNER JOIN pending_fingerprint pf ON pf.file_id = f.id
+            ORDER BY f.directory || '/' || f.name`
+
+	rows, err := db.ExecQuery(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readFiles(rows, make(entities.Files, 0, 10))
+}
+
+// Queues the specified file for fingerprinting.
+func (db *Database) InsertPendingFingerprint(fileId entities.FileId) error {
+	sql := `INSERT OR IGNORE INTO pending_fingerprint (file_id, queued)
+            VALUES (?, ?)`
+
+	_, err := db.Exec(sql, fileId, time.Now().UTC())
+	return err
+}
+
+// Removes the specified file from the fingerprinting queue.
+func (db *Database) DeletePendingFingerprint(fileId entities.FileId) error {
+	sql := `DELETE FROM pending_fingerprint
+            WHERE file_id = ?`
+
+	_, err := db.Exec(sql, fileId)
+	return err
+}
@@ -101,7 +101,7 @@ func (db *Database) DeleteQuery(text string) error {
 
 func readQuery(rows *sql.Rows) (*entities.Query, error) {
 	if !rows.Next() {
-		return nil, nil
+		return nil, rows.Err()
 	}
 	if rows.Err() != nil {
 		return nil, rows.Err()
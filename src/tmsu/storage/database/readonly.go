@@ -0,0 +1,84 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"database/sql"
+	"net/url"
+	"tmsu/common/log"
+)
+
+// Runs the query through a separate, read-only connection to the database so
+// that it cannot change the database, whatever the query. The query sees the
+// committed state of the database. The header function is given the names of
+// the columns and then the row function the values of each row in turn.
+func (db *Database) ReadOnlyQuery(query string, header func(columns []string) error, row func(values []interface{}) error) error {
+	log.Infof(3, "executing read-only query\n"+query)
+
+	dataSource := url.URL{Scheme: "file", Path: db.Path, RawQuery: "mode=ro"}
+
+	connection, err := sql.Open("sqlite3", dataSource.String())
+	if err != nil {
+		return DatabaseAccessError{db.Path, err}
+	}
+	defer connection.Close()
+
+	rows, err := connection.QueryContext(db.ctx, query)
+	if err != nil {
+		return DatabaseQueryError{db.Path, query, err}
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return DatabaseQueryError{db.Path, query, err}
+	}
+
+	if err := header(columns); err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for index := range values {
+		pointers[index] = &values[index]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return DatabaseQueryError{db.Path, query, err}
+		}
+
+		// text is read as bytes
+		for index, value := range values {
+			if bytes, ok := value.([]byte); ok {
+				values[index] = string(bytes)
+			}
+		}
+
+		if err := row(values); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return DatabaseQueryError{db.Path, query, err}
+	}
+
+	return nil
+}
@@ -22,6 +22,8 @@ import (
 	"tmsu/common/log"
 )
 
+// Creates the base schema, as it was prior to the introduction of versioned
+// migrations. Later schema changes are made by the migrations.
 func (db *Database) CreateSchema() error {
 	log.Info(2, "creating schema")
 
@@ -193,3 +195,763 @@ func (db *Database) CreateSettingTable() error {
 
 	return nil
 }
+
+func (db *Database) CreateFileSearchTable() error {
+	exists, err := db.tableExists("file_search")
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	// FTS5 is preferred but is not compiled into every SQLite build so fall
+	// back to FTS4, which supports the same subset of the match syntax we use
+	sql := `CREATE VIRTUAL TABLE file_search USING fts5(tags, vals)`
+
+	if _, err := db.Exec(sql); err != nil {
+		log.Infof(2, "full-text search module fts5 unavailable, using fts4: %v", err)
+
+		sql = `CREATE VIRTUAL TABLE file_search USING fts4(tags, vals)`
+
+		if _, err := db.Exec(sql); err != nil {
+			return err
+		}
+	}
+
+	if err := db.createFileSearchTriggers(); err != nil {
+		return err
+	}
+
+	// index any existing file tags
+	sql = refreshFileSearchSql("SELECT DISTINCT file_id FROM file_tag")
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *Database) CreateDeletedFileTable() error {
+	sql := `CREATE TABLE IF NOT EXISTS deleted_file (
+                id INTEGER PRIMARY KEY,
+                directory TEXT NOT NULL,
+                name TEXT NOT NULL,
+                fingerprint TEXT NOT NULL,
+                deleted DATETIME NOT NULL
+            )`
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `CREATE TABLE IF NOT EXISTS deleted_file_tag (
+               deleted_file_id INTEGER NOT NULL,
+               tag_name TEXT NOT NULL,
+               value_name TEXT NOT NULL,
+               PRIMARY KEY (deleted_file_id, tag_name, value_name),
+               FOREIGN KEY (deleted_file_id) REFERENCES deleted_file(id)
+           )`
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *Database) CreateEventTable() error {
+	sql := `CREATE TABLE IF NOT EXISTS event (
+                seq INTEGER PRIMARY KEY AUTOINCREMENT,
+                time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                type TEXT NOT NULL,
+                path TEXT NOT NULL DEFAULT '',
+                tag TEXT NOT NULL DEFAULT '',
+                value TEXT NOT NULL DEFAULT '',
+                previous TEXT NOT NULL DEFAULT ''
+            )`
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
+	if err := db.createEventTriggers(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Records how far an interrupted repair of each path got so that it can be
+// resumed.
+func (db *Database) CreateRepairCheckpointTable() error {
+	sql := `CREATE TABLE IF NOT EXISTS repair_checkpoint (
+                path TEXT PRIMARY KEY,
+                last_path TEXT NOT NULL,
+                updated DATETIME NOT NULL
+            )`
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Holds the fingerprints of each file under algorithms other than the primary
+// one. They are removed when the file is deleted or its primary fingerprint changes.
+func (db *Database) CreateFileFingerprintTable() error {
+	sql := `CREATE TABLE IF NOT EXISTS file_fingerprint (
+                file_id INTEGER NOT NULL,
+                algorithm TEXT NOT NULL,
+                fingerprint TEXT NOT NULL,
+                PRIMARY KEY (file_id, algorithm),
+                FOREIGN KEY (file_id) REFERENCES file(id)
+            )`
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `CREATE INDEX IF NOT EXISTS idx_file_fingerprint_algorithm
+           ON file_fingerprint(algorithm, fingerprint)`
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
+	triggers := map[string]string{
+		"trg_file_fingerprint_file_update": `AFTER UPDATE OF fingerprint ON file
+                                             WHEN OLD.fingerprint != NEW.fingerprint
+                                             BEGIN
+                                                 DELETE FROM file_fingerprint WHERE file_id = OLD.id;
+                                             END`,
+		"trg_file_fingerprint_file_delete": `AFTER DELETE ON file
+                                             BEGIN
+                                                 DELETE FROM file_fingerprint WHERE file_id = OLD.id;
+                                             END`}
+
+	for name, body := range triggers {
+		sql := `CREATE TRIGGER IF NOT EXISTS ` + name + ` ` + body
+
+		if _, err := db.Exec(sql); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Adds the owner of each file tag, which becomes part of its key so that users
+// can independently apply the same tag. Existing file tags have no owner.
+func (db *Database) AddFileTagOwner() error {
+	exists, err := db.columnExists("file_tag", "owner")
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	// triggers on the other tables that refer to the file_tag table would
+	// prevent it from being replaced
+	if err := db.dropTriggersReferring("file_tag"); err != nil {
+		return err
+	}
+
+	statements := []string{`CREATE TABLE file_tag_owned (
+                                file_id INTEGER NOT NULL,
+                                tag_id INTEGER NOT NULL,
+                                value_id INTEGER NOT NULL,
+                                owner TEXT NOT NULL DEFAULT '',
+                                PRIMARY KEY (file_id, tag_id, value_id, owner),
+                                FOREIGN KEY (file_id) REFERENCES file(id),
+                                FOREIGN KEY (tag_id) REFERENCES tag(id)
+                                FOREIGN KEY (value_id) REFERENCES value(id)
+                            )`,
+		`INSERT INTO file_tag_owned (file_id, tag_id, value_id)
+         SELECT file_id, tag_id, value_id
+         FROM file_tag`,
+		`DROP TABLE file_tag`,
+		`ALTER TABLE file_tag_owned RENAME TO file_tag`}
+
+	for _, sql := range statements {
+		if _, err := db.Exec(sql); err != nil {
+			return err
+		}
+	}
+
+	// the indices went with the old table
+	if err := db.CreateFileTagTable(); err != nil {
+		return err
+	}
+
+	if err := db.createFileSearchTriggers(); err != nil {
+		return err
+	}
+
+	if err := db.createEventTriggers(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// unexported
+
+// Creates the triggers that keep the full-text search index up to date.
+func (db *Database) createFileSearchTriggers() error {
+	triggers := map[string]string{
+		"trg_file_search_file_tag_insert": `AFTER INSERT ON file_tag
+                                            BEGIN ` + refreshFileSearchSql("SELECT NEW.file_id") + ` END`,
+		"trg_file_search_file_tag_delete": `AFTER DELETE ON file_tag
+                                            BEGIN ` + refreshFileSearchSql("SELECT OLD.file_id") + ` END`,
+		"trg_file_search_tag_update": `AFTER UPDATE OF name ON tag
+                                       BEGIN ` + refreshFileSearchSql("SELECT file_id FROM file_tag WHERE tag_id = NEW.id") + ` END`,
+		"trg_file_search_value_update": `AFTER UPDATE OF name ON value
+                                         BEGIN ` + refreshFileSearchSql("SELECT file_id FROM file_tag WHERE value_id = NEW.id") + ` END`,
+		"trg_file_search_file_delete": `AFTER DELETE ON file
+                                        BEGIN
+                                            DELETE FROM file_search WHERE rowid = OLD.id;
+                                        END`}
+
+	for name, body := range triggers {
+		sql := `CREATE TRIGGER IF NOT EXISTS ` + name + ` ` + body
+
+		if _, err := db.Exec(sql); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Creates the triggers that record the change events.
+func (db *Database) createEventTriggers() error {
+	// the directories are held by the file table until they are normalised
+	normalised, err := db.directoriesNormalised()
+	if err != nil {
+		return err
+	}
+
+	files, directoryColumn := "file", "directory"
+	rowPath := func(row string) string {
+		return row + `.directory || '/' || ` + row + `.name`
+	}
+	if normalised {
+		files, directoryColumn = sqlFiles, "directory_id"
+		rowPath = func(row string) string {
+			return `(SELECT path FROM directory WHERE id = ` + row + `.directory_id) || '/' || ` + row + `.name`
+		}
+	}
+
+	filePath := func(fileId string) string {
+		return `ifnull((SELECT directory || '/' || name FROM ` + files + ` file WHERE id = ` + fileId + `), '')`
+	}
+	tagName := func(tagId string) string {
+		return `ifnull((SELECT name FROM tag WHERE id = ` + tagId + `), '')`
+	}
+	valueName := func(valueId string) string {
+		return `ifnull((SELECT name FROM value WHERE id = ` + valueId + `), '')`
+	}
+
+	triggers := map[string]string{
+		"trg_event_file_tag_insert": `AFTER INSERT ON file_tag
+                                      BEGIN
+                                          INSERT INTO event (type, path, tag, value)
+                                          VALUES ('tag', ` + filePath("NEW.file_id") + `, ` + tagName("NEW.tag_id") + `, ` + valueName("NEW.value_id") + `);
+                                      END`,
+		"trg_event_file_tag_delete": `AFTER DELETE ON file_tag
+                                      BEGIN
+                                          INSERT INTO event (type, path, tag, value)
+                                          VALUES ('untag', ` + filePath("OLD.file_id") + `, ` + tagName("OLD.tag_id") + `, ` + valueName("OLD.value_id") + `);
+                                      END`,
+		"trg_event_file_insert": `AFTER INSERT ON file
+                                  BEGIN
+                                      INSERT INTO event (type, path)
+                                      VALUES ('file-add', ` + rowPath("NEW") + `);
+                                  END`,
+		"trg_event_file_update": `AFTER UPDATE OF ` + directoryColumn + `, name ON file
+                                  WHEN OLD.` + directoryColumn + ` != NEW.` + directoryColumn + ` OR OLD.name != NEW.name
+                                  BEGIN
+                                      INSERT INTO event (type, path, previous)
+                                      VALUES ('file-move', ` + rowPath("NEW") + `, ` + rowPath("OLD") + `);
+                                  END`,
+		"trg_event_file_delete": `AFTER DELETE ON file
+                                  BEGIN
+                                      INSERT INTO event (type, path)
+                                      VALUES ('file-remove', ` + rowPath("OLD") + `);
+                                  END`,
+		"trg_event_tag_insert": `AFTER INSERT ON tag
+                                 BEGIN
+                                     INSERT INTO event (type, tag)
+                                     VALUES ('tag-add', NEW.name);
+                                 END`,
+		"trg_event_tag_update": `AFTER UPDATE OF name ON tag
+                                 WHEN OLD.name != NEW.name
+                                 BEGIN
+                                     INSERT INTO event (type, tag, previous)
+                                     VALUES ('tag-rename', NEW.name, OLD.name);
+                                 END`,
+		"trg_event_tag_delete": `AFTER DELETE ON tag
+                                 BEGIN
+                                     INSERT INTO event (type, tag)
+                                     VALUES ('tag-remove', OLD.name);
+                                 END`}
+
+	for name, body := range triggers {
+		sql := `CREATE TRIGGER IF NOT EXISTS ` + name + ` ` + body
+
+		if _, err := db.Exec(sql); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Records the device and inode numbers of each file so that hard links to the
+// same file may be identified. Both are zero where they are not yet known.
+func (db *Database) AddFileInode() error {
+	for _, column := range []string{"dev", "inode"} {
+		exists, err := db.columnExists("file", column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		sql := `ALTER TABLE file ADD COLUMN ` + column + ` INTEGER NOT NULL DEFAULT 0`
+
+		if _, err := db.Exec(sql); err != nil {
+			return err
+		}
+	}
+
+	sql := `CREATE INDEX IF NOT EXISTS idx_file_inode
+            ON file(dev, inode)`
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Records when, and by which subcommand, each file tag was applied. The time
+// is null for file tags applied before this migration.
+func (db *Database) AddFileTagApplied() error {
+	columns := map[string]string{"applied": "DATETIME",
+		"command": "TEXT NOT NULL DEFAULT ''"}
+
+	for column, definition := range columns {
+		exists, err := db.columnExists("file_tag", column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		sql := `ALTER TABLE file_tag ADD COLUMN ` + column + ` ` + definition
+
+		if _, err := db.Exec(sql); err != nil {
+			return err
+		}
+	}
+
+	sql := `CREATE INDEX IF NOT EXISTS idx_file_tag_applied
+            ON file_tag(applied)`
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Records when each tag and value last became unused so that they may be
+// recycled once a retention period has elapsed. The time is null whilst the tag
+// or value is applied to at least one file.
+func (db *Database) AddUnusedSince() error {
+	for _, table := range []string{"tag", "value"} {
+		exists, err := db.columnExists(table, "unused_since")
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		statements := []string{`ALTER TABLE ` + table + ` ADD COLUMN unused_since DATETIME`,
+			`UPDATE ` + table + `
+             SET unused_since = CURRENT_TIMESTAMP
+             WHERE id NOT IN (SELECT distinct(` + table + `_id)
+                              FROM file_tag)`}
+
+		for _, sql := range statements {
+			if _, err := db.Exec(sql); err != nil {
+				return err
+			}
+		}
+	}
+
+	return db.createUnusedSinceTriggers()
+}
+
+// Creates the triggers that maintain the times at which tags and values became
+// unused.
+func (db *Database) createUnusedSinceTriggers() error {
+	triggers := map[string]string{
+		"trg_unused_file_tag_insert": `AFTER INSERT ON file_tag
+                                       BEGIN
+                                           UPDATE tag SET unused_since = NULL WHERE id = NEW.tag_id;
+                                           UPDATE value SET unused_since = NULL WHERE id = NEW.value_id;
+                                       END`,
+		"trg_unused_file_tag_delete": `AFTER DELETE ON file_tag
+                                       BEGIN
+                                           UPDATE tag SET unused_since = CURRENT_TIMESTAMP
+                                           WHERE id = OLD.tag_id AND
+                                                 NOT EXISTS (SELECT 1 FROM file_tag WHERE tag_id = OLD.tag_id);
+                                           UPDATE value SET unused_since = CURRENT_TIMESTAMP
+                                           WHERE id = OLD.value_id AND
+                                                 NOT EXISTS (SELECT 1 FROM file_tag WHERE value_id = OLD.value_id);
+                                       END`,
+		"trg_unused_tag_insert": `AFTER INSERT ON tag
+                                  BEGIN
+                                      UPDATE tag SET unused_since = CURRENT_TIMESTAMP WHERE id = NEW.id;
+                                  END`,
+		"trg_unused_value_insert": `AFTER INSERT ON value
+                                    BEGIN
+                                        UPDATE value SET unused_since = CURRENT_TIMESTAMP WHERE id = NEW.id;
+                                    END`}
+
+	for name, body := range triggers {
+		sql := `CREATE TRIGGER IF NOT EXISTS ` + name + ` ` + body
+
+		if _, err := db.Exec(sql); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Adds the value of the implying tag to each implication, which becomes part of
+// its key so that a tag may imply different tags depending upon its value.
+// Existing implications have no value and so hold whatever the value.
+func (db *Database) AddImplicationValue() error {
+	exists, err := db.columnExists("implication", "value_id")
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	statements := []string{`CREATE TABLE implication_valued (
+                                tag_id INTEGER NOT NULL,
+                                value_id INTEGER NOT NULL DEFAULT 0,
+                                implied_tag_id INTEGER NOT NULL,
+                                PRIMARY KEY (tag_id, value_id, implied_tag_id)
+                            )`,
+		`INSERT INTO implication_valued (tag_id, implied_tag_id)
+         SELECT tag_id, implied_tag_id
+         FROM implication`,
+		`DROP TABLE implication`,
+		`ALTER TABLE implication_valued RENAME TO implication`}
+
+	for _, sql := range statements {
+		if _, err := db.Exec(sql); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Adds a compound index over the tag, value and file of each file tag so that
+// the file tag sub-queries for a tag, or a tag and value, are answered from the
+// index alone. This supersedes the index on the tag. (The file path and value
+// name are already indexed by their unique constraints.) The statistics that
+// the query planner uses to choose between indexes are then gathered.
+func (db *Database) AddFileTagCompoundIndex() error {
+	statements := []string{`CREATE INDEX IF NOT EXISTS idx_file_tag_tag_value_file
+                            ON file_tag(tag_id, value_id, file_id)`,
+		`DROP INDEX IF EXISTS idx_file_tag_tag_id`,
+		`ANALYZE`}
+
+	for _, sql := range statements {
+		if _, err := db.Exec(sql); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Queues the files that were added without a fingerprint for a later
+// fingerprinting pass. A file leaves the queue when it is deleted.
+func (db *Database) CreatePendingFingerprintTable() error {
+	sql := `CREATE TABLE IF NOT EXISTS pending_fingerprint (
+                file_id INTEGER PRIMARY KEY,
+                queued DATETIME NOT NULL,
+                FOREIGN KEY (file_id) REFERENCES file(id)
+            )`
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `CREATE TRIGGER IF NOT EXISTS trg_pending_fingerprint_file_delete AFTER DELETE ON file
+           BEGIN
+               DELETE FROM pending_fingerprint WHERE file_id = OLD.id;
+           END`
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var reportingViewNames = []string{"v_files", "v_tags", "v_file_tags"}
+
+// Creates the views against which 'sql' queries are written, which present
+// the files, tags and file tags without the need for joins. The views are
+// recreated so that their definitions are brought up to date.
+func (db *Database) CreateReportingViews() error {
+	// the directories are held by the file table until they are normalised
+	normalised, err := db.directoriesNormalised()
+	if err != nil {
+		return err
+	}
+
+	files := "file"
+	if normalised {
+		files = sqlFiles
+	}
+
+	views := map[string]string{
+		"v_files": `SELECT id, ` + sqlPath("file") + ` AS path, directory, name, fingerprint, mod_time, size, is_dir
+                    FROM ` + files + ` file`,
+		"v_tags": `SELECT t.id, t.name, count(DISTINCT ft.file_id) AS file_count
+                   FROM tag t
+                   LEFT OUTER JOIN file_tag ft ON ft.tag_id = t.id
+                   GROUP BY t.id`,
+		"v_file_tags": `SELECT ft.file_id, ` + sqlPath("f") + ` AS path, ft.tag_id, t.name AS tag, ft.value_id, v.name AS value, ft.owner, ft.applied
+                        FROM file_tag ft
+                        INNER JOIN ` + files + ` f ON f.id = ft.file_id
+                        INNER JOIN tag t ON t.id = ft.tag_id
+                        LEFT OUTER JOIN value v ON v.id = ft.value_id`}
+
+	for _, name := range reportingViewNames {
+		if _, err := db.Exec(`DROP VIEW IF EXISTS ` + name); err != nil {
+			return err
+		}
+
+		if _, err := db.Exec(`CREATE VIEW ` + name + ` AS ` + views[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Stores the named sets of tags that may be applied together.
+func (db *Database) CreateTagSetTable() error {
+	sql := `CREATE TABLE IF NOT EXISTS tag_set (
+                name TEXT PRIMARY KEY,
+                tags TEXT NOT NULL
+            )`
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Moves the file directories into a table of their own so that each is stored
+// once, however many files it holds, with the files referring to it by its
+// identifier. Path prefix queries then compare the directories rather than
+// every file.
+func (db *Database) NormaliseFileDirectories() error {
+	sql := `CREATE TABLE IF NOT EXISTS directory (
+                id INTEGER PRIMARY KEY,
+                path TEXT NOT NULL,
+                CONSTRAINT con_directory_path UNIQUE (path)
+            )`
+
+	if _, err := db.Exec(sql); err != nil {
+		return err
+	}
+
+	normalised, err := db.directoriesNormalised()
+	if err != nil {
+		return err
+	}
+	if normalised {
+		return nil
+	}
+
+	// the triggers and views that refer to the file table would prevent it
+	// from being replaced
+	if err := db.dropTriggersReferring("file"); err != nil {
+		return err
+	}
+
+	for _, name := range reportingViewNames {
+		if _, err := db.Exec(`DROP VIEW IF EXISTS ` + name); err != nil {
+			return err
+		}
+	}
+
+	statements := []string{`INSERT OR IGNORE INTO directory (path)
+                            SELECT DISTINCT directory
+                            FROM file`,
+		`CREATE TABLE file_normalised (
+             id INTEGER PRIMARY KEY,
+             directory_id INTEGER NOT NULL,
+             name TEXT NOT NULL,
+             fingerprint TEXT NOT NULL,
+             mod_time DATETIME NOT NULL,
+             size INTEGER NOT NULL,
+             is_dir BOOLEAN NOT NULL,
+             dev INTEGER NOT NULL DEFAULT 0,
+             inode INTEGER NOT NULL DEFAULT 0,
+             CONSTRAINT con_file_path UNIQUE (directory_id, name),
+             FOREIGN KEY (directory_id) REFERENCES directory(id)
+         )`,
+		`INSERT INTO file_normalised (id, directory_id, name, fingerprint, mod_time, size, is_dir, dev, inode)
+         SELECT f.id, d.id, f.name, f.fingerprint, f.mod_time, f.size, f.is_dir, f.dev, f.inode
+         FROM file f
+         INNER JOIN directory d ON d.path = f.directory`,
+		`DROP TABLE file`,
+		`ALTER TABLE file_normalised RENAME TO file`,
+		`CREATE INDEX IF NOT EXISTS idx_file_fingerprint
+         ON file(fingerprint)`,
+		`CREATE INDEX IF NOT EXISTS idx_file_inode
+         ON file(dev, inode)`}
+
+	for _, sql := range statements {
+		if _, err := db.Exec(sql); err != nil {
+			return err
+		}
+	}
+
+	// the triggers went with the old table
+	if err := db.createFileSearchTriggers(); err != nil {
+		return err
+	}
+
+	if err := db.createEventTriggers(); err != nil {
+		return err
+	}
+
+	if err := db.createUnusedSinceTriggers(); err != nil {
+		return err
+	}
+
+	if err := db.CreateFileFingerprintTable(); err != nil {
+		return err
+	}
+
+	if err := db.CreatePendingFingerprintTable(); err != nil {
+		return err
+	}
+
+	if err := db.CreateReportingViews(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *Database) tableExists(name string) (bool, error) {
+	sql := `SELECT count(1)
+            FROM sqlite_master
+            WHERE type = 'table' AND name = ?`
+
+	rows, err := db.ExecQuery(sql, name)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	count, err := readCount(rows)
+	return count > 0, err
+}
+
+func (db *Database) dropTriggersReferring(table string) error {
+	sql := `SELECT name
+            FROM sqlite_master
+            WHERE type = 'trigger' AND sql LIKE '%' || ? || '%'`
+
+	rows, err := db.ExecQuery(sql, table)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, 10)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+
+		names = append(names, name)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if _, err := db.Exec(`DROP TRIGGER IF EXISTS ` + name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Determines whether the files refer to the directory table for their
+// directories, rather than holding the directory paths themselves.
+func (db *Database) directoriesNormalised() (bool, error) {
+	return db.columnExists("file", "directory_id")
+}
+
+func (db *Database) columnExists(table, column string) (bool, error) {
+	sql := `SELECT count(1)
+            FROM pragma_table_info(?)
+            WHERE name = ?`
+
+	rows, err := db.ExecQuery(sql, table, column)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	count, err := readCount(rows)
+	return count > 0, err
+}
+
+// Builds the statements that rebuild the search documents for the files
+// identified by the specified sub-query.
+func refreshFileSearchSql(fileIdQuery string) string {
+	return `DELETE FROM file_search
+            WHERE rowid IN (` + fileIdQuery + `);
+
+            INSERT INTO file_search (rowid, tags, vals)
+            SELECT ft.file_id, group_concat(t.name, ' '), ifnull(group_concat(v.name, ' '), '')
+            FROM file_tag ft
+            INNER JOIN tag t ON t.id = ft.tag_id
+            LEFT OUTER JOIN value v ON v.id = ft.value_id
+            WHERE ft.file_id IN (` + fileIdQuery + `)
+            GROUP BY ft.file_id;`
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+	"tmsu/common/fingerprint"
+	"tmsu/entities"
+)
+
+// Retrieves the files whose tag or value names match any of the specified
+// search terms, which are matched as prefixes. The terms must consist of
+// lower-case letters and digits only.
+func (db *Database) SearchFiles(terms []string) (entities.SearchResults, error) {
+	if len(terms) == 0 {
+		return entities.SearchResults{}, nil
+	}
+
+	matchTerms := make([]string, len(terms))
+	for index, term := range terms {
+		matchTerms[index] = term + "*"
+	}
+
+	sql := `SELECT f.id, f.directory, f.name, f.fingerprint, f.mod_time, f.size, f.is_dir, s.tags, s.vals
+            FROM file_search s
+            INNER JOIN ` + sqlFiles + ` f ON f.id = s.rowid
+            WHERE file_search MATCH ?`
+
+	rows, err := db.ExecQuery(sql, strings.Join(matchTerms, " OR "))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readSearchResults(rows, make(entities.SearchResults, 0, 10))
+}
+
+// unexported
+
+func readSearchResult(rows *sql.Rows) (*entities.SearchResult, error) {
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	var fileId entities.FileId
+	var directory, name, fp, tags, values string
+	var modTime time.Time
+	var size int64
+	var isDir bool
+	err := rows.Scan(&fileId, &directory, &name, &fp, &modTime, &size, &isDir, &tags, &values)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &entities.File{fileId, directory, name, fingerprint.Fingerprint(fp), modTime, size, isDir}
+	return &entities.SearchResult{file, tags, values, 0}, nil
+}
+
+func readSearchResults(rows *sql.Rows, results entities.SearchResults) (entities.SearchResults, error) {
+	for {
+		result, err := readSearchResult(rows)
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			break
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
@@ -51,11 +51,53 @@ func (db *Database) Setting(name string) (*entities.Setting, error) {
 	return readSetting(rows)
 }
 
+// Creates or updates the specified setting.
+func (db *Database) UpdateSetting(name, value string) (*entities.Setting, error) {
+	sql := `INSERT OR REPLACE INTO setting (name, value)
+	        VALUES (?, ?)`
+
+	result, err := db.Exec(sql, name, value)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected != 1 {
+		panic("expected exactly one row to be affected.")
+	}
+
+	return &entities.Setting{name, value}, nil
+}
+
+// Deletes the specified setting.
+func (db *Database) DeleteSetting(name string) error {
+	sql := `DELETE FROM setting
+	        WHERE name = ?`
+
+	result, err := db.Exec(sql, name)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected > 1 {
+		panic("expected only one row to be affected.")
+	}
+
+	return nil
+}
+
 // unexported
 
 func readSetting(rows *sql.Rows) (*entities.Setting, error) {
 	if !rows.Next() {
-		return nil, nil
+		return nil, rows.Err()
 	}
 	if rows.Err() != nil {
 		return nil, rows.Err()
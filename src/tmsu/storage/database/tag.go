@@ -20,7 +20,9 @@ package database
 import (
 	"database/sql"
 	"strings"
+	"time"
 	"tmsu/entities"
+	"tmsu/query"
 )
 
 // The number of tags in the database.
@@ -139,6 +141,44 @@ func (db *Database) TagsByNames(names []string) (entities.Tags, error) {
 	return tags, nil
 }
 
+// Retrieves the tags that have been unused since before the specified time.
+// Tags that take part in an implication are not considered unused.
+func (db *Database) UnusedTagsSince(cutoff time.Time) (entities.Tags, error) {
+	sql := `SELECT id, name
+            FROM tag
+            WHERE unused_since IS NOT NULL AND
+                  unused_since < ? AND
+                  id NOT IN (SELECT tag_id FROM implication) AND
+                  id NOT IN (SELECT implied_tag_id FROM implication)
+            ORDER BY name`
+
+	rows, err := db.ExecQuery(sql, cutoff.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readTags(rows, make(entities.Tags, 0, 10))
+}
+
+// The number of tags that are not applied to any file. Tags that take part in
+// an implication are not considered unused.
+func (db *Database) UnusedTagCount() (uint, error) {
+	sql := `SELECT count(1)
+            FROM tag
+            WHERE unused_since IS NOT NULL AND
+                  id NOT IN (SELECT tag_id FROM implication) AND
+                  id NOT IN (SELECT implied_tag_id FROM implication)`
+
+	rows, err := db.ExecQuery(sql)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	return readCount(rows)
+}
+
 // Adds a tag.
 func (db *Database) InsertTag(name string) (*entities.Tag, error) {
 	sql := `INSERT INTO tag (name)
@@ -208,6 +248,56 @@ func (db *Database) DeleteTag(tagId entities.TagId) error {
 	return nil
 }
 
+// Retrieves the tags applied to the files matching the specified query and
+// path, in a single query.
+func (db *Database) TagsForFileQuery(expression query.Expression, path string) (entities.Tags, error) {
+	builder := NewBuilder()
+	builder.AppendSql(`SELECT DISTINCT t.id, t.name
+FROM file_tag ft
+INNER JOIN tag t ON t.id = ft.tag_id
+WHERE ft.file_id IN (`)
+	buildFileIdQuery(expression, path, &builder)
+	builder.AppendSql(`)
+ORDER BY t.name`)
+
+	rows, err := db.ExecQuery(builder.Sql, builder.Params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readTags(rows, make(entities.Tags, 0, 10))
+}
+
+// Retrieves the distinct tag and value pairs applied to the files matching the
+// specified query and path, in a single query.
+func (db *Database) TagValuePairsForFileQuery(expression query.Expression, path string) ([]entities.TagValuePair, error) {
+	builder := NewBuilder()
+	builder.AppendSql(`SELECT DISTINCT ft.tag_id, ft.value_id
+FROM file_tag ft
+WHERE ft.file_id IN (`)
+	buildFileIdQuery(expression, path, &builder)
+	builder.AppendSql(`)`)
+
+	rows, err := db.ExecQuery(builder.Sql, builder.Params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pairs := make([]entities.TagValuePair, 0, 10)
+	for rows.Next() {
+		var pair entities.TagValuePair
+		if err := rows.Scan(&pair.TagId, &pair.ValueId); err != nil {
+			return nil, err
+		}
+
+		pairs = append(pairs, pair)
+	}
+
+	return pairs, rows.Err()
+}
+
 // Retrieves the usage of each tag
 func (db *Database) TagUsage() ([]entities.TagFileCount, error) {
 	sql := `SELECT t.id, t.name, count(file_id)
@@ -242,14 +332,14 @@ func (db *Database) TagUsage() ([]entities.TagFileCount, error) {
 		tags = append(tags, entities.TagFileCount{tagId, name, count})
 	}
 
-	return tags, nil
+	return tags, rows.Err()
 }
 
 // unexported
 
 func readTag(rows *sql.Rows) (*entities.Tag, error) {
 	if !rows.Next() {
-		return nil, nil
+		return nil, rows.Err()
 	}
 	if rows.Err() != nil {
 		return nil, rows.Err()
@@ -0,0 +1,133 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"database/sql"
+	"tmsu/entities"
+)
+
+// The complete set of tag sets.
+func (db *Database) TagSets() (entities.TagSets, error) {
+	sql := `SELECT name, tags
+	        FROM tag_set
+	        ORDER BY name`
+
+	rows, err := db.ExecQuery(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readTagSets(rows, make(entities.TagSets, 0, 10))
+}
+
+// Retrieves the specified tag set.
+func (db *Database) TagSet(name string) (*entities.TagSet, error) {
+	sql := `SELECT name, tags
+            FROM tag_set
+            WHERE name = ?`
+
+	rows, err := db.ExecQuery(sql, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readTagSet(rows)
+}
+
+// Creates or replaces the specified tag set.
+func (db *Database) UpdateTagSet(name, tags string) (*entities.TagSet, error) {
+	sql := `INSERT OR REPLACE INTO tag_set (name, tags)
+	        VALUES (?, ?)`
+
+	result, err := db.Exec(sql, name, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected != 1 {
+		panic("expected exactly one row to be affected.")
+	}
+
+	return &entities.TagSet{name, tags}, nil
+}
+
+// Deletes the specified tag set.
+func (db *Database) DeleteTagSet(name string) error {
+	sql := `DELETE FROM tag_set
+	        WHERE name = ?`
+
+	result, err := db.Exec(sql, name)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return NoSuchTagSetError{name}
+	}
+	if rowsAffected != 1 {
+		panic("expected exactly one row to be affected.")
+	}
+
+	return nil
+}
+
+// unexported
+
+func readTagSet(rows *sql.Rows) (*entities.TagSet, error) {
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	var name, tags string
+	err := rows.Scan(&name, &tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entities.TagSet{name, tags}, nil
+}
+
+func readTagSets(rows *sql.Rows, tagSets entities.TagSets) (entities.TagSets, error) {
+	for {
+		tagSet, err := readTagSet(rows)
+		if err != nil {
+			return nil, err
+		}
+		if tagSet == nil {
+			break
+		}
+
+		tagSets = append(tagSets, tagSet)
+	}
+
+	return tagSets, nil
+}
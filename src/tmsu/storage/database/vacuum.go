@@ -0,0 +1,58 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"fmt"
+	"tmsu/common/log"
+)
+
+// Rebuilds the database file, reclaiming the space left by deleted rows and by
+// the directories that no longer hold any files. There must not be an open
+// transaction.
+func (db *Database) Vacuum() error {
+	db.state.Lock()
+	defer db.state.Unlock()
+
+	if db.state.transaction != nil {
+		return fmt.Errorf("could not vacuum database: there is an open transaction")
+	}
+
+	log.Info(2, "vacuuming database")
+
+	for _, sql := range []string{`DELETE FROM directory
+                                  WHERE id NOT IN (SELECT DISTINCT directory_id
+                                                   FROM file)`,
+		"VACUUM"} {
+		if _, err := db.connection.ExecContext(db.ctx, sql); err != nil {
+			return DatabaseQueryError{db.Path, sql, err}
+		}
+	}
+
+	db.changed()
+
+	return nil
+}
+
+// Gathers the statistics SQLite uses to choose how to run queries.
+func (db *Database) Analyze() error {
+	log.Info(2, "analysing database")
+
+	_, err := db.Exec("ANALYZE")
+	return err
+}
@@ -20,7 +20,9 @@ package database
 import (
 	"database/sql"
 	"strings"
+	"time"
 	"tmsu/entities"
+	"tmsu/query"
 )
 
 // Retrieves the count of values.
@@ -98,7 +100,8 @@ func (db *Database) UnusedValues() (entities.Values, error) {
 	sql := `SELECT id, name
             FROM value
             WHERE id NOT IN (SELECT distinct(value_id)
-                             FROM file_tag)`
+                             FROM file_tag) AND
+                  id NOT IN (SELECT value_id FROM implication)`
 
 	rows, err := db.ExecQuery(sql)
 	if err != nil {
@@ -109,6 +112,65 @@ func (db *Database) UnusedValues() (entities.Values, error) {
 	return readValues(rows, make(entities.Values, 0, 10))
 }
 
+// Retrieves the values that have been unused since before the specified time.
+// Values that take part in an implication are not considered unused.
+func (db *Database) UnusedValuesSince(cutoff time.Time) (entities.Values, error) {
+	sql := `SELECT id, name
+            FROM value
+            WHERE unused_since IS NOT NULL AND
+                  unused_since < ? AND
+                  id NOT IN (SELECT value_id FROM implication)
+            ORDER BY name`
+
+	rows, err := db.ExecQuery(sql, cutoff.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readValues(rows, make(entities.Values, 0, 10))
+}
+
+// The number of values that are not applied to any file. Values that take
+// part in an implication are not considered unused.
+func (db *Database) UnusedValueCount() (uint, error) {
+	sql := `SELECT count(1)
+            FROM value
+            WHERE unused_since IS NOT NULL AND
+                  id NOT IN (SELECT value_id FROM implication)`
+
+	rows, err := db.ExecQuery(sql)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	return readCount(rows)
+}
+
+// Retrieves the values of the specified tag that are applied to the files
+// matching the specified query and path, in a single query.
+func (db *Database) ValuesForFileQuery(expression query.Expression, path string, tagId entities.TagId) (entities.Values, error) {
+	builder := NewBuilder()
+	builder.AppendSql(`SELECT DISTINCT v.id, v.name
+FROM file_tag ft
+INNER JOIN value v ON v.id = ft.value_id
+WHERE ft.tag_id = `)
+	builder.AppendParam(tagId)
+	builder.AppendSql(`AND ft.file_id IN (`)
+	buildFileIdQuery(expression, path, &builder)
+	builder.AppendSql(`)
+ORDER BY v.name`)
+
+	rows, err := db.ExecQuery(builder.Sql, builder.Params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readValues(rows, make(entities.Values, 0, 10))
+}
+
 // Retrieves a specific value by name.
 func (db *Database) ValueByName(name string) (*entities.Value, error) {
 	sql := `SELECT id, name
@@ -233,21 +295,20 @@ func (db *Database) DeleteUnusedValues(valueIds entities.ValueIds) error {
             WHERE id IN (?`
 	sql += strings.Repeat(",?", len(valueIds)-1)
 	sql += `)
-            AND id NOT IN (SELECT distinct(value_id)
-                           FROM file_tag
-                           WHERE id IN (?`
-	sql += strings.Repeat(",?", len(valueIds)-1)
-	sql += "))"
+            AND NOT EXISTS (SELECT 1
+                            FROM file_tag
+                            WHERE value_id = value.id)
+            AND id NOT IN (SELECT value_id
+                           FROM implication)`
 
-	params := make([]interface{}, len(valueIds)*2)
+	params := make([]interface{}, len(valueIds))
 	for index, valueId := range valueIds {
 		params[index] = valueId
-		params[len(valueIds)+index] = valueId
 	}
 
 	_, err := db.Exec(sql, params...)
 	if err != nil {
-		return nil
+		return err
 	}
 
 	return nil
@@ -257,7 +318,7 @@ func (db *Database) DeleteUnusedValues(valueIds entities.ValueIds) error {
 
 func readValue(rows *sql.Rows) (*entities.Value, error) {
 	if !rows.Next() {
-		return nil, nil
+		return nil, rows.Err()
 	}
 	if rows.Err() != nil {
 		return nil, rows.Err()
@@ -0,0 +1,162 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	_path "tmsu/common/path"
+	"tmsu/entities"
+	"tmsu/query"
+)
+
+// Retrieves the deleted file records that match the specified query and are
+// under any of the specified paths (or anywhere, if there are none).
+func (storage *Storage) DeletedFiles(expression query.Expression, paths []string) (entities.DeletedFiles, error) {
+	expression, err := mapQueryPaths(expression, _path.Abs)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := storage.Db.DeletedFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make(entities.DeletedFiles, 0, len(files))
+	for _, file := range files {
+		if file.Directory == "" || file.Directory[0] != filepath.Separator && !_path.IsUrl(file.Directory) {
+			file.Directory = filepath.Join(storage.RootPath, file.Directory)
+		}
+
+		if !withinAny(file.Path(), paths) {
+			continue
+		}
+
+		if !deletedFileMatches(expression, file.Path(), file.Tags) {
+			continue
+		}
+
+		matches = append(matches, file)
+	}
+
+	return matches, nil
+}
+
+// Records the specified file as deleted, provided the 'retainDeletedFiles'
+// setting is enabled. This must be called before the file's tags are removed.
+func (storage *Storage) RetainDeletedFile(fileId entities.FileId) error {
+	retain, err := storage.SettingAsBool("retainDeletedFiles")
+	if err != nil {
+		return err
+	}
+	if !retain {
+		return nil
+	}
+
+	_, err = storage.Db.InsertDeletedFile(fileId, time.Now())
+	return err
+}
+
+// unexported
+
+func deletedFileMatches(expression query.Expression, path string, tags entities.DeletedFileTags) bool {
+	switch exp := expression.(type) {
+	case query.EmptyExpression:
+		return true
+	case query.TagExpression:
+		for _, tag := range tags {
+			if tag.TagName == exp.Name {
+				return true
+			}
+		}
+
+		return false
+	case query.ComparisonExpression:
+		for _, tag := range tags {
+			if tag.TagName == exp.Tag.Name && tag.ValueName != "" && compareValue(tag.ValueName, exp.Operator, exp.Value.Name) {
+				return true
+			}
+		}
+
+		return false
+	case query.PathExpression:
+		return withinAny(path, []string{exp.Path})
+	case query.NotExpression:
+		return !deletedFileMatches(exp.Operand, path, tags)
+	case query.AndExpression:
+		return deletedFileMatches(exp.LeftOperand, path, tags) && deletedFileMatches(exp.RightOperand, path, tags)
+	case query.OrExpression:
+		return deletedFileMatches(exp.LeftOperand, path, tags) || deletedFileMatches(exp.RightOperand, path, tags)
+	default:
+		panic("Unsupported expression type.")
+	}
+}
+
+// Compares the values in the same manner as the database query: numerically
+// if the queried value is a number, otherwise textually.
+func compareValue(value, operator, queried string) bool {
+	var comparison int
+
+	if queriedNumber, err := strconv.ParseFloat(queried, 64); err == nil {
+		number, _ := strconv.ParseFloat(value, 64)
+
+		switch {
+		case number < queriedNumber:
+			comparison = -1
+		case number > queriedNumber:
+			comparison = 1
+		}
+	} else {
+		comparison = strings.Compare(value, queried)
+	}
+
+	switch operator {
+	case "=", "==":
+		return comparison == 0
+	case "!=":
+		return comparison != 0
+	case "<":
+		return comparison < 0
+	case ">":
+		return comparison > 0
+	case "<=":
+		return comparison <= 0
+	case ">=":
+		return comparison >= 0
+	default:
+		panic("Unsupported comparison operator.")
+	}
+}
+
+func withinAny(path string, dirs []string) bool {
+	if len(dirs) == 0 {
+		return true
+	}
+
+	for _, dir := range dirs {
+		dir = _path.Clean(dir)
+		if dir == "." || path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
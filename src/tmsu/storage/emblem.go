@@ -0,0 +1,68 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Maps a tag, or a tag with a particular value, to the emblem shown for the
+// files it is applied to.
+type EmblemRule struct {
+	TagName   string
+	ValueName string
+	Emblem    string
+}
+
+// Determines whether the rule applies to the tag name, of the form TAG or
+// TAG=VALUE. A rule without a value applies to the tag whatever its value.
+func (rule EmblemRule) Matches(tagName string) bool {
+	name, valueName := tagName, ""
+	if index := strings.Index(tagName, "="); index != -1 {
+		name, valueName = tagName[:index], tagName[index+1:]
+	}
+
+	return name == rule.TagName && (rule.ValueName == "" || valueName == rule.ValueName)
+}
+
+// The rules of the 'emblemTags' setting, which holds whitespace-separated
+// rules of the form TAG[=VALUE]:EMBLEM.
+func (storage *Storage) EmblemRules() ([]EmblemRule, error) {
+	value, err := storage.SettingAsString("emblemTags")
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]EmblemRule, 0, 5)
+	for _, field := range strings.Fields(value) {
+		index := strings.LastIndex(field, ":")
+		if index < 1 || index == len(field)-1 {
+			return nil, fmt.Errorf("invalid emblem rule '%v': must be of the form TAG[=VALUE]:EMBLEM", field)
+		}
+
+		rule := EmblemRule{TagName: field[:index], Emblem: field[index+1:]}
+		if equals := strings.Index(rule.TagName, "="); equals != -1 {
+			rule.TagName, rule.ValueName = rule.TagName[:equals], rule.TagName[equals+1:]
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
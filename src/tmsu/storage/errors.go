@@ -40,3 +40,28 @@ type FileTagDoesNotExist struct {
 func (err FileTagDoesNotExist) Error() string {
 	return fmt.Sprintf("File-tag for file #%v, tag #%v and value #%v does not exist", err.FileId, err.TagId, err.ValueId)
 }
+
+type TagLockedError struct {
+	Name string
+}
+
+func (err TagLockedError) Error() string {
+	return fmt.Sprintf("Tag '%v' is locked", err.Name)
+}
+
+type TagQuotaError struct {
+	Name  string
+	Limit uint
+}
+
+func (err TagQuotaError) Error() string {
+	return fmt.Sprintf("Tag '%v' would be applied to more than %v files", err.Name, err.Limit)
+}
+
+type FileQuotaError struct {
+	Limit uint
+}
+
+func (err FileQuotaError) Error() string {
+	return fmt.Sprintf("File would have more than %v tags", err.Limit)
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"path/filepath"
+	_path "tmsu/common/path"
+	"tmsu/entities"
+)
+
+// Retrieves the sequence number of the latest event.
+func (storage *Storage) LatestEventSeq() (uint, error) {
+	return storage.Db.LatestEventSeq()
+}
+
+// Retrieves the events after the specified sequence number, oldest first.
+func (storage *Storage) EventsSince(seq uint) (entities.Events, error) {
+	events, err := storage.Db.EventsSince(seq)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, event := range events {
+		switch event.Type {
+		case entities.EventFileMove:
+			event.Previous = storage.absEventPath(event.Previous)
+			fallthrough
+		case entities.EventTag, entities.EventUntag, entities.EventFileAdd, entities.EventFileRemove:
+			event.Path = storage.absEventPath(event.Path)
+		}
+	}
+
+	return events, nil
+}
+
+// Retrieves the tag additions and removals for the file at the specified path,
+// oldest first. The file is followed back through any moves, which are also
+// included.
+func (storage *Storage) FileEvents(path string) (entities.Events, error) {
+	seq, err := storage.Db.LatestEventSeq()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(entities.Events, 0, 10)
+	eventPath := storage.eventPath(path)
+
+	for eventPath != "" {
+		pathEvents, err := storage.Db.EventsByPath(eventPath, seq+1)
+		if err != nil {
+			return nil, err
+		}
+
+		eventPath = ""
+		for _, event := range pathEvents {
+			events = append(events, event)
+
+			if event.Type == entities.EventFileMove {
+				// continue with the events from before the file was moved
+				eventPath = event.Previous
+				seq = event.Seq - 1
+				break
+			}
+		}
+	}
+
+	return storage.chronological(events), nil
+}
+
+// Retrieves the applications and removals of the specified tag, oldest first.
+// The tag is followed back through any renames, which are also included.
+func (storage *Storage) TagEvents(tagName string) (entities.Events, error) {
+	seq, err := storage.Db.LatestEventSeq()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(entities.Events, 0, 10)
+
+	for tagName != "" {
+		tagEvents, err := storage.Db.EventsByTag(tagName, seq+1)
+		if err != nil {
+			return nil, err
+		}
+
+		tagName = ""
+		for _, event := range tagEvents {
+			events = append(events, event)
+
+			if event.Type == entities.EventTagRename {
+				// continue with the events from before the tag was renamed
+				tagName = event.Previous
+				seq = event.Seq - 1
+				break
+			}
+		}
+	}
+
+	return storage.chronological(events), nil
+}
+
+// Deletes the events up to and including the specified sequence number.
+func (storage *Storage) DeleteEventsUpTo(seq uint) error {
+	return storage.Db.DeleteEventsUpTo(seq)
+}
+
+// unexported
+
+// Reverses the events, which are latest first, and makes their paths absolute.
+func (storage *Storage) chronological(events entities.Events) entities.Events {
+	result := make(entities.Events, len(events))
+	for index, event := range events {
+		event.Path = storage.absEventPath(event.Path)
+		if event.Type == entities.EventFileMove {
+			event.Previous = storage.absEventPath(event.Previous)
+		}
+
+		result[len(events)-index-1] = event
+	}
+
+	return result
+}
+
+// The path of the file as it is recorded in the events.
+func (storage *Storage) eventPath(path string) string {
+	relPath := storage.relPath(path)
+	if _path.IsUrl(relPath) {
+		return relPath
+	}
+
+	return filepath.Dir(relPath) + "/" + filepath.Base(relPath)
+}
+
+func (storage *Storage) absEventPath(path string) string {
+	if path == "" {
+		return path
+	}
+	if _path.IsUrl(path) {
+		return path
+	}
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+
+	return filepath.Join(storage.RootPath, path)
+}
@@ -59,8 +59,7 @@ func (storage *Storage) FileByPath(path string) (*entities.File, error) {
 
 // Retrieves all files that are under the specified directory.
 func (storage *Storage) FilesByDirectory(path string) (entities.Files, error) {
-    relPath := storage.relPath(path)
-    files, err := storage.Db.FilesByDirectory(relPath)
+    files, err := storage.filesByDirectory(path)
     storage.absPaths(files)
 
     return files, err
@@ -71,8 +70,7 @@ func (storage *Storage) FilesByDirectories(paths []string) (entities.Files, erro
 	files := make(entities.Files, 0, 100)
 
 	for _, path := range paths {
-        relPath := storage.relPath(path)
-		pathFiles, err := storage.Db.FilesByDirectory(relPath)
+		pathFiles, err := storage.filesByDirectory(path)
 		if err != nil {
 			return nil, fmt.Errorf("'%v': could not retrieve files for directory: %v", path, err)
 		}
@@ -104,16 +102,31 @@ func (storage *Storage) UntaggedFiles() (entities.Files, error) {
     return files, err
 }
 
+// Ranks the other files by the similarity of their visible, explicitly applied
+// tags to those of the specified file, most similar first.
+func (storage *Storage) SimilarFiles(fileId entities.FileId, limit uint) (entities.SimilarFiles, error) {
+	owner, err := storage.visibleOwner()
+	if err != nil {
+		return nil, err
+	}
+
+	similarFiles, err := storage.Db.SimilarFiles(fileId, owner, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, similarFile := range similarFiles {
+		storage.absPath(similarFile.File)
+	}
+
+	return similarFiles, nil
+}
+
 // Retrieves the count of files with the specified tags and matching the specified path.
 func (storage *Storage) FileCountWithTags(tagNames []string, path string, explicitOnly bool) (uint, error) {
-	expression := query.HasAll(tagNames)
-
-	if !explicitOnly {
-		var err error
-		expression, err = storage.addImpliedTags(expression)
-		if err != nil {
-			return 0, err
-		}
+	expression, err := storage.prepareExpression(query.HasAll(tagNames), explicitOnly)
+	if err != nil {
+		return 0, err
 	}
 
     relPath := storage.relPath(path)
@@ -122,14 +135,9 @@ func (storage *Storage) FileCountWithTags(tagNames []string, path string, explic
 
 // Retrieves the set of files with the specified tags and matching the specified path.
 func (storage *Storage) FilesWithTags(tagNames []string, path string, explicitOnly bool) (entities.Files, error) {
-	expression := query.HasAll(tagNames)
-
-	if !explicitOnly {
-		var err error
-		expression, err = storage.addImpliedTags(expression)
-		if err != nil {
-			return nil, err
-		}
+	expression, err := storage.prepareExpression(query.HasAll(tagNames), explicitOnly)
+	if err != nil {
+		return nil, err
 	}
 
     relPath := storage.relPath(path)
@@ -140,12 +148,9 @@ func (storage *Storage) FilesWithTags(tagNames []string, path string, explicitOn
 
 // Retrieves the count of files that match the specified query and matching the specified path.
 func (storage *Storage) QueryFileCount(expression query.Expression, path string, explicitOnly bool) (uint, error) {
-	if !explicitOnly {
-		var err error
-		expression, err = storage.addImpliedTags(expression)
-		if err != nil {
-			return 0, err
-		}
+	expression, err := storage.prepareExpression(expression, explicitOnly)
+	if err != nil {
+		return 0, err
 	}
 
     relPath := storage.relPath(path)
@@ -154,18 +159,119 @@ func (storage *Storage) QueryFileCount(expression query.Expression, path string,
 
 // Retrieves the set of files that match the specified query.
 func (storage *Storage) QueryFiles(expression query.Expression, path string, explicitOnly bool) (entities.Files, error) {
-	if !explicitOnly {
-		var err error
-		expression, err = storage.addImpliedTags(expression)
-		if err != nil {
-			return nil, err
+	var paths []string
+	if path != "" {
+		paths = []string{path}
+	}
+
+	return storage.QueryFilteredFiles(expression, paths, explicitOnly, entities.FileFilter{})
+}
+
+// Retrieves the set of files that match the specified query, are under any of
+// the specified paths (or anywhere, if there are none) and pass the filter.
+func (storage *Storage) QueryFilteredFiles(expression query.Expression, paths []string, explicitOnly bool, filter entities.FileFilter) (entities.Files, error) {
+	key := queryCacheKey(expression, paths, explicitOnly, filter)
+	revision, cached := storage.queryRevision()
+	cached = cached && queryCacheable(filter)
+	if cached {
+		if files, ok := storage.queries.files(key, revision); ok {
+			return files, nil
 		}
 	}
 
-    relPath := storage.relPath(path)
-    files, err := storage.Db.QueryFiles(expression, relPath)
-    storage.absPaths(files)
-    return files, err
+	expression, err := storage.prepareExpression(expression, explicitOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	relPaths := make([]string, len(paths))
+	for index, path := range paths {
+		relPaths[index] = storage.relPath(path)
+	}
+
+	files, err := storage.Db.QueryFilteredFiles(expression, relPaths, filter)
+	storage.absPaths(files)
+
+	if cached && err == nil {
+		storage.queries.addFiles(key, revision, files)
+	}
+
+	return files, err
+}
+
+// Calls the function with each file that matches the specified query, is under
+// any of the specified paths (or anywhere, if there are none) and passes the
+// filter, in path order. Unlike QueryFilteredFiles the files are not gathered
+// up front, so arbitrarily large results may be processed. Iteration stops at
+// the first error the function returns.
+func (storage *Storage) EachQueryFilteredFile(expression query.Expression, paths []string, explicitOnly bool, filter entities.FileFilter, each func(*entities.File) error) error {
+	key := queryCacheKey(expression, paths, explicitOnly, filter)
+	revision, cached := storage.queryRevision()
+	cached = cached && queryCacheable(filter)
+	if cached {
+		if files, ok := storage.queries.files(key, revision); ok {
+			for _, file := range files {
+				if err := each(file); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+	}
+
+	expression, err := storage.prepareExpression(expression, explicitOnly)
+	if err != nil {
+		return err
+	}
+
+	relPaths := make([]string, len(paths))
+	for index, path := range paths {
+		relPaths[index] = storage.relPath(path)
+	}
+
+	// gather the files for the cache unless there are too many
+	var files entities.Files
+	err = storage.Db.EachQueryFilteredFile(expression, relPaths, filter, func(file *entities.File) error {
+		storage.absPath(file)
+
+		if cached && len(files) <= queryCacheMaxFiles {
+			fileCopy := *file
+			files = append(files, &fileCopy)
+		}
+
+		return each(file)
+	})
+	if err != nil {
+		return err
+	}
+
+	if cached {
+		storage.queries.addFiles(key, revision, files)
+	}
+
+	return nil
+}
+
+// Retrieves the plan for the query that QueryFilteredFiles runs, together with
+// the expression it runs once the tag owners and implications are applied.
+func (storage *Storage) ExplainQuery(expression query.Expression, paths []string, explicitOnly bool, filter entities.FileFilter) (query.Expression, *entities.QueryPlan, error) {
+	expression, err := storage.prepareExpression(expression, explicitOnly)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	relPaths := make([]string, len(paths))
+	for index, path := range paths {
+		relPaths[index] = storage.relPath(path)
+	}
+
+	plan, err := storage.Db.ExplainQueryFiles(expression, relPaths, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return expression, plan, nil
 }
 
 // Retrieves the sets of duplicate files within the database.
@@ -179,22 +285,66 @@ func (storage *Storage) DuplicateFiles() ([]entities.Files, error) {
     return fileSets, err
 }
 
-// Adds a file to the database.
+// Adds a file to the database, along with its modification time tags.
 func (storage *Storage) AddFile(path string, fingerprint fingerprint.Fingerprint, modTime time.Time, size int64, isDir bool) (*entities.File, error) {
     relPath := storage.relPath(path)
     file, err := storage.Db.InsertFile(relPath, fingerprint, modTime, size, isDir)
+    if err != nil {
+        return nil, err
+    }
+
     storage.absPath(file)
 
-    return file, err
+    if err := storage.UpdateModTimeTags(file); err != nil {
+        return nil, err
+    }
+
+    return file, nil
 }
 
-// Updates a file in the database.
+// Adds a batch of files, inserting many at once. Returns the files with their
+// identifiers.
+func (storage *Storage) AddFiles(files entities.Files) (entities.Files, error) {
+    relFiles := make(entities.Files, len(files))
+    for index, file := range files {
+        relPath := storage.relPath(file.Path())
+
+        relFile := *file
+        relFile.Directory, relFile.Name = _path.Split(relPath)
+        relFiles[index] = &relFile
+    }
+
+    addedFiles, err := storage.Db.InsertFiles(relFiles)
+    if err != nil {
+        return nil, err
+    }
+
+    storage.absPaths(addedFiles)
+
+    for _, file := range addedFiles {
+        if err := storage.UpdateModTimeTags(file); err != nil {
+            return nil, err
+        }
+    }
+
+    return addedFiles, nil
+}
+
+// Updates a file in the database, along with its modification time tags.
 func (storage *Storage) UpdateFile(fileId entities.FileId, path string, fingerprint fingerprint.Fingerprint, modTime time.Time, size int64, isDir bool) (*entities.File, error) {
     relPath := storage.relPath(path)
     file, err := storage.Db.UpdateFile(fileId, relPath, fingerprint, modTime, size, isDir)
+    if err != nil {
+        return nil, err
+    }
+
     storage.absPath(file)
 
-    return file, err
+    if err := storage.UpdateModTimeTags(file); err != nil {
+        return nil, err
+    }
+
+    return file, nil
 }
 
 // Deletes a file from the database.
@@ -202,28 +352,88 @@ func (storage *Storage) DeleteFile(fileId entities.FileId) error {
 	return storage.Db.DeleteFile(fileId)
 }
 
-// Deletes a file if it is untagged
+// Deletes a file if it is untagged. A file with only modification time tags
+// counts as untagged and these are removed too.
 func (storage *Storage) DeleteFileIfUntagged(fileId entities.FileId) error {
-	count, err := storage.FileTagCountByFileId(fileId, true)
+	fileTags, err := storage.Db.FileTagsByFileId(fileId)
 	if err != nil {
 		return err
 	}
-	if count == 0 {
-		if err := storage.DeleteFile(fileId); err != nil {
+
+	if len(fileTags) > 0 {
+		modTimeTagIds, err := storage.modTimeTagIds()
+		if err != nil {
+			return err
+		}
+
+		for _, fileTag := range fileTags {
+			if !containsTagId(modTimeTagIds, fileTag.TagId) {
+				return nil
+			}
+		}
+
+		if err := storage.Db.DeleteFileTagsByFileId(fileId); err != nil {
+			return err
+		}
+
+		if err := storage.DeleteUnusedValues(fileTags.ValueIds()); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return storage.DeleteFile(fileId)
 }
 
 // Deletes the specified files if they are untagged
 func (storage *Storage) DeleteUntaggedFiles(fileIds entities.FileIds) error {
-	return storage.Db.DeleteUntaggedFiles(fileIds)
+	modTimeTagIds, err := storage.modTimeTagIds()
+	if err != nil {
+		return err
+	}
+
+	if len(modTimeTagIds) == 0 {
+		return storage.Db.DeleteUntaggedFiles(fileIds)
+	}
+
+	for _, fileId := range fileIds {
+		file, err := storage.Db.File(fileId)
+		if err != nil {
+			return err
+		}
+		if file == nil {
+			continue
+		}
+
+		if err := storage.DeleteFileIfUntagged(fileId); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // unexported
 
+// Retrieves the files under the directory, without making their paths
+// absolute. The files under the root path are stored relative to it, except
+// where the root path is '/', so both forms are retrieved for the root path.
+func (storage *Storage) filesByDirectory(path string) (entities.Files, error) {
+	relPath := storage.relPath(path)
+
+	files, err := storage.Db.FilesByDirectory(relPath)
+	if err != nil || relPath != "." {
+		return files, err
+	}
+
+	absFiles, err := storage.Db.FilesByDirectory(storage.RootPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// the relative paths, beginning '.', sort before the absolute
+	return append(files, absFiles...), nil
+}
+
 func (storage *Storage) relPath(path string) string {
     return _path.RelTo(path, storage.RootPath)
 }
@@ -235,80 +445,237 @@ func (storage *Storage) absPaths(files entities.Files) {
 }
 
 func (storage *Storage) absPath(file *entities.File) {
-    if file == nil || file.Directory == "" || file.Directory[0] == filepath.Separator {
+    if file == nil || file.Directory == "" || filepath.IsAbs(file.Directory) || _path.IsUrl(file.Directory) {
         return
     }
 
     file.Directory = filepath.Join(storage.RootPath, file.Directory)
 }
 
-func (storage *Storage) addImpliedTags(expression query.Expression) (query.Expression, error) {
-	implications, err := storage.Implications()
+// Marks the tags within the expression as inherited, so that the items within
+// a tagged directory also match, if the 'inheritDirectoryTags' setting is
+// enabled. Tags that must be applied explicitly are unmarked again when the
+// implied tags are added.
+func (storage *Storage) applyTagInheritance(expression query.Expression) (query.Expression, error) {
+	inherit, err := storage.SettingAsBool("inheritDirectoryTags")
 	if err != nil {
-		fmt.Errorf("could not retrieve tag implications: %v", err)
+		return nil, err
+	}
+	if !inherit {
+		return expression, nil
 	}
 
-	impliersByTag := make(map[string][]string, len(implications))
-	for _, implication := range implications {
-		impliers, ok := impliersByTag[implication.ImpliedTag.Name]
-		if !ok {
-			impliers = make([]string, 0, 1)
+	return applyTagInheritanceRecursive(expression), nil
+}
+
+func applyTagInheritanceRecursive(expression query.Expression) query.Expression {
+	switch typedExpression := expression.(type) {
+	case query.OrExpression:
+		typedExpression.LeftOperand = applyTagInheritanceRecursive(typedExpression.LeftOperand)
+		typedExpression.RightOperand = applyTagInheritanceRecursive(typedExpression.RightOperand)
+		return typedExpression
+	case query.AndExpression:
+		typedExpression.LeftOperand = applyTagInheritanceRecursive(typedExpression.LeftOperand)
+		typedExpression.RightOperand = applyTagInheritanceRecursive(typedExpression.RightOperand)
+		return typedExpression
+	case query.NotExpression:
+		typedExpression.Operand = applyTagInheritanceRecursive(typedExpression.Operand)
+		return typedExpression
+	case query.TagExpression:
+		typedExpression.Inherited = true
+		return typedExpression
+	case query.ComparisonExpression:
+		typedExpression.Tag.Inherited = true
+		return typedExpression
+	case query.ValueExpression, query.PathExpression, query.EmptyExpression:
+		return expression
+	default:
+		panic(fmt.Sprintf("unsupported expression type '%T'.", typedExpression))
+	}
+}
+
+// Replaces the paths of the 'under:' terms within the expression.
+func mapQueryPaths(expression query.Expression, mapPath func(string) (string, error)) (query.Expression, error) {
+	var err error
+
+	switch typedExpression := expression.(type) {
+	case query.OrExpression:
+		if typedExpression.LeftOperand, err = mapQueryPaths(typedExpression.LeftOperand, mapPath); err != nil {
+			return nil, err
 		}
+		if typedExpression.RightOperand, err = mapQueryPaths(typedExpression.RightOperand, mapPath); err != nil {
+			return nil, err
+		}
+		return typedExpression, nil
+	case query.AndExpression:
+		if typedExpression.LeftOperand, err = mapQueryPaths(typedExpression.LeftOperand, mapPath); err != nil {
+			return nil, err
+		}
+		if typedExpression.RightOperand, err = mapQueryPaths(typedExpression.RightOperand, mapPath); err != nil {
+			return nil, err
+		}
+		return typedExpression, nil
+	case query.NotExpression:
+		if typedExpression.Operand, err = mapQueryPaths(typedExpression.Operand, mapPath); err != nil {
+			return nil, err
+		}
+		return typedExpression, nil
+	case query.PathExpression:
+		if typedExpression.Path, err = mapPath(typedExpression.Path); err != nil {
+			return nil, err
+		}
+		return typedExpression, nil
+	case query.TagExpression, query.ComparisonExpression, query.ValueExpression, query.EmptyExpression:
+		return expression, nil
+	default:
+		panic(fmt.Sprintf("unsupported expression type '%T'.", typedExpression))
+	}
+}
 
-		impliersByTag[implication.ImpliedTag.Name] = append(impliers, implication.ImplyingTag.Name)
+// The path of an 'under:' term as stored in the database.
+func (storage *Storage) queryPath(path string) (string, error) {
+	absPath, err := _path.Abs(path)
+	if err != nil {
+		return "", AbsolutePathResolutionError{path, err}
+	}
+
+	return storage.relPath(absPath), nil
+}
+
+func (storage *Storage) addImpliedTags(expression query.Expression, explicitOnly bool) (query.Expression, error) {
+	implications, err := storage.Implications()
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve tag implications: %v", err)
+	}
+
+	impliersByTag := make(map[string]entities.Implications, len(implications))
+	for _, implication := range implications {
+		impliersByTag[implication.ImpliedTag.Name] = append(impliersByTag[implication.ImpliedTag.Name], implication)
 	}
 
-	return addImpliedTagsRecursive(expression, impliersByTag), nil
+	return addImpliedTagsRecursive(expression, impliersByTag, explicitOnly), nil
 }
 
-func addImpliedTagsRecursive(expression query.Expression, impliersByTag map[string][]string) query.Expression {
+func addImpliedTagsRecursive(expression query.Expression, impliersByTag map[string]entities.Implications, explicitOnly bool) query.Expression {
 	switch typedExpression := expression.(type) {
 	case query.OrExpression:
-		typedExpression.LeftOperand = addImpliedTagsRecursive(typedExpression.LeftOperand, impliersByTag)
-		typedExpression.RightOperand = addImpliedTagsRecursive(typedExpression.RightOperand, impliersByTag)
+		typedExpression.LeftOperand = addImpliedTagsRecursive(typedExpression.LeftOperand, impliersByTag, explicitOnly)
+		typedExpression.RightOperand = addImpliedTagsRecursive(typedExpression.RightOperand, impliersByTag, explicitOnly)
 		return typedExpression
 	case query.AndExpression:
-		typedExpression.LeftOperand = addImpliedTagsRecursive(typedExpression.LeftOperand, impliersByTag)
-		typedExpression.RightOperand = addImpliedTagsRecursive(typedExpression.RightOperand, impliersByTag)
+		typedExpression.LeftOperand = addImpliedTagsRecursive(typedExpression.LeftOperand, impliersByTag, explicitOnly)
+		typedExpression.RightOperand = addImpliedTagsRecursive(typedExpression.RightOperand, impliersByTag, explicitOnly)
 		return typedExpression
 	case query.NotExpression:
-		typedExpression.Operand = addImpliedTagsRecursive(typedExpression.Operand, impliersByTag)
+		typedExpression.Operand = addImpliedTagsRecursive(typedExpression.Operand, impliersByTag, explicitOnly)
 		return typedExpression
 	case query.TagExpression:
-		return applyImplicationsForTag(typedExpression, impliersByTag)
-	case query.ValueExpression, query.EmptyExpression, query.ComparisonExpression:
+		return applyImplicationsForTag(typedExpression, impliersByTag, explicitOnly)
+	case query.ComparisonExpression:
+		return applyImplicationsForComparison(typedExpression, explicitOnly)
+	case query.ValueExpression, query.PathExpression, query.EmptyExpression:
 		return expression
 	default:
 		panic(fmt.Sprintf("unsupported expression type '%T'.", typedExpression))
 	}
 }
 
-func applyImplicationsForTag(tagExpression query.TagExpression, impliersByTag map[string][]string) query.Expression {
-	implyingTags, ok := impliersByTag[tagExpression.Name]
+func applyImplicationsForComparison(comparison query.ComparisonExpression, explicitOnly bool) query.Expression {
+	inherited := comparison.Tag.Inherited
+	comparison.Tag.Inherited = false
+
+	switch {
+	case comparison.Tag.Provenance == query.ExplicitProvenance, comparison.Tag.Provenance == query.AnyProvenance && explicitOnly:
+		return comparison
+	case comparison.Tag.Provenance == query.ImpliedProvenance:
+		// implications do not carry values but directories pass them on
+		if !inherited {
+			return matchNothing
+		}
+
+		inheritedComparison := comparison
+		inheritedComparison.Tag.Inherited = true
+
+		return query.AndExpression{inheritedComparison, query.NotExpression{comparison}}
+	}
+
+	comparison.Tag.Inherited = inherited
+	return comparison
+}
+
+// An expression that no file matches.
+var matchNothing = query.NotExpression{query.EmptyExpression{}}
+
+func applyImplicationsForTag(tagExpression query.TagExpression, impliersByTag map[string]entities.Implications, explicitOnly bool) query.Expression {
+	provenance := tagExpression.Provenance
+	tagExpression.Provenance = query.AnyProvenance
+
+	// only the items the tag is applied to directly have it explicitly
+	explicitTagExpression := tagExpression
+	explicitTagExpression.Inherited = false
+
+	if provenance == query.ExplicitProvenance || provenance == query.AnyProvenance && explicitOnly {
+		return explicitTagExpression
+	}
+
+	impliers, ok := impliersByTag[tagExpression.Name]
 	if !ok {
+		if provenance == query.ImpliedProvenance {
+			if !tagExpression.Inherited {
+				return matchNothing
+			}
+
+			return query.AndExpression{tagExpression, query.NotExpression{explicitTagExpression}}
+		}
+
 		return tagExpression
 	}
 
-	var expression query.Expression = tagExpression
+	var expression query.Expression
+	if provenance != query.ImpliedProvenance || tagExpression.Inherited {
+		expression = tagExpression
+	}
+
+	implications := append(entities.Implications{}, impliers...)
+	for index := 0; index < len(implications); index++ {
+		implication := implications[index]
+
+		implyingTagExpression := query.TagExpression{Name: implication.ImplyingTag.Name, Owner: tagExpression.Owner, Shared: tagExpression.Shared, Inherited: tagExpression.Inherited}
 
-	for index := 0; index < len(implyingTags); index++ {
-		implyingTag := implyingTags[index]
+		var implyingExpression query.Expression = implyingTagExpression
+		if implication.ImplyingValue.Id != 0 {
+			implyingExpression = query.ComparisonExpression{implyingTagExpression, "==", query.ValueExpression{implication.ImplyingValue.Name}}
+		}
+
+		if expression == nil {
+			expression = implyingExpression
+		} else {
+			expression = query.OrExpression{expression, implyingExpression}
+		}
 
-		expression = query.OrExpression{expression, query.TagExpression{implyingTag}}
+		// an implied tag has no value so cannot satisfy a value implication
+		if implication.ImplyingValue.Id != 0 {
+			continue
+		}
 
-		for _, furtherImplyingTag := range impliersByTag[implyingTag] {
-			if furtherImplyingTag != tagExpression.Name && !containsTagName(implyingTags, furtherImplyingTag) {
-				implyingTags = append(implyingTags, furtherImplyingTag)
+		for _, furtherImplication := range impliersByTag[implication.ImplyingTag.Name] {
+			if furtherImplication.ImplyingTag.Name != tagExpression.Name && !containsImplier(implications, furtherImplication) {
+				implications = append(implications, furtherImplication)
 			}
 		}
 	}
 
+	if provenance == query.ImpliedProvenance {
+		expression = query.AndExpression{expression, query.NotExpression{explicitTagExpression}}
+	}
+
 	return expression
 }
 
-func containsTagName(tagNames []string, tagName string) bool {
-	for _, tn := range tagNames {
-		if tn == tagName {
+// Whether any of the implications has the same implying tag and value.
+func containsImplier(implications entities.Implications, implication *entities.Implication) bool {
+	for _, other := range implications {
+		if other.ImplyingTag.Id == implication.ImplyingTag.Id && other.ImplyingValue.Id == implication.ImplyingValue.Id {
 			return true
 		}
 	}
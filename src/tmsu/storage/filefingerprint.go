@@ -0,0 +1,134 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"tmsu/common/fingerprint"
+	"tmsu/entities"
+)
+
+// The additional fingerprint algorithms named by the
+// 'extraFingerprintAlgorithms' setting.
+func (storage *Storage) ExtraFingerprintAlgorithms() ([]string, error) {
+	algorithms, err := storage.SettingAsString("extraFingerprintAlgorithms")
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(algorithms), nil
+}
+
+// Retrieves the additional fingerprints of the specified file by algorithm.
+func (storage *Storage) FileFingerprints(fileId entities.FileId) (map[string]fingerprint.Fingerprint, error) {
+	return storage.Db.FileFingerprints(fileId)
+}
+
+// Records the fingerprint of the specified file under the specified additional
+// algorithm.
+func (storage *Storage) UpdateFileFingerprint(fileId entities.FileId, algorithm string, fingerprint fingerprint.Fingerprint) error {
+	return storage.Db.UpdateFileFingerprint(fileId, algorithm, fingerprint)
+}
+
+// Calculates and records the fingerprints of the file under each of the
+// additional fingerprint algorithms.
+func (storage *Storage) UpdateExtraFingerprints(file *entities.File) error {
+	if file.IsDir {
+		return nil
+	}
+
+	algorithms, err := storage.ExtraFingerprintAlgorithms()
+	if err != nil {
+		return err
+	}
+
+	for _, algorithm := range algorithms {
+		fp, err := fingerprint.Create(file.Path(), algorithm)
+		if err != nil {
+			return fmt.Errorf("%v: could not create '%v' fingerprint: %v", file.Path(), algorithm, err)
+		}
+
+		if err := storage.Db.UpdateFileFingerprint(file.Id, algorithm, fp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Retrieves the sets of duplicate files according to their fingerprints under
+// the specified algorithm: either the primary algorithm, which is assumed if
+// none is specified, or one of the additional algorithms.
+func (storage *Storage) DuplicateFilesUsing(algorithm string) ([]entities.Files, error) {
+	primary, err := storage.isPrimaryAlgorithm(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if primary {
+		return storage.DuplicateFiles()
+	}
+
+	fileSets, err := storage.Db.DuplicateFilesByAlgorithm(algorithm)
+	for _, fileSet := range fileSets {
+		storage.absPaths(fileSet)
+	}
+
+	return fileSets, err
+}
+
+// Retrieves the set of files with the specified fingerprint under the
+// specified algorithm: either the primary algorithm, which is assumed if none
+// is specified, or one of the additional algorithms.
+func (storage *Storage) FilesByFingerprintUsing(algorithm string, fp fingerprint.Fingerprint) (entities.Files, error) {
+	primary, err := storage.isPrimaryAlgorithm(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if primary {
+		return storage.FilesByFingerprint(fp)
+	}
+
+	files, err := storage.Db.FilesByAlgorithmFingerprint(algorithm, fp)
+	storage.absPaths(files)
+	return files, err
+}
+
+// unexported
+
+func (storage *Storage) isPrimaryAlgorithm(algorithm string) (bool, error) {
+	primary, err := storage.SettingAsString("fingerprintAlgorithm")
+	if err != nil {
+		return false, err
+	}
+	if algorithm == "" || algorithm == primary {
+		return true, nil
+	}
+
+	extras, err := storage.ExtraFingerprintAlgorithms()
+	if err != nil {
+		return false, err
+	}
+	for _, extra := range extras {
+		if extra == algorithm {
+			return false, nil
+		}
+	}
+
+	return false, fmt.Errorf("fingerprints are not recorded under '%v': add it to the 'extraFingerprintAlgorithms' setting", algorithm)
+}
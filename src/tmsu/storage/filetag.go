@@ -40,9 +40,14 @@ func (storage *Storage) FileTagCount() (uint, error) {
 	return storage.Db.FileTagCount()
 }
 
-// Retrieves the complete set of file tags.
+// Retrieves the complete set of visible file tags.
 func (storage *Storage) FileTags() (entities.FileTags, error) {
-	return storage.Db.FileTags()
+	fileTags, err := storage.Db.FileTags()
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.visibleFileTags(fileTags)
 }
 
 // Retrieves the count of file tags for the specified file.
@@ -59,6 +64,11 @@ func (storage *Storage) FileTagCountByFileId(fileId entities.FileId, explicitOnl
 	return uint(len(fileTags)), err
 }
 
+// Retrieves the number of files the specified tag is explicitly applied to.
+func (storage *Storage) FileCountByTagId(tagId entities.TagId) (uint, error) {
+	return storage.Db.FileCountByTagId(tagId)
+}
+
 // Retrieves the count of file tags for the specified tag.
 func (storage *Storage) FileTagCountByTagId(tagId entities.TagId, explicitOnly bool) (uint, error) {
 	if explicitOnly {
@@ -80,6 +90,11 @@ func (storage *Storage) FileTagsByTagId(tagId entities.TagId, explicitOnly bool)
 		return nil, err
 	}
 
+	fileTags, err = storage.visibleFileTags(fileTags)
+	if err != nil {
+		return nil, err
+	}
+
 	if !explicitOnly {
 		var err error
 		fileTags, err = storage.addImpliedFileTags(fileTags)
@@ -98,7 +113,12 @@ func (storage *Storage) FileTagCountByValueId(valueId entities.ValueId) (uint, e
 
 // Retrieves the file tags with the specified value ID.
 func (storage *Storage) FileTagsByValueId(valueId entities.ValueId) (entities.FileTags, error) {
-	return storage.Db.FileTagsByValueId(valueId)
+	fileTags, err := storage.Db.FileTagsByValueId(valueId)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.visibleFileTags(fileTags)
 }
 
 // Retrieves the file tags with the specified file ID.
@@ -108,6 +128,11 @@ func (storage *Storage) FileTagsByFileId(fileId entities.FileId, explicitOnly bo
 		return nil, err
 	}
 
+	fileTags, err = storage.visibleFileTags(fileTags)
+	if err != nil {
+		return nil, err
+	}
+
 	if !explicitOnly {
 		var err error
 		fileTags, err = storage.addImpliedFileTags(fileTags)
@@ -119,9 +144,48 @@ func (storage *Storage) FileTagsByFileId(fileId entities.FileId, explicitOnly bo
 	return fileTags, nil
 }
 
-// Adds a file tag.
+// Adds a file tag, owned by the current user if the 'recordTagOwner' setting
+// is enabled.
 func (storage *Storage) AddFileTag(fileId entities.FileId, tagId entities.TagId, valueId entities.ValueId) (*entities.FileTag, error) {
-	return storage.Db.AddFileTag(fileId, tagId, valueId)
+	if err := storage.CheckTagQuotas(fileId, entities.TagIds{tagId}); err != nil {
+		return nil, err
+	}
+
+	owner, err := storage.taggingOwner()
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.Db.AddFileTag(fileId, tagId, valueId, owner, storage.Command)
+}
+
+// Applies a batch of tags (and values) to a file, inserting them at once.
+func (storage *Storage) AddFileTags(fileId entities.FileId, pairs []entities.TagValuePair) (entities.FileTags, error) {
+	if len(pairs) == 0 {
+		return entities.FileTags{}, nil
+	}
+
+	tagIds := make(entities.TagIds, len(pairs))
+	for index, pair := range pairs {
+		tagIds[index] = pair.TagId
+	}
+
+	if err := storage.CheckTagQuotas(fileId, tagIds); err != nil {
+		return nil, err
+	}
+
+	owner, err := storage.taggingOwner()
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.Db.AddFileTags(fileId, pairs, owner, storage.Command)
+}
+
+// Retrieves when, and by which subcommand, each of the file's tags was
+// applied, oldest first.
+func (storage *Storage) FileTagApplications(fileId entities.FileId) (entities.FileTagApplications, error) {
+	return storage.Db.FileTagApplications(fileId)
 }
 
 // Delete file tag.
@@ -134,7 +198,22 @@ func (storage *Storage) DeleteFileTag(fileId entities.FileId, tagId entities.Tag
 		return FileTagDoesNotExist{fileId, tagId, valueId}
 	}
 
-	if err := storage.Db.DeleteFileTag(fileId, tagId, valueId); err != nil {
+	if err := storage.CheckTagsUnlocked(entities.TagIds{tagId}); err != nil {
+		return err
+	}
+
+	owner, err := storage.taggingOwner()
+	if err != nil {
+		return err
+	}
+
+	if owner != "" {
+		// leave the other users' file tags alone
+		err = storage.Db.DeleteOwnedFileTag(fileId, tagId, valueId, owner)
+	} else {
+		err = storage.Db.DeleteFileTag(fileId, tagId, valueId)
+	}
+	if err != nil {
 		return err
 	}
 
@@ -149,6 +228,56 @@ func (storage *Storage) DeleteFileTag(fileId entities.FileId, tagId entities.Tag
 	return nil
 }
 
+// Deletes a file tag along with the explicit applications of the tags it
+// implies, except where those tags are still implied by the file's remaining
+// tags. The file tags removed by way of the implications are returned. Either
+// all are removed or, upon error, none.
+func (storage *Storage) DeleteFileTagWithImplied(fileId entities.FileId, tagId entities.TagId, valueId entities.ValueId) (entities.FileTags, error) {
+	closure, err := storage.addImpliedFileTags(entities.FileTags{&entities.FileTag{FileId: fileId, TagId: tagId, ValueId: valueId, Explicit: true}})
+	if err != nil {
+		return nil, err
+	}
+	implied := closure[1:]
+
+	removed := make(entities.FileTags, 0, len(implied))
+	err = storage.atomically("delete_file_tag_with_implied", func() error {
+		if err := storage.DeleteFileTag(fileId, tagId, valueId); err != nil {
+			return err
+		}
+
+		// removing a tag may leave the tags it implies unimplied in turn
+		for changed := true; changed; {
+			changed = false
+
+			fileTags, err := storage.FileTagsByFileId(fileId, false)
+			if err != nil {
+				return err
+			}
+
+			for _, impliedFileTag := range implied {
+				fileTag := fileTags.Find(fileId, impliedFileTag.TagId, impliedFileTag.ValueId)
+				if fileTag == nil || !fileTag.Explicit || fileTag.Implicit {
+					continue
+				}
+
+				if err := storage.DeleteFileTag(fileId, fileTag.TagId, fileTag.ValueId); err != nil {
+					return err
+				}
+
+				removed = append(removed, fileTag)
+				changed = true
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return removed, nil
+}
+
 // Deletes all of the file tags for the specified file.
 func (storage *Storage) DeleteFileTagsByFileId(fileId entities.FileId) error {
 	fileTags, err := storage.Db.FileTagsByFileId(fileId)
@@ -156,6 +285,10 @@ func (storage *Storage) DeleteFileTagsByFileId(fileId entities.FileId) error {
 		return err
 	}
 
+	if err := storage.CheckTagsUnlocked(fileTags.TagIds()); err != nil {
+		return err
+	}
+
 	if err := storage.Db.DeleteFileTagsByFileId(fileId); err != nil {
 		return err
 	}
@@ -173,6 +306,10 @@ func (storage *Storage) DeleteFileTagsByFileId(fileId entities.FileId) error {
 
 // Deletes all of the file tags for the specified tag.
 func (storage *Storage) DeleteFileTagsByTagId(tagId entities.TagId) error {
+	if err := storage.CheckTagsUnlocked(entities.TagIds{tagId}); err != nil {
+		return err
+	}
+
 	fileTags, err := storage.Db.FileTagsByTagId(tagId)
 	if err != nil {
 		return err
@@ -193,9 +330,10 @@ func (storage *Storage) DeleteFileTagsByTagId(tagId entities.TagId) error {
 	return nil
 }
 
-// Copies file tags from one tag to another.
-func (storage *Storage) CopyFileTags(sourceTagId, destTagId entities.TagId) error {
-	return storage.Db.CopyFileTags(sourceTagId, destTagId)
+// Copies file tags from one tag to another. If values are specified then only
+// the file tags with those values are copied.
+func (storage *Storage) CopyFileTags(sourceTagId, destTagId entities.TagId, valueIds entities.ValueIds) error {
+	return storage.Db.CopyFileTags(sourceTagId, destTagId, valueIds)
 }
 
 // unexported
@@ -215,13 +353,13 @@ func (storage *Storage) addImpliedFileTags(fileTags entities.FileTags) (entities
 		fileTag := fileTags[index]
 
 		for _, implication := range implications {
-			if implication.ImplyingTag.Id == fileTag.TagId {
+			if implication.AppliesTo(fileTag.TagId, fileTag.ValueId) {
 				//TODO consider values in implied tags
 				impliedFileTag := fileTags.Find(fileTag.FileId, implication.ImpliedTag.Id, 0)
 				if impliedFileTag != nil {
 					impliedFileTag.Implicit = true
 				} else {
-					impliedFileTag := entities.FileTag{fileTag.FileId, implication.ImpliedTag.Id, 0, false, true}
+					impliedFileTag := entities.FileTag{fileTag.FileId, implication.ImpliedTag.Id, 0, fileTag.Owner, false, true}
 					fileTags = append(fileTags, &impliedFileTag)
 				}
 			}
@@ -230,3 +368,8 @@ func (storage *Storage) addImpliedFileTags(fileTags entities.FileTags) (entities
 
 	return fileTags, nil
 }
+
+// Copies the file tags of one file to another.
+func (storage *Storage) CopyFileTagsByFileId(sourceFileId, destFileId entities.FileId) error {
+	return storage.Db.CopyFileTagsByFileId(sourceFileId, destFileId)
+}
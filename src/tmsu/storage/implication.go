@@ -51,9 +51,10 @@ func (storage *Storage) ImplicationsForTags(tagIds ...entities.TagId) (entities.
 	return resultantImplications, nil
 }
 
-// Adds the specified implication.
-func (storage Storage) AddImplication(tagId, impliedTagId entities.TagId) error {
-	return storage.Db.AddImplication(tagId, impliedTagId)
+// Adds the specified implication. A value of zero makes the implication hold
+// whatever the value of the implying tag.
+func (storage Storage) AddImplication(tagId entities.TagId, valueId entities.ValueId, impliedTagId entities.TagId) error {
+	return storage.Db.AddImplication(tagId, valueId, impliedTagId)
 }
 
 // Updates implications featuring the specified tag.
@@ -62,8 +63,8 @@ func (storage Storage) UpdateImplicationsForTagId(tagId, impliedTagId entities.T
 }
 
 // Removes the specified implication
-func (storage Storage) RemoveImplication(tagId, impliedTagId entities.TagId) error {
-	return storage.Db.DeleteImplication(tagId, impliedTagId)
+func (storage Storage) RemoveImplication(tagId entities.TagId, valueId entities.ValueId, impliedTagId entities.TagId) error {
+	return storage.Db.DeleteImplication(tagId, valueId, impliedTagId)
 }
 
 // Removes implications featuring the specified tag.
@@ -75,7 +76,9 @@ func (storage Storage) RemoveImplicationsForTagId(tagId entities.TagId) error {
 
 func containsImplication(implications entities.Implications, implication *entities.Implication) bool {
 	for index := 0; index < len(implications); index++ {
-		if implications[index].ImplyingTag.Id == implication.ImplyingTag.Id && implications[index].ImpliedTag.Id == implication.ImpliedTag.Id {
+		if implications[index].ImplyingTag.Id == implication.ImplyingTag.Id &&
+			implications[index].ImplyingValue.Id == implication.ImplyingValue.Id &&
+			implications[index].ImpliedTag.Id == implication.ImpliedTag.Id {
 			return true
 		}
 	}
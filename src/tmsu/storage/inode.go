@@ -0,0 +1,53 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"tmsu/entities"
+)
+
+// Retrieves the device and inode numbers recorded for the file: both zero if
+// they are not known.
+func (storage *Storage) FileInode(fileId entities.FileId) (uint64, uint64, error) {
+	return storage.Db.FileInode(fileId)
+}
+
+// Records the device and inode numbers of the file.
+func (storage *Storage) UpdateFileInode(fileId entities.FileId, dev, inode uint64) error {
+	return storage.Db.UpdateFileInode(fileId, dev, inode)
+}
+
+// Retrieves the other files in the database that are hard links to the same
+// file as that specified.
+func (storage *Storage) HardLinkedFiles(file *entities.File) (entities.Files, error) {
+	dev, inode, err := storage.Db.FileInode(file.Id)
+	if err != nil {
+		return nil, err
+	}
+	if dev == 0 && inode == 0 {
+		return entities.Files{}, nil
+	}
+
+	files, err := storage.Db.FilesByInode(dev, inode)
+	if err != nil {
+		return nil, err
+	}
+	storage.absPaths(files)
+
+	return files.Where(func(linked *entities.File) bool { return linked.Id != file.Id }), nil
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"path/filepath"
+	"sort"
+	"tmsu/entities"
+)
+
+// Retrieves the file tags that refer to a missing file, tag or value.
+func (storage *Storage) OrphanedFileTags() (entities.FileTags, error) {
+	return storage.Db.OrphanedFileTags()
+}
+
+// Deletes the file tags that refer to a missing file, tag or value.
+func (storage *Storage) DeleteOrphanedFileTags() error {
+	return storage.Db.DeleteOrphanedFileTags()
+}
+
+// Retrieves the implications that refer to a missing tag.
+func (storage *Storage) DanglingImplications() (entities.Implications, error) {
+	return storage.Db.DanglingImplications()
+}
+
+// Deletes the implications that refer to a missing tag.
+func (storage *Storage) DeleteDanglingImplications() error {
+	return storage.Db.DeleteDanglingImplications()
+}
+
+// Retrieves the files, other than directories, that have no fingerprint.
+func (storage *Storage) FilesWithoutFingerprint() (entities.Files, error) {
+	files, err := storage.Db.FilesWithoutFingerprint()
+	storage.absPaths(files)
+
+	return files, err
+}
+
+// Retrieves the sets of files that resolve to the same path, e.g. where one
+// entry is stored relative to the root path and another absolutely.
+func (storage *Storage) FilesWithDuplicatePaths() ([]entities.Files, error) {
+	files, err := storage.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	filesByPath := make(map[string]entities.Files, len(files))
+	for _, file := range files {
+		path := filepath.Clean(file.Path())
+		filesByPath[path] = append(filesByPath[path], file)
+	}
+
+	paths := make([]string, 0, 10)
+	for path, pathFiles := range filesByPath {
+		if len(pathFiles) > 1 {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	duplicates := make([]entities.Files, len(paths))
+	for index, path := range paths {
+		duplicates[index] = filesByPath[path]
+	}
+
+	return duplicates, nil
+}
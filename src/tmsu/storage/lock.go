@@ -0,0 +1,63 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"strings"
+	"tmsu/entities"
+)
+
+// The names of the tags that are protected against untagging, merging and
+// deletion by the 'lockedTags' setting.
+func (storage *Storage) LockedTagNames() ([]string, error) {
+	value, err := storage.SettingAsString("lockedTags")
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(value), nil
+}
+
+// Returns a TagLockedError for the first of the specified tags that is locked
+// unless Force is set.
+func (storage *Storage) CheckTagsUnlocked(tagIds entities.TagIds) error {
+	if storage.Force || len(tagIds) == 0 {
+		return nil
+	}
+
+	lockedNames, err := storage.LockedTagNames()
+	if err != nil {
+		return err
+	}
+	if len(lockedNames) == 0 {
+		return nil
+	}
+
+	tags, err := storage.Db.TagsByIds(tagIds.Uniq())
+	if err != nil {
+		return err
+	}
+
+	for _, name := range lockedNames {
+		if tags.ContainsName(name) {
+			return TagLockedError{name}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"strings"
+	"tmsu/query"
+)
+
+// The prefix of the settings that define query macros, e.g. 'macro.recent'.
+const MacroSettingPrefix = "macro."
+
+// The query macros, defined by settings of the form 'macro.NAME', keyed by
+// NAME.
+func (storage *Storage) QueryMacros() (map[string]string, error) {
+	settings, err := storage.Settings()
+	if err != nil {
+		return nil, err
+	}
+
+	macros := make(map[string]string, 10)
+	for _, setting := range settings {
+		if strings.HasPrefix(setting.Name, MacroSettingPrefix) {
+			macros[setting.Name[len(MacroSettingPrefix):]] = setting.Value
+		}
+	}
+
+	return macros, nil
+}
+
+// Parses the query text, expanding the macros it references.
+func (storage *Storage) ParseQuery(queryText string) (query.Expression, error) {
+	expression, err := query.Parse(queryText)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.Contains(queryText, query.MacroPrefix) {
+		return expression, nil
+	}
+
+	macros, err := storage.QueryMacros()
+	if err != nil {
+		return nil, err
+	}
+
+	return query.ExpandMacros(expression, macros)
+}
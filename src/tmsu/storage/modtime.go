@@ -0,0 +1,223 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"tmsu/entities"
+)
+
+// The prefix of the names of the tags derived from the files' modification
+// times, e.g. 'mtime-year'.
+const ModTimeTagPrefix = "mtime-"
+
+// The components of a modification time that may be maintained as tags, with
+// the layout of their values.
+var modTimeComponents = []struct {
+	name   string
+	layout string
+}{
+	{"year", "2006"},
+	{"month", "01"},
+	{"day", "02"},
+}
+
+// Brings the file's modification time tags, such as 'mtime-year=2015', into
+// line with its modification time and the 'modTimeTags' setting.
+func (storage *Storage) UpdateModTimeTags(file *entities.File) error {
+	components, err := storage.modTimeTagComponents()
+	if err != nil {
+		return err
+	}
+	if len(components) == 0 {
+		return nil
+	}
+
+	return storage.updateModTimeTags(file, components)
+}
+
+// Brings the modification time tags of every file into line with the
+// 'modTimeTags' setting, adding those now enabled and removing those now
+// disabled. Files left without tags are removed.
+func (storage *Storage) UpdateAllModTimeTags() error {
+	components, err := storage.modTimeTagComponents()
+	if err != nil {
+		return err
+	}
+
+	files, err := storage.Files()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := storage.updateModTimeTags(file, components); err != nil {
+			return fmt.Errorf("%v: could not update modification time tags: %v", file.Path(), err)
+		}
+	}
+
+	return nil
+}
+
+// Determines whether a value is valid for the 'modTimeTags' setting.
+func ValidateModTimeTags(value string) error {
+	_, err := parseModTimeTagComponents(value)
+	return err
+}
+
+// unexported
+
+// The components enabled by the 'modTimeTags' setting.
+func (storage *Storage) modTimeTagComponents() (map[string]bool, error) {
+	value, err := storage.SettingAsString("modTimeTags")
+	if err != nil {
+		return nil, err
+	}
+
+	components, err := parseModTimeTagComponents(value)
+	if err != nil {
+		return nil, fmt.Errorf("setting 'modTimeTags' has an %v.", err)
+	}
+
+	return components, nil
+}
+
+// Parses a comma or whitespace-separated list of 'year', 'month' and 'day'.
+func parseModTimeTagComponents(value string) (map[string]bool, error) {
+	components := make(map[string]bool, len(modTimeComponents))
+	for _, name := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		known := false
+		for _, component := range modTimeComponents {
+			if component.name == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("invalid component '%v': expected 'year', 'month' or 'day'", name)
+		}
+
+		components[name] = true
+	}
+
+	return components, nil
+}
+
+func (storage *Storage) updateModTimeTags(file *entities.File, components map[string]bool) error {
+	if file.ModTime.IsZero() {
+		// the modification time of a URL may not be known
+		components = nil
+	}
+
+	fileTags, err := storage.Db.FileTagsByFileId(file.Id)
+	if err != nil {
+		return err
+	}
+
+	for _, component := range modTimeComponents {
+		tagName := ModTimeTagPrefix + component.name
+
+		tag, err := storage.TagByName(tagName)
+		if err != nil {
+			return err
+		}
+		if tag == nil {
+			if !components[component.name] {
+				continue
+			}
+
+			tag, err = storage.AddTag(tagName)
+			if err != nil {
+				return err
+			}
+		}
+
+		var value *entities.Value
+		if components[component.name] {
+			valueName := file.ModTime.Local().Format(component.layout)
+
+			value, err = storage.ValueByName(valueName)
+			if err != nil {
+				return err
+			}
+			if value == nil {
+				value, err = storage.AddValue(valueName)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		current := false
+		for _, fileTag := range fileTags {
+			if fileTag.TagId != tag.Id {
+				continue
+			}
+			if value != nil && fileTag.ValueId == value.Id {
+				current = true
+				continue
+			}
+
+			if err := storage.Db.DeleteFileTag(file.Id, fileTag.TagId, fileTag.ValueId); err != nil {
+				return err
+			}
+			if err := storage.DeleteValueIfUnused(fileTag.ValueId); err != nil {
+				return err
+			}
+		}
+
+		if value != nil && !current {
+			if _, err := storage.Db.AddFileTag(file.Id, tag.Id, value.Id, "", storage.Command); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(components) == 0 {
+		return storage.DeleteFileIfUntagged(file.Id)
+	}
+
+	return nil
+}
+
+// The identifiers of the modification time tags enabled by the 'modTimeTags'
+// setting, which do not count as tags of their own.
+func (storage *Storage) modTimeTagIds() (entities.TagIds, error) {
+	components, err := storage.modTimeTagComponents()
+	if err != nil {
+		return nil, err
+	}
+
+	tagIds := make(entities.TagIds, 0, len(components))
+	for _, component := range modTimeComponents {
+		if !components[component.name] {
+			continue
+		}
+
+		tag, err := storage.TagByName(ModTimeTagPrefix + component.name)
+		if err != nil {
+			return nil, err
+		}
+		if tag != nil {
+			tagIds = append(tagIds, tag.Id)
+		}
+	}
+
+	return tagIds, nil
+}
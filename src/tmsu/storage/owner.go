@@ -0,0 +1,183 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"tmsu/entities"
+	"tmsu/query"
+)
+
+// Where several users share a database, the 'recordTagOwner' setting causes
+// the file tags each user applies to be recorded as theirs, whilst the
+// 'tagVisibility' setting determines whether each user sees 'all' of the file
+// tags or just those that are 'mine' or have no owner.
+
+// Identifies the current user, which can be overridden with the TMSU_USER
+// environment variable.
+func (storage *Storage) CurrentOwner() (string, error) {
+	if name := os.Getenv("TMSU_USER"); name != "" {
+		return name, nil
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("could not identify current user: %v", err)
+	}
+
+	return u.Username, nil
+}
+
+// unexported
+
+// The owner to record for new file tags: none unless 'recordTagOwner' is set.
+func (storage *Storage) taggingOwner() (string, error) {
+	record, err := storage.SettingAsBool("recordTagOwner")
+	if err != nil {
+		return "", err
+	}
+	if !record {
+		return "", nil
+	}
+
+	return storage.CurrentOwner()
+}
+
+// The owner whose file tags, along with those with no owner, are visible: none
+// (meaning all file tags are visible) unless 'tagVisibility' is 'mine'.
+func (storage *Storage) visibleOwner() (string, error) {
+	visibility, err := storage.SettingAsString("tagVisibility")
+	if err != nil {
+		return "", err
+	}
+
+	switch visibility {
+	case "all":
+		return "", nil
+	case "mine":
+		return storage.CurrentOwner()
+	}
+
+	return "", fmt.Errorf("setting 'tagVisibility' has an invalid value '%v': expected 'all' or 'mine'.", visibility)
+}
+
+// Filters the file tags to those that are visible, combining those applied by
+// more than one user.
+func (storage *Storage) visibleFileTags(fileTags entities.FileTags) (entities.FileTags, error) {
+	owner, err := storage.visibleOwner()
+	if err != nil {
+		return nil, err
+	}
+
+	visible := make(entities.FileTags, 0, len(fileTags))
+	for _, fileTag := range fileTags {
+		if owner != "" && fileTag.Owner != "" && fileTag.Owner != owner {
+			continue
+		}
+		if visible.Find(fileTag.FileId, fileTag.TagId, fileTag.ValueId) != nil {
+			continue
+		}
+
+		visible = append(visible, fileTag)
+	}
+
+	return visible, nil
+}
+
+// Prepares a query expression for the database, resolving its paths and the
+// owners of its tags, marking them as inherited from directories where the
+// 'inheritDirectoryTags' setting is enabled and adding the implied tags. Where
+// explicitOnly is specified only the tags marked 'implied:' are expanded.
+func (storage *Storage) prepareExpression(expression query.Expression, explicitOnly bool) (query.Expression, error) {
+	expression, err := mapQueryPaths(expression, storage.queryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	expression, err = storage.applyTagOwners(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	expression, err = storage.applyTagInheritance(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	expression, err = storage.addImpliedTags(expression, explicitOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	return expression, nil
+}
+
+func (storage *Storage) applyTagOwners(expression query.Expression) (query.Expression, error) {
+	currentOwner, err := storage.CurrentOwner()
+	if err != nil {
+		return nil, err
+	}
+
+	visibleOwner, err := storage.visibleOwner()
+	if err != nil {
+		return nil, err
+	}
+
+	return applyTagOwnersRecursive(expression, currentOwner, visibleOwner), nil
+}
+
+func applyTagOwnersRecursive(expression query.Expression, currentOwner, visibleOwner string) query.Expression {
+	switch typedExpression := expression.(type) {
+	case query.OrExpression:
+		typedExpression.LeftOperand = applyTagOwnersRecursive(typedExpression.LeftOperand, currentOwner, visibleOwner)
+		typedExpression.RightOperand = applyTagOwnersRecursive(typedExpression.RightOperand, currentOwner, visibleOwner)
+		return typedExpression
+	case query.AndExpression:
+		typedExpression.LeftOperand = applyTagOwnersRecursive(typedExpression.LeftOperand, currentOwner, visibleOwner)
+		typedExpression.RightOperand = applyTagOwnersRecursive(typedExpression.RightOperand, currentOwner, visibleOwner)
+		return typedExpression
+	case query.NotExpression:
+		typedExpression.Operand = applyTagOwnersRecursive(typedExpression.Operand, currentOwner, visibleOwner)
+		return typedExpression
+	case query.TagExpression:
+		return applyTagOwner(typedExpression, currentOwner, visibleOwner)
+	case query.ComparisonExpression:
+		typedExpression.Tag = applyTagOwner(typedExpression.Tag, currentOwner, visibleOwner)
+		return typedExpression
+	case query.ValueExpression, query.PathExpression, query.EmptyExpression:
+		return expression
+	default:
+		panic(fmt.Sprintf("unsupported expression type '%T'.", typedExpression))
+	}
+}
+
+func applyTagOwner(tagExpression query.TagExpression, currentOwner, visibleOwner string) query.TagExpression {
+	switch tagExpression.Owner {
+	case query.CurrentOwner:
+		tagExpression.Owner = currentOwner
+	case "":
+		if visibleOwner != "" {
+			tagExpression.Owner = visibleOwner
+			tagExpression.Shared = true
+		}
+	}
+
+	return tagExpression
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"tmsu/entities"
+)
+
+// Retrieves the number of files awaiting a fingerprint.
+func (storage *Storage) PendingFingerprintCount() (uint, error) {
+	return storage.Db.PendingFingerprintCount()
+}
+
+// Retrieves the files that were added without a fingerprint and await one.
+func (storage *Storage) PendingFingerprintFiles() (entities.Files, error) {
+	files, err := storage.Db.PendingFingerprintFiles()
+	storage.absPaths(files)
+	return files, err
+}
+
+// Queues the specified file for fingerprinting.
+func (storage *Storage) AddPendingFingerprint(fileId entities.FileId) error {
+	return storage.Db.InsertPendingFingerprint(fileId)
+}
+
+// Removes the specified file from the fingerprinting queue.
+func (storage *Storage) DeletePendingFingerprint(fileId entities.FileId) error {
+	return storage.Db.DeletePendingFingerprint(fileId)
+}
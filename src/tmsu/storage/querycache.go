@@ -0,0 +1,105 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"tmsu/entities"
+	"tmsu/query"
+	"tmsu/storage/database"
+)
+
+// The number of query results kept by the query cache.
+const queryCacheSize = 100
+
+// The number of files above which query results are not cached.
+const queryCacheMaxFiles = 10000
+
+// Remembers the files matched by recent queries so that repeating a query, such
+// as when a virtual directory is listed again, need not run it again. The
+// results are those of a particular revision of the database and are discarded
+// as soon as it changes. The files returned are copies so that callers cannot
+// alter the cached results.
+type queryCache struct {
+	sync.Mutex
+	revision database.Revision
+	results  map[string]entities.Files
+}
+
+func newQueryCache() *queryCache {
+	return &queryCache{results: make(map[string]entities.Files)}
+}
+
+func (cache *queryCache) files(key string, revision database.Revision) (entities.Files, bool) {
+	if cache == nil {
+		return nil, false
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	if revision != cache.revision {
+		return nil, false
+	}
+
+	files, ok := cache.results[key]
+	if !ok {
+		return nil, false
+	}
+
+	return copyFiles(files), true
+}
+
+func (cache *queryCache) addFiles(key string, revision database.Revision, files entities.Files) {
+	if cache == nil || len(files) > queryCacheMaxFiles {
+		return
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	if revision != cache.revision || len(cache.results) >= queryCacheSize {
+		cache.revision = revision
+		cache.results = make(map[string]entities.Files)
+	}
+
+	cache.results[key] = copyFiles(files)
+}
+
+// Identifies the results of a query by the query text and the options it was
+// run with.
+func queryCacheKey(expression query.Expression, paths []string, explicitOnly bool, filter entities.FileFilter) string {
+	return fmt.Sprintf("%v\x00%q\x00%v\x00%+v", query.Format(expression), paths, explicitOnly, filter)
+}
+
+// Whether the files matching a query with the filter may be cached: a random
+// sample may not, being different each time.
+func queryCacheable(filter entities.FileFilter) bool {
+	return filter.Sample == 0
+}
+
+func copyFiles(files entities.Files) entities.Files {
+	copies := make(entities.Files, len(files))
+	for index, file := range files {
+		fileCopy := *file
+		copies[index] = &fileCopy
+	}
+
+	return copies
+}
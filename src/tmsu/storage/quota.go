@@ -0,0 +1,114 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"tmsu/entities"
+)
+
+// Returns a FileQuotaError if applying the specified tags to the file would
+// give it more tags than the 'fileTagLimit' setting permits, or a
+// TagQuotaError if it would apply any of the tags to more files than the
+// 'tagFileLimit' setting permits, unless Force is set. A limit of zero
+// permits any number.
+func (storage *Storage) CheckTagQuotas(fileId entities.FileId, tagIds entities.TagIds) error {
+	if storage.Force || len(tagIds) == 0 {
+		return nil
+	}
+
+	fileTagLimit, err := storage.SettingAsUint("fileTagLimit")
+	if err != nil {
+		return err
+	}
+
+	tagFileLimit, err := storage.SettingAsUint("tagFileLimit")
+	if err != nil {
+		return err
+	}
+
+	if fileTagLimit == 0 && tagFileLimit == 0 {
+		return nil
+	}
+
+	fileTags, err := storage.Db.FileTagsByFileId(fileId)
+	if err != nil {
+		return err
+	}
+
+	modTimeTagIds, err := storage.modTimeTagIds()
+	if err != nil {
+		return err
+	}
+
+	// only the tags the file does not already have count against the limits,
+	// and the modification time tags not at all
+	appliedTagIds := make(entities.TagIds, 0, len(fileTags))
+	for _, tagId := range fileTags.TagIds().Uniq() {
+		if !containsTagId(modTimeTagIds, tagId) {
+			appliedTagIds = append(appliedTagIds, tagId)
+		}
+	}
+	newTagIds := make(entities.TagIds, 0, len(tagIds))
+	for _, tagId := range tagIds.Uniq() {
+		if !containsTagId(appliedTagIds, tagId) {
+			newTagIds = append(newTagIds, tagId)
+		}
+	}
+
+	if len(newTagIds) == 0 {
+		return nil
+	}
+
+	if fileTagLimit != 0 && uint(len(appliedTagIds)+len(newTagIds)) > fileTagLimit {
+		return FileQuotaError{fileTagLimit}
+	}
+
+	if tagFileLimit == 0 {
+		return nil
+	}
+
+	for _, tagId := range newTagIds {
+		count, err := storage.Db.FileCountByTagId(tagId)
+		if err != nil {
+			return err
+		}
+
+		if count+1 > tagFileLimit {
+			tag, err := storage.Db.Tag(tagId)
+			if err != nil {
+				return err
+			}
+
+			return TagQuotaError{tag.Name, tagFileLimit}
+		}
+	}
+
+	return nil
+}
+
+// unexported
+
+func containsTagId(tagIds entities.TagIds, tagId entities.TagId) bool {
+	for _, id := range tagIds {
+		if id == tagId {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"sort"
+	"strings"
+	"tmsu/entities"
+	"unicode"
+)
+
+// Searches for files with tag or value names matching the specified text,
+// returning the results ordered by relevance.
+func (storage *Storage) SearchFiles(text string) (entities.SearchResults, error) {
+	terms := searchTerms(text)
+
+	results, err := storage.Db.SearchFiles(terms)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		storage.absPath(result.File)
+		result.Score = searchScore(terms, result)
+	}
+
+	sort.Sort(results)
+
+	return results, nil
+}
+
+// unexported
+
+// Splits text into search terms in the same way as the full-text tokenizer.
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Scores a search result: tag name matches count for more than value matches
+// and whole-word matches for more than prefix matches.
+func searchScore(terms []string, result *entities.SearchResult) float64 {
+	tagWords := searchTerms(result.Tags)
+	valueWords := searchTerms(result.Values)
+
+	score := 0.0
+	for _, term := range terms {
+		score += 2 * wordScore(term, tagWords)
+		score += wordScore(term, valueWords)
+	}
+
+	// favour files with fewer tags as they match more specifically
+	return score / float64(1+len(tagWords)+len(valueWords))
+}
+
+func wordScore(term string, words []string) float64 {
+	score := 0.0
+	for _, word := range words {
+		switch {
+		case word == term:
+			score += 2
+		case strings.HasPrefix(word, term):
+			score += 1
+		}
+	}
+
+	return score
+}
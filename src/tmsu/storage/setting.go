@@ -19,12 +19,67 @@ package storage
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"tmsu/entities"
 )
 
-// The complete set of settings.
+// The built-in defaults for the settings.
+var defaultSettings = entities.Settings{
+	{"autoCreateTags", "yes"},
+	{"autoCreateValues", "yes"},
+	{"autoVacuumThreshold", "0"},
+	{"cascade", "none"},
+	{"deleteConfirmThreshold", "1000"},
+	{"emblemTags", ""},
+	{"extraFingerprintAlgorithms", ""},
+	{"fileTagLimit", "0"},
+	{"finderTagPrefix", ""},
+	{"fingerprintAlgorithm", "dynamic:SHA256"},
+	{"inheritDirectoryTags", "no"},
+	{"lockedTags", ""},
+	{"modTimeTags", ""},
+	{"recordTagOwner", "no"},
+	{"retainDeletedFiles", "no"},
+	{"sidecarGroups", ""},
+	{"symlinkPolicy", "follow"},
+	{"tagFileLimit", "0"},
+	{"tagHardLinks", "no"},
+	{"tagVisibility", "all"},
+	{"untagConfirmThreshold", "100"},
+	{"unusedRetention", "30d"},
+	{"xmpValueSeparator", "="},
+}
+
+// The complete set of settings: those stored in the database, those from the
+// configuration file and the defaults for the remainder, ordered by name.
 func (storage *Storage) Settings() (entities.Settings, error) {
-	return storage.Db.Settings()
+	settings, err := storage.Db.Settings()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool, len(settings))
+	for _, setting := range settings {
+		names[setting.Name] = true
+	}
+
+	for name, value := range storage.Config {
+		if !names[name] {
+			settings = append(settings, &entities.Setting{name, value})
+			names[name] = true
+		}
+	}
+
+	for _, setting := range defaultSettings {
+		if !names[setting.Name] {
+			settings = append(settings, &entities.Setting{setting.Name, setting.Value})
+		}
+	}
+
+	sort.Sort(settings)
+
+	return settings, nil
 }
 
 // Retrievs the specified setting.
@@ -34,19 +89,32 @@ func (storage *Storage) Setting(name string) (*entities.Setting, error) {
 		return nil, err
 	}
 
-	// defaults
+	// the configuration file, then the built-in defaults
 	if setting == nil {
-		switch name {
-		case "fingerprintAlgorithm":
-			return &entities.Setting{name, "dynamic:SHA256"}, nil
-		case "autoCreateTags", "autoCreateValues":
-			return &entities.Setting{name, "yes"}, nil
+		if value, ok := storage.Config[name]; ok {
+			return &entities.Setting{name, value}, nil
+		}
+
+		for _, defaultSetting := range defaultSettings {
+			if defaultSetting.Name == name {
+				return &entities.Setting{name, defaultSetting.Value}, nil
+			}
 		}
 	}
 
 	return setting, nil
 }
 
+// Stores the specified setting in the database.
+func (storage *Storage) UpdateSetting(name, value string) (*entities.Setting, error) {
+	return storage.Db.UpdateSetting(name, value)
+}
+
+// Removes the specified setting from the database, restoring its default.
+func (storage *Storage) DeleteSetting(name string) error {
+	return storage.Db.DeleteSetting(name)
+}
+
 // Retrieves the specified setting's string value.
 func (storage *Storage) SettingAsString(name string) (string, error) {
 	setting, err := storage.Setting(name)
@@ -80,3 +148,21 @@ func (storage *Storage) SettingAsBool(name string) (bool, error) {
 
 	}
 }
+
+// Retrieves the specified setting's non-negative integer value.
+func (storage *Storage) SettingAsUint(name string) (uint, error) {
+	setting, err := storage.Setting(name)
+	if err != nil {
+		return 0, err
+	}
+	if setting == nil {
+		return 0, fmt.Errorf("no such setting '%v'.", name)
+	}
+
+	value, err := strconv.ParseUint(setting.Value, 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("setting '%v' has an invalid value '%v': expected a whole number.", name, setting.Value)
+	}
+
+	return uint(value), nil
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The groups of files, such as a RAW photograph and the JPEG and XMP files
+// alongside it, that the 'sidecarGroups' setting has tagged as one. Each group
+// is a list of patterns in which '*' stands for the name the files share.
+func (storage *Storage) SidecarGroups() ([][]string, error) {
+	value, err := storage.SettingAsString("sidecarGroups")
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([][]string, 0, 2)
+	for _, field := range strings.Fields(value) {
+		patterns := strings.Split(field, ",")
+		for _, pattern := range patterns {
+			if strings.Count(pattern, "*") != 1 || strings.ContainsRune(pattern, '/') {
+				return nil, fmt.Errorf("invalid sidecar pattern '%v': must contain a single '*' and no '/'", pattern)
+			}
+		}
+
+		groups = append(groups, patterns)
+	}
+
+	return groups, nil
+}
@@ -18,15 +18,43 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package storage
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
+	"sync"
+	"tmsu/common/config"
 	"tmsu/common/log"
+	_path "tmsu/common/path"
+	"tmsu/entities"
 	"tmsu/storage/database"
 )
 
+// The storage facade. It may be used by multiple goroutines, which share the
+// current transaction: changes that must not interleave with those of other
+// goroutines, such as those made atomically, should be made Exclusively.
 type Storage struct {
-	Db *database.Database
+	Db       *database.Database
 	RootPath string
+
+	// Whether tags listed in the 'lockedTags' setting may be removed.
+	Force bool
+
+	// The subcommand being run, which is recorded against the file tags it
+	// applies.
+	Command string
+
+	// Settings from the user's configuration file, which apply where the
+	// database does not specify its own.
+	Config config.Settings
+
+	// Tags and values looked up by name within the current transaction.
+	names *nameCache
+
+	// The results of recent queries, if enabled.
+	queries *queryCache
+
+	// Held for the duration of each Exclusively call, by whichever handle.
+	exclusive *sync.Mutex
 }
 
 func OpenAt(path string) (*Storage, error) {
@@ -42,21 +70,112 @@ func OpenAt(path string) (*Storage, error) {
 
     log.Infof(2, "files are stored relative to root path '%v'", rootPath)
 
-	return &Storage{db, rootPath}, nil
+	return &Storage{Db: db, RootPath: rootPath, names: newNameCache(), exclusive: &sync.Mutex{}}, nil
+}
+
+// Creates a handle to the same storage whose database operations are bound
+// to the specified context, allowing them to be cancelled or given a deadline.
+func (storage *Storage) WithContext(ctx context.Context) *Storage {
+	handle := *storage
+	handle.Db = storage.Db.WithContext(ctx)
+
+	return &handle
+}
+
+// The context to which the database operations are bound.
+func (storage *Storage) Context() context.Context {
+	return storage.Db.Context()
 }
 
 func (storage *Storage) Begin() error {
+	storage.names.clear()
 	return storage.Db.Begin()
 }
 
 func (storage *Storage) Commit() error {
+	storage.names.clear()
 	return storage.Db.Commit()
 }
 
 func (storage *Storage) Rollback() error {
+	storage.names.clear()
 	return storage.Db.Rollback()
 }
 
+// Commits the changes made so far and continues in a new transaction.
+func (storage *Storage) Checkpoint() error {
+	storage.names.clear()
+	return storage.Db.Checkpoint()
+}
+
+// Caches the results of queries until the database is next changed, so that
+// repeated queries need not be run again. It is worthwhile for long-running
+// uses of the storage, such as the virtual filesystem.
+func (storage *Storage) EnableQueryCache() {
+	if storage.queries == nil {
+		storage.queries = newQueryCache()
+	}
+}
+
+// Retrieves the version of the database schema.
+func (storage *Storage) SchemaVersion() (uint, error) {
+	return storage.Db.SchemaVersion()
+}
+
+// Retrieves the schema migrations that have been applied to the database.
+func (storage *Storage) Migrations() (entities.Migrations, error) {
+	return storage.Db.Migrations()
+}
+
+// Retrieves the use of the pages of the database file.
+func (storage *Storage) PageUsage() (*database.PageUsage, error) {
+	return storage.Db.PageUsage()
+}
+
+// Rebuilds the database file, reclaiming the space left by deleted rows. There
+// must not be an open transaction.
+func (storage *Storage) Vacuum() error {
+	return storage.Db.Vacuum()
+}
+
+// Gathers the statistics used to choose how to run queries.
+func (storage *Storage) Analyze() error {
+	return storage.Db.Analyze()
+}
+
+// Runs the query against a read-only connection to the database. The header
+// function is given the names of the columns and then the row function the
+// values of each row in turn.
+func (storage *Storage) ReadOnlyQuery(query string, header func(columns []string) error, row func(values []interface{}) error) error {
+	return storage.Db.ReadOnlyQuery(query, header, row)
+}
+
+// Writes a snapshot of the database to the specified path.
+func (storage *Storage) Backup(destPath string) error {
+	return storage.Db.Backup(destPath)
+}
+
+// Replaces the database with the snapshot at the specified path.
+func (storage *Storage) Restore(sourcePath string) error {
+	storage.names.clear()
+	return storage.Db.Restore(sourcePath)
+}
+
+// Makes the changes of the specified function as a unit: if it returns an
+// error then none of its changes to the database are kept.
+func (storage *Storage) Atomically(name string, changes func() error) error {
+	return storage.atomically(name, changes)
+}
+
+// Runs the specified function with exclusive use of the storage, so that the
+// requests a server handles concurrently do not interleave their use of it.
+func (storage *Storage) Exclusively(fn func() error) error {
+	storage.exclusive.Lock()
+	defer storage.exclusive.Unlock()
+
+	return fn()
+}
+
 func (storage *Storage) Close() error {
 	err := storage.Db.Close()
 	if err != nil {
@@ -68,6 +187,61 @@ func (storage *Storage) Close() error {
 
 // unexported
 
+// Makes the changes of the specified function as a unit: if it fails then none
+// of its changes are kept. Within an open transaction a savepoint of the
+// specified name is used, otherwise a transaction of its own.
+func (storage *Storage) atomically(name string, changes func() error) error {
+	began, err := storage.Db.BeginUnlessOpen()
+	if err != nil {
+		return err
+	}
+
+	if began {
+		if err := changes(); err != nil {
+			storage.names.clear()
+			if err := storage.Db.Rollback(); err != nil {
+				return err
+			}
+
+			return err
+		}
+
+		storage.names.clear()
+		return storage.Db.Commit()
+	}
+
+	if err := storage.Db.Savepoint(name); err != nil {
+		return err
+	}
+
+	if err := changes(); err != nil {
+		storage.names.clear()
+		if err := storage.Db.RollbackToSavepoint(name); err != nil {
+			return err
+		}
+
+		return err
+	}
+
+	return storage.Db.ReleaseSavepoint(name)
+}
+
+// Retrieves the revision of the database that cached query results must be
+// of, if the query cache is enabled.
+func (storage *Storage) queryRevision() (database.Revision, bool) {
+	if storage.queries == nil {
+		return database.Revision{}, false
+	}
+
+	revision, err := storage.Db.Revision()
+	if err != nil {
+		log.Warnf("could not determine database revision: %v", err)
+		return database.Revision{}, false
+	}
+
+	return revision, true
+}
+
 func determineRootPath(dbPath string) (string, error) {
     absDbPath, err := filepath.Abs(dbPath)
     if err != nil {
@@ -79,5 +253,5 @@ func determineRootPath(dbPath string) (string, error) {
         return filepath.Dir(absDbDirPath), nil
     }
 
-    return string(filepath.Separator), nil //TODO Windows
+    return _path.Root(absDbPath), nil
 }
@@ -0,0 +1,431 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"tmsu/entities"
+	"tmsu/query"
+	"tmsu/storage/database"
+)
+
+// a query that runs until it is interrupted
+const endlessQuery = `WITH RECURSIVE counter(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM counter)
+                      SELECT count(*) FROM counter`
+
+func TestWithContextCancelsQuery(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handle := store.WithContext(ctx)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	// test
+
+	started := time.Now()
+	err := handle.ReadOnlyQuery(endlessQuery, ignoreColumns, ignoreRow)
+
+	// validate
+
+	if err == nil {
+		test.Fatal("Expected the cancelled query to fail.")
+	}
+	if time.Since(started) > 5*time.Second {
+		test.Fatalf("Query was not abandoned promptly: %v.", time.Since(started))
+	}
+	if ctx.Err() != context.Canceled {
+		test.Fatalf("Expected the context to be cancelled but was %v.", ctx.Err())
+	}
+}
+
+func TestWithContextDeadline(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// test
+
+	err := store.WithContext(ctx).ReadOnlyQuery(endlessQuery, ignoreColumns, ignoreRow)
+
+	// validate
+
+	if err == nil {
+		test.Fatal("Expected the query to fail once its deadline passed.")
+	}
+	if ctx.Err() != context.DeadlineExceeded {
+		test.Fatalf("Expected the deadline to have passed but was %v.", ctx.Err())
+	}
+}
+
+func TestWithContextCancelledStatements(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	if _, err := store.AddTag("apple"); err != nil {
+		test.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// test
+
+	_, err := store.WithContext(ctx).Tags()
+
+	// validate
+
+	queryErr, ok := err.(database.DatabaseQueryError)
+	if !ok {
+		test.Fatalf("Expected a query error but was %v.", err)
+	}
+	if queryErr.Reason != context.Canceled {
+		test.Fatalf("Expected the query to be cancelled but was %v.", queryErr.Reason)
+	}
+
+	// the original handle is unaffected
+	tags, err := store.Tags()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0].Name != "apple" {
+		test.Fatalf("Incorrect tags %v.", tags)
+	}
+}
+
+func TestWithContextCopiesSettings(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	store.Force = true
+	store.Command = "tag"
+	store.Config = map[string]string{"color": "never"}
+
+	// test
+
+	handle := store.WithContext(context.Background())
+
+	// validate
+
+	if !handle.Force || handle.Command != "tag" || handle.Config["color"] != "never" || handle.RootPath != store.RootPath {
+		test.Fatalf("Handle does not have the storage's settings: %+v.", handle)
+	}
+	if handle.names != store.names {
+		test.Fatal("Handle does not share the storage's name cache.")
+	}
+}
+
+func TestConcurrentUse(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+
+	const workers = 8
+	const tagsPerWorker = 25
+
+	// test
+
+	var wait sync.WaitGroup
+	errs := make(chan error, workers)
+	for worker := 0; worker < workers; worker++ {
+		wait.Add(1)
+
+		go func(worker int) {
+			defer wait.Done()
+
+			handle := store.WithContext(context.Background())
+			for index := 0; index < tagsPerWorker; index++ {
+				name := fmt.Sprintf("tag-%v-%v", worker, index)
+				if _, err := handle.AddTag(name); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := handle.TagByName(name); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(worker)
+	}
+
+	wait.Wait()
+	close(errs)
+
+	// validate
+
+	for err := range errs {
+		test.Fatal(err)
+	}
+
+	if err := store.Commit(); err != nil {
+		test.Fatal(err)
+	}
+
+	count, err := store.TagCount()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if count != workers*tagsPerWorker {
+		test.Fatalf("Expected %v tags but there were %v.", workers*tagsPerWorker, count)
+	}
+}
+
+func TestExclusively(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	const workers = 8
+
+	var active, overlaps int32
+	var wait sync.WaitGroup
+
+	// test
+
+	for worker := 0; worker < workers; worker++ {
+		wait.Add(1)
+
+		go func() {
+			defer wait.Done()
+
+			// each handle shares the one lock
+			handle := store.WithContext(context.Background())
+			handle.Exclusively(func() error {
+				if atomic.AddInt32(&active, 1) > 1 {
+					atomic.AddInt32(&overlaps, 1)
+				}
+
+				_, err := handle.Tags()
+				time.Sleep(time.Millisecond)
+
+				atomic.AddInt32(&active, -1)
+				return err
+			})
+		}()
+	}
+
+	wait.Wait()
+
+	// validate
+
+	if overlaps > 0 {
+		test.Fatalf("Exclusive use of the storage overlapped %v times.", overlaps)
+	}
+}
+
+func TestBeginUnlessOpen(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	const workers = 8
+	var wait sync.WaitGroup
+	var began int32
+
+	// test
+
+	errs := make(chan error, workers)
+	for worker := 0; worker < workers; worker++ {
+		wait.Add(1)
+
+		go func() {
+			defer wait.Done()
+
+			handle := store.WithContext(context.Background())
+			ok, err := handle.Db.BeginUnlessOpen()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if ok {
+				atomic.AddInt32(&began, 1)
+			}
+		}()
+	}
+
+	wait.Wait()
+	close(errs)
+
+	// validate
+
+	for err := range errs {
+		test.Fatal(err)
+	}
+
+	if began != 1 {
+		test.Fatalf("Expected one transaction to be started but there were %v.", began)
+	}
+	if !store.Db.InTransaction() {
+		test.Fatal("Expected a transaction to be open.")
+	}
+	if err := store.Rollback(); err != nil {
+		test.Fatal(err)
+	}
+}
+
+func TestTagsForFileQueryValueImplications(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	if err := store.Begin(); err != nil {
+		test.Fatal(err)
+	}
+	defer store.Rollback()
+
+	tags := make(map[string]*entities.Tag)
+	for _, name := range []string{"music", "genre", "jazz", "improvised", "year", "old"} {
+		tag, err := store.AddTag(name)
+		if err != nil {
+			test.Fatal(err)
+		}
+		tags[name] = tag
+	}
+
+	values := make(map[string]*entities.Value)
+	for _, name := range []string{"jazz", "rock", "1920", "2020"} {
+		value, err := store.AddValue(name)
+		if err != nil {
+			test.Fatal(err)
+		}
+		values[name] = value
+	}
+
+	// genre=jazz -> jazz -> improvised, year=1920 -> old
+	implications := []struct {
+		tag, value, implied string
+	}{
+		{"genre", "jazz", "jazz"},
+		{"jazz", "", "improvised"},
+		{"year", "1920", "old"},
+	}
+	for _, implication := range implications {
+		var valueId entities.ValueId
+		if implication.value != "" {
+			valueId = values[implication.value].Id
+		}
+
+		if err := store.AddImplication(tags[implication.tag].Id, valueId, tags[implication.implied].Id); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	fileTags := map[string][]entities.TagValuePair{
+		"/tmp/tmsu/a": {{tags["music"].Id, 0}, {tags["genre"].Id, values["jazz"].Id}, {tags["year"].Id, values["2020"].Id}},
+		"/tmp/tmsu/b": {{tags["music"].Id, 0}, {tags["genre"].Id, values["rock"].Id}},
+	}
+	for path, pairs := range fileTags {
+		file, err := store.AddFile(path, "", time.Time{}, 0, false)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		for _, pair := range pairs {
+			if _, err := store.AddFileTag(file.Id, pair.TagId, pair.ValueId); err != nil {
+				test.Fatal(err)
+			}
+		}
+	}
+
+	expectations := map[string]string{
+		"music":                 "genre improvised jazz music year",
+		"genre = rock":          "genre music",
+		"music and year = 2020": "genre improvised jazz music year",
+	}
+
+	for queryText, expected := range expectations {
+		expression, err := query.Parse(queryText)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		// test
+
+		tags, err := store.TagsForFileQuery(expression, "", false)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		// validate
+
+		names := make([]string, len(tags))
+		for index, tag := range tags {
+			names[index] = tag.Name
+		}
+		if actual := strings.Join(names, " "); actual != expected {
+			test.Fatalf("%v: expected tags '%v' but were '%v'.", queryText, expected, actual)
+		}
+	}
+}
+
+// unexported
+
+func openTestStorage(test *testing.T) *Storage {
+	databasePath := filepath.Join(os.TempDir(), "tmsu_storage_test.db")
+	os.Remove(databasePath)
+
+	store, err := OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	return store
+}
+
+func closeTestStorage(store *Storage) {
+	store.Close()
+	os.Remove(store.Db.Path)
+}
+
+func ignoreColumns(columns []string) error {
+	return nil
+}
+
+func ignoreRow(values []interface{}) error {
+	return nil
+}
@@ -20,7 +20,10 @@ package storage
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"time"
 	"tmsu/entities"
+	"tmsu/query"
 	"unicode"
 )
 
@@ -34,6 +37,69 @@ func (storage *Storage) Tags() (entities.Tags, error) {
 	return storage.Db.Tags()
 }
 
+// Retrieves the tags applied to the files that match the specified query and
+// are under the specified path. Unless explicitOnly is specified this includes
+// the implied tags.
+func (storage *Storage) TagsForFileQuery(expression query.Expression, path string, explicitOnly bool) (entities.Tags, error) {
+	preparedExpression, err := storage.prepareExpression(expression, explicitOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := storage.Db.TagsForFileQuery(preparedExpression, storage.relPath(path))
+	if err != nil || explicitOnly {
+		return tags, err
+	}
+
+	tagIds := make(entities.TagIds, len(tags))
+	for index, tag := range tags {
+		tagIds[index] = tag.Id
+	}
+
+	implications, err := storage.ImplicationsForTags(tagIds...)
+	if err != nil {
+		return nil, err
+	}
+
+	// where an implication depends upon the value, the implying tag being
+	// present does not mean the implied tag is so the implications holding
+	// for the values applied are used instead
+	valueDependent := false
+	for _, implication := range implications {
+		if implication.ImplyingValue.Id != 0 {
+			valueDependent = true
+			break
+		}
+	}
+
+	if valueDependent {
+		pairs, err := storage.Db.TagValuePairsForFileQuery(preparedExpression, storage.relPath(path))
+		if err != nil {
+			return nil, err
+		}
+
+		holding := make(entities.Implications, 0, len(implications))
+		for _, pair := range pairs {
+			holding = append(holding, implications.Holding(pair.TagId, pair.ValueId)...)
+		}
+
+		implications = holding
+	}
+
+	for _, implication := range implications {
+		if tags.ContainsName(implication.ImpliedTag.Name) {
+			continue
+		}
+
+		impliedTag := implication.ImpliedTag
+		tags = append(tags, &impliedTag)
+	}
+
+	sort.Sort(tags)
+
+	return tags, nil
+}
+
 // Retrieves a specific tag.
 func (storage Storage) Tag(id entities.TagId) (*entities.Tag, error) {
 	return storage.Db.Tag(id)
@@ -46,7 +112,20 @@ func (storage Storage) TagsByIds(ids entities.TagIds) (entities.Tags, error) {
 
 // Retrieves a specific tag.
 func (storage Storage) TagByName(name string) (*entities.Tag, error) {
-	return storage.Db.TagByName(name)
+	if tag, ok := storage.names.tag(name); ok {
+		return tag, nil
+	}
+
+	tag, err := storage.Db.TagByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if storage.Db.InTransaction() {
+		storage.names.addTag(tag)
+	}
+
+	return tag, nil
 }
 
 // Retrieves the set of named tags.
@@ -60,6 +139,7 @@ func (storage *Storage) AddTag(name string) (*entities.Tag, error) {
 		return nil, err
 	}
 
+	storage.names.clear()
 	return storage.Db.InsertTag(name)
 }
 
@@ -69,23 +149,37 @@ func (storage Storage) RenameTag(tagId entities.TagId, name string) (*entities.T
 		return nil, err
 	}
 
+	storage.names.clear()
 	return storage.Db.RenameTag(tagId, name)
 }
 
-// Copies a tag.
-func (storage Storage) CopyTag(sourceTagId entities.TagId, name string) (*entities.Tag, error) {
+// Copies a tag. If values are specified then only the applications of the tag
+// with those values are copied. Either the tag is copied in full or, upon
+// error, not at all.
+func (storage Storage) CopyTag(sourceTagId entities.TagId, name string, valueIds entities.ValueIds) (*entities.Tag, error) {
 	if err := validateTagName(name); err != nil {
 		return nil, err
 	}
 
-	tag, err := storage.Db.InsertTag(name)
-	if err != nil {
-		return nil, fmt.Errorf("could not create tag '%v': %v", name, err)
-	}
+	var tag *entities.Tag
+	err := storage.atomically("copy_tag", func() error {
+		storage.names.clear()
 
-	err = storage.Db.CopyFileTags(sourceTagId, tag.Id)
+		var err error
+		tag, err = storage.Db.InsertTag(name)
+		if err != nil {
+			return fmt.Errorf("could not create tag '%v': %v", name, err)
+		}
+
+		err = storage.Db.CopyFileTags(sourceTagId, tag.Id, valueIds)
+		if err != nil {
+			return fmt.Errorf("could not copy file tags for tag #%v to tag '%v': %v", sourceTagId, name, err)
+		}
+
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("could not copy file tags for tag #%v to tag '%v': %v", sourceTagId, name, err)
+		return nil, err
 	}
 
 	return tag, nil
@@ -98,6 +192,7 @@ func (storage Storage) DeleteTag(tagId entities.TagId) error {
 		return err
 	}
 
+	storage.names.clear()
 	err = storage.Db.DeleteTag(tagId)
 	if err != nil {
 		return fmt.Errorf("could not delete tag '%v': %v", tagId, err)
@@ -106,15 +201,100 @@ func (storage Storage) DeleteTag(tagId entities.TagId) error {
 	return nil
 }
 
+// Merges the source tag into the destination tag, deleting the source tag. If a
+// value is specified then files tagged with the source tag without a value are
+// given the destination tag with that value. Either the whole merge is made or,
+// upon error, none of it.
+func (storage *Storage) MergeTag(sourceTagId, destTagId entities.TagId, valueId entities.ValueId) error {
+	return storage.atomically("merge_tag", func() error {
+		return storage.mergeTag(sourceTagId, destTagId, valueId)
+	})
+}
+
+// Replaces the applications of the tag with the specified value by
+// applications of the destination tag without a value. Either every file is
+// retagged or, upon error, none.
+func (storage *Storage) SplitTagValue(tagId entities.TagId, valueId entities.ValueId, destTagId entities.TagId) error {
+	return storage.atomically("split_tag_value", func() error {
+		return storage.splitTagValue(tagId, valueId, destTagId)
+	})
+}
+
+// Retrieves the tags that have been unused since before the specified time.
+func (storage Storage) UnusedTagsSince(cutoff time.Time) (entities.Tags, error) {
+	return storage.Db.UnusedTagsSince(cutoff)
+}
+
+// The number of tags that are not applied to any file.
+func (storage Storage) UnusedTagCount() (uint, error) {
+	return storage.Db.UnusedTagCount()
+}
+
 // Retrieves the tag usage.
 func (storage Storage) TagUsage() ([]entities.TagFileCount, error) {
 	return storage.Db.TagUsage()
 }
 
+// Determines whether a name may be used for a tag.
+func ValidateTagName(tagName string) error {
+	return validateTagName(tagName)
+}
+
 // unexported
 
 var validTagChars = []*unicode.RangeTable{unicode.Letter, unicode.Number, unicode.Punct, unicode.Symbol}
 
+func (storage *Storage) mergeTag(sourceTagId, destTagId entities.TagId, valueId entities.ValueId) error {
+	if err := storage.CheckTagsUnlocked(entities.TagIds{sourceTagId}); err != nil {
+		return err
+	}
+
+	fileTags, err := storage.FileTagsByTagId(sourceTagId, true)
+	if err != nil {
+		return fmt.Errorf("could not retrieve file tags for tag #%v: %v", sourceTagId, err)
+	}
+
+	for _, fileTag := range fileTags {
+		destValueId := fileTag.ValueId
+		if destValueId == 0 {
+			destValueId = valueId
+		}
+
+		if _, err := storage.AddFileTag(fileTag.FileId, destTagId, destValueId); err != nil {
+			return fmt.Errorf("could not apply tag #%v to file #%v: %v", destTagId, fileTag.FileId, err)
+		}
+	}
+
+	return storage.DeleteTag(sourceTagId)
+}
+
+func (storage *Storage) splitTagValue(tagId entities.TagId, valueId entities.ValueId, destTagId entities.TagId) error {
+	if err := storage.CheckTagsUnlocked(entities.TagIds{tagId}); err != nil {
+		return err
+	}
+
+	fileTags, err := storage.FileTagsByTagId(tagId, true)
+	if err != nil {
+		return fmt.Errorf("could not retrieve file tags for tag #%v: %v", tagId, err)
+	}
+
+	for _, fileTag := range fileTags {
+		if fileTag.ValueId != valueId {
+			continue
+		}
+
+		if _, err := storage.AddFileTag(fileTag.FileId, destTagId, 0); err != nil {
+			return fmt.Errorf("could not apply tag #%v to file #%v: %v", destTagId, fileTag.FileId, err)
+		}
+
+		if err := storage.DeleteFileTag(fileTag.FileId, tagId, valueId); err != nil {
+			return fmt.Errorf("could not remove tag #%v from file #%v: %v", tagId, fileTag.FileId, err)
+		}
+	}
+
+	return nil
+}
+
 func validateTagName(tagName string) error {
 	switch tagName {
 	case "":
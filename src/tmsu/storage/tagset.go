@@ -0,0 +1,71 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"errors"
+	"strings"
+	"tmsu/entities"
+)
+
+// The complete set of tag sets.
+func (storage *Storage) TagSets() (entities.TagSets, error) {
+	return storage.Db.TagSets()
+}
+
+// Retrieves the specified tag set.
+func (storage *Storage) TagSet(name string) (*entities.TagSet, error) {
+	return storage.Db.TagSet(name)
+}
+
+// Creates or replaces the specified tag set.
+func (storage *Storage) UpdateTagSet(name, tags string) (*entities.TagSet, error) {
+	if err := validateTagSetName(name); err != nil {
+		return nil, err
+	}
+
+	tagArgs := strings.Fields(tags)
+	if len(tagArgs) == 0 {
+		return nil, errors.New("tag set cannot be empty.")
+	}
+
+	return storage.Db.UpdateTagSet(name, strings.Join(tagArgs, " "))
+}
+
+// Deletes the specified tag set.
+func (storage *Storage) DeleteTagSet(name string) error {
+	return storage.Db.DeleteTagSet(name)
+}
+
+// unexported
+
+func validateTagSetName(name string) error {
+	if name == "" {
+		return errors.New("tag set name cannot be empty.")
+	}
+
+	if strings.HasPrefix(name, "+") {
+		return errors.New("tag set name cannot start with a plus: '+'.") // used to refer to the set
+	}
+
+	if strings.ContainsAny(name, " \t") {
+		return errors.New("tag set names cannot contain space or tab.")
+	}
+
+	return nil
+}
@@ -20,7 +20,9 @@ package storage
 import (
 	"errors"
 	"fmt"
+	"time"
 	"tmsu/entities"
+	"tmsu/query"
 	"unicode"
 )
 
@@ -49,13 +51,47 @@ func (storage *Storage) UnusedValues() (entities.Values, error) {
 	return storage.Db.UnusedValues()
 }
 
+// Retrieves the values that have been unused since before the specified time.
+func (storage *Storage) UnusedValuesSince(cutoff time.Time) (entities.Values, error) {
+	return storage.Db.UnusedValuesSince(cutoff)
+}
+
+// The number of values that are not applied to any file.
+func (storage *Storage) UnusedValueCount() (uint, error) {
+	return storage.Db.UnusedValueCount()
+}
+
+// Retrieves the values of the specified tag that are applied to the files
+// that match the specified query and are under the specified path.
+func (storage *Storage) ValuesForFileQuery(expression query.Expression, path string, tagId entities.TagId, explicitOnly bool) (entities.Values, error) {
+	expression, err := storage.prepareExpression(expression, explicitOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.Db.ValuesForFileQuery(expression, storage.relPath(path), tagId)
+}
+
 // Retrieves a specific value by name.
 func (storage *Storage) ValueByName(name string) (*entities.Value, error) {
 	if name == "" {
 		return &entities.Value{0, ""}, nil
 	}
 
-	return storage.Db.ValueByName(name)
+	if value, ok := storage.names.value(name); ok {
+		return value, nil
+	}
+
+	value, err := storage.Db.ValueByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if storage.Db.InTransaction() {
+		storage.names.addValue(value)
+	}
+
+	return value, nil
 }
 
 // Retrieves the set of values for the specified tag.
@@ -74,6 +110,7 @@ func (storage *Storage) AddValue(name string) (*entities.Value, error) {
 		return nil, err
 	}
 
+	storage.names.clear()
 	return storage.Db.InsertValue(name)
 }
 
@@ -90,33 +127,36 @@ func (storage *Storage) DeleteValue(valueId entities.ValueId) error {
 		}
 	}
 
+	if err := storage.Db.DeleteImplicationsForValueId(valueId); err != nil {
+		return err
+	}
+
+	storage.names.removeValues(entities.ValueIds{valueId})
 	return storage.Db.DeleteValue(valueId)
 }
 
-// Deletes the value if it is unused.
+// Deletes the value if it is unused. A value that takes part in an
+// implication is retained.
 func (storage *Storage) DeleteValueIfUnused(valueId entities.ValueId) error {
 	if valueId == 0 {
 		return nil
 	}
 
-	count, err := storage.FileTagCountByValueId(valueId)
-	if err != nil {
-		return err
-	}
-	if count == 0 {
-		if err := storage.Db.DeleteValue(valueId); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	storage.names.removeValues(entities.ValueIds{valueId})
+	return storage.Db.DeleteUnusedValues(entities.ValueIds{valueId})
 }
 
 // Deletes unused values.
 func (storage *Storage) DeleteUnusedValues(valueIds entities.ValueIds) error {
+	storage.names.removeValues(valueIds)
 	return storage.Db.DeleteUnusedValues(valueIds)
 }
 
+// Determines whether a name may be used for a value.
+func ValidateValueName(valueName string) error {
+	return validateValueName(valueName)
+}
+
 // unexported
 
 var validValueChars = []*unicode.RangeTable{unicode.Letter, unicode.Number, unicode.Punct, unicode.Symbol}
@@ -0,0 +1,74 @@
+// +build !windows
+
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package vfs
+
+import (
+	"github.com/hanwen/go-fuse/fuse"
+	"sync"
+	"time"
+)
+
+const cacheTimeout = 5 * time.Second
+
+type cacheEntry struct {
+	entries []fuse.DirEntry
+	expires time.Time
+}
+
+// directory listing cache
+type dirCache struct {
+	sync.Mutex
+	timeout time.Duration
+	entries map[string]cacheEntry
+}
+
+func newDirCache(timeout time.Duration) *dirCache {
+	return &dirCache{timeout: timeout, entries: make(map[string]cacheEntry)}
+}
+
+func (cache *dirCache) get(key string) ([]fuse.DirEntry, bool) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	entry, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(cache.entries, key)
+		return nil, false
+	}
+
+	return entry.entries, true
+}
+
+func (cache *dirCache) put(key string, entries []fuse.DirEntry) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	cache.entries[key] = cacheEntry{entries, time.Now().Add(cache.timeout)}
+}
+
+func (cache *dirCache) clear() {
+	cache.Lock()
+	defer cache.Unlock()
+
+	cache.entries = make(map[string]cacheEntry)
+}
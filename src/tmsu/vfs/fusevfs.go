@@ -1,3 +1,6 @@
+//go:build !windows
+// +build !windows
+
 /*
 Copyright 2011-2015 Paul Ruane.
 
@@ -15,8 +18,6 @@ You should have received a copy of the GNU General Public License
 along with this program.  If not, see <http://www.gnu.org/licenses/>.
 */
 
-// +build !windows
-
 package vfs
 
 import (
@@ -26,7 +27,7 @@ import (
 	"github.com/hanwen/go-fuse/fuse/pathfs"
 	"os"
 	"path/filepath"
-	"strconv"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
@@ -34,11 +35,17 @@ import (
 	"tmsu/entities"
 	"tmsu/query"
 	"tmsu/storage"
+	"unicode"
+	"unicode/utf8"
 )
 
 const helpFilename = "README.md"
 
-const tagsDir = "tags"
+const databasePollInterval = time.Second
+
+// The extended attribute of the symbolic links to files that holds their tags.
+const tagsXAttr = "user.tmsu.tags"
+
 const tagsDirHelp = `Tags Directories
 ----------------
 
@@ -53,6 +60,16 @@ Descend the tag directories to hone in on the files you want:
     edam_blanc.14  funghi.11  margherita.7  mushroom  pino_cheddar.12  tomato  wine
     $ ls cheese/tomato
     margherita.7
+
+Tags with values also contain a directory for each value:
+
+    $ ls year
+    2014  2015  cheese  funghi.11  margherita.7
+    $ ls year/2015
+    cheese  margherita.7
+
+(Where a value has the same name as a tag, the value directory is prefixed with
+'=' to distinguish it, e.g. 'year/=2015'.)
     
 The tags directory also allows some operations to be performed:
 
@@ -63,7 +80,6 @@ The tags directory also allows some operations to be performed:
   
 (This file will hide once you have created a few tags.)`
 
-const queriesDir = "queries"
 const queryDirHelp = `Query Directories
 -----------------
 
@@ -91,22 +107,35 @@ type FuseVfs struct {
 	store     *storage.Storage
 	mountPath string
 	server    *fuse.Server
+	pathFs    *pathfs.PathNodeFs
+	tree      *Tree
+	cache     *dirCache
+	settings  mountSettings
+	names     *linkNames
+
+	// The directory beneath which the filesystem is presented, when it shares
+	// a mount with other databases.
+	prefix string
 }
 
-func MountVfs(store *storage.Storage, mountPath string, options []string) (*FuseVfs, error) {
+func MountVfs(store *storage.Storage, mountPath string, options []string) (Vfs, error) {
 	fuseVfs := FuseVfs{}
 	pathFs := pathfs.NewPathNodeFs(&fuseVfs, nil)
 	conn := nodefs.NewFileSystemConnector(pathFs.Root(), nil)
-	mountOptions := &fuse.MountOptions{Options: options}
+
+	settings, fuseOptions, err := parseMountOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	mountOptions := &fuse.MountOptions{Options: fuseOptions, FsName: store.Db.Path, Name: strings.TrimPrefix(fileSystemType, "fuse.")}
 
 	server, err := fuse.NewServer(conn.RawFS(), mountPath, mountOptions)
 	if err != nil {
 		return nil, fmt.Errorf("could not mount virtual filesystem at '%v': %v", mountPath, err)
 	}
 
-	fuseVfs.store = store
-	fuseVfs.mountPath = mountPath
-	fuseVfs.server = server
+	fuseVfs.init(store, mountPath, server, pathFs, settings, "")
 
 	return &fuseVfs, nil
 }
@@ -116,6 +145,11 @@ func (vfs FuseVfs) Unmount() {
 }
 
 func (vfs FuseVfs) Serve() {
+	done := make(chan struct{})
+	defer close(done)
+
+	go vfs.watchDatabase(databasePollInterval, done)
+
 	vfs.server.Serve()
 }
 
@@ -155,12 +189,28 @@ func (vfs FuseVfs) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse
 	log.Infof(2, "BEGIN GetAttr(%v)", name)
 	defer log.Infof(2, "END GetAttr(%v)", name)
 
+	name = vfs.unbucket(name)
+
+	attr, status := vfs.getAttr(name)
+	if attr != nil {
+		if vfs.settings.uid != nil {
+			attr.Uid = *vfs.settings.uid
+		}
+		if vfs.settings.gid != nil {
+			attr.Gid = *vfs.settings.gid
+		}
+	}
+
+	return attr, status
+}
+
+func (vfs FuseVfs) getAttr(name string) (*fuse.Attr, fuse.Status) {
 	switch name {
 	case "":
 		fallthrough
 	case tagsDir:
 		return vfs.getTagsAttr()
-	case queriesDir:
+	case queriesDir, untaggedDir, duplicatesDir, missingDir:
 		return vfs.getQueryAttr()
 	}
 
@@ -171,6 +221,10 @@ func (vfs FuseVfs) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse
 		return vfs.getTaggedEntryAttr(path[1:])
 	case queriesDir:
 		return vfs.getQueryEntryAttr(path[1:])
+	case untaggedDir, missingDir:
+		return vfs.getReportEntryAttr(path[0], path[1:], 1)
+	case duplicatesDir:
+		return vfs.getReportEntryAttr(path[0], path[1:], 2)
 	}
 
 	return nil, fuse.ENOENT
@@ -180,7 +234,19 @@ func (vfs FuseVfs) GetXAttr(name string, attr string, context *fuse.Context) ([]
 	log.Infof(2, "BEGIN GetXAttr(%v, %v)", name, attr)
 	defer log.Infof(2, "END GetAttr(%v, %v)", name, attr)
 
-	return nil, fuse.ENOSYS
+	name = vfs.unbucket(name)
+
+	fileId := vfs.linkFileId(name)
+	if fileId == 0 || attr != tagsXAttr {
+		return nil, fuse.ENOATTR
+	}
+
+	tagValueNames, err := vfs.fileTagValueNames(fileId)
+	if err != nil {
+		log.Fatalf("could not retrieve tags for file #%v: %v", fileId, err)
+	}
+
+	return []byte(strings.Join(tagValueNames, " ")), fuse.OK
 }
 
 func (vfs FuseVfs) Link(oldName string, newName string, context *fuse.Context) fuse.Status {
@@ -194,13 +260,25 @@ func (vfs FuseVfs) ListXAttr(name string, context *fuse.Context) ([]string, fuse
 	log.Infof(2, "BEGIN ListXAttr(%v)", name)
 	defer log.Infof(2, "END ListXAttr(%v)", name)
 
-	return nil, fuse.ENOSYS
+	name = vfs.unbucket(name)
+
+	if vfs.linkFileId(name) == 0 {
+		return []string{}, fuse.OK
+	}
+
+	return []string{tagsXAttr}, fuse.OK
 }
 
 func (vfs FuseVfs) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
 	log.Infof(2, "BEGIN Mkdir(%v)", name)
 	defer log.Infof(2, "END Mkdir(%v)", name)
 
+	name = vfs.unbucket(name)
+
+	if vfs.settings.readOnly {
+		return fuse.EROFS
+	}
+
 	path := vfs.splitPath(name)
 
 	if len(path) != 2 {
@@ -215,6 +293,8 @@ func (vfs FuseVfs) Mkdir(name string, mode uint32, context *fuse.Context) fuse.S
 			log.Fatalf("could not create tag '%v': %v", name, err)
 		}
 
+		vfs.cache.clear()
+
 		return fuse.OK
 	case queriesDir:
 		return fuse.EINVAL
@@ -244,12 +324,14 @@ func (vfs FuseVfs) Open(name string, flags uint32, context *fuse.Context) (nodef
 	log.Infof(2, "BEGIN Open(%v)", name)
 	defer log.Infof(2, "END Open(%v)", name)
 
-    switch name {
-    case filepath.Join(queriesDir, helpFilename):
+	name = vfs.unbucket(name)
+
+	switch name {
+	case filepath.Join(queriesDir, helpFilename):
 		return nodefs.NewDataFile([]byte(queryDirHelp)), fuse.OK
-    case filepath.Join(tagsDir, helpFilename):
+	case filepath.Join(tagsDir, helpFilename):
 		return nodefs.NewDataFile([]byte(tagsDirHelp)), fuse.OK
-    }
+	}
 
 	return nil, fuse.ENOSYS
 }
@@ -258,6 +340,17 @@ func (vfs FuseVfs) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry,
 	log.Infof(2, "BEGIN OpenDir(%v)", name)
 	defer log.Infof(2, "END OpenDir(%v)", name)
 
+	name, bucket := vfs.splitBucket(name)
+
+	entries, status := vfs.openDir(name)
+	if status != fuse.OK {
+		return nil, status
+	}
+
+	return vfs.bucketEntries(name, bucket, entries), fuse.OK
+}
+
+func (vfs FuseVfs) openDir(name string) ([]fuse.DirEntry, fuse.Status) {
 	switch name {
 	case "":
 		return vfs.topDirectories()
@@ -265,6 +358,12 @@ func (vfs FuseVfs) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry,
 		return vfs.tagDirectories()
 	case queriesDir:
 		return vfs.queriesDirectories()
+	case untaggedDir:
+		return vfs.openUntaggedDir()
+	case duplicatesDir:
+		return vfs.openDuplicatesDir()
+	case missingDir:
+		return vfs.openMissingDir()
 	}
 
 	path := vfs.splitPath(name)
@@ -273,6 +372,10 @@ func (vfs FuseVfs) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry,
 		return vfs.openTaggedEntryDir(path[1:])
 	case queriesDir:
 		return vfs.openQueryEntryDir(path[1:])
+	case duplicatesDir:
+		if len(path) == 2 {
+			return vfs.openDuplicateSetDir(path[1])
+		}
 	}
 
 	return nil, fuse.ENOENT
@@ -282,9 +385,11 @@ func (vfs FuseVfs) Readlink(name string, context *fuse.Context) (string, fuse.St
 	log.Infof(2, "BEGIN Readlink(%v)", name)
 	defer log.Infof(2, "END Readlink(%v)", name)
 
+	name = vfs.unbucket(name)
+
 	path := vfs.splitPath(name)
 	switch path[0] {
-	case tagsDir, queriesDir:
+	case tagsDir, queriesDir, untaggedDir, duplicatesDir, missingDir:
 		return vfs.readTaggedEntryLink(path[1:])
 	}
 
@@ -295,13 +400,20 @@ func (vfs FuseVfs) RemoveXAttr(name string, attr string, context *fuse.Context)
 	log.Infof(2, "BEGIN RemoveXAttr(%v, %v)", name, attr)
 	defer log.Infof(2, "END RemoveXAttr(%v, %v)", name, attr)
 
-	return fuse.ENOSYS
+	return vfs.SetXAttr(name, attr, nil, 0, context)
 }
 
 func (vfs FuseVfs) Rename(oldName string, newName string, context *fuse.Context) fuse.Status {
 	log.Infof(2, "BEGIN Rename(%v, %v)", oldName, newName)
 	defer log.Infof(2, "END Rename(%v, %v)", oldName, newName)
 
+	oldName = vfs.unbucket(oldName)
+	newName = vfs.unbucket(newName)
+
+	if vfs.settings.readOnly {
+		return fuse.EROFS
+	}
+
 	oldPath := vfs.splitPath(oldName)
 	newPath := vfs.splitPath(newName)
 
@@ -328,6 +440,8 @@ func (vfs FuseVfs) Rename(oldName string, newName string, context *fuse.Context)
 		log.Fatalf("could not rename tag '%v' to '%v': %v", oldTagName, newTagName, err)
 	}
 
+	vfs.cache.clear()
+
 	return fuse.OK
 }
 
@@ -335,6 +449,12 @@ func (vfs FuseVfs) Rmdir(name string, context *fuse.Context) fuse.Status {
 	log.Infof(2, "BEGIN Rmdir(%v)", name)
 	defer log.Infof(2, "END Rmdir(%v)", name)
 
+	name = vfs.unbucket(name)
+
+	if vfs.settings.readOnly {
+		return fuse.EROFS
+	}
+
 	path := vfs.splitPath(name)
 
 	switch path[0] {
@@ -362,9 +482,15 @@ func (vfs FuseVfs) Rmdir(name string, context *fuse.Context) fuse.Status {
 		}
 
 		if err := vfs.store.DeleteTag(tag.Id); err != nil {
+			if _, ok := err.(storage.TagLockedError); ok {
+				return fuse.EPERM
+			}
+
 			log.Fatalf("could not delete tag '%v': %v", tagName, err)
 		}
 
+		vfs.cache.clear()
+
 		return fuse.OK
 	case queriesDir:
 		if len(path) != 2 {
@@ -388,7 +514,23 @@ func (vfs FuseVfs) SetXAttr(name string, attr string, data []byte, flags int, co
 	log.Infof(2, "BEGIN SetXAttr(%v, %v)", name, attr)
 	defer log.Infof(2, "END SetXAttr(%v, %v)", name, attr)
 
-	return fuse.ENOSYS
+	name = vfs.unbucket(name)
+
+	fileId := vfs.linkFileId(name)
+	if fileId == 0 || attr != tagsXAttr {
+		return fuse.EPERM
+	}
+
+	if vfs.settings.readOnly {
+		return fuse.EROFS
+	}
+
+	status := vfs.retagFile(fileId, strings.Fields(string(data)))
+	if status == fuse.OK {
+		vfs.cache.clear()
+	}
+
+	return status
 }
 
 func (vfs FuseVfs) StatFs(name string) *fuse.StatfsOut {
@@ -420,6 +562,12 @@ func (vfs FuseVfs) Unlink(name string, context *fuse.Context) fuse.Status {
 	log.Infof(2, "BEGIN Unlink(%v)", name)
 	defer log.Infof(2, "END Unlink(%v)", name)
 
+	name = vfs.unbucket(name)
+
+	if vfs.settings.readOnly {
+		return fuse.EROFS
+	}
+
 	fileId := vfs.parseFileId(name)
 	if fileId == 0 {
 		// can only unlink file symbolic links
@@ -438,17 +586,22 @@ func (vfs FuseVfs) Unlink(name string, context *fuse.Context) fuse.Status {
 
 	switch path[0] {
 	case tagsDir:
-		dirName := path[len(path)-2]
+		elements, err := vfs.tree.parseTagPath(path[1 : len(path)-1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if elements == nil {
+			return fuse.ENOENT
+		}
 
-		var tagName, valueName string
-		if dirName[0] == '=' {
-			tagName = path[len(path)-3]
-			valueName = dirName[1:len(dirName)]
-		} else {
-			tagName = dirName
-			valueName = ""
+		lastElement := elements[len(elements)-1]
+		if lastElement.expression != nil {
+			// there is no single tag to remove
+			return fuse.EPERM
 		}
 
+		tagName, valueName := lastElement.tagName, lastElement.valueName
+
 		tag, err := vfs.store.TagByName(tagName)
 		if err != nil {
 			log.Fatal(err)
@@ -466,11 +619,17 @@ func (vfs FuseVfs) Unlink(name string, context *fuse.Context) fuse.Status {
 		}
 
 		if err = vfs.store.DeleteFileTag(fileId, tag.Id, value.Id); err != nil {
+			if _, ok := err.(storage.TagLockedError); ok {
+				return fuse.EPERM
+			}
+
 			log.Fatal(err)
 		}
 
+		vfs.cache.clear()
+
 		return fuse.OK
-	case queriesDir:
+	case queriesDir, untaggedDir, duplicatesDir, missingDir:
 		return fuse.EPERM
 	}
 
@@ -483,27 +642,299 @@ func (vfs FuseVfs) Utimens(name string, Atime *time.Time, Mtime *time.Time, cont
 
 // unexported
 
-func (vfs FuseVfs) splitPath(path string) []string {
-	return strings.Split(path, string(filepath.Separator))
+// Polls the database for changes made outside of the virtual filesystem,
+// invalidating the cached directory listings when the database changes.
+func (vfs FuseVfs) watchDatabase(interval time.Duration, done <-chan struct{}) {
+	lastSeq, err := vfs.store.LatestEventSeq()
+	if err != nil {
+		log.Warnf("could not retrieve latest event: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		seq, err := vfs.store.LatestEventSeq()
+		if err != nil {
+			log.Warnf("could not retrieve latest event: %v", err)
+			continue
+		}
+
+		if seq != lastSeq {
+			log.Infof(2, "database changed: invalidating caches")
+
+			lastSeq = seq
+			vfs.invalidate()
+		}
+	}
 }
 
-func (vfs FuseVfs) parseFileId(name string) entities.FileId {
-	parts := strings.Split(name, ".")
-	count := len(parts)
+func (vfs *FuseVfs) init(store *storage.Storage, mountPath string, server *fuse.Server, pathFs *pathfs.PathNodeFs, settings mountSettings, prefix string) {
+	store.EnableQueryCache()
+
+	vfs.store = store
+	vfs.mountPath = mountPath
+	vfs.server = server
+	vfs.pathFs = pathFs
+	vfs.cache = newDirCache(cacheTimeout)
+	vfs.settings = settings
+	vfs.names = newLinkNames()
+	vfs.prefix = prefix
+	vfs.tree = &Tree{store, vfs.getLinkName, vfs.parseFileId, settings.minFiles, settings.queryNames}
+}
+
+func (vfs FuseVfs) invalidate() {
+	vfs.cache.clear()
+
+	for _, dirName := range []string{tagsDir, queriesDir, untaggedDir, duplicatesDir, missingDir} {
+		// the directory may not have been looked up yet
+		vfs.pathFs.Notify(filepath.Join(vfs.prefix, dirName))
+	}
+}
 
-	if count == 1 {
+// Identifies the file a symbolic link within the virtual filesystem refers
+// to, or zero if the name is not that of such a link.
+func (vfs FuseVfs) linkFileId(name string) entities.FileId {
+	attr, status := vfs.getAttr(name)
+	if status != fuse.OK || attr.Mode&syscall.S_IFMT != syscall.S_IFLNK {
 		return 0
 	}
 
-	id, err := asciiToFileId(parts[count-2])
+	return vfs.parseFileId(filepath.Base(name))
+}
+
+// The explicit tags of the file, as TAG or TAG=VALUE, in name order.
+func (vfs FuseVfs) fileTagValueNames(fileId entities.FileId) ([]string, error) {
+	fileTags, err := vfs.store.FileTagsByFileId(fileId, true)
+	if err != nil {
+		return nil, err
+	}
+
+	tagValueNames := make([]string, 0, len(fileTags))
+	for _, fileTag := range fileTags {
+		tag, err := vfs.store.Tag(fileTag.TagId)
+		if err != nil {
+			return nil, err
+		}
+
+		tagValueName := tag.Name
+		if fileTag.ValueId != 0 {
+			value, err := vfs.store.Value(fileTag.ValueId)
+			if err != nil {
+				return nil, err
+			}
+
+			tagValueName += "=" + value.Name
+		}
+
+		tagValueNames = append(tagValueNames, tagValueName)
+	}
+
+	sort.Strings(tagValueNames)
+
+	return tagValueNames, nil
+}
+
+// Replaces the explicit tags of the file with those specified, as TAG or
+// TAG=VALUE, creating the tags and values as permitted by the
+// 'autoCreateTags' and 'autoCreateValues' settings.
+func (vfs FuseVfs) retagFile(fileId entities.FileId, tagValueNames []string) fuse.Status {
+	pairs := make([]entities.TagValuePair, 0, len(tagValueNames))
+	for _, tagValueName := range tagValueNames {
+		pair, status := vfs.tagValuePair(tagValueName)
+		if status != fuse.OK {
+			return status
+		}
+
+		pairs = append(pairs, *pair)
+	}
+
+	fileTags, err := vfs.store.FileTagsByFileId(fileId, true)
+	if err != nil {
+		log.Fatalf("could not retrieve tags for file #%v: %v", fileId, err)
+	}
+
+	for _, pair := range pairs {
+		if fileTags.Contains(pair.TagId, pair.ValueId) {
+			continue
+		}
+
+		if _, err := vfs.store.AddFileTag(fileId, pair.TagId, pair.ValueId); err != nil {
+			return tagErrorStatus(err)
+		}
+	}
+
+	for _, fileTag := range fileTags {
+		if containsTagValuePair(pairs, fileTag.TagId, fileTag.ValueId) {
+			continue
+		}
+
+		if err := vfs.store.DeleteFileTag(fileId, fileTag.TagId, fileTag.ValueId); err != nil {
+			return tagErrorStatus(err)
+		}
+	}
+
+	return fuse.OK
+}
+
+func (vfs FuseVfs) tagValuePair(tagValueName string) (*entities.TagValuePair, fuse.Status) {
+	tagName, valueName := tagValueName, ""
+	if index := strings.Index(tagValueName, "="); index != -1 {
+		tagName, valueName = tagValueName[:index], tagValueName[index+1:]
+	}
+
+	tag, err := vfs.store.TagByName(tagName)
+	if err != nil {
+		log.Fatalf("could not retrieve tag '%v': %v", tagName, err)
+	}
+	if tag == nil {
+		autoCreateTags, err := vfs.store.SettingAsBool("autoCreateTags")
+		if err != nil {
+			log.Fatalf("could not retrieve setting 'autoCreateTags': %v", err)
+		}
+		if !autoCreateTags {
+			return nil, fuse.ENOENT
+		}
+
+		tag, err = vfs.store.AddTag(tagName)
+		if err != nil {
+			return nil, fuse.EINVAL
+		}
+	}
+
+	value, err := vfs.store.ValueByName(valueName)
 	if err != nil {
-		id, err = asciiToFileId(parts[count-1])
+		log.Fatalf("could not retrieve value '%v': %v", valueName, err)
+	}
+	if value == nil {
+		autoCreateValues, err := vfs.store.SettingAsBool("autoCreateValues")
 		if err != nil {
-			return 0
+			log.Fatalf("could not retrieve setting 'autoCreateValues': %v", err)
+		}
+		if !autoCreateValues {
+			return nil, fuse.ENOENT
+		}
+
+		value, err = vfs.store.AddValue(valueName)
+		if err != nil {
+			return nil, fuse.EINVAL
 		}
 	}
 
-	return entities.FileId(id)
+	return &entities.TagValuePair{tag.Id, value.Id}, fuse.OK
+}
+
+// Removes the bucket directories, which group the entries of large tag
+// directories by initial, from a path.
+func (vfs FuseVfs) unbucket(name string) string {
+	name, _ = vfs.splitBucket(name)
+	return name
+}
+
+// Removes the bucket directories from a path, also identifying the bucket
+// the path ends with, if any.
+func (vfs FuseVfs) splitBucket(name string) (string, string) {
+	if vfs.settings.bucketSize == 0 {
+		return name, ""
+	}
+
+	path := vfs.splitPath(name)
+	if path[0] != tagsDir {
+		return name, ""
+	}
+
+	unbucketed := make([]string, 0, len(path))
+	bucket := ""
+	for _, element := range path {
+		if key, ok := parseBucketName(element); ok {
+			bucket = key
+			continue
+		}
+
+		unbucketed = append(unbucketed, element)
+		bucket = ""
+	}
+
+	return filepath.Join(unbucketed...), bucket
+}
+
+// Groups the entries of a tag directory larger than the bucket size into a
+// directory per initial or, for a bucket, lists those entries in it.
+func (vfs FuseVfs) bucketEntries(name, bucket string, entries []fuse.DirEntry) []fuse.DirEntry {
+	if vfs.settings.bucketSize == 0 || vfs.splitPath(name)[0] != tagsDir {
+		return entries
+	}
+
+	if bucket != "" {
+		bucketed := make([]fuse.DirEntry, 0, len(entries))
+		for _, entry := range entries {
+			if bucketKey(entry.Name) == bucket {
+				bucketed = append(bucketed, entry)
+			}
+		}
+
+		return bucketed
+	}
+
+	if uint(len(entries)) <= vfs.settings.bucketSize {
+		return entries
+	}
+
+	keys := make(map[string]bool)
+	buckets := make([]fuse.DirEntry, 0, 36)
+	for _, entry := range entries {
+		key := bucketKey(entry.Name)
+		if !keys[key] {
+			keys[key] = true
+			buckets = append(buckets, fuse.DirEntry{Name: bucketName(key), Mode: fuse.S_IFDIR})
+		}
+	}
+
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Name < buckets[j].Name })
+
+	return buckets
+}
+
+func (vfs FuseVfs) splitPath(path string) []string {
+	return strings.Split(path, string(filepath.Separator))
+}
+
+func (vfs FuseVfs) parseFileId(name string) entities.FileId {
+	switch vfs.settings.naming {
+	case hashNaming:
+		return vfs.names.fileId(filepath.Base(name))
+	case pathNaming:
+		return vfs.parsePathLinkName(filepath.Base(name))
+	}
+
+	return parseIdLinkName(name)
+}
+
+func (vfs FuseVfs) parsePathLinkName(name string) entities.FileId {
+	if fileId := vfs.names.fileId(name); fileId != 0 {
+		return fileId
+	}
+
+	path := unescapeLinkPath(name)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(vfs.store.RootPath, path)
+	}
+
+	file, err := vfs.store.FileByPath(path)
+	if err != nil {
+		log.Fatalf("could not retrieve file '%v': %v", path, err)
+	}
+	if file == nil {
+		return 0
+	}
+
+	return file.Id
 }
 
 func (vfs FuseVfs) topDirectories() ([]fuse.DirEntry, fuse.Status) {
@@ -511,7 +942,10 @@ func (vfs FuseVfs) topDirectories() ([]fuse.DirEntry, fuse.Status) {
 	defer log.Infof(2, "END topDirectories")
 
 	entries := []fuse.DirEntry{fuse.DirEntry{Name: tagsDir, Mode: fuse.S_IFDIR},
-		fuse.DirEntry{Name: queriesDir, Mode: fuse.S_IFDIR}}
+		fuse.DirEntry{Name: queriesDir, Mode: fuse.S_IFDIR},
+		fuse.DirEntry{Name: untaggedDir, Mode: fuse.S_IFDIR},
+		fuse.DirEntry{Name: duplicatesDir, Mode: fuse.S_IFDIR},
+		fuse.DirEntry{Name: missingDir, Mode: fuse.S_IFDIR}}
 	return entries, fuse.OK
 }
 
@@ -524,14 +958,24 @@ func (vfs FuseVfs) tagDirectories() ([]fuse.DirEntry, fuse.Status) {
 		log.Fatalf("Could not retrieve tags: %v", err)
 	}
 
-	entries := make([]fuse.DirEntry, len(tags))
+	tagNames := make([]string, len(tags))
 	for index, tag := range tags {
-		entries[index] = fuse.DirEntry{Name: tag.Name, Mode: fuse.S_IFDIR}
+		tagNames[index] = tag.Name
+	}
+
+	tagNames, err = vfs.tree.frequentTagNames(query.EmptyExpression{}, tagNames)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	entries := make([]fuse.DirEntry, len(tagNames))
+	for index, tagName := range tagNames {
+		entries[index] = fuse.DirEntry{Name: tagName, Mode: fuse.S_IFDIR}
 	}
 
 	if len(tags) < 5 {
-	    entries = append(entries, fuse.DirEntry{Name: helpFilename, Mode: fuse.S_IFREG})
-    }
+		entries = append(entries, fuse.DirEntry{Name: helpFilename, Mode: fuse.S_IFREG})
+	}
 
 	return entries, fuse.OK
 }
@@ -587,26 +1031,19 @@ func (vfs FuseVfs) getTaggedEntryAttr(path []string) (*fuse.Attr, fuse.Status) {
 		return &fuse.Attr{Mode: fuse.S_IFREG | 0444, Nlink: 1, Size: uint64(len(tagsDirHelp)), Mtime: uint64(now.Unix()), Mtimensec: uint32(now.Nanosecond())}, fuse.OK
 	}
 
-	name := path[len(path)-1]
-
-	fileId := vfs.parseFileId(name)
-	if fileId != 0 {
-		return vfs.getFileEntryAttr(fileId)
+	// tag or value directory
+	elements, err := vfs.tree.parseTagPath(path)
+	if err != nil {
+		log.Fatalf("could not parse path: %v.", err)
 	}
+	if elements == nil {
+		name := path[len(path)-1]
 
-	tagNames := make([]string, 0, len(path))
-	for _, pathElement := range path {
-		if pathElement[0] != '=' {
-			tagNames = append(tagNames, pathElement)
+		fileId := vfs.parseFileId(name)
+		if fileId != 0 {
+			return vfs.getFileEntryAttr(fileId)
 		}
-	}
 
-	// tag directory
-	tagIds, err := vfs.tagNamesToIds(tagNames)
-	if err != nil {
-		log.Fatalf("could not lookup tag IDs: %v.", err)
-	}
-	if tagIds == nil {
 		return nil, fuse.ENOENT
 	}
 
@@ -641,7 +1078,7 @@ func (vfs FuseVfs) getQueryEntryAttr(path []string) (*fuse.Attr, fuse.Status) {
 		return nil, fuse.ENOENT
 	}
 
-	expression, err := query.Parse(queryText)
+	expression, err := vfs.store.ParseQuery(queryText)
 	if err != nil {
 		return nil, fuse.ENOENT
 	}
@@ -658,7 +1095,7 @@ func (vfs FuseVfs) getQueryEntryAttr(path []string) (*fuse.Attr, fuse.Status) {
 	if err != nil {
 		log.Fatalf("could not retrieve query '%v': %v", queryText, err)
 	}
-	if q == nil {
+	if q == nil && !vfs.settings.readOnly {
 		_, err = vfs.store.AddQuery(queryText)
 		if err != nil {
 			log.Fatalf("could not add query '%v': %v", queryText, err)
@@ -669,6 +1106,26 @@ func (vfs FuseVfs) getQueryEntryAttr(path []string) (*fuse.Attr, fuse.Status) {
 	return &fuse.Attr{Mode: fuse.S_IFDIR | 0755, Nlink: 2, Size: uint64(0), Mtime: uint64(now.Unix()), Mtimensec: uint32(now.Nanosecond())}, fuse.OK
 }
 
+func (vfs FuseVfs) getReportEntryAttr(dirName string, path []string, depth int) (*fuse.Attr, fuse.Status) {
+	log.Infof(2, "BEGIN getReportEntryAttr(%v, %v)", dirName, path)
+	defer log.Infof(2, "END getReportEntryAttr(%v, %v)", dirName, path)
+
+	if len(path) < depth {
+		// duplicate set directory
+		return vfs.getQueryAttr()
+	}
+	if len(path) > depth {
+		return nil, fuse.ENOENT
+	}
+
+	fileId := vfs.parseFileId(path[len(path)-1])
+	if fileId == 0 {
+		return nil, fuse.ENOENT
+	}
+
+	return vfs.getFileEntryAttr(fileId)
+}
+
 func (vfs FuseVfs) getFileEntryAttr(fileId entities.FileId) (*fuse.Attr, fuse.Status) {
 	file, err := vfs.store.File(fileId)
 	if err != nil {
@@ -678,98 +1135,86 @@ func (vfs FuseVfs) getFileEntryAttr(fileId entities.FileId) (*fuse.Attr, fuse.St
 		return &fuse.Attr{Mode: fuse.S_IFREG}, fuse.ENOENT
 	}
 
+	if vfs.settings.stat == databaseStat {
+		return &fuse.Attr{Mode: fuse.S_IFLNK | 0755, Size: uint64(file.Size), Mtime: uint64(file.ModTime.Unix()), Mtimensec: uint32(file.ModTime.Nanosecond())}, fuse.OK
+	}
+
 	fileInfo, err := os.Stat(file.Path())
-	var size int64
-	var modTime time.Time
-	if err == nil {
-		size = fileInfo.Size()
-		modTime = fileInfo.ModTime()
-	} else {
-		size = 0
-		modTime = time.Time{}
+	if err != nil {
+		// missing files are still listed
+		return &fuse.Attr{Mode: fuse.S_IFLNK | 0755}, fuse.OK
 	}
 
-	return &fuse.Attr{Mode: fuse.S_IFLNK | 0755, Size: uint64(size), Mtime: uint64(modTime.Unix()), Mtimensec: uint32(modTime.Nanosecond())}, fuse.OK
+	return targetAttr(fileInfo), fuse.OK
 }
 
 func (vfs FuseVfs) openTaggedEntryDir(path []string) ([]fuse.DirEntry, fuse.Status) {
 	log.Infof(2, "BEGIN openTaggedEntryDir(%v)", path)
 	defer log.Infof(2, "END openTaggedEntryDir(%v)", path)
 
-	expression := pathToExpression(path)
-	files, err := vfs.store.QueryFiles(expression, "", false)
-	if err != nil {
-		log.Fatalf("could not query files: %v", err)
-	}
-
-	lastPathElement := path[len(path)-1]
-
-	var valueNames []string
-	if lastPathElement[0] != '=' {
-		tagName := lastPathElement
+	return vfs.openCachedDir(append([]string{tagsDir}, path...))
+}
 
-		valueNames, err = vfs.tagValueNamesForFiles(tagName, files)
-		if err != nil {
-			log.Fatalf("could not retrieve values for '%v': %v", err)
-		}
-	} else {
-		valueNames = []string{}
-	}
+func (vfs FuseVfs) openQueryEntryDir(path []string) ([]fuse.DirEntry, fuse.Status) {
+	log.Infof(2, "BEGIN openQueryEntryDir(%v)", path)
+	defer log.Infof(2, "END openQueryEntryDir(%v)", path)
 
-	furtherTagNames, err := vfs.tagNamesForFiles(files)
+	nodes, err := vfs.tree.listQuery(path[0])
 	if err != nil {
-		log.Fatalf("could not retrieve further tags: %v", err)
+		log.Fatal(err)
 	}
-
-	entries := make([]fuse.DirEntry, 0, len(files)+len(furtherTagNames))
-	for _, tagName := range furtherTagNames {
-		if !containsString(path, tagName) {
-			entries = append(entries, fuse.DirEntry{Name: tagName, Mode: fuse.S_IFDIR | 0755})
-		}
+	if nodes == nil {
+		return nil, fuse.ENOENT
 	}
 
-	for _, valueName := range valueNames {
-		entries = append(entries, fuse.DirEntry{Name: "=" + valueName, Mode: fuse.S_IFDIR | 0755})
-	}
+	return nodesToEntries(nodes), fuse.OK
+}
 
-	for _, file := range files {
-		linkName := vfs.getLinkName(file)
-		entries = append(entries, fuse.DirEntry{Name: linkName, Mode: fuse.S_IFLNK})
-	}
+func (vfs FuseVfs) openUntaggedDir() ([]fuse.DirEntry, fuse.Status) {
+	log.Infof(2, "BEGIN openUntaggedDir")
+	defer log.Infof(2, "END openUntaggedDir")
 
-	return entries, fuse.OK
+	return vfs.openCachedDir([]string{untaggedDir})
 }
 
-func (vfs FuseVfs) openQueryEntryDir(path []string) ([]fuse.DirEntry, fuse.Status) {
-	log.Infof(2, "BEGIN openQueryEntryDir(%v)", path)
-	defer log.Infof(2, "END openQueryEntryDir(%v)", path)
+func (vfs FuseVfs) openDuplicatesDir() ([]fuse.DirEntry, fuse.Status) {
+	log.Infof(2, "BEGIN openDuplicatesDir")
+	defer log.Infof(2, "END openDuplicatesDir")
 
-	queryText := path[0]
+	return vfs.openCachedDir([]string{duplicatesDir})
+}
 
-	expression, err := query.Parse(queryText)
-	if err != nil {
-		return nil, fuse.ENOENT
-	}
+func (vfs FuseVfs) openDuplicateSetDir(name string) ([]fuse.DirEntry, fuse.Status) {
+	log.Infof(2, "BEGIN openDuplicateSetDir(%v)", name)
+	defer log.Infof(2, "END openDuplicateSetDir(%v)", name)
 
-	tagNames := query.TagNames(expression)
-	tags, err := vfs.store.TagsByNames(tagNames)
-	for _, tagName := range tagNames {
-		if !containsTag(tags, tagName) {
-			return nil, fuse.ENOENT
-		}
+	return vfs.openCachedDir([]string{duplicatesDir, name})
+}
+
+func (vfs FuseVfs) openMissingDir() ([]fuse.DirEntry, fuse.Status) {
+	log.Infof(2, "BEGIN openMissingDir")
+	defer log.Infof(2, "END openMissingDir")
+
+	return vfs.openCachedDir([]string{missingDir})
+}
+
+func (vfs FuseVfs) openCachedDir(path []string) ([]fuse.DirEntry, fuse.Status) {
+	cacheKey := filepath.Join(path...)
+	if entries, ok := vfs.cache.get(cacheKey); ok {
+		return entries, fuse.OK
 	}
 
-	files, err := vfs.store.QueryFiles(expression, "", false)
+	nodes, err := vfs.tree.List(path)
 	if err != nil {
-		log.Fatalf("could not query files: %v", err)
+		log.Fatal(err)
 	}
-
-	entries := make([]fuse.DirEntry, 0, len(files))
-	for _, file := range files {
-		linkName := vfs.getLinkName(file)
-		entries = append(entries, fuse.DirEntry{Name: linkName, Mode: fuse.S_IFLNK})
+	if nodes == nil {
+		return nil, fuse.ENOENT
 	}
 
+	entries := nodesToEntries(nodes)
+	vfs.cache.put(cacheKey, entries)
+
 	return entries, fuse.OK
 }
 
@@ -793,16 +1238,34 @@ func (vfs FuseVfs) readTaggedEntryLink(path []string) (string, fuse.Status) {
 }
 
 func (vfs FuseVfs) getLinkName(file *entities.File) string {
-	extension := filepath.Ext(file.Path())
-	fileName := filepath.Base(file.Path())
-	linkName := fileName[0 : len(fileName)-len(extension)]
-	suffix := "." + fileIdToAscii(file.Id) + extension
+	var linkName string
+
+	switch vfs.settings.naming {
+	case hashNaming:
+		linkName = hashLinkName(file)
+	case pathNaming:
+		linkName = vfs.pathLinkName(file)
+	default:
+		return idLinkName(file)
+	}
+
+	if !vfs.names.register(linkName, file.Id) {
+		// name collision: fall back to the file identifier
+		linkName = idLinkName(file)
+		vfs.names.register(linkName, file.Id)
+	}
+
+	return linkName
+}
+
+func (vfs FuseVfs) pathLinkName(file *entities.File) string {
+	path := file.Path()
 
-	if len(linkName)+len(suffix) > 255 {
-		linkName = linkName[0 : 255-len(suffix)]
+	if relPath, err := filepath.Rel(vfs.store.RootPath, path); err == nil && !strings.HasPrefix(relPath, "..") {
+		path = relPath
 	}
 
-	return linkName + suffix
+	return escapeLinkPath(path)
 }
 
 func (vfs FuseVfs) tagNamesToIds(tagNames []string) (entities.TagIds, error) {
@@ -823,119 +1286,104 @@ func (vfs FuseVfs) tagNamesToIds(tagNames []string) (entities.TagIds, error) {
 	return tagIds, nil
 }
 
-func (vfs FuseVfs) tagValueNamesForFiles(tagName string, files entities.Files) ([]string, error) {
-	tag, err := vfs.store.TagByName(tagName)
-	if err != nil {
-		log.Fatalf("could not look up tag '%v': %v", tagName, err)
+// The attributes of a symbolic link reporting those of its target, so that
+// tools such as 'du' and media players see the size, modification time,
+// permissions and owner of the file linked to.
+func targetAttr(fileInfo os.FileInfo) *fuse.Attr {
+	modTime := fileInfo.ModTime()
+	attr := fuse.Attr{Mode: fuse.S_IFLNK | uint32(fileInfo.Mode().Perm()),
+		Size:      uint64(fileInfo.Size()),
+		Mtime:     uint64(modTime.Unix()),
+		Mtimensec: uint32(modTime.Nanosecond())}
+
+	if stat, ok := fileInfo.Sys().(*syscall.Stat_t); ok {
+		attr.Blocks = uint64(stat.Blocks)
+		attr.Uid = stat.Uid
+		attr.Gid = stat.Gid
 	}
-	if tag == nil {
-		return []string{}, nil
-	}
-
-	valueNames := make([]string, 0, 10)
-
-	for _, file := range files {
-		fileTags, err := vfs.store.FileTagsByFileId(file.Id, false)
-		if err != nil {
-			return nil, fmt.Errorf("could not retrieve file-tags for file '%v': %v", file.Id, err)
-		}
 
-		valueIds := make(entities.ValueIds, len(fileTags))
-		for index, fileTag := range fileTags {
-			if fileTag.TagId == tag.Id {
-				valueIds[index] = fileTag.ValueId
-			}
-		}
-
-		values, err := vfs.store.ValuesByIds(valueIds)
-		if err != nil {
-			return nil, fmt.Errorf("could not retrieve values: %v", err)
-		}
+	return &attr
+}
 
-		for _, value := range values {
-			valueNames = append(valueNames, value.Name)
+func containsTagValuePair(pairs []entities.TagValuePair, tagId entities.TagId, valueId entities.ValueId) bool {
+	for _, pair := range pairs {
+		if pair.TagId == tagId && pair.ValueId == valueId {
+			return true
 		}
 	}
 
-	return valueNames, nil
+	return false
 }
 
-func (vfs FuseVfs) tagNamesForFiles(files entities.Files) ([]string, error) {
-	tagNames := make([]string, 0, 10)
-
-	for _, file := range files {
-		fileTags, err := vfs.store.FileTagsByFileId(file.Id, false)
-		if err != nil {
-			return nil, fmt.Errorf("could not retrieve file-tags for file '%v': %v", file.Id, err)
-		}
-
-		tagIds := make(entities.TagIds, len(fileTags))
-		for index, fileTag := range fileTags {
-			tagIds[index] = fileTag.TagId
-		}
-
-		tags, err := vfs.store.TagsByIds(tagIds)
-		if err != nil {
-			return nil, fmt.Errorf("could not retrieve tags: %v", err)
-		}
-
-		for _, tag := range tags {
-			if !containsString(tagNames, tag.Name) {
-				tagNames = append(tagNames, tag.Name)
-			}
-		}
+// Tags that are locked or limits that would be exceeded refuse the change;
+// other errors are fatal.
+func tagErrorStatus(err error) fuse.Status {
+	switch err.(type) {
+	case storage.TagLockedError, storage.TagQuotaError, storage.FileQuotaError:
+		return fuse.EPERM
 	}
 
-	return tagNames, nil
+	log.Fatal(err)
+	return fuse.EIO
 }
 
-func pathToExpression(path []string) query.Expression {
-	var expression query.Expression = query.EmptyExpression{}
-
-	for index, stone := range path {
-		var stoneExpression query.Expression
-
-		if stone[0] == '=' {
-			tagName := path[index-1]
-			valueName := stone[1:len(stone)]
-
-			stoneExpression = query.ComparisonExpression{query.TagExpression{tagName}, "==", query.ValueExpression{valueName}}
-		} else {
-			tagName := stone
-			stoneExpression = query.TagExpression{tagName}
+// The bucket of an entry: its lower-cased initial if a letter or digit,
+// otherwise '#'.
+func bucketKey(name string) string {
+	for _, char := range name {
+		if unicode.IsLetter(char) || unicode.IsDigit(char) {
+			return string(unicode.ToLower(char))
 		}
 
-		expression = query.AndExpression{expression, stoneExpression}
+		break
 	}
 
-	return expression
+	return "#"
 }
 
-func fileIdToAscii(fileId entities.FileId) string {
-	return strconv.FormatUint(uint64(fileId), 10)
+// Buckets are named in parentheses, e.g. '(a)', which tag names cannot
+// contain.
+func bucketName(key string) string {
+	return "(" + key + ")"
 }
 
-func asciiToFileId(str string) (entities.FileId, error) {
-	ui64, err := strconv.ParseUint(str, 10, 0)
-	return entities.FileId(ui64), err
+func parseBucketName(name string) (string, bool) {
+	if len(name) < 3 || name[0] != '(' || name[len(name)-1] != ')' {
+		return "", false
+	}
+
+	key := name[1 : len(name)-1]
+	if utf8.RuneCountInString(key) != 1 {
+		return "", false
+	}
+
+	return key, true
 }
 
-func containsTag(tags entities.Tags, tagName string) bool {
-	for _, tag := range tags {
-		if tag.Name == tagName {
-			return true
-		}
+func hashLinkName(file *entities.File) string {
+	fingerprint := string(file.Fingerprint)
+	if len(fingerprint) < hashPrefixLength {
+		return idLinkName(file)
 	}
 
-	return false
+	extension := filepath.Ext(file.Path())
+	fileName := filepath.Base(file.Path())
+	linkName := fileName[0 : len(fileName)-len(extension)]
+	suffix := "." + fingerprint[0:hashPrefixLength] + extension
+
+	return truncateLinkName(linkName, suffix)
 }
 
-func containsString(values []string, value string) bool {
-	for _, v := range values {
-		if v == value {
-			return true
+func nodesToEntries(nodes Nodes) []fuse.DirEntry {
+	entries := make([]fuse.DirEntry, len(nodes))
+	for index, node := range nodes {
+		switch node.Type {
+		case DirectoryNode:
+			entries[index] = fuse.DirEntry{Name: node.Name, Mode: fuse.S_IFDIR | 0755}
+		case LinkNode:
+			entries[index] = fuse.DirEntry{Name: node.Name, Mode: fuse.S_IFLNK}
 		}
 	}
 
-	return false
+	return entries
 }
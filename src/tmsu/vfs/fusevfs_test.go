@@ -0,0 +1,499 @@
+// +build !windows
+
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package vfs
+
+import (
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+	"tmsu/storage"
+)
+
+func TestFuseVfsValueDirectories(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	addTaggedFile(test, store, "/tmp/tmsu/a.mp3", "", "year=2014")
+
+	vfs := newTestVfs(test, store)
+
+	// test
+
+	valueAttr, valueStatus := vfs.GetAttr("tags/year/2014", nil)
+	missingAttr, missingStatus := vfs.GetAttr("tags/year/2015", nil)
+	linkPath, linkStatus := vfs.Readlink("tags/year/2014/a.1.mp3", nil)
+
+	// validate
+
+	if valueStatus != fuse.OK || !valueAttr.IsDir() {
+		test.Fatalf("Expected a value directory but was %v, %v.", valueAttr, valueStatus)
+	}
+	if missingStatus != fuse.ENOENT || missingAttr != nil {
+		test.Fatalf("Expected no directory for an unused value but was %v, %v.", missingAttr, missingStatus)
+	}
+	if linkStatus != fuse.OK || linkPath != "/tmp/tmsu/a.mp3" {
+		test.Fatalf("Expected a link to the file but was '%v', %v.", linkPath, linkStatus)
+	}
+}
+
+func TestFuseVfsCachesListings(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	addTaggedFile(test, store, "/tmp/tmsu/a.mp3", "", "music")
+
+	vfs := newTestVfs(test, store)
+	expectEntries(test, vfs, "tags/music", "a.1.mp3")
+
+	// test
+
+	addTaggedFile(test, store, "/tmp/tmsu/b.mp3", "", "music")
+	cachedEntries := entryNames(openTestDir(test, vfs, "tags/music"))
+
+	if status := vfs.Mkdir("tags/jazz", 0755, nil); status != fuse.OK {
+		test.Fatalf("Could not create tag: %v.", status)
+	}
+
+	// validate
+
+	if expected := []string{"a.1.mp3"}; !reflect.DeepEqual(cachedEntries, expected) {
+		test.Fatalf("Expected the cached entries %v but were %v.", expected, cachedEntries)
+	}
+
+	// changes through the virtual filesystem clear the cache
+	expectEntries(test, vfs, "tags/music", "a.1.mp3", "b.2.mp3")
+}
+
+func TestFuseVfsWatchDatabaseInvalidatesCache(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	addTaggedFile(test, store, "/tmp/tmsu/a.mp3", "", "music")
+
+	vfs := newTestVfs(test, store)
+	expectEntries(test, vfs, "tags/music", "a.1.mp3")
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go vfs.watchDatabase(10*time.Millisecond, done)
+
+	// let the watcher note the latest event before the change
+	time.Sleep(50 * time.Millisecond)
+
+	// another process changes the database
+	otherStore, err := storage.OpenAt(store.Db.Path)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer otherStore.Close()
+
+	addTaggedFile(test, otherStore, "/tmp/tmsu/b.mp3", "", "music")
+
+	// test
+
+	var names []string
+	for attempt := 0; attempt < 100; attempt++ {
+		names = entryNames(openTestDir(test, vfs, "tags/music"))
+		if len(names) == 2 {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// validate
+
+	if expected := []string{"a.1.mp3", "b.2.mp3"}; !reflect.DeepEqual(names, expected) {
+		test.Fatalf("Expected the listing to be refreshed to %v but was %v.", expected, names)
+	}
+}
+
+func TestFuseVfsReportDirectories(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	addTaggedFile(test, store, "/tmp/tmsu/a.mp3", "same")
+	addTaggedFile(test, store, "/tmp/tmsu/b.mp3", "same", "music")
+
+	vfs := newTestVfs(test, store)
+
+	// test & validate
+
+	expectEntries(test, vfs, "", tagsDir, queriesDir, untaggedDir, duplicatesDir, missingDir)
+	expectEntries(test, vfs, untaggedDir, "a.1.mp3")
+	expectEntries(test, vfs, duplicatesDir, "same")
+	expectEntries(test, vfs, missingDir, "a.1.mp3", "b.2.mp3")
+
+	attr, status := vfs.GetAttr("duplicates/same/b.2.mp3", nil)
+	if status != fuse.OK || !attr.IsSymlink() {
+		test.Fatalf("Expected a link but was %v, %v.", attr, status)
+	}
+
+	linkPath, status := vfs.Readlink("untagged/a.1.mp3", nil)
+	if status != fuse.OK || linkPath != "/tmp/tmsu/a.mp3" {
+		test.Fatalf("Expected a link to the file but was '%v', %v.", linkPath, status)
+	}
+}
+
+func TestFuseVfsLinkNames(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	addTaggedFile(test, store, "/tmp/tmsu/one/song.mp3", "0123456789abcdef", "music")
+	addTaggedFile(test, store, "/tmp/tmsu/two/song.mp3", "0123456789abcdef", "music")
+	addTaggedFile(test, store, "/tmp/tmsu/two/other.mp3", "fedcba9876543210", "music")
+
+	expectations := map[string][]string{
+		"names=id":     {"song.1.mp3", "other.3.mp3", "song.2.mp3"},
+		"names=hash":   {"song.01234567.mp3", "other.fedcba98.mp3", "song.2.mp3"},
+		"names=mirror": {"tmp%2Ftmsu%2Fone%2Fsong.mp3", "tmp%2Ftmsu%2Ftwo%2Fother.mp3", "tmp%2Ftmsu%2Ftwo%2Fsong.mp3"},
+	}
+
+	// files are listed in path order and the hash collision falls back to the
+	// identifier
+	for option, expected := range expectations {
+		vfs := newTestVfs(test, store, option)
+
+		// test
+
+		names := entryNames(openTestDir(test, vfs, "tags/music"))
+
+		// validate
+
+		if !reflect.DeepEqual(names, expected) {
+			test.Fatalf("%v: expected %v but were %v.", option, expected, names)
+		}
+
+		for index, path := range []string{"/tmp/tmsu/one/song.mp3", "/tmp/tmsu/two/other.mp3", "/tmp/tmsu/two/song.mp3"} {
+			linkPath, status := vfs.Readlink("tags/music/"+names[index], nil)
+			if status != fuse.OK || linkPath != path {
+				test.Fatalf("%v: expected '%v' to link to '%v' but was '%v', %v.", option, names[index], path, linkPath, status)
+			}
+		}
+	}
+}
+
+func TestFuseVfsReadOnly(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	addTaggedFile(test, store, "/tmp/tmsu/a.mp3", "", "music")
+
+	vfs := newTestVfs(test, store, "ro")
+
+	// test
+
+	statuses := map[string]fuse.Status{
+		"Mkdir":    vfs.Mkdir("tags/jazz", 0755, nil),
+		"Rename":   vfs.Rename("tags/music", "tags/tunes", nil),
+		"Rmdir":    vfs.Rmdir("tags/music", nil),
+		"Unlink":   vfs.Unlink("tags/music/a.1.mp3", nil),
+		"SetXAttr": vfs.SetXAttr("tags/music/a.1.mp3", tagsXAttr, []byte("jazz"), 0, nil),
+	}
+
+	// validate
+
+	for operation, status := range statuses {
+		if status != fuse.EROFS {
+			test.Fatalf("Expected %v to fail with EROFS but was %v.", operation, status)
+		}
+	}
+
+	expectEntries(test, vfs, "tags/music", "a.1.mp3")
+
+	tagCount, err := store.TagCount()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if tagCount != 1 {
+		test.Fatalf("Expected the tags to be unchanged but there were %v.", tagCount)
+	}
+}
+
+func TestFuseVfsOwner(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	addTaggedFile(test, store, "/tmp/tmsu/a.mp3", "", "music")
+
+	vfs := newTestVfs(test, store, "uid=1000", "gid=100")
+
+	for _, name := range []string{"", "tags/music", "tags/music/a.1.mp3"} {
+		// test
+
+		attr, status := vfs.GetAttr(name, nil)
+
+		// validate
+
+		if status != fuse.OK {
+			test.Fatalf("'%v': could not get attributes: %v.", name, status)
+		}
+		if attr.Uid != 1000 || attr.Gid != 100 {
+			test.Fatalf("'%v': expected owner 1000:100 but was %v:%v.", name, attr.Uid, attr.Gid)
+		}
+	}
+}
+
+func TestFuseVfsStat(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	dirPath := createTestDir(test)
+	defer os.RemoveAll(dirPath)
+
+	path := createTestFile(test, dirPath, "a.txt", "hello")
+	if err := os.Chmod(path, 0640); err != nil {
+		test.Fatal(err)
+	}
+
+	file := addTaggedFile(test, store, path, "", "text")
+	modTime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	if _, err := store.UpdateFile(file.Id, path, "", modTime, 99, false); err != nil {
+		test.Fatal(err)
+	}
+
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	targetAttr, targetStatus := newTestVfs(test, store).GetAttr("tags/text/a.1.txt", nil)
+	databaseAttr, databaseStatus := newTestVfs(test, store, "stat=database").GetAttr("tags/text/a.1.txt", nil)
+
+	// validate
+
+	if targetStatus != fuse.OK || databaseStatus != fuse.OK {
+		test.Fatalf("Could not get attributes: %v, %v.", targetStatus, databaseStatus)
+	}
+
+	if targetAttr.Mode != fuse.S_IFLNK|0640 || targetAttr.Size != 5 || targetAttr.Mtime != uint64(fileInfo.ModTime().Unix()) {
+		test.Fatalf("Expected the target's attributes but were %+v.", targetAttr)
+	}
+	if databaseAttr.Mode != fuse.S_IFLNK|0755 || databaseAttr.Size != 99 || databaseAttr.Mtime != uint64(modTime.Unix()) {
+		test.Fatalf("Expected the recorded attributes but were %+v.", databaseAttr)
+	}
+
+	// missing files are still links
+	if err := os.Remove(path); err != nil {
+		test.Fatal(err)
+	}
+
+	missingAttr, status := newTestVfs(test, store).GetAttr("tags/text/a.1.txt", nil)
+	if status != fuse.OK || !missingAttr.IsSymlink() {
+		test.Fatalf("Expected a link for the missing file but was %v, %v.", missingAttr, status)
+	}
+}
+
+func TestFuseVfsBuckets(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	addTaggedFile(test, store, "/tmp/tmsu/a.mp3", "", "apple", "avocado", "banana", "cherry", "7up")
+
+	vfs := newTestVfs(test, store, "buckets=2")
+
+	// test & validate
+
+	expectEntries(test, vfs, "tags", "(7)", "(a)", "(b)", "(c)")
+	expectEntries(test, vfs, "tags/(a)", "apple", "avocado")
+	expectEntries(test, vfs, "tags/(a)/apple", "(7)", "(a)", "(b)", "(c)")
+	expectEntries(test, vfs, "tags/(a)/apple/(a)", "avocado", "a.1.mp3")
+
+	attr, status := vfs.GetAttr("tags/(a)/apple/(a)/a.1.mp3", nil)
+	if status != fuse.OK || !attr.IsSymlink() {
+		test.Fatalf("Expected a link within the bucket but was %v, %v.", attr, status)
+	}
+
+	// small directories are not split
+	expectEntries(test, newTestVfs(test, store, "buckets=10"), "tags/apple", "7up", "avocado", "banana", "cherry", "a.1.mp3")
+}
+
+func TestFuseVfsMinFiles(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	addTaggedFile(test, store, "/tmp/tmsu/a.mp3", "", "common", "rare")
+	addTaggedFile(test, store, "/tmp/tmsu/b.mp3", "", "common")
+
+	// test & validate
+
+	expectEntries(test, newTestVfs(test, store, "minfiles=2"), "tags", "common", helpFilename)
+	expectEntries(test, newTestVfs(test, store), "tags", "common", "rare", helpFilename)
+}
+
+func TestFuseVfsQueryDialect(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	addTaggedFile(test, store, "/tmp/tmsu/a.mp3", "", "year=2014")
+	addTaggedFile(test, store, "/tmp/tmsu/b.mp3", "", "year=2016")
+
+	queryVfs := newTestVfs(test, store, "dialect=query")
+	plainVfs := newTestVfs(test, store, "dialect=plain")
+
+	// test
+
+	queryAttr, queryStatus := queryVfs.GetAttr("tags/year > 2015", nil)
+	_, plainStatus := plainVfs.GetAttr("tags/year > 2015", nil)
+
+	// validate
+
+	if queryStatus != fuse.OK || !queryAttr.IsDir() {
+		test.Fatalf("Expected a query directory but was %v, %v.", queryAttr, queryStatus)
+	}
+	if plainStatus != fuse.ENOENT {
+		test.Fatalf("Expected no directory with the plain dialect but was %v.", plainStatus)
+	}
+
+	expectEntries(test, queryVfs, "tags/year > 2015", "b.2.mp3")
+}
+
+func TestFuseVfsTagsXAttr(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	file := addTaggedFile(test, store, "/tmp/tmsu/a.mp3", "", "music", "year=2014")
+
+	vfs := newTestVfs(test, store)
+
+	// test & validate
+
+	attrNames, status := vfs.ListXAttr("tags/music/a.1.mp3", nil)
+	if status != fuse.OK || !reflect.DeepEqual(attrNames, []string{tagsXAttr}) {
+		test.Fatalf("Expected the tags attribute but was %v, %v.", attrNames, status)
+	}
+
+	attrNames, status = vfs.ListXAttr("tags/music", nil)
+	if status != fuse.OK || len(attrNames) != 0 {
+		test.Fatalf("Expected no attributes for a directory but was %v, %v.", attrNames, status)
+	}
+
+	expectTagsXAttr(test, vfs, "tags/music/a.1.mp3", "music year=2014")
+
+	if _, status := vfs.GetXAttr("tags/music/a.1.mp3", "user.other", nil); status != fuse.ENOATTR {
+		test.Fatalf("Expected ENOATTR for another attribute but was %v.", status)
+	}
+
+	if status := vfs.SetXAttr("tags/music/a.1.mp3", tagsXAttr, []byte("music colour=red"), 0, nil); status != fuse.OK {
+		test.Fatalf("Could not set tags: %v.", status)
+	}
+	expectTagsXAttr(test, vfs, "tags/music/a.1.mp3", "colour=red music")
+	expectEntries(test, vfs, "tags/colour/red", "music", "a.1.mp3")
+
+	if status := vfs.SetXAttr("tags/music", tagsXAttr, []byte("music"), 0, nil); status != fuse.EPERM {
+		test.Fatalf("Expected EPERM setting tags on a directory but was %v.", status)
+	}
+
+	if status := vfs.RemoveXAttr("tags/music/a.1.mp3", tagsXAttr, nil); status != fuse.OK {
+		test.Fatalf("Could not remove tags: %v.", status)
+	}
+
+	tagValueNames, err := vfs.fileTagValueNames(file.Id)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(tagValueNames) != 0 {
+		test.Fatalf("Expected the tags to be removed but were %v.", tagValueNames)
+	}
+}
+
+// unexported
+
+// Creates the virtual filesystem as it is mounted, but without a server.
+func newTestVfs(test *testing.T, store *storage.Storage, options ...string) *FuseVfs {
+	settings, _, err := parseMountOptions(options)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	fuseVfs := &FuseVfs{}
+	pathFs := pathfs.NewPathNodeFs(fuseVfs, nil)
+	nodefs.NewFileSystemConnector(pathFs.Root(), nil)
+
+	fuseVfs.init(store, filepath.Join(os.TempDir(), "tmsu_vfs_test_mount"), nil, pathFs, settings, "")
+
+	return fuseVfs
+}
+
+func openTestDir(test *testing.T, vfs *FuseVfs, name string) []fuse.DirEntry {
+	entries, status := vfs.OpenDir(name, nil)
+	if status != fuse.OK {
+		test.Fatalf("Could not open directory '%v': %v.", name, status)
+	}
+
+	return entries
+}
+
+func expectEntries(test *testing.T, vfs *FuseVfs, name string, expected ...string) {
+	if names := entryNames(openTestDir(test, vfs, name)); !reflect.DeepEqual(names, expected) {
+		test.Fatalf("'%v': expected entries %v but were %v.", name, expected, names)
+	}
+}
+
+func expectTagsXAttr(test *testing.T, vfs *FuseVfs, name, expected string) {
+	data, status := vfs.GetXAttr(name, tagsXAttr, nil)
+	if status != fuse.OK {
+		test.Fatalf("'%v': could not get tags: %v.", name, status)
+	}
+	if string(data) != expected {
+		test.Fatalf("'%v': expected tags '%v' but were '%v'.", name, expected, string(data))
+	}
+}
+
+func entryNames(entries []fuse.DirEntry) []string {
+	names := make([]string, len(entries))
+	for index, entry := range entries {
+		names[index] = entry.Name
+	}
+
+	return names
+}
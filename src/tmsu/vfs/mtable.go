@@ -1,3 +1,5 @@
+// +build !windows
+
 /*
 Copyright 2011-2015 Paul Ruane.
 
@@ -18,44 +20,75 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package vfs
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
-	"tmsu/common/proc"
+	"strconv"
+	"strings"
 )
 
-// +build !windows
+// The filesystem type under which the virtual filesystem is mounted, as it
+// appears in the mount table.
+const fileSystemType = "fuse.tmsu"
+
+const mountTablePath = "/proc/mounts"
 
 type Mount struct {
 	DatabasePath string
 	MountPath    string
 }
 
+// Retrieves the mounted virtual filesystems from the system mount table.
 func GetMountTable() ([]Mount, error) {
-	pids, err := proc.GetProcessIds()
+	file, err := os.Open(mountTablePath)
 	if err != nil {
-		return nil, fmt.Errorf("could not get process IDs: %v", err)
+		return nil, fmt.Errorf("could not open mount table '%v': %v", mountTablePath, err)
 	}
+	defer file.Close()
+
+	return parseMountTable(file)
+}
+
+// unexported
 
+func parseMountTable(reader io.Reader) ([]Mount, error) {
 	mountTable := make([]Mount, 0, 10)
 
-	for _, pid := range pids {
-		process, err := proc.GetProcess(pid)
-		if err != nil {
-			if !os.IsPermission(err) {
-				return nil, err
-			}
-		} else {
-			if len(process.CommandLine) >= 4 && process.CommandLine[0] == "tmsu" && process.CommandLine[1] == "vfs" {
-				databasePath := filepath.Join(process.WorkingDirectory, process.CommandLine[2])
-				mountPath := process.CommandLine[3]
-				if mountPath[0] != '/' {
-					mountPath = filepath.Join(process.WorkingDirectory, mountPath)
-				}
-				mountTable = append(mountTable, Mount{databasePath, mountPath})
-			}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[2] != fileSystemType {
+			continue
 		}
+
+		mountTable = append(mountTable, Mount{unescapeMountField(fields[0]), unescapeMountField(fields[1])})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read mount table: %v", err)
 	}
 
 	return mountTable, nil
 }
+
+// The mount table escapes whitespace and backslashes as octal, e.g. '\040'.
+func unescapeMountField(field string) string {
+	if !strings.Contains(field, "\\") {
+		return field
+	}
+
+	var unescaped []byte
+	for index := 0; index < len(field); index++ {
+		if field[index] == '\\' && index+3 < len(field) {
+			if char, err := strconv.ParseUint(field[index+1:index+4], 8, 8); err == nil {
+				unescaped = append(unescaped, byte(char))
+				index += 3
+				continue
+			}
+		}
+
+		unescaped = append(unescaped, field[index])
+	}
+
+	return string(unescaped)
+}
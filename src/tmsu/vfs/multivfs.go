@@ -0,0 +1,330 @@
+// +build !windows
+
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package vfs
+
+import (
+	"fmt"
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"strings"
+	"syscall"
+	"time"
+	"tmsu/common/log"
+	"tmsu/storage"
+)
+
+// A virtual filesystem presenting several databases from a single mount, each
+// beneath a directory of its own, e.g. 'mp/photos/tags'.
+type MultiFuseVfs struct {
+	server   *fuse.Server
+	settings mountSettings
+	names    []string
+	vfses    map[string]*FuseVfs
+}
+
+// Mounts the databases, each beneath the directory of the corresponding name.
+func MountMultiVfs(stores []*storage.Storage, names []string, mountPath string, options []string) (Vfs, error) {
+	if len(stores) != len(names) {
+		panic("a name must be specified for each database")
+	}
+
+	multiVfs := MultiFuseVfs{names: names, vfses: make(map[string]*FuseVfs, len(names))}
+	pathFs := pathfs.NewPathNodeFs(&multiVfs, nil)
+	conn := nodefs.NewFileSystemConnector(pathFs.Root(), nil)
+
+	settings, fuseOptions, err := parseMountOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	databasePaths := make([]string, len(stores))
+	for index, store := range stores {
+		if _, exists := multiVfs.vfses[names[index]]; exists {
+			return nil, fmt.Errorf("more than one database is named '%v'", names[index])
+		}
+
+		multiVfs.vfses[names[index]] = &FuseVfs{}
+		databasePaths[index] = store.Db.Path
+	}
+
+	mountOptions := &fuse.MountOptions{Options: fuseOptions, FsName: strings.Join(databasePaths, ","), Name: strings.TrimPrefix(fileSystemType, "fuse.")}
+
+	server, err := fuse.NewServer(conn.RawFS(), mountPath, mountOptions)
+	if err != nil {
+		return nil, fmt.Errorf("could not mount virtual filesystem at '%v': %v", mountPath, err)
+	}
+
+	for index, store := range stores {
+		multiVfs.vfses[names[index]].init(store, mountPath, server, pathFs, settings, names[index])
+	}
+
+	multiVfs.server = server
+	multiVfs.settings = settings
+
+	return &multiVfs, nil
+}
+
+func (vfs MultiFuseVfs) Unmount() {
+	vfs.server.Unmount()
+}
+
+func (vfs MultiFuseVfs) Serve() {
+	done := make(chan struct{})
+	defer close(done)
+
+	for _, fuseVfs := range vfs.vfses {
+		go fuseVfs.watchDatabase(databasePollInterval, done)
+	}
+
+	vfs.server.Serve()
+}
+
+func (vfs MultiFuseVfs) SetDebug(debug bool) {
+}
+
+func (vfs MultiFuseVfs) Access(name string, mode uint32, context *fuse.Context) fuse.Status {
+	return fuse.ENOSYS
+}
+
+func (vfs MultiFuseVfs) Chmod(name string, mode uint32, context *fuse.Context) fuse.Status {
+	if fuseVfs, rest := vfs.route(name); fuseVfs != nil {
+		return fuseVfs.Chmod(rest, mode, context)
+	}
+
+	return fuse.ENOSYS
+}
+
+func (vfs MultiFuseVfs) Chown(name string, uid uint32, gid uint32, context *fuse.Context) fuse.Status {
+	if fuseVfs, rest := vfs.route(name); fuseVfs != nil {
+		return fuseVfs.Chown(rest, uid, gid, context)
+	}
+
+	return fuse.ENOSYS
+}
+
+func (vfs MultiFuseVfs) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	if fuseVfs, rest := vfs.route(name); fuseVfs != nil {
+		return fuseVfs.Create(rest, flags, mode, context)
+	}
+
+	return nil, fuse.ENOSYS
+}
+
+func (vfs MultiFuseVfs) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	log.Infof(2, "BEGIN GetAttr(%v)", name)
+	defer log.Infof(2, "END GetAttr(%v)", name)
+
+	if name == "" {
+		return vfs.getRootAttr(), fuse.OK
+	}
+
+	if fuseVfs, rest := vfs.route(name); fuseVfs != nil {
+		return fuseVfs.GetAttr(rest, context)
+	}
+
+	return nil, fuse.ENOENT
+}
+
+func (vfs MultiFuseVfs) GetXAttr(name string, attr string, context *fuse.Context) ([]byte, fuse.Status) {
+	if fuseVfs, rest := vfs.route(name); fuseVfs != nil {
+		return fuseVfs.GetXAttr(rest, attr, context)
+	}
+
+	return nil, fuse.ENOATTR
+}
+
+func (vfs MultiFuseVfs) Link(oldName string, newName string, context *fuse.Context) fuse.Status {
+	return fuse.ENOSYS
+}
+
+func (vfs MultiFuseVfs) ListXAttr(name string, context *fuse.Context) ([]string, fuse.Status) {
+	if fuseVfs, rest := vfs.route(name); fuseVfs != nil {
+		return fuseVfs.ListXAttr(rest, context)
+	}
+
+	return []string{}, fuse.OK
+}
+
+func (vfs MultiFuseVfs) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	if fuseVfs, rest := vfs.route(name); fuseVfs != nil && rest != "" {
+		return fuseVfs.Mkdir(rest, mode, context)
+	}
+
+	return fuse.EPERM
+}
+
+func (vfs MultiFuseVfs) Mknod(name string, mode uint32, dev uint32, context *fuse.Context) fuse.Status {
+	return fuse.ENOSYS
+}
+
+func (vfs MultiFuseVfs) OnMount(nodeFs *pathfs.PathNodeFs) {
+}
+
+func (vfs MultiFuseVfs) OnUnmount() {
+}
+
+func (vfs MultiFuseVfs) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	if fuseVfs, rest := vfs.route(name); fuseVfs != nil {
+		return fuseVfs.Open(rest, flags, context)
+	}
+
+	return nil, fuse.ENOENT
+}
+
+func (vfs MultiFuseVfs) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	log.Infof(2, "BEGIN OpenDir(%v)", name)
+	defer log.Infof(2, "END OpenDir(%v)", name)
+
+	if name == "" {
+		entries := make([]fuse.DirEntry, len(vfs.names))
+		for index, databaseName := range vfs.names {
+			entries[index] = fuse.DirEntry{Name: databaseName, Mode: fuse.S_IFDIR}
+		}
+
+		return entries, fuse.OK
+	}
+
+	if fuseVfs, rest := vfs.route(name); fuseVfs != nil {
+		return fuseVfs.OpenDir(rest, context)
+	}
+
+	return nil, fuse.ENOENT
+}
+
+func (vfs MultiFuseVfs) Readlink(name string, context *fuse.Context) (string, fuse.Status) {
+	if fuseVfs, rest := vfs.route(name); fuseVfs != nil {
+		return fuseVfs.Readlink(rest, context)
+	}
+
+	return "", fuse.ENOENT
+}
+
+func (vfs MultiFuseVfs) RemoveXAttr(name string, attr string, context *fuse.Context) fuse.Status {
+	if fuseVfs, rest := vfs.route(name); fuseVfs != nil {
+		return fuseVfs.RemoveXAttr(rest, attr, context)
+	}
+
+	return fuse.EPERM
+}
+
+func (vfs MultiFuseVfs) Rename(oldName string, newName string, context *fuse.Context) fuse.Status {
+	oldVfs, oldRest := vfs.route(oldName)
+	newVfs, newRest := vfs.route(newName)
+
+	if oldVfs == nil || newVfs == nil {
+		return fuse.EPERM
+	}
+	if oldVfs != newVfs {
+		// tags cannot be moved between databases
+		return fuse.Status(syscall.EXDEV)
+	}
+
+	return oldVfs.Rename(oldRest, newRest, context)
+}
+
+func (vfs MultiFuseVfs) Rmdir(name string, context *fuse.Context) fuse.Status {
+	if fuseVfs, rest := vfs.route(name); fuseVfs != nil && rest != "" {
+		return fuseVfs.Rmdir(rest, context)
+	}
+
+	return fuse.EPERM
+}
+
+func (vfs MultiFuseVfs) SetXAttr(name string, attr string, data []byte, flags int, context *fuse.Context) fuse.Status {
+	if fuseVfs, rest := vfs.route(name); fuseVfs != nil {
+		return fuseVfs.SetXAttr(rest, attr, data, flags, context)
+	}
+
+	return fuse.EPERM
+}
+
+func (vfs MultiFuseVfs) StatFs(name string) *fuse.StatfsOut {
+	return &fuse.StatfsOut{}
+}
+
+func (vfs MultiFuseVfs) String() string {
+	return "tmsu"
+}
+
+func (vfs MultiFuseVfs) Symlink(value string, linkName string, context *fuse.Context) fuse.Status {
+	if fuseVfs, rest := vfs.route(linkName); fuseVfs != nil {
+		return fuseVfs.Symlink(value, rest, context)
+	}
+
+	return fuse.ENOSYS
+}
+
+func (vfs MultiFuseVfs) Truncate(name string, offset uint64, context *fuse.Context) fuse.Status {
+	if fuseVfs, rest := vfs.route(name); fuseVfs != nil {
+		return fuseVfs.Truncate(rest, offset, context)
+	}
+
+	return fuse.ENOSYS
+}
+
+func (vfs MultiFuseVfs) Unlink(name string, context *fuse.Context) fuse.Status {
+	if fuseVfs, rest := vfs.route(name); fuseVfs != nil {
+		return fuseVfs.Unlink(rest, context)
+	}
+
+	return fuse.ENOSYS
+}
+
+func (vfs MultiFuseVfs) Utimens(name string, Atime *time.Time, Mtime *time.Time, context *fuse.Context) fuse.Status {
+	if fuseVfs, rest := vfs.route(name); fuseVfs != nil {
+		return fuseVfs.Utimens(rest, Atime, Mtime, context)
+	}
+
+	return fuse.ENOSYS
+}
+
+// unexported
+
+// Identifies the database a path lies within and the path within that
+// database's filesystem.
+func (vfs MultiFuseVfs) route(name string) (*FuseVfs, string) {
+	parts := strings.SplitN(name, "/", 2)
+
+	fuseVfs, found := vfs.vfses[parts[0]]
+	if !found {
+		return nil, ""
+	}
+
+	if len(parts) == 1 {
+		return fuseVfs, ""
+	}
+
+	return fuseVfs, parts[1]
+}
+
+func (vfs MultiFuseVfs) getRootAttr() *fuse.Attr {
+	now := time.Now()
+	attr := fuse.Attr{Mode: fuse.S_IFDIR | 0755, Nlink: 2, Size: uint64(len(vfs.names)), Mtime: uint64(now.Unix()), Mtimensec: uint32(now.Nanosecond())}
+
+	if vfs.settings.uid != nil {
+		attr.Uid = *vfs.settings.uid
+	}
+	if vfs.settings.gid != nil {
+		attr.Gid = *vfs.settings.gid
+	}
+
+	return &attr
+}
@@ -0,0 +1,89 @@
+// +build !windows
+
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package vfs
+
+import (
+	"fmt"
+	"sync"
+	"tmsu/entities"
+)
+
+// The strategy used to name the symbolic links within the virtual filesystem.
+type namingStrategy int
+
+const (
+	// the file's name with its identifier inserted before the extension
+	idNaming namingStrategy = iota
+
+	// the file's name with a prefix of its fingerprint inserted before the
+	// extension
+	hashNaming
+
+	// the file's path relative to the root path, with the directory separators
+	// escaped
+	pathNaming
+)
+
+const hashPrefixLength = 8
+
+func parseNamingStrategy(name string) (namingStrategy, error) {
+	switch name {
+	case "id":
+		return idNaming, nil
+	case "hash":
+		return hashNaming, nil
+	case "mirror":
+		return pathNaming, nil
+	}
+
+	return idNaming, fmt.Errorf("invalid naming strategy '%v': must be one of 'id', 'hash' or 'mirror'", name)
+}
+
+// The link names issued, so that names that do not contain the file
+// identifier can be resolved back to the file.
+type linkNames struct {
+	sync.Mutex
+	fileIds map[string]entities.FileId
+}
+
+func newLinkNames() *linkNames {
+	return &linkNames{fileIds: make(map[string]entities.FileId)}
+}
+
+// Registers the name for the file, returning false if the name is already in
+// use by a different file.
+func (names *linkNames) register(name string, fileId entities.FileId) bool {
+	names.Lock()
+	defer names.Unlock()
+
+	if existingId, ok := names.fileIds[name]; ok && existingId != fileId {
+		return false
+	}
+
+	names.fileIds[name] = fileId
+	return true
+}
+
+func (names *linkNames) fileId(name string) entities.FileId {
+	names.Lock()
+	defer names.Unlock()
+
+	return names.fileIds[name]
+}
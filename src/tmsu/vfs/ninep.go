@@ -0,0 +1,595 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package vfs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"tmsu/common/log"
+	"tmsu/storage"
+)
+
+// Serves the virtual filesystem, read-only, over the 9P2000 protocol.
+type NinePServer struct {
+	tree *Tree
+}
+
+func NewNinePServer(store *storage.Storage) *NinePServer {
+	return &NinePServer{NewTree(store)}
+}
+
+// Accepts connections on the listener, serving each on its own goroutine.
+func (server *NinePServer) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+
+			if err := server.ServeConn(conn); err != nil && err != io.EOF {
+				log.Warnf("9P connection from %v failed: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// Serves a single 9P connection until it is closed.
+func (server *NinePServer) ServeConn(conn io.ReadWriter) error {
+	session := &ninePSession{server.tree, bufio.NewReader(conn), conn, sync.Mutex{}, defaultMessageSize, make(map[uint32]*ninePFid)}
+	defer session.clunkAll()
+
+	for {
+		message, err := session.readMessage()
+		if err != nil {
+			return err
+		}
+
+		if err := session.handle(message); err != nil {
+			return err
+		}
+	}
+}
+
+// unexported
+
+const (
+	msgTversion = 100
+	msgTauth    = 102
+	msgTattach  = 104
+	msgRerror   = 107
+	msgTflush   = 108
+	msgTwalk    = 110
+	msgTopen    = 112
+	msgTread    = 116
+	msgTclunk   = 120
+	msgTstat    = 124
+)
+
+const (
+	qidTypeDir   = 0x80
+	qidTypeFile  = 0x00
+	modeDir      = 0x80000000
+	noFid        = 0xFFFFFFFF
+	openModeMask = 0x03
+	openRead     = 0x00
+	openExec     = 0x03
+)
+
+const defaultMessageSize = 8192
+const maximumMessageSize = 65536
+const messageHeaderSize = 4 + 1 + 2
+const readHeaderSize = messageHeaderSize + 4
+
+var errReadOnly = errors.New("the virtual filesystem is read-only")
+
+type ninePFid struct {
+	path    []string
+	node    Node
+	file    *os.File
+	listing []byte
+}
+
+type ninePSession struct {
+	tree        *Tree
+	reader      *bufio.Reader
+	writer      io.Writer
+	writeLock   sync.Mutex
+	messageSize uint32
+	fids        map[uint32]*ninePFid
+}
+
+type ninePMessage struct {
+	kind byte
+	tag  uint16
+	body *ninePBuffer
+}
+
+func (session *ninePSession) readMessage() (*ninePMessage, error) {
+	var size uint32
+	if err := binary.Read(session.reader, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < messageHeaderSize || size > maximumMessageSize {
+		return nil, fmt.Errorf("invalid message size %v", size)
+	}
+
+	data := make([]byte, size-4)
+	if _, err := io.ReadFull(session.reader, data); err != nil {
+		return nil, err
+	}
+
+	return &ninePMessage{data[0], binary.LittleEndian.Uint16(data[1:3]), &ninePBuffer{data: data[3:]}}, nil
+}
+
+func (session *ninePSession) reply(kind byte, tag uint16, body []byte) error {
+	message := make([]byte, messageHeaderSize, messageHeaderSize+len(body))
+	binary.LittleEndian.PutUint32(message[0:4], uint32(messageHeaderSize+len(body)))
+	message[4] = kind
+	binary.LittleEndian.PutUint16(message[5:7], tag)
+	message = append(message, body...)
+
+	session.writeLock.Lock()
+	defer session.writeLock.Unlock()
+
+	_, err := session.writer.Write(message)
+	return err
+}
+
+func (session *ninePSession) handle(message *ninePMessage) error {
+	var response *ninePBuffer
+	var err error
+
+	switch message.kind {
+	case msgTversion:
+		response, err = session.version(message.body)
+	case msgTauth:
+		err = errors.New("authentication not required")
+	case msgTattach:
+		response, err = session.attach(message.body)
+	case msgTflush:
+		response = &ninePBuffer{}
+	case msgTwalk:
+		response, err = session.walk(message.body)
+	case msgTopen:
+		response, err = session.open(message.body)
+	case msgTread:
+		response, err = session.read(message.body)
+	case msgTclunk:
+		response, err = session.clunk(message.body)
+	case msgTstat:
+		response, err = session.stat(message.body)
+	default:
+		err = errReadOnly
+	}
+
+	if err == nil && message.body.err != nil {
+		err = message.body.err
+	}
+
+	if err != nil {
+		log.Infof(2, "9P request %v failed: %v", message.kind, err)
+
+		errorResponse := &ninePBuffer{}
+		errorResponse.putString(err.Error())
+		return session.reply(msgRerror, message.tag, errorResponse.data)
+	}
+
+	return session.reply(message.kind+1, message.tag, response.data)
+}
+
+func (session *ninePSession) version(request *ninePBuffer) (*ninePBuffer, error) {
+	messageSize := request.uint32()
+	version := request.string()
+
+	if messageSize > maximumMessageSize {
+		messageSize = maximumMessageSize
+	}
+	session.messageSize = messageSize
+	session.clunkAll()
+
+	if !strings.HasPrefix(version, "9P2000") {
+		version = "unknown"
+	} else {
+		version = "9P2000"
+	}
+
+	response := &ninePBuffer{}
+	response.putUint32(messageSize)
+	response.putString(version)
+	return response, nil
+}
+
+func (session *ninePSession) attach(request *ninePBuffer) (*ninePBuffer, error) {
+	fidId := request.uint32()
+
+	if _, exists := session.fids[fidId]; exists {
+		return nil, errors.New("fid already in use")
+	}
+
+	root := &ninePFid{path: []string{}, node: Node{Type: DirectoryNode}}
+	session.fids[fidId] = root
+
+	response := &ninePBuffer{}
+	response.putQid(root.path, root.node)
+	return response, nil
+}
+
+func (session *ninePSession) walk(request *ninePBuffer) (*ninePBuffer, error) {
+	fidId := request.uint32()
+	newFidId := request.uint32()
+	count := int(request.uint16())
+
+	names := make([]string, count)
+	for index := range names {
+		names[index] = request.string()
+	}
+
+	fid, ok := session.fids[fidId]
+	if !ok {
+		return nil, errors.New("unknown fid")
+	}
+	if _, exists := session.fids[newFidId]; exists && newFidId != fidId {
+		return nil, errors.New("fid already in use")
+	}
+
+	path := fid.path
+	node := fid.node
+
+	response := &ninePBuffer{}
+	response.putUint16(0)
+	walked := 0
+
+	for _, name := range names {
+		var nextPath []string
+
+		if name == ".." {
+			if len(path) == 0 {
+				nextPath = path
+			} else {
+				nextPath = path[:len(path)-1]
+			}
+		} else {
+			nextPath = append(path[:len(path):len(path)], name)
+		}
+
+		nextNode, err := session.tree.Lookup(nextPath)
+		if err != nil {
+			return nil, err
+		}
+		if nextNode == nil {
+			break
+		}
+
+		path, node = nextPath, *nextNode
+		response.putQid(path, node)
+		walked++
+	}
+
+	if walked == 0 && count > 0 {
+		return nil, errors.New("file does not exist")
+	}
+
+	binary.LittleEndian.PutUint16(response.data[0:2], uint16(walked))
+
+	if walked == count {
+		session.fids[newFidId] = &ninePFid{path: path, node: node}
+	}
+
+	return response, nil
+}
+
+func (session *ninePSession) open(request *ninePBuffer) (*ninePBuffer, error) {
+	fidId := request.uint32()
+	mode := request.byte()
+
+	fid, ok := session.fids[fidId]
+	if !ok {
+		return nil, errors.New("unknown fid")
+	}
+
+	if mode&openModeMask != openRead && mode&openModeMask != openExec || mode&^openModeMask != 0 {
+		return nil, errReadOnly
+	}
+
+	switch fid.node.Type {
+	case DirectoryNode:
+		fid.listing = nil
+	case LinkNode:
+		file, err := session.tree.File(&fid.node)
+		if err != nil {
+			return nil, err
+		}
+		if file == nil {
+			return nil, errors.New("file does not exist")
+		}
+
+		fid.file, err = os.Open(file.Path())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response := &ninePBuffer{}
+	response.putQid(fid.path, fid.node)
+	response.putUint32(session.messageSize - readHeaderSize)
+	return response, nil
+}
+
+func (session *ninePSession) read(request *ninePBuffer) (*ninePBuffer, error) {
+	fidId := request.uint32()
+	offset := request.uint64()
+	count := request.uint32()
+
+	fid, ok := session.fids[fidId]
+	if !ok {
+		return nil, errors.New("unknown fid")
+	}
+
+	if count > session.messageSize-readHeaderSize {
+		count = session.messageSize - readHeaderSize
+	}
+
+	var data []byte
+
+	switch fid.node.Type {
+	case DirectoryNode:
+		if offset == 0 || fid.listing == nil {
+			listing, err := session.listing(fid.path)
+			if err != nil {
+				return nil, err
+			}
+			fid.listing = listing
+		}
+
+		data = directoryEntriesAt(fid.listing, offset, count)
+	case LinkNode:
+		if fid.file == nil {
+			return nil, errors.New("file not open")
+		}
+
+		data = make([]byte, count)
+		read, err := fid.file.ReadAt(data, int64(offset))
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		data = data[:read]
+	}
+
+	response := &ninePBuffer{}
+	response.putUint32(uint32(len(data)))
+	response.data = append(response.data, data...)
+	return response, nil
+}
+
+func (session *ninePSession) clunk(request *ninePBuffer) (*ninePBuffer, error) {
+	fidId := request.uint32()
+
+	fid, ok := session.fids[fidId]
+	if !ok {
+		return nil, errors.New("unknown fid")
+	}
+
+	if fid.file != nil {
+		fid.file.Close()
+	}
+	delete(session.fids, fidId)
+
+	return &ninePBuffer{}, nil
+}
+
+func (session *ninePSession) clunkAll() {
+	for fidId, fid := range session.fids {
+		if fid.file != nil {
+			fid.file.Close()
+		}
+		delete(session.fids, fidId)
+	}
+}
+
+func (session *ninePSession) stat(request *ninePBuffer) (*ninePBuffer, error) {
+	fidId := request.uint32()
+
+	fid, ok := session.fids[fidId]
+	if !ok {
+		return nil, errors.New("unknown fid")
+	}
+
+	stat, err := session.statEntry(fid.path, fid.node)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &ninePBuffer{}
+	response.putUint16(uint16(len(stat)))
+	response.data = append(response.data, stat...)
+	return response, nil
+}
+
+func (session *ninePSession) listing(path []string) ([]byte, error) {
+	nodes, err := session.tree.List(path)
+	if err != nil {
+		return nil, err
+	}
+
+	listing := make([]byte, 0, 1024)
+	for _, node := range nodes {
+		stat, err := session.statEntry(append(path[:len(path):len(path)], node.Name), node)
+		if err != nil {
+			return nil, err
+		}
+
+		listing = append(listing, stat...)
+	}
+
+	return listing, nil
+}
+
+func (session *ninePSession) statEntry(path []string, node Node) ([]byte, error) {
+	var mode uint32
+	var length uint64
+	var modTime uint32
+
+	switch node.Type {
+	case DirectoryNode:
+		mode = modeDir | 0555
+	case LinkNode:
+		mode = 0444
+
+		file, err := session.tree.File(&node)
+		if err != nil {
+			return nil, err
+		}
+		if file != nil {
+			if fileInfo, err := os.Stat(file.Path()); err == nil {
+				length = uint64(fileInfo.Size())
+				modTime = uint32(fileInfo.ModTime().Unix())
+			}
+		}
+	}
+
+	name := node.Name
+	if len(path) == 0 {
+		name = "/"
+	}
+
+	stat := &ninePBuffer{}
+	stat.putUint16(0) // size, filled in below
+	stat.putUint16(0) // type
+	stat.putUint32(0) // dev
+	stat.putQid(path, node)
+	stat.putUint32(mode)
+	stat.putUint32(modTime) // atime
+	stat.putUint32(modTime) // mtime
+	stat.putUint64(length)
+	stat.putString(name)
+	stat.putString("tmsu") // uid
+	stat.putString("tmsu") // gid
+	stat.putString("")     // muid
+
+	binary.LittleEndian.PutUint16(stat.data[0:2], uint16(len(stat.data)-2))
+	return stat.data, nil
+}
+
+// Retrieves the whole directory entries from the listing that start at the
+// offset and fit within count bytes.
+func directoryEntriesAt(listing []byte, offset uint64, count uint32) []byte {
+	if offset >= uint64(len(listing)) {
+		return nil
+	}
+
+	start := int(offset)
+	end := start
+	for end+2 <= len(listing) {
+		entrySize := int(binary.LittleEndian.Uint16(listing[end:end+2])) + 2
+		if end+entrySize-start > int(count) {
+			break
+		}
+		end += entrySize
+	}
+
+	return listing[start:end]
+}
+
+// A buffer for encoding and decoding 9P message fields.
+type ninePBuffer struct {
+	data []byte
+	err  error
+}
+
+func (buffer *ninePBuffer) take(count int) []byte {
+	if buffer.err != nil {
+		return make([]byte, count)
+	}
+	if len(buffer.data) < count {
+		buffer.err = errors.New("message too short")
+		return make([]byte, count)
+	}
+
+	taken := buffer.data[:count]
+	buffer.data = buffer.data[count:]
+	return taken
+}
+
+func (buffer *ninePBuffer) byte() byte {
+	return buffer.take(1)[0]
+}
+
+func (buffer *ninePBuffer) uint16() uint16 {
+	return binary.LittleEndian.Uint16(buffer.take(2))
+}
+
+func (buffer *ninePBuffer) uint32() uint32 {
+	return binary.LittleEndian.Uint32(buffer.take(4))
+}
+
+func (buffer *ninePBuffer) uint64() uint64 {
+	return binary.LittleEndian.Uint64(buffer.take(8))
+}
+
+func (buffer *ninePBuffer) string() string {
+	length := int(buffer.uint16())
+	return string(buffer.take(length))
+}
+
+func (buffer *ninePBuffer) putByte(value byte) {
+	buffer.data = append(buffer.data, value)
+}
+
+func (buffer *ninePBuffer) putUint16(value uint16) {
+	buffer.data = append(buffer.data, byte(value), byte(value>>8))
+}
+
+func (buffer *ninePBuffer) putUint32(value uint32) {
+	buffer.data = append(buffer.data, byte(value), byte(value>>8), byte(value>>16), byte(value>>24))
+}
+
+func (buffer *ninePBuffer) putUint64(value uint64) {
+	buffer.putUint32(uint32(value))
+	buffer.putUint32(uint32(value >> 32))
+}
+
+func (buffer *ninePBuffer) putString(value string) {
+	buffer.putUint16(uint16(len(value)))
+	buffer.data = append(buffer.data, value...)
+}
+
+func (buffer *ninePBuffer) putQid(path []string, node Node) {
+	hash := fnv.New64a()
+	hash.Write([]byte(strings.Join(path, "/")))
+
+	switch node.Type {
+	case DirectoryNode:
+		buffer.putByte(qidTypeDir)
+	default:
+		buffer.putByte(qidTypeFile)
+	}
+
+	buffer.putUint32(0) // version
+	buffer.putUint64(hash.Sum64())
+}
@@ -0,0 +1,141 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package vfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The TMSU specific mount options.
+type mountSettings struct {
+	naming   namingStrategy
+	stat     statSource
+	readOnly bool
+
+	// tag directories with more entries than this are split into buckets
+	bucketSize uint
+
+	// tags applied to fewer files than this are not listed
+	minFiles uint
+
+	// whether tag directories may be named with query terms
+	queryNames bool
+	uid        *uint32
+	gid        *uint32
+}
+
+// Separates the TMSU specific mount options from those to be passed to FUSE.
+func parseMountOptions(options []string) (mountSettings, []string, error) {
+	settings := mountSettings{naming: idNaming, stat: targetStat}
+	fuseOptions := make([]string, 0, len(options))
+
+	for _, option := range options {
+		if option == "" {
+			continue
+		}
+
+		parts := strings.SplitN(option, "=", 2)
+		name := parts[0]
+
+		switch {
+		case name == "names" && len(parts) == 2:
+			naming, err := parseNamingStrategy(parts[1])
+			if err != nil {
+				return settings, nil, err
+			}
+
+			settings.naming = naming
+		case name == "stat" && len(parts) == 2:
+			stat, err := parseStatSource(parts[1])
+			if err != nil {
+				return settings, nil, err
+			}
+
+			settings.stat = stat
+		case (name == "uid" || name == "gid") && len(parts) == 2:
+			id, err := strconv.ParseUint(parts[1], 10, 32)
+			if err != nil {
+				return settings, nil, fmt.Errorf("invalid %v '%v'", name, parts[1])
+			}
+
+			id32 := uint32(id)
+			if name == "uid" {
+				settings.uid = &id32
+			} else {
+				settings.gid = &id32
+			}
+		case name == "dialect" && len(parts) == 2:
+			switch parts[1] {
+			case "plain":
+				settings.queryNames = false
+			case "query":
+				settings.queryNames = true
+			default:
+				return settings, nil, fmt.Errorf("invalid dialect '%v': must be one of 'plain' or 'query'", parts[1])
+			}
+		case (name == "buckets" || name == "minfiles") && len(parts) == 2:
+			count, err := strconv.ParseUint(parts[1], 10, 32)
+			if err != nil {
+				return settings, nil, fmt.Errorf("invalid %v '%v'", name, parts[1])
+			}
+
+			if name == "buckets" {
+				settings.bucketSize = uint(count)
+			} else {
+				settings.minFiles = uint(count)
+			}
+		case name == "ro":
+			settings.readOnly = true
+			fuseOptions = append(fuseOptions, option)
+		case name == "rw":
+			settings.readOnly = false
+		default:
+			fuseOptions = append(fuseOptions, option)
+		}
+	}
+
+	return settings, fuseOptions, nil
+}
+
+// The source of the attributes reported for the symbolic links to files.
+type statSource int
+
+const (
+	// the size, modification time, permissions and owner of the link's target
+	targetStat statSource = iota
+
+	// the size and modification time recorded in the database, which avoids
+	// touching the file-system when a directory is listed
+	databaseStat
+)
+
+func parseStatSource(name string) (statSource, error) {
+	switch name {
+	case "target":
+		return targetStat, nil
+	case "database":
+		return databaseStat, nil
+	}
+
+	return targetStat, fmt.Errorf("invalid stat source '%v': must be one of 'target' or 'database'", name)
+}
@@ -0,0 +1,100 @@
+// +build !windows
+
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package vfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMountOptions(test *testing.T) {
+	// set-up
+
+	options := []string{"names=hash", "stat=database", "uid=1000", "gid=100", "dialect=query", "buckets=50", "minfiles=3", "ro", "allow_other", ""}
+
+	// test
+
+	settings, fuseOptions, err := parseMountOptions(options)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	if settings.naming != hashNaming {
+		test.Fatalf("Expected hash naming but was %v.", settings.naming)
+	}
+	if settings.stat != databaseStat {
+		test.Fatalf("Expected database attributes but was %v.", settings.stat)
+	}
+	if settings.uid == nil || *settings.uid != 1000 {
+		test.Fatalf("Expected uid 1000 but was %v.", settings.uid)
+	}
+	if settings.gid == nil || *settings.gid != 100 {
+		test.Fatalf("Expected gid 100 but was %v.", settings.gid)
+	}
+	if !settings.queryNames {
+		test.Fatal("Expected query names to be enabled.")
+	}
+	if settings.bucketSize != 50 || settings.minFiles != 3 {
+		test.Fatalf("Expected bucket size 50 and minimum files 3 but were %v and %v.", settings.bucketSize, settings.minFiles)
+	}
+	if !settings.readOnly {
+		test.Fatal("Expected the mount to be read-only.")
+	}
+	if expected := []string{"ro", "allow_other"}; !reflect.DeepEqual(fuseOptions, expected) {
+		test.Fatalf("Expected FUSE options %v but were %v.", expected, fuseOptions)
+	}
+}
+
+func TestParseMountOptionsDefaults(test *testing.T) {
+	// test
+
+	settings, fuseOptions, err := parseMountOptions([]string{"ro", "rw"})
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	if settings.naming != idNaming || settings.stat != targetStat || settings.readOnly || settings.queryNames {
+		test.Fatalf("Unexpected settings %+v.", settings)
+	}
+	if settings.uid != nil || settings.gid != nil || settings.bucketSize != 0 || settings.minFiles != 0 {
+		test.Fatalf("Unexpected settings %+v.", settings)
+	}
+	if expected := []string{"ro"}; !reflect.DeepEqual(fuseOptions, expected) {
+		test.Fatalf("Expected FUSE options %v but were %v.", expected, fuseOptions)
+	}
+}
+
+func TestParseMountOptionsInvalid(test *testing.T) {
+	for _, option := range []string{"names=bogus", "stat=link", "uid=root", "gid=-1", "dialect=sql", "buckets=many", "minfiles=-2"} {
+		// test
+
+		_, _, err := parseMountOptions([]string{option})
+
+		// validate
+
+		if err == nil {
+			test.Fatalf("Expected option '%v' to be rejected.", option)
+		}
+	}
+}
@@ -0,0 +1,661 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package vfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"tmsu/common/filesystem"
+	"tmsu/common/fingerprint"
+	_path "tmsu/common/path"
+	"tmsu/entities"
+	"tmsu/query"
+	"tmsu/storage"
+)
+
+const tagsDir = "tags"
+const queriesDir = "queries"
+const untaggedDir = "untagged"
+const duplicatesDir = "duplicates"
+const missingDir = "missing"
+
+type NodeType int
+
+const (
+	DirectoryNode NodeType = iota
+	LinkNode
+)
+
+// An entry within the virtual filesystem tree.
+type Node struct {
+	Name   string
+	Type   NodeType
+	FileId entities.FileId
+}
+
+type Nodes []Node
+
+// The structure of the virtual filesystem, independent of the means by which
+// it is served. Its exported methods use the storage exclusively as the
+// servers call them for concurrent requests.
+type Tree struct {
+	store     *storage.Storage
+	linkName  func(file *entities.File) string
+	parseName func(name string) entities.FileId
+
+	// Tags applied to fewer files than this, amongst the files of the
+	// directory being listed, are not listed.
+	minFiles uint
+
+	// Whether directories within the tags directory may be named with query
+	// terms, e.g. 'year=2014' or 'not private'.
+	queryNames bool
+}
+
+func NewTree(store *storage.Storage) *Tree {
+	return &Tree{store, idLinkName, parseIdLinkName, 0, false}
+}
+
+// Lists the entries within the directory at the specified path. Returns nil
+// if the path does not identify a directory.
+func (tree *Tree) List(path []string) (Nodes, error) {
+	var nodes Nodes
+	err := tree.store.Exclusively(func() error {
+		var err error
+		nodes, err = tree.list(path)
+		return err
+	})
+
+	return nodes, err
+}
+
+// Retrieves the entry at the specified path. Returns nil if there is no such
+// entry.
+func (tree *Tree) Lookup(path []string) (*Node, error) {
+	if len(path) == 0 {
+		return &Node{Name: "", Type: DirectoryNode}, nil
+	}
+
+	nodes, err := tree.List(path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	name := path[len(path)-1]
+	for _, node := range nodes {
+		if node.Name == name {
+			return &node, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Retrieves the file that a link node refers to.
+func (tree *Tree) File(node *Node) (*entities.File, error) {
+	if node.Type != LinkNode {
+		return nil, nil
+	}
+
+	var file *entities.File
+	err := tree.store.Exclusively(func() error {
+		var err error
+		file, err = tree.store.File(node.FileId)
+		return err
+	})
+
+	return file, err
+}
+
+// unexported
+
+func (tree *Tree) list(path []string) (Nodes, error) {
+	if len(path) == 0 {
+		return Nodes{Node{Name: tagsDir, Type: DirectoryNode},
+			Node{Name: queriesDir, Type: DirectoryNode},
+			Node{Name: untaggedDir, Type: DirectoryNode},
+			Node{Name: duplicatesDir, Type: DirectoryNode},
+			Node{Name: missingDir, Type: DirectoryNode}}, nil
+	}
+
+	switch path[0] {
+	case tagsDir:
+		if len(path) == 1 {
+			return tree.listTags()
+		}
+
+		return tree.listTagged(path[1:])
+	case queriesDir:
+		switch len(path) {
+		case 1:
+			return tree.listQueries()
+		case 2:
+			return tree.listQuery(path[1])
+		}
+	case untaggedDir:
+		if len(path) == 1 {
+			return tree.listUntagged()
+		}
+	case duplicatesDir:
+		switch len(path) {
+		case 1:
+			return tree.listDuplicates()
+		case 2:
+			return tree.listDuplicateSet(path[1])
+		}
+	case missingDir:
+		if len(path) == 1 {
+			return tree.listMissing()
+		}
+	}
+
+	return nil, nil
+}
+
+func (tree *Tree) listTags() (Nodes, error) {
+	tags, err := tree.store.Tags()
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve tags: %v", err)
+	}
+
+	tagNames := make([]string, len(tags))
+	for index, tag := range tags {
+		tagNames[index] = tag.Name
+	}
+
+	tagNames, err = tree.frequentTagNames(query.EmptyExpression{}, tagNames)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(Nodes, len(tagNames))
+	for index, tagName := range tagNames {
+		nodes[index] = Node{Name: tagName, Type: DirectoryNode}
+	}
+
+	return nodes, nil
+}
+
+func (tree *Tree) listTagged(path []string) (Nodes, error) {
+	elements, err := tree.parseTagPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse path: %v", err)
+	}
+	if elements == nil {
+		return nil, nil
+	}
+
+	expression := pathToExpression(elements)
+	lastElement := elements[len(elements)-1]
+
+	var valueNames []string
+	if !lastElement.isValue && lastElement.expression == nil {
+		valueNames, err = tree.tagValueNamesForQuery(lastElement.tagName, expression)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve values for '%v': %v", lastElement.tagName, err)
+		}
+	}
+
+	furtherTagNames, err := tree.tagNamesForQuery(expression)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve further tags: %v", err)
+	}
+
+	furtherTagNames, err = tree.frequentTagNames(expression, furtherTagNames)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(Nodes, 0, len(furtherTagNames)+len(valueNames))
+	for _, tagName := range furtherTagNames {
+		if !elements.containsTag(tagName) {
+			nodes = append(nodes, Node{Name: tagName, Type: DirectoryNode})
+		}
+	}
+
+	for _, valueName := range valueNames {
+		dirName, err := tree.valueDirName(valueName)
+		if err != nil {
+			return nil, fmt.Errorf("could not determine directory name for value '%v': %v", valueName, err)
+		}
+
+		nodes = append(nodes, Node{Name: dirName, Type: DirectoryNode})
+	}
+
+	nodes, err = tree.appendQueriedFileNodes(nodes, expression)
+	if err != nil {
+		return nil, fmt.Errorf("could not query files: %v", err)
+	}
+
+	return nodes, nil
+}
+
+func (tree *Tree) listQueries() (Nodes, error) {
+	queries, err := tree.store.Queries()
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve queries: %v", err)
+	}
+
+	nodes := make(Nodes, len(queries))
+	for index, query := range queries {
+		nodes[index] = Node{Name: query.Text, Type: DirectoryNode}
+	}
+
+	return nodes, nil
+}
+
+func (tree *Tree) listQuery(queryText string) (Nodes, error) {
+	expression, err := tree.store.ParseQuery(queryText)
+	if err != nil {
+		return nil, nil
+	}
+
+	tagNames := query.TagNames(expression)
+	tags, err := tree.store.TagsByNames(tagNames)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve tags: %v", err)
+	}
+	for _, tagName := range tagNames {
+		if !containsTag(tags, tagName) {
+			return nil, nil
+		}
+	}
+
+	nodes, err := tree.appendQueriedFileNodes(make(Nodes, 0, 10), expression)
+	if err != nil {
+		return nil, fmt.Errorf("could not query files: %v", err)
+	}
+
+	return nodes, nil
+}
+
+func (tree *Tree) listUntagged() (Nodes, error) {
+	files, err := tree.store.UntaggedFiles()
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve untagged files: %v", err)
+	}
+
+	return tree.fileNodes(files), nil
+}
+
+func (tree *Tree) listDuplicates() (Nodes, error) {
+	fileSets, err := tree.store.DuplicateFiles()
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve duplicate files: %v", err)
+	}
+
+	nodes := make(Nodes, len(fileSets))
+	for index, fileSet := range fileSets {
+		nodes[index] = Node{Name: string(fileSet[0].Fingerprint), Type: DirectoryNode}
+	}
+
+	return nodes, nil
+}
+
+func (tree *Tree) listDuplicateSet(name string) (Nodes, error) {
+	files, err := tree.store.FilesByFingerprint(fingerprint.Fingerprint(name))
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve files with fingerprint '%v': %v", name, err)
+	}
+	if len(files) < 2 {
+		return nil, nil
+	}
+
+	return tree.fileNodes(files), nil
+}
+
+func (tree *Tree) listMissing() (Nodes, error) {
+	files, err := tree.store.Files()
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve files: %v", err)
+	}
+
+	policyName, err := tree.store.SettingAsString("symlinkPolicy")
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := filesystem.ParseSymlinkPolicy(policyName)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := make(entities.Files, 0, 10)
+	for _, file := range files {
+		if _path.IsUrl(file.Directory) {
+			continue
+		}
+
+		if _, err := policy.Stat(file.Path()); err != nil && os.IsNotExist(err) {
+			missing = append(missing, file)
+		}
+	}
+
+	return tree.fileNodes(missing), nil
+}
+
+// The nodes for the files, omitting those at URLs as a symbolic link cannot
+// point to them.
+func (tree *Tree) fileNodes(files entities.Files) Nodes {
+	nodes := make(Nodes, 0, len(files))
+	for _, file := range files {
+		if _path.IsUrl(file.Directory) {
+			continue
+		}
+
+		nodes = append(nodes, Node{Name: tree.linkName(file), Type: LinkNode, FileId: file.Id})
+	}
+
+	return nodes
+}
+
+// Appends a node for each file matching the query, reading the files one at a
+// time rather than gathering them first.
+func (tree *Tree) appendQueriedFileNodes(nodes Nodes, expression query.Expression) (Nodes, error) {
+	err := tree.store.EachQueryFilteredFile(expression, nil, false, entities.FileFilter{}, func(file *entities.File) error {
+		if _path.IsUrl(file.Directory) {
+			return nil
+		}
+
+		nodes = append(nodes, Node{Name: tree.linkName(file), Type: LinkNode, FileId: file.Id})
+		return nil
+	})
+
+	return nodes, err
+}
+
+// A directory within the tags directory: either a tag, a value of the
+// preceding tag or, where enabled, a query term.
+type tagPathElement struct {
+	tagName    string
+	valueName  string
+	isValue    bool
+	expression query.Expression
+}
+
+type tagPathElements []tagPathElement
+
+func (elements tagPathElements) containsTag(tagName string) bool {
+	for _, element := range elements {
+		if element.expression != nil {
+			for _, name := range query.TagNames(element.expression) {
+				if name == tagName {
+					return true
+				}
+			}
+
+			continue
+		}
+
+		if !element.isValue && element.tagName == tagName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Parses the path of a directory within the tags directory. A directory
+// following a tag directory is a value directory if it is prefixed with '='
+// or it names a value and there is no tag of the same name. Where query names
+// are enabled, a directory whose name contains whitespace or a comparison
+// operator is a query term. Returns nil if the path does not identify a
+// directory.
+func (tree *Tree) parseTagPath(path []string) (tagPathElements, error) {
+	elements := make(tagPathElements, 0, len(path))
+
+	for index, name := range path {
+		if name == "" {
+			return nil, nil
+		}
+
+		afterTag := index > 0 && !elements[index-1].isValue
+
+		if afterTag && name[0] == '=' {
+			elements = append(elements, tagPathElement{elements[index-1].tagName, name[1:], true, nil})
+			continue
+		}
+
+		tag, err := tree.store.TagByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve tag '%v': %v", name, err)
+		}
+		if tag != nil {
+			elements = append(elements, tagPathElement{name, "", false, nil})
+			continue
+		}
+
+		if tree.queryNames && isQueryName(name) {
+			expression, err := tree.parseQueryName(name)
+			if err != nil {
+				return nil, err
+			}
+			if expression == nil {
+				return nil, nil
+			}
+
+			elements = append(elements, tagPathElement{"", "", false, expression})
+			continue
+		}
+
+		if !afterTag {
+			return nil, nil
+		}
+
+		value, err := tree.store.ValueByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve value '%v': %v", name, err)
+		}
+		if value == nil || value.Id == 0 {
+			return nil, nil
+		}
+
+		elements = append(elements, tagPathElement{elements[index-1].tagName, name, true, nil})
+	}
+
+	return elements, nil
+}
+
+// Parses a directory name as a query term. As directory names cannot contain
+// slashes, these are escaped as '%2F' (and percent signs as '%25'). Returns nil
+// if the name is not a valid query or refers to tags that do not exist.
+func (tree *Tree) parseQueryName(name string) (query.Expression, error) {
+	expression, err := tree.store.ParseQuery(unescapeLinkPath(name))
+	if err != nil {
+		return nil, nil
+	}
+
+	tagNames := query.TagNames(expression)
+	tags, err := tree.store.TagsByNames(tagNames)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve tags: %v", err)
+	}
+	for _, tagName := range tagNames {
+		if !containsTag(tags, tagName) {
+			return nil, nil
+		}
+	}
+
+	return expression, nil
+}
+
+// The name of the directory for a value: the value name unless this would be
+// mistaken for a tag, in which case it is prefixed with '='.
+func (tree *Tree) valueDirName(valueName string) (string, error) {
+	tag, err := tree.store.TagByName(valueName)
+	if err != nil {
+		return "", err
+	}
+	if tag != nil || valueName == "" || valueName[0] == '=' {
+		return "=" + valueName, nil
+	}
+
+	return valueName, nil
+}
+
+func (tree *Tree) tagValueNamesForQuery(tagName string, expression query.Expression) ([]string, error) {
+	tag, err := tree.store.TagByName(tagName)
+	if err != nil {
+		return nil, fmt.Errorf("could not look up tag '%v': %v", tagName, err)
+	}
+	if tag == nil {
+		return []string{}, nil
+	}
+
+	values, err := tree.store.ValuesForFileQuery(expression, "", tag.Id, false)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve values for tag '%v': %v", tagName, err)
+	}
+
+	valueNames := make([]string, len(values))
+	for index, value := range values {
+		valueNames[index] = value.Name
+	}
+
+	return valueNames, nil
+}
+
+func (tree *Tree) tagNamesForQuery(expression query.Expression) ([]string, error) {
+	tags, err := tree.store.TagsForFileQuery(expression, "", false)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve tags: %v", err)
+	}
+
+	tagNames := make([]string, len(tags))
+	for index, tag := range tags {
+		tagNames[index] = tag.Name
+	}
+
+	return tagNames, nil
+}
+
+// Filters out the tags applied to fewer than the minimum number of the files
+// matching the expression.
+func (tree *Tree) frequentTagNames(expression query.Expression, tagNames []string) ([]string, error) {
+	if tree.minFiles < 2 {
+		return tagNames, nil
+	}
+
+	frequent := make([]string, 0, len(tagNames))
+	for _, tagName := range tagNames {
+		count, err := tree.store.QueryFileCount(query.AndExpression{expression, query.TagExpression{Name: tagName}}, "", false)
+		if err != nil {
+			return nil, fmt.Errorf("could not count files tagged '%v': %v", tagName, err)
+		}
+
+		if count >= tree.minFiles {
+			frequent = append(frequent, tagName)
+		}
+	}
+
+	return frequent, nil
+}
+
+func pathToExpression(elements tagPathElements) query.Expression {
+	var expression query.Expression = query.EmptyExpression{}
+
+	for _, element := range elements {
+		var elementExpression query.Expression
+
+		if element.expression != nil {
+			elementExpression = element.expression
+		} else if element.isValue {
+			elementExpression = query.ComparisonExpression{query.TagExpression{Name: element.tagName}, "==", query.ValueExpression{element.valueName}}
+		} else {
+			elementExpression = query.TagExpression{Name: element.tagName}
+		}
+
+		expression = query.AndExpression{expression, elementExpression}
+	}
+
+	return expression
+}
+
+// Whether a directory name is a query term rather than a tag or value name,
+// which is the case where it contains characters that tag names cannot.
+func isQueryName(name string) bool {
+	return strings.ContainsAny(name, " \t=<>")
+}
+
+func idLinkName(file *entities.File) string {
+	extension := filepath.Ext(file.Path())
+	fileName := filepath.Base(file.Path())
+	linkName := fileName[0 : len(fileName)-len(extension)]
+	suffix := "." + fileIdToAscii(file.Id) + extension
+
+	return truncateLinkName(linkName, suffix)
+}
+
+func truncateLinkName(linkName, suffix string) string {
+	if len(linkName)+len(suffix) > 255 {
+		linkName = linkName[0 : 255-len(suffix)]
+	}
+
+	return linkName + suffix
+}
+
+func parseIdLinkName(name string) entities.FileId {
+	parts := strings.Split(name, ".")
+	count := len(parts)
+
+	if count == 1 {
+		return 0
+	}
+
+	id, err := asciiToFileId(parts[count-2])
+	if err != nil {
+		id, err = asciiToFileId(parts[count-1])
+		if err != nil {
+			return 0
+		}
+	}
+
+	return entities.FileId(id)
+}
+
+func escapeLinkPath(path string) string {
+	path = strings.Replace(path, "%", "%25", -1)
+	return strings.Replace(path, string(filepath.Separator), "%2F", -1)
+}
+
+func unescapeLinkPath(name string) string {
+	name = strings.Replace(name, "%2F", string(filepath.Separator), -1)
+	return strings.Replace(name, "%25", "%", -1)
+}
+
+func fileIdToAscii(fileId entities.FileId) string {
+	return strconv.FormatUint(uint64(fileId), 10)
+}
+
+func asciiToFileId(str string) (entities.FileId, error) {
+	ui64, err := strconv.ParseUint(str, 10, 0)
+	return entities.FileId(ui64), err
+}
+
+func containsTag(tags entities.Tags, tagName string) bool {
+	for _, tag := range tags {
+		if tag.Name == tagName {
+			return true
+		}
+	}
+
+	return false
+}
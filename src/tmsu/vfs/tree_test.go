@@ -0,0 +1,335 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package vfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"tmsu/common/fingerprint"
+	"tmsu/entities"
+	"tmsu/storage"
+)
+
+func TestTreeListValueDirectories(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	addTaggedFile(test, store, "/tmp/tmsu/a.mp3", "", "music", "year=2014")
+	addTaggedFile(test, store, "/tmp/tmsu/b.mp3", "", "year=2015")
+	addTaggedFile(test, store, "/tmp/tmsu/c.ogg", "", "genre=music")
+
+	tree := NewTree(store)
+
+	expectations := []struct {
+		path     []string
+		expected []string
+	}{
+		{[]string{tagsDir, "year"}, []string{"music", "2014", "2015", "a.1.mp3", "b.2.mp3"}},
+		{[]string{tagsDir, "year", "2014"}, []string{"music", "a.1.mp3"}},
+		{[]string{tagsDir, "year", "2014", "music"}, []string{"a.1.mp3"}},
+
+		// a value named as a tag is prefixed
+		{[]string{tagsDir, "genre"}, []string{"=music", "c.3.ogg"}},
+		{[]string{tagsDir, "genre", "=music"}, []string{"c.3.ogg"}},
+	}
+
+	for _, expectation := range expectations {
+		// test
+
+		nodes, err := tree.List(expectation.path)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		// validate
+
+		if names := nodeNames(nodes); !reflect.DeepEqual(names, expectation.expected) {
+			test.Fatalf("%v: expected %v but were %v.", expectation.path, expectation.expected, names)
+		}
+	}
+
+	for _, path := range [][]string{{tagsDir, "year", "2016"}, {tagsDir, "2014"}} {
+		nodes, err := tree.List(path)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if nodes != nil {
+			test.Fatalf("%v: expected no directory but there were entries %v.", path, nodeNames(nodes))
+		}
+	}
+}
+
+func TestTreeListReportDirectories(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	dirPath := createTestDir(test)
+	defer os.RemoveAll(dirPath)
+
+	presentPath := createTestFile(test, dirPath, "present.txt", "hello")
+	copyPath := createTestFile(test, dirPath, "copy.txt", "hello")
+	gonePath := filepath.Join(dirPath, "gone.txt")
+
+	addTaggedFile(test, store, presentPath, "dup")
+	addTaggedFile(test, store, copyPath, "dup", "text")
+	addTaggedFile(test, store, gonePath, "other", "text")
+
+	tree := NewTree(store)
+
+	expectations := []struct {
+		path     []string
+		expected []string
+	}{
+		{[]string{untaggedDir}, []string{"present.1.txt"}},
+		{[]string{duplicatesDir}, []string{"dup"}},
+		{[]string{missingDir}, []string{"gone.3.txt"}},
+	}
+
+	for _, expectation := range expectations {
+		// test
+
+		nodes, err := tree.List(expectation.path)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		// validate
+
+		if names := nodeNames(nodes); !reflect.DeepEqual(names, expectation.expected) {
+			test.Fatalf("%v: expected %v but were %v.", expectation.path, expectation.expected, names)
+		}
+	}
+
+	nodes, err := tree.List([]string{duplicatesDir, "dup"})
+	if err != nil {
+		test.Fatal(err)
+	}
+	names := nodeNames(nodes)
+	if len(names) != 2 || !containsName(names, "present.1.txt") || !containsName(names, "copy.2.txt") {
+		test.Fatalf("Expected both copies in the duplicate set but were %v.", names)
+	}
+
+	nodes, err = tree.List([]string{duplicatesDir, "other"})
+	if err != nil {
+		test.Fatal(err)
+	}
+	if nodes != nil {
+		test.Fatalf("Expected no duplicate set for a unique file but there were entries %v.", nodeNames(nodes))
+	}
+}
+
+func TestTreeMinFiles(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	addTaggedFile(test, store, "/tmp/tmsu/a.mp3", "", "common", "rare")
+	addTaggedFile(test, store, "/tmp/tmsu/b.mp3", "", "common")
+
+	tree := &Tree{store, idLinkName, parseIdLinkName, 2, false}
+
+	// test
+
+	tagNodes, err := tree.List([]string{tagsDir})
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	commonNodes, err := tree.List([]string{tagsDir, "common"})
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	if names := nodeNames(tagNodes); !reflect.DeepEqual(names, []string{"common"}) {
+		test.Fatalf("Expected only the tag applied to two files but were %v.", names)
+	}
+	if names := nodeNames(commonNodes); !reflect.DeepEqual(names, []string{"a.1.mp3", "b.2.mp3"}) {
+		test.Fatalf("Expected only the files but were %v.", names)
+	}
+}
+
+func TestTreeQueryNames(test *testing.T) {
+	// set-up
+
+	store := openTestStorage(test)
+	defer closeTestStorage(store)
+
+	addTaggedFile(test, store, "/tmp/tmsu/a.mp3", "", "music", "year=2014")
+	addTaggedFile(test, store, "/tmp/tmsu/b.mp3", "", "music", "year=2016")
+	addTaggedFile(test, store, "/tmp/tmsu/c.mp3", "", "music", "private")
+
+	plainTree := NewTree(store)
+	queryTree := &Tree{store, idLinkName, parseIdLinkName, 0, true}
+
+	expectations := []struct {
+		path     []string
+		expected []string
+	}{
+		{[]string{tagsDir, "year > 2015"}, []string{"music", "b.2.mp3"}},
+		{[]string{tagsDir, "music", "not private"}, []string{"year", "a.1.mp3", "b.2.mp3"}},
+	}
+
+	for _, expectation := range expectations {
+		// test
+
+		nodes, err := queryTree.List(expectation.path)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		plainNodes, err := plainTree.List(expectation.path)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		// validate
+
+		if names := nodeNames(nodes); !reflect.DeepEqual(names, expectation.expected) {
+			test.Fatalf("%v: expected %v but were %v.", expectation.path, expectation.expected, names)
+		}
+		if plainNodes != nil {
+			test.Fatalf("%v: expected no directory without query names but there were entries %v.", expectation.path, nodeNames(plainNodes))
+		}
+	}
+
+	// terms must refer to existing tags
+	nodes, err := queryTree.List([]string{tagsDir, "colour = red"})
+	if err != nil {
+		test.Fatal(err)
+	}
+	if nodes != nil {
+		test.Fatalf("Expected no directory for an unknown tag but there were entries %v.", nodeNames(nodes))
+	}
+}
+
+// unexported
+
+func openTestStorage(test *testing.T) *storage.Storage {
+	databasePath := filepath.Join(os.TempDir(), "tmsu_vfs_test.db")
+	os.Remove(databasePath)
+
+	store, err := storage.OpenAt(databasePath)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	return store
+}
+
+func closeTestStorage(store *storage.Storage) {
+	store.Close()
+	os.Remove(store.Db.Path)
+}
+
+func createTestDir(test *testing.T) string {
+	dirPath := filepath.Join(os.TempDir(), "tmsu_vfs_test")
+	os.RemoveAll(dirPath)
+
+	if err := os.Mkdir(dirPath, 0755); err != nil {
+		test.Fatal(err)
+	}
+
+	return dirPath
+}
+
+func createTestFile(test *testing.T, dirPath, name, content string) string {
+	path := filepath.Join(dirPath, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		test.Fatal(err)
+	}
+
+	return path
+}
+
+// Adds a file with the tags and values, specified as TAG or TAG=VALUE,
+// creating the tags and values as necessary.
+func addTaggedFile(test *testing.T, store *storage.Storage, path, fp string, tagValueNames ...string) *entities.File {
+	file, err := store.AddFile(path, fingerprint.Fingerprint(fp), time.Time{}, 0, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	for _, tagValueName := range tagValueNames {
+		tagName, valueName := tagValueName, ""
+		if index := strings.Index(tagValueName, "="); index != -1 {
+			tagName, valueName = tagValueName[:index], tagValueName[index+1:]
+		}
+
+		tag, err := store.TagByName(tagName)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if tag == nil {
+			if tag, err = store.AddTag(tagName); err != nil {
+				test.Fatal(err)
+			}
+		}
+
+		var valueId entities.ValueId
+		if valueName != "" {
+			value, err := store.ValueByName(valueName)
+			if err != nil {
+				test.Fatal(err)
+			}
+			if value == nil {
+				if value, err = store.AddValue(valueName); err != nil {
+					test.Fatal(err)
+				}
+			}
+
+			valueId = value.Id
+		}
+
+		if _, err := store.AddFileTag(file.Id, tag.Id, valueId); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	return file
+}
+
+func nodeNames(nodes Nodes) []string {
+	names := make([]string, len(nodes))
+	for index, node := range nodes {
+		names[index] = node.Name
+	}
+
+	return names
+}
+
+func containsName(names []string, name string) bool {
+	for _, candidate := range names {
+		if candidate == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,265 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package vfs
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"tmsu/common/log"
+	"tmsu/storage"
+)
+
+// Serves the virtual filesystem, read-only, over WebDAV.
+type WebDavHandler struct {
+	tree *Tree
+}
+
+func NewWebDavHandler(store *storage.Storage) *WebDavHandler {
+	return &WebDavHandler{NewTree(store)}
+}
+
+func (handler *WebDavHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	log.Infof(2, "%v %v", request.Method, request.URL.Path)
+
+	path := splitUrlPath(request.URL.Path)
+
+	node, err := handler.tree.Lookup(path)
+	if err != nil {
+		log.Warnf("could not look up '%v': %v", request.URL.Path, err)
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch request.Method {
+	case "OPTIONS":
+		writer.Header().Set("DAV", "1")
+		writer.Header().Set("Allow", "OPTIONS, GET, HEAD, PROPFIND")
+		writer.WriteHeader(http.StatusOK)
+		return
+	case "GET", "HEAD", "PROPFIND":
+	default:
+		http.Error(writer, "the virtual filesystem is read-only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if node == nil {
+		http.NotFound(writer, request)
+		return
+	}
+
+	if request.Method == "PROPFIND" {
+		err = handler.propFind(writer, request, path, node)
+	} else {
+		err = handler.get(writer, request, path, node)
+	}
+
+	if err != nil {
+		log.Warnf("could not serve '%v': %v", request.URL.Path, err)
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// unexported
+
+type multiStatus struct {
+	XMLName   xml.Name   `xml:"D:multistatus"`
+	Namespace string     `xml:"xmlns:D,attr"`
+	Responses []response `xml:"D:response"`
+}
+
+type response struct {
+	Href     string   `xml:"D:href"`
+	PropStat propStat `xml:"D:propstat"`
+}
+
+type propStat struct {
+	Prop   prop   `xml:"D:prop"`
+	Status string `xml:"D:status"`
+}
+
+type prop struct {
+	DisplayName   string        `xml:"D:displayname"`
+	ResourceType  *resourceType `xml:"D:resourcetype"`
+	ContentLength *int64        `xml:"D:getcontentlength,omitempty"`
+	ContentType   string        `xml:"D:getcontenttype,omitempty"`
+	LastModified  string        `xml:"D:getlastmodified,omitempty"`
+}
+
+type resourceType struct {
+	Collection *struct{} `xml:"D:collection"`
+}
+
+func (handler *WebDavHandler) propFind(writer http.ResponseWriter, request *http.Request, path []string, node *Node) error {
+	result := multiStatus{Namespace: "DAV:"}
+
+	nodeResponse, err := handler.response(path, node)
+	if err != nil {
+		return err
+	}
+	result.Responses = append(result.Responses, nodeResponse)
+
+	if node.Type == DirectoryNode && request.Header.Get("Depth") != "0" {
+		children, err := handler.tree.List(path)
+		if err != nil {
+			return err
+		}
+
+		for index := range children {
+			child := &children[index]
+
+			childResponse, err := handler.response(append(path[:len(path):len(path)], child.Name), child)
+			if err != nil {
+				return err
+			}
+			result.Responses = append(result.Responses, childResponse)
+		}
+	}
+
+	writer.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	writer.WriteHeader(207) // multi-status
+
+	if _, err := writer.Write([]byte(xml.Header)); err != nil {
+		return err
+	}
+
+	return xml.NewEncoder(writer).Encode(result)
+}
+
+func (handler *WebDavHandler) response(path []string, node *Node) (response, error) {
+	prop := prop{DisplayName: node.Name, ResourceType: &resourceType{}}
+	href := joinUrlPath(path)
+
+	switch node.Type {
+	case DirectoryNode:
+		prop.ResourceType.Collection = &struct{}{}
+		if !strings.HasSuffix(href, "/") {
+			href += "/"
+		}
+	case LinkNode:
+		file, err := handler.tree.File(node)
+		if err != nil {
+			return response{}, err
+		}
+
+		if file != nil {
+			if fileInfo, err := os.Stat(file.Path()); err == nil {
+				size := fileInfo.Size()
+				prop.ContentLength = &size
+				prop.LastModified = fileInfo.ModTime().UTC().Format(http.TimeFormat)
+			}
+
+			prop.ContentType = mime.TypeByExtension(filepath.Ext(file.Path()))
+		}
+	}
+
+	return response{href, propStat{prop, "HTTP/1.1 200 OK"}}, nil
+}
+
+func (handler *WebDavHandler) get(writer http.ResponseWriter, request *http.Request, path []string, node *Node) error {
+	if node.Type == DirectoryNode {
+		return handler.listing(writer, path)
+	}
+
+	file, err := handler.tree.File(node)
+	if err != nil {
+		return err
+	}
+	if file == nil {
+		http.NotFound(writer, request)
+		return nil
+	}
+
+	content, err := os.Open(file.Path())
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(writer, request)
+			return nil
+		}
+
+		return err
+	}
+	defer content.Close()
+
+	var modTime time.Time
+	if fileInfo, err := content.Stat(); err == nil {
+		modTime = fileInfo.ModTime()
+	}
+
+	http.ServeContent(writer, request, file.Path(), modTime, content)
+	return nil
+}
+
+func (handler *WebDavHandler) listing(writer http.ResponseWriter, path []string) error {
+	nodes, err := handler.tree.List(path)
+	if err != nil {
+		return err
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	fmt.Fprintf(writer, "<html><body><ul>\n")
+	for _, node := range nodes {
+		name := node.Name
+		if node.Type == DirectoryNode {
+			name += "/"
+		}
+
+		href := joinUrlPath(append(path[:len(path):len(path)], node.Name))
+		if node.Type == DirectoryNode {
+			href += "/"
+		}
+
+		fmt.Fprintf(writer, "<li><a href=\"%v\">%v</a></li>\n", html.EscapeString(href), html.EscapeString(name))
+	}
+	fmt.Fprintf(writer, "</ul></body></html>\n")
+
+	return nil
+}
+
+func splitUrlPath(urlPath string) []string {
+	path := make([]string, 0, 10)
+
+	for _, name := range strings.Split(urlPath, "/") {
+		if name != "" {
+			path = append(path, name)
+		}
+	}
+
+	return path
+}
+
+func joinUrlPath(path []string) string {
+	href := ""
+	for _, name := range path {
+		href += "/" + url.PathEscape(name)
+	}
+
+	if href == "" {
+		return "/"
+	}
+
+	return href
+}
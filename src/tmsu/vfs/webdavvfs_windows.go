@@ -0,0 +1,93 @@
+/*
+Copyright 2011-2015 Paul Ruane.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package vfs
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"tmsu/common/log"
+	"tmsu/storage"
+)
+
+// Windows has no FUSE so instead the virtual filesystem is served over WebDAV
+// on the loopback interface and the share mapped to a drive letter using the
+// built-in WebDAV redirector.
+type WebDavVfs struct {
+	mountPath string
+	listener  net.Listener
+}
+
+func MountVfs(store *storage.Storage, mountPath string, options []string) (Vfs, error) {
+	if !isDriveLetter(mountPath) {
+		return nil, fmt.Errorf("could not mount virtual filesystem at '%v': mount point must be a drive letter, e.g. 'T:'", mountPath)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("could not listen for WebDAV connections: %v", err)
+	}
+
+	go http.Serve(listener, NewWebDavHandler(store))
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	share := fmt.Sprintf(`\\127.0.0.1@%v\DavWWWRoot`, port)
+
+	log.Infof(2, "mapping '%v' to '%v'", share, mountPath)
+
+	output, err := exec.Command("net", "use", mountPath, share).CombinedOutput()
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("could not map drive '%v': %v: %v", mountPath, err, strings.TrimSpace(string(output)))
+	}
+
+	return &WebDavVfs{mountPath, listener}, nil
+}
+
+// Several databases cannot yet share a WebDAV share.
+func MountMultiVfs(stores []*storage.Storage, names []string, mountPath string, options []string) (Vfs, error) {
+	return nil, fmt.Errorf("could not mount virtual filesystem at '%v': only a single database may be mounted on Windows", mountPath)
+}
+
+// Serves until interrupted.
+func (vfs *WebDavVfs) Serve() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+
+	<-signals
+}
+
+func (vfs *WebDavVfs) Unmount() {
+	if err := exec.Command("net", "use", vfs.mountPath, "/delete", "/y").Run(); err != nil {
+		log.Warnf("could not unmap drive '%v': %v", vfs.mountPath, err)
+	}
+
+	vfs.listener.Close()
+}
+
+// unexported
+
+func isDriveLetter(path string) bool {
+	volume := filepath.VolumeName(path)
+	return len(volume) == 2 && volume[1] == ':' && strings.TrimRight(path[2:], `\`) == ""
+}